@@ -12,8 +12,35 @@ const (
 	// Link is a type of annotation that refers to an arbitrary URL
 	// (typically pointing to an external web page).
 	Link = "link"
+
+	// Diagnostic is a type of annotation that reports a problem found
+	// by an analysis (e.g., a lint-style toolchain). Its Data is a
+	// JSON-encoded DiagnosticData.
+	Diagnostic = "diagnostic"
+)
+
+// Diagnostic levels, in increasing order of severity. They are the
+// same as SARIF's result levels.
+const (
+	LevelNote    = "note"
+	LevelWarning = "warning"
+	LevelError   = "error"
 )
 
+// DiagnosticData is the data of a Diagnostic annotation.
+type DiagnosticData struct {
+	// Rule is the ID of the rule (or check) that reported the
+	// problem (e.g., "unused-var").
+	Rule string
+
+	// Level is the severity of the problem (LevelNote, LevelWarning,
+	// or LevelError).
+	Level string
+
+	// Message describes the problem.
+	Message string
+}
+
 // LinkURL parses and returns a's link URL, if a's type is Link and if
 // its Data contains a valid URL (encoded as a JSON string).
 func (a *Ann) LinkURL() (*url.URL, error) {
@@ -44,6 +71,36 @@ func (a *Ann) SetLinkURL(urlStr string) error {
 	return nil
 }
 
+// Diagnostic parses and returns a's diagnostic data, if a's type is
+// Diagnostic.
+func (a *Ann) Diagnostic() (*DiagnosticData, error) {
+	if a.Type != Diagnostic {
+		return nil, &ErrType{Expected: Diagnostic, Actual: a.Type, Op: "Diagnostic"}
+	}
+	var d DiagnosticData
+	if err := json.Unmarshal(a.Data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SetDiagnostic sets a's Type to Diagnostic and Data to the JSON
+// representation of d. If d's level is invalid, an error is returned.
+func (a *Ann) SetDiagnostic(d *DiagnosticData) error {
+	switch d.Level {
+	case LevelNote, LevelWarning, LevelError:
+	default:
+		return fmt.Errorf("invalid diagnostic level %q", d.Level)
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	a.Type = Diagnostic
+	a.Data = b
+	return nil
+}
+
 // ErrType indicates that an operation performed on an annotation
 // expected the annotation to be a different type (e.g., calling
 // LinkURL on a non-link annotation).
@@ -30,3 +30,28 @@ func TestAnn_LinkURL_badURL(t *testing.T) {
 		t.Fatal("LinkURL returned nil error")
 	}
 }
+
+func TestAnn_Diagnostic(t *testing.T) {
+	want := DiagnosticData{Rule: "unused-var", Level: LevelWarning, Message: "x is unused"}
+
+	var a Ann
+	if err := a.SetDiagnostic(&want); err != nil {
+		t.Fatal(err)
+	}
+	d, err := a.Diagnostic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *d != want {
+		t.Errorf("got %+v, want %+v", *d, want)
+	}
+
+	if err := a.SetDiagnostic(&DiagnosticData{Level: "fatal"}); err == nil {
+		t.Fatal("SetDiagnostic returned nil error for invalid level")
+	}
+
+	a.Type = Link
+	if _, err := a.Diagnostic(); err == nil {
+		t.Fatal("Diagnostic returned nil error for link annotation")
+	}
+}
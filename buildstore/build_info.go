@@ -0,0 +1,111 @@
+package buildstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// BuildInfoName is the name of the file (at the root of a commit's
+// build data) that describes how the build data was produced.
+var BuildInfoName = "build-info.json"
+
+// BuildInfo describes how a commit's build data was produced, so that
+// the analysis can be reproduced and audited after the build data is
+// imported into a store.
+type BuildInfo struct {
+	// SrclibVersion is the version of src that ran the build.
+	SrclibVersion string
+
+	// Host is the hostname of the machine that ran the build.
+	Host string `json:",omitempty"`
+
+	// Toolchains maps the paths of the toolchains that produced the
+	// build data to their versions (typically the VCS revision of
+	// the toolchain's dir), or to "" if their versions are unknown.
+	Toolchains map[string]string `json:",omitempty"`
+
+	// Started is when the build started, and Duration is how long it
+	// took.
+	Started  time.Time
+	Duration time.Duration
+
+	// BaseCommitID is set if only the source units that changed since
+	// the BaseCommitID commit were built (see 'src make --since'); the
+	// other source units' data is the same as at BaseCommitID.
+	BaseCommitID string `json:",omitempty"`
+
+	// Rules describes the resource usage of the toolchain rules that
+	// were run during the build (rules that were already up to date
+	// are omitted).
+	Rules []*RuleStats `json:",omitempty"`
+}
+
+// RuleStats describes the resource usage of a toolchain rule (such as
+// graphing or resolving the dependencies of a source unit) during a
+// build, so that slow or memory-hungry source units can be found.
+type RuleStats struct {
+	// Target is the rule's target (its output file in the build
+	// data).
+	Target string
+
+	// Op is the toolchain operation that the rule performed (e.g.,
+	// "graph" or "depresolve"), and UnitType and Unit identify the
+	// source unit that it was performed on.
+	Op       string `json:",omitempty"`
+	UnitType string `json:",omitempty"`
+	Unit     string `json:",omitempty"`
+
+	// Wall is the elapsed real time of the rule's tool. UserCPU and
+	// SysCPU are the user and system CPU time used by the tool's
+	// process and its children, and MaxRSS is the largest resident
+	// set size (in bytes) of any of them. The CPU and memory usage is
+	// 0 on systems where it can't be determined.
+	Wall    time.Duration
+	UserCPU time.Duration `json:",omitempty"`
+	SysCPU  time.Duration `json:",omitempty"`
+	MaxRSS  int64         `json:",omitempty"`
+}
+
+// CPU returns the total CPU time (user and system) used by the rule.
+func (s *RuleStats) CPU() time.Duration { return s.UserCPU + s.SysCPU }
+
+// WriteBuildInfo writes info to the BuildInfoName file in a commit's
+// build data (in commitFS), replacing any existing build info. It
+// should be called before WriteChecksumManifest, so that the build
+// info is covered by the manifest.
+func WriteBuildInfo(commitFS rwvfs.FileSystem, info *BuildInfo) error {
+	f, err := commitFS.Create(BuildInfoName)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadBuildInfo reads the build info of a commit's build data (in
+// commitFS).
+func ReadBuildInfo(commitFS vfs.FileSystem) (*BuildInfo, error) {
+	f, err := commitFS.Open(BuildInfoName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var info BuildInfo
+	if err := json.NewDecoder(f).Decode(&info); err != nil {
+		return nil, fmt.Errorf("reading build info: %s", err)
+	}
+	return &info, nil
+}
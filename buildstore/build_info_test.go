@@ -0,0 +1,38 @@
+package buildstore
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestBuildInfo(t *testing.T) {
+	fs := rwvfs.Map(map[string]string{})
+	if _, err := ReadBuildInfo(fs); !os.IsNotExist(err) {
+		t.Errorf("ReadBuildInfo with no build info: got err %v, want not-exist error", err)
+	}
+
+	info := &BuildInfo{
+		SrclibVersion: "0.1",
+		Host:          "h",
+		Toolchains:    map[string]string{"sourcegraph.com/sourcegraph/srclib-go": "abc"},
+		Started:       time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration:      time.Minute,
+		Rules: []*RuleStats{
+			{Target: "u/t.graph.json", Op: "graph", UnitType: "t", Unit: "u", Wall: time.Second, UserCPU: 2 * time.Second, MaxRSS: 1 << 20},
+		},
+	}
+	if err := WriteBuildInfo(fs, info); err != nil {
+		t.Fatal(err)
+	}
+	info2, err := ReadBuildInfo(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info2, info) {
+		t.Errorf("got %+v, want %+v", info2, info)
+	}
+}
@@ -0,0 +1,144 @@
+package buildstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/kr/fs"
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// ChecksumManifestName is the name of the file (at the root of a
+// commit's build data) that lists the SHA-256 checksums of the
+// commit's other build data files.
+var ChecksumManifestName = "checksums.json"
+
+// A ChecksumManifest maps build data file paths (slash-separated and
+// relative to the root of a commit's build data) to their SHA-256
+// checksums (in hex).
+type ChecksumManifest map[string]string
+
+// WriteChecksumManifest computes the checksums of all files in a
+// commit's build data (in commitFS) and writes them to the
+// ChecksumManifestName file, replacing any existing manifest.
+func WriteChecksumManifest(commitFS rwvfs.WalkableFileSystem) error {
+	m := ChecksumManifest{}
+	w := fs.WalkFS(".", commitFS)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		p := filepath.ToSlash(w.Path())
+		if fi := w.Stat(); !fi.Mode().IsRegular() || p == ChecksumManifestName {
+			continue
+		}
+		sum, err := fileChecksum(commitFS, p)
+		if err != nil {
+			return err
+		}
+		m[p] = sum
+	}
+
+	f, err := commitFS.Create(ChecksumManifestName)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadChecksumManifest reads the checksum manifest of a commit's build
+// data (in commitFS).
+func ReadChecksumManifest(commitFS vfs.FileSystem) (ChecksumManifest, error) {
+	f, err := commitFS.Open(ChecksumManifestName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m ChecksumManifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("reading build data checksum manifest: %s", err)
+	}
+	return m, nil
+}
+
+// Verify checks that the files in commitFS match the checksums in the
+// manifest. If paths are given, only those files are checked, and
+// each must be listed in the manifest (or not exist); otherwise all
+// files listed in the manifest are checked. It returns an error for
+// which IsChecksumMismatch returns true if a file is missing,
+// unlisted, or has changed.
+func (m ChecksumManifest) Verify(commitFS vfs.FileSystem, paths ...string) error {
+	if len(paths) == 0 {
+		for p := range m {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+	}
+	for _, p := range paths {
+		p = path.Clean(filepath.ToSlash(p))
+		want, listed := m[p]
+		got, err := fileChecksum(commitFS, p)
+		switch {
+		case os.IsNotExist(err) && listed:
+			return &errChecksumMismatch{path: p, reason: "file is missing"}
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return err
+		case !listed:
+			return &errChecksumMismatch{path: p, reason: "file is not listed in " + ChecksumManifestName}
+		case got != want:
+			return &errChecksumMismatch{path: p, reason: "file has changed (it may be truncated or corrupted)"}
+		}
+	}
+	return nil
+}
+
+// errChecksumMismatch is returned by ChecksumManifest.Verify when a
+// build data file does not match the manifest.
+type errChecksumMismatch struct {
+	path, reason string
+}
+
+func (e *errChecksumMismatch) Error() string {
+	return fmt.Sprintf("build data file %s does not match its checksum: %s", e.path, e.reason)
+}
+
+// IsChecksumMismatch returns a boolean indicating whether err reports
+// that a build data file does not match its checksum manifest.
+func IsChecksumMismatch(err error) bool {
+	_, ok := err.(*errChecksumMismatch)
+	return ok
+}
+
+// fileChecksum returns the SHA-256 checksum (in hex) of a file.
+func fileChecksum(fs vfs.FileSystem, path string) (string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
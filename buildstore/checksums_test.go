@@ -0,0 +1,45 @@
+package buildstore
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestChecksumManifest(t *testing.T) {
+	m := map[string]string{
+		"a.json":   "a",
+		"b/c.json": "c",
+	}
+	fs := rwvfs.Walkable(rwvfs.Map(m))
+	if err := WriteChecksumManifest(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadChecksumManifest(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 2 {
+		t.Errorf("got %d manifest entries, want 2", len(manifest))
+	}
+	if err := manifest.Verify(fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Verify(fs, "b/c.json", "d.json"); err != nil {
+		t.Errorf("Verify with a nonexistent, unlisted path: %s", err)
+	}
+
+	m["b/c.json"] = "truncated"
+	if err := manifest.Verify(fs); !IsChecksumMismatch(err) {
+		t.Errorf("after changing a file, got err %v, want checksum mismatch", err)
+	}
+	delete(m, "b/c.json")
+	if err := manifest.Verify(fs); !IsChecksumMismatch(err) {
+		t.Errorf("after removing a file, got err %v, want checksum mismatch", err)
+	}
+	m["d.json"] = "d"
+	if err := manifest.Verify(fs, "d.json"); !IsChecksumMismatch(err) {
+		t.Errorf("with an unlisted file, got err %v, want checksum mismatch", err)
+	}
+}
@@ -0,0 +1,222 @@
+package buildstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// HTTP returns a VFS for build data stored on an HTTP server (e.g., a
+// CI artifacts server) at baseURL. Paths are resolved relative to
+// baseURL, and the server must handle the following requests:
+//
+//   GET    PATH   read a file
+//   HEAD   PATH   stat a file (using its Content-Length and Last-Modified)
+//   PUT    PATH   create or overwrite a file
+//   DELETE PATH   remove a file
+//   GET    PATH/  list a dir, as a JSON array of objects with the fields
+//                 "Name", "Size", "ModTime", and "Dir"
+//
+// A 404 response means that the file or dir does not exist. Dirs are
+// implicit (Mkdir does nothing, and a dir exists if it has files). Every request includes the query
+// string of baseURL (e.g., a signature or token) and the given header
+// (e.g., Authorization).
+func HTTP(baseURL *url.URL, header http.Header) rwvfs.FileSystem {
+	return &httpFS{base: baseURL, header: header}
+}
+
+type httpFS struct {
+	base   *url.URL
+	header http.Header
+}
+
+// url returns the URL of the named file (or dir, if dir is true).
+func (fs *httpFS) url(name string, dir bool) string {
+	u := *fs.base
+	p := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + p
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// do sends an HTTP request for the named file (or dir). It returns an
+// error if the response status is not 2xx. The caller must close the
+// returned response's body.
+func (fs *httpFS) do(method, name string, dir bool, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, fs.url(name, dir), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fs.header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: name, Err: os.ErrNotExist}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: name, Err: fmt.Errorf("HTTP error: %s", resp.Status)}
+	}
+	return resp, nil
+}
+
+func (fs *httpFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	resp, err := fs.do("GET", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(b)}, nil
+}
+
+func (fs *httpFS) Lstat(name string) (os.FileInfo, error) { return fs.Stat(name) }
+
+func (fs *httpFS) Stat(name string) (os.FileInfo, error) {
+	if p := path.Clean(filepath.ToSlash(name)); p != "." && p != "/" {
+		resp, err := fs.do("HEAD", name, false, nil)
+		if err == nil {
+			resp.Body.Close()
+			fi := &httpFileInfo{name: path.Base(p), size: resp.ContentLength}
+			if fi.size < 0 {
+				fi.size = 0
+			}
+			if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+				fi.modTime = t
+			}
+			return fi, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	// Not a file; see if it's a dir.
+	if _, err := fs.ReadDir(name); err != nil {
+		return nil, err
+	}
+	return &httpFileInfo{name: path.Base(filepath.ToSlash(name)), dir: true}, nil
+}
+
+// httpDirEntry is an entry in the JSON dir listings returned by the
+// HTTP build data server.
+type httpDirEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+}
+
+func (fs *httpFS) ReadDir(name string) ([]os.FileInfo, error) {
+	resp, err := fs.do("GET", name, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var entries []httpDirEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("reading HTTP build data dir listing for %s: %s", name, err)
+	}
+	fis := make([]os.FileInfo, len(entries))
+	for i, e := range entries {
+		fis[i] = &httpFileInfo{name: e.Name, size: e.Size, modTime: e.ModTime, dir: e.Dir}
+	}
+	return fis, nil
+}
+
+func (fs *httpFS) Create(name string) (io.WriteCloser, error) {
+	return &httpFileWriter{fs: fs, name: name}, nil
+}
+
+func (fs *httpFS) Mkdir(name string) error { return nil }
+
+// Remove removes a file. Because dirs are implicit, removing a dir
+// that has no files (or that does not exist) succeeds.
+func (fs *httpFS) Remove(name string) error {
+	resp, err := fs.do("DELETE", name, false, nil)
+	if os.IsNotExist(err) {
+		if _, err2 := fs.ReadDir(name); os.IsNotExist(err2) {
+			return nil
+		} else if err2 == nil {
+			return &os.PathError{Op: "remove", Path: name, Err: fmt.Errorf("dir is not empty")}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// String returns a description of the VFS that omits the base URL's
+// query string, which may contain credentials.
+func (fs *httpFS) String() string {
+	u := *fs.base
+	u.RawQuery = ""
+	return "http(" + u.String() + ")"
+}
+
+// httpFileWriter buffers a file's data and PUTs it to the server when
+// it is closed.
+type httpFileWriter struct {
+	fs   *httpFS
+	name string
+	bytes.Buffer
+}
+
+func (w *httpFileWriter) Close() error {
+	resp, err := w.fs.do("PUT", w.name, false, bytes.NewReader(w.Bytes()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type httpFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *httpFileInfo) Name() string       { return fi.name }
+func (fi *httpFileInfo) Size() int64        { return fi.size }
+func (fi *httpFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *httpFileInfo) IsDir() bool        { return fi.dir }
+func (fi *httpFileInfo) Sys() interface{}   { return nil }
+
+func (fi *httpFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,148 @@
+package buildstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testHTTPServer is an in-memory HTTP build data server (see HTTP).
+type testHTTPServer struct {
+	mu    sync.Mutex
+	files map[string][]byte // path (with leading "/") -> data
+}
+
+func (s *testHTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer t" || r.URL.Query().Get("sig") != "s" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := r.URL.Path
+	if strings.HasSuffix(p, "/") {
+		seen := map[string]bool{}
+		var entries []httpDirEntry
+		for f, data := range s.files {
+			if !strings.HasPrefix(f, p) {
+				continue
+			}
+			rest := strings.TrimPrefix(f, p)
+			if i := strings.Index(rest, "/"); i != -1 {
+				if name := rest[:i]; !seen[name] {
+					seen[name] = true
+					entries = append(entries, httpDirEntry{Name: name, Dir: true})
+				}
+			} else {
+				entries = append(entries, httpDirEntry{Name: rest, Size: int64(len(data))})
+			}
+		}
+		if entries == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		data, ok := s.files[p]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	case "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		s.files[p] = data
+	case "DELETE":
+		if _, ok := s.files[p]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.files, p)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(&testHTTPServer{files: map[string][]byte{}})
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL + "/bd/?sig=s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := HTTP(base, http.Header{"Authorization": []string{"Bearer t"}})
+
+	if _, err := fs.Stat("a/b.json"); !os.IsNotExist(err) {
+		t.Errorf("got err %v, want a not-exist error", err)
+	}
+
+	for _, name := range []string{"a/b.json", "a/c/d.json", "e.json"} {
+		w, err := fs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := fs.Open("a/b.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a/b.json" {
+		t.Errorf("got data %q, want %q", data, "a/b.json")
+	}
+
+	if fi, err := fs.Stat("a/b.json"); err != nil {
+		t.Fatal(err)
+	} else if fi.IsDir() || fi.Size() != int64(len("a/b.json")) {
+		t.Errorf("got file info %+v, want a file of size %d", fi, len("a/b.json"))
+	}
+	if fi, err := fs.Stat("a/c"); err != nil {
+		t.Fatal(err)
+	} else if !fi.IsDir() {
+		t.Errorf("got file info %+v, want a dir", fi)
+	}
+
+	fis, err := fs.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"a", "e.json"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("got dir entries %v, want %v", names, want)
+	}
+
+	if err := fs.Remove("e.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Open("e.json"); !os.IsNotExist(err) {
+		t.Errorf("after remove, got err %v, want a not-exist error", err)
+	}
+
+	if s := fs.String(); strings.Contains(s, "sig=") {
+		t.Errorf("String() %q contains the base URL's query string", s)
+	}
+}
@@ -60,8 +60,15 @@ func Repo(repoStoreFS rwvfs.WalkableFileSystem) RepoBuildStore {
 //   .                the root dir of repoStoreFS
 //   <COMMITID>/**/*  build data for a specific commit
 func LocalRepo(repoDir string) (RepoBuildStore, error) {
-	storeDir := filepath.Join(repoDir, BuildDataDirName)
-	if err := os.Mkdir(storeDir, 0700); err != nil && !os.IsExist(err) {
+	return LocalRepoDir(filepath.Join(repoDir, BuildDataDirName))
+}
+
+// LocalRepoDir creates a new single-repository build store rooted at
+// storeDir (laid out like LocalRepo's), which need not be in the
+// repository (e.g., when build data is written outside of the tree).
+// It creates storeDir if it doesn't exist.
+func LocalRepoDir(storeDir string) (RepoBuildStore, error) {
+	if err := os.MkdirAll(storeDir, 0700); err != nil {
 		return nil, err
 	}
 	fs := rwvfs.OS(storeDir)
@@ -26,6 +26,6 @@ func main() {
 	}
 
 	if err := src.Main(); err != nil {
-		os.Exit(1)
+		os.Exit(src.ExitCode(err))
 	}
 }
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// BuildData configures where `src make` writes a repository's local
+// build data (and where other commands read it from). It is read from
+// the "BuildData" section of the Srcfile and of the .srclibrc file.
+// Command-line flags and the SRCLIB_BUILD_DATA_DIR environment variable
+// override it.
+type BuildData struct {
+	// Dir is the directory that contains the build data, in a
+	// subdirectory per commit, instead of the .srclib-cache directory
+	// in the top-level directory of the repository. Environment
+	// variables in it (such as $HOME or $TMPDIR) are expanded, and
+	// relative paths are relative to the top-level directory of the
+	// repository.
+	//
+	// Setting it to a directory outside of the repository avoids
+	// writing into the tree, which breaks some build systems and
+	// isn't possible in read-only checkouts. Each repository must use
+	// a separate directory.
+	Dir string `json:",omitempty"`
+}
+
+// ReadBuildData reads the build data configuration for the repository
+// whose top-level directory is dir. Settings in the .srclibrc file
+// override those in the Srcfile. If neither file configures the build
+// data, it returns an empty (non-nil) BuildData.
+func ReadBuildData(dir string) (*BuildData, error) {
+	var srcfile Repository
+	if err := readJSONFileIfExists(filepath.Join(dir, Filename), &srcfile); err != nil {
+		return nil, err
+	}
+	var rc rcFile
+	if err := readJSONFileIfExists(filepath.Join(dir, RCFilename), &rc); err != nil {
+		return nil, err
+	}
+
+	b := &BuildData{}
+	for _, o := range []*BuildData{srcfile.BuildData, rc.BuildData} {
+		if o == nil {
+			continue
+		}
+		if o.Dir != "" {
+			b.Dir = o.Dir
+		}
+	}
+	return b, nil
+}
+
+// DirPath returns the absolute path of b.Dir for the repository whose
+// top-level directory is repoDir, or "" if b.Dir is empty.
+func (b *BuildData) DirPath(repoDir string) (string, error) {
+	if b.Dir == "" {
+		return "", nil
+	}
+	dir := os.ExpandEnv(b.Dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repoDir, dir)
+	}
+	return filepath.Abs(dir)
+}
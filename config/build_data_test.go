@@ -0,0 +1,60 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBuildData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := ReadBuildData(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Dir != "" {
+		t.Errorf("no config files: got Dir %q, want empty", b.Dir)
+	}
+	if path, err := b.DirPath(dir); err != nil || path != "" {
+		t.Errorf("no config files: got DirPath %q (err %v), want empty", path, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, Filename), []byte(`{"BuildData": {"Dir": "../build"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ReadBuildData(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := b.DirPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(dir), "build"); path != want {
+		t.Errorf("Srcfile: got DirPath %q, want %q", path, want)
+	}
+
+	// The .srclibrc overrides the Srcfile, and env vars are expanded.
+	if err := ioutil.WriteFile(filepath.Join(dir, RCFilename), []byte(`{"BuildData": {"Dir": "$SRCLIB_TEST_BUILD_DATA/r"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SRCLIB_TEST_BUILD_DATA", "/tmp/bd")
+	defer os.Unsetenv("SRCLIB_TEST_BUILD_DATA")
+	b, err = ReadBuildData(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err = b.DirPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/tmp/bd/r"; path != want {
+		t.Errorf(".srclibrc: got DirPath %q, want %q", path, want)
+	}
+}
@@ -23,6 +23,12 @@ import (
 // the Srcfile; the Srcfile's directives are already accounted for in
 // the cached source unit definition files.
 //
+// If `src config` recorded the tree's per-directory overlays in the
+// cache (see OverlaysFilename), they are merged into the returned
+// Tree's source units hierarchically (see Tree.ApplyOverlays), and
+// units that they skip are omitted. Likewise, the recorded SkipPaths
+// (see SkipPathsFilename) are removed from the units.
+//
 // bdfs should be a VFS obtained from a call to
 // (buildstore.RepoBuildStore).Commit.
 func ReadCached(bdfs vfs.FileSystem) (*Tree, error) {
@@ -69,5 +75,21 @@ func ReadCached(bdfs vfs.FileSystem) (*Tree, error) {
 	if err := par.Wait(); err != nil {
 		return nil, err
 	}
-	return &Tree{SourceUnits: units}, nil
+
+	overlays, err := readCachedOverlays(bdfs)
+	if err != nil {
+		return nil, err
+	}
+	skipPaths, err := readCachedSkipPaths(bdfs)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{Overlays: overlays, SkipPaths: skipPaths}
+	skip := t.SkipPathsRules()
+	for _, u := range units {
+		if skip.FilterUnit(u) && t.ApplyOverlays(u, "") {
+			t.SourceUnits = append(t.SourceUnits, u)
+		}
+	}
+	return t, nil
 }
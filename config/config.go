@@ -21,6 +21,14 @@ type Repository struct {
 	// Tree is the configuration for the top-level directory tree in the
 	// repository.
 	Tree
+
+	// Store configures the default store for `src store` commands
+	// (see ReadStore).
+	Store *Store `json:",omitempty"`
+
+	// BuildData configures where local build data is stored (see
+	// ReadBuildData).
+	BuildData *BuildData `json:",omitempty"`
 }
 
 // Tree represents the config for a directory and its subdirectories.
@@ -57,6 +65,19 @@ type Tree struct {
 	// not processed further.
 	SkipDirs []string `json:",omitempty"`
 
+	// SkipPaths is a list of gitignore-style patterns (relative to the
+	// top-level directory of the tree) of files and directories that
+	// are skipped: they are removed from scanned source units' Files,
+	// and source units whose Dir or files are all skipped are not
+	// processed further. The patterns are also sent to scanners (in
+	// their config's "SkipPaths" property) so that they can avoid
+	// scanning those paths at all.
+	//
+	// ReadRepository adds the patterns in the tree's .gitignore files
+	// and in its overlays' SkipPaths, so after it is called, SkipPaths
+	// lists all of the tree's effective patterns.
+	SkipPaths []string `json:",omitempty"`
+
 	// SkipUnits is a list of source units that are skipped. That is,
 	// any scanned source units whose name and type exactly matches a
 	// name and type pair in SkipUnits is skipped.
@@ -70,6 +91,12 @@ type Tree struct {
 	// Config is an arbitrary key-value property map. Properties are copied
 	// verbatim to each source unit that is scanned in this tree.
 	Config map[string]interface{} `json:",omitempty"`
+
+	// Overlays maps slash-separated subdirectory paths (relative to the
+	// top-level directory of the tree) to the configuration in the
+	// Srcfiles in those subdirectories (see ReadOverlays). It is not
+	// read from the top-level Srcfile.
+	Overlays map[string]*Overlay `json:"-"`
 }
 
 // ReadRepository parses and validates the configuration for a repository. If no
@@ -80,7 +107,8 @@ type Tree struct {
 func ReadRepository(dir string, repoURI string) (*Repository, error) {
 	var c *Repository
 	if oc, overridden := Overrides[repoURI]; overridden {
-		c = oc
+		occ := *oc
+		c = &occ
 	} else if f, err := os.Open(filepath.Join(dir, Filename)); err == nil {
 		defer f.Close()
 		err = json.NewDecoder(f).Decode(&c)
@@ -94,6 +122,17 @@ func ReadRepository(dir string, repoURI string) (*Repository, error) {
 		return nil, err
 	}
 
+	overlays, err := ReadOverlays(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(overlays) > 0 {
+		c.Overlays = overlays
+	}
+	if c.SkipPaths, err = readSkipPaths(dir, &c.Tree); err != nil {
+		return nil, err
+	}
+
 	return c.finish(repoURI)
 }
 
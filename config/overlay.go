@@ -0,0 +1,210 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// An Overlay is the configuration in a Srcfile in a subdirectory of a
+// tree (a "Srcfile fragment"). It applies to the source units whose
+// Dir is in that subdirectory's tree, in addition to the configuration
+// in the top-level Srcfile and in the Srcfiles of any intermediate
+// directories.
+//
+// Overlays let monorepos configure heterogeneous subtrees in the
+// subtrees themselves, instead of listing every subtree's settings in
+// the top-level Srcfile.
+type Overlay struct {
+	// SkipScanners is a list of toolchain paths (e.g.,
+	// "sourcegraph.com/sourcegraph/srclib-python") whose scanners'
+	// source units in this directory tree are skipped.
+	SkipScanners []string `json:",omitempty"`
+
+	// SkipDirs is a list of directory trees, relative to the overlay's
+	// directory, that are skipped (see Tree.SkipDirs).
+	SkipDirs []string `json:",omitempty"`
+
+	// SkipPaths is a list of gitignore-style patterns, relative to the
+	// overlay's directory, of paths that are skipped (see
+	// Tree.SkipPaths).
+	SkipPaths []string `json:",omitempty"`
+
+	// SkipUnits is a list of source units in this directory tree that
+	// are skipped (see Tree.SkipUnits).
+	SkipUnits []struct{ Name, Type string } `json:",omitempty"`
+
+	// Config is merged into the Config of each source unit in this
+	// directory tree, as with Tree.Config. Properties in an overlay
+	// take precedence over those in the overlays of ancestor
+	// directories and in the top-level Srcfile, but not over those set
+	// by the scanner.
+	Config map[string]interface{} `json:",omitempty"`
+}
+
+// OverlaysFilename is the name of the file in the build cache (next
+// to the source unit definition files) that `src config` writes a
+// tree's overlays to, so that ReadCached can apply them.
+const OverlaysFilename = "Srcfile.overlays.json"
+
+// ReadOverlays reads the Srcfiles in the subdirectories of dir. It
+// returns a map of slash-separated directory paths (relative to dir)
+// to the overlays they define. The Srcfile in dir itself is not an
+// overlay (see ReadRepository), and directories whose names begin
+// with "." (such as .git and .srclib-cache) are not searched.
+func ReadOverlays(dir string) (map[string]*Overlay, error) {
+	overlays := map[string]*Overlay{}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if p != dir && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Name() != Filename {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		var o *Overlay
+		if err := readJSONFileIfExists(p, &o); err != nil {
+			return err
+		}
+		if o != nil {
+			overlays[filepath.ToSlash(rel)] = o
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return overlays, nil
+}
+
+// readCachedOverlays reads the overlays that `src config` wrote to the
+// build cache (see OverlaysFilename). If there are none, it returns
+// nil.
+func readCachedOverlays(bdfs vfs.FileSystem) (map[string]*Overlay, error) {
+	f, err := bdfs.Open(OverlaysFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var overlays map[string]*Overlay
+	if err := json.NewDecoder(f).Decode(&overlays); err != nil {
+		return nil, err
+	}
+	return overlays, nil
+}
+
+// ApplyOverlays applies the overlays of the directories containing u
+// (outermost first) to u. If any of them skip u, it returns false.
+// Otherwise it merges their Config properties into u.Config and
+// returns true.
+//
+// The scanner argument is the toolchain path of the scanner that
+// produced u, if known; if it is empty, the overlays' SkipScanners are
+// not consulted.
+func (t *Tree) ApplyOverlays(u *unit.SourceUnit, scanner string) bool {
+	if len(t.Overlays) == 0 {
+		return true
+	}
+
+	dir := UnitDir(u)
+	var config map[string]interface{}
+	for _, odir := range overlayDirs(t.Overlays, dir) {
+		o := t.Overlays[odir]
+		for _, s := range o.SkipScanners {
+			if scanner != "" && s == scanner {
+				return false
+			}
+		}
+		for _, skipDir := range o.SkipDirs {
+			if pathHasPrefix(dir, path.Join(odir, skipDir)) {
+				return false
+			}
+		}
+		for _, skipUnit := range o.SkipUnits {
+			if u.Name == skipUnit.Name && u.Type == skipUnit.Type {
+				return false
+			}
+		}
+		for k, v := range o.Config {
+			if config == nil {
+				config = map[string]interface{}{}
+			}
+			config[k] = v
+		}
+	}
+
+	for k, v := range config {
+		if _, present := u.Config[k]; present {
+			continue
+		}
+		if u.Config == nil {
+			u.Config = map[string]interface{}{}
+		}
+		u.Config[k] = v
+	}
+	return true
+}
+
+// overlayDirs returns the directories in overlays that contain dir,
+// outermost first.
+func overlayDirs(overlays map[string]*Overlay, dir string) []string {
+	var dirs []string
+	for odir := range overlays {
+		if pathHasPrefix(dir, odir) {
+			dirs = append(dirs, odir)
+		}
+	}
+	sort.Sort(byPathDepth(dirs))
+	return dirs
+}
+
+type byPathDepth []string
+
+func (v byPathDepth) Len() int      { return len(v) }
+func (v byPathDepth) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byPathDepth) Less(i, j int) bool {
+	di, dj := strings.Count(v[i], "/"), strings.Count(v[j], "/")
+	if di != dj {
+		return di < dj
+	}
+	return v[i] < v[j]
+}
+
+// UnitDir returns the directory of the source unit u: its Dir, or if
+// that is empty, the directory of its first file.
+func UnitDir(u *unit.SourceUnit) string {
+	dir := u.Dir
+	if dir == "" && len(u.Files) > 0 {
+		dir = path.Dir(filepath.ToSlash(u.Files[0]))
+	}
+	return dir
+}
+
+// pathHasPrefix returns whether the slash-separated path p is prefix
+// or is underneath the directory prefix.
+func pathHasPrefix(p, prefix string) bool {
+	p = path.Clean(filepath.ToSlash(p))
+	prefix = path.Clean(filepath.ToSlash(prefix))
+	return prefix == "." || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
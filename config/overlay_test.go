@@ -0,0 +1,77 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestOverlays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		Filename:                               `{"Config": {"a": "root"}}`,
+		"svc/" + Filename:                      `{"Config": {"a": "svc", "b": "svc"}, "SkipDirs": ["gen"]}`,
+		"svc/py/" + Filename:                   `{"Config": {"b": "py"}, "SkipScanners": ["srclib-python"], "SkipUnits": [{"Name": "x", "Type": "GoPackage"}]}`,
+		".git/" + Filename:                     `{"Config": {"a": "git"}}`,
+		"svc/py/lib/README":                    ``,
+		"other/" + Filename + ".unrelated.txt": `{}`,
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overlays, err := ReadOverlays(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for d := range overlays {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	if want := []string{"svc", "svc/py"}; !reflect.DeepEqual(dirs, want) {
+		t.Fatalf("got overlay dirs %v, want %v", dirs, want)
+	}
+
+	tree := &Tree{Overlays: overlays}
+	tests := []struct {
+		unit       *unit.SourceUnit
+		scanner    string
+		wantOK     bool
+		wantConfig map[string]interface{}
+	}{
+		{&unit.SourceUnit{Name: "u", Type: "GoPackage", Dir: "top"}, "srclib-go", true, nil},
+		{&unit.SourceUnit{Name: "u", Type: "GoPackage", Dir: "svc"}, "srclib-go", true, map[string]interface{}{"a": "svc", "b": "svc"}},
+		{&unit.SourceUnit{Name: "u", Type: "GoPackage", Dir: "svc/gen/x"}, "srclib-go", false, nil},
+		{&unit.SourceUnit{Name: "u", Type: "GoPackage", Files: []string{"svc/py/lib/a.go"}}, "srclib-go", true, map[string]interface{}{"a": "svc", "b": "py"}},
+		{&unit.SourceUnit{Name: "u", Type: "PipPackage", Dir: "svc/py/lib"}, "srclib-python", false, nil},
+		{&unit.SourceUnit{Name: "x", Type: "GoPackage", Dir: "svc/py"}, "srclib-go", false, nil},
+		{&unit.SourceUnit{Name: "u", Type: "GoPackage", Dir: "svc", Config: map[string]interface{}{"a": "scanner"}}, "", true, map[string]interface{}{"a": "scanner", "b": "svc"}},
+	}
+	for _, test := range tests {
+		ok := tree.ApplyOverlays(test.unit, test.scanner)
+		if ok != test.wantOK {
+			t.Errorf("%+v: got ok %v, want %v", test.unit, ok, test.wantOK)
+			continue
+		}
+		if ok && !reflect.DeepEqual(test.unit.Config, test.wantConfig) {
+			t.Errorf("%+v: got Config %v, want %v", test.unit, test.unit.Config, test.wantConfig)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// SkipPathsFilename is the name of the file in the build cache (next
+// to the source unit definition files) that `src config` writes a
+// tree's effective SkipPaths to, one pattern per line, so that
+// ReadCached and the plan built from it honor them.
+const SkipPathsFilename = "Srcfile.skippaths"
+
+// gitignoreFilename is the name of the files whose patterns are
+// added to a tree's SkipPaths.
+const gitignoreFilename = ".gitignore"
+
+// IgnoreRules matches paths against a list of gitignore-style
+// patterns (see Tree.SkipPaths).
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+// ignoreRule is a single parsed pattern. Its pattern is relative to the
+// tree root and always anchored (unanchored gitignore patterns are
+// prefixed with "**/").
+type ignoreRule struct {
+	pattern []string // slash-separated pattern components
+	negate  bool     // "!pattern": re-include matching paths
+	dirOnly bool     // "pattern/": only match directories
+}
+
+// NewIgnoreRules parses gitignore-style patterns that are relative to
+// the slash-separated directory base (relative to the tree root; "."
+// or "" for the root). Blank lines and lines beginning with "#" are
+// ignored.
+func NewIgnoreRules(base string, patterns []string) *IgnoreRules {
+	r := &IgnoreRules{}
+	r.add(base, patterns)
+	return r
+}
+
+func (r *IgnoreRules) add(base string, patterns []string) {
+	base = path.Clean(filepath.ToSlash(base))
+	for _, p := range patterns {
+		p = strings.TrimRight(p, " \t\r")
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(p, "!") {
+			rule.negate = true
+			p = p[1:]
+		} else if strings.HasPrefix(p, `\#`) || strings.HasPrefix(p, `\!`) {
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			rule.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			p = "**/" + p
+		}
+		p = strings.TrimPrefix(p, "/")
+		if base != "." {
+			p = base + "/" + p
+		}
+		rule.pattern = strings.Split(p, "/")
+		r.rules = append(r.rules, rule)
+	}
+}
+
+// Patterns returns the rules as gitignore-style patterns relative to
+// the tree root. Parsing them with NewIgnoreRules(".", ...) yields
+// equivalent rules.
+func (r *IgnoreRules) Patterns() []string {
+	patterns := make([]string, len(r.rules))
+	for i, rule := range r.rules {
+		p := "/" + strings.Join(rule.pattern, "/")
+		if rule.negate {
+			p = "!" + p
+		}
+		if rule.dirOnly {
+			p += "/"
+		}
+		patterns[i] = p
+	}
+	return patterns
+}
+
+// Ignored returns whether the path p (relative to the tree root) is
+// matched by the rules. As with .gitignore, paths underneath an
+// ignored directory are ignored, and later rules override earlier
+// ones.
+func (r *IgnoreRules) Ignored(p string, isDir bool) bool {
+	if len(r.rules) == 0 {
+		return false
+	}
+	p = path.Clean(filepath.ToSlash(p))
+	if p == "." {
+		return false
+	}
+	parts := strings.Split(p, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.match(parts[:i], true) {
+			return true
+		}
+	}
+	return r.match(parts, isDir)
+}
+
+func (r *IgnoreRules) match(parts []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchPathPattern(rule.pattern, parts) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchPathPattern returns whether the path components match the
+// pattern components, where a "**" component matches zero or more
+// path components and other components are matched by path.Match.
+func matchPathPattern(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchPathPattern(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// FilterUnit removes the files that are ignored by r from u.Files. It
+// returns false if u should be skipped entirely: if its directory is
+// ignored, or if all of its files are.
+func (r *IgnoreRules) FilterUnit(u *unit.SourceUnit) bool {
+	if len(r.rules) == 0 {
+		return true
+	}
+	if dir := UnitDir(u); dir != "" && r.Ignored(dir, true) {
+		return false
+	}
+	if len(u.Files) == 0 {
+		return true
+	}
+	files := make([]string, 0, len(u.Files))
+	for _, f := range u.Files {
+		if !r.Ignored(f, false) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return false
+	}
+	u.Files = files
+	return true
+}
+
+// SkipPathsRules returns the rules for t.SkipPaths.
+func (t *Tree) SkipPathsRules() *IgnoreRules {
+	return NewIgnoreRules(".", t.SkipPaths)
+}
+
+// readSkipPaths returns the effective SkipPaths of the tree rooted at
+// dir, relative to dir: the patterns in the .gitignore files in the
+// tree, then those in c.SkipPaths, then those in the overlays'
+// SkipPaths. Directories that are ignored by the patterns read so far
+// are not searched for .gitignore files.
+func readSkipPaths(dir string, c *Tree) ([]string, error) {
+	r := &IgnoreRules{}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if rel != "." && (strings.HasPrefix(fi.Name(), ".") || r.Ignored(rel, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Name() != gitignoreFilename {
+			return nil
+		}
+		patterns, err := readLines(p)
+		if err != nil {
+			return err
+		}
+		r.add(filepath.Dir(rel), patterns)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.add(".", c.SkipPaths)
+	odirs := make([]string, 0, len(c.Overlays))
+	for odir := range c.Overlays {
+		odirs = append(odirs, odir)
+	}
+	sort.Sort(byPathDepth(odirs))
+	for _, odir := range odirs {
+		r.add(odir, c.Overlays[odir].SkipPaths)
+	}
+	return r.Patterns(), nil
+}
+
+// readCachedSkipPaths reads the SkipPaths that `src config` wrote to
+// the build cache (see SkipPathsFilename). If there are none, it
+// returns nil.
+func readCachedSkipPaths(bdfs vfs.FileSystem) ([]string, error) {
+	f, err := bdfs.Open(SkipPathsFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanLines(f)
+}
+
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanLines(f)
+}
+
+func scanLines(f io.Reader) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines, s.Err()
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	r := NewIgnoreRules(".", []string{
+		"# comment",
+		"",
+		"*.pb.go",
+		"build/",
+		"/vendor",
+		"docs/**/*.html",
+		"!keep.pb.go",
+	})
+	r.add("web", []string{"node_modules/", "/dist"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.go", false, false},
+		{"a.pb.go", false, true},
+		{"x/y/a.pb.go", false, true},
+		{"x/keep.pb.go", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"x/build/a.go", false, true},
+		{"vendor/a/b.go", false, true},
+		{"x/vendor/b.go", false, false},
+		{"docs/a.html", false, true},
+		{"docs/a/b/c.html", false, true},
+		{"docs/a.md", false, false},
+		{"web/node_modules/x/y.js", false, true},
+		{"web/a/node_modules/y.js", false, true},
+		{"node_modules/y.js", false, false},
+		{"web/dist/a.js", false, true},
+		{"web/a/dist/a.js", false, false},
+	}
+	for _, test := range tests {
+		if got := r.Ignored(test.path, test.isDir); got != test.want {
+			t.Errorf("Ignored(%q, isDir=%v): got %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+
+	// The root-relative patterns are equivalent.
+	r2 := NewIgnoreRules(".", r.Patterns())
+	for _, test := range tests {
+		if got := r2.Ignored(test.path, test.isDir); got != test.want {
+			t.Errorf("from Patterns: Ignored(%q, isDir=%v): got %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+
+	u := &unit.SourceUnit{Dir: "x", Files: []string{"x/a.go", "x/a.pb.go"}}
+	if !r.FilterUnit(u) {
+		t.Errorf("FilterUnit(%+v): got false, want true", u)
+	}
+	if want := []string{"x/a.go"}; !reflect.DeepEqual(u.Files, want) {
+		t.Errorf("FilterUnit: got Files %v, want %v", u.Files, want)
+	}
+	for _, u := range []*unit.SourceUnit{
+		{Dir: "vendor/a", Files: []string{"vendor/a/a.go"}},
+		{Files: []string{"x/a.pb.go", "x/b.pb.go"}},
+	} {
+		if r.FilterUnit(u) {
+			t.Errorf("FilterUnit(%+v): got true, want false", u)
+		}
+	}
+}
+
+func TestReadSkipPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".gitignore":         "out/\n",
+		"web/.gitignore":     "node_modules/\n",
+		"out/sub/.gitignore": "ignored\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree := &Tree{
+		SkipPaths: []string{"*.gen.go"},
+		Overlays:  map[string]*Overlay{"svc": {SkipPaths: []string{"/testdata"}}},
+	}
+	got, err := readSkipPaths(dir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/**/out/", "/web/**/node_modules/", "/**/*.gen.go", "/svc/testdata"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
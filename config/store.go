@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// RCFilename is the name of the optional file (in the top-level
+// directory of a repository) that configures srclib for a project or
+// user without modifying the Srcfile. It is usually not committed to
+// the repository.
+var RCFilename = ".srclibrc"
+
+// Store configures the default store used by `src store` commands
+// run in a repository. It is read from the "Store" section of the
+// Srcfile and of the .srclibrc file. Command-line flags override it.
+type Store struct {
+	// Type is the default store --type (RepoStore or
+	// MultiRepoStore).
+	Type string `json:",omitempty"`
+
+	// Root is the default store --root. Relative paths are relative to
+	// the top-level directory of the repository. It may be an S3 URL
+	// (s3://BUCKET/PATH), to share a store among a team.
+	Root string `json:",omitempty"`
+
+	// Codec is the codec used for new stores (json, protobuf,
+	// protobuf-full, or msgpack).
+	Codec string `json:",omitempty"`
+
+	// Parallel is the max number of concurrent fetches issued by a
+	// single query to a network (e.g., S3) store.
+	Parallel int `json:",omitempty"`
+
+	// IndexDefData lists the Def.Data paths whose values are indexed
+	// (see the store --config IndexDefData).
+	IndexDefData []string `json:",omitempty"`
+}
+
+// rcFile is the format of the .srclibrc file.
+type rcFile struct {
+	Store     *Store
+	BuildData *BuildData
+}
+
+// ReadStore reads the store configuration for the repository whose
+// top-level directory is dir. Settings in the .srclibrc file override
+// those in the Srcfile. If neither file configures the store, it
+// returns an empty (non-nil) Store.
+func ReadStore(dir string) (*Store, error) {
+	var srcfile Repository
+	if err := readJSONFileIfExists(filepath.Join(dir, Filename), &srcfile); err != nil {
+		return nil, err
+	}
+	var rc rcFile
+	if err := readJSONFileIfExists(filepath.Join(dir, RCFilename), &rc); err != nil {
+		return nil, err
+	}
+
+	s := &Store{}
+	for _, o := range []*Store{srcfile.Store, rc.Store} {
+		if o == nil {
+			continue
+		}
+		if o.Type != "" {
+			s.Type = o.Type
+		}
+		if o.Root != "" {
+			s.Root = o.Root
+		}
+		if o.Codec != "" {
+			s.Codec = o.Codec
+		}
+		if o.Parallel != 0 {
+			s.Parallel = o.Parallel
+		}
+		if o.IndexDefData != nil {
+			s.IndexDefData = o.IndexDefData
+		}
+	}
+	return s, nil
+}
+
+// readJSONFileIfExists decodes the JSON file at path into v. It does
+// nothing if the file does not exist.
+func readJSONFileIfExists(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := ReadStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Store{}); !reflect.DeepEqual(s, want) {
+		t.Errorf("no config files: got %+v, want %+v", s, want)
+	}
+
+	files := map[string]string{
+		Filename:   `{"Store": {"Type": "MultiRepoStore", "Root": "s3://bucket/store", "Codec": "json"}}`,
+		RCFilename: `{"Store": {"Codec": "msgpack", "Parallel": 8}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err = ReadStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Store{Type: "MultiRepoStore", Root: "s3://bucket/store", Codec: "msgpack", Parallel: 8}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
@@ -0,0 +1,248 @@
+package dep
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A Cache stores the resolutions of raw dependencies so that they can
+// be reused across source units and repositories. Resolving the same
+// dependency (e.g., a Maven artifact or npm package) in many repos
+// otherwise requires the dependency resolver to hit the network for
+// each one.
+//
+// Resolutions are keyed on the raw dependency (as JSON) and the
+// resolver (its toolchain, subcommand, and version; see
+// ResolverVersion). Only successful resolutions of dependencies on
+// other repositories are cached: errors may be transient, and a
+// resolution to a target without a clone URL refers to the repository
+// that the dependency is in.
+type Cache struct {
+	// Dir is the directory that the cache is stored in.
+	Dir string
+
+	// Offline makes ResolveCached tolerate failures of the depresolve
+	// tool, which is expected to fail when it needs the network to
+	// resolve dependencies that aren't cached (see toolchain.Offline).
+	// The uncached dependencies are given resolution errors instead.
+	Offline bool
+}
+
+// DefaultCacheDir is the directory that holds the depresolve cache
+// that 'src make' uses.
+func DefaultCacheDir() string {
+	return filepath.Join(srclib.CacheDir, "depresolve")
+}
+
+// Get returns the cached resolution of raw by resolver, or nil if
+// there is none.
+func (c *Cache) Get(resolver string, raw interface{}) (*Resolution, error) {
+	path, err := c.path(resolver, raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var res *Resolution
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("reading cached resolution %s: %s", path, err)
+	}
+	return res, nil
+}
+
+// Put caches res (the resolution of res.Raw by resolver), if it is
+// cacheable.
+func (c *Cache) Put(resolver string, res *Resolution) error {
+	if !cacheable(res) {
+		return nil
+	}
+	path, err := c.path(resolver, res.Raw)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// Write to a temp file and rename it, so that concurrent builds
+	// never read a partially written resolution.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func cacheable(res *Resolution) bool {
+	return res.Error == "" && res.Target != nil && res.Target.ToRepoCloneURL != ""
+}
+
+// path returns the path of the file that holds the resolution of raw
+// by resolver.
+func (c *Cache) path(resolver string, raw interface{}) (string, error) {
+	key, err := rawKey(raw)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(resolver + "\x00" + key))
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(c.Dir, name[:2], name[2:]+".json"), nil
+}
+
+// rawKey returns the JSON encoding of raw, which identifies a raw
+// dependency. Because raw dependencies are decoded from JSON (so
+// objects are maps, whose keys are encoded in sorted order), equal
+// dependencies have equal keys.
+func rawKey(raw interface{}) (string, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ResolverVersion returns a string that identifies the version of the
+// depresolve tool subcmd in the toolchain at toolchainPath, for use as
+// a Cache key. It is the toolchain's path, the subcommand, and the
+// toolchain repository's current git commit or (if it isn't a git
+// repository) the modification time of its program or Dockerfile. This
+// makes upgrading a toolchain invalidate its cached resolutions.
+func ResolverVersion(toolchainPath, subcmd string) (string, error) {
+	tc, err := toolchain.Lookup(toolchainPath)
+	if err != nil {
+		return "", err
+	}
+
+	var version string
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = tc.Dir
+	if out, err := cmd.Output(); err == nil {
+		version = strings.TrimSpace(string(out))
+	} else {
+		file := tc.Program
+		if file == "" {
+			file = tc.Dockerfile
+		}
+		fi, err := os.Stat(filepath.Join(tc.Dir, file))
+		if err != nil {
+			return "", err
+		}
+		version = fi.ModTime().UTC().Format("20060102T150405.000000000")
+	}
+	return tc.Path + " " + subcmd + " " + version, nil
+}
+
+// ResolveCached resolves u's dependencies with the depresolve tool,
+// using the cached resolutions in c. The tool is only run on the raw
+// dependencies that have no cached resolution (as the Dependencies of
+// a copy of u), and its cacheable resolutions are added to c. If all
+// of u's dependencies have cached resolutions, the tool isn't run.
+//
+// Source units without Dependencies are always resolved by the tool,
+// because the tool determines their dependencies itself.
+func ResolveCached(c *Cache, tool toolchain.Tool, resolver string, u *unit.SourceUnit) ([]*Resolution, error) {
+	if len(u.Dependencies) == 0 {
+		return c.runTool(tool, u)
+	}
+
+	var (
+		ress   []*Resolution
+		seen   = map[string]bool{} // raw keys of ress
+		misses []interface{}
+	)
+	for _, raw := range u.Dependencies {
+		res, err := c.Get(resolver, raw)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			misses = append(misses, raw)
+			continue
+		}
+		// Use the raw dependency from u, not the cache, in case the
+		// toolchain relies on its exact (non-JSON) representation.
+		res.Raw = raw
+		key, err := rawKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		ress = append(ress, res)
+		seen[key] = true
+	}
+	if len(misses) == 0 {
+		return ress, nil
+	}
+
+	u2 := *u
+	u2.Dependencies = misses
+	resolved, err := c.runTool(tool, &u2)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range resolved {
+		key, err := rawKey(res.Raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Put(resolver, res); err != nil {
+			return nil, err
+		}
+		// Some resolvers determine a unit's dependencies themselves
+		// instead of reading its Dependencies, so they may resolve
+		// dependencies that were cached.
+		if seen[key] {
+			continue
+		}
+		ress = append(ress, res)
+		seen[key] = true
+	}
+	return ress, nil
+}
+
+// runTool resolves u's dependencies with the depresolve tool. If c is
+// Offline and the tool fails, each of u's Dependencies (or u itself,
+// if it has none) is given a resolution error.
+func (c *Cache) runTool(tool toolchain.Tool, u *unit.SourceUnit) ([]*Resolution, error) {
+	var ress []*Resolution
+	err := tool.Run(nil, u, &ress)
+	if err == nil || !c.Offline {
+		return ress, err
+	}
+
+	msg := fmt.Sprintf("offline, and not in the depresolve cache (%s)", err)
+	if len(u.Dependencies) == 0 {
+		return []*Resolution{{Error: msg}}, nil
+	}
+	ress = make([]*Resolution, len(u.Dependencies))
+	for i, raw := range u.Dependencies {
+		ress[i] = &Resolution{Raw: raw, Error: msg}
+	}
+	return ress, nil
+}
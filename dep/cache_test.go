@@ -0,0 +1,145 @@
+package dep
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// fakeResolver is a depresolve tool that resolves each raw dependency
+// (a string) to a repository with that clone URL, except for "error",
+// whose resolution fails.
+type fakeResolver struct {
+	resolved [][]interface{} // the raw deps of each run
+	fail     bool            // fail (as if the network is unavailable)
+}
+
+func (r *fakeResolver) Command() (*exec.Cmd, error) { return nil, nil }
+func (r *fakeResolver) SetLogger(*log.Logger)       {}
+
+func (r *fakeResolver) Run(args []string, input, resp interface{}) error {
+	u := input.(*unit.SourceUnit)
+	r.resolved = append(r.resolved, u.Dependencies)
+	if r.fail {
+		return errors.New("network is unreachable")
+	}
+	var ress []*Resolution
+	for _, raw := range u.Dependencies {
+		if raw == "error" {
+			ress = append(ress, &Resolution{Raw: raw, Error: "failed"})
+		} else {
+			ress = append(ress, &Resolution{Raw: raw, Target: &ResolvedTarget{ToRepoCloneURL: raw.(string)}})
+		}
+	}
+	// Round-trip through JSON, like a real tool's output.
+	b, err := json.Marshal(ress)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, resp)
+}
+
+func TestResolveCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-dep-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Cache{Dir: dir}
+
+	resolve := func(resolver string, deps ...interface{}) (*fakeResolver, []*Resolution) {
+		tool := &fakeResolver{}
+		ress, err := ResolveCached(c, tool, resolver, &unit.SourceUnit{Name: "u", Type: "t", Dependencies: deps})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tool, ress
+	}
+	cloneURLs := func(ress []*Resolution) map[string]bool {
+		m := map[string]bool{}
+		for _, res := range ress {
+			if res.Target != nil {
+				m[res.Target.ToRepoCloneURL] = true
+			}
+		}
+		return m
+	}
+
+	tool, ress := resolve("r1", "a", "b", "error")
+	if want := [][]interface{}{{"a", "b", "error"}}; !reflect.DeepEqual(tool.resolved, want) {
+		t.Errorf("got resolved %v, want %v", tool.resolved, want)
+	}
+	if len(ress) != 3 {
+		t.Errorf("got %d resolutions, want 3", len(ress))
+	}
+
+	// Only uncached deps are resolved by the tool, and errors aren't
+	// cached.
+	tool, ress = resolve("r1", "a", "c", "error")
+	if want := [][]interface{}{{"c", "error"}}; !reflect.DeepEqual(tool.resolved, want) {
+		t.Errorf("got resolved %v, want %v", tool.resolved, want)
+	}
+	if want := map[string]bool{"a": true, "c": true}; !reflect.DeepEqual(cloneURLs(ress), want) {
+		t.Errorf("got clone URLs %v, want %v", cloneURLs(ress), want)
+	}
+
+	// The tool isn't run if all deps are cached.
+	tool, ress = resolve("r1", "b", "c")
+	if len(tool.resolved) != 0 {
+		t.Errorf("got resolved %v, want none", tool.resolved)
+	}
+	if want := map[string]bool{"b": true, "c": true}; !reflect.DeepEqual(cloneURLs(ress), want) {
+		t.Errorf("got clone URLs %v, want %v", cloneURLs(ress), want)
+	}
+
+	// Resolutions are cached per resolver.
+	tool, _ = resolve("r2", "a")
+	if want := [][]interface{}{{"a"}}; !reflect.DeepEqual(tool.resolved, want) {
+		t.Errorf("got resolved %v, want %v", tool.resolved, want)
+	}
+}
+
+func TestResolveCached_offline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-dep-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Cache{Dir: dir}
+
+	u := &unit.SourceUnit{Name: "u", Type: "t", Dependencies: []interface{}{"a"}}
+	if _, err := ResolveCached(c, &fakeResolver{}, "r", u); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offline, cached deps are resolved, and uncached deps get errors
+	// when the tool fails.
+	c.Offline = true
+	u.Dependencies = []interface{}{"a", "b"}
+	ress, err := ResolveCached(c, &fakeResolver{fail: true}, "r", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ress) != 2 {
+		t.Fatalf("got %d resolutions, want 2", len(ress))
+	}
+	if ress[0].Target == nil || ress[0].Target.ToRepoCloneURL != "a" {
+		t.Errorf("got resolution %+v for a, want the cached resolution", ress[0])
+	}
+	if ress[1].Raw != "b" || ress[1].Error == "" {
+		t.Errorf("got resolution %+v for b, want an error", ress[1])
+	}
+
+	// Online, tool failures are errors.
+	c.Offline = false
+	if _, err := ResolveCached(c, &fakeResolver{fail: true}, "r", u); err == nil {
+		t.Error("got no error when the tool failed online")
+	}
+}
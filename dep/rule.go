@@ -54,8 +54,13 @@ func (r *ResolveDepsRule) Prereqs() []string {
 }
 
 func (r *ResolveDepsRule) Recipes() []string {
+	if r.opt.DepresolveCache {
+		return []string{
+			fmt.Sprintf("src internal resolve-deps %s %q %q < $^ 1> $@", r.opt.ToolArgs(), r.Tool.Toolchain, r.Tool.Subcmd),
+		}
+	}
 	return []string{
-		fmt.Sprintf("src tool %s %q %q < $^ 1> $@", r.opt.ToolchainExecOpt, r.Tool.Toolchain, r.Tool.Subcmd),
+		fmt.Sprintf("src tool %s %q %q < $^ 1> $@", r.opt.ToolArgs(), r.Tool.Toolchain, r.Tool.Subcmd),
 	}
 }
 
@@ -0,0 +1,35 @@
+package graph
+
+import "strconv"
+
+// CallerDefKey returns the key of the calling def.
+func (c *Call) CallerDefKey() DefKey {
+	return DefKey{
+		Repo:     c.Repo,
+		CommitID: c.CommitID,
+		UnitType: c.UnitType,
+		Unit:     c.Unit,
+		Path:     c.CallerPath,
+	}
+}
+
+// CalleeDefKey returns the key of the called def.
+func (c *Call) CalleeDefKey() RefDefKey {
+	return RefDefKey{
+		DefRepo:     c.CalleeRepo,
+		DefUnitType: c.CalleeUnitType,
+		DefUnit:     c.CalleeUnit,
+		DefPath:     c.CalleePath,
+	}
+}
+
+// Sorting
+
+type Calls []*Call
+
+func (c *Call) sortKey() string {
+	return c.Repo + c.UnitType + c.Unit + c.CallerPath + c.CalleeRepo + c.CalleeUnitType + c.CalleeUnit + c.CalleePath + c.File + strconv.Itoa(int(c.Start)) + strconv.Itoa(int(c.End))
+}
+func (vs Calls) Len() int           { return len(vs) }
+func (vs Calls) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
+func (vs Calls) Less(i, j int) bool { return vs[i].sortKey() < vs[j].sortKey() }
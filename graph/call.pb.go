@@ -0,0 +1,482 @@
+// Code generated by protoc-gen-gogo.
+// source: call.proto
+// DO NOT EDIT!
+
+package graph
+
+import proto "github.com/gogo/protobuf/proto"
+import math "math"
+
+// discarding unused import gogoproto "github.com/gogo/protobuf/gogoproto/gogo.pb"
+
+import io "io"
+import fmt "fmt"
+import github_com_gogo_protobuf_proto "github.com/gogo/protobuf/proto"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// START Call OMIT
+// Call represents a call edge from a def (the caller) to another def
+// (the callee). Toolchains that can determine call edges emit them in
+// the Calls field of their graph output.
+type Call struct {
+	// CallerPath is the path of the calling def. The calling def is
+	// always in the same source unit as the call.
+	CallerPath string `protobuf:"bytes,1,opt,name=caller_path" json:"CallerPath"`
+	// CalleeRepo is the repository URI of the called def.
+	CalleeRepo string `protobuf:"bytes,2,opt,name=callee_repo" json:"CalleeRepo,omitempty"`
+	// CalleeUnitType is the source unit type of the called def.
+	CalleeUnitType string `protobuf:"bytes,3,opt,name=callee_unit_type" json:"CalleeUnitType,omitempty"`
+	// CalleeUnit is the name of the source unit of the called def.
+	CalleeUnit string `protobuf:"bytes,4,opt,name=callee_unit" json:"CalleeUnit,omitempty"`
+	// CalleePath is the path of the called def.
+	CalleePath string `protobuf:"bytes,5,opt,name=callee_path" json:"CalleePath"`
+	// Repo is the VCS repository in which this call exists.
+	Repo string `protobuf:"bytes,6,opt,name=repo" json:"Repo,omitempty"`
+	// CommitID is the ID of the VCS commit that this call exists in.
+	CommitID string `protobuf:"bytes,7,opt,name=commit_id" json:"CommitID,omitempty"`
+	// UnitType is the type name of the source unit that this call
+	// exists in.
+	UnitType string `protobuf:"bytes,8,opt,name=unit_type" json:"UnitType,omitempty"`
+	// Unit is the name of the source unit that this call exists in.
+	Unit string `protobuf:"bytes,9,opt,name=unit" json:"Unit,omitempty"`
+	// File is the filename in which the call site exists.
+	File string `protobuf:"bytes,10,opt,name=file" json:"File,omitempty"`
+	// Start is the byte offset of the call site's first byte in File.
+	Start uint32 `protobuf:"varint,11,opt,name=start" json:"Start"`
+	// End is the byte offset of the call site's last byte in File.
+	End uint32 `protobuf:"varint,12,opt,name=end" json:"End"`
+}
+// END Call OMIT
+
+func (m *Call) Reset()         { *m = Call{} }
+func (m *Call) String() string { return proto.CompactTextString(m) }
+func (*Call) ProtoMessage()    {}
+
+func init() {
+}
+func (m *Call) Unmarshal(data []byte) error {
+	l := len(data)
+	index := 0
+	for index < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if index >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[index]
+			index++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CallerPath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CallerPath = string(data[index:postIndex])
+			index = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CalleeRepo", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CalleeRepo = string(data[index:postIndex])
+			index = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CalleeUnitType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CalleeUnitType = string(data[index:postIndex])
+			index = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CalleeUnit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CalleeUnit = string(data[index:postIndex])
+			index = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CalleePath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CalleePath = string(data[index:postIndex])
+			index = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Repo", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Repo = string(data[index:postIndex])
+			index = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CommitID", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CommitID = string(data[index:postIndex])
+			index = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field UnitType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.UnitType = string(data[index:postIndex])
+			index = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(data[index:postIndex])
+			index = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field File", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.File = string(data[index:postIndex])
+			index = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Start", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.Start |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field End", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.End |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			var sizeOfWire int
+			for {
+				sizeOfWire++
+				wire >>= 7
+				if wire == 0 {
+					break
+				}
+			}
+			index -= sizeOfWire
+			skippy, err := github_com_gogo_protobuf_proto.Skip(data[index:])
+			if err != nil {
+				return err
+			}
+			if (index + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			index += skippy
+		}
+	}
+	return nil
+}
+func (m *Call) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.CallerPath)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.CalleeRepo)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.CalleeUnitType)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.CalleeUnit)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.CalleePath)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.Repo)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.CommitID)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.UnitType)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.Unit)
+	n += 1 + l + sovCall(uint64(l))
+	l = len(m.File)
+	n += 1 + l + sovCall(uint64(l))
+	n += 1 + sovCall(uint64(m.Start))
+	n += 1 + sovCall(uint64(m.End))
+	return n
+}
+
+func sovCall(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozCall(x uint64) (n int) {
+	return sovCall(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *Call) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *Call) MarshalTo(data []byte) (n int, err error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0xa
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.CallerPath)))
+	i += copy(data[i:], m.CallerPath)
+	data[i] = 0x12
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.CalleeRepo)))
+	i += copy(data[i:], m.CalleeRepo)
+	data[i] = 0x1a
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.CalleeUnitType)))
+	i += copy(data[i:], m.CalleeUnitType)
+	data[i] = 0x22
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.CalleeUnit)))
+	i += copy(data[i:], m.CalleeUnit)
+	data[i] = 0x2a
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.CalleePath)))
+	i += copy(data[i:], m.CalleePath)
+	data[i] = 0x32
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.Repo)))
+	i += copy(data[i:], m.Repo)
+	data[i] = 0x3a
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.CommitID)))
+	i += copy(data[i:], m.CommitID)
+	data[i] = 0x42
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.UnitType)))
+	i += copy(data[i:], m.UnitType)
+	data[i] = 0x4a
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.Unit)))
+	i += copy(data[i:], m.Unit)
+	data[i] = 0x52
+	i++
+	i = encodeVarintCall(data, i, uint64(len(m.File)))
+	i += copy(data[i:], m.File)
+	data[i] = 0x58
+	i++
+	i = encodeVarintCall(data, i, uint64(m.Start))
+	data[i] = 0x60
+	i++
+	i = encodeVarintCall(data, i, uint64(m.End))
+	return i, nil
+}
+
+func encodeFixed64Call(data []byte, offset int, v uint64) int {
+	data[offset] = uint8(v)
+	data[offset+1] = uint8(v >> 8)
+	data[offset+2] = uint8(v >> 16)
+	data[offset+3] = uint8(v >> 24)
+	data[offset+4] = uint8(v >> 32)
+	data[offset+5] = uint8(v >> 40)
+	data[offset+6] = uint8(v >> 48)
+	data[offset+7] = uint8(v >> 56)
+	return offset + 8
+}
+func encodeFixed32Call(data []byte, offset int, v uint32) int {
+	data[offset] = uint8(v)
+	data[offset+1] = uint8(v >> 8)
+	data[offset+2] = uint8(v >> 16)
+	data[offset+3] = uint8(v >> 24)
+	return offset + 4
+}
+func encodeVarintCall(data []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		data[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	data[offset] = uint8(v)
+	return offset + 1
+}
@@ -108,6 +108,10 @@ type Def struct {
 	// Test is whether this def is defined in test code (as opposed to main
 	// code). For example, definitions in Go *_test.go files have Test = true.
 	Test bool `protobuf:"varint,9,opt,name=test" json:"Test,omitempty"`
+	// Generated is whether this def is defined in generated code (such
+	// as protobuf or RPC stubs). It is set on import (see
+	// store.TagGenerated), not by graphers.
+	Generated bool `protobuf:"varint,18,opt,name=generated" json:"Generated,omitempty"`
 	// Data contains additional language- and toolchain-specific information
 	// about the def. Data is used to construct function signatures,
 	// import/require statements, language-specific type descriptions, etc.
@@ -496,6 +500,23 @@ func (m *Def) Unmarshal(data []byte) error {
 				}
 			}
 			m.Test = bool(v != 0)
+		case 18:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Generated", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Generated = bool(v != 0)
 		case 10:
 			if wireType != 2 {
 				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
@@ -702,6 +723,7 @@ func (m *Def) Size() (n int) {
 	n += 2
 	n += 2
 	n += 2
+	n += 3
 	if m.Data != nil {
 		l = len(m.Data)
 		n += 1 + l + sovDef(uint64(l))
@@ -843,6 +865,16 @@ func (m *Def) MarshalTo(data []byte) (n int, err error) {
 		data[i] = 0
 	}
 	i++
+	data[i] = 0x90
+	i++
+	data[i] = 0x1
+	i++
+	if m.Generated {
+		data[i] = 1
+	} else {
+		data[i] = 0
+	}
+	i++
 	if m.Data != nil {
 		data[i] = 0x52
 		i++
@@ -949,6 +981,7 @@ func (this *Def) GoString() string {
 		`Exported:` + fmt.Sprintf("%#v", this.Exported),
 		`Local:` + fmt.Sprintf("%#v", this.Local),
 		`Test:` + fmt.Sprintf("%#v", this.Test),
+		`Generated:` + fmt.Sprintf("%#v", this.Generated),
 		`Data:` + fmt.Sprintf("%#v", this.Data),
 		`Docs:` + strings.Replace(fmt.Sprintf("%#v", this.Docs), `&`, ``, 1),
 		`TreePath:` + fmt.Sprintf("%#v", this.TreePath) + `}`}, ", ")
@@ -26,6 +26,8 @@ type Output struct {
 	Refs []*Ref                                        `protobuf:"bytes,2,rep,name=refs" json:"Refs,omitempty"`
 	Docs []*Doc                                        `protobuf:"bytes,3,rep,name=docs" json:"Docs,omitempty"`
 	Anns []*ann.Ann `protobuf:"bytes,4,rep,name=anns,customtype=sourcegraph.com/sourcegraph/srclib/ann.Ann" json:"Anns,omitempty"`
+	Calls []*Call                                      `protobuf:"bytes,5,rep,name=calls" json:"Calls,omitempty"`
+	Relations []*Relation                              `protobuf:"bytes,6,rep,name=relations" json:"Relations,omitempty"`
 }
 // END Output OMIT
 
@@ -146,6 +148,52 @@ func (m *Output) Unmarshal(data []byte) error {
 			m.Anns = append(m.Anns, &ann.Ann{})
 			m.Anns[len(m.Anns)-1].Unmarshal(data[index:postIndex])
 			index = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Calls", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Calls = append(m.Calls, &Call{})
+			m.Calls[len(m.Calls)-1].Unmarshal(data[index:postIndex])
+			index = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Relations", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Relations = append(m.Relations, &Relation{})
+			m.Relations[len(m.Relations)-1].Unmarshal(data[index:postIndex])
+			index = postIndex
 		default:
 			var sizeOfWire int
 			for {
@@ -195,6 +243,18 @@ func (m *Output) Size() (n int) {
 			n += 1 + l + sovOutput(uint64(l))
 		}
 	}
+	if len(m.Calls) > 0 {
+		for _, e := range m.Calls {
+			l = e.Size()
+			n += 1 + l + sovOutput(uint64(l))
+		}
+	}
+	if len(m.Relations) > 0 {
+		for _, e := range m.Relations {
+			l = e.Size()
+			n += 1 + l + sovOutput(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -274,6 +334,30 @@ func (m *Output) MarshalTo(data []byte) (n int, err error) {
 			i += n
 		}
 	}
+	if len(m.Calls) > 0 {
+		for _, msg := range m.Calls {
+			data[i] = 0x2a
+			i++
+			i = encodeVarintOutput(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Relations) > 0 {
+		for _, msg := range m.Relations {
+			data[i] = 0x32
+			i++
+			i = encodeVarintOutput(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
 	return i, nil
 }
 
@@ -47,6 +47,10 @@ type Ref struct {
 	Unit string `protobuf:"bytes,9,opt,name=unit" json:"Unit,omitempty"`
 	// Def is true if this Ref spans the name of the Def it points to.
 	Def bool `protobuf:"varint,17,opt,name=def" json:"Def,omitempty"`
+	// Generated is true if this Ref is in generated code (such as
+	// protobuf or RPC stubs). It is set on import (see
+	// store.TagGenerated), not by graphers.
+	Generated bool `protobuf:"varint,18,opt,name=generated" json:"Generated,omitempty"`
 	// File is the filename in which this Ref exists.
 	File string `protobuf:"bytes,10,opt,name=file" json:"File,omitempty"`
 	// Start is the byte offset of this ref's first byte in File.
@@ -285,6 +289,23 @@ func (m *Ref) Unmarshal(data []byte) error {
 				}
 			}
 			m.Def = bool(v != 0)
+		case 18:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Generated", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Generated = bool(v != 0)
 		case 10:
 			if wireType != 2 {
 				return fmt.Errorf("proto: wrong wireType = %d for field File", wireType)
@@ -508,6 +529,7 @@ func (m *Ref) Size() (n int) {
 	l = len(m.Unit)
 	n += 1 + l + sovRef(uint64(l))
 	n += 3
+	n += 3
 	l = len(m.File)
 	n += 1 + l + sovRef(uint64(l))
 	n += 1 + sovRef(uint64(m.Start))
@@ -599,6 +621,16 @@ func (m *Ref) MarshalTo(data []byte) (n int, err error) {
 		data[i] = 0
 	}
 	i++
+	data[i] = 0x90
+	i++
+	data[i] = 0x1
+	i++
+	if m.Generated {
+		data[i] = 1
+	} else {
+		data[i] = 0
+	}
+	i++
 	data[i] = 0x52
 	i++
 	i = encodeVarintRef(data, i, uint64(len(m.File)))
@@ -687,6 +719,7 @@ func (this *Ref) GoString() string {
 		`UnitType:` + fmt.Sprintf("%#v", this.UnitType),
 		`Unit:` + fmt.Sprintf("%#v", this.Unit),
 		`Def:` + fmt.Sprintf("%#v", this.Def),
+		`Generated:` + fmt.Sprintf("%#v", this.Generated),
 		`File:` + fmt.Sprintf("%#v", this.File),
 		`Start:` + fmt.Sprintf("%#v", this.Start),
 		`End:` + fmt.Sprintf("%#v", this.End) + `}`}, ", ")
@@ -0,0 +1,46 @@
+package graph
+
+// Relation kinds emitted by toolchains. Toolchains may emit other
+// kinds, but they should use these for the common cases so that
+// relations can be queried uniformly across languages.
+const (
+	// RelationImplements is the kind of a relation from a type to an
+	// interface that it implements.
+	RelationImplements = "implements"
+
+	// RelationExtends is the kind of a relation from a type (or
+	// interface) to a type (or interface) that it extends or embeds.
+	RelationExtends = "extends"
+)
+
+// DefKey returns the key of the subtype def.
+func (r *Relation) DefKey() DefKey {
+	return DefKey{
+		Repo:     r.Repo,
+		CommitID: r.CommitID,
+		UnitType: r.UnitType,
+		Unit:     r.Unit,
+		Path:     r.DefPath,
+	}
+}
+
+// TargetDefKey returns the key of the supertype def.
+func (r *Relation) TargetDefKey() RefDefKey {
+	return RefDefKey{
+		DefRepo:     r.TargetRepo,
+		DefUnitType: r.TargetUnitType,
+		DefUnit:     r.TargetUnit,
+		DefPath:     r.TargetPath,
+	}
+}
+
+// Sorting
+
+type Relations []*Relation
+
+func (r *Relation) sortKey() string {
+	return r.Repo + r.UnitType + r.Unit + r.DefPath + r.Kind + r.TargetRepo + r.TargetUnitType + r.TargetUnit + r.TargetPath
+}
+func (vs Relations) Len() int           { return len(vs) }
+func (vs Relations) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
+func (vs Relations) Less(i, j int) bool { return vs[i].sortKey() < vs[j].sortKey() }
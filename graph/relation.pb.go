@@ -0,0 +1,442 @@
+// Code generated by protoc-gen-gogo.
+// source: relation.proto
+// DO NOT EDIT!
+
+package graph
+
+import proto "github.com/gogo/protobuf/proto"
+import math "math"
+
+// discarding unused import gogoproto "github.com/gogo/protobuf/gogoproto/gogo.pb"
+
+import io "io"
+import fmt "fmt"
+import github_com_gogo_protobuf_proto "github.com/gogo/protobuf/proto"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// START Relation OMIT
+// Relation represents a type-hierarchy edge from a def (the subtype) to
+// another def (the supertype), such as a class that extends another
+// class or a type that implements an interface. Toolchains that can
+// determine such edges emit them in the Relations field of their graph
+// output.
+type Relation struct {
+	// Kind is the kind of relation (e.g., "implements" or "extends").
+	Kind string `protobuf:"bytes,1,opt,name=kind" json:"Kind"`
+	// DefPath is the path of the subtype def. The subtype def is always
+	// in the same source unit as the relation.
+	DefPath string `protobuf:"bytes,2,opt,name=def_path" json:"DefPath"`
+	// TargetRepo is the repository URI of the supertype def.
+	TargetRepo string `protobuf:"bytes,3,opt,name=target_repo" json:"TargetRepo,omitempty"`
+	// TargetUnitType is the source unit type of the supertype def.
+	TargetUnitType string `protobuf:"bytes,4,opt,name=target_unit_type" json:"TargetUnitType,omitempty"`
+	// TargetUnit is the name of the source unit of the supertype def.
+	TargetUnit string `protobuf:"bytes,5,opt,name=target_unit" json:"TargetUnit,omitempty"`
+	// TargetPath is the path of the supertype def.
+	TargetPath string `protobuf:"bytes,6,opt,name=target_path" json:"TargetPath"`
+	// Repo is the VCS repository in which this relation exists.
+	Repo string `protobuf:"bytes,7,opt,name=repo" json:"Repo,omitempty"`
+	// CommitID is the ID of the VCS commit that this relation exists in.
+	CommitID string `protobuf:"bytes,8,opt,name=commit_id" json:"CommitID,omitempty"`
+	// UnitType is the type name of the source unit that this relation
+	// exists in.
+	UnitType string `protobuf:"bytes,9,opt,name=unit_type" json:"UnitType,omitempty"`
+	// Unit is the name of the source unit that this relation exists in.
+	Unit string `protobuf:"bytes,10,opt,name=unit" json:"Unit,omitempty"`
+}
+// END Relation OMIT
+
+func (m *Relation) Reset()         { *m = Relation{} }
+func (m *Relation) String() string { return proto.CompactTextString(m) }
+func (*Relation) ProtoMessage()    {}
+
+func init() {
+}
+func (m *Relation) Unmarshal(data []byte) error {
+	l := len(data)
+	index := 0
+	for index < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if index >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[index]
+			index++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Kind", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Kind = string(data[index:postIndex])
+			index = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DefPath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.DefPath = string(data[index:postIndex])
+			index = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TargetRepo", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TargetRepo = string(data[index:postIndex])
+			index = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TargetUnitType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TargetUnitType = string(data[index:postIndex])
+			index = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TargetUnit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TargetUnit = string(data[index:postIndex])
+			index = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TargetPath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TargetPath = string(data[index:postIndex])
+			index = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Repo", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Repo = string(data[index:postIndex])
+			index = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CommitID", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CommitID = string(data[index:postIndex])
+			index = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field UnitType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.UnitType = string(data[index:postIndex])
+			index = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + int(stringLen)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(data[index:postIndex])
+			index = postIndex
+		default:
+			var sizeOfWire int
+			for {
+				sizeOfWire++
+				wire >>= 7
+				if wire == 0 {
+					break
+				}
+			}
+			index -= sizeOfWire
+			skippy, err := github_com_gogo_protobuf_proto.Skip(data[index:])
+			if err != nil {
+				return err
+			}
+			if (index + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			index += skippy
+		}
+	}
+	return nil
+}
+func (m *Relation) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Kind)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.DefPath)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.TargetRepo)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.TargetUnitType)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.TargetUnit)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.TargetPath)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.Repo)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.CommitID)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.UnitType)
+	n += 1 + l + sovRelation(uint64(l))
+	l = len(m.Unit)
+	n += 1 + l + sovRelation(uint64(l))
+	return n
+}
+
+func sovRelation(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozRelation(x uint64) (n int) {
+	return sovRelation(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *Relation) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *Relation) MarshalTo(data []byte) (n int, err error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0xa
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.Kind)))
+	i += copy(data[i:], m.Kind)
+	data[i] = 0x12
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.DefPath)))
+	i += copy(data[i:], m.DefPath)
+	data[i] = 0x1a
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.TargetRepo)))
+	i += copy(data[i:], m.TargetRepo)
+	data[i] = 0x22
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.TargetUnitType)))
+	i += copy(data[i:], m.TargetUnitType)
+	data[i] = 0x2a
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.TargetUnit)))
+	i += copy(data[i:], m.TargetUnit)
+	data[i] = 0x32
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.TargetPath)))
+	i += copy(data[i:], m.TargetPath)
+	data[i] = 0x3a
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.Repo)))
+	i += copy(data[i:], m.Repo)
+	data[i] = 0x42
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.CommitID)))
+	i += copy(data[i:], m.CommitID)
+	data[i] = 0x4a
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.UnitType)))
+	i += copy(data[i:], m.UnitType)
+	data[i] = 0x52
+	i++
+	i = encodeVarintRelation(data, i, uint64(len(m.Unit)))
+	i += copy(data[i:], m.Unit)
+	return i, nil
+}
+
+func encodeFixed64Relation(data []byte, offset int, v uint64) int {
+	data[offset] = uint8(v)
+	data[offset+1] = uint8(v >> 8)
+	data[offset+2] = uint8(v >> 16)
+	data[offset+3] = uint8(v >> 24)
+	data[offset+4] = uint8(v >> 32)
+	data[offset+5] = uint8(v >> 40)
+	data[offset+6] = uint8(v >> 48)
+	data[offset+7] = uint8(v >> 56)
+	return offset + 8
+}
+func encodeFixed32Relation(data []byte, offset int, v uint32) int {
+	data[offset] = uint8(v)
+	data[offset+1] = uint8(v >> 8)
+	data[offset+2] = uint8(v >> 16)
+	data[offset+3] = uint8(v >> 24)
+	return offset + 4
+}
+func encodeVarintRelation(data []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		data[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	data[offset] = uint8(v)
+	return offset + 1
+}
@@ -0,0 +1,92 @@
+package grapher
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A DefsManifest lists the exported defs of the source units that a
+// source unit depends on, so that its grapher can resolve refs to them
+// precisely (see toolchain.ToolInfo.DefsManifest). It includes the
+// source units in the source unit's GraphDeps (from the current
+// build) and the source units in other repositories that its
+// dependencies resolve to (from the store, if they have been
+// imported).
+type DefsManifest struct {
+	Units []*DefsManifestUnit
+}
+
+// A DefsManifestUnit lists the exported defs of a source unit.
+type DefsManifestUnit struct {
+	// Repo and CommitID are the repository and commit of the source
+	// unit. They are empty for source units in the same tree as the
+	// source unit being graphed.
+	Repo     string `json:",omitempty"`
+	CommitID string `json:",omitempty"`
+
+	UnitType string
+	Unit     string
+
+	Defs []*DefsManifestDef
+}
+
+// A DefsManifestDef is an exported def in a DefsManifestUnit.
+type DefsManifestDef struct {
+	Path string
+	Name string
+	Kind string `json:",omitempty"`
+	File string `json:",omitempty"`
+}
+
+// NewDefsManifestUnit returns a DefsManifestUnit that lists the
+// exported (non-local) defs in defs.
+func NewDefsManifestUnit(repo, commitID, unitType, unitName string, defs []*graph.Def) *DefsManifestUnit {
+	mu := &DefsManifestUnit{Repo: repo, CommitID: commitID, UnitType: unitType, Unit: unitName, Defs: []*DefsManifestDef{}}
+	for _, def := range defs {
+		if !def.Exported || def.Local {
+			continue
+		}
+		mu.Defs = append(mu.Defs, &DefsManifestDef{Path: def.Path, Name: def.Name, Kind: def.Kind, File: def.File})
+	}
+	return mu
+}
+
+// DefsManifestRule creates the DefsManifest for a source unit that is
+// passed to its grapher.
+type DefsManifestRule struct {
+	dataDir string
+	Unit    *unit.SourceUnit
+
+	// Deps are the source units in the unit's GraphDeps, whose
+	// graph output is read from the current build.
+	Deps []*unit.SourceUnit
+
+	// DepresolveTarget is the unit's dependency resolution output,
+	// which lists the source units in other repositories whose defs
+	// are read from the store.
+	DepresolveTarget string
+}
+
+func (r *DefsManifestRule) Target() string {
+	return filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&DefsManifest{}, r.Unit))
+}
+
+func (r *DefsManifestRule) Prereqs() []string {
+	ps := []string{filepath.Join(r.dataDir, plan.SourceUnitDataFilename(unit.SourceUnit{}, r.Unit)), r.DepresolveTarget}
+	for _, dep := range r.Deps {
+		ps = append(ps, filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&graph.Output{}, dep)))
+	}
+	return ps
+}
+
+func (r *DefsManifestRule) Recipes() []string {
+	return []string{
+		fmt.Sprintf("src internal defs-manifest --data-dir %q < $< 1> $@", r.dataDir),
+	}
+}
+
+func (r *DefsManifestRule) SourceUnit() *unit.SourceUnit { return r.Unit }
@@ -0,0 +1,33 @@
+package grapher
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestNewDefsManifestUnit(t *testing.T) {
+	defs := []*graph.Def{
+		{DefKey: graph.DefKey{Path: "p/a"}, Name: "a", Kind: "func", File: "f", Exported: true},
+		{DefKey: graph.DefKey{Path: "p/b"}, Name: "b", Kind: "func", File: "f"},
+		{DefKey: graph.DefKey{Path: "p/a/c"}, Name: "c", Kind: "var", File: "f", Exported: true, Local: true},
+	}
+	mu := NewDefsManifestUnit("r", "c", "t", "u", defs)
+	want := &DefsManifestUnit{
+		Repo:     "r",
+		CommitID: "c",
+		UnitType: "t",
+		Unit:     "u",
+		Defs:     []*DefsManifestDef{{Path: "p/a", Name: "a", Kind: "func", File: "f"}},
+	}
+	if !reflect.DeepEqual(mu, want) {
+		t.Errorf("got %+v, want %+v", mu, want)
+	}
+
+	// Units without exported defs have an empty (not null) list of
+	// defs.
+	if mu := NewDefsManifestUnit("", "", "t", "u", defs[1:2]); mu.Defs == nil || len(mu.Defs) != 0 {
+		t.Errorf("got Defs %v, want empty", mu.Defs)
+	}
+}
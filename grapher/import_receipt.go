@@ -0,0 +1,14 @@
+package grapher
+
+// An ImportReceipt is the build data file that a graph rule writes
+// instead of the source unit's graph output when it imports the graph
+// output directly into a store (see GraphUnitRule.Import). It records
+// how much data was imported.
+type ImportReceipt struct {
+	// Repo and CommitID are the repository and commit that the data
+	// was imported as.
+	Repo     string `json:",omitempty"`
+	CommitID string
+
+	Defs, Refs, Docs, Anns int
+}
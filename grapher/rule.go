@@ -3,11 +3,13 @@ package grapher
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"sourcegraph.com/sourcegraph/makex"
 	"sourcegraph.com/sourcegraph/srclib"
 	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/plan"
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
@@ -19,10 +21,34 @@ const graphOp = "graph"
 func init() {
 	plan.RegisterRuleMaker(graphOp, makeGraphRules)
 	buildstore.RegisterDataType("graph", &graph.Output{})
+	buildstore.RegisterDataType("defs-manifest", &DefsManifest{})
+	buildstore.RegisterDataType("graph-import", &ImportReceipt{})
 }
 
 func makeGraphRules(c *config.Tree, dataDir string, existing []makex.Rule, opt plan.Options) ([]makex.Rule, error) {
 	const op = graphOp
+	deps, err := graphDeps(c.SourceUnits)
+	if err != nil {
+		return nil, err
+	}
+
+	// The graph output of source units that other source units depend
+	// on is read by other rules, so it is always written to the build
+	// data (even if the other units' graph output is imported directly
+	// into a store).
+	dependedOn := map[*unit.SourceUnit]bool{}
+	for _, ds := range deps {
+		for _, d := range ds {
+			dependedOn[d] = true
+		}
+	}
+	var depresolveTargets []string
+	if opt.ImportArgs != "" {
+		for _, u := range c.SourceUnits {
+			depresolveTargets = append(depresolveTargets, filepath.Join(dataDir, plan.SourceUnitDataFilename([]*dep.ResolvedDep{}, u)))
+		}
+	}
+
 	var rules []makex.Rule
 	for _, u := range c.SourceUnits {
 		toolRef := u.Ops[op]
@@ -34,32 +60,172 @@ func makeGraphRules(c *config.Tree, dataDir string, existing []makex.Rule, opt p
 			toolRef = choice
 		}
 
-		rules = append(rules, &GraphUnitRule{dataDir, u, toolRef, opt})
+		rule := &GraphUnitRule{dataDir: dataDir, Unit: u, Tool: toolRef, Deps: deps[u], opt: opt}
+		if opt.ImportArgs != "" && !dependedOn[u] {
+			rule.Import = true
+			rule.ImportPrereqs = depresolveTargets
+		}
+		if opt.DefsManifest && supportsDefsManifest(toolRef) {
+			manifestRule := &DefsManifestRule{
+				dataDir:          dataDir,
+				Unit:             u,
+				Deps:             deps[u],
+				DepresolveTarget: filepath.Join(dataDir, plan.SourceUnitDataFilename([]*dep.ResolvedDep{}, u)),
+			}
+			rule.DefsManifest = manifestRule.Target()
+			rules = append(rules, manifestRule)
+		}
+		rules = append(rules, rule)
 	}
 	return rules, nil
 }
 
+// supportsDefsManifest returns whether the grapher accepts a
+// DefsManifest (see toolchain.ToolInfo.DefsManifest).
+func supportsDefsManifest(tool *srclib.ToolRef) bool {
+	info, err := toolchain.LookupToolInfo(tool.Toolchain, tool.Subcmd)
+	return err == nil && info.DefsManifest
+}
+
+// graphDeps returns a map of each source unit in units to the source
+// units listed in its GraphDeps. GraphDeps on source units that aren't
+// in units (e.g., because they were skipped) are ignored. It returns
+// an error if the GraphDeps contain a cycle, because the source units
+// in the cycle can't be graphed in any order.
+func graphDeps(units []*unit.SourceUnit) (map[*unit.SourceUnit][]*unit.SourceUnit, error) {
+	byID := make(map[unit.ID2]*unit.SourceUnit, len(units))
+	for _, u := range units {
+		byID[u.ID2()] = u
+	}
+	deps := map[*unit.SourceUnit][]*unit.SourceUnit{}
+	for _, u := range units {
+		for _, id := range u.GraphDeps {
+			if dep := byID[id]; dep != nil && dep != u {
+				deps[u] = append(deps[u], dep)
+			}
+		}
+	}
+
+	// Check for cycles with a depth-first search.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[*unit.SourceUnit]int{}
+	var path []*unit.SourceUnit
+	var visit func(u *unit.SourceUnit) error
+	visit = func(u *unit.SourceUnit) error {
+		switch state[u] {
+		case visiting:
+			var cycle []string
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i].ID2().String()}, cycle...)
+				if path[i] == u {
+					break
+				}
+			}
+			return fmt.Errorf("source unit graph dependency cycle: %s -> %s", strings.Join(cycle, " -> "), u.ID2())
+		case visited:
+			return nil
+		}
+		state[u] = visiting
+		path = append(path, u)
+		for _, dep := range deps[u] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[u] = visited
+		return nil
+	}
+	for _, u := range units {
+		if err := visit(u); err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
 type GraphUnitRule struct {
 	dataDir string
 	Unit    *unit.SourceUnit
 	Tool    *srclib.ToolRef
-	opt     plan.Options
+
+	// Deps are the source units (in the same tree) whose graph output
+	// the grapher uses when graphing Unit (see
+	// unit.SourceUnit.GraphDeps).
+	Deps []*unit.SourceUnit
+
+	// DefsManifest is the DefsManifest file (created by a
+	// DefsManifestRule) that is passed to the grapher, if any.
+	DefsManifest string
+
+	// Import is whether the rule imports the graph output directly
+	// into a store (see plan.Options.ImportArgs) instead of writing it
+	// to the build data. If so, the rule's target is its
+	// ReceiptTarget.
+	Import bool
+
+	// ImportPrereqs are the other build data files that the import
+	// reads: the dependency resolution output of all of the tree's
+	// source units, which is used to attribute refs to vendored
+	// source units.
+	ImportPrereqs []string
+
+	opt plan.Options
 }
 
 func (r *GraphUnitRule) Target() string {
+	if r.Import {
+		return r.ReceiptTarget()
+	}
 	return filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&graph.Output{}, r.Unit))
 }
 
+// ReceiptTarget returns the ImportReceipt file that the rule writes if
+// it imports the graph output directly into a store.
+func (r *GraphUnitRule) ReceiptTarget() string {
+	return filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&ImportReceipt{}, r.Unit))
+}
+
 func (r *GraphUnitRule) Prereqs() []string {
 	ps := []string{filepath.Join(r.dataDir, plan.SourceUnitDataFilename(unit.SourceUnit{}, r.Unit))}
 	ps = append(ps, r.Unit.Files...)
+	ps = append(ps, r.depTargets()...)
+	if r.DefsManifest != "" {
+		ps = append(ps, r.DefsManifest)
+	}
+	ps = append(ps, r.ImportPrereqs...)
 	return ps
 }
 
+// depTargets returns the graph output files of r's Deps.
+func (r *GraphUnitRule) depTargets() []string {
+	targets := make([]string, len(r.Deps))
+	for i, dep := range r.Deps {
+		targets[i] = filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&graph.Output{}, dep))
+	}
+	return targets
+}
+
 func (r *GraphUnitRule) Recipes() []string {
-	return []string{
-		fmt.Sprintf("src tool %s %q %q < $< | src internal normalize-graph-data --unit-type %q --dir . 1> $@", r.opt.ToolchainExecOpt, r.Tool.Toolchain, r.Tool.Subcmd, r.Unit.Type),
+	// The DefsManifest and the graph output files of the Deps are
+	// passed to the grapher as args.
+	var depArgs string
+	if r.DefsManifest != "" {
+		depArgs += fmt.Sprintf(" %q", "--defs-manifest="+r.DefsManifest)
+	}
+	for _, target := range r.depTargets() {
+		depArgs += fmt.Sprintf(" %q", target)
+	}
+	recipe := fmt.Sprintf("src tool %s %q %q%s < $< | src internal normalize-graph-data --unit-type %q --dir .", r.opt.ToolArgs(), r.Tool.Toolchain, r.Tool.Subcmd, depArgs, r.Unit.Type)
+	if r.Import {
+		// Write the receipt to the target instead of the graph
+		// output.
+		recipe += fmt.Sprintf(" | src internal import-graph-data %s --data-dir %q --unit-type %q --unit %q --toolchain %q", r.opt.ImportArgs, r.dataDir, r.Unit.Type, r.Unit.Name, r.Tool.Toolchain)
 	}
+	return []string{recipe + " 1> $@"}
 }
 
 func (r *GraphUnitRule) SourceUnit() *unit.SourceUnit { return r.Unit }
@@ -0,0 +1,185 @@
+package plan
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/makex"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A RuleStatus is the predicted outcome of a rule when its Makefile is
+// executed.
+type RuleStatus string
+
+const (
+	// UpToDate means that the rule's target is up to date, so the
+	// rule won't be run.
+	UpToDate RuleStatus = "up-to-date"
+
+	// Copy means that the rule's target will be copied from the
+	// build data of a previous commit (a build cache hit).
+	Copy RuleStatus = "copy"
+
+	// Run means that the rule's tool will be run.
+	Run RuleStatus = "run"
+)
+
+// A RuleExplanation describes whether a rule will be run when its
+// Makefile is executed, and why.
+type RuleExplanation struct {
+	Target  string
+	Prereqs []string
+
+	// UnitType and Unit identify the rule's source unit, if any.
+	UnitType string `json:",omitempty"`
+	Unit     string `json:",omitempty"`
+
+	Status RuleStatus
+	Reason string
+
+	// CachedFrom is the file in a previous commit's build data that
+	// the target is copied from, if the rule is a cached rule.
+	CachedFrom string `json:",omitempty"`
+
+	Recipes []string
+}
+
+// Explain predicts which of the rules in mf will be run when it is
+// executed (in the current directory) and explains why, like a
+// detailed dry run. The rules are returned in the order they appear in
+// mf, omitting rules without recipes (such as "all"). The prev build
+// is the one that the cached rules in mf were created from (see
+// FindPrevBuild and CreateMakefile), or nil if there was none.
+//
+// Like make, a rule is run if its target doesn't exist, if any of its
+// prereqs are newer than its target, or if any of its prereqs will be
+// rebuilt.
+func Explain(mf *makex.Makefile, prev *PrevBuild, opt Options) []*RuleExplanation {
+	rules := make(map[string]makex.Rule, len(mf.Rules))
+	for _, rule := range mf.Rules {
+		rules[rule.Target()] = rule
+	}
+
+	explained := map[string]*RuleExplanation{}
+	var explain func(rule makex.Rule) *RuleExplanation
+	explain = func(rule makex.Rule) *RuleExplanation {
+		if x, present := explained[rule.Target()]; present {
+			return x
+		}
+		x := &RuleExplanation{
+			Target:  rule.Target(),
+			Prereqs: rule.Prereqs(),
+			Recipes: rule.Recipes(),
+		}
+		explained[rule.Target()] = x
+		if r, ok := rule.(interface {
+			SourceUnit() *unit.SourceUnit
+		}); ok {
+			u := r.SourceUnit()
+			x.UnitType, x.Unit = u.Type, u.Name
+		}
+		if r, ok := rule.(*cachedRule); ok {
+			x.CachedFrom = r.cachedPath
+		}
+
+		if stale := staleReason(rule, rules, explain); stale == "" {
+			x.Status, x.Reason = UpToDate, "target is newer than its prereqs"
+			return x
+		} else if x.CachedFrom != "" {
+			x.Status = Copy
+			x.Reason = fmt.Sprintf("%s, and the unit's files are unchanged since %s", stale, prev.CommitID)
+			if _, err := os.Stat(x.CachedFrom); err != nil {
+				x.Reason += fmt.Sprintf(" (but the cached file is missing: %s)", err)
+			}
+		} else {
+			x.Status = Run
+			x.Reason = stale + ", and " + uncachedReason(rule, prev, opt)
+		}
+		return x
+	}
+
+	var xs []*RuleExplanation
+	for _, rule := range mf.Rules {
+		if len(rule.Recipes()) == 0 {
+			continue
+		}
+		xs = append(xs, explain(rule))
+	}
+	return xs
+}
+
+// staleReason returns why rule's target is out of date, or "" if it is
+// up to date.
+func staleReason(rule makex.Rule, rules map[string]makex.Rule, explain func(makex.Rule) *RuleExplanation) string {
+	// Prereqs that are rebuilt make the target out of date, even if
+	// it exists.
+	for _, prereq := range rule.Prereqs() {
+		if r, present := rules[prereq]; present && len(r.Recipes()) > 0 {
+			if explain(r).Status != UpToDate {
+				return fmt.Sprintf("prereq %s will be rebuilt", prereq)
+			}
+		}
+	}
+
+	fi, err := os.Stat(rule.Target())
+	if os.IsNotExist(err) {
+		return "target doesn't exist"
+	} else if err != nil {
+		return fmt.Sprintf("target can't be read (%s)", err)
+	}
+	for _, prereq := range rule.Prereqs() {
+		pfi, err := os.Stat(prereq)
+		if os.IsNotExist(err) {
+			return fmt.Sprintf("prereq %s doesn't exist", prereq)
+		} else if err != nil {
+			return fmt.Sprintf("prereq %s can't be read (%s)", prereq, err)
+		}
+		if pfi.ModTime().After(fi.ModTime()) {
+			return fmt.Sprintf("prereq %s is newer than the target", prereq)
+		}
+	}
+	return ""
+}
+
+// uncachedReason returns why rule can't reuse build data from a
+// previous build.
+func uncachedReason(rule makex.Rule, prev *PrevBuild, opt Options) string {
+	if opt.NoCache {
+		return "the build cache is disabled"
+	}
+	r, ok := rule.(interface {
+		SourceUnit() *unit.SourceUnit
+	})
+	if !ok {
+		return "the rule's output isn't cached"
+	}
+	if prev == nil {
+		return "there is no previous build data to reuse"
+	}
+
+	files := map[string]bool{}
+	for _, f := range r.SourceUnit().Files {
+		files[f] = true
+	}
+	var changed []string
+	for _, f := range prev.ChangedFiles {
+		if files[f] {
+			changed = append(changed, f)
+		}
+	}
+	if len(changed) == 0 {
+		// The rule was rebuilt because of a rebuilt prereq (see
+		// rebuiltTargets).
+		return fmt.Sprintf("the source units it depends on changed since %s", prev.CommitID)
+	}
+	sort.Strings(changed)
+	const max = 3
+	s := strings.Join(changed, ", ")
+	if len(changed) > max {
+		s = fmt.Sprintf("%s, and %d more", strings.Join(changed[:max], ", "), len(changed)-max)
+	}
+	return fmt.Sprintf("the unit's files changed since %s (%s)", prev.CommitID, s)
+}
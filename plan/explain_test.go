@@ -0,0 +1,107 @@
+package plan_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestExplain(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "srclib-plan-explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	c := &config.Tree{
+		SourceUnits: []*unit.SourceUnit{
+			{
+				Name:  "n",
+				Type:  "t",
+				Files: []string{"f"},
+				Ops: map[string]*srclib.ToolRef{
+					"graph":      {Toolchain: "tc", Subcmd: "t"},
+					"depresolve": {Toolchain: "tc", Subcmd: "t"},
+				},
+			},
+		},
+	}
+	opt := plan.Options{NoCache: true}
+	mf, err := plan.CreateMakefile("data", nil, "", c, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(label string, want map[string]plan.RuleStatus, wantReason map[string]string) {
+		xs := plan.Explain(mf, nil, opt)
+		if len(xs) != len(want) {
+			t.Fatalf("%s: got %d explanations, want %d", label, len(xs), len(want))
+		}
+		for _, x := range xs {
+			if x.Unit != "n" || x.UnitType != "t" {
+				t.Errorf("%s: %s: got unit %q %q, want %q %q", label, x.Target, x.Unit, x.UnitType, "n", "t")
+			}
+			if x.Status != want[x.Target] {
+				t.Errorf("%s: %s: got status %q, want %q (reason: %s)", label, x.Target, x.Status, want[x.Target], x.Reason)
+			}
+			if !strings.HasPrefix(x.Reason, wantReason[x.Target]) {
+				t.Errorf("%s: %s: got reason %q, want it to start with %q", label, x.Target, x.Reason, wantReason[x.Target])
+			}
+		}
+	}
+
+	check("no targets", map[string]plan.RuleStatus{
+		"data/n/t.graph.json":      plan.Run,
+		"data/n/t.depresolve.json": plan.Run,
+	}, map[string]string{
+		"data/n/t.graph.json":      "target doesn't exist",
+		"data/n/t.depresolve.json": "target doesn't exist",
+	})
+
+	// Create the prereqs and then the targets, so that the targets
+	// are up to date.
+	old := time.Now().Add(-time.Hour)
+	for _, file := range []string{"f", "data/n/t.unit.json", "data/n/t.graph.json", "data/n/t.depresolve.json"} {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatal(err)
+		}
+		old = old.Add(time.Second)
+	}
+	check("up to date", map[string]plan.RuleStatus{
+		"data/n/t.graph.json":      plan.UpToDate,
+		"data/n/t.depresolve.json": plan.UpToDate,
+	}, nil)
+
+	// Changing a source file only makes the graph output out of date.
+	if err := ioutil.WriteFile("f", []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	check("changed file", map[string]plan.RuleStatus{
+		"data/n/t.graph.json":      plan.Run,
+		"data/n/t.depresolve.json": plan.UpToDate,
+	}, map[string]string{
+		"data/n/t.graph.json": "prereq f is newer than the target, and the build cache is disabled",
+	})
+}
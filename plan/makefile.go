@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"path/filepath"
 
 	"strings"
 
@@ -20,6 +21,42 @@ type Options struct {
 	// When NoCache is true, all files are rebuilt instead of only
 	// the ones associated with changed source units.
 	NoCache bool
+
+	// When DepresolveCache is true, dependencies are resolved using
+	// the depresolve cache shared across repositories (see
+	// dep.Cache), so that the same dependencies aren't resolved
+	// repeatedly.
+	DepresolveCache bool
+
+	// When RecordRuleStats is true, the tools run by rules record
+	// their resource usage (see buildstore.RuleStats) in the file
+	// named by the SRCLIB_RULE_STATS_FILE environment variable.
+	RecordRuleStats bool
+
+	// When DefsManifest is true, graphers that support it are given
+	// a manifest of the exported defs of the source units that the
+	// source unit being graphed depends on (see
+	// grapher.DefsManifest).
+	DefsManifest bool
+
+	// When ImportArgs is non-empty, graph rules import the graph
+	// output directly into a store, by piping it to 'src internal
+	// import-graph-data' with these args (which select the repo and
+	// commit), instead of writing it to the build data. The graph
+	// output of source units that other rules read (see
+	// unit.SourceUnit.GraphDeps) is still written to the build data.
+	ImportArgs string
+}
+
+// ToolArgs returns the args for the 'src tool' (or similar) commands
+// in rules' recipes: the ToolchainExecOpt, and the args that make the
+// command record the resource usage of the rule whose target is $@ if
+// RecordRuleStats is true.
+func (o Options) ToolArgs() string {
+	if o.RecordRuleStats {
+		return strings.TrimSpace(o.ToolchainExecOpt + " --stats-target $@")
+	}
+	return o.ToolchainExecOpt
 }
 
 type RuleMaker func(c *config.Tree, dataDir string, existing []makex.Rule, opt Options) ([]makex.Rule, error)
@@ -96,6 +133,126 @@ func filesChangedFromRevToIndex(vcsType, fromRev string) ([]string, error) {
 	return strings.Split(string(bytes.TrimSpace(out)), "\n"), err
 }
 
+// ChangedFiles returns the files that have changed between the since
+// commit and the working tree.
+func ChangedFiles(vcsType, since string) ([]string, error) {
+	files, err := filesChangedFromRevToIndex(vcsType, since)
+	if err != nil {
+		return nil, fmt.Errorf("listing files changed since %s: %s (%s)", since, err, strings.TrimSpace(strings.Join(files, "\n")))
+	}
+	if len(files) == 1 && files[0] == "" {
+		return nil, nil
+	}
+	return files, nil
+}
+
+// AffectedUnits returns the source units in units that contain any of
+// the changedFiles (see ChangedFiles), and the source units that
+// (directly or indirectly) list them in their GraphDeps. Only these
+// units need to be rebuilt; the build data of the others is unaffected
+// by the changes.
+func AffectedUnits(units []*unit.SourceUnit, changedFiles []string) []*unit.SourceUnit {
+	affected := map[unit.ID2]bool{}
+	for _, u := range units {
+		if u.ContainsAny(changedFiles) {
+			affected[u.ID2()] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, u := range units {
+			if affected[u.ID2()] {
+				continue
+			}
+			for _, dep := range u.GraphDeps {
+				if affected[dep] {
+					affected[u.ID2()] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var affectedUnits []*unit.SourceUnit
+	for _, u := range units {
+		if affected[u.ID2()] {
+			affectedUnits = append(affectedUnits, u)
+		}
+	}
+	return affectedUnits
+}
+
+// rebuiltTargets returns the targets of the rules that can't reuse the
+// build data of a previous build, given the files that changed since
+// it: rules without a source unit, rules whose source unit contains a
+// changed file, and rules with a prereq that is rebuilt (such as the
+// graph output of a unit in another unit's GraphDeps).
+func rebuiltTargets(rules []makex.Rule, changedFiles []string) map[string]bool {
+	rebuilt := map[string]bool{}
+	for _, rule := range rules {
+		r, ok := rule.(interface {
+			SourceUnit() *unit.SourceUnit
+		})
+		if !ok || r.SourceUnit().ContainsAny(changedFiles) {
+			rebuilt[rule.Target()] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, rule := range rules {
+			if rebuilt[rule.Target()] {
+				continue
+			}
+			for _, prereq := range rule.Prereqs() {
+				if rebuilt[prereq] {
+					rebuilt[rule.Target()] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return rebuilt
+}
+
+// A PrevBuild is a previous commit whose build data is reused (by
+// copying it) for the source units that haven't changed since it.
+type PrevBuild struct {
+	// CommitID is the previous commit.
+	CommitID string
+
+	// ChangedFiles are the files that have changed between CommitID
+	// and the current index.
+	ChangedFiles []string
+}
+
+// FindPrevBuild finds the previous build whose build data can be
+// reused in the current build. It returns nil if there is none.
+func FindPrevBuild(buildStore buildstore.RepoBuildStore, vcsType string) *PrevBuild {
+	var prev *PrevBuild
+	if revs, err := listLatestCommitIDs(vcsType); err != nil {
+		log.Printf("Warning: could not list revisions, rebuilding from scratch: %s, %s", revs, err)
+	} else {
+		// Skip HEAD, the first revision in the list.
+		for i := 1; i < len(revs); i++ {
+			if exist, _ := buildstore.BuildDataExistsForCommit(buildStore, revs[i]); !exist {
+				continue
+			}
+			// A build store exists for this commit. Now we need
+			// to get all the changed files between this rev and
+			// the current rev.
+			files, err := filesChangedFromRevToIndex(vcsType, revs[i])
+			if err != nil {
+				log.Printf("Warning: could not retrieve changed files, rebuilding from scratch: %s %s", files, err)
+				break
+			}
+			prev = &PrevBuild{CommitID: revs[i], ChangedFiles: files}
+		}
+	}
+	return prev
+}
+
 // CreateMakefile creates the makefiles for the source units in c.
 func CreateMakefile(buildDataDir string, buildStore buildstore.RepoBuildStore, vcsType string, c *config.Tree, opt Options) (*makex.Makefile, error) {
 	var allRules []makex.Rule
@@ -111,29 +268,9 @@ func CreateMakefile(buildDataDir string, buildStore buildstore.RepoBuildStore, v
 			// files stored at the previous commit to the current one.
 
 			// Check to see if a previous build exists.
-			var prevCommitID string
-			var changedFiles []string
-			if revs, err := listLatestCommitIDs(vcsType); err != nil {
-				log.Printf("Warning: could not list revisions, rebuilding from scratch: %s, %s", revs, err)
-			} else {
-				// Skip HEAD, the first revision in the list.
-				for i := 1; i < len(revs); i++ {
-					if exist, _ := buildstore.BuildDataExistsForCommit(buildStore, revs[i]); !exist {
-						continue
-					}
-					// A build store exists for this commit. Now we need
-					// to get all the changed files between this rev and
-					// the current rev.
-					files, err := filesChangedFromRevToIndex(vcsType, revs[i])
-					if err != nil {
-						log.Printf("Warning: could not retrieve changed files, rebuilding from scratch: %s %s", files, err)
-						break
-					}
-					changedFiles = files
-					prevCommitID = revs[i]
-				}
-			}
-			if prevCommitID != "" {
+			if prev := FindPrevBuild(buildStore, vcsType); prev != nil {
+				prevCommitID := prev.CommitID
+				rebuilt := rebuiltTargets(rules, prev.ChangedFiles)
 				// Replace rules.
 				for i, rule := range rules {
 					r, ok := rule.(interface {
@@ -143,7 +280,7 @@ func CreateMakefile(buildDataDir string, buildStore buildstore.RepoBuildStore, v
 						continue
 					}
 					u := r.SourceUnit()
-					if u.ContainsAny(changedFiles) {
+					if rebuilt[rule.Target()] {
 						continue
 					}
 
@@ -151,18 +288,19 @@ func CreateMakefile(buildDataDir string, buildStore buildstore.RepoBuildStore, v
 					// if it is, we simply swap the revision in the file name with the
 					// previous valid revision. If it isn't, we prefix p with
 					// "../[previous-revision]".
-					p := strings.Split(rule.Target(), "/")
-					if len(p) > 2 &&
-						strings.Join(p[0:2], "/") == buildDataDir &&
-						len(p[1]) == 40 { // HACK: Mercurial and Git both use 40-char hashes.
+					var p string
+					if rel := strings.TrimPrefix(rule.Target(), buildDataDir+"/"); rel != rule.Target() &&
+						len(filepath.Base(buildDataDir)) == 40 { // HACK: Mercurial and Git both use 40-char hashes.
 						// p is prefixed by "data-dir/vcs-commit-id"
-						p[1] = prevCommitID
+						// (where data-dir may be outside of the
+						// repository; see buildstore.LocalRepoDir).
+						p = filepath.Join(filepath.Dir(buildDataDir), prevCommitID, rel)
 					} else {
-						p = append([]string{"..", prevCommitID}, p...)
+						p = strings.Join([]string{"..", prevCommitID, rule.Target()}, "/")
 					}
 
 					rules[i] = &cachedRule{
-						cachedPath: strings.Join(p, "/"),
+						cachedPath: p,
 						target:     rule.Target(),
 						unit:       u,
 						prereqs:    rule.Prereqs(),
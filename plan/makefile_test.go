@@ -60,3 +60,146 @@ testdata/n/t.depresolve.json: testdata/n/t.unit.json
 		t.Errorf("got makefile:\n==========\n%s\n==========\n\nwant makefile:\n==========\n%s\n==========", got, want)
 	}
 }
+
+func TestCreateMakefile_RecordRuleStats(t *testing.T) {
+	c := &config.Tree{
+		SourceUnits: []*unit.SourceUnit{
+			{
+				Name:  "n",
+				Type:  "t",
+				Files: []string{"f"},
+				Ops: map[string]*srclib.ToolRef{
+					"graph":      {Toolchain: "tc", Subcmd: "t"},
+					"depresolve": {Toolchain: "tc", Subcmd: "t"},
+				},
+			},
+		},
+	}
+
+	mf, err := plan.CreateMakefile("testdata", nil, "", c, plan.Options{NoCache: true, RecordRuleStats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range mf.Rules {
+		for _, recipe := range rule.Recipes() {
+			if !strings.HasPrefix(recipe, `src tool --stats-target $@ "tc" "t" < `) {
+				t.Errorf("%s: got recipe %q, want it to record rule stats", rule.Target(), recipe)
+			}
+		}
+	}
+}
+
+func TestAffectedUnits(t *testing.T) {
+	units := []*unit.SourceUnit{
+		{Name: "a", Type: "t", Files: []string{"a/x", "a/y"}},
+		{Name: "b", Type: "t", Files: []string{"b/x"}},
+		{Name: "c", Type: "t", Files: []string{"c/x", "a/y"}},
+	}
+	affected := plan.AffectedUnits(units, []string{"a/y", "d/x"})
+	var names []string
+	for _, u := range affected {
+		names = append(names, u.Name)
+	}
+	if want := []string{"a", "c"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("got affected units %v, want %v", names, want)
+	}
+
+	// Units that list affected units in their GraphDeps are affected.
+	units = append(units, &unit.SourceUnit{Name: "d", Type: "t", Files: []string{"d/x"}, GraphDeps: []unit.ID2{{Type: "t", Name: "e"}}})
+	units = append(units, &unit.SourceUnit{Name: "e", Type: "t", Files: []string{"e/x"}, GraphDeps: []unit.ID2{{Type: "t", Name: "b"}}})
+	affected = plan.AffectedUnits(units, []string{"b/x"})
+	names = nil
+	for _, u := range affected {
+		names = append(names, u.Name)
+	}
+	if want := []string{"b", "d", "e"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("got affected units %v, want %v", names, want)
+	}
+
+	if affected := plan.AffectedUnits(units, nil); len(affected) != 0 {
+		t.Errorf("got affected units %v with no changed files, want none", affected)
+	}
+}
+
+func TestCreateMakefile_GraphDeps(t *testing.T) {
+	ops := map[string]*srclib.ToolRef{
+		"graph":      {Toolchain: "tc", Subcmd: "t"},
+		"depresolve": {Toolchain: "tc", Subcmd: "t"},
+	}
+	c := &config.Tree{
+		SourceUnits: []*unit.SourceUnit{
+			{Name: "app", Type: "t", Files: []string{"app/f"}, Ops: ops, GraphDeps: []unit.ID2{{Type: "t", Name: "lib"}, {Type: "t", Name: "missing"}}},
+			{Name: "lib", Type: "t", Files: []string{"lib/f"}, Ops: ops},
+		},
+	}
+
+	mf, err := plan.CreateMakefile("testdata", nil, "", c, plan.Options{NoCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, rule := range mf.Rules {
+		if rule.Target() != "testdata/app/t.graph.json" {
+			continue
+		}
+		found = true
+		if want := []string{"testdata/app/t.unit.json", "app/f", "testdata/lib/t.graph.json"}; strings.Join(rule.Prereqs(), " ") != strings.Join(want, " ") {
+			t.Errorf("got prereqs %v, want %v", rule.Prereqs(), want)
+		}
+		want := `src tool  "tc" "t" "testdata/lib/t.graph.json" < $< | src internal normalize-graph-data --unit-type "t" --dir . 1> $@`
+		if recipes := rule.Recipes(); len(recipes) != 1 || recipes[0] != want {
+			t.Errorf("got recipes %q, want %q", recipes, want)
+		}
+	}
+	if !found {
+		t.Fatal("no graph rule for app")
+	}
+
+	// Cycles are errors.
+	c.SourceUnits[1].GraphDeps = []unit.ID2{{Type: "t", Name: "app"}}
+	if _, err := plan.CreateMakefile("testdata", nil, "", c, plan.Options{NoCache: true}); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("got error %v, want a cycle error", err)
+	}
+}
+
+func TestCreateMakefile_ImportArgs(t *testing.T) {
+	ops := map[string]*srclib.ToolRef{
+		"graph":      {Toolchain: "tc", Subcmd: "t"},
+		"depresolve": {Toolchain: "tc", Subcmd: "t"},
+	}
+	c := &config.Tree{
+		SourceUnits: []*unit.SourceUnit{
+			{Name: "app", Type: "t", Files: []string{"app/f"}, Ops: ops, GraphDeps: []unit.ID2{{Type: "t", Name: "lib"}}},
+			{Name: "lib", Type: "t", Files: []string{"lib/f"}, Ops: ops},
+		},
+	}
+
+	mf, err := plan.CreateMakefile("testdata", nil, "", c, plan.Options{NoCache: true, ImportArgs: `--commit "c"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := map[string]makex.Rule{}
+	for _, rule := range mf.Rules {
+		targets[rule.Target()] = rule
+	}
+
+	// The app unit's graph output is imported, so its target is the
+	// import receipt.
+	rule := targets["testdata/app/t.graph-import.json"]
+	if rule == nil {
+		t.Fatal("no graph import rule for app")
+	}
+	if want := []string{"testdata/app/t.unit.json", "app/f", "testdata/lib/t.graph.json", "testdata/app/t.depresolve.json", "testdata/lib/t.depresolve.json"}; strings.Join(rule.Prereqs(), " ") != strings.Join(want, " ") {
+		t.Errorf("got prereqs %v, want %v", rule.Prereqs(), want)
+	}
+	want := `src tool  "tc" "t" "testdata/lib/t.graph.json" < $< | src internal normalize-graph-data --unit-type "t" --dir . | src internal import-graph-data --commit "c" --data-dir "testdata" --unit-type "t" --unit "app" --toolchain "tc" 1> $@`
+	if recipes := rule.Recipes(); len(recipes) != 1 || recipes[0] != want {
+		t.Errorf("got recipes %q, want %q", recipes, want)
+	}
+
+	// The app unit reads the lib unit's graph output, so it is
+	// written to the build data.
+	if targets["testdata/lib/t.graph.json"] == nil {
+		t.Error("no graph rule for lib")
+	}
+}
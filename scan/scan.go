@@ -24,18 +24,34 @@ type Options struct {
 // options from opt to each one, and it sends the JSON representation of cfg
 // (the repo/tree's Config) to each tool's stdin.
 func ScanMulti(scanners []toolchain.Tool, opt Options, treeConfig map[string]interface{}) ([]*unit.SourceUnit, error) {
+	unitsByScanner, err := ScanEach(scanners, opt, treeConfig)
+	if err != nil {
+		return nil, err
+	}
+	var units []*unit.SourceUnit
+	for _, units2 := range unitsByScanner {
+		units = append(units, units2...)
+	}
+	return units, nil
+}
+
+// ScanEach is like ScanMulti, but it returns each scanner's source
+// units separately: the i'th element of the result holds the units
+// produced by scanners[i].
+func ScanEach(scanners []toolchain.Tool, opt Options, treeConfig map[string]interface{}) ([][]*unit.SourceUnit, error) {
 	if treeConfig == nil {
 		treeConfig = map[string]interface{}{}
 	}
 
 	var (
-		units []*unit.SourceUnit
+		units = make([][]*unit.SourceUnit, len(scanners))
+		n     int // total number of units
 		mu    sync.Mutex
 	)
 
 	run := parallel.NewRun(runtime.GOMAXPROCS(0))
-	for _, scanner_ := range scanners {
-		scanner := scanner_
+	for i_, scanner_ := range scanners {
+		i, scanner := i_, scanner_
 		run.Do(func() error {
 			units2, err := Scan(scanner, opt, treeConfig)
 			if err != nil {
@@ -48,13 +64,14 @@ func ScanMulti(scanners []toolchain.Tool, opt Options, treeConfig map[string]int
 
 			mu.Lock()
 			defer mu.Unlock()
-			units = append(units, units2...)
+			units[i] = units2
+			n += len(units2)
 			return nil
 		})
 	}
 	err := run.Wait()
 	// Return error only if none of the commands succeeded.
-	if len(units) == 0 {
+	if n == 0 {
 		return nil, err
 	}
 	return units, nil
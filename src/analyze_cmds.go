@@ -0,0 +1,536 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	analyzeGroup, err := CLI.AddCommand("analyze",
+		"analyze the code graph in the store",
+		"The analyze command group contains subcommands that analyze the defs and refs in the store. They use the default store of `src store` (which can be changed with the SRC_STORE_ROOT and SRC_STORE_TYPE environment variables).",
+		&analyzeCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := analyzeGroup.AddCommand("unused",
+		"list defs that are never referenced",
+		`The unused command lists the defs (in the store) that have no refs other than their own definition, as JSON. In a MultiRepoStore, refs from all repos are considered, so a def that is only used by another repo is not reported.
+
+Defs that are expected to be unused (e.g., entry points and interface implementations) can be excluded with --allow patterns. A pattern is a glob (see path.Match) that is matched against the def's path, or, if it begins with "file:" or "kind:", against its file or kind.`,
+		&analyzeUnusedCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+	setDefaultCommitIDOpt(c)
+
+	c, err = analyzeGroup.AddCommand("impact",
+		"list files and source units affected by changes to files",
+		`The impact command lists the files and source units that may be affected by changes to the given files, as JSON. It follows refs backwards, transitively: the defs defined in a changed file are affected, so the files that contain refs to them are affected, and so on. Each affected file is listed with its distance (the number of such steps) from the changed files, which are at distance 0.
+
+This can be used to select the tests to run in CI (e.g., with --match '*_test.go').`,
+		&analyzeImpactCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+	setDefaultCommitIDOpt(c)
+
+	c, err = analyzeGroup.AddCommand("popular",
+		"list the most-referenced defs",
+		`The popular command ranks defs by the number of refs to them, as JSON, to help decide what to document and what not to break. Refs are counted with the store's def-to-refs indexes (without reading ref data) when they have been built.
+
+Refs from other repos are counted if the store has a global ref index (see "src store index --global-refs"); each referencing repo is counted at its indexed commit with the most refs to the def. With --global, the defs in all repos in the store are ranked.`,
+		&analyzePopularCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+	setDefaultCommitIDOpt(c)
+}
+
+type AnalyzeCmd struct{}
+
+var analyzeCmd AnalyzeCmd
+
+func (c *AnalyzeCmd) Execute(args []string) error { return nil }
+
+type AnalyzeUnusedCmd struct {
+	Repo     string `long:"repo" description:"only report defs in this repo"`
+	CommitID string `long:"commit" description:"only report defs (and consider refs) at this commit"`
+
+	Exported   bool `long:"exported" description:"only report exported defs"`
+	Unexported bool `long:"unexported" description:"only report non-exported defs"`
+
+	Allow     []string `long:"allow" description:"don't report defs that match this pattern (may be repeated)"`
+	Allowlist string   `long:"allowlist" description:"file with patterns (one per line; lines beginning with # are ignored) of defs not to report"`
+}
+
+var analyzeUnusedCmd AnalyzeUnusedCmd
+
+func (c *AnalyzeUnusedCmd) Execute(args []string) error {
+	allow := c.Allow
+	if c.Allowlist != "" {
+		patterns, err := readPatternFile(c.Allowlist)
+		if err != nil {
+			return err
+		}
+		allow = append(allow, patterns...)
+	}
+	for _, p := range allow {
+		if _, err := path.Match(allowPatternGlob(p), ""); err != nil {
+			return newCmdError(ExitUsage, fmt.Errorf("invalid allow pattern %q: %s", p, err))
+		}
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+	_, isMultiRepo := s.(store.MultiRepoStore)
+
+	var defFilters []store.DefFilter
+	var refFilters []store.RefFilter
+	if c.CommitID != "" {
+		if isMultiRepo && c.Repo != "" {
+			defFilters = append(defFilters, versionFilter(c.Repo, c.CommitID))
+		} else {
+			defFilters = append(defFilters, store.ByCommitIDs(c.CommitID))
+			refFilters = append(refFilters, store.ByCommitIDs(c.CommitID))
+		}
+	}
+	if isMultiRepo && c.Repo != "" {
+		defFilters = append(defFilters, store.ByRepos(c.Repo))
+	}
+
+	defs, err := rs.Defs(defFilters...)
+	if err != nil {
+		return err
+	}
+	refs, err := rs.Refs(refFilters...)
+	if err != nil {
+		return err
+	}
+
+	used := usedDefKeys(refs)
+	var unused []*graph.Def
+	for _, def := range defs {
+		if (c.Exported && !def.Exported) || (c.Unexported && def.Exported) {
+			continue
+		}
+		if isDefUsed(used, def) || matchesAllowPattern(allow, def) {
+			continue
+		}
+		unused = append(unused, def)
+	}
+	logger.Infof("# %d of %d defs are unused.", len(unused), len(defs))
+	PrintJSON(unused, "")
+	return nil
+}
+
+// usedDefKeys returns the keys of the defs that refs refer to,
+// excluding refs that are the defs' own definitions. The CommitID of
+// a key is the ref's CommitID for refs within a repo, and empty for
+// cross-repo refs (whose def's commit is not known).
+func usedDefKeys(refs []*graph.Ref) map[graph.DefKey]struct{} {
+	used := make(map[graph.DefKey]struct{}, len(refs))
+	for _, ref := range refs {
+		if ref.Def {
+			continue
+		}
+		key := ref.DefKey()
+		if ref.DefRepo == ref.Repo {
+			key.CommitID = ref.CommitID
+		}
+		used[key] = struct{}{}
+	}
+	return used
+}
+
+// isDefUsed returns whether def's key (at its commit or, for
+// cross-repo refs, at any commit) is in used.
+func isDefUsed(used map[graph.DefKey]struct{}, def *graph.Def) bool {
+	key := def.DefKey
+	if _, present := used[key]; present {
+		return true
+	}
+	key.CommitID = ""
+	_, present := used[key]
+	return present
+}
+
+// allowPatternGlob returns the glob of an allow pattern (without its
+// "file:" or "kind:" prefix, if any).
+func allowPatternGlob(pattern string) string {
+	for _, prefix := range []string{"file:", "kind:"} {
+		if strings.HasPrefix(pattern, prefix) {
+			return strings.TrimPrefix(pattern, prefix)
+		}
+	}
+	return pattern
+}
+
+// matchesAllowPattern returns whether def matches any of the allow
+// patterns.
+func matchesAllowPattern(patterns []string, def *graph.Def) bool {
+	for _, p := range patterns {
+		s := def.Path
+		switch {
+		case strings.HasPrefix(p, "file:"):
+			s = def.File
+		case strings.HasPrefix(p, "kind:"):
+			s = def.Kind
+		}
+		if match, _ := path.Match(allowPatternGlob(p), s); match {
+			return true
+		}
+	}
+	return false
+}
+
+// readPatternFile reads a file with one pattern per line. Blank lines
+// and lines beginning with "#" are ignored.
+func readPatternFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+type AnalyzeImpactCmd struct {
+	Repo     string `long:"repo" description:"repo (for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"commit ID whose defs and refs to use"`
+
+	Files    string `long:"files" description:"comma-separated list of changed files" required:"yes"`
+	MaxDepth int    `long:"max-depth" description:"max number of steps to follow from the changed files (0 for no limit)"`
+	Match    string `long:"match" description:"only list affected files whose base names match this glob (e.g., '*_test.go')"`
+}
+
+var analyzeImpactCmd AnalyzeImpactCmd
+
+// ImpactedFile is a file that may be affected by a change.
+type ImpactedFile struct {
+	File  string
+	Depth int // number of steps from the changed files
+}
+
+// ImpactReport is the output of the impact command.
+type ImpactReport struct {
+	Files []*ImpactedFile
+	Units []unit.ID2
+}
+
+func (c *AnalyzeImpactCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	if _, err := path.Match(c.Match, ""); err != nil {
+		return newCmdError(ExitUsage, fmt.Errorf("invalid --match glob %q: %s", c.Match, err))
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+	repo := c.Repo
+	if _, isMultiRepo := s.(store.MultiRepoStore); !isMultiRepo {
+		repo = ""
+	}
+
+	defs, err := rs.Defs(versionFilter(repo, c.CommitID), store.Unordered())
+	if err != nil {
+		return err
+	}
+	refs, err := rs.Refs(versionFilter(repo, c.CommitID), store.Unordered())
+	if err != nil {
+		return err
+	}
+
+	// Index the defs by file and the refs by the def they refer to.
+	// Only refs within the repo are followed.
+	defsByFile := map[string][]impactDefKey{}
+	for _, def := range defs {
+		defsByFile[def.File] = append(defsByFile[def.File], impactDefKey{def.UnitType, def.Unit, def.Path})
+	}
+	refsByDef := map[impactDefKey][]*graph.Ref{}
+	for _, ref := range refs {
+		if ref.Def || ref.DefRepo != ref.Repo {
+			continue
+		}
+		k := impactDefKey{ref.DefUnitType, ref.DefUnit, ref.DefPath}
+		refsByDef[k] = append(refsByDef[k], ref)
+	}
+
+	depths := map[string]int{}
+	units := map[unit.ID2]struct{}{}
+	var queue []string
+	for _, file := range strings.Split(c.Files, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			file = path.Clean(file)
+			depths[file] = 0
+			queue = append(queue, file)
+		}
+	}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		depth := depths[file]
+		for _, k := range defsByFile[file] {
+			units[unit.ID2{Type: k.unitType, Name: k.unit}] = struct{}{}
+			if c.MaxDepth != 0 && depth >= c.MaxDepth {
+				continue
+			}
+			for _, ref := range refsByDef[k] {
+				units[unit.ID2{Type: ref.UnitType, Name: ref.Unit}] = struct{}{}
+				if _, seen := depths[ref.File]; !seen {
+					depths[ref.File] = depth + 1
+					queue = append(queue, ref.File)
+				}
+			}
+		}
+	}
+
+	report := ImpactReport{Files: []*ImpactedFile{}, Units: []unit.ID2{}}
+	for file, depth := range depths {
+		if c.Match != "" {
+			if match, _ := path.Match(c.Match, path.Base(file)); !match {
+				continue
+			}
+		}
+		report.Files = append(report.Files, &ImpactedFile{File: file, Depth: depth})
+	}
+	sort.Sort(impactedFiles(report.Files))
+	for u := range units {
+		report.Units = append(report.Units, u)
+	}
+	sort.Sort(unitID2s(report.Units))
+	PrintJSON(report, "")
+	return nil
+}
+
+// impactDefKey is the key of a def within a repo and commit.
+type impactDefKey struct {
+	unitType, unit, path string
+}
+
+type impactedFiles []*ImpactedFile
+
+func (v impactedFiles) Len() int      { return len(v) }
+func (v impactedFiles) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v impactedFiles) Less(i, j int) bool {
+	if v[i].Depth != v[j].Depth {
+		return v[i].Depth < v[j].Depth
+	}
+	return v[i].File < v[j].File
+}
+
+type AnalyzePopularCmd struct {
+	Repo     string `long:"repo" description:"only rank defs in this repo"`
+	CommitID string `long:"commit" description:"only rank defs (and count refs) at this commit"`
+	Global   bool   `long:"global" description:"rank defs in all repos (ignores --repo and --commit)"`
+
+	Kinds    []string `long:"kind" description:"only rank defs of this kind (may be repeated)"`
+	Exported bool     `long:"exported" description:"only rank exported defs"`
+	Limit    int      `short:"n" long:"limit" description:"max number of defs to list (0 for no limit)" default:"50"`
+}
+
+var analyzePopularCmd AnalyzePopularCmd
+
+// PopularDef is a def and the number of refs to it.
+type PopularDef struct {
+	Def *graph.Def
+
+	// Refs is the number of refs to the def in its own repo (at the
+	// def's commit).
+	Refs int
+
+	// CrossRepoRefs is the number of refs to the def in other repos,
+	// and CrossRepos is the number of those repos.
+	CrossRepoRefs int `json:",omitempty"`
+	CrossRepos    int `json:",omitempty"`
+}
+
+func (d *PopularDef) totalRefs() int { return d.Refs + d.CrossRepoRefs }
+
+func (c *AnalyzePopularCmd) Execute(args []string) error {
+	if c.Limit < 0 {
+		return newCmdError(ExitUsage, fmt.Errorf("invalid --limit %d", c.Limit))
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs", s)
+	}
+	rcs, ok := s.(store.DefRefCountStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement counting refs", s)
+	}
+	_, isMultiRepo := s.(store.MultiRepoStore)
+
+	repo, commitID := c.Repo, c.CommitID
+	if c.Global {
+		repo, commitID = "", ""
+	}
+	if !isMultiRepo {
+		repo = ""
+	}
+	var filters []interface {
+		store.DefFilter
+		store.UnitFilter
+	}
+	if commitID != "" {
+		filters = append(filters, versionFilter(repo, commitID))
+	}
+	if repo != "" {
+		filters = append(filters, store.ByRepos(repo))
+	}
+
+	unitFilters := make([]store.UnitFilter, len(filters))
+	defFilters := make([]store.DefFilter, len(filters), len(filters)+1)
+	for i, f := range filters {
+		unitFilters[i], defFilters[i] = f, f
+	}
+	counts, err := rcs.DefRefCounts(unitFilters...)
+	if err != nil {
+		return err
+	}
+
+	byDef := map[graph.DefKey]*PopularDef{}
+	for _, rc := range counts {
+		k := graph.DefKey{Repo: rc.Repo, CommitID: rc.CommitID, UnitType: rc.UnitType, Unit: rc.Unit, Path: rc.Path}
+		byDef[k] = &PopularDef{Refs: rc.Refs}
+	}
+
+	// Refs in other repos don't refer to a specific commit of the
+	// def's repo, so they are counted for the def at every commit.
+	crossRefs := map[graph.DefKey]*store.DefRefCount{}
+	if grs, ok := s.(store.GlobalRefStore); ok {
+		var repos []string
+		if repo != "" {
+			repos = []string{repo}
+		} else {
+			repos, err = s.(store.MultiRepoStore).Repos()
+			if err != nil && !store.IsNotExist(err) {
+				return err
+			}
+		}
+		for _, r := range repos {
+			xcounts, err := grs.CrossRepoRefCounts(r)
+			if err != nil {
+				return err
+			}
+			for _, rc := range xcounts {
+				crossRefs[graph.DefKey{Repo: rc.Repo, UnitType: rc.UnitType, Unit: rc.Unit, Path: rc.Path}] = rc
+			}
+		}
+	}
+
+	defFilters = append(defFilters, store.DefFilterFunc(func(def *graph.Def) bool {
+		if c.Exported && !def.Exported {
+			return false
+		}
+		if len(c.Kinds) == 0 {
+			return true
+		}
+		for _, kind := range c.Kinds {
+			if def.Kind == kind {
+				return true
+			}
+		}
+		return false
+	}))
+	defs, err := rs.Defs(defFilters...)
+	if err != nil {
+		return err
+	}
+
+	var popular []*PopularDef
+	for _, def := range defs {
+		pd := byDef[def.DefKey]
+		if pd == nil {
+			pd = &PopularDef{}
+		}
+		pd.Def = def
+		if rc := crossRefs[graph.DefKey{Repo: def.Repo, UnitType: def.UnitType, Unit: def.Unit, Path: def.Path}]; rc != nil {
+			pd.CrossRepoRefs, pd.CrossRepos = rc.CrossRepoRefs, rc.CrossRepos
+		}
+		if pd.totalRefs() > 0 {
+			popular = append(popular, pd)
+		}
+	}
+	sort.Sort(popularDefs(popular))
+	logger.Infof("# %d of %d defs are referenced.", len(popular), len(defs))
+	if c.Limit > 0 && len(popular) > c.Limit {
+		popular = popular[:c.Limit]
+	}
+	PrintJSON(popular, "")
+	return nil
+}
+
+// popularDefs sorts by decreasing number of refs (and then by def).
+type popularDefs []*PopularDef
+
+func (v popularDefs) Len() int      { return len(v) }
+func (v popularDefs) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v popularDefs) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.totalRefs() != b.totalRefs() {
+		return a.totalRefs() > b.totalRefs()
+	}
+	ak := []string{a.Def.Repo, a.Def.CommitID, a.Def.UnitType, a.Def.Unit, a.Def.Path}
+	bk := []string{b.Def.Repo, b.Def.CommitID, b.Def.UnitType, b.Def.Unit, b.Def.Path}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+type unitID2s []unit.ID2
+
+func (v unitID2s) Len() int      { return len(v) }
+func (v unitID2s) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v unitID2s) Less(i, j int) bool {
+	if v[i].Type != v[j].Type {
+		return v[i].Type < v[j].Type
+	}
+	return v[i].Name < v[j].Name
+}
@@ -178,7 +178,7 @@ func prepareCommandContext(file string) (commandContext, error) {
 		return commandContext{}, err
 	}
 
-	buildStore, err := buildstore.LocalRepo(repo.RootDir)
+	buildStore, err := localBuildStore(repo.RootDir)
 	if err != nil {
 		return commandContext{}, err
 	}
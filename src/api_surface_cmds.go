@@ -0,0 +1,169 @@
+package src
+
+import (
+	"fmt"
+	"log"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	c, err := CLI.AddCommand("api-surface",
+		"list a commit's exported defs and their signatures",
+		"The api-surface command prints the API surface of a repository at a commit (the exported defs in the store, with their signatures) as JSON. It uses the default store of `src store` (which can be changed with the SRC_STORE_ROOT and SRC_STORE_TYPE environment variables).",
+		&apiSurfaceCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+	setDefaultCommitIDOpt(c)
+
+	c, err = CLI.AddCommand("api-compat",
+		"report breaking API changes between two commits",
+		`The api-compat command compares the API surfaces (see 'src api-surface') of a repository at two commits and reports breaking changes as JSON: exported defs that were removed (or unexported) and exported defs whose kind or signature changed. Exported defs that were added are also listed. It exits with a non-zero status if there are breaking changes.
+
+Both commits must have been imported into the store.`,
+		&apiCompatCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+}
+
+// APIDef is a def in an API surface.
+type APIDef struct {
+	graph.DefKey
+	Name      string
+	Kind      string `json:",omitempty"`
+	File      string
+	Signature string `json:",omitempty"`
+}
+
+func newAPIDef(def *graph.Def) *APIDef {
+	return &APIDef{DefKey: def.DefKey, Name: def.Name, Kind: def.Kind, File: def.File, Signature: defSignature(def)}
+}
+
+// defSignature returns a human-readable signature of def, if a def
+// formatter is registered for its unit type. (Signature changes are
+// detected by comparing the defs' data, not their signatures.)
+func defSignature(def *graph.Def) string {
+	if _, present := graph.MakeDefFormatters[def.UnitType]; !present {
+		return ""
+	}
+	f := graph.PrintFormatter(def)
+	return fmt.Sprintf("%s %.1n% .1t", f.DefKeyword(), f, f)
+}
+
+// exportedDefs returns the exported defs in repo at commitID.
+func exportedDefs(repo, commitID string) ([]*graph.Def, error) {
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing defs", s)
+	}
+	if _, isMultiRepo := s.(store.MultiRepoStore); !isMultiRepo {
+		repo = ""
+	} else if repo == "" {
+		return nil, newCmdError(ExitUsage, fmt.Errorf("no --repo given (required for a MultiRepoStore)"))
+	}
+	return rs.Defs(versionFilter(repo, commitID), store.DefFilterFunc(func(def *graph.Def) bool { return def.Exported }))
+}
+
+type APISurfaceCmd struct {
+	Repo     string `long:"repo" description:"repo (for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"commit ID whose API surface to print"`
+}
+
+var apiSurfaceCmd APISurfaceCmd
+
+func (c *APISurfaceCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	defs, err := exportedDefs(c.Repo, c.CommitID)
+	if err != nil {
+		return err
+	}
+	apiDefs := make([]*APIDef, len(defs))
+	for i, def := range defs {
+		apiDefs[i] = newAPIDef(def)
+	}
+	PrintJSON(apiDefs, "")
+	return nil
+}
+
+type APICompatCmd struct {
+	Repo string `long:"repo" description:"repo (for MultiRepoStores)"`
+	From string `long:"from" description:"commit ID of the old version" required:"yes"`
+	To   string `long:"to" description:"commit ID of the new version" required:"yes"`
+}
+
+var apiCompatCmd APICompatCmd
+
+// APIChange is a change to an API surface.
+type APIChange struct {
+	Change  string   // "removed", "changed", or "added"
+	From    *APIDef  `json:",omitempty"`
+	To      *APIDef  `json:",omitempty"`
+	Changed []string `json:",omitempty"` // for "changed": "kind" and/or "data" (i.e., the signature)
+}
+
+// APICompatReport is the output of the api-compat command.
+type APICompatReport struct {
+	Breaking []*APIChange
+	Added    []*APIChange
+}
+
+func (c *APICompatCmd) Execute(args []string) error {
+	from, err := exportedDefs(c.Repo, c.From)
+	if err != nil {
+		return err
+	}
+	to, err := exportedDefs(c.Repo, c.To)
+	if err != nil {
+		return err
+	}
+	if len(from) == 0 {
+		logger.Warnf("no exported defs at commit %s (has it been imported?)", c.From)
+	}
+
+	report := APICompatReport{Breaking: []*APIChange{}, Added: []*APIChange{}}
+	for _, d := range store.DiffDefs(from, to) {
+		ch := &APIChange{Change: d.Change}
+		if d.From != nil {
+			ch.From = newAPIDef(d.From)
+		}
+		if d.To != nil {
+			ch.To = newAPIDef(d.To)
+		}
+		switch d.Change {
+		case store.DefRemoved:
+			report.Breaking = append(report.Breaking, ch)
+		case store.DefAdded:
+			report.Added = append(report.Added, ch)
+		case store.DefChanged:
+			// Moving a def (changing its file or range) doesn't
+			// break its users.
+			for _, aspect := range d.Changed {
+				if aspect == "kind" || aspect == "data" {
+					ch.Changed = append(ch.Changed, aspect)
+				}
+			}
+			if len(ch.Changed) > 0 {
+				report.Breaking = append(report.Breaking, ch)
+			}
+		}
+	}
+	PrintJSON(report, "")
+
+	if n := len(report.Breaking); n > 0 {
+		return fmt.Errorf("%d breaking API changes between %s and %s", n, c.From, c.To)
+	}
+	return nil
+}
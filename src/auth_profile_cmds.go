@@ -0,0 +1,242 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kr/s3"
+)
+
+var credentialProfilesFile = filepath.Join(os.Getenv("HOME"), ".srclib-profiles")
+
+// credentialProfiles holds named credential profiles for remote
+// stores. It's typically saved in a file named by
+// credentialProfilesFile.
+type credentialProfiles map[string]*credentialProfile
+
+// credentialProfile holds the credentials used to access remote
+// stores.
+type credentialProfile struct {
+	AWSAccessKeyID     string `json:",omitempty"`
+	AWSSecretAccessKey string `json:",omitempty"`
+
+	// Token is a bearer token for remote (non-S3) stores.
+	Token string `json:",omitempty"`
+}
+
+// readCredentialProfiles reads the credential profiles from the
+// credentialProfilesFile. It is not considered an error if the file
+// doesn't exist; in that case, an empty credentialProfiles and a nil
+// error is returned.
+func readCredentialProfiles() (credentialProfiles, error) {
+	f, err := os.Open(credentialProfilesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return credentialProfiles{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var ps credentialProfiles
+	if err := json.NewDecoder(f).Decode(&ps); err != nil {
+		return nil, fmt.Errorf("reading credential profiles from %s: %s", credentialProfilesFile, err)
+	}
+	if ps == nil {
+		ps = credentialProfiles{}
+	}
+	return ps, nil
+}
+
+// writeCredentialProfiles writes ps to the credentialProfilesFile.
+// It writes a new file that only the user can read (mode 0600) and
+// renames it over the old file, so that the credentials are never
+// readable by other users (even briefly, or if the old file had other
+// permissions) and a failed write doesn't lose the existing profiles.
+func writeCredentialProfiles(ps credentialProfiles) (err error) {
+	b, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	// TempFile creates the file with mode 0600.
+	f, err := ioutil.TempFile(filepath.Dir(credentialProfilesFile), filepath.Base(credentialProfilesFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), credentialProfilesFile)
+}
+
+// s3Keys returns the S3 keys to use for the profile with the given
+// name. If name is empty, the keys are read from the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+func s3Keys(name string) (*s3.Keys, error) {
+	if name == "" {
+		return &s3.Keys{
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}, nil
+	}
+	ps, err := readCredentialProfiles()
+	if err != nil {
+		return nil, err
+	}
+	p, present := ps[name]
+	if !present {
+		return nil, newCmdError(ExitUsage, fmt.Errorf("no credential profile named %q (use `src auth set` to create it)", name))
+	}
+	if p.AWSAccessKeyID == "" || p.AWSSecretAccessKey == "" {
+		return nil, fmt.Errorf("credential profile %q has no AWS keys", name)
+	}
+	return &s3.Keys{AccessKey: p.AWSAccessKeyID, SecretKey: p.AWSSecretAccessKey}, nil
+}
+
+func init() {
+	c, err := CLI.AddCommand("auth",
+		"manage credential profiles for remote stores",
+		"The auth commands manage named credential profiles for remote (e.g., S3) stores. Profiles are saved in ~/.srclib-profiles and selected with `src store --profile=NAME`. If no profile is selected, the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are used.",
+		&authCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("set",
+		"create or update a profile",
+		"The set command creates a credential profile or updates the credentials given by flags in an existing profile.",
+		&authSetCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("list",
+		"list profiles",
+		"The list command lists credential profiles. Secrets are not printed.",
+		&authListCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("rm",
+		"remove a profile",
+		"The rm command removes a credential profile.",
+		&authRmCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type AuthCmd struct{}
+
+var authCmd AuthCmd
+
+func (c *AuthCmd) Execute(args []string) error { return nil }
+
+type AuthSetCmd struct {
+	AWSAccessKeyID     string `long:"aws-access-key-id" description:"AWS access key ID (for S3 stores)"`
+	AWSSecretAccessKey string `long:"aws-secret-access-key" description:"AWS secret access key (for S3 stores)"`
+	Token              string `long:"token" description:"bearer token (for remote non-S3 stores)"`
+
+	Args struct {
+		Profile string `name:"PROFILE" description:"profile name"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var authSetCmd AuthSetCmd
+
+func (c *AuthSetCmd) Execute(args []string) error {
+	ps, err := readCredentialProfiles()
+	if err != nil {
+		return err
+	}
+	p := ps[c.Args.Profile]
+	if p == nil {
+		p = &credentialProfile{}
+		ps[c.Args.Profile] = p
+	}
+	if c.AWSAccessKeyID != "" {
+		p.AWSAccessKeyID = c.AWSAccessKeyID
+	}
+	if c.AWSSecretAccessKey != "" {
+		p.AWSSecretAccessKey = c.AWSSecretAccessKey
+	}
+	if c.Token != "" {
+		p.Token = c.Token
+	}
+	if err := writeCredentialProfiles(ps); err != nil {
+		return err
+	}
+	logger.Infof("# Profile %q saved to %s.", c.Args.Profile, credentialProfilesFile)
+	return nil
+}
+
+type AuthListCmd struct{}
+
+var authListCmd AuthListCmd
+
+func (c *AuthListCmd) Execute(args []string) error {
+	ps, err := readCredentialProfiles()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(ps))
+	for name := range ps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := ps[name]
+		var creds []string
+		if p.AWSAccessKeyID != "" {
+			creds = append(creds, "aws-access-key-id="+p.AWSAccessKeyID)
+		}
+		if p.AWSSecretAccessKey != "" {
+			creds = append(creds, "aws-secret-access-key")
+		}
+		if p.Token != "" {
+			creds = append(creds, "token")
+		}
+		fmt.Println(name, creds)
+	}
+	return nil
+}
+
+type AuthRmCmd struct {
+	Args struct {
+		Profiles []string `name:"PROFILES" description:"profile names"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var authRmCmd AuthRmCmd
+
+func (c *AuthRmCmd) Execute(args []string) error {
+	ps, err := readCredentialProfiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range c.Args.Profiles {
+		if _, present := ps[name]; !present {
+			return fmt.Errorf("no credential profile named %q", name)
+		}
+		delete(ps, name)
+	}
+	return writeCredentialProfiles(ps)
+}
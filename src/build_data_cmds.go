@@ -24,7 +24,9 @@ import (
 func init() {
 	buildDataGroup, err := CLI.AddCommand("build-data",
 		"build data operations",
-		"The build-data command group contains subcommands for listing, displaying, uploading, and downloading build data.",
+		`The build-data command group contains subcommands for listing, displaying, uploading, and downloading build data.
+
+Remote build data is stored on Sourcegraph unless the SRCLIB_BUILD_DATA_URL env var is set to the base URL of an HTTP build data server (such as a CI artifacts server), in which case build data for a repo at a commit is stored under BASE/REPO/COMMIT/. The server must handle GET, HEAD, PUT, and DELETE requests for files and return a JSON array of {"Name", "Size", "ModTime", "Dir"} objects for GET requests for dirs (whose paths end in "/"). The SRCLIB_BUILD_DATA_HEADER env var holds headers to send with each request (newline-separated "Name: value" pairs, such as "Authorization: Bearer TOKEN").`,
 		&buildDataCmd,
 	)
 	if err != nil {
@@ -74,13 +76,34 @@ func init() {
 
 	c, err = buildDataGroup.AddCommand("upload",
 		"upload local build data to remote",
-		"The upload command uploads local build data (in .srclib-cache) for the current repository to the remote.",
+		`The upload command uploads local build data (in .srclib-cache) for the current repository to the remote.
+
+With --to=s3://BUCKET/PATH, it uploads the build data to S3 under PATH/REPO/COMMIT/ instead, using parallel multipart uploads. Each object records the MD5 checksum of its file, which is checked after the upload. Files whose objects already have the same checksum are skipped, so running the command again resumes an interrupted upload and uploads only files that changed.`,
 		&buildDataUploadCmd,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 	setDefaultCommitIDOpt(c)
+
+	c, err = buildDataGroup.AddCommand("sarif",
+		"export diagnostics as SARIF",
+		"The sarif command converts the diagnostic annotations (reported by lint-style toolchains) in the build data for a repository at a specific commit to a SARIF log, which can be uploaded to code scanning UIs. There is one SARIF run per source unit type.",
+		&buildDataSARIFCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultCommitIDOpt(c)
+
+	_, err = buildDataGroup.AddCommand("gc",
+		"remove old build data",
+		`The gc command removes build data for old commits from local .srclib-cache dirs (of the current repo, or of the repos whose top-level dirs are given as arguments) or, with --remote, from the HTTP build data server, or, with --s3=s3://BUCKET/PATH, from the current repo's build data uploaded to S3 (with 'src build-data upload --to'). It keeps build data for the --keep most recently built commits of each repo, and removes build data for commits built longer ago than --max-age. Build data for a repo's checked-out commit is never removed from local .srclib-cache dirs. It reports the space freed.`,
+		&buildDataGCCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 type BuildDataCmd struct {
@@ -99,6 +122,16 @@ func (c buildDataSingleCommitCommonOpts) getLocalFileSystem() (rwvfs.FileSystem,
 }
 
 func (c buildDataSingleCommitCommonOpts) getRemoteFileSystem() (rwvfs.FileSystem, string, sourcegraph.RepoRevSpec, error) {
+	if base, err := httpBuildDataBaseURL(); err != nil {
+		return nil, "", sourcegraph.RepoRevSpec{}, err
+	} else if base != nil {
+		lrepo, err := openLocalRepo()
+		if err != nil {
+			return nil, "", sourcegraph.RepoRevSpec{}, err
+		}
+		return getRemoteBuildDataFS(lrepo.URI(), c.CommitID)
+	}
+
 	cl := NewAPIClientWithAuthIfPresent()
 	rrepo, err := getRemoteRepo(cl)
 	if err != nil {
@@ -130,7 +163,7 @@ func getLocalBuildDataFS(commitID string) (rwvfs.FileSystem, string, error) {
 	if lrepo == nil || lrepo.RootDir == "" || commitID == "" {
 		return nil, "", err
 	}
-	localStore, err := buildstore.LocalRepo(lrepo.RootDir)
+	localStore, err := localBuildStore(lrepo.RootDir)
 	if err != nil {
 		return nil, "", err
 	}
@@ -138,12 +171,21 @@ func getLocalBuildDataFS(commitID string) (rwvfs.FileSystem, string, error) {
 }
 
 // getRemoteBuildDataFS gets the remote build data file system for
-// repo at commitID. It returns an error if repo is empty.
+// repo at commitID. It returns an error if repo is empty. If the
+// SRCLIB_BUILD_DATA_URL env var is set, the build data is on that
+// HTTP server (see httpBuildDataBaseURL); otherwise it is on
+// Sourcegraph.
 func getRemoteBuildDataFS(repo, commitID string) (rwvfs.FileSystem, string, sourcegraph.RepoRevSpec, error) {
 	if repo == "" {
 		err := errors.New("getRemoteBuildDataFS: repo cannot be empty")
 		return nil, "", sourcegraph.RepoRevSpec{}, err
 	}
+	if base, err := httpBuildDataBaseURL(); err != nil {
+		return nil, "", sourcegraph.RepoRevSpec{}, err
+	} else if base != nil {
+		fs, label, err := getHTTPBuildDataFS(base, repo, commitID)
+		return fs, label, sourcegraph.RepoRevSpec{RepoSpec: sourcegraph.RepoSpec{URI: repo}, Rev: commitID, CommitID: commitID}, err
+	}
 	cl := NewAPIClientWithAuthIfPresent()
 	rrepo, _, err := cl.Repos.Get(sourcegraph.RepoSpec{URI: repo}, nil)
 	if err != nil {
@@ -198,13 +240,22 @@ func (c *BuildDataListCmd) Execute(args []string) error {
 		return err
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Listing build files for %s in dir %q", repoLabel, dir)
+	logger.Debugf("Listing build files for %s in dir %q", repoLabel, dir)
+
+	httpBase, err := httpBuildDataBaseURL()
+	if err != nil {
+		return err
 	}
 
 	// Only used for constructing the URLs for remote build data.
 	var repoRevSpec sourcegraph.RepoRevSpec
-	if !c.Local {
+	if !c.Local && httpBase != nil {
+		lrepo, err := openLocalRepo()
+		if err != nil {
+			return err
+		}
+		repoRevSpec = sourcegraph.RepoRevSpec{RepoSpec: sourcegraph.RepoSpec{URI: lrepo.URI()}, Rev: c.CommitID, CommitID: c.CommitID}
+	} else if !c.Local {
 		cl := NewAPIClientWithAuthIfPresent()
 		rrepo, err := getRemoteRepo(cl)
 		if err != nil {
@@ -234,7 +285,9 @@ func (c *BuildDataListCmd) Execute(args []string) error {
 		}
 
 		var urlStr string
-		if c.URLs {
+		if c.URLs && httpBase != nil {
+			urlStr = httpBuildDataFileURL(httpBase, repoRevSpec.URI, repoRevSpec.CommitID, filepath.ToSlash(filepath.Join(dir, fi.Name())))
+		} else if c.URLs {
 			spec := sourcegraph.BuildDataFileSpec{RepoRev: repoRevSpec, Path: filepath.Join(dir, fi.Name())}
 
 			// TODO(sqs): use sourcegraph.Router when it is merged to go-sourcegraph master
@@ -309,9 +362,7 @@ func (c *BuildDataCatCmd) Execute(args []string) error {
 		return err
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Displaying build file %q for %s", file, repoLabel)
-	}
+	logger.Debugf("Displaying build file %q for %s", file, repoLabel)
 
 	f, err := bdfs.Open(file)
 	if err != nil {
@@ -350,7 +401,11 @@ func (c *BuildDataRemoveCmd) Execute(args []string) error {
 		if err := os.RemoveAll(filepath.Join(lrepo.RootDir, store.SrclibStoreDir)); err != nil {
 			return err
 		}
-		if err := os.RemoveAll(filepath.Join(lrepo.RootDir, buildstore.BuildDataDirName)); err != nil {
+		root, err := localBuildDataRoot(lrepo.RootDir)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(root); err != nil {
 			return err
 		}
 		return nil
@@ -361,9 +416,7 @@ func (c *BuildDataRemoveCmd) Execute(args []string) error {
 		return err
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Removing build files %v for %s", c.Args.Files, repoLabel)
-	}
+	logger.Debugf("Removing build files %v for %s", c.Args.Files, repoLabel)
 
 	vfs := removeLoggedFS{rwvfs.Walkable(bdfs)}
 
@@ -387,9 +440,7 @@ func (fs removeLoggedFS) Remove(path string) error {
 	if err := fs.WalkableFileSystem.Remove(path); err != nil {
 		return err
 	}
-	if GlobalOpt.Verbose {
-		log.Printf("Removed %s", path)
-	}
+	logger.Debugf("Removed %s", path)
 	return nil
 }
 
@@ -413,19 +464,22 @@ func (c *BuildDataFetchCmd) Execute(args []string) error {
 	}
 
 	// Use uncached API client because the .srclib-cache already
-	// caches it, and we want to be able to stream large files.
+	// caches it, and we want to be able to stream large files. (HTTP
+	// build data servers are never cached.)
 	//
 	// TODO(sqs): this uncached client isn't authed because it doesn't
 	// have the other API client's http.Client or http.RoundTripper
-	cl := newAPIClientWithAuth(false)
-	remoteFS, err = cl.BuildData.FileSystem(repoRevSpec)
-	if err != nil {
+	if base, err := httpBuildDataBaseURL(); err != nil {
 		return err
+	} else if base == nil {
+		cl := newAPIClientWithAuth(false)
+		remoteFS, err = cl.BuildData.FileSystem(repoRevSpec)
+		if err != nil {
+			return err
+		}
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Fetching remote build files for %s to %s...", remoteRepoLabel, localRepoLabel)
-	}
+	logger.Debugf("Fetching remote build files for %s to %s...", remoteRepoLabel, localRepoLabel)
 
 	// TODO(sqs): check if file exists in local cache and don't fetch it if it does and if it is identical
 
@@ -435,7 +489,7 @@ func (c *BuildDataFetchCmd) Execute(args []string) error {
 		path := w.Path()
 		if err := w.Err(); err != nil {
 			if path == "." {
-				log.Printf("# No build data to pull from %s", remoteRepoLabel)
+				logger.Infof("# No build data to pull from %s", remoteRepoLabel)
 				return nil
 			}
 			return fmt.Errorf("walking remote dir tree: %s", err)
@@ -460,7 +514,7 @@ func (c *BuildDataFetchCmd) Execute(args []string) error {
 func fetchFile(remote vfs.FileSystem, local rwvfs.FileSystem, path string, fi os.FileInfo, dryRun bool) error {
 	kb := float64(fi.Size()) / 1024
 	if GlobalOpt.Verbose || dryRun {
-		log.Printf("Fetching %s (%.1fkb)", path, kb)
+		logger.Infof("Fetching %s (%.1fkb)", path, kb)
 	}
 	if dryRun {
 		return nil
@@ -486,9 +540,7 @@ func fetchFile(remote vfs.FileSystem, local rwvfs.FileSystem, path string, fi os
 		return fmt.Errorf("copy from remote to local: %s", err)
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Fetched %s (%.1fkb)", path, kb)
-	}
+	logger.Debugf("Fetched %s (%.1fkb)", path, kb)
 
 	if err := lf.Close(); err != nil {
 		return fmt.Errorf("local file: %s", err)
@@ -500,6 +552,10 @@ type BuildDataUploadCmd struct {
 	buildDataSingleCommitCommonOpts
 
 	DryRun bool `short:"n" long:"dry-run" description:"don't do anything, just show what would be done"`
+
+	To       string `long:"to" description:"upload to S3 (s3://BUCKET/PATH), under PATH/REPO/COMMIT/, instead of to the remote" value-name:"URL"`
+	Parallel int    `long:"parallel" description:"max number of files to upload concurrently (with --to)" default:"8"`
+	Profile  string `long:"profile" description:"credential profile to use for S3 (see 'src auth'; with --to)"`
 }
 
 var buildDataUploadCmd BuildDataUploadCmd
@@ -510,14 +566,21 @@ func (c *BuildDataUploadCmd) Execute(args []string) error {
 		return err
 	}
 
+	if c.To != "" {
+		lrepo, err := openLocalRepo()
+		if err != nil {
+			return err
+		}
+		logger.Debugf("Uploading build files from %s to %s...", localRepoLabel, c.To)
+		return c.uploadToS3(localFS, lrepo.URI())
+	}
+
 	remoteFS, remoteRepoLabel, _, err := c.getRemoteFileSystem()
 	if err != nil {
 		return err
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Uploading build files from %s to %s...", localRepoLabel, remoteRepoLabel)
-	}
+	logger.Debugf("Uploading build files from %s to %s...", localRepoLabel, remoteRepoLabel)
 
 	// TODO(sqs): check if file exists remotely and don't upload it if it does and if it is identical
 
@@ -545,7 +608,7 @@ func (c *BuildDataUploadCmd) Execute(args []string) error {
 func uploadFile(local vfs.FileSystem, remote rwvfs.FileSystem, path string, fi os.FileInfo, dryRun bool) error {
 	kb := float64(fi.Size()) / 1024
 	if GlobalOpt.Verbose || dryRun {
-		log.Printf("Uploading %s (%.1fkb)", path, kb)
+		logger.Infof("Uploading %s (%.1fkb)", path, kb)
 	}
 	if dryRun {
 		return nil
@@ -565,7 +628,7 @@ func uploadFile(local vfs.FileSystem, remote rwvfs.FileSystem, path string, fi o
 	}
 	defer func() {
 		if err := rf.Close(); err != nil {
-			log.Println("Error closing after error:", err)
+			logger.Errorf("Error closing after error: %s", err)
 		}
 	}()
 
@@ -577,8 +640,6 @@ func uploadFile(local vfs.FileSystem, remote rwvfs.FileSystem, path string, fi o
 		return err
 	}
 
-	if GlobalOpt.Verbose {
-		log.Printf("Uploaded %s (%.1fkb)", path, kb)
-	}
+	logger.Debugf("Uploaded %s (%.1fkb)", path, kb)
 	return nil
 }
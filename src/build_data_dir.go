@@ -0,0 +1,71 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/config"
+)
+
+// buildDataDirEnv is the name of the env var that sets the dir that
+// contains the local build data (like the --build-data-dir flag). It
+// is also how the dir is passed to the 'src' subprocesses that need
+// it.
+const buildDataDirEnv = "SRCLIB_BUILD_DATA_DIR"
+
+// localBuildDataRoot returns the absolute path of the dir that
+// contains the local build data (in a subdir per commit) of the
+// repository whose top-level dir is repoDir. It is the first of: the
+// --build-data-dir flag, the SRCLIB_BUILD_DATA_DIR env var, the
+// repository's build data config (see config.BuildData), and the
+// .srclib-cache dir in repoDir.
+func localBuildDataRoot(repoDir string) (string, error) {
+	if dir := GlobalOpt.BuildDataDir; dir != "" {
+		return filepath.Abs(dir)
+	}
+	if dir := os.Getenv(buildDataDirEnv); dir != "" {
+		return filepath.Abs(dir)
+	}
+	c, err := config.ReadBuildData(repoDir)
+	if err != nil {
+		return "", fmt.Errorf("reading build data config from %s or %s: %s", config.Filename, config.RCFilename, err)
+	}
+	if dir, err := c.DirPath(repoDir); err != nil || dir != "" {
+		return dir, err
+	}
+	return filepath.Abs(filepath.Join(repoDir, buildstore.BuildDataDirName))
+}
+
+// localBuildStore returns the build store for the local build data of
+// the repository whose top-level dir is repoDir (see
+// localBuildDataRoot).
+func localBuildStore(repoDir string) (buildstore.RepoBuildStore, error) {
+	root, err := localBuildDataRoot(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	return buildstore.LocalRepoDir(root)
+}
+
+// makeBuildDataDir returns the dir that contains the local build data
+// of the repository whose top-level dir is repoDir at commitID, as it
+// is referred to in Makefiles (which are run in repoDir): relative to
+// repoDir if it is in the repository, and absolute otherwise.
+func makeBuildDataDir(repoDir, commitID string) (string, error) {
+	root, err := localBuildDataRoot(repoDir)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, commitID)
+	absRepoDir, err := filepath.Abs(repoDir)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(absRepoDir, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel, nil
+	}
+	return dir, nil
+}
@@ -0,0 +1,206 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kr/fs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+)
+
+type BuildDataGCCmd struct {
+	Keep    int           `long:"keep" description:"keep build data for the N most recently built commits of each repo (0 means no limit)" value-name:"N"`
+	MaxAge  time.Duration `long:"max-age" description:"remove build data for commits last built longer ago than this (e.g., 720h; 0 means no limit)"`
+	Remote  bool          `long:"remote" description:"remove the current repo's remote build data (on the HTTP build data server given by SRCLIB_BUILD_DATA_URL), not local build data"`
+	S3      string        `long:"s3" description:"remove the current repo's build data uploaded to S3 (s3://BUCKET/PATH) with 'src build-data upload --to', not local build data" value-name:"URL"`
+	Profile string        `long:"profile" description:"credential profile to use for S3 (see 'src auth'; with --s3)"`
+	DryRun  bool          `short:"n" long:"dry-run" description:"don't remove anything, just show what would be removed"`
+
+	Args struct {
+		Dirs []string `name:"DIR" description:"top-level dirs of repos whose local build data (.srclib-cache) to remove (default: the current repo)"`
+	} `positional-args:"yes"`
+}
+
+var buildDataGCCmd BuildDataGCCmd
+
+// buildDataCommit describes the build data for a commit in a repo
+// build store.
+type buildDataCommit struct {
+	CommitID string
+	ModTime  time.Time // latest mod time of the commit's build data files
+	Size     int64     // total size of the commit's build data files
+}
+
+type buildDataCommitsByModTime []*buildDataCommit
+
+func (v buildDataCommitsByModTime) Len() int           { return len(v) }
+func (v buildDataCommitsByModTime) Less(i, j int) bool { return v[i].ModTime.After(v[j].ModTime) }
+func (v buildDataCommitsByModTime) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+func (c *BuildDataGCCmd) Execute(args []string) error {
+	if c.Keep < 0 || c.MaxAge < 0 {
+		return newCmdError(ExitUsage, fmt.Errorf("--keep and --max-age must not be negative"))
+	}
+	if c.Keep == 0 && c.MaxAge == 0 {
+		return newCmdError(ExitUsage, fmt.Errorf("at least one of --keep and --max-age must be given"))
+	}
+	if c.Remote && c.S3 != "" {
+		return newCmdError(ExitUsage, fmt.Errorf("--remote and --s3 can't both be given"))
+	}
+	if (c.Remote || c.S3 != "") && len(c.Args.Dirs) > 0 {
+		return newCmdError(ExitUsage, fmt.Errorf("--remote and --s3 can't be used with DIR arguments (they only apply to the current repo)"))
+	}
+
+	var freed int64
+	if c.S3 != "" {
+		lrepo, err := openLocalRepo()
+		if err != nil {
+			return err
+		}
+		if freed, err = c.gcS3(lrepo.URI()); err != nil {
+			return err
+		}
+	} else if c.Remote {
+		lrepo, err := openLocalRepo()
+		if err != nil {
+			return err
+		}
+		base, err := httpBuildDataBaseURL()
+		if err != nil {
+			return err
+		}
+		if base == nil {
+			return fmt.Errorf("--remote requires an HTTP build data server (set SRCLIB_BUILD_DATA_URL); build data stored on Sourcegraph is removed by Sourcegraph, and build data uploaded to S3 is removed with --s3")
+		}
+		if lrepo.URI() == "" {
+			return fmt.Errorf("can't determine repo URI for remote build data")
+		}
+		header, err := httpBuildDataHeader()
+		if err != nil {
+			return err
+		}
+		root := buildstore.HTTP(httpBuildDataCommitURL(base, lrepo.URI(), ""), header)
+		if freed, err = c.gc(rwvfs.Walkable(root), "remote build data for "+lrepo.URI(), ""); err != nil {
+			return err
+		}
+	} else {
+		dirs := c.Args.Dirs
+		if len(dirs) == 0 {
+			lrepo, err := openLocalRepo()
+			if err != nil {
+				return err
+			}
+			dirs = []string{lrepo.RootDir}
+		}
+		for _, dir := range dirs {
+			root, err := localBuildDataRoot(dir)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(root); os.IsNotExist(err) {
+				logger.Debugf("Skipping %s (no build data)", dir)
+				continue
+			}
+
+			// Never remove build data for the checked-out commit.
+			var current string
+			if lrepo, err := OpenRepo(dir); err == nil {
+				current = lrepo.CommitID
+			}
+
+			n, err := c.gc(rwvfs.Walkable(rwvfs.OS(root)), root, current)
+			if err != nil {
+				return err
+			}
+			freed += n
+		}
+	}
+
+	verb := "Freed"
+	if c.DryRun {
+		verb = "Would free"
+	}
+	logger.Infof("# %s %.1fkb", verb, float64(freed)/1024)
+	return nil
+}
+
+// gc removes build data in the repo build store rooted at root for
+// commits that are not among the c.Keep most recently built or that
+// were built longer ago than c.MaxAge. It never removes build data
+// for the commit keepCommitID (if non-empty), or removes build data
+// by age if its mod time is unknown. It returns the number of bytes
+// freed.
+func (c *BuildDataGCCmd) gc(root rwvfs.WalkableFileSystem, label, keepCommitID string) (int64, error) {
+	s := buildstore.Repo(root)
+	commits, err := listBuildDataCommits(root, s)
+	if err != nil {
+		return 0, err
+	}
+	return c.gcCommits(commits, label, keepCommitID, func(commitID string) error {
+		return buildstore.RemoveAllDataForCommit(s, commitID)
+	})
+}
+
+// gcCommits calls remove for each of commits whose build data gc
+// should remove, and returns the number of bytes freed.
+func (c *BuildDataGCCmd) gcCommits(commits []*buildDataCommit, label, keepCommitID string, remove func(commitID string) error) (int64, error) {
+	sort.Sort(buildDataCommitsByModTime(commits))
+
+	var freed int64
+	kept := 0
+	for _, bc := range commits {
+		keep := bc.CommitID == keepCommitID ||
+			((c.Keep == 0 || kept < c.Keep) && (c.MaxAge == 0 || bc.ModTime.IsZero() || time.Since(bc.ModTime) <= c.MaxAge))
+		if keep {
+			kept++
+			continue
+		}
+		if GlobalOpt.Verbose || c.DryRun {
+			logger.Infof("Removing %s build data for commit %s (%.1fkb, last built %s)", label, bc.CommitID, float64(bc.Size)/1024, bc.ModTime.Format(time.RFC3339))
+		}
+		if !c.DryRun {
+			if err := remove(bc.CommitID); err != nil {
+				return freed, fmt.Errorf("removing %s build data for commit %s: %s", label, bc.CommitID, err)
+			}
+		}
+		freed += bc.Size
+	}
+	logger.Debugf("Kept build data for %d of %d commits in %s", kept, len(commits), label)
+	return freed, nil
+}
+
+// listBuildDataCommits lists the commits that the repo build store s
+// (rooted at root) has build data for.
+func listBuildDataCommits(root rwvfs.FileSystem, s buildstore.RepoBuildStore) ([]*buildDataCommit, error) {
+	fis, err := root.ReadDir(".")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var commits []*buildDataCommit
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		bc := &buildDataCommit{CommitID: fi.Name(), ModTime: fi.ModTime()}
+		w := fs.WalkFS(".", s.Commit(bc.CommitID))
+		for w.Step() {
+			if err := w.Err(); err != nil {
+				return nil, err
+			}
+			if fi := w.Stat(); fi.Mode().IsRegular() {
+				bc.Size += fi.Size()
+				if fi.ModTime().After(bc.ModTime) {
+					bc.ModTime = fi.ModTime()
+				}
+			}
+		}
+		commits = append(commits, bc)
+	}
+	return commits, nil
+}
@@ -0,0 +1,160 @@
+package src
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kr/s3"
+)
+
+// gcS3 removes the build data for repo that was uploaded to S3 (under
+// s3://BUCKET/PATH/REPO/, where s3://BUCKET/PATH is c.S3) with `src
+// build-data upload --to`. It returns the number of bytes freed.
+func (c *BuildDataGCCmd) gcS3(repo string) (int64, error) {
+	u, err := url.Parse(c.S3)
+	if err != nil {
+		return 0, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return 0, newCmdError(ExitUsage, fmt.Errorf("--s3 must be an S3 URL (s3://BUCKET/PATH), got %q", c.S3))
+	}
+	if repo == "" {
+		return 0, fmt.Errorf("can't determine repo URI for build data on S3")
+	}
+	keys, err := s3Keys(c.Profile)
+	if err != nil {
+		return 0, err
+	}
+
+	b := &s3Bucket{
+		base: &url.URL{Scheme: "https", Host: u.Host + ".s3.amazonaws.com", Path: "/"},
+		keys: keys,
+	}
+	prefix := strings.TrimPrefix(path.Join("/", u.Path, repo), "/") + "/"
+	objs, err := b.list(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	// Group the objects (at PREFIX/COMMIT/FILE) by commit.
+	var commits []*buildDataCommit
+	byCommit := map[string]*buildDataCommit{}
+	keysByCommit := map[string][]string{}
+	for _, obj := range objs {
+		rel := strings.TrimPrefix(obj.Key, prefix)
+		i := strings.Index(rel, "/")
+		if i <= 0 {
+			continue // not in a commit dir
+		}
+		commitID := rel[:i]
+		bc, present := byCommit[commitID]
+		if !present {
+			bc = &buildDataCommit{CommitID: commitID}
+			byCommit[commitID] = bc
+			commits = append(commits, bc)
+		}
+		bc.Size += obj.Size
+		if obj.LastModified.After(bc.ModTime) {
+			bc.ModTime = obj.LastModified
+		}
+		keysByCommit[commitID] = append(keysByCommit[commitID], obj.Key)
+	}
+
+	return c.gcCommits(commits, "s3://"+u.Host+"/"+prefix, "", func(commitID string) error {
+		for _, key := range keysByCommit[commitID] {
+			if err := b.remove(key); err != nil {
+				return err
+			}
+			logger.Debugf("Removed s3://%s/%s", u.Host, key)
+		}
+		return nil
+	})
+}
+
+// s3Bucket lists and removes objects in an S3 bucket.
+type s3Bucket struct {
+	base *url.URL // https://BUCKET.s3.amazonaws.com/
+	keys *s3.Keys
+}
+
+// An s3Object is an object in a listing of an S3 bucket.
+type s3Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+// s3ListBucketResult is the response of an S3 GET Bucket (List
+// Objects) request.
+type s3ListBucketResult struct {
+	IsTruncated bool
+	Contents    []s3Object
+}
+
+// list returns all objects whose keys begin with prefix.
+func (b *s3Bucket) list(prefix string) ([]s3Object, error) {
+	var objs []s3Object
+	marker := ""
+	for {
+		q := url.Values{"prefix": []string{prefix}}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		listURL := b.base.ResolveReference(&url.URL{RawQuery: q.Encode()}).String()
+		resp, err := b.do("GET", listURL)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: HTTP error: %s", listURL, resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %s", listURL, err)
+		}
+		objs = append(objs, result.Contents...)
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return objs, nil
+		}
+		// Without a delimiter, the listing continues after the last
+		// key returned.
+		marker = result.Contents[len(result.Contents)-1].Key
+	}
+}
+
+// remove removes the object whose key is key. It is not an error if
+// the object doesn't exist.
+func (b *s3Bucket) remove(key string) error {
+	objURL := b.base.ResolveReference(&url.URL{Path: key}).String()
+	resp, err := b.do("DELETE", objURL)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("DELETE %s: HTTP error: %s", objURL, resp.Status)
+}
+
+// do sends a signed request with no body to S3.
+func (b *s3Bucket) do(method, rawurl string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	s3.Sign(req, *b.keys)
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,86 @@
+package src
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+)
+
+// httpBuildDataBaseURL returns the base URL of the HTTP build data
+// server (see buildstore.HTTP) given by the SRCLIB_BUILD_DATA_URL env
+// var, or nil if it is not set (in which case remote build data is
+// stored on Sourcegraph).
+//
+// Build data for a repo at a commit is stored under
+// BASE/REPO/COMMIT/. The base URL's query string (e.g., a signature)
+// is sent with each request.
+func httpBuildDataBaseURL() (*url.URL, error) {
+	urlStr := os.Getenv("SRCLIB_BUILD_DATA_URL")
+	if urlStr == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SRCLIB_BUILD_DATA_URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("SRCLIB_BUILD_DATA_URL must be an http or https URL (got %q)", urlStr)
+	}
+	return u, nil
+}
+
+// httpBuildDataHeader returns the headers to send to the HTTP build
+// data server, which are given by the SRCLIB_BUILD_DATA_HEADER env var
+// as newline-separated "Name: value" pairs (e.g., "Authorization:
+// Bearer TOKEN" or "X-JFrog-Art-Api: KEY").
+func httpBuildDataHeader() (http.Header, error) {
+	h := http.Header{}
+	for _, line := range strings.Split(os.Getenv("SRCLIB_BUILD_DATA_HEADER"), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header in SRCLIB_BUILD_DATA_HEADER (want \"Name: value\"): %q", line)
+		}
+		h.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+	return h, nil
+}
+
+// httpBuildDataCommitURL returns the URL of the dir on the HTTP build
+// data server that holds build data for repo at commitID.
+func httpBuildDataCommitURL(base *url.URL, repo, commitID string) *url.URL {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path.Join(repo, commitID) + "/"
+	return &u
+}
+
+// httpBuildDataFileURL returns the URL of a build data file (for repo
+// at commitID) on the HTTP build data server.
+func httpBuildDataFileURL(base *url.URL, repo, commitID, file string) string {
+	u := httpBuildDataCommitURL(base, repo, commitID)
+	u.Path += strings.TrimPrefix(path.Clean("/"+file), "/")
+	return u.String()
+}
+
+// getHTTPBuildDataFS returns the build data VFS for repo at commitID
+// on the HTTP build data server at base.
+func getHTTPBuildDataFS(base *url.URL, repo, commitID string) (rwvfs.FileSystem, string, error) {
+	if repo == "" {
+		return nil, "", fmt.Errorf("can't determine repo URI for HTTP build data (at %s)", base.Host)
+	}
+	header, err := httpBuildDataHeader()
+	if err != nil {
+		return nil, "", err
+	}
+	u := httpBuildDataCommitURL(base, repo, commitID)
+	fs := buildstore.HTTP(u, header)
+	return fs, fmt.Sprintf("HTTP build data (repo %s, commit %s, at %s)", repo, commitID, fs), nil
+}
@@ -0,0 +1,208 @@
+package src
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kr/fs"
+	"github.com/kr/s3"
+	"github.com/kr/s3/s3util"
+
+	"code.google.com/p/rog-go/parallel"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// s3ChecksumHeader is the S3 object metadata header that holds the
+// MD5 checksum (in hex) of build data files uploaded with `src
+// build-data upload --to`. (S3 ETags of multipart uploads are not MD5
+// checksums, so they can't be used to detect changed files.)
+const s3ChecksumHeader = "X-Amz-Meta-Srclib-Md5"
+
+// s3Upload uploads build data files to S3 using multipart uploads,
+// skipping files that were already uploaded with the same checksum
+// (so an interrupted upload resumes where it left off).
+type s3Upload struct {
+	base   *url.URL // https://BUCKET.s3.amazonaws.com/PATH/REPO/COMMIT/
+	keys   *s3.Keys
+	dryRun bool
+
+	mu                sync.Mutex
+	uploaded, skipped int
+	uploadedBytes     int64
+}
+
+// uploadToS3 uploads the local build data in localFS (for repo at
+// commitID) to s3://BUCKET/PATH/REPO/COMMIT/, where s3://BUCKET/PATH
+// is c.To.
+func (c *BuildDataUploadCmd) uploadToS3(localFS rwvfs.FileSystem, repo string) error {
+	u, err := url.Parse(c.To)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("--to must be an S3 URL (s3://BUCKET/PATH), got %q", c.To))
+	}
+	if repo == "" {
+		return fmt.Errorf("can't determine repo URI to upload build data for")
+	}
+	if c.Parallel < 1 {
+		return newCmdError(ExitUsage, fmt.Errorf("--parallel must be positive"))
+	}
+	keys, err := s3Keys(c.Profile)
+	if err != nil {
+		return err
+	}
+
+	up := &s3Upload{
+		base: &url.URL{
+			Scheme: "https",
+			Host:   u.Host + ".s3.amazonaws.com",
+			Path:   path.Join("/", u.Path, repo, c.CommitID) + "/",
+		},
+		keys:   keys,
+		dryRun: c.DryRun,
+	}
+	logger.Debugf("Uploading build files to %s...", up.base)
+
+	par := parallel.NewRun(c.Parallel)
+	w := fs.WalkFS(".", rwvfs.Walkable(localFS))
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		fi := w.Stat()
+		if fi == nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		file := w.Path()
+		par.Do(func() error {
+			if err := up.uploadFile(localFS, file); err != nil {
+				return fmt.Errorf("uploading %s: %s", file, err)
+			}
+			return nil
+		})
+	}
+	if err := par.Wait(); err != nil {
+		return err
+	}
+
+	verb := "Uploaded"
+	if c.DryRun {
+		verb = "Would upload"
+	}
+	logger.Infof("# %s %d files (%.1fkb) to %s; skipped %d unchanged files", verb, up.uploaded, float64(up.uploadedBytes)/1024, c.To, up.skipped)
+	return nil
+}
+
+// uploadFile uploads a single file, unless the S3 object already has
+// the same checksum. After uploading, it checks that the S3 object
+// has the expected size and checksum.
+func (up *s3Upload) uploadFile(localFS rwvfs.FileSystem, file string) error {
+	sum, size, err := fileMD5(localFS, file)
+	if err != nil {
+		return err
+	}
+	objURL := up.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(path.Clean("/"+file), "/")}).String()
+
+	remoteSum, _, err := up.stat(objURL)
+	if err != nil {
+		return err
+	}
+	if remoteSum == sum {
+		logger.Debugf("Skipping %s (unchanged)", file)
+		up.mu.Lock()
+		up.skipped++
+		up.mu.Unlock()
+		return nil
+	}
+
+	if GlobalOpt.Verbose || up.dryRun {
+		logger.Infof("Uploading %s (%.1fkb)", file, float64(size)/1024)
+	}
+	if !up.dryRun {
+		lf, err := localFS.Open(file)
+		if err != nil {
+			return err
+		}
+		defer lf.Close()
+
+		h := http.Header{}
+		h.Set(s3ChecksumHeader, sum)
+		w, err := s3util.Create(objURL, h, &s3util.Config{Service: s3.DefaultService, Keys: up.keys})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, lf); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		gotSum, gotSize, err := up.stat(objURL)
+		if err != nil {
+			return err
+		}
+		if gotSum != sum || gotSize != size {
+			return fmt.Errorf("uploaded object %s has checksum %q and size %d, want checksum %q and size %d", objURL, gotSum, gotSize, sum, size)
+		}
+		logger.Debugf("Uploaded %s (%.1fkb)", file, float64(size)/1024)
+	}
+
+	up.mu.Lock()
+	up.uploaded++
+	up.uploadedBytes += size
+	up.mu.Unlock()
+	return nil
+}
+
+// stat returns the checksum (from s3ChecksumHeader) and size of the S3
+// object at objURL. If the object does not exist, it returns an empty
+// checksum and no error.
+func (up *s3Upload) stat(objURL string) (sum string, size int64, err error) {
+	req, err := http.NewRequest("HEAD", objURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	s3.Sign(req, *up.keys)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		return resp.Header.Get(s3ChecksumHeader), size, nil
+	case http.StatusNotFound:
+		return "", 0, nil
+	}
+	return "", 0, fmt.Errorf("HEAD %s: HTTP error: %s", objURL, resp.Status)
+}
+
+// fileMD5 returns the MD5 checksum (in hex) and size of a file.
+func fileMD5(fs rwvfs.FileSystem, file string) (string, int64, error) {
+	f, err := fs.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := md5.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
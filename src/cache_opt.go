@@ -3,4 +3,6 @@ package src
 type BuildCacheOpt struct {
 	NoCacheRead  bool `long:"no-cache-read" description:"do not read from build cache"`
 	NoCacheWrite bool `long:"no-cache-write" description:"do not write results to build cache"`
+
+	NoDepresolveCache bool `long:"no-depresolve-cache" description:"do not use the depresolve cache shared across repositories (in SRCLIBCACHE)"`
 }
@@ -1,6 +1,7 @@
 package src
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
@@ -15,11 +16,18 @@ import (
 	"sourcegraph.com/sourcegraph/go-sourcegraph/sourcegraph"
 )
 
-var CLI = flags.NewNamedParser("src", flags.Default)
+// CLI is the src command-line parser. It does not print errors
+// itself; Main prints them (see printError).
+var CLI = flags.NewNamedParser("src", flags.HelpFlag|flags.PassDoubleDash)
 
 // GlobalOpt contains global options.
 var GlobalOpt struct {
 	Verbose bool `short:"v" description:"show verbose output"`
+
+	LogLevel  logLevel  `long:"log-level" description:"minimum level of log messages to show (debug, info, warn, or error); -v implies debug" default:"info"`
+	LogFormat msgFormat `long:"log-format" description:"format of log messages (text or json)" default:"text"`
+
+	BuildDataDir string `long:"build-data-dir" description:"dir that contains the local build data, instead of .srclib-cache in the repository (overrides SRCLIB_BUILD_DATA_DIR and the BuildData.Dir setting in the Srcfile or .srclibrc)" value-name:"DIR"`
 }
 
 func init() {
@@ -121,5 +129,12 @@ func Main() error {
 	log.SetPrefix("")
 
 	_, err := CLI.Parse()
+	if err, ok := err.(*flags.Error); ok && err.Type == flags.ErrHelp {
+		fmt.Fprintln(os.Stdout, err)
+		return nil
+	}
+	if err != nil {
+		printError(err)
+	}
 	return err
 }
@@ -0,0 +1,304 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	_, err := CLI.AddCommand("completion",
+		"print shell completion script",
+		`The completion command prints a script that sets up tab completion of src's commands and flags (and of --repo, --commit, and --unit values, which are read from the store) for the given shell (bash, zsh, or fish).
+
+To enable completion, add one of the following to your shell's startup file:
+
+  bash:  eval "$(src completion bash)"
+  zsh:   eval "$(src completion zsh)"
+  fish:  src completion fish | source`,
+		&completionCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type CompletionCmd struct {
+	Args struct {
+		Shell string `name:"SHELL" description:"shell to print completion script for (bash, zsh, or fish)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var completionCmd CompletionCmd
+
+func (c *CompletionCmd) Execute(args []string) error {
+	cmds := completionCommands(CLI.Command)
+	switch c.Args.Shell {
+	case "bash":
+		writeBashCompletion(os.Stdout, cmds)
+	case "zsh":
+		fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(os.Stdout, cmds)
+	case "fish":
+		writeFishCompletion(os.Stdout, cmds)
+	default:
+		return fmt.Errorf("unsupported shell %q (supported shells are bash, zsh, and fish)", c.Args.Shell)
+	}
+	return nil
+}
+
+// completionCommand describes a (sub)command for completion.
+type completionCommand struct {
+	path    string   // space-separated names of the command and its parents ("" for src)
+	subcmds []string // names of subcommands
+	flags   []*flags.Option
+}
+
+// completionValueFlags are the long names of flags whose values are
+// completed by querying the store (see StoreCompleteCmd).
+var completionValueFlags = map[string]bool{"repo": true, "commit": true, "unit": true}
+
+// completionCommands returns the command tree rooted at cmd, in
+// depth-first order. Each command's flags include those of its
+// parents, because go-flags accepts them after the subcommand name.
+func completionCommands(cmd *flags.Command) []*completionCommand {
+	var cmds []*completionCommand
+	var walk func(cmd *flags.Command, path string, parentFlags []*flags.Option)
+	walk = func(cmd *flags.Command, path string, parentFlags []*flags.Option) {
+		c := &completionCommand{path: path}
+		c.flags = append(append(c.flags, parentFlags...), groupOptions(cmd.Group)...)
+		for _, sub := range cmd.Commands() {
+			c.subcmds = append(c.subcmds, sub.Name)
+		}
+		sort.Strings(c.subcmds)
+		cmds = append(cmds, c)
+		for _, sub := range cmd.Commands() {
+			walk(sub, strings.TrimSpace(path+" "+sub.Name), c.flags)
+		}
+	}
+	walk(cmd, "", nil)
+	return cmds
+}
+
+// groupOptions returns the options in g and its child groups.
+func groupOptions(g *flags.Group) []*flags.Option {
+	opts := g.Options()
+	for _, child := range g.Groups() {
+		opts = append(opts, groupOptions(child)...)
+	}
+	return opts
+}
+
+// takesArg returns whether opt takes a value (i.e., whether it is
+// not a bool flag).
+func takesArg(opt *flags.Option) bool {
+	_, isBool := opt.Value().(bool)
+	return !isBool
+}
+
+func writeBashCompletion(w io.Writer, cmds []*completionCommand) {
+	var buf bytes.Buffer
+	buf.WriteString("# bash completion for src (generated by `src completion bash`)\n\n")
+
+	buf.WriteString("_src_subcmds() {\n\tcase \"$1\" in\n")
+	for _, c := range cmds {
+		if len(c.subcmds) > 0 {
+			fmt.Fprintf(&buf, "\t\"%s\") echo %q ;;\n", c.path, strings.Join(c.subcmds, " "))
+		}
+	}
+	buf.WriteString("\tesac\n}\n\n")
+
+	buf.WriteString("_src_flags() {\n\tcase \"$1\" in\n")
+	for _, c := range cmds {
+		var names []string
+		for _, opt := range c.flags {
+			if opt.LongName != "" {
+				names = append(names, "--"+opt.LongName)
+			}
+			if opt.ShortName != 0 {
+				names = append(names, "-"+string(opt.ShortName))
+			}
+		}
+		fmt.Fprintf(&buf, "\t\"%s\") echo %q ;;\n", c.path, strings.Join(names, " "))
+	}
+	buf.WriteString("\tesac\n}\n\n")
+
+	// Store flags (--root, --type, --config, and --profile) are passed along
+	// when querying the store for completion values.
+	buf.WriteString(`_src() {
+	local cur prev path="" storeflags=() i w
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	for ((i=1; i < COMP_CWORD; i++)); do
+		w="${COMP_WORDS[i]}"
+		case "$w" in
+		-r|--root|-t|--type|--config|--profile) storeflags+=("$w" "${COMP_WORDS[i+1]}") ;;
+		-*) ;;
+		*) if [[ " $(_src_subcmds "$path") " == *" $w "* ]]; then path="${path:+$path }$w"; fi ;;
+		esac
+	done
+
+	case "$prev" in
+`)
+	var valueFlags []string
+	for name := range completionValueFlags {
+		valueFlags = append(valueFlags, "--"+name)
+	}
+	sort.Strings(valueFlags)
+	fmt.Fprintf(&buf, "\t%s)\n", strings.Join(valueFlags, "|"))
+	buf.WriteString(`		COMPREPLY=($(compgen -W "$(src store "${storeflags[@]}" complete "${prev#--}" 2>/dev/null)" -- "$cur"))
+		return ;;
+	esac
+
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "$(_src_flags "$path")" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "$(_src_subcmds "$path")" -- "$cur"))
+	fi
+}
+
+complete -o default -F _src src
+`)
+	w.Write(buf.Bytes())
+}
+
+func writeFishCompletion(w io.Writer, cmds []*completionCommand) {
+	var buf bytes.Buffer
+	buf.WriteString("# fish completion for src (generated by `src completion fish`)\n\n")
+
+	buf.WriteString("function __src_subcmds\n\tswitch \"$argv\"\n")
+	for _, c := range cmds {
+		if len(c.subcmds) > 0 {
+			fmt.Fprintf(&buf, "\tcase '%s'\n\t\tprintf '%%s\\n' %s\n", c.path, strings.Join(c.subcmds, " "))
+		}
+	}
+	buf.WriteString("\tend\nend\n\n")
+
+	buf.WriteString(`function __src_path
+	set -l path
+	for w in (commandline -opc)[2..-1]
+		if contains -- $w (__src_subcmds "$path")
+			set path $path $w
+		end
+	end
+	echo "$path"
+end
+
+function __src_path_is
+	test (__src_path) = "$argv"
+end
+
+function __src_store_complete
+	set -l storeflags
+	set -l words (commandline -opc)
+	for i in (seq 2 (count $words))
+		switch $words[$i]
+		case -r --root -t --type --config --profile
+			set storeflags $storeflags $words[$i] $words[(math $i + 1)]
+		end
+	end
+	src store $storeflags complete $argv 2>/dev/null
+end
+
+complete -c src -f -a '(__src_subcmds (__src_path))'
+`)
+	for _, c := range cmds {
+		for _, opt := range c.flags {
+			fmt.Fprintf(&buf, "complete -c src -n '__src_path_is %s'", c.path)
+			if opt.LongName != "" {
+				fmt.Fprintf(&buf, " -l %s", opt.LongName)
+			}
+			if opt.ShortName != 0 {
+				fmt.Fprintf(&buf, " -s %c", opt.ShortName)
+			}
+			if completionValueFlags[opt.LongName] {
+				fmt.Fprintf(&buf, " -x -a '(__src_store_complete %s)'", opt.LongName)
+			} else if takesArg(opt) {
+				buf.WriteString(" -r")
+			}
+			if opt.Description != "" {
+				fmt.Fprintf(&buf, " -d %s", fishQuote(opt.Description))
+			}
+			buf.WriteString("\n")
+		}
+	}
+	w.Write(buf.Bytes())
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// StoreCompleteCmd prints completion values (used by the completion
+// scripts).
+type StoreCompleteCmd struct {
+	Args struct {
+		Kind string `name:"KIND" description:"kind of values to print (repo, commit, or unit)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var storeCompleteCmd StoreCompleteCmd
+
+func (c *StoreCompleteCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]struct{}{}
+	var vals []string
+	add := func(v string) {
+		if _, ok := seen[v]; !ok && v != "" {
+			seen[v] = struct{}{}
+			vals = append(vals, v)
+		}
+	}
+
+	switch c.Args.Kind {
+	case "repo":
+		if mrs, ok := s.(store.MultiRepoStore); ok {
+			repos, err := mrs.Repos()
+			if err != nil && !store.IsNotExist(err) {
+				return err
+			}
+			for _, repo := range repos {
+				add(repo)
+			}
+		}
+	case "commit":
+		if rs, ok := s.(store.RepoStore); ok {
+			versions, err := rs.Versions()
+			if err != nil && !store.IsNotExist(err) {
+				return err
+			}
+			for _, v := range versions {
+				add(v.CommitID)
+			}
+		}
+	case "unit":
+		if ts, ok := s.(store.TreeStore); ok {
+			units, err := ts.Units()
+			if err != nil && !store.IsNotExist(err) {
+				return err
+			}
+			for _, u := range units {
+				add(u.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown completion value kind %q (valid kinds are repo, commit, and unit)", c.Args.Kind)
+	}
+
+	sort.Strings(vals)
+	for _, v := range vals {
+		fmt.Println(v)
+	}
+	return nil
+}
@@ -12,7 +12,6 @@ import (
 	"sort"
 
 	"sourcegraph.com/sourcegraph/rwvfs"
-	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
 	"sourcegraph.com/sourcegraph/srclib/plan"
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
@@ -130,7 +129,7 @@ func (c *ConfigCmd) Execute(args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to open repo: %s", err)
 	}
-	buildStore, err := buildstore.LocalRepo(localRepo.RootDir)
+	buildStore, err := localBuildStore(localRepo.RootDir)
 	if err != nil {
 		return err
 	}
@@ -158,6 +157,43 @@ func (c *ConfigCmd) Execute(args []string) error {
 				log.Fatal(err)
 			}
 		}
+
+		// Record the overlays so that ReadCached applies them.
+		if len(cfg.Overlays) > 0 {
+			f, err := commitFS.Create(config.OverlaysFilename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := json.NewEncoder(f).Encode(cfg.Overlays); err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		} else if err := commitFS.Remove(config.OverlaysFilename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Record the SkipPaths in the plan, so that units aren't
+		// built with skipped files.
+		if len(cfg.SkipPaths) > 0 {
+			f, err := commitFS.Create(config.SkipPathsFilename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			for _, p := range cfg.SkipPaths {
+				if _, err := fmt.Fprintln(f, p); err != nil {
+					return err
+				}
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		} else if err := commitFS.Remove(config.SkipPathsFilename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
 	if c.Output.Output == "json" {
@@ -205,6 +241,9 @@ func runPreConfigCommands(dir string, cmds []string, execOpt ToolchainExecOpt, q
 		for _, cmdStr := range cmds {
 			cmd := exec.Command("sh", "-c", cmdStr)
 			cmd.Dir = dir
+			if execOpt.Offline {
+				cmd.Env = append(os.Environ(), toolchain.OfflineEnv+"=1")
+			}
 			if !quiet {
 				cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
 			}
@@ -259,7 +298,11 @@ WORKDIR /src
 		}
 
 		for _, cmdStr := range cmds {
-			cmd := exec.Command("docker", "run", "-v", dir+":/src", "--rm", "--entrypoint=/bin/bash", containerName)
+			cmd := exec.Command("docker", "run", "-v", dir+":/src", "--rm", "--entrypoint=/bin/bash")
+			if execOpt.Offline {
+				cmd.Args = append(cmd.Args, "--net=none", "--env="+toolchain.OfflineEnv+"=1")
+			}
+			cmd.Args = append(cmd.Args, containerName)
 			cmd.Args = append(cmd.Args, "-c", cmdStr)
 			if !quiet {
 				cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
@@ -0,0 +1,317 @@
+package src
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	docsGroup, err := CLI.AddCommand("docs",
+		"documentation commands",
+		"The docs command group contains subcommands for generating documentation from the graph store.",
+		&docsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = docsGroup.AddCommand("generate",
+		"generate HTML docs",
+		"The generate command renders an HTML documentation site from the defs and docs in the store. It writes an index page and one page per source unit. Defs that refer to other documented defs link to them (using the stored refs). The store must already contain imported data (see `src store import`).",
+		&docsGenerateCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = docsGroup.AddCommand("markdown",
+		"generate Markdown API reference docs",
+		"The markdown command renders Markdown API reference docs from the defs, docs, and refs in the store, suitable for committing into a docs site. It writes an index page (README.md), a page for each source unit (UNIT/README.md), and a page for each def (UNIT/DEF.md) with its signature (if its toolchain registered a def formatter), docs, and example refs. Pages cross-link the defs that each def uses and is used by. Example refs' lines are shown if the current repository is checked out at the documented commit.",
+		&docsMarkdownCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type DocsCmd struct{}
+
+var docsCmd DocsCmd
+
+func (c *DocsCmd) Execute(args []string) error { return nil }
+
+type DocsGenerateCmd struct {
+	Repo     string `long:"repo"`
+	UnitType string `long:"unit-type" `
+	Unit     string `long:"unit"`
+	CommitID string `long:"commit"`
+
+	All bool `long:"all" description:"also document unexported defs (local defs are never documented)"`
+
+	OutDir string `short:"o" long:"out" description:"output directory" default:"docs"`
+}
+
+var docsGenerateCmd DocsGenerateCmd
+
+func (c *DocsGenerateCmd) Execute(args []string) error {
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		return fmt.Errorf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+	}
+
+	defsCmd := StoreDefsCmd{
+		Repo:     c.Repo,
+		UnitType: c.UnitType,
+		Unit:     c.Unit,
+		CommitID: c.CommitID,
+		Filter: store.DefFilterFunc(func(def *graph.Def) bool {
+			return !def.Local && (def.Exported || c.All)
+		}),
+	}
+	defs, err := defsCmd.Get()
+	if err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		return fmt.Errorf("no defs found in store to document (did you run `src store import`?)")
+	}
+
+	refs, err := c.refs()
+	if err != nil {
+		return err
+	}
+
+	site := newDocsSite(defs, refs)
+	if err := os.MkdirAll(c.OutDir, 0755); err != nil {
+		return err
+	}
+	if err := writeDocsPage(filepath.Join(c.OutDir, "index.html"), docsIndexTmpl, site); err != nil {
+		return err
+	}
+	for _, p := range site.Units {
+		if err := writeDocsPage(filepath.Join(c.OutDir, p.Filename), docsUnitTmpl, p); err != nil {
+			return err
+		}
+	}
+	logger.Debugf("Wrote docs for %d defs in %d source units to %s.", len(defs), len(site.Units), c.OutDir)
+	return nil
+}
+
+// refs returns the refs in the scope of the documented defs. They
+// are used to cross-link the generated docs.
+func (c *DocsGenerateCmd) refs() ([]*graph.Ref, error) {
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing refs", s)
+	}
+
+	var fs []store.RefFilter
+	if c.UnitType != "" && c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	if c.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(c.CommitID))
+	}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	fs = append(fs, store.Unordered())
+	return us.Refs(fs...)
+}
+
+// docsSite is a generated documentation site.
+type docsSite struct {
+	Units []*docsUnitPage
+
+	// anchors maps the (commit-independent) key of each documented
+	// def to its URL in the site.
+	anchors map[graph.DefKey]string
+}
+
+// docsUnitPage is the page that documents a single source unit.
+type docsUnitPage struct {
+	Unit     unit.ID2
+	Filename string
+	Defs     []*docsDef
+}
+
+// docsDef is a documented def.
+type docsDef struct {
+	*graph.Def
+	Anchor string
+	Doc    template.HTML
+	Uses   []docsLink
+}
+
+type docsLink struct {
+	Name, URL string
+}
+
+func newDocsSite(defs []*graph.Def, refs []*graph.Ref) *docsSite {
+	site := &docsSite{anchors: map[graph.DefKey]string{}}
+
+	pages := map[unit.ID2]*docsUnitPage{}
+	var docDefs []*docsDef
+	for _, def := range defs {
+		u := unit.ID2{Type: def.UnitType, Name: def.Unit}
+		p, present := pages[u]
+		if !present {
+			p = &docsUnitPage{Unit: u, Filename: docsUnitFilename(u)}
+			pages[u] = p
+			site.Units = append(site.Units, p)
+		}
+		d := &docsDef{Def: def, Anchor: def.Path, Doc: docsHTML(def.Docs)}
+		p.Defs = append(p.Defs, d)
+		docDefs = append(docDefs, d)
+		site.anchors[docsDefKey(def.DefKey)] = p.Filename + "#" + d.Anchor
+	}
+	sort.Sort(docsUnitPagesByID(site.Units))
+
+	// Link each def to the documented defs that it refers to.
+	uses := docsUses(defs, refs, func(k graph.DefKey) bool {
+		_, present := site.anchors[k]
+		return present
+	})
+	for _, d := range docDefs {
+		for _, target := range uses[d.Def] {
+			d.Uses = append(d.Uses, docsLink{Name: target.Path, URL: site.anchors[target]})
+		}
+	}
+	return site
+}
+
+// docsUses returns, for each def in defs, the keys (see docsDefKey)
+// of the other documented defs that it refers to (i.e., that are
+// referred to from within its definition), in the order of their
+// first refs.
+func docsUses(defs []*graph.Def, refs []*graph.Ref, documented func(graph.DefKey) bool) map[*graph.Def][]graph.DefKey {
+	refsByFile := map[string][]*graph.Ref{}
+	for _, ref := range refs {
+		if ref.Def {
+			continue
+		}
+		k := docsFileKey(ref.Repo, ref.CommitID, ref.File)
+		refsByFile[k] = append(refsByFile[k], ref)
+	}
+	uses := map[*graph.Def][]graph.DefKey{}
+	for _, def := range defs {
+		seen := map[graph.DefKey]struct{}{}
+		for _, ref := range refsByFile[docsFileKey(def.Repo, def.CommitID, def.File)] {
+			if ref.Start < def.DefStart || ref.End > def.DefEnd {
+				continue
+			}
+			target := docsDefKey(ref.DefKey())
+			if !documented(target) || target == docsDefKey(def.DefKey) {
+				continue
+			}
+			if _, dup := seen[target]; dup {
+				continue
+			}
+			seen[target] = struct{}{}
+			uses[def] = append(uses[def], target)
+		}
+	}
+	return uses
+}
+
+// docsTemplate is an html/template or text/template template.
+type docsTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+func writeDocsPage(filename string, tmpl docsTemplate, data interface{}) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	return tmpl.Execute(f, data)
+}
+
+// docsDefKey returns the def key with an empty CommitID, so that
+// refs (which don't specify the commit of the def they refer to) can
+// be matched to defs.
+func docsDefKey(k graph.DefKey) graph.DefKey {
+	k.CommitID = ""
+	return k
+}
+
+func docsFileKey(repo, commitID, file string) string {
+	return repo + "@" + commitID + ":" + file
+}
+
+// docsUnitFilename returns the (flat) filename of the page that
+// documents the source unit u.
+func docsUnitFilename(u unit.ID2) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(u.Type+"-"+u.Name) + ".html"
+}
+
+// docsHTML returns the HTML rendering of a def's docs. HTML docs are
+// used as-is (they are generated by the toolchain from the source
+// code); other formats are escaped and preformatted.
+func docsHTML(docs []graph.DefDoc) template.HTML {
+	for _, doc := range docs {
+		if doc.Format == "text/html" {
+			return template.HTML(doc.Data)
+		}
+	}
+	if len(docs) > 0 {
+		return template.HTML("<pre>" + template.HTMLEscapeString(docs[0].Data) + "</pre>")
+	}
+	return ""
+}
+
+type docsUnitPagesByID []*docsUnitPage
+
+func (v docsUnitPagesByID) Len() int      { return len(v) }
+func (v docsUnitPagesByID) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v docsUnitPagesByID) Less(i, j int) bool {
+	a, b := v[i].Unit, v[j].Unit
+	return a.Type < b.Type || (a.Type == b.Type && a.Name < b.Name)
+}
+
+var docsIndexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Documentation</title></head>
+<body>
+<h1>Documentation</h1>
+<ul>
+{{range .Units}}<li><a href="{{.Filename}}">{{.Unit.Name}}</a> ({{.Unit.Type}}, {{len .Defs}} defs)</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+var docsUnitTmpl = template.Must(template.New("unit").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Unit.Name}}</title></head>
+<body>
+<p><a href="index.html">Index</a></p>
+<h1>{{.Unit.Name}} <small>({{.Unit.Type}})</small></h1>
+{{range .Defs}}<div class="def">
+<h2 id="{{.Anchor}}">{{.Name}}{{with .Kind}} <small>{{.}}</small>{{end}}</h2>
+<p class="location">{{.File}}</p>
+{{.Doc}}
+{{with .Uses}}<p class="uses">Uses: {{range $i, $l := .}}{{if $i}}, {{end}}<a href="{{$l.URL}}">{{$l.Name}}</a>{{end}}</p>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
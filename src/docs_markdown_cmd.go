@@ -0,0 +1,314 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+type DocsMarkdownCmd struct {
+	Repo     string `long:"repo"`
+	UnitType string `long:"unit-type" `
+	Unit     string `long:"unit"`
+	CommitID string `long:"commit"`
+
+	All      bool `long:"all" description:"also document unexported defs (local defs are never documented)"`
+	Examples int  `long:"examples" description:"max number of example refs to show for each def" default:"3"`
+
+	OutDir string `short:"o" long:"out" description:"output directory" default:"docs"`
+}
+
+var docsMarkdownCmd DocsMarkdownCmd
+
+func (c *DocsMarkdownCmd) Execute(args []string) error {
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		return fmt.Errorf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+	}
+
+	defsCmd := StoreDefsCmd{
+		Repo:     c.Repo,
+		UnitType: c.UnitType,
+		Unit:     c.Unit,
+		CommitID: c.CommitID,
+		Filter: store.DefFilterFunc(func(def *graph.Def) bool {
+			return !def.Local && (def.Exported || c.All)
+		}),
+	}
+	defs, err := defsCmd.Get()
+	if err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		return fmt.Errorf("no defs found in store to document (did you run `src store import`?)")
+	}
+
+	// Examples can come from any source unit, so don't limit the refs
+	// to the documented unit.
+	refs, err := (&DocsGenerateCmd{Repo: c.Repo, CommitID: c.CommitID}).refs()
+	if err != nil {
+		return err
+	}
+
+	site := newMarkdownDocs(defs, refs, c.Examples)
+	if err := os.MkdirAll(c.OutDir, 0755); err != nil {
+		return err
+	}
+	if err := writeDocsPage(filepath.Join(c.OutDir, "README.md"), mdIndexTmpl, site); err != nil {
+		return err
+	}
+	for _, u := range site.Units {
+		filename := filepath.Join(c.OutDir, filepath.FromSlash(u.Filename))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := writeDocsPage(filename, mdUnitTmpl, u); err != nil {
+			return err
+		}
+		for _, d := range u.Defs {
+			if err := writeDocsPage(filepath.Join(c.OutDir, filepath.FromSlash(d.Filename)), mdDefTmpl, d); err != nil {
+				return err
+			}
+		}
+	}
+	logger.Debugf("Wrote Markdown docs for %d defs in %d source units to %s.", len(defs), len(site.Units), c.OutDir)
+	return nil
+}
+
+// mdSite is a generated Markdown documentation site. Its pages are
+// README.md (the index), UNITDIR/README.md for each source unit, and
+// UNITDIR/DEF.md for each def.
+type mdSite struct {
+	Units []*mdUnitPage
+}
+
+type mdUnitPage struct {
+	Unit     unit.ID2
+	Filename string
+	Defs     []*mdDefPage
+}
+
+type mdDefPage struct {
+	*graph.Def
+	Filename  string
+	Unit      *mdUnitPage
+	Line      int    // line of the def's start (0 if unknown)
+	Signature string // see defSignature
+	Doc       string
+	Uses      []mdLink // documented defs that this def refers to
+	UsedBy    []mdLink // documented defs that refer to this def
+	Examples  []mdExample
+}
+
+type mdLink struct {
+	Name, URL string
+}
+
+// mdExample is a ref to a def, shown as an example of its use.
+type mdExample struct {
+	File    string
+	Line    int    // 0 if unknown
+	Snippet string // the ref's line of code ("" if unknown)
+}
+
+func newMarkdownDocs(defs []*graph.Def, refs []*graph.Ref, maxExamples int) *mdSite {
+	site := &mdSite{}
+	files := map[string][]byte{}
+	units := map[unit.ID2]*mdUnitPage{}
+	pages := map[graph.DefKey]*mdDefPage{}
+	for _, def := range defs {
+		u := unit.ID2{Type: def.UnitType, Name: def.Unit}
+		up, present := units[u]
+		if !present {
+			dir := strings.TrimSuffix(docsUnitFilename(u), ".html")
+			up = &mdUnitPage{Unit: u, Filename: dir + "/README.md"}
+			units[u] = up
+			site.Units = append(site.Units, up)
+		}
+		p := &mdDefPage{
+			Def:       def,
+			Filename:  path.Dir(up.Filename) + "/" + mdDefFilename(def.Path),
+			Unit:      up,
+			Signature: defSignature(def),
+			Doc:       docsMarkdown(def.Docs),
+		}
+		if src := localRepoFile(def.Repo, def.CommitID, def.File, files); src != nil && int(def.DefStart) <= len(src) {
+			p.Line, _ = lineCol(src, int(def.DefStart))
+		}
+		up.Defs = append(up.Defs, p)
+		pages[docsDefKey(def.DefKey)] = p
+	}
+	sort.Sort(mdUnitPagesByID(site.Units))
+	for _, up := range site.Units {
+		sort.Sort(mdDefPagesByName(up.Defs))
+	}
+
+	uses := docsUses(defs, refs, func(k graph.DefKey) bool {
+		_, present := pages[k]
+		return present
+	})
+	for _, def := range defs {
+		from := pages[docsDefKey(def.DefKey)]
+		for _, k := range uses[def] {
+			to := pages[k]
+			from.Uses = append(from.Uses, mdLink{Name: to.Path, URL: mdRelLink(from.Filename, to.Filename)})
+			to.UsedBy = append(to.UsedBy, mdLink{Name: from.Path, URL: mdRelLink(to.Filename, from.Filename)})
+		}
+	}
+
+	// Add examples: the first refs (in file order) to each def, at
+	// most one per file so that they show varied uses.
+	if maxExamples > 0 {
+		sorted := make([]*graph.Ref, 0, len(refs))
+		for _, ref := range refs {
+			if !ref.Def {
+				sorted = append(sorted, ref)
+			}
+		}
+		sort.Sort(mdRefsByLocation(sorted))
+		exampleFiles := map[*mdDefPage]map[string]struct{}{}
+		for _, ref := range sorted {
+			p, present := pages[docsDefKey(ref.DefKey())]
+			if !present || len(p.Examples) >= maxExamples {
+				continue
+			}
+			if exampleFiles[p] == nil {
+				exampleFiles[p] = map[string]struct{}{}
+			}
+			if _, seen := exampleFiles[p][ref.File]; seen {
+				continue
+			}
+			exampleFiles[p][ref.File] = struct{}{}
+			ex := mdExample{File: ref.File}
+			if src := localRepoFile(ref.Repo, ref.CommitID, ref.File, files); src != nil && int(ref.End) <= len(src) {
+				ex.Line, _ = lineCol(src, int(ref.Start))
+				ex.Snippet = lineAt(src, int(ref.Start))
+			}
+			p.Examples = append(p.Examples, ex)
+		}
+	}
+	return site
+}
+
+// mdDefFilename returns the filename of the page that documents the
+// def with the given path.
+func mdDefFilename(defPath string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_", " ", "_").Replace(defPath) + ".md"
+}
+
+// mdRelLink returns the relative URL of the page to from the page
+// from (both are slash-separated paths relative to the output dir).
+func mdRelLink(from, to string) string {
+	if path.Dir(from) == path.Dir(to) {
+		return path.Base(to)
+	}
+	return strings.Repeat("../", strings.Count(path.Dir(from), "/")+1) + to
+}
+
+// lineAt returns the line of src that contains the byte offset ofs,
+// with surrounding whitespace trimmed.
+func lineAt(src []byte, ofs int) string {
+	start := bytes.LastIndex(src[:ofs], []byte("\n")) + 1
+	end := bytes.Index(src[ofs:], []byte("\n"))
+	if end == -1 {
+		end = len(src)
+	} else {
+		end += ofs
+	}
+	return strings.TrimSpace(string(src[start:end]))
+}
+
+// docsMarkdown returns the Markdown rendering of a def's docs. Markdown
+// and plain-text docs are used as-is, and HTML docs are embedded (as
+// Markdown allows).
+func docsMarkdown(docs []graph.DefDoc) string {
+	for _, format := range []string{"text/x-markdown", "text/plain", "text/html"} {
+		for _, doc := range docs {
+			if doc.Format == format {
+				return strings.TrimSpace(doc.Data)
+			}
+		}
+	}
+	if len(docs) > 0 {
+		return strings.TrimSpace(docs[0].Data)
+	}
+	return ""
+}
+
+type mdUnitPagesByID []*mdUnitPage
+
+func (v mdUnitPagesByID) Len() int      { return len(v) }
+func (v mdUnitPagesByID) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v mdUnitPagesByID) Less(i, j int) bool {
+	a, b := v[i].Unit, v[j].Unit
+	return a.Type < b.Type || (a.Type == b.Type && a.Name < b.Name)
+}
+
+type mdDefPagesByName []*mdDefPage
+
+func (v mdDefPagesByName) Len() int      { return len(v) }
+func (v mdDefPagesByName) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v mdDefPagesByName) Less(i, j int) bool {
+	return v[i].Name < v[j].Name || (v[i].Name == v[j].Name && v[i].Path < v[j].Path)
+}
+
+type mdRefsByLocation []*graph.Ref
+
+func (v mdRefsByLocation) Len() int      { return len(v) }
+func (v mdRefsByLocation) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v mdRefsByLocation) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Start < b.Start
+}
+
+var mdIndexTmpl = template.Must(template.New("index").Parse(`# Documentation
+{{range .Units}}
+- [{{.Unit.Name}}]({{.Filename}}) ({{.Unit.Type}}, {{len .Defs}} defs){{end}}
+`))
+
+var mdUnitTmpl = template.Must(template.New("unit").Funcs(template.FuncMap{"base": path.Base}).Parse(`[Index](../README.md)
+
+# {{.Unit.Name}}
+
+{{.Unit.Type}} source unit.
+{{range .Defs}}
+- [{{.Name}}]({{.Filename | base}}){{with .Kind}} ({{.}}){{end}}{{end}}
+`))
+
+var mdDefTmpl = template.Must(template.New("def").Parse("[Index](../README.md) / [{{.Unit.Unit.Name}}](README.md)\n" + `
+# {{.Name}}
+
+{{with .Kind}}{{.}} {{end}}in ` + "`{{.File}}{{with .Line}}:{{.}}{{end}}`" + `
+{{with .Signature}}
+` + "```" + `
+{{.}}
+` + "```" + `
+{{end}}{{with .Doc}}
+{{.}}
+{{end}}{{with .Uses}}
+## Uses
+{{range .}}
+- [{{.Name}}]({{.URL}}){{end}}
+{{end}}{{with .UsedBy}}
+## Used by
+{{range .}}
+- [{{.Name}}]({{.URL}}){{end}}
+{{end}}{{with .Examples}}
+## Examples
+{{range .}}
+- ` + "`{{.File}}{{with .Line}}:{{.}}{{end}}`" + `{{with .Snippet}}: ` + "`{{.}}`" + `{{end}}{{end}}
+{{end}}`))
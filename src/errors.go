@@ -0,0 +1,105 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// Exit codes returned by src. Each corresponds to a category of
+// errors (see errorCategories) so that wrapper scripts can act on
+// failures without parsing error messages.
+const (
+	ExitError            = 1 // an error not in any of the categories below
+	ExitUsage            = 2 // invalid command-line flags or arguments
+	ExitStoreNotFound    = 3 // the store (or the data queried) does not exist
+	ExitNoDataForCommit  = 4 // there is no build data to import for the commit
+	ExitToolchainFailure = 5 // a toolchain (or the build it runs) failed
+	ExitPartialImport    = 6 // some source units could not be imported
+	ExitCorruptIndex     = 7 // an index could not be read
+	ExitCorruptBuildData = 8 // build data does not match its checksum manifest
+	ExitQueryLimit       = 9 // a query exceeded --max-results or --max-bytes-scanned
+)
+
+// errorCategories maps exit codes to the names of their error
+// categories, which are used in JSON error messages.
+var errorCategories = map[int]string{
+	ExitError:            "error",
+	ExitUsage:            "usage",
+	ExitStoreNotFound:    "store-not-found",
+	ExitNoDataForCommit:  "no-data-for-commit",
+	ExitToolchainFailure: "toolchain-failure",
+	ExitPartialImport:    "partial-import",
+	ExitCorruptIndex:     "corrupt-index",
+	ExitCorruptBuildData: "corrupt-build-data",
+	ExitQueryLimit:       "query-limit",
+}
+
+// A cmdError is an error whose category (and exit code) is known
+// where it is created.
+type cmdError struct {
+	code int
+	err  error
+}
+
+func (e *cmdError) Error() string { return e.err.Error() }
+
+// newCmdError returns an error that wraps err and causes src to exit
+// with the given exit code.
+func newCmdError(code int, err error) error {
+	return &cmdError{code: code, err: err}
+}
+
+// ExitCode returns the exit code for an error returned by Main (0 if
+// err is nil).
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	switch err := err.(type) {
+	case *cmdError:
+		return err.code
+	case *flags.Error:
+		return ExitUsage
+	case *exec.ExitError:
+		return ExitToolchainFailure
+	}
+	switch {
+	case store.IsIndexCorrupt(err):
+		return ExitCorruptIndex
+	case store.IsQueryLimitExceeded(err):
+		return ExitQueryLimit
+	case buildstore.IsChecksumMismatch(err):
+		return ExitCorruptBuildData
+	case store.IsNotExist(err):
+		return ExitStoreNotFound
+	}
+	return ExitError
+}
+
+// jsonError is an error in the JSON error format.
+type jsonError struct {
+	Error    string `json:"error"`
+	Category string `json:"category"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// printError prints an error returned by a command to stderr in the
+// format given by the --error-format flag of the store command.
+func printError(err error) {
+	code := ExitCode(err)
+	if storeCmd.ErrorFormat != "json" {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	b, err2 := json.Marshal(jsonError{Error: err.Error(), Category: errorCategories[code], ExitCode: code})
+	if err2 != nil {
+		panic(err2)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", b)
+}
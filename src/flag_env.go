@@ -0,0 +1,58 @@
+package src
+
+import (
+	"os"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+)
+
+// storeFlagEnvVars maps environment variables to the long names of
+// the store command flags whose defaults they set.
+var storeFlagEnvVars = map[string]string{
+	"SRC_STORE_ROOT":   "root",
+	"SRC_STORE_TYPE":   "type",
+	"SRC_STORE_CONFIG": "config",
+}
+
+// flagEnvPrefix is the prefix of environment variables that set the
+// default value of any flag of a command and its subcommands. The
+// rest of the variable name is the flag's long name, uppercased and
+// with dashes replaced by underscores (e.g., SRC_FLAG_DRY_RUN sets
+// --dry-run).
+const flagEnvPrefix = "SRC_FLAG_"
+
+// flagEnvVar returns the name of the environment variable that sets
+// the default value of the flag with the given long name.
+func flagEnvVar(longName string) string {
+	return flagEnvPrefix + strings.ToUpper(strings.Replace(longName, "-", "_", -1))
+}
+
+// setFlagDefaultsFromEnv sets the default values of the flags of cmd
+// (and its subcommands) from the SRC_FLAG_* environment variables. It
+// must be called after all of cmd's subcommands have been added.
+// Flags given on the command line still take precedence.
+func setFlagDefaultsFromEnv(cmd *flags.Command) {
+	for _, opt := range groupOptions(cmd.Group) {
+		if opt.LongName == "" {
+			continue
+		}
+		if v := os.Getenv(flagEnvVar(opt.LongName)); v != "" {
+			opt.Default = []string{v}
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		setFlagDefaultsFromEnv(sub)
+	}
+}
+
+// setStoreFlagDefaultsFromEnv sets the defaults of the store command's
+// flags from the SRC_STORE_* environment variables (see
+// storeFlagEnvVars).
+func setStoreFlagDefaultsFromEnv(storeC *flags.Command) {
+	for envVar, longName := range storeFlagEnvVars {
+		if v := os.Getenv(envVar); v != "" {
+			SetOptionDefaultValue(storeC.Group, longName, v)
+		}
+	}
+}
@@ -2,37 +2,51 @@ package src
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 
+	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
 )
 
 func init() {
-	_, err := CLI.AddCommand("fmt",
+	fmtGroup, err := CLI.AddCommand("fmt",
 		"format an object (def, ref, doc, etc)",
-		"The fmt command takes an object and formats it.",
+		"The fmt command takes an object and formats it with the fmt tool of the toolchain (in the SRCLIBPATH) that handles its unit type. Its subcommands format specific kinds of objects.",
 		&fmtCmd,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
-}
+	fmtGroup.SubcommandsOptional = true
+
+	_, err = fmtGroup.AddCommand("def",
+		"format defs as one-line signatures",
+		`The def command prints a one-line signature (e.g., "func (*Client) Get(url string) (*Response, error)") for the def given by --def, or for each def in a JSON array (or stream) of defs read from stdin if --def is not given (e.g., the output of 'src store defs').
 
-var fmtOpt = "fmt"
+Defs are formatted by the fmt tool of the toolchain that handles their unit type. If there is no such toolchain, a generic signature is made from the def's kind and tree path.`,
+		&fmtDefCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
 type FmtCmd struct {
-	UnitType   string `short:"u" long:"unit-type" description:"unit type" required:"yes"`
-	ObjectType string `short:"t" long:"object-type" description:"Object type ('def', 'doc')" required:"yes"`
+	UnitType   string `short:"u" long:"unit-type" description:"unit type"`
+	ObjectType string `short:"t" long:"object-type" description:"Object type ('def', 'doc')"`
 	Format     string `short:"f" long:"format" description:"Format to output ('full', 'decl')" default:"full"`
 
-	Object string `long:"object" description:"Object to format, serialized as JSON" required:"yes"`
+	Object string `long:"object" description:"Object to format, serialized as JSON"`
 }
 
 var fmtCmd FmtCmd
 
 func (c *FmtCmd) Get() (string, error) {
-	t, err := toolchain.ChooseTool(fmtOpt, c.UnitType)
+	t, err := toolchain.ChooseTool(toolchain.FormatOp, c.UnitType)
 	if err != nil {
 		return "", err
 	}
@@ -55,6 +69,9 @@ func (c *FmtCmd) Get() (string, error) {
 }
 
 func (c *FmtCmd) Execute(args []string) error {
+	if c.UnitType == "" || c.ObjectType == "" || c.Object == "" {
+		return newCmdError(ExitUsage, errors.New("--unit-type, --object-type, and --object are required (or use a subcommand, such as 'src fmt def')"))
+	}
 	out, err := c.Get()
 	if err != nil {
 		return err
@@ -62,3 +79,39 @@ func (c *FmtCmd) Execute(args []string) error {
 	fmt.Println(out)
 	return nil
 }
+
+type FmtDefCmd struct {
+	Format string `short:"f" long:"format" description:"Format to output ('decl', 'full')" default:"decl"`
+	Def    string `long:"def" description:"def to format, serialized as JSON (if omitted, defs are read from stdin)"`
+}
+
+var fmtDefCmd FmtDefCmd
+
+func (c *FmtDefCmd) Execute(args []string) error {
+	if c.Format != toolchain.DeclFormat && c.Format != toolchain.FullFormat {
+		return newCmdError(ExitUsage, fmt.Errorf("unknown --format %q (must be %q or %q)", c.Format, toolchain.DeclFormat, toolchain.FullFormat))
+	}
+
+	var defs []*graph.Def
+	if c.Def != "" {
+		var def graph.Def
+		if err := json.Unmarshal([]byte(c.Def), &def); err != nil {
+			return newCmdError(ExitUsage, fmt.Errorf("parsing --def: %s", err))
+		}
+		defs = []*graph.Def{&def}
+	} else {
+		var err error
+		if defs, err = readDefsJSON(os.Stdin); err != nil {
+			return fmt.Errorf("reading defs from stdin: %s", err)
+		}
+	}
+
+	for _, def := range defs {
+		sig, err := toolchain.FormatDef(def, c.Format)
+		if err != nil {
+			return fmt.Errorf("formatting def %s: %s", def.Path, err)
+		}
+		fmt.Println(sig)
+	}
+	return nil
+}
@@ -0,0 +1,647 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/context"
+)
+
+// This file implements the subset of GraphQL that 'src store graphql'
+// and the store API server's /graphql endpoint support: a single
+// query operation whose fields may have aliases, arguments (literals
+// or variables), and nested selection sets. Fragments, directives, and
+// mutations are not supported.
+
+const (
+	// gqlMaxDepth is the maximum nesting depth of a query's selection
+	// sets.
+	gqlMaxDepth = 10
+
+	// gqlMaxCost is the maximum number of fields that executing a
+	// query may resolve (counting a field once for each object that
+	// it is selected on). It bounds the store queries made by queries
+	// that nest lists (e.g., "defs { refs { def { refs ... } } }").
+	gqlMaxCost = 10000
+)
+
+// A gqlField is a field in a GraphQL selection set.
+type gqlField struct {
+	Alias, Name string
+	Args        gqlArgs
+	Selections  []*gqlField
+}
+
+// key is the key of the field's value in the response.
+func (f *gqlField) key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// gqlArgs are the arguments of a GraphQL field.
+type gqlArgs map[string]interface{}
+
+// check returns an error if args has an argument that is not in
+// allowed.
+func (args gqlArgs) check(allowed ...string) error {
+	for name := range args {
+		found := false
+		for _, a := range allowed {
+			if a == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown argument %q (valid arguments are: %s)", name, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+func (args gqlArgs) string(name string) (string, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a string", name)
+	}
+	return s, nil
+}
+
+func (args gqlArgs) int(name string) (int, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	n, ok := v.(int)
+	if x, isFloat := v.(float64); isFloat && x == float64(int(x)) {
+		n, ok = int(x), true // from a JSON-encoded variable
+	}
+	if !ok {
+		return 0, fmt.Errorf("argument %q must be an integer", name)
+	}
+	return n, nil
+}
+
+func (args gqlArgs) bool(name string) (bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("argument %q must be a boolean", name)
+	}
+	return b, nil
+}
+
+// parseGraphQL parses a GraphQL query document and returns the
+// selection set of its query operation. References to the query's
+// variables are replaced by their values in vars (or their default
+// values).
+func parseGraphQL(query string, vars map[string]interface{}) ([]*gqlField, error) {
+	p := &gqlParser{s: query, vars: vars}
+	p.next()
+	if p.tok == "query" {
+		p.next()
+		if p.kind == gqlName {
+			p.next() // operation name
+		}
+		if p.kind == gqlPunct && p.tok == "(" {
+			if err := p.variableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	} else if p.kind == gqlName {
+		return nil, p.errorf("unsupported operation %q (only queries are supported)", p.tok)
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.kind != gqlEOF {
+		return nil, p.errorf("unexpected %q after query (only a single operation is supported)", p.tok)
+	}
+	return sels, nil
+}
+
+type gqlTokenKind int
+
+const (
+	gqlEOF gqlTokenKind = iota
+	gqlPunct
+	gqlName
+	gqlString
+	gqlInt
+	gqlFloat
+)
+
+type gqlParser struct {
+	s    string
+	pos  int // offset of the next unread byte in s
+	tok  string
+	kind gqlTokenKind
+	err  error // lexical error
+
+	depth int // nesting depth of the current selection set
+
+	vars      map[string]interface{} // given variable values
+	varValues map[string]interface{} // values of the defined variables
+	inVarDefs bool                   // parsing variable definitions
+}
+
+func (p *gqlParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("GraphQL syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, a...))
+}
+
+// next reads the next token into p.tok and p.kind.
+func (p *gqlParser) next() {
+	// Skip whitespace, commas, and comments.
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '#' {
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if c == ',' || unicode.IsSpace(rune(c)) {
+			p.pos++
+		} else {
+			break
+		}
+	}
+	if p.pos == len(p.s) {
+		p.tok, p.kind = "", gqlEOF
+		return
+	}
+
+	start := p.pos
+	c := p.s[p.pos]
+	switch {
+	case strings.HasPrefix(p.s[p.pos:], "..."):
+		p.pos += 3
+		p.kind = gqlPunct
+	case strings.IndexByte("{}()[]:!$@=|", c) != -1:
+		p.pos++
+		p.kind = gqlPunct
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.s) && (p.s[p.pos] == '_' || unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+			p.pos++
+		}
+		p.kind = gqlName
+	case c == '-' || unicode.IsDigit(rune(c)):
+		p.pos++
+		p.kind = gqlInt
+		for p.pos < len(p.s) && strings.IndexByte("0123456789.eE+-", p.s[p.pos]) != -1 {
+			if strings.IndexByte(".eE", p.s[p.pos]) != -1 {
+				p.kind = gqlFloat
+			}
+			p.pos++
+		}
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.s) && p.s[p.pos] != '"' {
+			if p.s[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.s) {
+			p.err = p.errorf("unterminated string")
+			p.tok, p.kind = "", gqlEOF
+			return
+		}
+		p.pos++
+		p.kind = gqlString
+	default:
+		p.err = p.errorf("unexpected character %q", c)
+		p.tok, p.kind = "", gqlEOF
+		return
+	}
+	p.tok = p.s[start:p.pos]
+}
+
+// expect reads the punctuator tok or returns an error.
+func (p *gqlParser) expect(tok string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.kind != gqlPunct || p.tok != tok {
+		if p.kind == gqlEOF {
+			return p.errorf("expected %q, got end of query", tok)
+		}
+		return p.errorf("expected %q, got %q", tok, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+// variableDefinitions parses the variable definitions of the query
+// operation and sets p.varValues to the variables' values.
+func (p *gqlParser) variableDefinitions() error {
+	p.next()
+	p.varValues = map[string]interface{}{}
+	p.inVarDefs = true
+	defer func() { p.inVarDefs = false }()
+	for !(p.kind == gqlPunct && p.tok == ")") {
+		if err := p.expect("$"); err != nil {
+			return err
+		}
+		if p.kind != gqlName {
+			return p.errorf("expected variable name, got %q", p.tok)
+		}
+		name := p.tok
+		p.next()
+		if _, dup := p.varValues[name]; dup {
+			return p.errorf("variable $%s is defined more than once", name)
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		nonNull, err := p.typeRef()
+		if err != nil {
+			return err
+		}
+		v, given := p.vars[name]
+		if p.kind == gqlPunct && p.tok == "=" {
+			p.next()
+			def, err := p.value()
+			if err != nil {
+				return err
+			}
+			if !given {
+				v = def
+			}
+		}
+		if v == nil && nonNull {
+			return fmt.Errorf("variable $%s of non-null type must be given", name)
+		}
+		p.varValues[name] = v
+	}
+	p.next()
+	return nil
+}
+
+// typeRef parses a type reference (such as "String", "[Int]", or
+// "String!") and returns whether the type is non-null. Variables'
+// types are not otherwise checked: arguments are checked when their
+// fields are resolved.
+func (p *gqlParser) typeRef() (nonNull bool, err error) {
+	if p.err != nil {
+		return false, p.err
+	}
+	if p.kind == gqlPunct && p.tok == "[" {
+		p.next()
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if p.kind == gqlName {
+		p.next()
+	} else {
+		return false, p.errorf("expected type, got %q", p.tok)
+	}
+	if p.kind == gqlPunct && p.tok == "!" {
+		p.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *gqlParser) selectionSet() ([]*gqlField, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > gqlMaxDepth {
+		return nil, p.errorf("query is nested too deeply (the maximum depth is %d)", gqlMaxDepth)
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []*gqlField
+	for !(p.kind == gqlPunct && p.tok == "}") {
+		if p.err != nil {
+			return nil, p.err
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, f)
+	}
+	p.next()
+	if len(sels) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return sels, nil
+}
+
+func (p *gqlParser) field() (*gqlField, error) {
+	if p.kind == gqlPunct && p.tok == "..." {
+		return nil, p.errorf("fragments are not supported")
+	}
+	if p.kind != gqlName {
+		return nil, p.errorf("expected field name, got %q", p.tok)
+	}
+	f := &gqlField{Name: p.tok}
+	p.next()
+	if p.kind == gqlPunct && p.tok == ":" {
+		p.next()
+		if p.kind != gqlName {
+			return nil, p.errorf("expected field name after alias %q, got %q", f.Name, p.tok)
+		}
+		f.Alias, f.Name = f.Name, p.tok
+		p.next()
+	}
+	if p.kind == gqlPunct && p.tok == "(" {
+		p.next()
+		f.Args = gqlArgs{}
+		for !(p.kind == gqlPunct && p.tok == ")") {
+			if p.kind != gqlName {
+				return nil, p.errorf("expected argument name, got %q", p.tok)
+			}
+			name := p.tok
+			p.next()
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			f.Args[name] = v
+		}
+		p.next()
+	}
+	if p.kind == gqlPunct && p.tok == "@" {
+		return nil, p.errorf("directives are not supported")
+	}
+	if p.kind == gqlPunct && p.tok == "{" {
+		sels, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		f.Selections = sels
+	}
+	return f, nil
+}
+
+func (p *gqlParser) value() (interface{}, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch p.kind {
+	case gqlString:
+		p.next()
+		var s string
+		if err := json.Unmarshal([]byte(tok), &s); err != nil {
+			return nil, p.errorf("invalid string %s", tok)
+		}
+		return s, nil
+	case gqlInt:
+		p.next()
+		n, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, p.errorf("invalid integer %s", tok)
+		}
+		return n, nil
+	case gqlFloat:
+		p.next()
+		x, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok)
+		}
+		return x, nil
+	case gqlName:
+		p.next()
+		switch tok {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return tok, nil // enum value
+	case gqlPunct:
+		switch tok {
+		case "$":
+			if p.inVarDefs {
+				return nil, p.errorf("default values can't refer to variables")
+			}
+			p.next()
+			if p.kind != gqlName {
+				return nil, p.errorf("expected variable name, got %q", p.tok)
+			}
+			name := p.tok
+			p.next()
+			v, ok := p.varValues[name]
+			if !ok {
+				return nil, p.errorf("variable $%s is not defined", name)
+			}
+			return v, nil
+		case "[":
+			p.next()
+			list := []interface{}{}
+			for !(p.kind == gqlPunct && p.tok == "]") {
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			p.next()
+			obj := map[string]interface{}{}
+			for !(p.kind == gqlPunct && p.tok == "}") {
+				if p.kind != gqlName {
+					return nil, p.errorf("expected object field name, got %q", p.tok)
+				}
+				name := p.tok
+				p.next()
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				obj[name] = v
+			}
+			p.next()
+			return obj, nil
+		}
+	case gqlEOF:
+		return nil, p.errorf("expected value, got end of query")
+	}
+	return nil, p.errorf("expected value, got %q", tok)
+}
+
+// A gqlObject is a GraphQL object type. Its fields are resolved
+// lazily, so that only the data that a query selects is read from the
+// store.
+type gqlObject interface {
+	// typename is the name of the object's GraphQL type.
+	typename() string
+
+	// resolve returns the value of the named field. Values are
+	// scalars (strings, numbers, booleans, and slices of them),
+	// gqlObjects, slices of gqlObjects, or nil.
+	resolve(field string, args gqlArgs) (interface{}, error)
+}
+
+// gqlResponse is a GraphQL response.
+type gqlResponse struct {
+	Data   *gqlResult  `json:"data"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+// gqlError is an error in a GraphQL response. Path is the response
+// keys of the field whose resolution failed (if any).
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// A gqlResult is the result of selecting fields of an object. It is
+// encoded as a JSON object whose keys are in selection order.
+type gqlResult []gqlResultField
+
+type gqlResultField struct {
+	key   string
+	value interface{}
+}
+
+func (r gqlResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// execGraphQL parses and executes a GraphQL query against root (the
+// query type), with the given variable values. As in GraphQL, a field
+// whose resolution fails is null in the result, and its error is
+// reported in the response's errors.
+//
+// Execution stops when ctx is done or when the query has resolved
+// gqlMaxCost fields; the fields that were not resolved are null. (The
+// resolvers of root should also stop their store queries when ctx is
+// done; see store.ContextTreeStore.)
+func execGraphQL(ctx context.Context, root gqlObject, query string, vars map[string]interface{}) *gqlResponse {
+	sels, err := parseGraphQL(query, vars)
+	if err != nil {
+		return &gqlResponse{Errors: []*gqlError{{Message: err.Error()}}}
+	}
+	e := gqlExecutor{ctx: ctx}
+	data := e.selectFields(root, sels, nil)
+	return &gqlResponse{Data: &data, Errors: e.errors}
+}
+
+type gqlExecutor struct {
+	ctx     context.Context
+	errors  []*gqlError
+	cost    int  // number of fields resolved
+	stopped bool // whether execution stopped early (see stop)
+}
+
+// stop reports whether execution must stop, because e.ctx is done or
+// the query is too expensive. The first time it does, it records the
+// reason in e.errors.
+func (e *gqlExecutor) stop() bool {
+	if e.stopped {
+		return true
+	}
+	var msg string
+	if e.cost > gqlMaxCost {
+		msg = fmt.Sprintf("query is too expensive (it resolves more than %d fields; select fewer nested fields or use smaller limits)", gqlMaxCost)
+	} else if err := e.ctx.Err(); err != nil {
+		msg = fmt.Sprintf("query stopped: %s", err)
+	} else {
+		return false
+	}
+	e.stopped = true
+	e.errors = append(e.errors, &gqlError{Message: msg})
+	return true
+}
+
+func (e *gqlExecutor) selectFields(obj gqlObject, sels []*gqlField, path []interface{}) gqlResult {
+	result := make(gqlResult, 0, len(sels))
+	for _, f := range sels {
+		fpath := append(append([]interface{}(nil), path...), f.key())
+		e.cost++
+		var v interface{}
+		if e.stop() {
+			v = nil
+		} else if f.Name == "__typename" {
+			v = obj.typename()
+		} else {
+			var err error
+			v, err = obj.resolve(f.Name, f.Args)
+			if err != nil {
+				e.errors = append(e.errors, &gqlError{Message: fmt.Sprintf("%s.%s: %s", obj.typename(), f.Name, err), Path: fpath})
+				v = nil
+			} else {
+				v = e.complete(obj, f, v, fpath)
+			}
+		}
+		result = append(result, gqlResultField{f.key(), v})
+	}
+	return result
+}
+
+// complete selects the subfields of a resolved field value.
+func (e *gqlExecutor) complete(parent gqlObject, f *gqlField, v interface{}, path []interface{}) interface{} {
+	fail := func(format string, a ...interface{}) interface{} {
+		e.errors = append(e.errors, &gqlError{Message: fmt.Sprintf("%s.%s: %s", parent.typename(), f.Name, fmt.Sprintf(format, a...)), Path: path})
+		return nil
+	}
+	switch v := v.(type) {
+	case gqlObject:
+		if f.Selections == nil {
+			return fail("field of type %s must have a selection of subfields", v.typename())
+		}
+		return e.selectFields(v, f.Selections, path)
+	case []gqlObject:
+		if f.Selections == nil {
+			return fail("field of type [object] must have a selection of subfields")
+		}
+		list := make([]interface{}, len(v))
+		for i, o := range v {
+			list[i] = e.selectFields(o, f.Selections, append(append([]interface{}(nil), path...), i))
+		}
+		return list
+	}
+	if f.Selections != nil {
+		return fail("field is a scalar and can't have a selection of subfields")
+	}
+	return v
+}
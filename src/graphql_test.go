@@ -0,0 +1,223 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// testGQLNode is a GraphQL object for testing the executor.
+type testGQLNode struct {
+	name string
+}
+
+func (n *testGQLNode) typename() string { return "Node" }
+
+func (n *testGQLNode) resolve(field string, args gqlArgs) (interface{}, error) {
+	switch field {
+	case "name":
+		return n.name, nil
+	case "child":
+		return &testGQLNode{name: n.name + "/c"}, nil
+	case "children":
+		if err := args.check("count"); err != nil {
+			return nil, err
+		}
+		count, err := args.int("count")
+		if err != nil {
+			return nil, err
+		}
+		children := make([]gqlObject, count)
+		for i := range children {
+			children[i] = &testGQLNode{name: fmt.Sprintf("%s/%d", n.name, i)}
+		}
+		return children, nil
+	case "echo":
+		return args["v"], nil
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+func execTestGraphQL(ctx context.Context, query string, vars map[string]interface{}) (data string, errs []string) {
+	resp := execGraphQL(ctx, &testGQLNode{name: "r"}, query, vars)
+	if resp.Data != nil {
+		b, err := json.Marshal(resp.Data)
+		if err != nil {
+			panic(err)
+		}
+		data = string(b)
+	}
+	for _, e := range resp.Errors {
+		errs = append(errs, e.Message)
+	}
+	return data, errs
+}
+
+func TestParseGraphQL_syntaxErrors(t *testing.T) {
+	tests := map[string]string{
+		``:                      `expected "{", got end of query`,
+		`{`:                     `expected field name, got ""`,
+		`{ name`:                `expected field name, got ""`,
+		`{ }`:                   `empty selection set`,
+		`{ a: }`:                `expected field name after alias "a"`,
+		`{ echo(v: ) }`:         `expected value, got ")"`,
+		`{ echo(v: "x) }`:       `unterminated string`,
+		`{ name } { name }`:     `only a single operation is supported`,
+		`mutation { name }`:     `unsupported operation "mutation"`,
+		`{ ...F }`:              `fragments are not supported`,
+		`{ name @skip }`:        `directives are not supported`,
+		`{ name % }`:            `unexpected character '%'`,
+		`query ($x) { name }`:   `expected ":", got ")"`,
+		`query ($x: ) { name }`: `expected type, got ")"`,
+	}
+	for query, want := range tests {
+		_, err := parseGraphQL(query, nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want it to contain %q", query, err, want)
+		}
+	}
+}
+
+func TestParseGraphQL_nesting(t *testing.T) {
+	sels, err := parseGraphQL(`query Q { a: child { name children(count: 2) { name } } }`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sels) != 1 || sels[0].Alias != "a" || sels[0].Name != "child" {
+		t.Fatalf("got selections %+v, want a: child", sels)
+	}
+	if sub := sels[0].Selections; len(sub) != 2 || sub[1].Name != "children" || sub[1].Args["count"] != 2 || len(sub[1].Selections) != 1 {
+		t.Errorf("got subselections %+v, want name and children(count: 2) { name }", sub)
+	}
+
+	nested := func(depth int) string {
+		return strings.Repeat("{ child ", depth-1) + "{ name" + strings.Repeat(" }", depth)
+	}
+	if _, err := parseGraphQL(nested(gqlMaxDepth), nil); err != nil {
+		t.Errorf("depth %d: %s", gqlMaxDepth, err)
+	}
+	if _, err := parseGraphQL(nested(gqlMaxDepth+1), nil); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("depth %d: got error %v, want nested too deeply", gqlMaxDepth+1, err)
+	}
+}
+
+func TestParseGraphQL_variables(t *testing.T) {
+	tests := []struct {
+		query   string
+		vars    map[string]interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{query: `query ($v: String) { echo(v: $v) }`, vars: map[string]interface{}{"v": "x"}, want: "x"},
+		{query: `query Q($v: [Int!]!) { echo(v: $v) }`, vars: map[string]interface{}{"v": []interface{}{1.0}}, want: []interface{}{1.0}},
+		{query: `query ($v: Int = 3) { echo(v: $v) }`, want: 3},
+		{query: `query ($v: Int = 3) { echo(v: $v) }`, vars: map[string]interface{}{"v": 4.0}, want: 4.0},
+		{query: `query ($v: String) { echo(v: $v) }`, want: nil},
+		{query: `query ($v: String) { echo(v: [$v]) }`, vars: map[string]interface{}{"v": "x"}, want: []interface{}{"x"}},
+		{query: `{ echo(v: $v) }`, wantErr: "variable $v is not defined"},
+		{query: `query ($w: String) { echo(v: $v) }`, wantErr: "variable $v is not defined"},
+		{query: `query ($v: String!) { echo(v: $v) }`, wantErr: "variable $v of non-null type must be given"},
+		{query: `query ($v: String, $v: Int) { echo(v: $v) }`, wantErr: "variable $v is defined more than once"},
+		{query: `query ($v: String, $w: String = $v) { echo(v: $w) }`, wantErr: "default values can't refer to variables"},
+	}
+	for _, test := range tests {
+		sels, err := parseGraphQL(test.query, test.vars)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%q: got error %v, want it to contain %q", test.query, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.query, err)
+			continue
+		}
+		if got := sels[0].Args["v"]; fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: got argument %#v, want %#v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestExecGraphQL(t *testing.T) {
+	tests := []struct {
+		query    string
+		vars     map[string]interface{}
+		wantData string
+		wantErrs []string
+	}{
+		{
+			query:    `{ name __typename }`,
+			wantData: `{"name":"r","__typename":"Node"}`,
+		},
+		{
+			query:    `{ c: child { name child { name } } children(count: 2) { name } }`,
+			wantData: `{"c":{"name":"r/c","child":{"name":"r/c/c"}},"children":[{"name":"r/0"},{"name":"r/1"}]}`,
+		},
+		{
+			query:    `query ($n: Int) { children(count: $n) { name } }`,
+			vars:     map[string]interface{}{"n": 1.0}, // as decoded from JSON
+			wantData: `{"children":[{"name":"r/0"}]}`,
+		},
+		{
+			query:    `{ name nope child { nope } }`,
+			wantData: `{"name":"r","nope":null,"child":{"nope":null}}`,
+			wantErrs: []string{"Node.nope: no such field", "Node.nope: no such field"},
+		},
+		{
+			query:    `{ children(count: 1, bad: 2) { name } }`,
+			wantData: `{"children":null}`,
+			wantErrs: []string{`Node.children: unknown argument "bad" (valid arguments are: count)`},
+		},
+		{
+			query:    `{ child }`,
+			wantData: `{"child":null}`,
+			wantErrs: []string{"Node.child: field of type Node must have a selection of subfields"},
+		},
+		{
+			query:    `{ name { x } }`,
+			wantData: `{"name":null}`,
+			wantErrs: []string{"Node.name: field is a scalar and can't have a selection of subfields"},
+		},
+		{
+			query:    `{ name(`,
+			wantErrs: []string{`GraphQL syntax error at offset 7: expected argument name, got ""`},
+		},
+	}
+	for _, test := range tests {
+		data, errs := execTestGraphQL(context.Background(), test.query, test.vars)
+		if data != test.wantData {
+			t.Errorf("%q: got data %s, want %s", test.query, data, test.wantData)
+		}
+		if fmt.Sprint(errs) != fmt.Sprint(test.wantErrs) {
+			t.Errorf("%q: got errors %q, want %q", test.query, errs, test.wantErrs)
+		}
+	}
+}
+
+func TestExecGraphQL_cost(t *testing.T) {
+	query := fmt.Sprintf(`{ children(count: %d) { name } }`, gqlMaxCost)
+	_, errs := execTestGraphQL(context.Background(), query, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0], "query is too expensive") {
+		t.Errorf("got errors %q, want query is too expensive", errs)
+	}
+
+	query = fmt.Sprintf(`{ children(count: %d) { name } }`, gqlMaxCost-1)
+	if _, errs := execTestGraphQL(context.Background(), query, nil); len(errs) != 0 {
+		t.Errorf("got errors %q, want none", errs)
+	}
+}
+
+func TestExecGraphQL_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	data, errs := execTestGraphQL(ctx, `{ name child { name } }`, nil)
+	if want := `{"name":null,"child":null}`; data != want {
+		t.Errorf("got data %s, want %s", data, want)
+	}
+	if want := []string{"query stopped: context canceled"}; fmt.Sprint(errs) != fmt.Sprint(want) {
+		t.Errorf("got errors %q, want %q", errs, want)
+	}
+}
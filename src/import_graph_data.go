@@ -0,0 +1,223 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/makex"
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// graphDataPreparer prepares source units' graph data to be imported:
+// it normalizes the data, converts docs, tags defs and refs in
+// generated files, attributes refs to vendored source units, and
+// records the toolchain that graphed each unit (according to the
+// ImportOpt). It is safe for concurrent use.
+type graphDataPreparer struct {
+	opt      ImportOpt
+	vendored *store.VendorAttribution
+
+	mu        sync.Mutex
+	languages map[string]string // toolchain path -> language
+	generated map[string]bool   // file -> whether it's generated
+}
+
+// newGraphDataPreparer creates a graphDataPreparer for the build data
+// in buildDataFS, whose rules and source units are given.
+func newGraphDataPreparer(buildDataFS vfs.FileSystem, rules []makex.Rule, units []*unit.SourceUnit, opt ImportOpt) (*graphDataPreparer, error) {
+	p := &graphDataPreparer{
+		opt:       opt,
+		vendored:  &store.VendorAttribution{},
+		languages: map[string]string{},
+		generated: map[string]bool{},
+	}
+	if !opt.NoAttributeVendored {
+		var err error
+		p.vendored, err = vendorAttribution(buildDataFS, rules, units, opt.Repo, opt.CommitID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// prepare prepares the graph data of u (graphed by tool) in place. It
+// returns the source unit to import along with the data.
+func (p *graphDataPreparer) prepare(u *unit.SourceUnit, tool *srclib.ToolRef, data *graph.Output) *unit.SourceUnit {
+	if !p.opt.NoNormalize {
+		if r := store.Normalize(data); r.Changed() {
+			logger.Infof("# Normalized graph data for unit %s %s: %s", u.Type, u.Name, r)
+		}
+	}
+	if len(p.opt.DocFormats) > 0 {
+		store.ConvertDocs(data, p.opt.DocFormats...)
+	}
+	if !p.opt.NoTagGenerated {
+		if files := store.TagGenerated(data, p.isGenerated); len(files) > 0 && GlobalOpt.Verbose {
+			logger.Infof("# Tagged defs and refs in %d generated files for unit %s %s", len(files), u.Type, u.Name)
+		}
+	}
+	if n := p.vendored.AttributeRefs(u.ID2(), data); n > 0 && GlobalOpt.Verbose {
+		logger.Infof("# Attributed %d refs to vendored defs to their upstream repos for unit %s %s", n, u.Type, u.Name)
+	}
+	return p.vendored.AttributeUnit(p.withToolchain(u, tool))
+}
+
+// isGenerated returns whether the file (relative to the current dir,
+// which is the root of the tree) is generated code.
+func (p *graphDataPreparer) isGenerated(file string) bool {
+	p.mu.Lock()
+	gen, ok := p.generated[file]
+	p.mu.Unlock()
+	if ok {
+		return gen
+	}
+	gen = store.IsGeneratedPath(file)
+	if !gen {
+		if f, err := os.Open(filepath.FromSlash(file)); err == nil {
+			head := make([]byte, store.GeneratedHeaderSize)
+			n, _ := io.ReadFull(f, head)
+			f.Close()
+			gen = store.IsGeneratedHeader(head[:n])
+		}
+	}
+	p.mu.Lock()
+	p.generated[file] = gen
+	p.mu.Unlock()
+	return gen
+}
+
+// withToolchain returns a copy of u recording the toolchain (and, if
+// the scanner did not set it, the language) that graphed it.
+func (p *graphDataPreparer) withToolchain(u *unit.SourceUnit, tool *srclib.ToolRef) *unit.SourceUnit {
+	if tool == nil {
+		return u
+	}
+	uc := *u
+	uc.Toolchain = tool.Toolchain
+	if uc.Language == "" {
+		p.mu.Lock()
+		lang, ok := p.languages[tool.Toolchain]
+		if !ok {
+			lang = toolchain.Language(tool.Toolchain)
+			p.languages[tool.Toolchain] = lang
+		}
+		p.mu.Unlock()
+		uc.Language = lang
+	}
+	return &uc
+}
+
+// importGraphData imports the graph data of u into stor (a RepoStore
+// or MultiRepoStore).
+func importGraphData(stor interface{}, repo, commitID string, u *unit.SourceUnit, data *graph.Output) error {
+	switch imp := stor.(type) {
+	case store.RepoImporter:
+		return imp.Import(commitID, u, *data)
+	case store.MultiRepoImporter:
+		return imp.Import(repo, commitID, u, *data)
+	}
+	return fmt.Errorf("store (type %T) does not implement importing", stor)
+}
+
+// buildDataTarget returns the build data file that rule created: its
+// target or, for graph rules that imported the graph output directly
+// into a store, its import receipt (see grapher.GraphUnitRule.Import).
+func buildDataTarget(buildDataFS vfs.FileSystem, rule makex.Rule) string {
+	if rule, ok := rule.(*grapher.GraphUnitRule); ok {
+		if _, err := buildDataFS.Stat(rule.Target()); os.IsNotExist(err) {
+			if _, err := buildDataFS.Stat(rule.ReceiptTarget()); err == nil {
+				return rule.ReceiptTarget()
+			}
+		}
+	}
+	return rule.Target()
+}
+
+// ImportGraphDataCmd imports the graph output of a source unit (read
+// from stdin) directly into the store, the way 'src store import'
+// would, and prints an import receipt (see grapher.ImportReceipt). It
+// is used by graph rules when 'src make --import' is run.
+//
+// It doesn't lock the commit in the store or build indexes; 'src make
+// --import' does that.
+type ImportGraphDataCmd struct {
+	Repo     string `long:"repo" description:"repo URI to import the data as"`
+	CommitID string `long:"commit" description:"commit ID to import the data as" required:"yes"`
+
+	DataDir   string `long:"data-dir" description:"build data dir of the current build" required:"yes"`
+	UnitType  string `long:"unit-type" description:"type of the source unit" required:"yes"`
+	Unit      string `long:"unit" description:"name of the source unit" required:"yes"`
+	Toolchain string `long:"toolchain" description:"toolchain that graphed the source unit"`
+}
+
+var importGraphDataCmd ImportGraphDataCmd
+
+func (c *ImportGraphDataCmd) Execute(args []string) error {
+	var data graph.Output
+	if err := json.NewDecoder(os.Stdin).Decode(&data); err != nil {
+		return err
+	}
+
+	buildDataFS := rwvfs.OS(c.DataDir)
+	treeConfig, err := config.ReadCached(buildDataFS)
+	if err != nil {
+		return err
+	}
+	var u *unit.SourceUnit
+	for _, u2 := range treeConfig.SourceUnits {
+		if u2.Type == c.UnitType && u2.Name == c.Unit {
+			u = u2
+			break
+		}
+	}
+	if u == nil {
+		return fmt.Errorf("no source unit %s %s in build data dir %s", c.UnitType, c.Unit, c.DataDir)
+	}
+
+	mf, err := plan.CreateMakefile(".", nil, "", treeConfig, plan.Options{NoCache: true})
+	if err != nil {
+		return err
+	}
+	opt := ImportOpt{Repo: c.Repo, CommitID: c.CommitID}
+	prep, err := newGraphDataPreparer(buildDataFS, mf.Rules, treeConfig.SourceUnits, opt)
+	if err != nil {
+		return err
+	}
+	var tool *srclib.ToolRef
+	if c.Toolchain != "" {
+		tool = &srclib.ToolRef{Toolchain: c.Toolchain}
+	}
+	u = prep.prepare(u, tool, &data)
+
+	storeCmd.allowCreate = true
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	if err := importGraphData(s, c.Repo, c.CommitID, u, &data); err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(&grapher.ImportReceipt{
+		Repo:     c.Repo,
+		CommitID: c.CommitID,
+		Defs:     len(data.Defs),
+		Refs:     len(data.Refs),
+		Docs:     len(data.Docs),
+		Anns:     len(data.Anns),
+	})
+}
@@ -0,0 +1,154 @@
+package src
+
+import (
+	"sync"
+
+	"code.google.com/p/rog-go/parallel"
+
+	"sourcegraph.com/sourcegraph/makex"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// importItem is a source unit's decoded build data, which is passed
+// from the decoding stage of the import pipeline to the writing
+// stage. Exactly one of graph, deps, and receipt is set.
+type importItem struct {
+	unit  *unit.SourceUnit
+	graph *graph.Output
+	deps  []*dep.ResolvedDep
+
+	// receipt is set (instead of graph) if the unit's graph data was
+	// already imported by src make (see grapher.GraphUnitRule.Import).
+	receipt *grapher.ImportReceipt
+
+	cost int64 // bytes of the import's memory budget held by the item
+}
+
+// Defaults for the import pipeline options (used when the
+// corresponding ImportOpt fields are zero).
+const (
+	defaultImportDecoders      = 4
+	defaultImportWriters       = 6
+	defaultImportPipelineDepth = 4
+)
+
+func (opt *ImportOpt) decoders() int {
+	if opt.Decoders > 0 {
+		return opt.Decoders
+	}
+	return defaultImportDecoders
+}
+
+func (opt *ImportOpt) writers() int {
+	if opt.Writers > 0 {
+		return opt.Writers
+	}
+	return defaultImportWriters
+}
+
+func (opt *ImportOpt) pipelineDepth() int {
+	if opt.PipelineDepth > 0 {
+		return opt.PipelineDepth
+	}
+	return defaultImportPipelineDepth
+}
+
+// runImportPipeline calls decode for each rule (in nDecoders
+// goroutines) and passes the non-nil items it returns to write (in
+// nWriters goroutines), so that units are decoded while others are
+// being written. At most depth decoded items wait to be written, so
+// at most nDecoders+depth+nWriters decoded units are in memory at
+// once.
+//
+// After the first error, no more rules are decoded and no more items
+// are written (discard is called for each decoded item that is not
+// written), and the first error is returned.
+func runImportPipeline(rules []makex.Rule, decode func(makex.Rule) (*importItem, error), write func(*importItem) error, discard func(*importItem), nDecoders, nWriters, depth int) error {
+	var (
+		errMu    sync.Mutex
+		firstErr error
+		done     = make(chan struct{})
+	)
+	fail := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			close(done)
+		}
+	}
+	stopped := func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	ruleC := make(chan makex.Rule)
+	go func() {
+		defer close(ruleC)
+		for _, rule := range rules {
+			select {
+			case ruleC <- rule:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	itemC := make(chan *importItem, depth)
+	decoders := parallel.NewRun(nDecoders)
+	for i := 0; i < nDecoders; i++ {
+		decoders.Do(func() error {
+			for rule := range ruleC {
+				if stopped() {
+					continue
+				}
+				item, err := decode(rule)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				if item == nil {
+					continue
+				}
+				select {
+				case itemC <- item:
+				case <-done:
+					discard(item)
+				}
+			}
+			return nil
+		})
+	}
+	go func() {
+		decoders.Wait()
+		close(itemC)
+	}()
+
+	writers := parallel.NewRun(nWriters)
+	for i := 0; i < nWriters; i++ {
+		writers.Do(func() error {
+			for item := range itemC {
+				if stopped() {
+					discard(item)
+					continue
+				}
+				if err := write(item); err != nil {
+					fail(err)
+				}
+			}
+			return nil
+		})
+	}
+	writers.Wait()
+
+	errMu.Lock()
+	defer errMu.Unlock()
+	return firstErr
+}
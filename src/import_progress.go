@@ -0,0 +1,133 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// importProgress reports the progress of an import (see
+// ImportOpt.Progress). A nil *importProgress reports nothing.
+type importProgress struct {
+	w          io.Writer
+	start      time.Time
+	totalUnits int
+	totalBytes uint64 // total size of the graph data files to import
+
+	decoded uint64 // bytes of graph data decoded so far (updated atomically)
+
+	mu         sync.Mutex
+	doneUnits  int
+	defs, refs int
+	done       chan struct{}
+}
+
+// newImportProgress creates an importProgress that writes to w (if w
+// is non-nil) and reports the progress of importing the graph data
+// files (in buildDataFS) named by targets.
+func newImportProgress(w io.Writer, buildDataFS vfs.FileSystem, targets []string) *importProgress {
+	if w == nil {
+		return nil
+	}
+	p := &importProgress{w: w, start: time.Now(), totalUnits: len(targets), done: make(chan struct{})}
+	for _, target := range targets {
+		if fi, err := buildDataFS.Stat(target); err == nil {
+			p.totalBytes += uint64(fi.Size())
+		}
+	}
+	fmt.Fprintf(w, "# Importing %d source units (%s of graph data)\n", p.totalUnits, bytesString(p.totalBytes))
+	go p.tick()
+	return p
+}
+
+// tick periodically reports the overall progress (so that there is
+// output while large units are being decoded) until stop is called.
+func (p *importProgress) tick() {
+	t := time.NewTicker(2 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.mu.Lock()
+			fmt.Fprintf(p.w, "# [%d/%d] %s\n", p.doneUnits, p.totalUnits, p.status())
+			p.mu.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// status returns a description of the bytes decoded, elapsed time,
+// and ETA.
+func (p *importProgress) status() string {
+	decoded := atomic.LoadUint64(&p.decoded)
+	elapsed := time.Since(p.start)
+	eta := "?"
+	if decoded > 0 && decoded <= p.totalBytes {
+		remaining := time.Duration(float64(elapsed) * float64(p.totalBytes-decoded) / float64(decoded))
+		eta = roundDuration(remaining).String()
+	}
+	return fmt.Sprintf("%s/%s decoded, %s elapsed, ETA %s", bytesString(decoded), bytesString(p.totalBytes), roundDuration(elapsed), eta)
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return (d + 50*time.Millisecond) / (100 * time.Millisecond) * (100 * time.Millisecond)
+}
+
+// readJSON reads the JSON-encoded file into v, counting the bytes
+// decoded.
+func (p *importProgress) readJSON(fs vfs.FileSystem, file string, v interface{}) (err error) {
+	if p == nil {
+		return readJSONFileFS(fs, file, v)
+	}
+	f, err := fs.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	return json.NewDecoder(&countingReader{Reader: f, n: &p.decoded}).Decode(v)
+}
+
+// unitDone reports that the graph data for u (with the given number
+// of defs and refs) was imported.
+func (p *importProgress) unitDone(u *unit.SourceUnit, defs, refs int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneUnits++
+	p.defs += defs
+	p.refs += refs
+	fmt.Fprintf(p.w, "# [%d/%d] Imported %s %s (%d defs, %d refs); %s\n", p.doneUnits, p.totalUnits, u.Type, u.Name, defs, refs, p.status())
+}
+
+// indexing reports that the import is building indexes.
+func (p *importProgress) indexing() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "# Imported %d defs and %d refs in %s; building indexes\n", p.defs, p.refs, roundDuration(time.Since(p.start)))
+}
+
+// stop stops the periodic progress reports.
+func (p *importProgress) stop() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+}
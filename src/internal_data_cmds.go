@@ -2,11 +2,19 @@ package src
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/grapher"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
 func init() {
@@ -19,6 +27,21 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	_, err = c.AddCommand("resolve-deps", "", "", &resolveDepsCmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("defs-manifest", "", "", &defsManifestCmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("import-graph-data", "", "", &importGraphDataCmd)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 type NormalizeGraphDataCmd struct {
@@ -55,3 +78,171 @@ func (c *NormalizeGraphDataCmd) Execute(args []string) error {
 
 	return nil
 }
+
+// ResolveDepsCmd resolves the dependencies of the source unit read
+// from stdin with a depresolve tool, like 'src tool', but uses the
+// depresolve cache shared across repositories (see dep.Cache).
+type ResolveDepsCmd struct {
+	ToolchainExecOpt
+	RuleStatsOpt
+
+	Args struct {
+		Toolchain ToolchainPath `name:"TOOLCHAIN" description:"toolchain path of the depresolve tool"`
+		Tool      ToolName      `name:"TOOL" description:"depresolve tool subcommand name (in TOOLCHAIN)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var resolveDepsCmd ResolveDepsCmd
+
+func (c *ResolveDepsCmd) Execute(args []string) error {
+	start := time.Now()
+	var u *unit.SourceUnit
+	if err := json.NewDecoder(os.Stdin).Decode(&u); err != nil {
+		return err
+	}
+
+	tool, err := toolchain.OpenTool(string(c.Args.Toolchain), string(c.Args.Tool), c.ToolchainMode())
+	if err != nil {
+		return err
+	}
+	if !GlobalOpt.Verbose {
+		tool.SetLogger(log.New(ioutil.Discard, "", 0))
+	}
+	resolver, err := dep.ResolverVersion(string(c.Args.Toolchain), string(c.Args.Tool))
+	if err != nil {
+		return err
+	}
+
+	cache := &dep.Cache{Dir: dep.DefaultCacheDir(), Offline: c.Offline}
+	ress, err := dep.ResolveCached(cache, tool, resolver, u)
+	if err != nil {
+		return err
+	}
+	c.recordRuleStats(start)
+	if ress == nil {
+		ress = []*dep.Resolution{}
+	}
+	return json.NewEncoder(os.Stdout).Encode(ress)
+}
+
+// DefsManifestCmd creates the DefsManifest (see grapher.DefsManifest)
+// for the source unit read from stdin, from the graph output of the
+// source units in its GraphDeps (in the build data dir) and the defs
+// in the store of the source units that its dependencies resolve to.
+type DefsManifestCmd struct {
+	DataDir string `long:"data-dir" description:"build data dir of the current build" required:"yes"`
+}
+
+var defsManifestCmd DefsManifestCmd
+
+func (c *DefsManifestCmd) Execute(args []string) error {
+	var u *unit.SourceUnit
+	if err := json.NewDecoder(os.Stdin).Decode(&u); err != nil {
+		return err
+	}
+
+	manifest := &grapher.DefsManifest{Units: []*grapher.DefsManifestUnit{}}
+	for _, id := range u.GraphDeps {
+		file := filepath.Join(c.DataDir, plan.SourceUnitDataFilename(&graph.Output{}, &unit.SourceUnit{Name: id.Name, Type: id.Type}))
+		var o graph.Output
+		if err := readJSONFile(file, &o); os.IsNotExist(err) {
+			continue // not in the tree
+		} else if err != nil {
+			return err
+		}
+		manifest.Units = append(manifest.Units, grapher.NewDefsManifestUnit("", "", id.Type, id.Name, o.Defs))
+	}
+
+	storeUnits, err := storeDefsManifestUnits(filepath.Join(c.DataDir, plan.SourceUnitDataFilename([]*dep.ResolvedDep{}, u)))
+	if err != nil {
+		return err
+	}
+	manifest.Units = append(manifest.Units, storeUnits...)
+	return json.NewEncoder(os.Stdout).Encode(manifest)
+}
+
+// storeDefsManifestUnits returns the DefsManifestUnits of the source
+// units in other repositories that the resolutions in the depresolve
+// output file resolve to, using their defs at the resolved revision
+// (or the latest imported commit) in the store. Source units that
+// haven't been imported are omitted.
+func storeDefsManifestUnits(depresolveFile string) ([]*grapher.DefsManifestUnit, error) {
+	var ress []*dep.Resolution
+	if err := readJSONFile(depresolveFile, &ress); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		logger.Warnf("opening store to read dependencies' defs: %s", err)
+		return nil, nil
+	}
+	mrs, ok := s.(store.MultiRepoStore)
+	if !ok {
+		logger.Debugf("# Store (type %T) is not a MultiRepoStore, so dependencies' defs are not read from it", s)
+		return nil, nil
+	}
+
+	var mus []*grapher.DefsManifestUnit
+	seen := map[unit.Key]bool{}
+	for _, res := range ress {
+		if res.Target == nil || res.Target.ToRepoCloneURL == "" {
+			continue
+		}
+		repo, err := graph.TryMakeURI(res.Target.ToRepoCloneURL)
+		if err != nil {
+			continue
+		}
+		commitID, err := depCommitID(mrs, repo, res.Target.ToRevSpec)
+		if err != nil {
+			return nil, err
+		}
+		if commitID == "" {
+			continue // not imported
+		}
+		key := unit.Key{Repo: repo, CommitID: commitID, UnitType: res.Target.ToUnitType, Unit: res.Target.ToUnit}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		filters := []store.DefFilter{
+			store.ByRepoCommitIDs(store.Version{Repo: repo, CommitID: commitID}),
+			store.DefFilterFunc(func(def *graph.Def) bool { return def.Exported }),
+		}
+		if key.UnitType != "" && key.Unit != "" {
+			filters = append(filters, store.ByUnits(unit.ID2{Type: key.UnitType, Name: key.Unit}))
+		}
+		defs, err := mrs.Defs(filters...)
+		if err != nil {
+			return nil, err
+		}
+		mus = append(mus, grapher.NewDefsManifestUnit(repo, commitID, key.UnitType, key.Unit, defs))
+	}
+	return mus, nil
+}
+
+// depCommitID returns the commit of repo whose data in mrs should be
+// used for a dependency on it: revSpec if it is an imported commit,
+// and otherwise the latest imported commit. It returns "" if repo
+// hasn't been imported.
+func depCommitID(mrs store.MultiRepoStore, repo, revSpec string) (string, error) {
+	if revSpec != "" {
+		versions, err := mrs.Versions(store.ByRepoCommitIDs(store.Version{Repo: repo, CommitID: revSpec}))
+		if err != nil && !store.IsNotExist(err) {
+			return "", err
+		}
+		if len(versions) > 0 {
+			return revSpec, nil
+		}
+	}
+	stats, err := store.GetRepoStats(mrs, repo, false)
+	if store.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return stats.LatestCommitID, nil
+}
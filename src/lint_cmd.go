@@ -77,6 +77,12 @@ func (c *LintCmd) Execute(args []string) error {
 	if lrepoErr != nil {
 		log.Printf("warning: while opening current dir's repo: %s", lrepoErr)
 	}
+	var buildDataRoot string
+	if lrepo != nil && lrepo.RootDir != "" {
+		if root, err := localBuildDataRoot(lrepo.RootDir); err == nil {
+			buildDataRoot = root
+		}
+	}
 
 	var wg sync.WaitGroup
 	for _, path := range c.Args.Paths {
@@ -93,34 +99,27 @@ func (c *LintCmd) Execute(args []string) error {
 						return err
 					}
 
+					pcs := buildDataPathComponents(absPath, buildDataRoot)
+
 					var unitType, unitName string
 					if !c.NoCheckResolve {
-						if !strings.Contains(absPath, buildstore.BuildDataDirName) {
-							return fmt.Errorf("couldn't infer which source unit %s corresponds to, because its absolute path is not under any %s dir; either run with --no-check-resolve to skip checking that internal refs resolve to valid defs (which requires knowing what source unit each output file is from), or run 'src lint' against .srclib-cache or subdirectories of it", w.Path(), buildstore.BuildDataDirName)
+						if pcs == nil {
+							return fmt.Errorf("couldn't infer which source unit %s corresponds to, because its absolute path is not under any %s dir or the local repo's build data dir; either run with --no-check-resolve to skip checking that internal refs resolve to valid defs (which requires knowing what source unit each output file is from), or run 'src lint' against .srclib-cache or subdirectories of it", w.Path(), buildstore.BuildDataDirName)
 						}
 						unitType = strings.TrimSuffix(fi.Name(), "."+suffix+".json")
 						// Infer source unit name from file path (the
-						// path components after .srclib-cache until
+						// path components after the commit ID until
 						// the basename).
-						pcs := strings.Split(absPath, string(os.PathSeparator))
-						for i, pc := range pcs {
-							if pc == buildstore.BuildDataDirName && len(pcs) > i+2 {
-								unitName = filepath.Clean(strings.Join(pcs[i+2:len(pcs)-1], "/"))
-								break
-							}
+						if len(pcs) > 1 {
+							unitName = filepath.Clean(strings.Join(pcs[1:len(pcs)-1], "/"))
 						}
 					}
 
 					var commitID string
-					if !c.NoCheckFiles {
-						// Infer commit ID from file path (the path component after .srclib-cache).
-						pcs := strings.Split(absPath, string(os.PathSeparator))
-						for i, pc := range pcs {
-							if pc == buildstore.BuildDataDirName && len(pcs) > i+1 {
-								commitID = pcs[i+1]
-								break
-							}
-						}
+					if !c.NoCheckFiles && len(pcs) > 0 {
+						// Infer commit ID from file path (the path
+						// component after the build data dir).
+						commitID = pcs[0]
 					}
 					if commitID == "" && !c.NoCheckFiles {
 						return fmt.Errorf("couldn't infer which commit ID %s was built from, which is necessary to check that file/dir fields refer to actual files; either run with --no-check-files to skip the file/dir check or pass paths that contain '.../.srclib-cache/COMMITID/...' (which allows this command to infer the commit ID)", w.Path())
@@ -324,8 +323,21 @@ func lintGraphOutput(baseDir, repoURI, unitType, unitName, path string, checkFil
 }
 
 func lintDepresolveOutput(baseDir, path string, checkFilesExist bool) (issues []string, err error) {
-	// TODO(sqs): lint depresolve output
-	return nil, nil
+	var ress []*dep.Resolution
+	if err := readJSONFile(path, &ress); err != nil {
+		return nil, err
+	}
+
+	for i, res := range ress {
+		label := fmt.Sprintf("Resolution %d (raw dep %v)", i, res.Raw)
+		if res.Target == nil && res.Error == "" {
+			issues = append(issues, label+": either Target or Error must be set")
+		}
+		if res.Target != nil && res.Target.ToRepoCloneURL == "" && res.Target.ToUnit == "" {
+			issues = append(issues, label+": Target must have a ToRepoCloneURL or ToUnit")
+		}
+	}
+	return issues, nil
 }
 
 func lintCheckFiles(baseDir string, checkExist bool, fn func(os.FileInfo) error, paths ...string) (issues []string, err error) {
@@ -375,3 +387,24 @@ func lintCheckFiles(baseDir string, checkExist bool, fn func(os.FileInfo) error,
 	}
 	return issues, nil
 }
+
+// buildDataPathComponents returns the components of absPath (the path
+// of a build data file) after the build data dir that contains it: the
+// commit ID, the components of the source unit's name, and the file's
+// name. The build data dir is root (the local repo's build data dir,
+// if non-empty) or any dir named .srclib-cache. It returns nil if
+// absPath is in neither.
+func buildDataPathComponents(absPath, root string) []string {
+	if root != "" {
+		if rel, err := filepath.Rel(root, absPath); err == nil && !strings.HasPrefix(rel, "..") {
+			return strings.Split(rel, string(os.PathSeparator))
+		}
+	}
+	pcs := strings.Split(absPath, string(os.PathSeparator))
+	for i, pc := range pcs {
+		if pc == buildstore.BuildDataDirName {
+			return pcs[i+1:]
+		}
+	}
+	return nil
+}
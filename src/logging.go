@@ -0,0 +1,149 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log message. Messages below the
+// level given by the --log-level flag are not logged.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota - 1
+	levelInfo           // the zero value, so that it is the default
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+func (l logLevel) String() string { return logLevelNames[l] }
+
+// UnmarshalFlag implements flags.Unmarshaler.
+func (l *logLevel) UnmarshalFlag(value string) error {
+	for level, name := range logLevelNames {
+		if name == value {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid log level %q (valid levels are debug, info, warn, and error)", value)
+}
+
+// msgFormat is the format of log and error messages: "text" (the
+// default) or "json" (one JSON object per line, for parsing by CI
+// systems and other tools).
+type msgFormat string
+
+// UnmarshalFlag implements flags.Unmarshaler.
+func (f *msgFormat) UnmarshalFlag(value string) error {
+	if value != "text" && value != "json" {
+		return fmt.Errorf("invalid message format %q (valid formats are text and json)", value)
+	}
+	*f = msgFormat(value)
+	return nil
+}
+
+// leveledLogger logs messages whose level is at least the level given
+// by the --log-level flag (or debug, if -v is given) in the format
+// given by the --log-format flag.
+type leveledLogger struct {
+	mu sync.Mutex
+	w  io.Writer // where JSON log messages are written
+}
+
+// logger is the leveled logger used by src commands.
+var logger = &leveledLogger{w: os.Stderr}
+
+// enabled returns whether messages at the given level are logged.
+func (l *leveledLogger) enabled(level logLevel) bool {
+	min := GlobalOpt.LogLevel
+	if GlobalOpt.Verbose && min > levelDebug {
+		min = levelDebug
+	}
+	return level >= min
+}
+
+// jsonLogMessage is a log message in the JSON log format.
+type jsonLogMessage struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, v ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if GlobalOpt.LogFormat != "json" {
+		if level == levelWarn {
+			msg = "Warning: " + msg
+		}
+		log.Print(msg)
+		return
+	}
+
+	// The "# " prefix of many messages marks them as comments in
+	// text output; it is not part of the message.
+	b, err := json.Marshal(jsonLogMessage{
+		Time:  time.Now().UTC(),
+		Level: level.String(),
+		Msg:   strings.TrimPrefix(msg, "# "),
+	})
+	if err != nil {
+		panic(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(b, '\n'))
+}
+
+func (l *leveledLogger) Debugf(format string, v ...interface{}) { l.logf(levelDebug, format, v...) }
+func (l *leveledLogger) Infof(format string, v ...interface{})  { l.logf(levelInfo, format, v...) }
+func (l *leveledLogger) Warnf(format string, v ...interface{})  { l.logf(levelWarn, format, v...) }
+func (l *leveledLogger) Errorf(format string, v ...interface{}) { l.logf(levelError, format, v...) }
+
+// Fatalf logs an error message and exits with status 1.
+func (l *leveledLogger) Fatalf(format string, v ...interface{}) {
+	l.Errorf(format, v...)
+	os.Exit(1)
+}
+
+// writer returns an io.Writer that logs each line written to it as a
+// message at the given level.
+func (l *leveledLogger) writer(level logLevel) io.Writer {
+	return &logWriter{l: l, level: level}
+}
+
+type logWriter struct {
+	l     *leveledLogger
+	level logLevel
+	buf   []byte // incomplete last line
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+		w.l.logf(w.level, "%s", w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -1,19 +1,25 @@
 package src
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"time"
 
 	"sourcegraph.com/sourcegraph/makex"
 
 	"strings"
 
+	"sourcegraph.com/sourcegraph/srclib"
 	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/flagutil"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
 )
 
 func init() {
@@ -35,6 +41,7 @@ type MakeCmd struct {
 
 	ToolchainExecOpt `group:"execution"`
 	BuildCacheOpt    `group:"build cache"`
+	PlanOpt          `group:"planning"`
 
 	Quiet  bool `short:"q" long:"quiet" description:"silence all output"`
 	DryRun bool `short:"n" long:"dry-run" description:"print what would be done and exit"`
@@ -55,7 +62,7 @@ func (c *MakeCmd) Execute(args []string) error {
 		}
 	}
 
-	mf, err := CreateMakefile(c.ToolchainExecOpt, c.BuildCacheOpt)
+	mf, err := CreateMakefile(c.ToolchainExecOpt, c.BuildCacheOpt, c.PlanOpt)
 	if err != nil {
 		return err
 	}
@@ -80,18 +87,222 @@ func (c *MakeCmd) Execute(args []string) error {
 	if c.DryRun {
 		return mk.DryRun(os.Stdout)
 	}
-	return mk.Run()
+
+	// When importing, lock the commit in the store while the graph
+	// rules import data into it.
+	var stor interface{}
+	if c.Import {
+		storeCmd.allowCreate = true
+		if stor, err = OpenStore(); err != nil {
+			return err
+		}
+		localRepo, err := OpenRepo(".")
+		if err != nil {
+			return err
+		}
+		unlock, err := lockCommit(stor, localRepo.URI(), localRepo.CommitID, true)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := unlock(); err != nil {
+				logger.Warnf("releasing store lock: %s", err)
+			}
+		}()
+	}
+
+	statsFile, removeStatsFile, err := newRuleStatsFile()
+	if err != nil {
+		return err
+	}
+	defer removeStatsFile()
+	start := time.Now()
+	if err := mk.Run(); err != nil {
+		return newCmdError(ExitToolchainFailure, err)
+	}
+	if err := writeLocalBuildInfo(mf, start, statsFile, c.PlanOpt); err != nil {
+		return err
+	}
+	if err := writeLocalChecksumManifest(); err != nil {
+		return err
+	}
+	if c.Import {
+		return importLocalBuildData(stor, c.Quiet)
+	}
+	return nil
+}
+
+// importLocalBuildData imports the local repo's build data at its
+// current commit into stor, after 'src make --import' has imported the
+// graph output directly into it: it imports the remaining graph output
+// and the other build data, builds indexes, and records the data's
+// provenance.
+func importLocalBuildData(stor interface{}, quiet bool) error {
+	start := time.Now()
+	localRepo, err := OpenRepo(".")
+	if err != nil {
+		return err
+	}
+	bdfs, label, err := getLocalBuildDataFS(localRepo.CommitID)
+	if err != nil {
+		return err
+	}
+	conf, err := storeCmd.config()
+	if err != nil {
+		return err
+	}
+	opt := ImportOpt{
+		Repo:      localRepo.URI(),
+		CommitID:  localRepo.CommitID,
+		Source:    label,
+		Hooks:     &conf.Hooks,
+		MaxMemory: int64(storeCmd.MaxMemory),
+	}
+	if err := Import(bdfs, stor, opt); err != nil {
+		return err
+	}
+	if !quiet {
+		logger.Infof("# Import completed in %s.", time.Since(start))
+	}
+	return nil
+}
+
+// writeLocalBuildInfo writes the build info (see buildstore.BuildInfo)
+// for the local repo's build data at its current commit, so that the
+// provenance of the data can be recorded when it is imported. The
+// resource usage of the rules that were run is read from statsFile
+// (see recordRuleStats). It must be called before
+// writeLocalChecksumManifest.
+func writeLocalBuildInfo(mf *makex.Makefile, start time.Time, statsFile string, planOpt PlanOpt) error {
+	localRepo, err := OpenRepo(".")
+	if err != nil {
+		return err
+	}
+	buildStore, err := localBuildStore(localRepo.RootDir)
+	if err != nil {
+		return err
+	}
+	commitFS := buildStore.Commit(localRepo.CommitID)
+	if _, err := commitFS.Stat("."); os.IsNotExist(err) {
+		return nil
+	}
+
+	info := &buildstore.BuildInfo{
+		SrclibVersion: Version,
+		Toolchains:    toolchainVersions(mf.Rules),
+		Started:       start,
+		Duration:      time.Since(start),
+	}
+	info.Host, _ = os.Hostname()
+	if info.BaseCommitID, err = planOpt.sinceCommitID(localRepo); err != nil {
+		return err
+	}
+	buildDataDir, err := makeBuildDataDir(localRepo.RootDir, localRepo.CommitID)
+	if err != nil {
+		return err
+	}
+	if info.Rules, err = readRuleStats(statsFile, mf.Rules, buildDataDir); err != nil {
+		logger.Warnf("reading resource usage of rules: %s", err)
+	}
+	if err := buildstore.WriteBuildInfo(commitFS, info); err != nil {
+		return fmt.Errorf("writing build info: %s", err)
+	}
+	return nil
+}
+
+// toolchainVersions returns a map of the paths of the toolchains used
+// by rules to their versions (the VCS revisions of their dirs), or to
+// "" if their versions can't be determined (e.g., because they aren't
+// in a VCS repository or are run in Docker from a prebuilt image).
+func toolchainVersions(rules []makex.Rule) map[string]string {
+	versions := map[string]string{}
+	for _, rule := range rules {
+		var tool *srclib.ToolRef
+		switch rule := rule.(type) {
+		case *grapher.GraphUnitRule:
+			tool = rule.Tool
+		case *dep.ResolveDepsRule:
+			tool = rule.Tool
+		}
+		if tool == nil {
+			continue
+		}
+		if _, seen := versions[tool.Toolchain]; seen {
+			continue
+		}
+		var version string
+		if tc, err := toolchain.Lookup(tool.Toolchain); err == nil && tc != nil {
+			if rev, err := resolveWorkingTreeRevision("git", tc.Dir); err == nil {
+				version = rev
+			}
+		}
+		versions[tool.Toolchain] = version
+	}
+	return versions
+}
+
+// writeLocalChecksumManifest writes the checksum manifest (see
+// buildstore.WriteChecksumManifest) for the local repo's build data
+// at its current commit, so that `src store import --verify` can
+// detect truncated or corrupted build data files.
+func writeLocalChecksumManifest() error {
+	localRepo, err := OpenRepo(".")
+	if err != nil {
+		return err
+	}
+	buildStore, err := localBuildStore(localRepo.RootDir)
+	if err != nil {
+		return err
+	}
+	commitFS := buildStore.Commit(localRepo.CommitID)
+	if _, err := commitFS.Stat("."); os.IsNotExist(err) {
+		return nil
+	}
+	if err := buildstore.WriteChecksumManifest(commitFS); err != nil {
+		return fmt.Errorf("writing build data checksum manifest: %s", err)
+	}
+	return nil
+}
+
+// PlanOpt holds options that determine which source units are built.
+type PlanOpt struct {
+	Since string `long:"since" description:"only build the source units containing files that changed between COMMIT and the working tree (according to the VCS diff and the source units' files from 'src config')" value-name:"COMMIT"`
+
+	Overlay bool `long:"overlay" description:"only build the source units affected by uncommitted changes (like --since with the commit that the working tree is based on, but also considering untracked files), so that 'src store --overlay' queries merge their data over the base commit's; requires SRCLIB_WORKING_TREE=1"`
+
+	DefsManifest bool `long:"defs-manifest" description:"give graphers that support it a manifest of the exported defs of the source units that each source unit depends on (from the current build and the store), so they can resolve refs to them precisely"`
+
+	Import bool `long:"import" description:"import the graph output directly into the store (see 'src store'; the store is chosen by the Srcfile, .srclibrc, and SRC_STORE_* environment variables) instead of writing it to the build data, and then import the rest of the build data and build indexes; implies --no-cache"`
+}
+
+// sinceCommitID returns the commit such that only the source units
+// that changed since it are built (the --since commit, or with
+// --overlay, the commit that the working tree is based on), or "" if
+// all source units are built.
+func (o PlanOpt) sinceCommitID(localRepo *Repo) (string, error) {
+	if !o.Overlay {
+		return o.Since, nil
+	}
+	if o.Since != "" {
+		return "", newCmdError(ExitUsage, errors.New("--overlay and --since are mutually exclusive"))
+	}
+	if !workingTreeKeys() {
+		return "", newCmdError(ExitUsage, fmt.Errorf("--overlay requires %s=1 (so that the working tree's data is built and stored under its own commit ID)", workingTreeEnv))
+	}
+	// BaseCommitID is empty if the working tree has no uncommitted
+	// changes, in which case its commit is built as usual.
+	return localRepo.BaseCommitID, nil
 }
 
 // CreateMakefile creates a Makefile to build a tree. The cwd should
 // be the root of the tree you want to make (due to some probably
 // unnecessary assumptions that CreateMaker makes).
-func CreateMakefile(execOpt ToolchainExecOpt, cacheOpt BuildCacheOpt) (*makex.Makefile, error) {
+func CreateMakefile(execOpt ToolchainExecOpt, cacheOpt BuildCacheOpt, planOpt PlanOpt) (*makex.Makefile, error) {
 	localRepo, err := OpenRepo(".")
 	if err != nil {
 		return nil, err
 	}
-	buildStore, err := buildstore.LocalRepo(localRepo.RootDir)
+	buildStore, err := localBuildStore(localRepo.RootDir)
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +312,34 @@ func CreateMakefile(execOpt ToolchainExecOpt, cacheOpt BuildCacheOpt) (*makex.Ma
 		return nil, err
 	}
 	if len(treeConfig.SourceUnits) == 0 {
-		log.Println("No source unit files found. Did you mean to run `src config`? (This is not an error; it just means that src didn't find anything to build or analyze here.)")
+		logger.Infof("No source unit files found. Did you mean to run `src config`? (This is not an error; it just means that src didn't find anything to build or analyze here.)")
+	}
+
+	since, err := planOpt.sinceCommitID(localRepo)
+	if err != nil {
+		return nil, err
+	}
+	if since != "" {
+		changedFiles, err := plan.ChangedFiles(localRepo.VCSType, since)
+		if err != nil {
+			return nil, err
+		}
+		if planOpt.Overlay {
+			untracked, err := untrackedFiles(localRepo.VCSType, localRepo.RootDir)
+			if err != nil {
+				return nil, err
+			}
+			changedFiles = append(changedFiles, untracked...)
+		}
+		affected := plan.AffectedUnits(treeConfig.SourceUnits, changedFiles)
+		logger.Infof("# %d files changed since %s, affecting %d of %d source units", len(changedFiles), since, len(affected), len(treeConfig.SourceUnits))
+		for _, u := range affected {
+			logger.Debugf("#   %s %s", u.Name, u.Type)
+		}
+		// Don't modify the cached config.
+		tc := *treeConfig
+		tc.SourceUnits = affected
+		treeConfig = &tc
 	}
 
 	toolchainExecOptArgs, err := flagutil.MarshalArgs(&execOpt)
@@ -109,12 +347,25 @@ func CreateMakefile(execOpt ToolchainExecOpt, cacheOpt BuildCacheOpt) (*makex.Ma
 		return nil, err
 	}
 
-	// TODO(sqs): buildDataDir is hardcoded.
-	buildDataDir := filepath.Join(buildstore.BuildDataDirName, localRepo.CommitID)
-	mf, err := plan.CreateMakefile(buildDataDir, buildStore, localRepo.VCSType, treeConfig, plan.Options{
+	buildDataDir, err := makeBuildDataDir(localRepo.RootDir, localRepo.CommitID)
+	if err != nil {
+		return nil, err
+	}
+	opt := plan.Options{
 		ToolchainExecOpt: strings.Join(toolchainExecOptArgs, " "),
 		NoCache:          cacheOpt.NoCacheWrite,
-	})
+		DepresolveCache:  !cacheOpt.NoDepresolveCache,
+		RecordRuleStats:  true,
+		DefsManifest:     planOpt.DefsManifest,
+	}
+	if planOpt.Import {
+		// Graph output that was imported directly into the store
+		// isn't in the build data, so it can't be reused by later
+		// builds.
+		opt.NoCache = true
+		opt.ImportArgs = fmt.Sprintf("--repo %q --commit %q", localRepo.URI(), localRepo.CommitID)
+	}
+	mf, err := plan.CreateMakefile(buildDataDir, buildStore, localRepo.VCSType, treeConfig, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -21,12 +21,13 @@ func init() {
 type MakefileCmd struct {
 	ToolchainExecOpt `group:"execution"`
 	BuildCacheOpt    `group:"build cache"`
+	PlanOpt          `group:"planning"`
 }
 
 var makefileCmd MakefileCmd
 
 func (c *MakefileCmd) Execute(args []string) error {
-	mf, err := CreateMakefile(c.ToolchainExecOpt, c.BuildCacheOpt)
+	mf, err := CreateMakefile(c.ToolchainExecOpt, c.BuildCacheOpt, c.PlanOpt)
 	if err != nil {
 		return err
 	}
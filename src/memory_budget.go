@@ -0,0 +1,239 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// byteSize is a flag value for a number of bytes, with an optional K,
+// M, or G suffix (e.g., 512M).
+type byteSize int64
+
+// UnmarshalFlag implements flags.Unmarshaler.
+func (b *byteSize) UnmarshalFlag(value string) error {
+	s := strings.TrimSuffix(strings.ToUpper(value), "B")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q (want a number of bytes, optionally with a K, M, or G suffix)", value)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
+
+// decodedSizeFactor is the estimated ratio of the memory used by
+// decoded graph data to the size of its JSON build data file.
+const decodedSizeFactor = 3
+
+// memoryBudget limits the estimated memory used by concurrent
+// operations (e.g., decoding and writing source units during import).
+// It only limits how many operations run at once: operations don't
+// spill their data to disk, so one that is larger than the budget
+// still uses as much memory as it needs (while it runs alone). A nil
+// *memoryBudget imposes no limit.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	total int64
+	used  int64
+}
+
+// newMemoryBudget returns a memoryBudget of total bytes, or nil if
+// total is not positive.
+func newMemoryBudget(total int64) *memoryBudget {
+	if total <= 0 {
+		return nil
+	}
+	b := &memoryBudget{total: total}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes of the budget are available and
+// reserves them. It returns the number of bytes reserved, which must
+// be passed to release. If n exceeds the whole budget, it waits until
+// nothing else holds any of the budget and reserves all of it, so
+// that an operation larger than the budget runs alone.
+func (b *memoryBudget) acquire(n int64) int64 {
+	if b == nil {
+		return 0
+	}
+	if n > b.total {
+		n = b.total
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used+n > b.total {
+		b.cond.Wait()
+	}
+	b.used += n
+	return n
+}
+
+// exceeds reports whether an operation of n bytes is larger than the
+// whole budget (and will run alone).
+func (b *memoryBudget) exceeds(n int64) bool {
+	return b != nil && n > b.total
+}
+
+// release returns n bytes (as returned by acquire) to the budget.
+func (b *memoryBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// queryUnits returns the units (sorted by key, unless filters
+// contains an Unordered filter) that may contain results of a query
+// with the given filters, for querying one unit at a time.
+func queryUnits(s interface{}, filters []interface{}) ([]*unit.SourceUnit, error) {
+	ts, ok := s.(store.TreeStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing units", s)
+	}
+	var ufs []store.UnitFilter
+	for _, f := range filters {
+		if uf, ok := f.(store.UnitFilter); ok {
+			ufs = append(ufs, uf)
+		}
+	}
+	return ts.Units(ufs...)
+}
+
+// unitQueryFilters returns filters that select only the data in u.
+func unitQueryFilters(u *unit.SourceUnit) []interface{} {
+	fs := []interface{}{store.ByUnits(u.ID2())}
+	if u.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(u.CommitID))
+	}
+	if u.Repo != "" {
+		fs = append(fs, store.ByRepos(u.Repo))
+	}
+	return fs
+}
+
+// streamDefs prints the defs that match filters as a JSON array
+// (formatted like PrintJSON), querying and printing one unit's defs
+// at a time so that only one unit's defs are in memory at once.
+// Because results are sorted by key and keys begin with the unit,
+// the output order is the same as that of a single query.
+func streamDefs(s store.UnitStore, filters []store.DefFilter) error {
+	anyFilters := make([]interface{}, len(filters))
+	for i, f := range filters {
+		anyFilters[i] = f
+	}
+	units, err := queryUnits(s, anyFilters)
+	if err != nil {
+		return err
+	}
+	w := newJSONArrayWriter()
+	for _, u := range units {
+		fs := append([]store.DefFilter{}, filters...)
+		for _, f := range unitQueryFilters(u) {
+			fs = append(fs, f.(store.DefFilter))
+		}
+		defs, err := s.Defs(fs...)
+		if err != nil {
+			return err
+		}
+		for _, def := range defs {
+			if err := w.write(def); err != nil {
+				return err
+			}
+		}
+	}
+	return w.close()
+}
+
+// streamRefs is like streamDefs, but for refs.
+func streamRefs(s store.UnitStore, filters []store.RefFilter) error {
+	anyFilters := make([]interface{}, len(filters))
+	for i, f := range filters {
+		anyFilters[i] = f
+	}
+	units, err := queryUnits(s, anyFilters)
+	if err != nil {
+		return err
+	}
+	w := newJSONArrayWriter()
+	for _, u := range units {
+		fs := append([]store.RefFilter{}, filters...)
+		for _, f := range unitQueryFilters(u) {
+			fs = append(fs, f.(store.RefFilter))
+		}
+		refs, err := s.Refs(fs...)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if err := w.write(ref); err != nil {
+				return err
+			}
+		}
+	}
+	return w.close()
+}
+
+// jsonArrayWriter writes a JSON array to stdout one element at a
+// time, in the same format as PrintJSON(v, "").
+type jsonArrayWriter struct {
+	w *bufio.Writer
+	n int
+}
+
+func newJSONArrayWriter() *jsonArrayWriter {
+	return &jsonArrayWriter{w: bufio.NewWriter(os.Stdout)}
+}
+
+func (w *jsonArrayWriter) write(v interface{}) error {
+	b, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if w.n == 0 {
+		sep = "[\n  "
+	}
+	w.n++
+	if _, err := w.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = w.w.Write(b)
+	return err
+}
+
+func (w *jsonArrayWriter) close() error {
+	end := "\n]\n"
+	if w.n == 0 {
+		end = "[]\n"
+	}
+	if _, err := w.w.WriteString(end); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
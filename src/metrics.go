@@ -0,0 +1,236 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// daemonMetrics are the live metrics of this process's store daemon
+// ('src store serve' or 'src store importd'), which are served as
+// JSON at /metrics and displayed by 'src store top'.
+var daemonMetrics = newLiveMetrics()
+
+// maxRecentQueries is the number of completed queries kept in the
+// metrics.
+const maxRecentQueries = 200
+
+// liveMetrics tracks a daemon's in-flight and recently completed
+// queries (HTTP requests) and its imports.
+type liveMetrics struct {
+	start time.Time
+
+	mu       sync.Mutex
+	nextID   int64
+	inFlight map[int64]*queryMetrics
+	recent   []*queryMetrics // most recent last
+	queries  int64           // completed queries
+	errors   int64           // completed queries with an error status
+	queued   int             // import bundles waiting to be imported
+
+	// Import counters (updated atomically).
+	activeImports                             int64
+	importedUnits, importedDefs, importedRefs int64
+}
+
+func newLiveMetrics() *liveMetrics {
+	return &liveMetrics{start: time.Now(), inFlight: map[int64]*queryMetrics{}}
+}
+
+// queryMetrics describes an in-flight or completed query.
+type queryMetrics struct {
+	ID     int64
+	Method string // HTTP method and path (e.g., "POST /Editor.Refs")
+	Query  string `json:",omitempty"` // URL query
+	Client string // client's address
+	Start  time.Time
+
+	// Duration is how long the query took (or, if it is in flight,
+	// how long it has been running).
+	Duration time.Duration
+
+	// Status is the HTTP status of the response (0 if the query is in
+	// flight).
+	Status int `json:",omitempty"`
+}
+
+// metricsSnapshot is the JSON representation of a daemon's metrics.
+type metricsSnapshot struct {
+	Time   time.Time
+	Uptime time.Duration
+
+	InFlight      []*queryMetrics // sorted by start time
+	RecentQueries []*queryMetrics // most recent first
+	Queries       int64           // completed queries
+	QueryErrors   int64           // completed queries with an error status
+
+	Imports struct {
+		Active int64 // imports in progress
+		Queued int   // bundles waiting to be imported (importd only)
+
+		// Units, Defs, and Refs are the number of source units, defs,
+		// and refs imported since the daemon started.
+		Units, Defs, Refs int64
+	}
+
+	// Caches are the hit and miss counts of the store's caches (see
+	// store.AllCacheStats).
+	Caches map[string]store.CacheStats
+}
+
+// queryStarted records the start of a query and returns its metrics,
+// which must be passed to queryDone when it completes.
+func (m *liveMetrics) queryStarted(r *http.Request) *queryMetrics {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	q := &queryMetrics{Method: r.Method + " " + r.URL.Path, Query: r.URL.RawQuery, Client: client, Start: time.Now()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	q.ID = m.nextID
+	m.inFlight[q.ID] = q
+	return q
+}
+
+// queryDone records that a query completed with the given HTTP
+// status.
+func (m *liveMetrics) queryDone(q *queryMetrics, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.inFlight, q.ID)
+	q.Duration = time.Since(q.Start)
+	q.Status = status
+	m.queries++
+	if status >= 400 {
+		m.errors++
+	}
+	m.recent = append(m.recent, q)
+	if len(m.recent) > maxRecentQueries {
+		m.recent = append([]*queryMetrics{}, m.recent[len(m.recent)-maxRecentQueries:]...)
+	}
+}
+
+// importStarted and importDone record the start and end of an
+// import.
+func (m *liveMetrics) importStarted() { atomic.AddInt64(&m.activeImports, 1) }
+func (m *liveMetrics) importDone()    { atomic.AddInt64(&m.activeImports, -1) }
+
+// unitImported records that a source unit with the given number of
+// defs and refs was imported.
+func (m *liveMetrics) unitImported(defs, refs int) {
+	atomic.AddInt64(&m.importedUnits, 1)
+	atomic.AddInt64(&m.importedDefs, int64(defs))
+	atomic.AddInt64(&m.importedRefs, int64(refs))
+}
+
+// setQueued records the number of bundles waiting to be imported.
+func (m *liveMetrics) setQueued(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued = n
+}
+
+func (m *liveMetrics) snapshot() *metricsSnapshot {
+	now := time.Now()
+	s := &metricsSnapshot{Time: now, Uptime: now.Sub(m.start)}
+
+	m.mu.Lock()
+	for _, q := range m.inFlight {
+		q2 := *q
+		q2.Duration = now.Sub(q.Start)
+		s.InFlight = append(s.InFlight, &q2)
+	}
+	s.RecentQueries = make([]*queryMetrics, len(m.recent))
+	for i, q := range m.recent {
+		s.RecentQueries[len(m.recent)-1-i] = q
+	}
+	s.Queries, s.QueryErrors = m.queries, m.errors
+	s.Imports.Queued = m.queued
+	m.mu.Unlock()
+	sortQueriesByStart(s.InFlight)
+
+	s.Imports.Active = atomic.LoadInt64(&m.activeImports)
+	s.Imports.Units = atomic.LoadInt64(&m.importedUnits)
+	s.Imports.Defs = atomic.LoadInt64(&m.importedDefs)
+	s.Imports.Refs = atomic.LoadInt64(&m.importedRefs)
+	s.Caches = store.AllCacheStats()
+	return s
+}
+
+// ServeHTTP serves the metrics as JSON.
+func (m *liveMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(m.snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// metricsHandler records the requests to h (except for requests for
+// the metrics themselves) as queries in m.
+type metricsHandler struct {
+	h http.Handler
+	m *liveMetrics
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		h.h.ServeHTTP(w, r)
+		return
+	}
+	q := h.m.queryStarted(r)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	defer func() { h.m.queryDone(q, sw.status) }()
+	h.h.ServeHTTP(sw, r)
+}
+
+// statusWriter records the status of an HTTP response. It passes
+// through the optional interfaces that the store's handlers use
+// (http.CloseNotifier, http.Flusher, and http.Hijacker).
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response does not support hijacking")
+}
+
+func sortQueriesByStart(qs []*queryMetrics) { sort.Sort(queriesByStart(qs)) }
+
+type queriesByStart []*queryMetrics
+
+func (v queriesByStart) Len() int           { return len(v) }
+func (v queriesByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v queriesByStart) Less(i, j int) bool { return v[i].Start.Before(v[j].Start) }
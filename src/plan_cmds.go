@@ -0,0 +1,338 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aybabtme/color/brush"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+)
+
+func init() {
+	c, err := CLI.AddCommand("plan",
+		"inspect the build plan",
+		"The plan subcommands inspect the plan (the Makefile that 'src make' executes) and the results of executing it.",
+		&struct{}{},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("explain",
+		"explain which rules 'src make' will run, and why",
+		`The explain command shows the plan (the Makefile rules) that 'src make' will execute as a dependency tree, and predicts whether each rule will be run, copied from a previous commit's build data (a build cache hit), or skipped because its target is up to date. Each prediction is explained (e.g., "prereq foo.go is newer than the target, and the unit's files changed since COMMIT (foo.go)"), so you can see why a source unit will or won't be re-graphed before running 'src make'.
+
+With --output=dot, the plan is printed in Graphviz DOT format (e.g., pipe it to 'dot -Tsvg'); run rules are red, copied rules are yellow, and up-to-date rules are green. With --output=json, the explanation of each rule is printed.`,
+		&planExplainCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("stats",
+		"show resource usage of rules in the last build",
+		`The stats command shows the wall time, CPU time, and peak resident set size (RSS) of each toolchain rule (such as graphing or resolving the dependencies of a source unit) that ran in the last 'src make' of the current repository's checked-out commit, to help find the source units that are slowest or use the most memory. Rules that were already up to date are not shown.
+
+CPU time and peak RSS are of the tool's process and its children. They aren't available on all systems, and in Docker mode they only measure the docker client.
+
+Use 'src store units --with-build-stats' to see the resource usage recorded in the store when the build data was imported.`,
+		&planStatsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type PlanExplainCmd struct {
+	ToolchainExecOpt `group:"execution"`
+	BuildCacheOpt    `group:"build cache"`
+	PlanOpt          `group:"planning"`
+
+	Files bool `long:"files" description:"show the rules' source file prereqs (not just their number)"`
+
+	Output struct {
+		Output string `short:"o" long:"output" description:"output format" default:"text" value-name:"text|dot|json"`
+	}
+}
+
+var planExplainCmd PlanExplainCmd
+
+func (c *PlanExplainCmd) Execute(args []string) error {
+	switch c.Output.Output {
+	case "text", "dot", "json":
+	default:
+		return newCmdError(ExitUsage, fmt.Errorf("invalid --output %q (must be text, dot, or json)", c.Output.Output))
+	}
+
+	mf, err := CreateMakefile(c.ToolchainExecOpt, c.BuildCacheOpt, c.PlanOpt)
+	if err != nil {
+		return err
+	}
+
+	// Find the previous build that CreateMakefile reused build data
+	// from, to explain the cached rules.
+	var prev *plan.PrevBuild
+	if !c.NoCacheWrite {
+		localRepo, err := OpenRepo(".")
+		if err != nil {
+			return err
+		}
+		buildStore, err := localBuildStore(localRepo.RootDir)
+		if err != nil {
+			return err
+		}
+		prev = plan.FindPrevBuild(buildStore, localRepo.VCSType)
+	}
+
+	xs := plan.Explain(mf, prev, plan.Options{NoCache: c.NoCacheWrite})
+	switch c.Output.Output {
+	case "json":
+		if xs == nil {
+			xs = []*plan.RuleExplanation{}
+		}
+		PrintJSON(xs, "  ")
+	case "dot":
+		writePlanDOT(os.Stdout, xs, c.Files)
+	default:
+		writePlanTree(os.Stdout, xs, c.Files)
+	}
+	return nil
+}
+
+// writePlanTree writes the rules explained by xs to w as a text tree
+// rooted at the "all" target.
+func writePlanTree(w io.Writer, xs []*plan.RuleExplanation, files bool) {
+	byTarget := make(map[string]*plan.RuleExplanation, len(xs))
+	for _, x := range xs {
+		byTarget[x.Target] = x
+	}
+
+	printed := map[string]bool{}
+	var printRule func(x *plan.RuleExplanation, indent string, last bool)
+	printRule = func(x *plan.RuleExplanation, indent string, last bool) {
+		branch, childIndent := "├── ", indent+"│   "
+		if last {
+			branch, childIndent = "└── ", indent+"    "
+		}
+		fmt.Fprintf(w, "%s%s%s %s\n", indent, branch, colorRuleStatus(x.Status), x.Target)
+		if printed[x.Target] {
+			fmt.Fprintf(w, "%s(see above)\n", childIndent)
+			return
+		}
+		printed[x.Target] = true
+		if x.Unit != "" {
+			fmt.Fprintf(w, "%s%s %s: %s\n", childIndent, x.Unit, x.UnitType, x.Reason)
+		} else {
+			fmt.Fprintf(w, "%s%s\n", childIndent, x.Reason)
+		}
+
+		var rules, other []string
+		for _, prereq := range x.Prereqs {
+			if _, isRule := byTarget[prereq]; isRule {
+				rules = append(rules, prereq)
+			} else {
+				other = append(other, prereq)
+			}
+		}
+		if !files && len(other) > 0 {
+			other = []string{"(" + numFiles(len(other)) + ")"}
+		}
+		for i, prereq := range rules {
+			printRule(byTarget[prereq], childIndent, i == len(rules)-1 && len(other) == 0)
+		}
+		for i, prereq := range other {
+			branch := "├── "
+			if i == len(other)-1 {
+				branch = "└── "
+			}
+			fmt.Fprintf(w, "%s%s%s\n", childIndent, branch, prereq)
+		}
+	}
+
+	// Rules that no other rule depends on are the prereqs of "all".
+	isPrereq := map[string]bool{}
+	for _, x := range xs {
+		for _, prereq := range x.Prereqs {
+			isPrereq[prereq] = true
+		}
+	}
+	var roots []*plan.RuleExplanation
+	counts := map[plan.RuleStatus]int{}
+	for _, x := range xs {
+		if !isPrereq[x.Target] {
+			roots = append(roots, x)
+		}
+		counts[x.Status]++
+	}
+	fmt.Fprintln(w, "all")
+	for i, x := range roots {
+		printRule(x, "", i == len(roots)-1)
+	}
+	fmt.Fprintf(w, "\n# %d rules: %d to run, %d to copy from a previous build, %d up to date\n", len(xs), counts[plan.Run], counts[plan.Copy], counts[plan.UpToDate])
+}
+
+func colorRuleStatus(status plan.RuleStatus) string {
+	s := fmt.Sprintf("[%s]", status)
+	switch status {
+	case plan.Run:
+		return brush.Red(s).String()
+	case plan.Copy:
+		return brush.Yellow(s).String()
+	}
+	return brush.Green(s).String()
+}
+
+// writePlanDOT writes the rules explained by xs to w as a Graphviz
+// DOT graph.
+func writePlanDOT(w io.Writer, xs []*plan.RuleExplanation, files bool) {
+	colors := map[plan.RuleStatus]string{plan.Run: "red", plan.Copy: "gold", plan.UpToDate: "green"}
+	isRule := make(map[string]bool, len(xs))
+	isPrereq := map[string]bool{}
+	for _, x := range xs {
+		isRule[x.Target] = true
+		for _, prereq := range x.Prereqs {
+			isPrereq[prereq] = true
+		}
+	}
+
+	fmt.Fprintln(w, "digraph plan {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	fmt.Fprintln(w, `  "all" [shape=doublecircle];`)
+	for _, x := range xs {
+		label := x.Target
+		if x.Unit != "" {
+			label += "\n" + x.Unit + " " + x.UnitType
+		}
+		label += "\n" + string(x.Status)
+		fmt.Fprintf(w, "  %s [label=%s, color=%s, tooltip=%s];\n", dotQuote(x.Target), dotQuote(label), colors[x.Status], dotQuote(x.Reason))
+		if !isPrereq[x.Target] {
+			fmt.Fprintf(w, "  \"all\" -> %s;\n", dotQuote(x.Target))
+		}
+		var nfiles int
+		for _, prereq := range x.Prereqs {
+			if !files && !isRule[prereq] {
+				nfiles++
+				continue
+			}
+			fmt.Fprintf(w, "  %s -> %s;\n", dotQuote(x.Target), dotQuote(prereq))
+		}
+		if nfiles > 0 {
+			filesNode := x.Target + " files"
+			fmt.Fprintf(w, "  %s [label=%s, shape=note];\n", dotQuote(filesNode), dotQuote(numFiles(nfiles)))
+			fmt.Fprintf(w, "  %s -> %s;\n", dotQuote(x.Target), dotQuote(filesNode))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func numFiles(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+// dotQuote quotes s as a DOT string. Newlines (\n) are kept as DOT
+// line breaks.
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+type PlanStatsCmd struct {
+	Sort  string `long:"sort" description:"sort rules by this resource (descending)" default:"wall" value-name:"wall|cpu|rss"`
+	Op    string `long:"op" description:"only show rules that perform this operation (e.g., graph or depresolve)"`
+	Limit int    `short:"n" long:"limit" description:"max number of rules to show (0 for all)"`
+
+	Output struct {
+		Output string `short:"o" long:"output" description:"output format" default:"text" value-name:"text|json"`
+	}
+}
+
+var planStatsCmd PlanStatsCmd
+
+func (c *PlanStatsCmd) Execute(args []string) error {
+	less, ok := ruleStatsOrders[c.Sort]
+	if !ok {
+		return newCmdError(ExitUsage, fmt.Errorf("invalid --sort %q (must be wall, cpu, or rss)", c.Sort))
+	}
+
+	localRepo, err := OpenRepo(".")
+	if err != nil {
+		return err
+	}
+	buildStore, err := localBuildStore(localRepo.RootDir)
+	if err != nil {
+		return err
+	}
+	info, err := buildstore.ReadBuildInfo(buildStore.Commit(localRepo.CommitID))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no build info for commit %s (run 'src make' first)", localRepo.CommitID)
+	} else if err != nil {
+		return err
+	}
+
+	var rules []*buildstore.RuleStats
+	for _, r := range info.Rules {
+		if c.Op == "" || r.Op == c.Op {
+			rules = append(rules, r)
+		}
+	}
+	sort.Sort(sort.Reverse(ruleStatsSorter{rules, less}))
+	if c.Limit > 0 && len(rules) > c.Limit {
+		rules = rules[:c.Limit]
+	}
+
+	if c.Output.Output == "json" {
+		if rules == nil {
+			rules = []*buildstore.RuleStats{}
+		}
+		PrintJSON(rules, "  ")
+		return nil
+	}
+
+	if len(info.Rules) == 0 {
+		logger.Infof("# No resource usage was recorded for commit %s (all rules were up to date, or the build data was produced by an older src).", localRepo.CommitID)
+		return nil
+	}
+	var wall, cpu time.Duration
+	for _, r := range info.Rules {
+		wall += r.Wall
+		cpu += r.CPU()
+	}
+	fmt.Printf("# %d rules ran in %s (total rule wall time %s, CPU time %s)\n", len(info.Rules), roundDuration(info.Duration), roundDuration(wall), roundDuration(cpu))
+	fmt.Printf("%-10s  %10s  %10s  %8s  %s\n", "OP", "WALL", "CPU", "RSS", "UNIT")
+	for _, r := range rules {
+		name := r.Target
+		if r.Unit != "" {
+			name = r.Unit + " " + r.UnitType
+		}
+		fmt.Printf("%-10s  %10s  %10s  %8s  %s\n", r.Op, roundDuration(r.Wall), roundDuration(r.CPU()), bytesString(uint64(r.MaxRSS)), name)
+	}
+	return nil
+}
+
+var ruleStatsOrders = map[string]func(a, b *buildstore.RuleStats) bool{
+	"wall": func(a, b *buildstore.RuleStats) bool { return a.Wall < b.Wall },
+	"cpu":  func(a, b *buildstore.RuleStats) bool { return a.CPU() < b.CPU() },
+	"rss":  func(a, b *buildstore.RuleStats) bool { return a.MaxRSS < b.MaxRSS },
+}
+
+type ruleStatsSorter struct {
+	rules []*buildstore.RuleStats
+	less  func(a, b *buildstore.RuleStats) bool
+}
+
+func (s ruleStatsSorter) Len() int           { return len(s.rules) }
+func (s ruleStatsSorter) Less(i, j int) bool { return s.less(s.rules[i], s.rules[j]) }
+func (s ruleStatsSorter) Swap(i, j int)      { s.rules[i], s.rules[j] = s.rules[j], s.rules[i] }
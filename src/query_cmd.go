@@ -2,7 +2,6 @@ package src
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,6 +18,7 @@ import (
 	"sort"
 
 	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
 
 	"github.com/peterh/liner"
 )
@@ -1082,17 +1082,7 @@ func formatObject(objs interface{}, f format) string {
 		if f.showDefs {
 			output = append(output, "---------- def ----------")
 			if f.showDefDecl {
-				b, err := json.Marshal(o)
-				if err != nil {
-					return fmt.Sprintf("error unmarshalling: %s", err)
-				}
-				c := &FmtCmd{
-					UnitType:   o.UnitType,
-					ObjectType: "def",
-					Format:     "decl",
-					Object:     string(b),
-				}
-				out, err := c.Get()
+				out, err := toolchain.FormatDef(o, toolchain.DeclFormat)
 				if err != nil {
 					return fmt.Sprintf("error formatting def: %s", err)
 				}
@@ -33,9 +33,7 @@ var remoteImportBuildCmd RemoteImportBuildCmd
 func (c *RemoteImportBuildCmd) Execute(args []string) error {
 	cl := NewAPIClientWithAuthIfPresent()
 
-	if GlobalOpt.Verbose {
-		log.Printf("Creating a new import-only build for repo %q commit %q", remoteCmd.RepoURI, c.CommitID)
-	}
+	logger.Debugf("Creating a new import-only build for repo %q commit %q", remoteCmd.RepoURI, c.CommitID)
 
 	repo, _, err := cl.Repos.GetOrCreate(sourcegraph.RepoSpec{URI: remoteCmd.RepoURI}, nil)
 	if err != nil {
@@ -63,9 +61,7 @@ func (c *RemoteImportBuildCmd) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
-	if GlobalOpt.Verbose {
-		log.Printf("Created build #%d", build.BID)
-	}
+	logger.Debugf("Created build #%d", build.BID)
 
 	now := time.Now()
 	host := fmt.Sprintf("local (USER=%s)", os.Getenv("USER"))
@@ -84,9 +80,7 @@ func (c *RemoteImportBuildCmd) Execute(args []string) error {
 		return err
 	}
 	importTask = tasks[0]
-	if GlobalOpt.Verbose {
-		log.Printf("Created import task #%d", importTask.TaskID)
-	}
+	logger.Debugf("Created import task #%d", importTask.TaskID)
 
 	// Stream logs.
 	done := make(chan struct{})
@@ -100,7 +94,7 @@ func (c *RemoteImportBuildCmd) Execute(args []string) error {
 			case <-time.After(time.Duration(loopsSinceLastLog+1) * 500 * time.Millisecond):
 				logs, _, err := cl.Builds.GetTaskLog(importTask.Spec(), &logOpt)
 				if err != nil {
-					log.Printf("Warning: failed to get build logs: %s.", err)
+					logger.Warnf("failed to get build logs: %s.", err)
 					return
 				}
 				if len(logs.Entries) == 0 {
@@ -121,7 +115,7 @@ func (c *RemoteImportBuildCmd) Execute(args []string) error {
 	}()
 	taskID := importTask.TaskID
 	started := false
-	log.Printf("# Import queued. Waiting for task #%d in build #%d to start...", importTask.TaskID, build.BID)
+	logger.Infof("# Import queued. Waiting for task #%d in build #%d to start...", importTask.TaskID, build.BID)
 	for i, start := 0, time.Now(); ; i++ {
 		if time.Since(start) > 45*time.Minute {
 			return fmt.Errorf("import timed out after %s", time.Since(start))
@@ -143,15 +137,15 @@ func (c *RemoteImportBuildCmd) Execute(args []string) error {
 		}
 
 		if !started && importTask.StartedAt.Valid {
-			log.Printf("# Import started.")
+			logger.Infof("# Import started.")
 			started = true
 		}
 
 		if importTask.EndedAt.Valid {
 			if importTask.Success {
-				log.Printf("# Import succeeded!")
+				logger.Infof("# Import succeeded!")
 			} else if importTask.Failure {
-				log.Printf("# Import failed!")
+				logger.Infof("# Import failed!")
 				return fmt.Errorf("import failed")
 			}
 			break
@@ -160,8 +154,8 @@ func (c *RemoteImportBuildCmd) Execute(args []string) error {
 		time.Sleep(time.Duration(i) * 200 * time.Millisecond)
 	}
 
-	log.Printf("# View the repository at:")
-	log.Printf("# %s://%s/%s@%s", cl.BaseURL.Scheme, cl.BaseURL.Host, repo.URI, repoRevSpec.Rev)
+	logger.Infof("# View the repository at:")
+	logger.Infof("# %s://%s/%s@%s", cl.BaseURL.Scheme, cl.BaseURL.Host, repo.URI, repoRevSpec.Rev)
 
 	return nil
 }
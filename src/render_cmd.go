@@ -0,0 +1,317 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	renderGroup, err := CLI.AddCommand("render",
+		"render source code as HTML",
+		"The render command group contains subcommands that render source code as HTML, annotated with the graph data in the store.",
+		&renderCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := renderGroup.AddCommand("file",
+		"render a file as annotated HTML",
+		`The file command renders a source file as HTML in which each ref links to its def and each def has an anchor, so that static code-browsing sites can be generated from the store (by rendering each file).
+
+Links assume that each file FILE is rendered to FILE.html in the same dir tree, with defs' anchors at #DEFPATH. Refs to defs in other repos link to the URL generated by --external-template (see 'src url def').
+
+Annotations in the commit's local build data (.srclib-cache), if any, are also rendered: link annotations are links, diagnostics are underlined (with their messages as tooltips), and other annotations are spans with the CSS class "ann-TYPE", so that stylesheets can highlight them (e.g., syntax classes emitted by a toolchain).
+
+The source file is read from the current repository, which must be checked out at the rendered commit.`,
+		&renderFileCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+	setDefaultCommitIDOpt(c)
+}
+
+type RenderCmd struct{}
+
+var renderCmd RenderCmd
+
+func (c *RenderCmd) Execute(args []string) error { return nil }
+
+type RenderFileCmd struct {
+	Repo     string `long:"repo" description:"repository URI"`
+	CommitID string `long:"commit" description:"commit ID"`
+	File     string `long:"file" description:"file to render (relative to the repository root)" required:"yes"`
+
+	Output   string `short:"o" long:"output" description:"write the HTML to this file (default: stdout)"`
+	Fragment bool   `long:"fragment" description:"only output the <pre> element (not a complete HTML page)"`
+
+	ExternalTemplate string `long:"external-template" description:"URL template for links to defs in other repos (see 'src url def')" default:"sourcegraph"`
+	Templates        string `long:"templates" description:"JSON file mapping template names to URL templates (see 'src url def')" value-name:"FILE"`
+}
+
+var renderFileCmd RenderFileCmd
+
+func (c *RenderFileCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	externalTmpl, err := parseURLTemplate(c.ExternalTemplate, c.Templates)
+	if err != nil {
+		return err
+	}
+
+	file := strings.TrimPrefix(c.File, "./")
+	files := map[string][]byte{}
+	src := localRepoFile(c.Repo, c.CommitID, file, files)
+	if src == nil {
+		return fmt.Errorf("can't read %s: the current repository must be %s, checked out at commit %s", file, c.Repo, c.CommitID)
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing refs and defs", s)
+	}
+	_, isMultiRepo := s.(store.MultiRepoStore)
+	repo := ""
+	if isMultiRepo {
+		repo = c.Repo
+	}
+
+	refs, err := us.Refs(versionFilter(repo, c.CommitID), store.ByFiles(file))
+	if err != nil {
+		return err
+	}
+	targets, err := refTargetDefs(us, repo, c.Repo, c.CommitID, refs)
+	if err != nil {
+		return err
+	}
+
+	var anns []*ann.Ann
+	if bdfs, label, err := getLocalBuildDataFS(c.CommitID); err != nil {
+		return err
+	} else if bdfs != nil {
+		logger.Debugf("# Reading annotations from %s", label)
+		anns, err = readBuildDataAnns(bdfs, func(a *ann.Ann) bool { return a.File == file })
+		if err != nil {
+			logger.Warnf("Reading annotations from %s: %s (rendering without them)", label, err)
+		}
+	}
+
+	var spans []htmlSpan
+	for _, ref := range refs {
+		spans = append(spans, refSpan(ref, c.Repo, file, targets, externalTmpl, files))
+	}
+	for _, a := range anns {
+		spans = append(spans, annSpan(a))
+	}
+
+	var buf bytes.Buffer
+	if !c.Fragment {
+		fmt.Fprintf(&buf, renderFileHeader, html.EscapeString(file))
+	}
+	buf.WriteString(`<pre class="src">`)
+	buf.Write(renderAnnotatedHTML(src, spans))
+	buf.WriteString("</pre>\n")
+	if !c.Fragment {
+		buf.WriteString("</body>\n</html>\n")
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Output != "" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+const renderFileHeader = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>%s</title>
+<style>
+a.ref { color: inherit; text-decoration: none; }
+a.ref:hover { text-decoration: underline; }
+.def { font-weight: bold; }
+.ann-diagnostic { text-decoration: underline wavy; }
+.ann-error { text-decoration-color: red; }
+.ann-warning { text-decoration-color: orange; }
+:target { background: #ff8; }
+</style>
+</head>
+<body>
+`
+
+// refTargetDefs returns the defs (in repo at commitID) that refs
+// refer to, keyed by their (commit-independent) keys with the repo
+// set to defRepo. Defs in other repos are not looked up.
+func refTargetDefs(us store.UnitStore, repo, defRepo, commitID string, refs []*graph.Ref) (map[graph.DefKey]*graph.Def, error) {
+	keys := map[graph.DefKey]struct{}{}
+	units := map[unit.ID2]struct{}{}
+	for _, ref := range refs {
+		if ref.Def || (ref.DefRepo != "" && ref.DefRepo != defRepo) {
+			continue
+		}
+		keys[graph.DefKey{Repo: defRepo, UnitType: ref.DefUnitType, Unit: ref.DefUnit, Path: ref.DefPath}] = struct{}{}
+		units[unit.ID2{Type: ref.DefUnitType, Name: ref.DefUnit}] = struct{}{}
+	}
+	targets := map[graph.DefKey]*graph.Def{}
+	if len(keys) == 0 {
+		return targets, nil
+	}
+
+	unitIDs := make([]unit.ID2, 0, len(units))
+	for u := range units {
+		unitIDs = append(unitIDs, u)
+	}
+	defs, err := us.Defs(versionFilter(repo, commitID), store.ByUnits(unitIDs...), store.DefFilterFunc(func(def *graph.Def) bool {
+		_, present := keys[graph.DefKey{Repo: defRepo, UnitType: def.UnitType, Unit: def.Unit, Path: def.Path}]
+		return present
+	}))
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range defs {
+		targets[graph.DefKey{Repo: defRepo, UnitType: def.UnitType, Unit: def.Unit, Path: def.Path}] = def
+	}
+	return targets, nil
+}
+
+// An htmlSpan is an HTML element that wraps the source code from
+// byte offset start to end.
+type htmlSpan struct {
+	start, end  int
+	open, close string
+}
+
+// refSpan returns the span for ref (in file, in repo): an anchor for
+// a def, or a link to the ref's def.
+func refSpan(ref *graph.Ref, repo, file string, targets map[graph.DefKey]*graph.Def, externalTmpl *template.Template, files map[string][]byte) htmlSpan {
+	sp := htmlSpan{start: int(ref.Start), end: int(ref.End), close: "</a>"}
+	title := html.EscapeString(ref.DefPath)
+	if ref.Def {
+		sp.open = fmt.Sprintf(`<a class="ref def" id="%s" href="#%s" title="%s">`, html.EscapeString(ref.DefPath), pathEscape(ref.DefPath), title)
+		return sp
+	}
+
+	var href string
+	if ref.DefRepo == "" || ref.DefRepo == repo {
+		if def := targets[graph.DefKey{Repo: repo, UnitType: ref.DefUnitType, Unit: ref.DefUnit, Path: ref.DefPath}]; def != nil {
+			href = "#" + pathEscape(def.Path)
+			if def.File != file {
+				href = strings.Repeat("../", strings.Count(file, "/")) + pathEscape(def.File) + ".html" + href
+			}
+		}
+	} else {
+		def := &graph.Def{DefKey: graph.DefKey{Repo: ref.DefRepo, UnitType: ref.DefUnitType, Unit: ref.DefUnit, Path: ref.DefPath}}
+		if u, err := defURL(externalTmpl, def, files); err == nil {
+			href = u
+		} else {
+			logger.Debugf("# No URL for def %s in %s: %s", ref.DefPath, ref.DefRepo, err)
+		}
+	}
+	if href == "" {
+		sp.open, sp.close = fmt.Sprintf(`<span class="ref" title="%s">`, title), "</span>"
+		return sp
+	}
+	sp.open = fmt.Sprintf(`<a class="ref" href="%s" title="%s">`, html.EscapeString(href), title)
+	return sp
+}
+
+// annSpan returns the span for the annotation a.
+func annSpan(a *ann.Ann) htmlSpan {
+	sp := htmlSpan{start: int(a.Start), end: int(a.End), close: "</span>"}
+	switch a.Type {
+	case ann.Link:
+		if u, err := a.LinkURL(); err == nil {
+			sp.open, sp.close = fmt.Sprintf(`<a class="ann-link" href="%s">`, html.EscapeString(u.String())), "</a>"
+			return sp
+		}
+	case ann.Diagnostic:
+		if d, err := a.Diagnostic(); err == nil {
+			sp.open = fmt.Sprintf(`<span class="ann-diagnostic ann-%s" title="%s">`, cssClass(d.Level), html.EscapeString(d.Message))
+			return sp
+		}
+	}
+	sp.open = fmt.Sprintf(`<span class="ann-%s">`, cssClass(a.Type))
+	return sp
+}
+
+// cssClass returns s with the characters that are not valid in a CSS
+// class name replaced by '-'.
+func cssClass(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+// renderAnnotatedHTML returns src, HTML-escaped, with the spans'
+// elements wrapped around their source code. Spans that extend past
+// an enclosing span are truncated at its end so that the elements
+// nest, and spans that are empty or out of bounds are skipped.
+func renderAnnotatedHTML(src []byte, spans []htmlSpan) []byte {
+	sort.Sort(htmlSpansByStart(spans))
+
+	var buf bytes.Buffer
+	var stack []htmlSpan
+	pos := 0
+	closeTo := func(ofs int) {
+		for len(stack) > 0 && stack[len(stack)-1].end <= ofs {
+			top := stack[len(stack)-1]
+			buf.WriteString(html.EscapeString(string(src[pos:top.end])))
+			buf.WriteString(top.close)
+			pos = top.end
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for _, sp := range spans {
+		if sp.start < 0 || sp.end > len(src) || sp.start >= sp.end {
+			continue
+		}
+		closeTo(sp.start)
+		if len(stack) > 0 && sp.end > stack[len(stack)-1].end {
+			sp.end = stack[len(stack)-1].end
+		}
+		buf.WriteString(html.EscapeString(string(src[pos:sp.start])))
+		buf.WriteString(sp.open)
+		pos = sp.start
+		stack = append(stack, sp)
+	}
+	closeTo(len(src))
+	buf.WriteString(html.EscapeString(string(src[pos:])))
+	return buf.Bytes()
+}
+
+// htmlSpansByStart sorts spans by start, with enclosing (longer)
+// spans first.
+type htmlSpansByStart []htmlSpan
+
+func (v htmlSpansByStart) Len() int      { return len(v) }
+func (v htmlSpansByStart) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v htmlSpansByStart) Less(i, j int) bool {
+	return v[i].start < v[j].start || (v[i].start == v[j].start && v[i].end > v[j].end)
+}
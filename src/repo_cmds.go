@@ -31,6 +31,9 @@ func (c *RepoCmd) Execute(args []string) error {
 		fmt.Printf("Root directory:\t%s\n", localRepo.RootDir)
 		fmt.Printf("VCS type:\t%s\n", localRepo.VCSType)
 		fmt.Printf("Commit ID:\t%s\n", localRepo.CommitID)
+		if localRepo.BaseCommitID != "" {
+			fmt.Printf("Base commit ID:\t%s (the commit ID is for uncommitted changes; see %s)\n", localRepo.BaseCommitID, workingTreeEnv)
+		}
 		fmt.Printf("Clone URL:\t%s\n", localRepo.CloneURL)
 
 		fmt.Println()
@@ -22,6 +22,11 @@ type Repo struct {
 	VCSType  string // VCS type (git or hg)
 	CommitID string // CommitID of current working directory
 	CloneURL string // CloneURL of repo.
+
+	// BaseCommitID is the commit that the working tree is based on,
+	// if CommitID is a synthetic working tree commit ID (see
+	// workingTreeEnv). Otherwise it is empty.
+	BaseCommitID string
 }
 
 // URI returns the Repo's URI. It returns the empty string if the
@@ -56,7 +61,18 @@ func OpenRepo(dir string) (*Repo, error) {
 		// Current commit ID
 		var err error
 		rc.CommitID, err = resolveWorkingTreeRevision(rc.VCSType, rc.RootDir)
-		return err
+		if err != nil || !workingTreeKeys() {
+			return err
+		}
+		// Use a synthetic commit ID for uncommitted changes.
+		key, err := workingTreeCommitID(rc.VCSType, rc.RootDir, rc.CommitID)
+		if err != nil {
+			return err
+		}
+		if key != "" {
+			rc.BaseCommitID, rc.CommitID = rc.CommitID, key
+		}
+		return nil
 	})
 	par.Do(func() error {
 		// Get repo URI from clone URL.
@@ -86,6 +102,43 @@ func resolveWorkingTreeRevision(vcsType string, dir string) (string, error) {
 	return strings.TrimSuffix(string(bytes.TrimSpace(out)), "+"), nil
 }
 
+// resolveCommitRange returns the IDs of the commits in rng (of the
+// form "A..B"), from oldest to newest. Unlike git's A..B, the range
+// includes A.
+func resolveCommitRange(vcsType string, dir string, rng string) ([]string, error) {
+	parts := strings.Split(rng, "..")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid commit range %q (expected A..B)", rng)
+	}
+	from, to := parts[0], parts[1]
+
+	var cmds []*exec.Cmd
+	switch vcsType {
+	case "git":
+		cmds = []*exec.Cmd{
+			exec.Command("git", "rev-parse", from),
+			exec.Command("git", "rev-list", "--reverse", to, "^"+from),
+		}
+	case "hg":
+		cmds = []*exec.Cmd{
+			exec.Command("hg", "--config", "trusted.users=root", "log", "-r", from+"::"+to, "--template", "{node}\\n"),
+		}
+	default:
+		return nil, fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+
+	var commitIDs []string
+	for _, cmd := range cmds {
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("exec %v failed: %s", cmd.Args, err)
+		}
+		commitIDs = append(commitIDs, strings.Fields(string(out))...)
+	}
+	return commitIDs, nil
+}
+
 func getRootDir(dir string) (rootDir string, vcsType string, err error) {
 	dir, err = filepath.Abs(dir)
 	if err != nil {
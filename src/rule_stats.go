@@ -0,0 +1,141 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sourcegraph.com/sourcegraph/makex"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
+)
+
+// ruleStatsEnv is the name of the environment variable that holds the
+// path of the file that the tools run by 'src make' append their
+// resource usage to (see RuleStatsOpt).
+const ruleStatsEnv = "SRCLIB_RULE_STATS_FILE"
+
+// RuleStatsOpt is embedded in the commands that run a Makefile rule's
+// tool, so that 'src make' can record the rule's resource usage in the
+// build info (see plan.Options.RecordRuleStats).
+type RuleStatsOpt struct {
+	StatsTarget string `long:"stats-target" description:"(internal) record resource usage for the Makefile rule with this target" value-name:"TARGET"`
+}
+
+// recordRuleStats appends the resource usage of the current process's
+// children (which have run the rule's tool) since start to the file
+// named by the SRCLIB_RULE_STATS_FILE env var, if StatsTarget is set.
+// Failures are only logged, because they shouldn't fail the build.
+//
+// In Docker mode, only the usage of the docker client is measured, not
+// the usage of the container.
+func (o *RuleStatsOpt) recordRuleStats(start time.Time) {
+	file := os.Getenv(ruleStatsEnv)
+	if o.StatsTarget == "" || file == "" {
+		return
+	}
+	s := &buildstore.RuleStats{Target: o.StatsTarget, Wall: time.Since(start)}
+	if usage, ok := childUsage(); ok {
+		s.UserCPU, s.SysCPU, s.MaxRSS = usage.UserCPU, usage.SysCPU, usage.MaxRSS
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		logger.Warnf("recording resource usage of %s: %s", o.StatsTarget, err)
+		return
+	}
+
+	// Rules run concurrently, but appends of a single short line are
+	// atomic.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logger.Warnf("recording resource usage of %s: %s", o.StatsTarget, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		logger.Warnf("recording resource usage of %s: %s", o.StatsTarget, err)
+	}
+}
+
+// rusage is the resource usage of a process's children.
+type rusage struct {
+	UserCPU, SysCPU time.Duration
+	MaxRSS          int64 // bytes
+}
+
+// newRuleStatsFile creates an empty file for the tools run by 'src
+// make' to record their resource usage in, and sets the
+// SRCLIB_RULE_STATS_FILE env var to its path (so that it's inherited
+// by the tools). The returned func removes the file and unsets the env
+// var.
+func newRuleStatsFile() (string, func(), error) {
+	f, err := ioutil.TempFile("", "srclib-rule-stats")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	if err := os.Setenv(ruleStatsEnv, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() {
+		os.Unsetenv(ruleStatsEnv)
+		os.Remove(f.Name())
+	}, nil
+}
+
+// readRuleStats reads the resource usage recorded in file (see
+// recordRuleStats) and annotates it with the operation and source unit
+// of the corresponding rules. Targets are made relative to
+// buildDataDir.
+func readRuleStats(file string, rules []makex.Rule, buildDataDir string) ([]*buildstore.RuleStats, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rulesByTarget := make(map[string]makex.Rule, len(rules))
+	for _, rule := range rules {
+		rulesByTarget[filepath.Clean(rule.Target())] = rule
+	}
+
+	var (
+		stats    []*buildstore.RuleStats
+		byTarget = map[string]*buildstore.RuleStats{}
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s *buildstore.RuleStats
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, err
+		}
+		target := filepath.Clean(s.Target)
+		switch rule := rulesByTarget[target].(type) {
+		case *grapher.GraphUnitRule:
+			s.Op, s.UnitType, s.Unit = "graph", rule.Unit.Type, rule.Unit.Name
+		case *dep.ResolveDepsRule:
+			s.Op, s.UnitType, s.Unit = "depresolve", rule.Unit.Type, rule.Unit.Name
+		}
+		if rel, err := filepath.Rel(buildDataDir, target); err == nil {
+			s.Target = filepath.ToSlash(rel)
+		}
+
+		// If a rule's tool ran more than once, keep its last run.
+		if prev, present := byTarget[s.Target]; present {
+			*prev = *s
+			continue
+		}
+		byTarget[s.Target] = s
+		stats = append(stats, s)
+	}
+	return stats, scanner.Err()
+}
@@ -0,0 +1,9 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package src
+
+// childUsage reports that the resource usage of child processes can't
+// be determined on this system.
+func childUsage() (rusage, bool) {
+	return rusage{}, false
+}
@@ -0,0 +1,27 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package src
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// childUsage returns the resource usage of the terminated children of
+// the current process.
+func childUsage() (rusage, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &ru); err != nil {
+		return rusage{}, false
+	}
+	maxRSS := int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		maxRSS *= 1024 // kilobytes everywhere except OS X
+	}
+	return rusage{
+		UserCPU: time.Duration(ru.Utime.Nano()),
+		SysCPU:  time.Duration(ru.Stime.Nano()),
+		MaxRSS:  maxRSS,
+	}, true
+}
@@ -0,0 +1,232 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+)
+
+type BuildDataSARIFCmd struct {
+	buildDataSingleRepoCommonOpts
+
+	Output string `short:"o" long:"output" description:"write the SARIF log to this file (default: stdout)"`
+}
+
+var buildDataSARIFCmd BuildDataSARIFCmd
+
+func (c *BuildDataSARIFCmd) Execute(args []string) error {
+	bdfs, label, err := c.getFileSystem()
+	if err != nil {
+		return err
+	}
+	logger.Debugf("# Reading diagnostics from %s", label)
+
+	anns, err := readDiagnosticAnns(bdfs)
+	if err != nil {
+		return err
+	}
+
+	// Line and column numbers can only be computed if the source
+	// files are those of the build data's commit.
+	var srcDir string
+	if lrepo, _ := openLocalRepo(); c.Local && lrepo != nil && lrepo.CommitID == c.CommitID {
+		srcDir = lrepo.RootDir
+	}
+	log, err := sarifLogForAnns(anns, srcDir)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Output != "" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	b, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// readDiagnosticAnns returns the Diagnostic annotations in the graph
+// output files in the build data.
+func readDiagnosticAnns(bdfs vfs.FileSystem) ([]*ann.Ann, error) {
+	return readBuildDataAnns(bdfs, func(a *ann.Ann) bool { return a.Type == ann.Diagnostic })
+}
+
+// readBuildDataAnns returns the annotations in the graph output files
+// in the build data for which keep returns true, sorted.
+func readBuildDataAnns(bdfs vfs.FileSystem, keep func(*ann.Ann) bool) ([]*ann.Ann, error) {
+	treeConfig, err := config.ReadCached(bdfs)
+	if err != nil {
+		return nil, err
+	}
+	mf, err := plan.CreateMakefile(".", nil, "", treeConfig, plan.Options{NoCache: true})
+	if err != nil {
+		return nil, err
+	}
+	var anns []*ann.Ann
+	for _, rule := range mf.Rules {
+		rule, ok := rule.(*grapher.GraphUnitRule)
+		if !ok {
+			continue
+		}
+		var data graph.Output
+		if err := readJSONFileFS(bdfs, rule.Target(), &data); err != nil {
+			if os.IsNotExist(err) {
+				logger.Warnf("no build data for unit %s %s.", rule.Unit.Type, rule.Unit.Name)
+				continue
+			}
+			return nil, err
+		}
+		for _, a := range data.Anns {
+			if !keep(a) {
+				continue
+			}
+			if a.UnitType == "" {
+				a.UnitType, a.Unit = rule.Unit.Type, rule.Unit.Name
+			}
+			anns = append(anns, a)
+		}
+	}
+	sort.Sort(ann.Anns(anns))
+	return anns, nil
+}
+
+// SARIF 2.1.0 log format (only the parts that are used).
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri,omitempty"`
+			Rules          []sarifRule `json:"rules,omitempty"`
+		} `json:"driver"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId,omitempty"`
+		Level     string          `json:"level,omitempty"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region sarifRegion `json:"region"`
+		} `json:"physicalLocation"`
+	}
+	sarifRegion struct {
+		ByteOffset  uint32 `json:"byteOffset"`
+		ByteLength  uint32 `json:"byteLength"`
+		StartLine   int    `json:"startLine,omitempty"`
+		StartColumn int    `json:"startColumn,omitempty"`
+		EndLine     int    `json:"endLine,omitempty"`
+		EndColumn   int    `json:"endColumn,omitempty"`
+	}
+)
+
+// sarifLogForAnns converts Diagnostic annotations to a SARIF log with
+// one run per source unit type (i.e., per toolchain). If srcDir is
+// non-empty, the annotations' files are read from it to add line and
+// column numbers to the results.
+func sarifLogForAnns(anns []*ann.Ann, srcDir string) (*sarifLog, error) {
+	log := &sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{},
+	}
+	runs := map[string]*sarifRun{}
+	rules := map[string]map[string]struct{}{}
+	var unitTypes []string
+	fileCache := map[string][]byte{}
+	for _, a := range anns {
+		d, err := a.Diagnostic()
+		if err != nil {
+			return nil, err
+		}
+
+		run := runs[a.UnitType]
+		if run == nil {
+			run = &sarifRun{Results: []sarifResult{}}
+			run.Tool.Driver.Name = "srclib " + a.UnitType
+			run.Tool.Driver.InformationURI = "https://srclib.org"
+			runs[a.UnitType] = run
+			rules[a.UnitType] = map[string]struct{}{}
+			unitTypes = append(unitTypes, a.UnitType)
+		}
+		if _, seen := rules[a.UnitType][d.Rule]; !seen && d.Rule != "" {
+			rules[a.UnitType][d.Rule] = struct{}{}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Rule})
+		}
+
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(a.File)
+		loc.PhysicalLocation.Region = sarifRegion{ByteOffset: a.Start, ByteLength: a.End - a.Start}
+		if srcDir != "" {
+			src, present := fileCache[a.File]
+			if !present {
+				src, _ = ioutil.ReadFile(filepath.Join(srcDir, a.File))
+				fileCache[a.File] = src
+			}
+			if int(a.End) <= len(src) {
+				r := &loc.PhysicalLocation.Region
+				r.StartLine, r.StartColumn = lineCol(src, int(a.Start))
+				r.EndLine, r.EndColumn = lineCol(src, int(a.End))
+			}
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    d.Rule,
+			Level:     d.Level,
+			Message:   sarifMessage{Text: d.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+	sort.Strings(unitTypes)
+	for _, unitType := range unitTypes {
+		log.Runs = append(log.Runs, *runs[unitType])
+	}
+	return log, nil
+}
+
+// lineCol returns the 1-based line and column (in bytes) of the byte
+// offset ofs in src.
+func lineCol(src []byte, ofs int) (line, col int) {
+	before := src[:ofs]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = ofs - (bytes.LastIndex(before, []byte("\n")) + 1) + 1
+	return line, col
+}
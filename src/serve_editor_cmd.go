@@ -0,0 +1,180 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	_, err := CLI.AddCommand("serve-editor",
+		"serve editor requests over stdio (JSON-RPC)",
+		`The serve-editor command is a persistent backend for editor plugins. It reads JSON-RPC 1.0 requests from stdin and writes responses to stdout until stdin is closed.
+
+It keeps the store open (and the indexes it has read in memory) between requests, so that editor actions don't pay the process startup and store open cost each time. Because of this, it does not see data imported into the store after it has started; restart it after rebuilding.
+
+Methods:
+
+  Editor.DefAtPosition {"File": F, "Byte": B}  -> the same result as 'src store hover'
+  Editor.Refs {"DefRepo": R, "DefUnitType": T, "DefUnit": U, "DefPath": P}  -> refs to the def
+  Editor.Symbols {"Query": Q, "Limit": N}  -> defs matching the query
+
+All methods also accept optional "Repo" and "CommitID" fields to restrict the results to a repo and commit.`,
+		&serveEditorCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type ServeEditorCmd struct {
+	Type string `short:"t" long:"type" description:"the (multi-)repo store type to use (RepoStore, MultiRepoStore, etc.)" default:"RepoStore"`
+	Root string `short:"r" long:"root" description:"the root of the store (repo clone dir for RepoStore, global path for MultiRepoStore, etc.)" default:".srclib-store"`
+}
+
+var serveEditorCmd ServeEditorCmd
+
+func (c *ServeEditorCmd) Execute(args []string) error {
+	store.CacheOpenStores = true
+
+	sc := StoreCmd{Type: c.Type, Root: c.Root}
+	s, err := sc.store()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing refs and defs", s)
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Editor", &EditorService{s: us}); err != nil {
+		return err
+	}
+	logger.Debugf("Serving editor requests on stdio (store %s at %s).", c.Type, c.Root)
+	srv.ServeCodec(jsonrpc.NewServerCodec(stdioConn{os.Stdin, os.Stdout}))
+	return nil
+}
+
+// stdioConn is an io.ReadWriteCloser that reads from stdin and writes
+// to stdout.
+type stdioConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (stdioConn) Close() error { return nil }
+
+// EditorService is the JSON-RPC service exposed by the serve-editor
+// command.
+type EditorService struct {
+	s store.UnitStore
+
+	// ctx, if non-nil, is the context of the request being served
+	// (see editorHTTPHandler). Queries stop when it is done.
+	ctx context.Context
+}
+
+// store returns the store to query.
+func (e *EditorService) store() store.UnitStore {
+	if e.ctx == nil {
+		return e.s
+	}
+	return store.ContextUnitStore(e.ctx, e.s)
+}
+
+// EditorScope restricts the results of an EditorService method to a
+// repo and commit. Empty fields match all values.
+type EditorScope struct {
+	Repo     string
+	CommitID string
+}
+
+// DefAtPositionArgs are the arguments to EditorService.DefAtPosition.
+type DefAtPositionArgs struct {
+	EditorScope
+	File string
+	Byte uint32
+}
+
+// DefAtPosition returns information about the ref (and the def it
+// refers to) at the position. If there is no ref at the position,
+// the result's Ref is nil.
+func (e *EditorService) DefAtPosition(args *DefAtPositionArgs, reply *HoverInfo) error {
+	if args.File == "" {
+		return fmt.Errorf("File: empty")
+	}
+	c := StoreHoverCmd{Repo: args.Repo, CommitID: args.CommitID, File: args.File, Byte: args.Byte}
+	info, err := c.get(e.store())
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		*reply = *info
+	}
+	return nil
+}
+
+// RefsArgs are the arguments to EditorService.Refs.
+type RefsArgs struct {
+	EditorScope
+	graph.RefDefKey
+}
+
+// Refs returns the refs to a def.
+func (e *EditorService) Refs(args *RefsArgs, reply *[]*graph.Ref) error {
+	if args.DefPath == "" {
+		return fmt.Errorf("DefPath: empty")
+	}
+	fs := []store.RefFilter{store.ByRefDef(args.RefDefKey)}
+	if args.Repo != "" {
+		fs = append(fs, store.ByRepos(args.Repo))
+	}
+	if args.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(args.CommitID))
+	}
+	refs, err := e.store().Refs(fs...)
+	if err != nil {
+		return err
+	}
+	*reply = refs
+	return nil
+}
+
+// SymbolsArgs are the arguments to EditorService.Symbols.
+type SymbolsArgs struct {
+	EditorScope
+	Query string
+	Limit int // max results (0 for all)
+}
+
+// Symbols returns the defs that match the query (see store.ByDefQuery).
+func (e *EditorService) Symbols(args *SymbolsArgs, reply *[]*graph.Def) error {
+	if args.Query == "" {
+		return fmt.Errorf("Query: empty")
+	}
+	fs := []store.DefFilter{store.ByDefQuery(args.Query)}
+	if args.Repo != "" {
+		fs = append(fs, store.ByRepos(args.Repo))
+	}
+	if args.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(args.CommitID))
+	}
+	if args.Limit != 0 {
+		fs = append(fs, store.Limit(args.Limit, 0))
+	}
+	defs, err := e.store().Defs(fs...)
+	if err != nil {
+		return err
+	}
+	*reply = defs
+	return nil
+}
@@ -0,0 +1,179 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreBlameDefsCmd struct {
+	Repo     string `long:"repo" description:"repo (for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"commit ID of the defs (and of the blame)"`
+	File     string `long:"file" description:"file whose defs to blame" required:"yes"`
+}
+
+var storeBlameDefsCmd StoreBlameDefsCmd
+
+// DefBlame attributes a def to the last commit that modified it.
+type DefBlame struct {
+	graph.DefKey
+	Name string
+	Kind string `json:",omitempty"`
+
+	// StartLine and EndLine are the (1-based, inclusive) lines of
+	// the def's range.
+	StartLine, EndLine int
+
+	// LastCommitID, Author, AuthorEmail, and AuthorTime describe the
+	// most recently authored commit that modified any line of the
+	// def.
+	LastCommitID string
+	Author       string
+	AuthorEmail  string
+	AuthorTime   time.Time
+}
+
+// blameLine is the commit that last modified a line (from git blame).
+type blameLine struct {
+	commitID string
+	start    int // byte offset of the line's start
+}
+
+// blameCommit describes a commit in git blame output.
+type blameCommit struct {
+	author, authorEmail string
+	authorTime          time.Time
+}
+
+func (c *StoreBlameDefsCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	lrepo, err := openLocalRepo()
+	if err != nil {
+		return err
+	}
+	if lrepo == nil || lrepo.RootDir == "" {
+		return fmt.Errorf("no local repository (run 'src store blame-defs' in a git repository)")
+	}
+	if lrepo.VCSType != "git" {
+		return fmt.Errorf("blame-defs only supports git repositories, not %s", lrepo.VCSType)
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs", s)
+	}
+	file := path.Clean(c.File)
+	repo := c.Repo
+	if _, isMultiRepo := s.(store.MultiRepoStore); !isMultiRepo {
+		repo = ""
+	}
+	defs, err := us.Defs(versionFilter(repo, c.CommitID), store.ByFiles(file))
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "blame", "--porcelain", c.CommitID, "--", file)
+	cmd.Dir = lrepo.RootDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git blame %s %s: %s (%s)", c.CommitID, file, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	lines, commits, err := parseBlamePorcelain(bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+
+	blames := make([]*DefBlame, 0, len(defs))
+	for _, def := range defs {
+		b := &DefBlame{DefKey: def.DefKey, Name: def.Name, Kind: def.Kind}
+		b.StartLine = lineAtOffset(lines, int(def.DefStart))
+		b.EndLine = lineAtOffset(lines, int(def.DefEnd))
+		for l := b.StartLine; l <= b.EndLine && l > 0; l++ {
+			commitID := lines[l-1].commitID
+			if ci := commits[commitID]; ci != nil && (b.LastCommitID == "" || ci.authorTime.After(b.AuthorTime)) {
+				b.LastCommitID = commitID
+				b.Author, b.AuthorEmail, b.AuthorTime = ci.author, ci.authorEmail, ci.authorTime
+			}
+		}
+		blames = append(blames, b)
+	}
+	PrintJSON(blames, "")
+	return nil
+}
+
+// parseBlamePorcelain parses the output of `git blame --porcelain`.
+// It returns the commit and byte offset of each line of the file
+// (the line numbered n is at index n-1) and information about each
+// commit.
+func parseBlamePorcelain(r io.Reader) ([]blameLine, map[string]*blameCommit, error) {
+	var lines []blameLine
+	commits := map[string]*blameCommit{}
+	var cur string // commit of the current line
+	var ofs int
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, "\t") {
+			// The line's content.
+			lines = append(lines, blameLine{commitID: cur, start: ofs})
+			ofs += len(line) - 1 + len("\n")
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields[0]) == 40 && len(fields) == 2 {
+			// A header: "SHA ORIGLINE FINALLINE [NUMLINES]".
+			cur = fields[0]
+			if commits[cur] == nil {
+				commits[cur] = &blameCommit{}
+			}
+			continue
+		}
+		if len(fields) != 2 || cur == "" {
+			continue
+		}
+		c := commits[cur]
+		switch fields[0] {
+		case "author":
+			c.author = fields[1]
+		case "author-mail":
+			c.authorEmail = strings.TrimSuffix(strings.TrimPrefix(fields[1], "<"), ">")
+		case "author-time":
+			t, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing git blame author-time %q: %s", fields[1], err)
+			}
+			c.authorTime = time.Unix(t, 0).UTC()
+		}
+	}
+	return lines, commits, nil
+}
+
+// lineAtOffset returns the 1-based number of the line that contains
+// the byte offset ofs (or 0 if there are no lines).
+func lineAtOffset(lines []blameLine, ofs int) int {
+	return sort.Search(len(lines), func(i int) bool { return lines[i].start > ofs })
+}
@@ -0,0 +1,395 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+type StoreBrowseCmd struct {
+	Repo     string `long:"repo" description:"start browsing at this repo's commits (MultiRepoStore only)"`
+	CommitID string `long:"commit" description:"start browsing at this commit's source units (requires --repo for MultiRepoStore)"`
+}
+
+var storeBrowseCmd StoreBrowseCmd
+
+func (c *StoreBrowseCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	b := &storeBrowser{s: s}
+
+	// Push the views above the starting view so that the user can
+	// navigate back up to them.
+	var views []func() (*browseView, error)
+	if _, ok := s.(store.MultiRepoStore); ok {
+		views = append(views, b.reposView)
+		if c.Repo != "" {
+			views = append(views, func() (*browseView, error) { return b.commitsView(c.Repo) })
+		}
+	} else {
+		views = append(views, func() (*browseView, error) { return b.commitsView("") })
+	}
+	if c.CommitID != "" {
+		views = append(views, func() (*browseView, error) { return b.unitsView(c.Repo, c.CommitID) })
+	}
+	for _, open := range views {
+		v, err := open()
+		if err != nil {
+			return err
+		}
+		b.stack = append(b.stack, v)
+	}
+
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+	return b.run()
+}
+
+// storeBrowser is an interactive terminal UI for browsing a store's
+// repos, commits, source units, files, and the defs and refs in each
+// file.
+type storeBrowser struct {
+	s     interface{}
+	stack []*browseView // the current view is last
+	err   error         // error from the last attempt to open a view
+}
+
+// A browseView is a list of items (or, if detail is set, a page of
+// text) at a level of the store hierarchy.
+type browseView struct {
+	title string
+	items []browseItem
+
+	search   string
+	filtered []int // indexes of items that match search
+	sel, top int   // selected and topmost visible index in filtered
+
+	detail bool // whether the view is a page of text (not selectable)
+}
+
+type browseItem struct {
+	label string
+
+	// open returns the view to show when the item is selected. It is
+	// nil for items that can't be opened.
+	open func() (*browseView, error)
+}
+
+func newBrowseView(title string, items []browseItem) *browseView {
+	v := &browseView{title: title, items: items}
+	v.applySearch()
+	return v
+}
+
+// applySearch updates v.filtered to the items whose labels contain
+// v.search (case-insensitively).
+func (v *browseView) applySearch() {
+	q := strings.ToLower(v.search)
+	v.filtered = v.filtered[:0]
+	for i, item := range v.items {
+		if q == "" || strings.Contains(strings.ToLower(item.label), q) {
+			v.filtered = append(v.filtered, i)
+		}
+	}
+	v.sel, v.top = 0, 0
+}
+
+func (v *browseView) move(delta int) {
+	v.sel += delta
+	if v.sel >= len(v.filtered) {
+		v.sel = len(v.filtered) - 1
+	}
+	if v.sel < 0 {
+		v.sel = 0
+	}
+}
+
+// versionLabel returns a description of the given commit (in repo,
+// if the store is a MultiRepoStore).
+func versionLabel(repo, commitID string) string {
+	if repo == "" {
+		return commitID
+	}
+	return repo + "@" + commitID
+}
+
+// versionFilter returns a filter for the given commit (in repo, if
+// the store is a MultiRepoStore).
+func versionFilter(repo, commitID string) interface {
+	store.DefFilter
+	store.RefFilter
+	store.UnitFilter
+} {
+	if repo == "" {
+		return store.ByCommitIDs(commitID)
+	}
+	return store.ByRepoCommitIDs(store.Version{Repo: repo, CommitID: commitID})
+}
+
+func (b *storeBrowser) reposView() (*browseView, error) {
+	repos, err := b.s.(store.MultiRepoStore).Repos()
+	if err != nil && !store.IsNotExist(err) {
+		return nil, err
+	}
+	items := make([]browseItem, len(repos))
+	for i, repo := range repos {
+		repo := repo
+		items[i] = browseItem{label: repo, open: func() (*browseView, error) { return b.commitsView(repo) }}
+	}
+	return newBrowseView("Repos", items), nil
+}
+
+func (b *storeBrowser) commitsView(repo string) (*browseView, error) {
+	var fs []store.VersionFilter
+	if repo != "" {
+		fs = append(fs, store.ByRepos(repo))
+	}
+	versions, err := b.s.(store.RepoStore).Versions(fs...)
+	if err != nil && !store.IsNotExist(err) {
+		return nil, err
+	}
+	items := make([]browseItem, len(versions))
+	for i, v := range versions {
+		commitID := v.CommitID
+		items[i] = browseItem{label: commitID, open: func() (*browseView, error) { return b.unitsView(repo, commitID) }}
+	}
+	return newBrowseView(strings.TrimSpace(repo+" commits"), items), nil
+}
+
+func (b *storeBrowser) unitsView(repo, commitID string) (*browseView, error) {
+	units, err := b.s.(store.TreeStore).Units(versionFilter(repo, commitID))
+	if err != nil && !store.IsNotExist(err) {
+		return nil, err
+	}
+	items := make([]browseItem, len(units))
+	for i, u := range units {
+		u := u
+		items[i] = browseItem{label: u.Type + " " + u.Name, open: func() (*browseView, error) { return b.filesView(repo, commitID, u), nil }}
+	}
+	return newBrowseView(versionLabel(repo, commitID)+" units", items), nil
+}
+
+func (b *storeBrowser) filesView(repo, commitID string, u *unit.SourceUnit) *browseView {
+	files := append([]string(nil), u.Files...)
+	sort.Strings(files)
+	items := make([]browseItem, len(files))
+	for i, file := range files {
+		file := file
+		items[i] = browseItem{label: file, open: func() (*browseView, error) { return b.fileView(repo, commitID, u, file) }}
+	}
+	return newBrowseView(fmt.Sprintf("%s %s %s files", versionLabel(repo, commitID), u.Type, u.Name), items)
+}
+
+// fileView lists the defs and refs in a file, in order of their
+// position in the file.
+func (b *storeBrowser) fileView(repo, commitID string, u *unit.SourceUnit, file string) (*browseView, error) {
+	us := b.s.(store.UnitStore)
+	unitFilter := store.ByUnits(unit.ID2{Type: u.Type, Name: u.Name})
+	fileFilter := store.ByFiles(path.Clean(file))
+	defs, err := us.Defs(versionFilter(repo, commitID), unitFilter, fileFilter)
+	if err != nil && !store.IsNotExist(err) {
+		return nil, err
+	}
+	refs, err := us.Refs(versionFilter(repo, commitID), unitFilter, fileFilter)
+	if err != nil && !store.IsNotExist(err) {
+		return nil, err
+	}
+
+	var pis []browsePosItem
+	for _, def := range defs {
+		def := def
+		pis = append(pis, browsePosItem{def.DefStart, browseItem{
+			label: fmt.Sprintf("def %s (%s) @%d-%d", def.Name, def.Kind, def.DefStart, def.DefEnd),
+			open:  func() (*browseView, error) { return detailView("def "+def.Path, def), nil },
+		}})
+	}
+	for _, ref := range refs {
+		ref := ref
+		target := ref.DefPath
+		if ref.DefUnit != u.Name || ref.DefUnitType != u.Type {
+			target = fmt.Sprintf("%s %s %s", ref.DefUnitType, ref.DefUnit, target)
+		}
+		if ref.DefRepo != "" && ref.DefRepo != ref.Repo {
+			target = ref.DefRepo + " " + target
+		}
+		pis = append(pis, browsePosItem{ref.Start, browseItem{
+			label: fmt.Sprintf("ref -> %s @%d-%d", target, ref.Start, ref.End),
+			open:  func() (*browseView, error) { return detailView("ref to "+ref.DefPath, ref), nil },
+		}})
+	}
+	sort.Stable(posItemsByStart(pis))
+
+	items := make([]browseItem, len(pis))
+	for i, pi := range pis {
+		items[i] = pi.browseItem
+	}
+	return newBrowseView(fmt.Sprintf("%s %s (%d defs, %d refs)", versionLabel(repo, commitID), file, len(defs), len(refs)), items), nil
+}
+
+// A browsePosItem is an item for a def or ref at a position in a
+// file.
+type browsePosItem struct {
+	start uint32
+	browseItem
+}
+
+type posItemsByStart []browsePosItem
+
+func (v posItemsByStart) Len() int           { return len(v) }
+func (v posItemsByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v posItemsByStart) Less(i, j int) bool { return v[i].start < v[j].start }
+
+// detailView shows the JSON representation of a def or ref.
+func detailView(title string, v interface{}) *browseView {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b = []byte(err.Error())
+	}
+	lines := strings.Split(string(b), "\n")
+	items := make([]browseItem, len(lines))
+	for i, line := range lines {
+		items[i] = browseItem{label: line}
+	}
+	bv := newBrowseView(title, items)
+	bv.detail = true
+	return bv
+}
+
+func (b *storeBrowser) cur() *browseView { return b.stack[len(b.stack)-1] }
+
+// run draws the UI and handles key events until the user quits.
+func (b *storeBrowser) run() error {
+	for {
+		b.draw()
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventError:
+			return ev.Err
+		case termbox.EventKey:
+			if quit := b.handleKey(ev); quit {
+				return nil
+			}
+		}
+	}
+}
+
+func (b *storeBrowser) handleKey(ev termbox.Event) (quit bool) {
+	v := b.cur()
+	_, h := termbox.Size()
+	page := h - 2
+	b.err = nil
+	switch ev.Key {
+	case termbox.KeyCtrlC, termbox.KeyCtrlQ:
+		return true
+	case termbox.KeyArrowUp, termbox.KeyCtrlP:
+		v.move(-1)
+	case termbox.KeyArrowDown, termbox.KeyCtrlN:
+		v.move(1)
+	case termbox.KeyPgup:
+		v.move(-page)
+	case termbox.KeyPgdn:
+		v.move(page)
+	case termbox.KeyHome:
+		v.move(-len(v.filtered))
+	case termbox.KeyEnd:
+		v.move(len(v.filtered))
+	case termbox.KeyEnter, termbox.KeyArrowRight:
+		if v.detail || len(v.filtered) == 0 {
+			break
+		}
+		item := v.items[v.filtered[v.sel]]
+		if item.open == nil {
+			break
+		}
+		nv, err := item.open()
+		if err != nil {
+			b.err = err
+			break
+		}
+		b.stack = append(b.stack, nv)
+	case termbox.KeyEsc:
+		if v.search != "" {
+			v.search = ""
+			v.applySearch()
+			break
+		}
+		fallthrough
+	case termbox.KeyArrowLeft:
+		if len(b.stack) > 1 {
+			b.stack = b.stack[:len(b.stack)-1]
+		}
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if v.search != "" {
+			v.search = v.search[:len(v.search)-1]
+			v.applySearch()
+		} else if len(b.stack) > 1 {
+			b.stack = b.stack[:len(b.stack)-1]
+		}
+	case termbox.KeySpace:
+		v.search += " "
+		v.applySearch()
+	default:
+		if ev.Ch != 0 {
+			v.search += string(ev.Ch)
+			v.applySearch()
+		}
+	}
+	return false
+}
+
+func (b *storeBrowser) draw() {
+	const coldef = termbox.ColorDefault
+	termbox.Clear(coldef, coldef)
+	w, h := termbox.Size()
+	v := b.cur()
+
+	drawLine(0, fmt.Sprintf(" %s (%d/%d)", v.title, len(v.filtered), len(v.items)), w, coldef|termbox.AttrBold|termbox.AttrReverse, coldef)
+
+	rows := h - 2
+	if v.sel < v.top {
+		v.top = v.sel
+	} else if v.sel >= v.top+rows {
+		v.top = v.sel - rows + 1
+	}
+	for i := 0; i < rows && v.top+i < len(v.filtered); i++ {
+		item := v.items[v.filtered[v.top+i]]
+		fg := coldef
+		if v.top+i == v.sel && !v.detail {
+			fg |= termbox.AttrReverse
+		}
+		drawLine(i+1, " "+item.label, w, fg, coldef)
+	}
+
+	status := "↑↓ move  ⏎ open  ← back  type to search  ^C quit"
+	if v.search != "" {
+		status = "search: " + v.search + "  (esc to clear)"
+	}
+	if b.err != nil {
+		status = "error: " + b.err.Error()
+	}
+	drawLine(h-1, status, w, coldef|termbox.AttrBold, coldef)
+	termbox.Flush()
+}
+
+// drawLine draws s on row y, truncated to width w.
+func drawLine(y int, s string, w int, fg, bg termbox.Attribute) {
+	x := 0
+	for _, r := range s {
+		if x >= w {
+			break
+		}
+		termbox.SetCell(x, y, r, fg, bg)
+		x++
+	}
+}
@@ -0,0 +1,213 @@
+package src
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// publishChange records a change to the data of opt.Repo at
+// opt.CommitID in stor's change log, so that the clients of 'src store
+// serve' are notified of it. Stores without a change log are skipped,
+// and failures are logged but not returned (as with hooks), because
+// the data was imported successfully.
+func publishChange(stor interface{}, typ string, opt ImportOpt, units []unit.ID2) {
+	cp, ok := stor.(store.ChangePublisher)
+	if !ok {
+		return
+	}
+	if err := cp.PublishChange(&store.Change{Type: typ, Repo: opt.Repo, CommitID: opt.CommitID, Units: units}); err != nil {
+		logger.Warnf("Publishing %s change: %s", typ, err)
+	}
+}
+
+// A changeBus polls a store's change log (which other processes, such
+// as 'src store import' and 'src store importd', publish changes to)
+// and wakes up the clients that are waiting for changes.
+type changeBus struct {
+	log      store.ChangeLog
+	interval time.Duration
+
+	mu      sync.Mutex
+	last    string        // ID of the most recent change seen
+	updated chan struct{} // closed (and replaced) when changes are seen
+}
+
+// changePollInterval is how often the change log is polled.
+const changePollInterval = time.Second
+
+func newChangeBus(log store.ChangeLog, interval time.Duration) (*changeBus, error) {
+	changes, err := log.Changes("")
+	if err != nil {
+		return nil, err
+	}
+	b := &changeBus{log: log, interval: interval, updated: make(chan struct{})}
+	if len(changes) > 0 {
+		b.last = changes[len(changes)-1].ID
+	}
+	go b.poll()
+	return b, nil
+}
+
+func (b *changeBus) poll() {
+	for range time.Tick(b.interval) {
+		b.mu.Lock()
+		last := b.last
+		b.mu.Unlock()
+
+		changes, err := b.log.Changes(last)
+		if err != nil {
+			logger.Warnf("Polling store change log: %s", err)
+			continue
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		b.mu.Lock()
+		b.last = changes[len(changes)-1].ID
+		close(b.updated)
+		b.updated = make(chan struct{})
+		b.mu.Unlock()
+	}
+}
+
+// wait returns the changes (to repo, or to all repos if repo is
+// empty) after the change with ID after, waiting until there are some
+// or until done is closed. It also returns the ID to pass as after to
+// get the changes after those that it returned.
+func (b *changeBus) wait(after, repo string, done <-chan struct{}) ([]*store.Change, string, error) {
+	for {
+		b.mu.Lock()
+		updated, last := b.updated, b.last
+		b.mu.Unlock()
+
+		if after < last {
+			changes, err := b.log.Changes(after)
+			if err != nil {
+				return nil, after, err
+			}
+			var matching []*store.Change
+			for _, c := range changes {
+				if repo == "" || c.Repo == repo {
+					matching = append(matching, c)
+				}
+				after = c.ID
+			}
+			if len(matching) > 0 {
+				return matching, after, nil
+			}
+		}
+
+		select {
+		case <-updated:
+		case <-done:
+			return nil, after, nil
+		}
+	}
+}
+
+// maxChangesWait is the max duration that a /changes long-poll
+// request waits for changes.
+const maxChangesWait = time.Minute
+
+// changesHandler serves the store's changes (see store.Change), so
+// that UIs and caches can react to new commits appearing in the
+// store.
+//
+// GET /changes?after=ID&repo=R&wait=30s returns a JSON object whose
+// Changes are the changes (to repo R, if given) after the change with
+// ID (or all retained changes if after is omitted), and whose Next is
+// the ID to pass as after in the next request. If wait is given and
+// there are no changes, it waits up to that long (max 1m) for some
+// (long polling).
+//
+// GET /changes/ws?after=ID&repo=R opens a WebSocket on which each
+// change is sent (as a JSON message) as it is published.
+type changesHandler struct {
+	b *changeBus
+}
+
+func (h *changesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method must be GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/changes/ws" {
+		websocket.Server{Handler: h.serveWebSocket}.ServeHTTP(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	var wait time.Duration
+	if s := q.Get("wait"); s != "" {
+		var err error
+		wait, err = time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "wait: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if wait > maxChangesWait {
+			wait = maxChangesWait
+		}
+	}
+
+	done := make(chan struct{})
+	t := time.AfterFunc(wait, func() { close(done) })
+	defer t.Stop()
+	changes, next, err := h.b.wait(q.Get("after"), q.Get("repo"), done)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if changes == nil {
+		changes = []*store.Change{}
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(struct {
+		Changes []*store.Change
+		Next    string
+	}{changes, next}); err != nil {
+		logger.Errorf("Writing %s response: %s", r.URL.Path, err)
+	}
+}
+
+func (h *changesHandler) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	q := ws.Request().URL.Query()
+	after, repo := q.Get("after"), q.Get("repo")
+
+	// Clients don't send messages, so reading only detects when the
+	// connection is closed.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, ws)
+		close(closed)
+	}()
+
+	for {
+		changes, next, err := h.b.wait(after, repo, closed)
+		if err != nil {
+			logger.Warnf("Listing changes for WebSocket client %s: %s", ws.Request().RemoteAddr, err)
+			return
+		}
+		if changes == nil {
+			return // closed
+		}
+		for _, c := range changes {
+			if err := websocket.JSON.Send(ws, c); err != nil {
+				return
+			}
+		}
+		after = next
+	}
+}
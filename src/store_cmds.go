@@ -2,11 +2,13 @@ package src
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -14,29 +16,41 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.google.com/p/rog-go/parallel"
 
+	"github.com/kr/s3"
+	"github.com/kr/s3/s3util"
+	"golang.org/x/net/context"
 	"golang.org/x/tools/godoc/vfs"
 
 	"sort"
 
 	"sourcegraph.com/sourcegraph/go-flags"
 	"sourcegraph.com/sourcegraph/go-sourcegraph/sourcegraph"
+	"sourcegraph.com/sourcegraph/makex"
 	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/s3vfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/plan"
 	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
 func init() {
 	storeC, err := CLI.AddCommand("store",
 		"graph store commands",
-		"",
+		`The store commands import and query data in a graph store.
+
+The default values of the store flags can be set with the SRC_STORE_ROOT, SRC_STORE_TYPE, and SRC_STORE_CONFIG environment variables. The default value of any flag of the store commands can be set with a SRC_FLAG_* environment variable named after the flag's long name (e.g., SRC_FLAG_ERROR_FORMAT=json sets --error-format=json). Flags given on the command line take precedence over environment variables, which take precedence over the Srcfile and .srclibrc.`,
 		&storeCmd,
 	)
 	if err != nil {
@@ -52,15 +66,36 @@ func init() {
 		if err == nil {
 			SetOptionDefaultValue(storeC.Group, "root", filepath.Join(relDir, store.SrclibStoreDir))
 		}
+
+		projectStore, err = config.ReadStore(lrepo.RootDir)
+		if err != nil {
+			log.Fatalf("Reading store config from %s or %s: %s", config.Filename, config.RCFilename, err)
+		}
+		if projectStore.Type != "" {
+			SetOptionDefaultValue(storeC.Group, "type", projectStore.Type)
+		}
+		if root := projectStore.Root; root != "" {
+			if !strings.HasPrefix(root, "s3://") && !filepath.IsAbs(root) && relDir != "" {
+				root = filepath.Join(relDir, root)
+			}
+			SetOptionDefaultValue(storeC.Group, "root", root)
+		}
 	}
 
 	InitStoreCmds(storeC)
+
+	// Environment variables override the project's store config but
+	// not the command line.
+	setStoreFlagDefaultsFromEnv(storeC)
+	setFlagDefaultsFromEnv(storeC)
 }
 
 func InitStoreCmds(c *flags.Command) {
 	importC, err := c.AddCommand("import",
 		"import data",
-		`The import command imports data (from .srclib-cache) into the store.`,
+		`The import command imports data (from .srclib-cache) into the store.
+
+Concurrent imports of the same repo and commit are serialized by an advisory lock (see the Lock field of the store --config); an import waits for the lock unless --no-wait is given.`,
 		&storeImportCmd,
 	)
 	if err != nil {
@@ -71,7 +106,9 @@ func InitStoreCmds(c *flags.Command) {
 
 	_, err = c.AddCommand("indexes",
 		"list indexes",
-		"The indexes command lists all of a store's indexes that match the specified criteria.",
+		`The indexes command lists all of a store's indexes that match the specified criteria.
+
+Transient errors reading from the store (such as S3 timeouts and "503 Slow Down" responses) are retried with backoff (see the Fetch settings in the store --config). Indexes that still can't be read are reported with an ERROR, and the command exits with an error after listing the rest (--keep-going, the default) or immediately (--fail-fast). The same applies to the index and warm commands.`,
 		&storeIndexesCmd,
 	)
 	if err != nil {
@@ -80,13 +117,42 @@ func InitStoreCmds(c *flags.Command) {
 
 	_, err = c.AddCommand("index",
 		"build indexes",
-		"The index command builds indexes that match the specified index criteria. Built indexes are printed to stdout.",
+		`The index command builds indexes that match the specified index criteria. Built indexes are printed to stdout.
+
+By default, indexes are built in the order in which they are listed. Use --order to build the smallest indexes first (smallest-first) or to build the indexes used by interactive queries, such as def lookups by path, name, or query, before the indexes used for navigation and for analysis, such as call graphs and ref counts (by-priority). With either order, all indexes are listed before the first is built, and indexes that are built from other indexes are still built after them.`,
 		&storeIndexCmd,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	_, err = c.AddCommand("warm",
+		"pre-read indexes",
+		"The warm command reads all built indexes that match the specified index criteria (typically --repo and --commit), so that they are in the OS's page cache and the first query after a rebuild isn't slow. With --daemon, it keeps running and periodically re-reads the indexes so that they stay cached. (To also keep indexes in memory between queries, use an editor backend such as 'src serve-editor'.)",
+		&storeWarmCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("migrate",
+		"upgrade store format",
+		"The migrate command upgrades the on-disk data and index formats of a store (in place) to the current format version.",
+		&storeMigrateCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("convert-codec",
+		"re-encode store data with a different codec",
+		"The convert-codec command re-encodes all data files of a store (in place) with a different codec, rebuilds the indexes of each commit, and records the new codec in the store's format file. The codec of a new store can be chosen with the store --config flag (for example, --config '{\"Codec\":\"msgpack\"}'); this command changes the codec of an existing store. Converting to a codec other than protobuf upgrades the store's format version (older versions of srclib would ignore the recorded codec and fail to decode the data). The store must not be used by other processes during the conversion.",
+		&storeConvertCodecCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = c.AddCommand("repos",
 		"list repos",
 		"The repos command lists all repos that match a filter.",
@@ -98,7 +164,7 @@ func InitStoreCmds(c *flags.Command) {
 
 	_, err = c.AddCommand("versions",
 		"list versions",
-		"The versions command lists all versions that match a filter.",
+		"The versions command lists all versions that match a filter. With --verbose, it prints the versions as JSON, including the provenance of each version's data (see 'src store provenance').",
 		&storeVersionsCmd,
 	)
 	if err != nil {
@@ -124,6 +190,15 @@ func InitStoreCmds(c *flags.Command) {
 	}
 	defsC.Aliases = []string{"def"}
 
+	_, err = c.AddCommand("docs",
+		"list docs",
+		"The docs command lists the stored documentation of defs that match a filter. Use --def-path to retrieve the docs of a single def.",
+		&storeDocsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = c.AddCommand("refs",
 		"list refs",
 		"The refs command lists all refs that match a filter.",
@@ -132,738 +207,3119 @@ func InitStoreCmds(c *flags.Command) {
 	if err != nil {
 		log.Fatal(err)
 	}
-}
 
-// OpenStore is called by all of the store subcommands to open the
-// store.
-var OpenStore func() (interface{}, error) = storeCmd.store
+	_, err = c.AddCommand("hover",
+		"show info about the def at a position",
+		"The hover command returns, in a single call, everything an editor needs to show a tooltip for the position at --file and --byte: the ref at that position, the def it refers to (with its kind and definition location), its signature (if the def's toolchain can format defs), and its docs.",
+		&storeHoverCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-type StoreCmd struct {
-	Type   string `short:"t" long:"type" description:"the (multi-)repo store type to use (RepoStore, MultiRepoStore, etc.)" default:"RepoStore"`
-	Root   string `short:"r" long:"root" description:"the root of the store (repo clone dir for RepoStore, global path for MultiRepoStore, etc.)" default:".srclib-store"`
-	Config string `long:"config" description:"(rarely used) JSON-encoded config for extra config, specific to each store type"`
-}
+	_, err = c.AddCommand("calls",
+		"list call edges",
+		"The calls command lists call edges (caller def -> callee def) that match a filter. Use --def-path to list the calls made by a def (its callees) and --callers-of to list the calls to a def (its callers). Call edges are only available for source units whose toolchain emits them.",
+		&storeCallsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-var storeCmd StoreCmd
+	_, err = c.AddCommand("impls",
+		"list type-hierarchy relations",
+		"The impls command lists type-hierarchy relations (e.g., implements and extends) that match a filter. Use --def-path to list the implementations and subtypes of a def, or add --supertypes to list the interfaces a def implements and the types it extends. Relations are only available for source units whose toolchain emits them.",
+		&storeImplsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func (c *StoreCmd) Execute(args []string) error { return nil }
+	_, err = c.AddCommand("history",
+		"show the history of a def across commits",
+		"The history command walks the imported commits of a repo (in the order they were imported) and reports when the def with the given --def-path appeared, changed (its file, byte range, kind, or toolchain-specific data such as its signature), or disappeared. It uses the def history index if it has been built (see `src store index`); otherwise it scans the defs of every commit, which is slow for repos with many commits.",
+		&storeHistoryCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-// store returns the store specified by StoreCmd's Type and Root
-// options.
-func (c *StoreCmd) store() (interface{}, error) {
-	fs := rwvfs.OS(c.Root)
+	_, err = c.AddCommand("detect-renames",
+		"detect and record def renames between commits",
+		"The detect-renames command matches the defs that were removed between the --from and --to commits to the defs that were added, based on the similarity of their names, signatures (and other toolchain-specific data), and docs. It prints the matched renames and records them in the store (unless --dry-run is given). Recorded renames are shown in the output of `src store history` (rebuild the def history index with `src store index` afterwards).",
+		&storeDetectRenamesCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("deps",
+		"list resolved deps",
+		"The deps command lists the resolved dependencies of source units that match a filter. With --dependents-of, it lists the deps (in all stored repos and commits) on the given repo, to find the dependents of a library.",
+		&storeDepsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	type createParents interface {
-		CreateParentDirs(bool)
+	_, err = c.AddCommand("browse",
+		"interactively browse the store",
+		"The browse command opens a terminal UI for navigating the store's repos, commits, source units, files, and the defs and refs in each file. Type to incrementally filter the current list; press Enter to open the selected item, Left or Backspace to go back, and Ctrl-C to quit.",
+		&storeBrowseCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if fs, ok := fs.(createParents); ok {
-		fs.CreateParentDirs(true)
+
+	_, err = c.AddCommand("top",
+		"monitor a store daemon's live operations",
+		"The top command attaches to a running 'src store serve' or 'src store importd --http' daemon and displays its live metrics (from the daemon's /metrics endpoint): in-flight queries, import throughput, cache hit rates, and the slowest recent queries. Press q, Esc, or Ctrl-C to quit and r to refresh. With --once, a single snapshot is printed as text.",
+		&storeTopCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	switch c.Type {
-	case "RepoStore":
-		return store.NewFSRepoStore(fs), nil
-	case "MultiRepoStore":
-		return store.NewFSMultiRepoStore(rwvfs.Walkable(fs), nil), nil
-	default:
-		return nil, fmt.Errorf("unrecognized store --type value: %q (valid values are RepoStore, MultiRepoStore)", c.Type)
+	_, err = c.AddCommand("complete",
+		"print completion values (used by shell completion scripts)",
+		"The complete command prints the repos, commit IDs, or source unit names in the store, one per line. It is used by the shell completion scripts printed by `src completion`.",
+		&storeCompleteCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
-}
 
-type StoreImportCmd struct {
-	ImportOpt
+	_, err = c.AddCommand("importd",
+		"import build data bundles from a spool directory",
+		`The importd command runs a daemon that watches a spool directory for build data bundles (written by 'src store enqueue') and imports them one at a time, retrying failed imports. Imports are serialized with other writers by the store lock (see 'src store import'). Imported bundles are moved to the .done (or, on failure, .failed) subdirectory of the spool directory, along with a status.json file describing the import.
 
-	Quiet bool `short:"q" long:"quiet" description:"silence all output"`
+With --http, the daemon serves its status (queued bundles, the current import, and recently finished imports) as JSON. It also serves dumps of commits (in the format written by 'src store dump') at /export?repo=R&commit=C, so that other systems can mirror the store's contents as they are imported.`,
+		&storeImportdCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	Sample           bool `long:"sample" description:"(sample data) import sample data, not .srclib-cache data"`
-	SampleDefs       int  `long:"sample-defs" description:"(sample data) number of sample defs to import" default:"100"`
-	SampleRefs       int  `long:"sample-refs" description:"(sample data) number of sample refs to import" default:"100"`
-	SampleImportOnly bool `long:"sample-import-only" description:"(sample data) only import, don't demonstrate listing data"`
+	_, err = c.AddCommand("serve",
+		"serve the store API over HTTP",
+		`The serve command serves the store over HTTP, so that editor plugins and other tools can share one store (and its in-memory indexes). It serves:
 
-	RemoteBuildData bool `long:"remote-build-data" description:"import remote build data (not the local .srclib-cache build data)"`
-}
+  POST /Editor.DefAtPosition, /Editor.Refs, /Editor.Symbols  -> the methods of 'src serve-editor' (JSON request and response bodies)
+  GET /export?repo=R&commit=C  -> a dump of a commit (see 'src store dump')
+  POST /graphql {"query": Q} or GET /graphql?query=Q  -> the result of a GraphQL query (see 'src store graphql')
+  GET /changes?after=ID&repo=R&wait=30s  -> {"Changes": [...], "Next": ID}, the imports and index builds (of repo R, if given) after change ID; if wait is given, waits up to that long (max 1m) for a change (long polling)
+  GET /changes/ws?after=ID&repo=R  -> a WebSocket that sends each change (as JSON) as it happens
 
-var storeImportCmd StoreImportCmd
+The --server-config file (JSON) limits what each client can do, so that one misbehaving client can't starve the others. For example:
 
-func (c *StoreImportCmd) Execute(args []string) error {
-	start := time.Now()
+  {"RateLimit": 20, "Burst": 40, "MaxRequestBytes": 1048576,
+   "Keys": {"KEY": {"Name": "vim-plugin", "RateLimit": 5, "DailyQuota": 10000}}}
 
-	s, err := OpenStore()
+RateLimit and Burst configure a token bucket that limits the requests per second of each client, and MaxRequestBytes limits the size of request bodies (default 1 MB). If Keys is set, each request must present one of the keys (in an "Authorization: token KEY" header or a key=KEY query parameter), and each key may override the rate limit and set a daily quota. Without keys, clients are identified by IP address. Requests that exceed a limit get a 429 response with a Retry-After header.
+
+With --grpc, the command also serves the gRPC Exporter service (defined in store/pb/export.proto) on another address. Its Export method streams the same records as /export, as protobuf messages. The --server-config limits apply to gRPC calls too (and are shared with HTTP requests); clients send their API key in the call's "authorization" metadata ("token KEY"), and calls that exceed a limit fail with the ResourceExhausted code.`,
+		&storeServeCmd,
+	)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
 
-	if c.Sample {
-		return c.sample(s)
-	}
+	_, err = c.AddCommand("graphql",
+		"query defs, refs, files, and units with GraphQL",
+		`The graphql command executes a GraphQL query (given as an argument, or read from stdin) against the store and prints the JSON response. GraphQL queries can navigate the graph in one request (e.g., from a def to its refs, to the files they're in, to those files' source units), which 'src store serve' also serves at /graphql. For example:
 
-	bdfs, label, err := getBuildDataFS(!c.RemoteBuildData, c.Repo, c.CommitID)
+  src store graphql '{ def(repo: "r", unitType: "GoPackage", unit: "p", path: "T") { name refs(allRepos: true) { repo file { path units { name } } } } }'
+
+Run 'src store graphql --schema' to print the schema. Variable values are given with --variables as a JSON object (e.g., --variables='{"repo": "r"}' for a query with a $repo variable). Fragments and directives are not supported. Queries may be nested at most 10 selection sets deep and may resolve at most 10000 fields.`,
+		&storeGraphQLCmd,
+	)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	if GlobalOpt.Verbose {
-		log.Printf("# Importing build data for %s (commit %s) from %s", c.Repo, c.CommitID, label)
+
+	enqueueC, err := c.AddCommand("enqueue",
+		"add build data to an import spool directory",
+		"The enqueue command copies the build data (from .srclib-cache) for a commit into a spool directory as a bundle, to be imported by 'src store importd'.",
+		&storeEnqueueCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
+	setDefaultRepoURIOpt(enqueueC)
+	setDefaultCommitIDOpt(enqueueC)
 
-	if err := Import(bdfs, s, c.ImportOpt); err != nil {
-		return err
+	_, err = c.AddCommand("push-es",
+		"push defs to an Elasticsearch index",
+		"The push-es command pushes the defs in the store (their names, kinds, files, docs, repos, and commits) to an Elasticsearch (or OpenSearch) index using the bulk API, for symbol search. Documents are keyed on the def's key, so pushing a def again replaces its document. If the index doesn't exist, it is created with the mapping given by --mapping (or a default mapping).",
+		&storePushESCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if !c.Quiet {
-		log.Printf("# Import completed in %s.", time.Since(start))
+
+	blameC, err := c.AddCommand("blame-defs",
+		"attribute a file's defs to the commits that last modified them",
+		"The blame-defs command combines the byte ranges of the defs in a file (from the store) with `git blame` output to attribute each def to the author and commit that most recently modified any of its lines. It prints the results as JSON. It must be run in the def's git repository.",
+		&storeBlameDefsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return nil
-}
+	setDefaultCommitIDOpt(blameC)
 
-type ImportOpt struct {
-	DryRun  bool `short:"n" long:"dry-run" description:"print what would be done but don't do anything"`
-	NoIndex bool `long:"no-index" description:"don't build indexes (indexes inside a single source unit are always built)"`
+	_, err = c.AddCommand("import-many",
+		"clone, build, and import many repos",
+		`The import-many command reads a list of repo clone URLs or local paths (one per line, from FILE or stdin), clones (or updates) each repo, builds it with `+"`src config`"+` and `+"`src make`"+`, and imports its build data into the store, which must be a MultiRepoStore. Repos are processed concurrently (see --parallel).
 
-	Repo     string `long:"repo" description:"only import for this repo"`
-	Unit     string `long:"unit" description:"only import source units with this name"`
-	UnitType string `long:"unit-type" description:"only import source units with this type"`
-	CommitID string `long:"commit" description:"commit ID of commit whose data to import"`
+Each repo's progress is recorded in a status file. When import-many is run again with the same status file, repos that were already imported at their current commit are skipped, and repos that were built but not imported are imported without being rebuilt.`,
+		&storeImportManyCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	Verbose bool
-}
+	dumpC, err := c.AddCommand("dump",
+		"stream all data for a commit",
+		`The dump command writes every source unit, def, doc, and ref of a commit to stdout as a stream of JSON records, one per line. Each record has a Type field (unit, def, doc, or ref) and a field of the same name (Unit, Def, Doc, or Ref) that holds the item. Records are grouped by source unit: each unit is followed by its defs (each followed by its docs) and then its refs. It is used to mirror store contents into other systems without issuing many filtered queries.
 
-// Import imports build data into a RepoStore or MultiRepoStore.
-func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
-	// Traverse the build data directory for this repo and commit to
-	// create the makefile that lists the targets (which are the data
-	// files we will import).
-	treeConfig, err := config.ReadCached(buildDataFS)
+The same stream is served over HTTP by 'src store importd --http' and 'src store serve' at /export?repo=R&commit=C, and over gRPC (as protobuf messages) by 'src store serve --grpc'.`,
+		&storeDumpCmd,
+	)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	mf, err := plan.CreateMakefile(".", nil, "", treeConfig, plan.Options{NoCache: true})
+	setDefaultRepoURIOpt(dumpC)
+	setDefaultCommitIDOpt(dumpC)
+
+	snapshotC, err := c.AddCommand("snapshot",
+		"manage named snapshots of commits",
+		`The snapshot commands manage snapshots, which are names (such as release-1.4) for commits in the store. A snapshot name can be given anywhere a commit ID is accepted (e.g., 'src store defs --commit release-1.4'), so that documentation sites and dashboards can query a release's graph without hard-coding its commit ID. Creating a snapshot with an existing name moves it to the new commit.`,
+		&storeSnapshotCmd,
+	)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
 
-	var (
-		mu               sync.Mutex
-		hasIndexableData bool
+	snapshotCreateC, err := snapshotC.AddCommand("create",
+		"create (or move) a snapshot",
+		"The create command names the commit given by --commit (which must have been imported).",
+		&storeSnapshotCreateCmd,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(snapshotCreateC)
+	setDefaultCommitIDOpt(snapshotCreateC)
 
-	par := parallel.NewRun(10)
-	for _, rule_ := range mf.Rules {
-		rule := rule_
+	_, err = snapshotC.AddCommand("list",
+		"list snapshots",
+		"The list command lists snapshots (of all repos in a MultiRepoStore, unless --repo is given) as JSON.",
+		&storeSnapshotListCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		if opt.Unit != "" || opt.UnitType != "" {
-			type ruleForSourceUnit interface {
-				SourceUnit() *unit.SourceUnit
-			}
-			if rule, ok := rule.(ruleForSourceUnit); ok {
-				u := rule.SourceUnit()
-				if (opt.Unit != "" && u.Name != opt.Unit) || (opt.UnitType != "" && u.Type != opt.UnitType) {
-					continue
-				}
-			} else {
-				// Skip all non-source-unit rules if --unit or
-				// --unit-type are specified.
-				continue
-			}
-		}
+	snapshotDeleteC, err := snapshotC.AddCommand("delete",
+		"delete a snapshot",
+		"The delete command deletes a snapshot. The commit's data is not deleted.",
+		&storeSnapshotDeleteCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(snapshotDeleteC)
 
-		par.Do(func() error {
-			switch rule := rule.(type) {
-			case *grapher.GraphUnitRule:
-				var data graph.Output
-				if err := readJSONFileFS(buildDataFS, rule.Target(), &data); err != nil {
-					if os.IsNotExist(err) {
-						log.Printf("Warning: no build data for unit %s %s.", rule.Unit.Type, rule.Unit.Name)
-						return nil
-					}
-					return err
-				}
-				if opt.DryRun || GlobalOpt.Verbose {
-					log.Printf("# Importing graph data (%d defs, %d refs, %d docs, %d anns) for unit %s %s", len(data.Defs), len(data.Refs), len(data.Docs), len(data.Anns), rule.Unit.Type, rule.Unit.Name)
-					if opt.DryRun {
-						return nil
-					}
-				}
+	provenanceC, err := c.AddCommand("provenance",
+		"show how a commit's data was produced and imported",
+		"The provenance command prints (as JSON) how the data of the commit given by --commit was produced and imported: the src versions that built and imported it, the versions of the toolchains that analyzed it, the hosts that ran the build and import, how long they took, and where the build data was imported from. Use it to reproduce and audit analysis results.",
+		&storeProvenanceCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(provenanceC)
+	setDefaultCommitIDOpt(provenanceC)
 
-				// HACK: Transfer docs to [def].Docs.
-				docsByPath := make(map[string]*graph.Doc, len(data.Docs))
-				for _, doc := range data.Docs {
-					docsByPath[doc.Path] = doc
-				}
-				for _, def := range data.Defs {
-					if doc, present := docsByPath[def.Path]; present {
-						def.Docs = append(def.Docs, graph.DefDoc{Format: doc.Format, Data: doc.Data})
-					}
-				}
+	_, err = c.AddCommand("export-ml",
+		"export defs as JSONL records for ML pipelines",
+		`The export-ml command writes a JSON record (one per line) for each def in the store (or in the repo and commit given by --repo and --commit), with its name, kind, signature, docs, language, and surrounding source code, for training code-search and documentation models. It is much faster than gathering the same data with repeated queries.
 
-				switch imp := stor.(type) {
-				case store.RepoImporter:
-					if err := imp.Import(opt.CommitID, rule.Unit, data); err != nil {
-						return err
-					}
-				case store.MultiRepoImporter:
-					if err := imp.Import(opt.Repo, opt.CommitID, rule.Unit, data); err != nil {
-						return err
-					}
-				default:
-					return fmt.Errorf("store (type %T) does not implement importing", stor)
-				}
+Use --exported and --documented to export only the defs that are useful for training, and --sample to export a deterministic fraction of them.
 
-				mu.Lock()
-				hasIndexableData = true
-				mu.Unlock()
-			}
-			return nil
-		})
-	}
-	if err := par.Wait(); err != nil {
-		return err
+Repos' licenses are read from a JSON file (see --licenses) that maps repos, or repo prefixes ending in '/', to SPDX license IDs; each record's License is set from it, and --allow-licenses excludes repos whose licenses are not listed (including repos whose licenses are unknown).
+
+Source context is only included for defs in the current repository, which must be checked out at the exported commit.`,
+		&storeExportMLCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if hasIndexableData && !opt.NoIndex {
-		if GlobalOpt.Verbose {
-			log.Printf("# Building indexes")
-		}
-		switch s := stor.(type) {
-		case store.RepoIndexer:
-			if err := s.Index(opt.CommitID); err != nil {
-				return err
-			}
-		case store.MultiRepoIndexer:
-			if err := s.Index(opt.Repo, opt.CommitID); err != nil {
-				return err
-			}
-		}
+	compactC, err := c.AddCommand("compact",
+		"merge a commit's per-unit data files into a pack file",
+		`The compact command merges the many small per-unit data files of the commit given by --commit into a single pack file with an index of the files' offsets (like a git packfile). Commits with many source units are much faster to enumerate and read once compacted, especially in stores on S3. Packed files are read transparently, so compaction doesn't change query results.
+
+Data written after compaction (e.g., by reimporting a source unit) is stored in loose files until the commit is compacted again. Compacting an already compacted commit does nothing.
+
+With --delta-base, the files are stored as deltas against the same files in another commit (usually the commit's parent), which takes much less space for repos with many imported commits, because most defs and refs are identical in consecutive commits. Deltas are resolved when they are read, which makes queries of the commit slower. The base commit must stay in the store and must not be reimported. Run compact without --delta-base to store the files in full again.
+
+With --dedup, each source unit's files are instead stored in a blob named by the hash of its contents, in a directory shared by all of the repo's commits. Units that are unchanged across commits are then stored only once. Unlike --delta-base, this doesn't make queries slower or make the commit depend on another commit being kept. Run "src store stats" to see how much space deduplication saves.`,
+		&storeCompactCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
+	setDefaultRepoURIOpt(compactC)
+	setDefaultCommitIDOpt(compactC)
 
-	return nil
+	statsC, err := c.AddCommand("stats",
+		"show how repos' data is stored",
+		`The stats command shows, for the repo given by --repo (or all repos in the store), how many commits are compacted and how many files are stored loose, as deltas, or in deduplicated blobs (see "src store compact"), and how much space compaction saves.`,
+		&storeStatsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(statsC)
 }
 
-// sample imports sample data (when the --sample option is given).
-func (c *StoreImportCmd) sample(s interface{}) error {
-	dataString := []byte(`"abcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcd"`)
-	makeGraphData := func(numDefs, numRefs int) *graph.Output {
-		defs := make([]graph.Def, numDefs)
-		refs := make([]graph.Ref, numRefs)
+// projectStore is the store configuration of the current repository
+// (from its Srcfile and .srclibrc), if any. Its settings are used
+// when the corresponding flags (or --config fields) are not given.
+var projectStore *config.Store
 
-		data := graph.Output{
-			Defs: make([]*graph.Def, numDefs),
-			Refs: make([]*graph.Ref, numRefs),
-		}
+// OpenStore is called by all of the store subcommands to open the
+// store.
+var OpenStore func() (interface{}, error) = storeCmd.store
 
-		var wg sync.WaitGroup
+type StoreCmd struct {
+	Type    string `short:"t" long:"type" description:"the (multi-)repo store type to use (RepoStore, MultiRepoStore, or ShardedMultiRepoStore, whose shards' roots are given in the --config)" default:"RepoStore"`
+	Root    string `short:"r" long:"root" description:"the root of the store (repo clone dir for RepoStore, global path for MultiRepoStore, etc.; may be an s3://BUCKET/PATH URL)" default:".srclib-store"`
+	Config  string `long:"config" description:"(rarely used) JSON-encoded config for extra config, specific to each store type"`
+	Profile string `long:"profile" description:"credential profile for remote stores (see 'src auth'; default: use AWS_* environment variables)"`
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for i := 0; i < numDefs; i++ {
-				defs[i] = graph.Def{
-					DefKey:   graph.DefKey{Path: fmt.Sprintf("def-path-%d", i)},
-					Name:     fmt.Sprintf("def-name-%d", i),
-					Kind:     "mykind",
-					DefStart: uint32((i % 53) * 37),
-					DefEnd:   uint32((i%53)*37 + (i % 20)),
-					File:     fmt.Sprintf("dir%d/subdir%d/subsubdir%d/file-%d.foo", i%5, i%3, i%7, i%11),
-					Exported: i%5 == 0,
-					Local:    i%3 == 0,
-					Data:     dataString,
-				}
-			}
-		}()
+	ReadOnly bool `long:"read-only" description:"open the store read-only, so that commands fail instead of writing to it (e.g., to build a missing index)"`
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for i := 0; i < numRefs; i++ {
-				refs[i] = graph.Ref{
-					DefPath: fmt.Sprintf("ref-path-%d", i%179),
-					Def:     i%5 == 0,
-					Start:   uint32((i % 51) * 39),
-					End:     uint32((i%51)*37 + (int(i) % 18)),
-					File:    fmt.Sprintf("dir%d/subdir%d/subsubdir%d/file-%d.foo", i%3, i%5, i%7, i%11),
-				}
-				if i%3 == 0 {
-					refs[i].DefUnit = fmt.Sprintf("def-unit-%d", i%5)
-					refs[i].DefUnitType = fmt.Sprintf("def-unit-type-%d", i%4)
-					if i%7 == 0 {
-						refs[i].DefRepo = fmt.Sprintf("def-repo-%d", i%7)
-					}
-				}
-			}
-		}()
+	Scope string `long:"scope" description:"only allow access to the repos in these namespaces of a MultiRepoStore (comma-separated; overrides the Scope in the store --config)" value-name:"NAMESPACES"`
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for i := range defs {
-				data.Defs[i] = &defs[i]
-			}
-			for i := range refs {
-				data.Refs[i] = &refs[i]
-			}
-		}()
+	QueryCache string `long:"query-cache" description:"cache the results of defs and refs queries that are scoped to specific commits in DIR, so that repeated queries (e.g., from editor plugins) are fast; cached results are invalidated when the commits' data or indexes change" value-name:"DIR"`
 
-		wg.Wait()
-		return &data
-	}
+	Overlay bool `long:"overlay" description:"when units, defs, and refs queries select a commit whose data was imported for only the source units that changed since a base commit (such as an uncommitted working tree built with 'src make --overlay'), merge it over the base commit's data, so that the whole repo can be navigated; a MultiRepoStore query must also select the repo"`
 
-	start := time.Now()
-	log.Printf("Making sample data (%d defs, %d refs)", c.SampleDefs, c.SampleRefs)
-	data := makeGraphData(c.SampleDefs, c.SampleRefs)
-	unit := &unit.SourceUnit{Type: "MyUnitType", Name: "MyUnit"}
-	files := map[string]struct{}{}
-	for _, def := range data.Defs {
-		files[def.File] = struct{}{}
-	}
-	for _, ref := range data.Refs {
-		files[ref.File] = struct{}{}
-	}
-	for f := range files {
-		unit.Files = append(unit.Files, f)
-	}
-	if d := time.Since(start); d > time.Millisecond*250 {
-		log.Printf("Done making sample data (took %s).", d)
-	}
+	MaxMemory byteSize `long:"max-memory" description:"limit the estimated memory used to hold source units' data during import (by decoding fewer units at once) and during defs and refs queries that can be streamed (by querying and printing one unit at a time); data is not spilled to disk, so a unit whose data alone exceeds the limit is still imported (alone); e.g., 512M or 2G" value-name:"SIZE"`
 
-	// Find some sample objects to query for down below.
-	ref2 := data.Refs[len(data.Refs)/2+1]
-	ref3 := data.Refs[len(data.Refs)/3+1]
-	def2 := data.Defs[len(data.Defs)/2+1]
-	def3 := data.Defs[len(data.Defs)/3+1]
-	// Find a ref that has the Unit/UnitType filled in.
-	var (
-		refDef4      graph.RefDefKey
-		foundRefDef4 bool
-	)
-	for _, ref := range data.Refs[len(data.Refs)/2:] {
-		if ref.DefUnit != "" && ref.DefUnitType != "" {
-			refDef4 = ref.RefDefKey()
-			foundRefDef4 = true
-			break
-		}
+	MaxResults      int      `long:"max-results" description:"fail defs and refs queries that select more than this many results, instead of letting overly broad queries run for hours (0 for no limit)" default:"1000000" value-name:"N"`
+	MaxBytesScanned byteSize `long:"max-bytes-scanned" description:"fail defs and refs queries that read more than this much data, including data rejected by filters; e.g., 512M or 2G (0 for no limit)" default:"4G" value-name:"SIZE"`
+	NoQueryLimits   bool     `long:"no-query-limits" description:"don't enforce --max-results and --max-bytes-scanned (for queries that are known to be large)"`
+
+	SlowQueryLog       string        `long:"slow-query-log" description:"append the defs and refs queries that take longer than --slow-query-threshold to FILE, as JSON lines recording each query's filters and shape fingerprint, the repos and source units it scanned, the bytes it read, and its duration" value-name:"FILE"`
+	SlowQueryThreshold time.Duration `long:"slow-query-threshold" description:"min duration of the queries written to the --slow-query-log" default:"1s"`
+
+	ErrorFormat msgFormat `long:"error-format" description:"format of error messages (text or json); the exit code also indicates the error category" default:"text"`
+
+	skipFormatCheck bool // don't check the store's format version on open
+	allowCreate     bool // don't fail if the store's root does not exist
+
+	slowQueries *store.SlowQueryLog // the opened --slow-query-log
+}
+
+var storeCmd StoreCmd
+
+func (c *StoreCmd) Execute(args []string) error { return nil }
+
+// store returns the store specified by StoreCmd's Type and Root
+// options.
+func (c *StoreCmd) store() (interface{}, error) {
+	if GlobalOpt.LogFormat == "json" {
+		store.SetVerboseLogOutput(logger.writer(levelDebug))
 	}
 
-	size, err := store.Codec.NewEncoder(ioutil.Discard).Encode(data)
+	conf, err := c.config()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Printf("Encoded data is %s", bytesString(size))
 
-	if c.CommitID == "" {
-		c.CommitID = strings.Repeat("f", 40)
+	if c.ReadOnly && c.allowCreate {
+		return nil, newCmdError(ExitUsage, errors.New("cannot write to a store opened with --read-only"))
 	}
-
-	log.Printf("Importing %d defs and %d refs into the source unit %+v at commit %s", len(data.Defs), len(data.Refs), unit.ID2(), c.CommitID)
-	start = time.Now()
-	switch imp := s.(type) {
-	case store.RepoImporter:
-		c.Repo = ""
-		if err := imp.Import(c.CommitID, unit, *data); err != nil {
-			return err
+	// openFS opens the VFS at root, failing over to the replicas
+	// (if any) when root is unhealthy.
+	openFS := func(root string, replicas []string) (rwvfs.FileSystem, error) {
+		fs, err := c.rootFS(root, conf)
+		if err != nil {
+			return nil, err
 		}
-	case store.MultiRepoImporter:
-		if c.Repo == "" {
-			c.Repo = "example.com/my/repo"
+		if len(replicas) > 0 {
+			var fc store.FailoverConfig
+			if conf.Failover.HealthCheckInterval != "" {
+				if fc.HealthCheckInterval, err = time.ParseDuration(conf.Failover.HealthCheckInterval); err != nil {
+					return nil, fmt.Errorf("parsing store --config Failover.HealthCheckInterval: %s", err)
+				}
+			}
+			rfss := make([]rwvfs.FileSystem, len(replicas))
+			for i, replica := range replicas {
+				if rfss[i], err = c.rootFS(replica, conf); err != nil {
+					return nil, err
+				}
+			}
+			fs = store.FailoverFS(fs, rfss, fc)
 		}
-		log.Printf(" - repo %s", c.Repo)
-		if err := imp.Import(c.Repo, c.CommitID, unit, *data); err != nil {
-			return err
+		if c.ReadOnly {
+			fs = store.ReadOnlyFS(fs)
 		}
-	default:
-		return fmt.Errorf("store (type %T) does not implement importing", s)
+		return fs, nil
 	}
-	log.Printf("Import took %s (~%s per def/ref)", time.Since(start), time.Duration(int64(time.Since(start))/int64(len(data.Defs)+len(data.Refs))))
 
-	start = time.Now()
-	if !c.NoIndex {
-		switch s := s.(type) {
-		case store.RepoIndexer:
-			if err := s.Index(c.CommitID); err != nil {
-				return err
+	var s interface{}
+	switch c.Type {
+	case "RepoStore":
+		if conf.Scope != nil {
+			return nil, newCmdError(ExitUsage, errors.New("namespace scopes are only supported by MultiRepoStores"))
+		}
+		fs, err := openFS(c.Root, conf.Replicas)
+		if err != nil {
+			return nil, err
+		}
+		s = store.NewFSRepoStore(fs)
+	case "MultiRepoStore", "ShardedMultiRepoStore":
+		mrsConf := &store.FSMultiRepoStoreConf{RepoAliases: conf.RepoAliases, Namespaces: conf.Namespaces, Scope: conf.Scope}
+		if err := mrsConf.CheckScope(); err != nil {
+			return nil, newCmdError(ExitUsage, err)
+		}
+		if c.Type == "MultiRepoStore" {
+			fs, err := openFS(c.Root, conf.Replicas)
+			if err != nil {
+				return nil, err
 			}
-		case store.MultiRepoIndexer:
-			if err := s.Index(c.Repo, c.CommitID); err != nil {
-				return err
+			s = store.NewFSMultiRepoStore(rwvfs.Walkable(fs), mrsConf)
+			break
+		}
+
+		if len(conf.Shards) == 0 {
+			return nil, newCmdError(ExitUsage, errors.New("a ShardedMultiRepoStore requires the roots of its shards (in the Shards field of the store --config)"))
+		}
+		if len(conf.Replicas) > 0 {
+			return nil, newCmdError(ExitUsage, errors.New("replicas are not supported by ShardedMultiRepoStores"))
+		}
+		fss := make([]rwvfs.WalkableFileSystem, len(conf.Shards))
+		for i, root := range conf.Shards {
+			fs, err := openFS(root, nil)
+			if err != nil {
+				return nil, err
 			}
+			fss[i] = rwvfs.Walkable(fs)
 		}
+		s = store.NewShardedMultiRepoStore(fss, mrsConf)
+	default:
+		return nil, fmt.Errorf("unrecognized store --type value: %q (valid values are RepoStore, MultiRepoStore, ShardedMultiRepoStore)", c.Type)
 	}
-	log.Printf("Index took %s (~%s per def/ref)", time.Since(start), time.Duration(int64(time.Since(start))/int64(len(data.Defs)+len(data.Refs))))
 
-	if c.SampleImportOnly {
-		return nil
+	// The migrate command must be able to open stores whose format
+	// is not readable.
+	if !c.skipFormatCheck {
+		if err := store.CheckFormat(s); err != nil {
+			return nil, err
+		}
 	}
+	if err := setCodec(s, conf); err != nil {
+		return nil, err
+	}
+	if err := setNetFetch(conf); err != nil {
+		return nil, err
+	}
+	store.DefDataIndexKeys = conf.IndexDefData
+	return s, nil
+}
 
-	log.Println()
-	log.Printf("Running some commands to list sample data...")
-
-	runCmd := func(args ...string) error {
-		start := time.Now()
-		var b bytes.Buffer
-		c := exec.Command(args[0], args[1:]...)
-		c.Stdout = &b
-		c.Stderr = &b
-		log.Println()
-		log.Println(strings.Join(c.Args, " "))
-		if err := c.Run(); err != nil {
-			return fmt.Errorf("command %v failed\n\noutput was:\n%s", c.Args, b.String())
+// rootFS returns the VFS at a store root (the --root, or the root of
+// a shard). If the root is an S3 URL (s3://BUCKET/PATH), it returns an
+// s3vfs root that is cached on local disk (see storeConfig.Cache);
+// otherwise it returns the local directory at the root.
+func (c *StoreCmd) rootFS(root string, conf *storeConfig) (rwvfs.FileSystem, error) {
+	if !strings.HasPrefix(root, "s3://") {
+		if !c.allowCreate {
+			if _, err := os.Stat(root); os.IsNotExist(err) {
+				return nil, newCmdError(ExitStoreNotFound, fmt.Errorf("store %s does not exist (use `src store import` to create it)", root))
+			}
 		}
-		if GlobalOpt.Verbose {
-			log.Println(b.String())
-		} else {
-			log.Printf("-> printed %d lines of output (run with `%s -v` to view)", bytes.Count(b.Bytes(), []byte{'\n'}), os.Args[0])
+		fs := rwvfs.OS(root)
+		type createParents interface {
+			CreateParentDirs(bool)
 		}
-		log.Printf("-> took %s", time.Since(start))
-		return nil
+		if fs, ok := fs.(createParents); ok {
+			fs.CreateParentDirs(true)
+		}
+		return fs, nil
 	}
 
-	prog := os.Args[0]
-	storeCmdArgs := func(subcmd, repo string, args ...string) []string {
-		x := []string{prog, "store", subcmd}
-		if repo != "" {
-			x = append(x, "--repo", repo)
-		}
-		x = append(x, "--commit", c.CommitID)
-		x = append(x, args...)
-		return x
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s3Keys(c.Profile)
+	if err != nil {
+		return nil, err
+	}
+	s3Config := &s3util.Config{Service: s3.DefaultService, Keys: keys}
+	bucket := &url.URL{Scheme: "https", Host: u.Host + ".s3.amazonaws.com", Path: "/"}
+	var fs rwvfs.FileSystem = s3vfs.S3(bucket, s3Config)
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		fs = rwvfs.Sub(fs, p)
 	}
 
-	if err := runCmd(storeCmdArgs("versions", c.Repo)...); err != nil {
-		return err
+	cacheDir, maxSize := conf.Cache.Dir, conf.Cache.MaxSize
+	if maxSize < 0 {
+		return fs, nil
 	}
-	if err := runCmd(storeCmdArgs("units", c.Repo)...); err != nil {
-		return err
+	if cacheDir == "" {
+		cacheDir = filepath.Join(srclib.CacheDir, "store", u.Host, filepath.FromSlash(u.Path))
 	}
-	if err := runCmd(storeCmdArgs("units", c.Repo, "--file", def2.File)...); err != nil {
-		return err
+	if maxSize == 0 {
+		maxSize = defaultStoreCacheSize
 	}
-	if err := runCmd(storeCmdArgs("units", c.Repo, "--file", ref2.File)...); err != nil {
-		return err
+	return store.NewDiskCacheFS(fs, cacheDir, maxSize)
+}
+
+// queryCache returns the query cache in the --query-cache dir, or nil
+// if no dir is given.
+func (c *StoreCmd) queryCache() (*store.QueryCache, error) {
+	// Cached results are only invalidated when the data of the
+	// queried commits changes, not their base commits' data.
+	if c.QueryCache == "" || c.Overlay {
+		return nil, nil
+	}
+	roots := []string{c.Root}
+	if c.Type == "ShardedMultiRepoStore" {
+		conf, err := c.config()
+		if err != nil {
+			return nil, err
+		}
+		roots = conf.Shards
 	}
-	if err := runCmd(storeCmdArgs("defs", c.Repo, "--file", def3.File)...); err != nil {
-		return err
+	for i, root := range roots {
+		if !strings.HasPrefix(root, "s3://") {
+			var err error
+			if roots[i], err = filepath.Abs(root); err != nil {
+				return nil, err
+			}
+		}
 	}
-	if err := runCmd(storeCmdArgs("defs", c.Repo, "--query", def3.Name[:len(def3.Name)-2])...); err != nil {
-		return err
+	return store.NewQueryCache(c.QueryCache, c.Type+" "+strings.Join(roots, " "))
+}
+
+// queryLimits returns a filter that enforces the --max-results and
+// --max-bytes-scanned limits on a defs or refs query, or nil if there
+// are no limits.
+func (c *StoreCmd) queryLimits() interface {
+	store.DefFilter
+	store.RefFilter
+} {
+	if c.NoQueryLimits || (c.MaxResults == 0 && c.MaxBytesScanned == 0) {
+		return nil
 	}
-	if err := runCmd(storeCmdArgs("refs", c.Repo, "--file", ref3.File)...); err != nil {
-		return err
+	return store.WithQueryLimits(store.QueryLimits{MaxResults: c.MaxResults, MaxBytesScanned: int64(c.MaxBytesScanned)})
+}
+
+// overlay returns a store that merges the data of overlay commits over
+// the data of their base commits (see store.OverlayStore) if --overlay
+// is given, or s otherwise.
+func (c *StoreCmd) overlay(s interface{}) (interface{}, error) {
+	if !c.Overlay {
+		return s, nil
+	}
+	rs, ok := s.(interface {
+		store.RepoStore
+		store.ProvenanceStore
+	})
+	if !ok {
+		return nil, newCmdError(ExitUsage, fmt.Errorf("store (type %T) does not support --overlay", s))
 	}
-	if foundRefDef4 {
-		if err := runCmd(storeCmdArgs("refs", c.Repo, "--def-unit-type", refDef4.DefUnitType, "--def-unit", refDef4.DefUnit, "--def-path", refDef4.DefPath)...); err != nil {
-			return err
+	return store.OverlayStore(rs), nil
+}
+
+// logSlowQueries returns a UnitStore that writes the slow queries to
+// us to the --slow-query-log, or us if there is no log.
+func (c *StoreCmd) logSlowQueries(us store.UnitStore) (store.UnitStore, error) {
+	if c.SlowQueryLog == "" {
+		return us, nil
+	}
+	if c.slowQueries == nil {
+		f, err := os.OpenFile(c.SlowQueryLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening --slow-query-log: %s", err)
 		}
+		c.slowQueries = store.NewSlowQueryLog(f, c.SlowQueryThreshold)
 	}
+	return store.SlowQueryLogUnitStore(us, c.slowQueries), nil
+}
 
+// checkSampleRate returns a usage error if rate is not a valid
+// --sample-rate (0 for no sampling).
+func checkSampleRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return newCmdError(ExitUsage, fmt.Errorf("--sample-rate must be between 0 and 1 (got %v)", rate))
+	}
 	return nil
 }
 
-// countingWriter wraps an io.Writer, counting the number of bytes
-// written.
-type countingWriter struct {
-	io.Writer
-	n uint64
+// sampleFilter returns a filter that samples the results of a defs or
+// refs query for --sample-rate and --sample-seed, or nil if rate is 0.
+func sampleFilter(rate float64, seed int64) interface {
+	store.DefFilter
+	store.RefFilter
+} {
+	if rate == 0 {
+		return nil
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return store.Sample(rate, seed)
 }
 
-func (cr *countingWriter) Write(p []byte) (n int, err error) {
-	n, err = cr.Writer.Write(p)
-	cr.n += uint64(n)
-	return
+// sampleResults is the output of a defs or refs query with
+// --sample-rate.
+type sampleResults struct {
+	SampleRate     float64
+	SampleSize     int // number of results in the sample
+	EstimatedTotal int // estimated number of results of the query without sampling
+	Results        interface{}
 }
 
-type storeIndexCriteria struct {
-	Repo     string `long:"repo" description:"only indexes for this repo"`
-	CommitID string `long:"commit" description:"only indexes for this commit ID"`
-	UnitType string `long:"unit-type" description:"only indexes for this source unit type"`
-	Unit     string `long:"unit" description:"only indexes for this source unit name"`
-	Name     string `long:"name" description:"only indexes whose name contains this substring"`
-	Type     string `long:"type" description:"only indexes whose Go type contains this substring"`
-
-	NoSourceUnit bool `long:"no-unit" description:"skip indexes for specific source units"`
+func newSampleResults(results interface{}, n int, rate float64) *sampleResults {
+	return &sampleResults{
+		SampleRate:     rate,
+		SampleSize:     n,
+		EstimatedTotal: store.EstimateTotal(n, rate),
+		Results:        results,
+	}
+}
 
-	Stale    bool `long:"stale" description:"only stale indexes"`
-	NotStale bool `long:"not-stale" description:"only non-stale indexes"`
+// checkQueryLimit adds guidance to an error that reports that a query
+// exceeded the limits of queryLimits.
+func checkQueryLimit(err error) error {
+	if store.IsQueryLimitExceeded(err) {
+		return newCmdError(ExitQueryLimit, fmt.Errorf("%s; narrow the query with filters (e.g., --repo, --commit, --unit, or --file), or raise the limit with --max-results or --max-bytes-scanned (or disable the limits with --no-query-limits)", err))
+	}
+	return err
+}
 
-	ReposLimit  int `long:"repos-limit" description:"only indexes from this many repos (0=all)"`
-	ReposOffset int `long:"repos-offset" description:"only indexes after skipping this many repos"`
+// defaultStoreCacheSize is the default max size (in bytes) of the
+// local disk cache for S3-backed stores.
+const defaultStoreCacheSize = 1 << 30
+
+// storeConfig is the JSON-encoded value of the StoreCmd's --config
+// flag.
+type storeConfig struct {
+	// Codec is the name of the codec that data files are encoded
+	// with (json, protobuf, protobuf-full, or msgpack). It only
+	// applies to new stores; existing stores are always read and written with the
+	// codec they were created with (see `src store convert-codec`).
+	Codec string
+
+	// Fetch configures how data is read from network (e.g., S3)
+	// stores. Unset fields keep their defaults (see
+	// store.NetFetchConfig).
+	Fetch struct {
+		Parallel     int
+		Retries      *int
+		RetryBackoff string // e.g., "250ms"
+		CoalesceGap  *int64
+		MaxFetchSize int64
+	}
+
+	// Lock configures the locks that serialize concurrent imports
+	// of the same repo and commit.
+	Lock struct {
+		// Dir is the directory of the lock files (default:
+		// $SRCLIBCACHE/store-locks/ROOT). Stores written to by
+		// many hosts should set it to a directory on a shared
+		// filesystem or use Command.
+		Dir string
+
+		// Command is a command that acquires and releases locks
+		// using a lock service (see store.NewCommandLocker). If
+		// set, Dir is ignored.
+		Command string
+	}
+
+	// RepoAliases maps alias repo URIs to canonical repo URIs in a
+	// MultiRepoStore (e.g., {"internal.git.corp/x":
+	// "github.com/org/x"}), so that data for mirrored repos is not
+	// fragmented (see store.FSMultiRepoStoreConf).
+	RepoAliases map[string]string
+
+	// Namespaces maps namespace names (such as orgs or teams) to the
+	// repo URI prefixes of the repos in them in a MultiRepoStore
+	// (e.g., {"payments": ["github.com/acme/pay-"]}), and Scope (if
+	// set) restricts the store to the repos in the named namespaces
+	// (see store.FSMultiRepoStoreConf). The --scope flag overrides
+	// Scope.
+	Namespaces map[string][]string
+	Scope      []string
+
+	// Hooks are run after data is imported and after indexes are
+	// built (by `src store import` and `src store importd`).
+	Hooks storeHooks
+
+	// IndexDefData lists the Def.Data paths (e.g., "Visibility" or
+	// "Annotations") whose values are indexed when indexes are built,
+	// so that `src store defs --data-filter` queries by those paths
+	// don't scan every def (see store.DefDataIndexKeys).
+	IndexDefData []string
+
+	// Shards lists the roots (local directories or s3://BUCKET/PATH
+	// URLs) of the shards of a ShardedMultiRepoStore, which stores
+	// each repo in one of the shards (chosen by a hash of the repo
+	// URI) and queries them in parallel, so that the listing and
+	// throughput limits of a single bucket or disk don't limit the
+	// size of the store. The --root is not used. The shards must
+	// not be reordered, and shards must not be added or removed
+	// unless the data is reimported.
+	Shards []string
+
+	// Replicas lists the roots (local directories or s3://BUCKET/PATH
+	// URLs) of replicas of the store at --root (kept up to date by
+	// other means, such as S3 cross-region replication). Reads fail
+	// over to the first healthy replica when the --root (or a
+	// replica) fails with an error other than "not exist", so that
+	// queries keep working when it is unavailable (see
+	// store.FailoverFS). Writes always go to the --root.
+	Replicas []string
+
+	// Failover configures failover to Replicas.
+	Failover struct {
+		// HealthCheckInterval is how long a root that failed is
+		// skipped before it is tried again (default: "30s").
+		HealthCheckInterval string
+	}
+
+	// Cache configures the local disk cache of S3-backed stores.
+	Cache struct {
+		// Dir is the cache directory (default:
+		// $SRCLIBCACHE/store/BUCKET/PATH).
+		Dir string
+
+		// MaxSize is the max total size of the cached files, in
+		// bytes (default: 1 GB). If negative, the cache is
+		// disabled.
+		MaxSize int64
+	}
 }
 
-func (c storeIndexCriteria) IndexCriteria() store.IndexCriteria {
-	crit := store.IndexCriteria{
-		Repo:     c.Repo,
-		CommitID: c.CommitID,
-		Name:     c.Name,
-		Type:     c.Type,
+// config parses the StoreCmd's --config flag, using the project's
+// store configuration (if any) for unset fields.
+func (c *StoreCmd) config() (*storeConfig, error) {
+	var conf storeConfig
+	if c.Config != "" {
+		if err := json.Unmarshal([]byte(c.Config), &conf); err != nil {
+			return nil, fmt.Errorf("parsing store --config: %s", err)
+		}
 	}
-	if c.Stale && c.NotStale {
-		log.Fatal("must specify exactly one of --stale and --not-stale")
+	if c.Scope != "" {
+		conf.Scope = nil
+		for _, ns := range strings.Split(c.Scope, ",") {
+			conf.Scope = append(conf.Scope, strings.TrimSpace(ns))
+		}
 	}
-	if c.Stale {
-		t := true
-		crit.Stale = &t
+	if projectStore != nil {
+		if conf.Codec == "" {
+			conf.Codec = projectStore.Codec
+		}
+		if conf.Fetch.Parallel == 0 {
+			conf.Fetch.Parallel = projectStore.Parallel
+		}
+		if conf.IndexDefData == nil {
+			conf.IndexDefData = projectStore.IndexDefData
+		}
 	}
-	if c.NotStale {
-		f := false
-		crit.Stale = &f
+	return &conf, nil
+}
+
+// setNetFetch applies the network fetch settings in conf to
+// store.NetFetch.
+func setNetFetch(conf *storeConfig) error {
+	f := conf.Fetch
+	if f.Parallel != 0 {
+		store.NetFetch.Parallel = f.Parallel
 	}
-	if c.NoSourceUnit {
-		crit.Unit = store.NoSourceUnit
+	if f.Retries != nil {
+		store.NetFetch.Retries = *f.Retries
 	}
-	if c.UnitType != "" || c.Unit != "" {
-		crit.Unit = &unit.ID2{Type: c.UnitType, Name: c.Unit}
-		if crit.Unit.Type == "" || crit.Unit.Name == "" {
-			log.Fatal("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+	if f.RetryBackoff != "" {
+		d, err := time.ParseDuration(f.RetryBackoff)
+		if err != nil {
+			return fmt.Errorf("parsing store --config Fetch.RetryBackoff: %s", err)
 		}
+		store.NetFetch.RetryBackoff = d
 	}
-	crit.ReposLimit = c.ReposLimit
-	crit.ReposOffset = c.ReposOffset
-	return crit
+	if f.CoalesceGap != nil {
+		store.NetFetch.CoalesceGap = *f.CoalesceGap
+	}
+	if f.MaxFetchSize != 0 {
+		store.NetFetch.MaxFetchSize = f.MaxFetchSize
+	}
+	return nil
 }
 
-// doStoreIndexesCmd is invoked by both StoreIndexesCmd.Execute and
-// StoreBuildIndexesCmd.Execute.
-func doStoreIndexesCmd(crit store.IndexCriteria, opt storeIndexOptions, f func(interface{}, store.IndexCriteria, chan<- store.IndexStatus) ([]store.IndexStatus, error)) error {
-	if opt.Parallel != 1 {
-		log.Printf("NOTE: Index parallelism is %d. Output will printed as it is available, not necessarily ordered and grouped by repo, source unit, etc.", opt.Parallel)
+// setCodec checks that s was created with the codec specified in conf
+// (if any), or, if s is a new store, makes s use that codec. (Existing
+// stores use the codec that they were created with.)
+func setCodec(s interface{}, conf *storeConfig) error {
+	name, err := store.StoreCodec(s)
+	if err != nil {
+		return err
 	}
-	store.MaxIndexParallel = opt.Parallel
+	if conf.Codec == "" {
+		return nil
+	}
+	if name != "" && conf.Codec != name {
+		return fmt.Errorf("store was created with codec %q, not %q (run `src store convert-codec --to %s` to convert it)", name, conf.Codec, conf.Codec)
+	}
+	return store.SetStoreCodec(s, conf.Codec)
+}
 
-	printIndex := func(x store.IndexStatus) error {
-		if !opt.Print {
-			return nil
+type StoreImportCmd struct {
+	ImportOpt
+
+	Quiet bool `short:"q" long:"quiet" description:"silence all output"`
+
+	Sample           bool `long:"sample" description:"(sample data) import sample data, not .srclib-cache data"`
+	SampleDefs       int  `long:"sample-defs" description:"(sample data) number of sample defs to import" default:"100"`
+	SampleRefs       int  `long:"sample-refs" description:"(sample data) number of sample refs to import" default:"100"`
+	SampleImportOnly bool `long:"sample-import-only" description:"(sample data) only import, don't demonstrate listing data"`
+
+	RemoteBuildData bool `long:"remote-build-data" description:"import remote build data (from Sourcegraph or SRCLIB_BUILD_DATA_URL, not the local .srclib-cache build data)"`
+
+	Progress bool `long:"progress" description:"show per-unit progress (with elapsed time and ETA) on stderr"`
+
+	NoWait bool `long:"no-wait" description:"fail instead of waiting if another process is writing data for the same repo and commit"`
+}
+
+var storeImportCmd StoreImportCmd
+
+func (c *StoreImportCmd) Execute(args []string) error {
+	start := time.Now()
+
+	if c.Normalize && c.NoNormalize {
+		return newCmdError(ExitUsage, fmt.Errorf("--normalize and --no-normalize are mutually exclusive"))
+	}
+	for _, f := range c.DocFormats {
+		if f != store.DocFormatHTML && f != store.DocFormatMarkdown {
+			return newCmdError(ExitUsage, fmt.Errorf("invalid --doc-format %q (valid formats are %s and %s)", f, store.DocFormatHTML, store.DocFormatMarkdown))
 		}
-		return x.Fprint(os.Stderr)
 	}
 
+	storeCmd.allowCreate = true
+
 	s, err := OpenStore()
 	if err != nil {
 		return err
 	}
 
-	hasError := false
-	done := make(chan struct{})
-	indexChan := make(chan store.IndexStatus)
-	switch opt.Output {
-	case "json":
-		go func() {
-			for x := range indexChan {
-				PrintJSON(x, "")
-				if err := printIndex(x); err != nil {
-					log.Fatal(err)
-				}
-			}
-			done <- struct{}{}
-		}()
-	case "text":
-		_, isMultiRepo := s.(store.MultiRepoStore)
-		var repoTab string
-		if isMultiRepo {
-			repoTab = "\t"
-		}
-
-		go func() {
-			var lastRepo, lastCommitID string
-			var lastUnit *unit.ID2
-			for x := range indexChan {
-				if isMultiRepo {
-					if x.Repo != lastRepo {
-						if lastRepo != "" {
-							fmt.Println()
-						}
-						fmt.Println(x.Repo)
-					}
-				}
-				if x.CommitID != lastCommitID {
-					fmt.Print(repoTab, x.CommitID, "\n")
-				}
-				if x.Unit != lastUnit && x.Unit != nil {
-					if x.Repo == lastRepo && x.CommitID == lastCommitID {
-						fmt.Println()
-					}
-					fmt.Print(repoTab, "\t", x.Unit.Name, " ", x.Unit.Type, "\n")
-				}
-
-				if x.Unit != nil {
-					fmt.Print("\t")
-				}
-
-				fmt.Print(repoTab, "\t")
-				fmt.Printf("%s (%s) ", x.Name, x.Type)
-				if x.Stale {
-					fmt.Print("STALE ")
-				}
-				if x.Size != 0 {
-					fmt.Print(bytesString(uint64(x.Size)), " ")
-				}
-				if x.Error != "" {
-					fmt.Printf("(ERROR: %s) ", x.Error)
-					hasError = true
-				}
-				if x.BuildError != "" {
-					fmt.Printf("(BUILD ERROR: %s) ", x.BuildError)
-					hasError = true
-				}
-				if x.BuildDuration != 0 {
-					fmt.Printf("- build took %s ", x.BuildDuration)
-				}
-				fmt.Println()
+	if c.Sample {
+		return c.sample(s)
+	}
 
-				if err := printIndex(x); err != nil {
-					log.Fatal(err)
-				}
+	bdfs, label, err := getBuildDataFS(!c.RemoteBuildData, c.Repo, c.CommitID)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("# Importing build data for %s (commit %s) from %s", c.Repo, c.CommitID, label)
+	c.ImportOpt.Source = label
 
-				lastRepo = x.Repo
-				lastCommitID = x.CommitID
-				lastUnit = x.Unit
+	if c.Progress && !c.Quiet {
+		c.ImportOpt.Progress = logger.writer(levelInfo)
+	}
+	conf, err := storeCmd.config()
+	if err != nil {
+		return err
+	}
+	c.ImportOpt.Hooks = &conf.Hooks
+	c.ImportOpt.MaxMemory = int64(storeCmd.MaxMemory)
+	if !c.DryRun {
+		unlock, err := lockCommit(s, c.Repo, c.CommitID, !c.NoWait)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := unlock(); err != nil {
+				logger.Warnf("releasing store lock: %s", err)
 			}
-			done <- struct{}{}
 		}()
-	default:
-		return fmt.Errorf("unexpected --output value: %q", opt.Output)
 	}
-
-	_, err = f(s, crit, indexChan)
-	defer func() {
-		close(indexChan)
-		<-done
-	}()
-	if err != nil {
+	if err := Import(bdfs, s, c.ImportOpt); err != nil {
 		return err
 	}
-	if hasError {
-		return errors.New("\nindex listing or index building errors occurred (see above)")
+	if !c.Quiet {
+		logger.Infof("# Import completed in %s.", time.Since(start))
 	}
 	return nil
 }
 
-type storeIndexOptions struct {
-	Output   string `short:"o" long:"output" description:"output format (text|json)" default:"text"`
-	Parallel int    `short:"p" long:"parallel" description:"parallelism (may produce out-of-order output)" default:"1"`
+type ImportOpt struct {
+	DryRun  bool `short:"n" long:"dry-run" description:"print what would be done but don't do anything"`
+	NoIndex bool `long:"no-index" description:"don't build indexes (indexes inside a single source unit are always built)"`
 
-	Print bool `long:"print" description:"(debug) print representation of index"`
-}
+	Repo     string `long:"repo" description:"only import for this repo"`
+	Unit     string `long:"unit" description:"only import source units with this name"`
+	UnitType string `long:"unit-type" description:"only import source units with this type"`
+	CommitID string `long:"commit" description:"commit ID of commit whose data to import"`
 
-type StoreIndexesCmd struct {
-	storeIndexCriteria
-	storeIndexOptions
-}
+	UnitPrefix []string `long:"unit-prefix" description:"only import source units whose dir (or, for units with no dir, all of whose files) is under this path (may be repeated)" value-name:"PATH"`
+	UnitTypeIn []string `long:"unit-type-in" description:"only import source units with one of these types (comma-separated; may be repeated)" value-name:"TYPES"`
 
-var storeIndexesCmd StoreIndexesCmd
+	Verify bool `long:"verify" description:"check build data files against their checksum manifest (written by src make) before importing any data"`
 
-func (c *StoreIndexesCmd) Execute(args []string) error {
-	return doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, store.Indexes)
-}
+	Normalize   bool `long:"normalize" description:"normalize graph data before importing it (the default): canonicalize file paths, remove identical refs, and remove defs with invalid keys"`
+	NoNormalize bool `long:"no-normalize" description:"import graph data as is, without normalizing it"`
 
-type StoreIndexCmd struct {
-	storeIndexCriteria
-	storeIndexOptions
-}
+	DocFormats []string `long:"doc-format" description:"also store defs' docs in this format (text/html or text/x-markdown), converted from their plain text docs (may be repeated)" value-name:"FORMAT"`
 
-var storeIndexCmd StoreIndexCmd
+	NoTagGenerated bool `long:"no-tag-generated" description:"don't tag defs and refs in generated files (detected by file name patterns and header comments)"`
 
-func (c *StoreIndexCmd) Execute(args []string) error {
-	return doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, store.BuildIndexes)
+	NoAttributeVendored bool `long:"no-attribute-vendored" description:"don't attribute source units in vendor/ and node_modules/ dirs (and refs to their defs) to the upstream repos they were copied from"`
+
+	Decoders      int `long:"decoders" description:"number of source units to decode concurrently (default 4)" value-name:"N"`
+	Writers       int `long:"writers" description:"number of source units to write to the store concurrently (default 6)" value-name:"N"`
+	PipelineDepth int `long:"pipeline-depth" description:"max number of decoded source units waiting to be written (bounds memory use; default 4)" value-name:"N"`
+
+	Verbose bool
+
+	// Progress, if non-nil, receives per-unit progress reports.
+	Progress io.Writer
+
+	// Hooks, if non-nil, are run after data is imported and after
+	// indexes are built.
+	Hooks *storeHooks
+
+	// MaxMemory, if positive, is the max estimated memory (in bytes)
+	// used by decoded source units waiting to be (or being) written.
+	// Units are not spilled to disk: a unit whose data alone exceeds
+	// MaxMemory is decoded and written while no other unit is held.
+	MaxMemory int64
+
+	// Source describes where the build data is read from (such as a
+	// local build data dir or a remote build data URL). It is recorded
+	// in the commit's provenance.
+	Source string
+
+	// Context, if non-nil, stops the import when it is done: no more
+	// source units are imported (units that are being written are
+	// finished), indexes are not built, and Import returns
+	// Context.Err().
+	Context context.Context
 }
 
-type StoreReposCmd struct {
-	IDContains string `short:"i" long:"id-contains" description:"filter to repos whose ID contains this substring"`
+// filtersUnits returns whether any of the options that restrict which
+// source units are imported are set.
+func (opt *ImportOpt) filtersUnits() bool {
+	return opt.Unit != "" || opt.UnitType != "" || len(opt.UnitPrefix) > 0 || len(opt.UnitTypeIn) > 0
 }
 
-func (c *StoreReposCmd) filters() []store.RepoFilter {
-	var fs []store.RepoFilter
-	if c.IDContains != "" {
-		fs = append(fs, store.RepoFilterFunc(func(repo string) bool { return strings.Contains(repo, c.IDContains) }))
+// matchesUnit returns whether u satisfies all of the unit filter
+// options.
+func (opt *ImportOpt) matchesUnit(u *unit.SourceUnit) bool {
+	if (opt.Unit != "" && u.Name != opt.Unit) || (opt.UnitType != "" && u.Type != opt.UnitType) {
+		return false
+	}
+	if len(opt.UnitTypeIn) > 0 {
+		var found bool
+		for _, types := range opt.UnitTypeIn {
+			for _, t := range strings.Split(types, ",") {
+				if strings.TrimSpace(t) == u.Type {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
 	}
-	return fs
+	if len(opt.UnitPrefix) > 0 {
+		var found bool
+		for _, prefix := range opt.UnitPrefix {
+			if unitUnderPath(u, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
-var storeReposCmd StoreReposCmd
-
-func (c *StoreReposCmd) Execute(args []string) error {
-	s, err := OpenStore()
-	if err != nil {
-		return err
+// unitUnderPath returns whether the source unit's dir is (or is
+// under) the dir p. If the unit has no dir, it returns whether all of
+// its files are under p.
+func unitUnderPath(u *unit.SourceUnit, p string) bool {
+	under := func(file string) bool {
+		file, p := path.Clean(filepath.ToSlash(file)), path.Clean(filepath.ToSlash(p))
+		return p == "." || file == p || strings.HasPrefix(file, p+"/")
 	}
-
-	mrs, ok := s.(store.MultiRepoStore)
-	if !ok {
-		return fmt.Errorf("store (type %T) does not implement listing repositories", s)
+	if u.Dir != "" {
+		return under(u.Dir)
+	}
+	if len(u.Files) == 0 {
+		return false
 	}
+	for _, f := range u.Files {
+		if !under(f) {
+			return false
+		}
+	}
+	return true
+}
 
-	repos, err := mrs.Repos(c.filters()...)
-	if err != nil {
+// verifyBuildData checks the build data files (all of those listed in
+// the checksum manifest, and the targets of rules) against the
+// checksum manifest, so that truncated or corrupted files are
+// detected before any data is imported.
+func verifyBuildData(buildDataFS vfs.FileSystem, rules []makex.Rule) error {
+	m, err := buildstore.ReadChecksumManifest(buildDataFS)
+	if os.IsNotExist(err) {
+		return newCmdError(ExitCorruptBuildData, fmt.Errorf("build data has no checksum manifest (%s) to verify (run `src make` to rebuild it)", buildstore.ChecksumManifestName))
+	} else if err != nil {
+		return newCmdError(ExitCorruptBuildData, err)
+	}
+	if err := m.Verify(buildDataFS); err != nil {
 		return err
 	}
-	for _, repo := range repos {
-		fmt.Println(repo)
+	targets := make([]string, len(rules))
+	for i, rule := range rules {
+		targets[i] = buildDataTarget(buildDataFS, rule)
+	}
+	if err := m.Verify(buildDataFS, targets...); err != nil {
+		return err
 	}
+	logger.Debugf("Verified %d build data files against %s", len(m), buildstore.ChecksumManifestName)
 	return nil
 }
 
-type StoreVersionsCmd struct {
-	Repo           string `long:"repo"`
-	CommitIDPrefix string `long:"commit" description:"commit ID prefix"`
+// Import imports build data into a RepoStore or MultiRepoStore.
+func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
+	start := time.Now()
+	daemonMetrics.importStarted()
+	defer daemonMetrics.importDone()
 
-	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
-}
+	// Traverse the build data directory for this repo and commit to
+	// create the makefile that lists the targets (which are the data
+	// files we will import).
+	if _, err := buildDataFS.Lstat("."); os.IsNotExist(err) {
+		return newCmdError(ExitNoDataForCommit, fmt.Errorf("no build data for commit %s (run `src make` to build it)", opt.CommitID))
+	}
+	treeConfig, err := config.ReadCached(buildDataFS)
+	if err != nil {
+		return err
+	}
+	mf, err := plan.CreateMakefile(".", nil, "", treeConfig, plan.Options{NoCache: true})
+	if err != nil {
+		return err
+	}
 
-func (c *StoreVersionsCmd) filters() []store.VersionFilter {
-	var fs []store.VersionFilter
-	if c.Repo != "" {
-		fs = append(fs, store.ByRepos(c.Repo))
+	// If only the source units that changed since a base commit were
+	// built (see 'src make --since'), the other source units have no
+	// build data, but they aren't missing: queries read their data
+	// from the base commit (see store.OverlayStore).
+	var baseCommitID string
+	if info, err := buildstore.ReadBuildInfo(buildDataFS); err == nil {
+		baseCommitID = info.BaseCommitID
 	}
-	if c.CommitIDPrefix != "" {
-		fs = append(fs, store.VersionFilterFunc(func(version *store.Version) bool {
-			return strings.HasPrefix(version.CommitID, c.CommitIDPrefix)
-		}))
+
+	var (
+		mu               sync.Mutex
+		hasIndexableData bool
+		missingUnits     []string // units with no graph data
+		importedUnits    []unit.ID2
+	)
+
+	prep, err := newGraphDataPreparer(buildDataFS, mf.Rules, treeConfig.SourceUnits, opt)
+	if err != nil {
+		return err
 	}
-	if c.RepoCommitIDs != "" {
-		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
+
+	var rules []makex.Rule
+	for _, rule := range mf.Rules {
+		if opt.filtersUnits() {
+			type ruleForSourceUnit interface {
+				SourceUnit() *unit.SourceUnit
+			}
+			if rule, ok := rule.(ruleForSourceUnit); ok {
+				if !opt.matchesUnit(rule.SourceUnit()) {
+					continue
+				}
+			} else {
+				// Skip all non-source-unit rules if any unit
+				// filters are specified.
+				continue
+			}
+		}
+		rules = append(rules, rule)
 	}
-	return fs
-}
 
-var storeVersionsCmd StoreVersionsCmd
+	if opt.Verify {
+		if err := verifyBuildData(buildDataFS, rules); err != nil {
+			return err
+		}
+	}
 
-func (c *StoreVersionsCmd) Execute(args []string) error {
-	s, err := OpenStore()
+	var progress *importProgress
+	if !opt.DryRun {
+		var graphTargets []string
+		for _, rule := range rules {
+			if rule, ok := rule.(*grapher.GraphUnitRule); ok {
+				graphTargets = append(graphTargets, rule.Target())
+			}
+		}
+		progress = newImportProgress(opt.Progress, buildDataFS, graphTargets)
+		defer progress.stop()
+	}
+
+	// Import in a pipeline: decoders read and decode build data files
+	// while writers write previously decoded units to the store. At
+	// most opt.pipelineDepth() decoded units wait to be written, which
+	// bounds memory use.
+	budget := newMemoryBudget(opt.MaxMemory)
+	decode := func(rule makex.Rule) (item *importItem, err error) {
+		switch rule := rule.(type) {
+		case *grapher.GraphUnitRule:
+			var cost int64
+			if fi, err := buildDataFS.Stat(rule.Target()); err == nil {
+				size := fi.Size() * decodedSizeFactor
+				if budget.exceeds(size) {
+					logger.Warnf("The data of unit %s %s (~%d MB decoded) exceeds --max-memory; importing it alone.", rule.Unit.Type, rule.Unit.Name, size>>20)
+				}
+				cost = budget.acquire(size)
+			}
+			defer func() {
+				if item == nil {
+					budget.release(cost)
+				}
+			}()
+
+			var data graph.Output
+			if err := progress.readJSON(buildDataFS, rule.Target(), &data); err != nil {
+				if os.IsNotExist(err) {
+					// The graph data may have been imported
+					// directly into the store by src make.
+					var receipt grapher.ImportReceipt
+					if err := readJSONFileFS(buildDataFS, rule.ReceiptTarget(), &receipt); err == nil {
+						logger.Debugf("# Graph data for unit %s %s was imported by src make (%d defs, %d refs)", rule.Unit.Type, rule.Unit.Name, receipt.Defs, receipt.Refs)
+						if opt.DryRun {
+							return nil, nil
+						}
+						return &importItem{unit: rule.Unit, receipt: &receipt}, nil
+					}
+					if baseCommitID != "" {
+						logger.Debugf("# No build data for unit %s %s (unchanged since %s)", rule.Unit.Type, rule.Unit.Name, baseCommitID)
+						return nil, nil
+					}
+					logger.Warnf("no build data for unit %s %s.", rule.Unit.Type, rule.Unit.Name)
+					mu.Lock()
+					missingUnits = append(missingUnits, rule.Unit.Type+" "+rule.Unit.Name)
+					mu.Unlock()
+					return nil, nil
+				}
+				return nil, err
+			}
+			u := prep.prepare(rule.Unit, rule.Tool, &data)
+			if opt.DryRun || GlobalOpt.Verbose {
+				logger.Infof("# Importing graph data (%d defs, %d refs, %d docs, %d anns) for unit %s %s", len(data.Defs), len(data.Refs), len(data.Docs), len(data.Anns), rule.Unit.Type, rule.Unit.Name)
+				if opt.DryRun {
+					return nil, nil
+				}
+			}
+			return &importItem{unit: u, graph: &data, cost: cost}, nil
+
+		case *dep.ResolveDepsRule:
+			var ress []*dep.Resolution
+			if err := readJSONFileFS(buildDataFS, rule.Target(), &ress); err != nil {
+				if os.IsNotExist(err) {
+					if baseCommitID == "" {
+						logger.Warnf("no dependency resolution data for unit %s %s.", rule.Unit.Type, rule.Unit.Name)
+					}
+					return nil, nil
+				}
+				return nil, err
+			}
+			deps, err := dep.ResolutionsToResolvedDeps(ress, rule.Unit, opt.Repo, opt.CommitID)
+			if err != nil {
+				return nil, err
+			}
+			if opt.DryRun || GlobalOpt.Verbose {
+				logger.Infof("# Importing %d resolved deps for unit %s %s", len(deps), rule.Unit.Type, rule.Unit.Name)
+				if opt.DryRun {
+					return nil, nil
+				}
+			}
+			return &importItem{unit: rule.Unit, deps: deps}, nil
+		}
+		return nil, nil
+	}
+
+	write := func(item *importItem) error {
+		defer budget.release(item.cost)
+		if item.receipt != nil {
+			progress.unitDone(item.unit, item.receipt.Defs, item.receipt.Refs)
+			mu.Lock()
+			hasIndexableData = true
+			importedUnits = append(importedUnits, item.unit.ID2())
+			mu.Unlock()
+			return nil
+		}
+		if item.graph != nil {
+			if err := importGraphData(stor, opt.Repo, opt.CommitID, item.unit, item.graph); err != nil {
+				return err
+			}
+
+			progress.unitDone(item.unit, len(item.graph.Defs), len(item.graph.Refs))
+			daemonMetrics.unitImported(len(item.graph.Defs), len(item.graph.Refs))
+
+			mu.Lock()
+			hasIndexableData = true
+			importedUnits = append(importedUnits, item.unit.ID2())
+			mu.Unlock()
+			return nil
+		}
+
+		u := item.unit.ID2()
+		switch imp := stor.(type) {
+		case store.RepoDepImporter:
+			if err := imp.ImportDeps(opt.CommitID, u, item.deps); err != nil {
+				return err
+			}
+		case store.MultiRepoDepImporter:
+			if err := imp.ImportDeps(opt.Repo, opt.CommitID, u, item.deps); err != nil {
+				return err
+			}
+		default:
+			logger.Debugf("# Store (type %T) does not support importing deps; skipping deps for unit %s %s", stor, item.unit.Type, item.unit.Name)
+		}
+		return nil
+	}
+
+	discard := func(item *importItem) { budget.release(item.cost) }
+
+	if opt.Context != nil {
+		decodeUnit := decode
+		decode = func(rule makex.Rule) (*importItem, error) {
+			if err := opt.Context.Err(); err != nil {
+				return nil, err
+			}
+			return decodeUnit(rule)
+		}
+	}
+
+	if err := runImportPipeline(rules, decode, write, discard, opt.decoders(), opt.writers(), opt.pipelineDepth()); err != nil {
+		return err
+	}
+	if opt.Context != nil {
+		if err := opt.Context.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(importedUnits) > 0 {
+		publishChange(stor, store.ChangeImport, opt, importedUnits)
+		if opt.Hooks != nil {
+			runHooks(opt.Hooks.PostImport, &hookEvent{Event: "import", Repo: opt.Repo, CommitID: opt.CommitID, Units: importedUnits})
+		}
+	}
+
+	if hasIndexableData && !opt.NoIndex {
+		logger.Debugf("# Building indexes")
+		progress.indexing()
+		if err := indexCommit(stor, opt.Repo, opt.CommitID); err != nil {
+			return err
+		}
+		publishChange(stor, store.ChangeIndex, opt, importedUnits)
+		if opt.Hooks != nil {
+			runHooks(opt.Hooks.PostIndex, &hookEvent{Event: "index", Repo: opt.Repo, CommitID: opt.CommitID, Units: importedUnits})
+		}
+	}
+
+	if hasIndexableData {
+		if err := importProvenance(buildDataFS, stor, opt, start, len(importedUnits)); err != nil {
+			return err
+		}
+	}
+
+	if len(missingUnits) > 0 {
+		sort.Strings(missingUnits)
+		return newCmdError(ExitPartialImport, fmt.Errorf("imported partial data: missing build data for %d source units: %s", len(missingUnits), strings.Join(missingUnits, ", ")))
+	}
+	return nil
+}
+
+// importProvenance records the provenance of the imported data (see
+// store.Provenance), including the build info that src make wrote to
+// the build data, if any. Stores that don't support provenance are
+// skipped.
+func importProvenance(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt, start time.Time, units int) error {
+	p := &store.Provenance{
+		ImporterVersion: Version,
+		Imported:        start,
+		ImportDuration:  time.Since(start),
+		Source:          opt.Source,
+		Units:           units,
+	}
+	p.ImportHost, _ = os.Hostname()
+	if info, err := buildstore.ReadBuildInfo(buildDataFS); err == nil {
+		p.BuilderVersion = info.SrclibVersion
+		p.BuildHost = info.Host
+		p.Toolchains = info.Toolchains
+		p.Built = &info.Started
+		p.BuildDuration = info.Duration
+		p.BaseCommitID = info.BaseCommitID
+		for _, r := range info.Rules {
+			if r.Unit == "" {
+				continue
+			}
+			p.UnitBuildStats = append(p.UnitBuildStats, &store.UnitBuildStats{
+				UnitType: r.UnitType,
+				Unit:     r.Unit,
+				Op:       r.Op,
+				Wall:     r.Wall,
+				UserCPU:  r.UserCPU,
+				SysCPU:   r.SysCPU,
+				MaxRSS:   r.MaxRSS,
+			})
+		}
+	} else if !os.IsNotExist(err) {
+		logger.Warnf("reading build info: %s", err)
+	}
+
+	switch imp := stor.(type) {
+	case store.ProvenanceImporter:
+		return imp.ImportProvenance(opt.Repo, opt.CommitID, p)
+	default:
+		logger.Debugf("# Store (type %T) does not support recording provenance", stor)
+	}
+	return nil
+}
+
+// vendorAttribution determines which of the tree's source units are
+// vendored copies of dependencies, using the dependency resolution
+// data of all of the tree's units (even those that are not being
+// imported). If no units are in vendor dirs, the resolution data is
+// not read.
+func vendorAttribution(buildDataFS vfs.FileSystem, rules []makex.Rule, units []*unit.SourceUnit, repo, commitID string) (*store.VendorAttribution, error) {
+	var hasVendored bool
+	for _, u := range units {
+		if _, ok := store.VendoredPath(config.UnitDir(u)); ok {
+			hasVendored = true
+			break
+		}
+	}
+	if !hasVendored {
+		return &store.VendorAttribution{}, nil
+	}
+
+	var deps []*dep.ResolvedDep
+	for _, rule := range rules {
+		rule, ok := rule.(*dep.ResolveDepsRule)
+		if !ok {
+			continue
+		}
+		var ress []*dep.Resolution
+		if err := readJSONFileFS(buildDataFS, rule.Target(), &ress); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		rdeps, err := dep.ResolutionsToResolvedDeps(ress, rule.Unit, repo, commitID)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, rdeps...)
+	}
+	a := store.NewVendorAttribution(repo, units, deps)
+	logger.Debugf("# Attributed %d vendored source units to upstream repos", a.Len())
+	return a, nil
+}
+
+// sample imports sample data (when the --sample option is given).
+func (c *StoreImportCmd) sample(s interface{}) error {
+	dataString := []byte(`"abcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcd"`)
+	makeGraphData := func(numDefs, numRefs int) *graph.Output {
+		defs := make([]graph.Def, numDefs)
+		refs := make([]graph.Ref, numRefs)
+
+		data := graph.Output{
+			Defs: make([]*graph.Def, numDefs),
+			Refs: make([]*graph.Ref, numRefs),
+		}
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numDefs; i++ {
+				defs[i] = graph.Def{
+					DefKey:   graph.DefKey{Path: fmt.Sprintf("def-path-%d", i)},
+					Name:     fmt.Sprintf("def-name-%d", i),
+					Kind:     "mykind",
+					DefStart: uint32((i % 53) * 37),
+					DefEnd:   uint32((i%53)*37 + (i % 20)),
+					File:     fmt.Sprintf("dir%d/subdir%d/subsubdir%d/file-%d.foo", i%5, i%3, i%7, i%11),
+					Exported: i%5 == 0,
+					Local:    i%3 == 0,
+					Data:     dataString,
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numRefs; i++ {
+				refs[i] = graph.Ref{
+					DefPath: fmt.Sprintf("ref-path-%d", i%179),
+					Def:     i%5 == 0,
+					Start:   uint32((i % 51) * 39),
+					End:     uint32((i%51)*37 + (int(i) % 18)),
+					File:    fmt.Sprintf("dir%d/subdir%d/subsubdir%d/file-%d.foo", i%3, i%5, i%7, i%11),
+				}
+				if i%3 == 0 {
+					refs[i].DefUnit = fmt.Sprintf("def-unit-%d", i%5)
+					refs[i].DefUnitType = fmt.Sprintf("def-unit-type-%d", i%4)
+					if i%7 == 0 {
+						refs[i].DefRepo = fmt.Sprintf("def-repo-%d", i%7)
+					}
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range defs {
+				data.Defs[i] = &defs[i]
+			}
+			for i := range refs {
+				data.Refs[i] = &refs[i]
+			}
+		}()
+
+		wg.Wait()
+		return &data
+	}
+
+	start := time.Now()
+	logger.Infof("Making sample data (%d defs, %d refs)", c.SampleDefs, c.SampleRefs)
+	data := makeGraphData(c.SampleDefs, c.SampleRefs)
+	unit := &unit.SourceUnit{Type: "MyUnitType", Name: "MyUnit"}
+	files := map[string]struct{}{}
+	for _, def := range data.Defs {
+		files[def.File] = struct{}{}
+	}
+	for _, ref := range data.Refs {
+		files[ref.File] = struct{}{}
+	}
+	for f := range files {
+		unit.Files = append(unit.Files, f)
+	}
+	if d := time.Since(start); d > time.Millisecond*250 {
+		logger.Infof("Done making sample data (took %s).", d)
+	}
+
+	// Find some sample objects to query for down below.
+	ref2 := data.Refs[len(data.Refs)/2+1]
+	ref3 := data.Refs[len(data.Refs)/3+1]
+	def2 := data.Defs[len(data.Defs)/2+1]
+	def3 := data.Defs[len(data.Defs)/3+1]
+	// Find a ref that has the Unit/UnitType filled in.
+	var (
+		refDef4      graph.RefDefKey
+		foundRefDef4 bool
+	)
+	for _, ref := range data.Refs[len(data.Refs)/2:] {
+		if ref.DefUnit != "" && ref.DefUnitType != "" {
+			refDef4 = ref.RefDefKey()
+			foundRefDef4 = true
+			break
+		}
+	}
+
+	size, err := store.Codec.NewEncoder(ioutil.Discard).Encode(data)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Encoded data is %s", bytesString(size))
+
+	if c.CommitID == "" {
+		c.CommitID = strings.Repeat("f", 40)
+	}
+
+	logger.Infof("Importing %d defs and %d refs into the source unit %+v at commit %s", len(data.Defs), len(data.Refs), unit.ID2(), c.CommitID)
+	start = time.Now()
+	switch imp := s.(type) {
+	case store.RepoImporter:
+		c.Repo = ""
+		if err := imp.Import(c.CommitID, unit, *data); err != nil {
+			return err
+		}
+	case store.MultiRepoImporter:
+		if c.Repo == "" {
+			c.Repo = "example.com/my/repo"
+		}
+		logger.Infof(" - repo %s", c.Repo)
+		if err := imp.Import(c.Repo, c.CommitID, unit, *data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("store (type %T) does not implement importing", s)
+	}
+	logger.Infof("Import took %s (~%s per def/ref)", time.Since(start), time.Duration(int64(time.Since(start))/int64(len(data.Defs)+len(data.Refs))))
+
+	start = time.Now()
+	if !c.NoIndex {
+		if err := indexCommit(s, c.Repo, c.CommitID); err != nil {
+			return err
+		}
+	}
+	logger.Infof("Index took %s (~%s per def/ref)", time.Since(start), time.Duration(int64(time.Since(start))/int64(len(data.Defs)+len(data.Refs))))
+
+	if c.SampleImportOnly {
+		return nil
+	}
+
+	logger.Infof("Running some commands to list sample data...")
+
+	runCmd := func(args ...string) error {
+		start := time.Now()
+		var b bytes.Buffer
+		c := exec.Command(args[0], args[1:]...)
+		c.Stdout = &b
+		c.Stderr = &b
+		logger.Infof("%s", strings.Join(c.Args, " "))
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("command %v failed\n\noutput was:\n%s", c.Args, b.String())
+		}
+		if logger.enabled(levelDebug) {
+			logger.Debugf("%s", b.String())
+		} else {
+			logger.Infof("-> printed %d lines of output (run with `%s -v` to view)", bytes.Count(b.Bytes(), []byte{'\n'}), os.Args[0])
+		}
+		logger.Infof("-> took %s", time.Since(start))
+		return nil
+	}
+
+	prog := os.Args[0]
+	storeCmdArgs := func(subcmd, repo string, args ...string) []string {
+		x := []string{prog, "store", subcmd}
+		if repo != "" {
+			x = append(x, "--repo", repo)
+		}
+		x = append(x, "--commit", c.CommitID)
+		x = append(x, args...)
+		return x
+	}
+
+	if err := runCmd(storeCmdArgs("versions", c.Repo)...); err != nil {
+		return err
+	}
+	if err := runCmd(storeCmdArgs("units", c.Repo)...); err != nil {
+		return err
+	}
+	if err := runCmd(storeCmdArgs("units", c.Repo, "--file", def2.File)...); err != nil {
+		return err
+	}
+	if err := runCmd(storeCmdArgs("units", c.Repo, "--file", ref2.File)...); err != nil {
+		return err
+	}
+	if err := runCmd(storeCmdArgs("defs", c.Repo, "--file", def3.File)...); err != nil {
+		return err
+	}
+	if err := runCmd(storeCmdArgs("defs", c.Repo, "--query", def3.Name[:len(def3.Name)-2])...); err != nil {
+		return err
+	}
+	if err := runCmd(storeCmdArgs("refs", c.Repo, "--file", ref3.File)...); err != nil {
+		return err
+	}
+	if foundRefDef4 {
+		if err := runCmd(storeCmdArgs("refs", c.Repo, "--def-unit-type", refDef4.DefUnitType, "--def-unit", refDef4.DefUnit, "--def-path", refDef4.DefPath)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// countingWriter wraps an io.Writer, counting the number of bytes
+// written.
+type countingWriter struct {
+	io.Writer
+	n uint64
+}
+
+func (cr *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cr.Writer.Write(p)
+	cr.n += uint64(n)
+	return
+}
+
+// countingReader wraps an io.Reader, counting the number of bytes
+// read. The count is updated atomically, so it may be shared by
+// multiple countingReaders.
+type countingReader struct {
+	io.Reader
+	n *uint64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.Reader.Read(p)
+	atomic.AddUint64(cr.n, uint64(n))
+	return
+}
+
+type storeIndexCriteria struct {
+	Repo     string `long:"repo" description:"only indexes for this repo"`
+	CommitID string `long:"commit" description:"only indexes for this commit ID"`
+	UnitType string `long:"unit-type" description:"only indexes for this source unit type"`
+	Unit     string `long:"unit" description:"only indexes for this source unit name"`
+	Name     string `long:"name" description:"only indexes whose name contains this substring"`
+	Type     string `long:"type" description:"only indexes whose Go type contains this substring"`
+
+	NoSourceUnit bool `long:"no-unit" description:"skip indexes for specific source units"`
+
+	Stale    bool `long:"stale" description:"only stale indexes"`
+	NotStale bool `long:"not-stale" description:"only non-stale indexes"`
+
+	ReposLimit  int `long:"repos-limit" description:"only indexes from this many repos (0=all)"`
+	ReposOffset int `long:"repos-offset" description:"only indexes after skipping this many repos"`
+}
+
+func (c storeIndexCriteria) IndexCriteria() store.IndexCriteria {
+	crit := store.IndexCriteria{
+		Repo:     c.Repo,
+		CommitID: c.CommitID,
+		Name:     c.Name,
+		Type:     c.Type,
+	}
+	if c.Stale && c.NotStale {
+		logger.Fatalf("must specify exactly one of --stale and --not-stale")
+	}
+	if c.Stale {
+		t := true
+		crit.Stale = &t
+	}
+	if c.NotStale {
+		f := false
+		crit.Stale = &f
+	}
+	if c.NoSourceUnit {
+		crit.Unit = store.NoSourceUnit
+	}
+	if c.UnitType != "" || c.Unit != "" {
+		crit.Unit = &unit.ID2{Type: c.UnitType, Name: c.Unit}
+		if crit.Unit.Type == "" || crit.Unit.Name == "" {
+			logger.Fatalf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+		}
+	}
+	crit.ReposLimit = c.ReposLimit
+	crit.ReposOffset = c.ReposOffset
+	return crit
+}
+
+// indexLabel describes the index x in messages.
+func indexLabel(x store.IndexStatus) string {
+	label := x.Name
+	if x.Unit != nil {
+		label += fmt.Sprintf(" of source unit %s %s", x.Unit.Name, x.Unit.Type)
+	}
+	if x.CommitID != "" {
+		label += " at commit " + x.CommitID
+	}
+	if x.Repo != "" {
+		label += " in repo " + x.Repo
+	}
+	return label
+}
+
+// doStoreIndexesCmd is invoked by both StoreIndexesCmd.Execute and
+// StoreBuildIndexesCmd.Execute.
+func doStoreIndexesCmd(crit store.IndexCriteria, opt storeIndexOptions, f func(context.Context, interface{}, store.IndexCriteria, chan<- store.IndexStatus) ([]store.IndexStatus, error)) error {
+	if opt.FailFast && opt.KeepGoing {
+		return newCmdError(ExitUsage, errors.New("--fail-fast and --keep-going are mutually exclusive"))
+	}
+	if opt.Parallel != 1 {
+		logger.Infof("NOTE: Index parallelism is %d. Output will printed as it is available, not necessarily ordered and grouped by repo, source unit, etc.", opt.Parallel)
+	}
+	store.MaxIndexParallel = opt.Parallel
+
+	printIndex := func(x store.IndexStatus) error {
+		if !opt.Print {
+			return nil
+		}
+		return x.Fprint(os.Stderr)
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	// With --fail-fast, the first index error cancels ctx, which
+	// stops listing (or building) the remaining indexes.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var firstErr error
+	failed := func(x store.IndexStatus) {
+		if opt.FailFast && firstErr == nil {
+			msg := x.Error
+			if msg == "" {
+				msg = x.BuildError
+			}
+			firstErr = fmt.Errorf("index %s failed (stopping because of --fail-fast): %s", indexLabel(x), msg)
+			cancel()
+		}
+	}
+	// canceled returns whether x was skipped because ctx was
+	// canceled.
+	canceled := func(x store.IndexStatus) bool {
+		err := ctx.Err()
+		return err != nil && (x.Error == err.Error() || x.BuildError == err.Error())
+	}
+
+	hasError := false
+	done := make(chan struct{})
+	indexChan := make(chan store.IndexStatus)
+	switch opt.Output {
+	case "json":
+		go func() {
+			for x := range indexChan {
+				if canceled(x) {
+					continue
+				}
+				if x.Error != "" || x.BuildError != "" {
+					failed(x)
+				}
+				PrintJSON(x, "")
+				if err := printIndex(x); err != nil {
+					log.Fatal(err)
+				}
+			}
+			done <- struct{}{}
+		}()
+	case "text":
+		_, isMultiRepo := s.(store.MultiRepoStore)
+		var repoTab string
+		if isMultiRepo {
+			repoTab = "\t"
+		}
+
+		go func() {
+			var lastRepo, lastCommitID string
+			var lastUnit *unit.ID2
+			for x := range indexChan {
+				if canceled(x) {
+					continue
+				}
+				if isMultiRepo {
+					if x.Repo != lastRepo {
+						if lastRepo != "" {
+							fmt.Println()
+						}
+						fmt.Println(x.Repo)
+					}
+				}
+				if x.CommitID != lastCommitID {
+					fmt.Print(repoTab, x.CommitID, "\n")
+				}
+				if x.Unit != lastUnit && x.Unit != nil {
+					if x.Repo == lastRepo && x.CommitID == lastCommitID {
+						fmt.Println()
+					}
+					fmt.Print(repoTab, "\t", x.Unit.Name, " ", x.Unit.Type, "\n")
+				}
+
+				if x.Unit != nil {
+					fmt.Print("\t")
+				}
+
+				fmt.Print(repoTab, "\t")
+				fmt.Printf("%s (%s, %s) ", x.Name, x.Type, x.Priority)
+				if x.Stale {
+					fmt.Print("STALE ")
+				}
+				if x.Size != 0 {
+					fmt.Print(bytesString(uint64(x.Size)), " ")
+				}
+				if x.Error != "" {
+					fmt.Printf("(ERROR: %s) ", x.Error)
+					hasError = true
+				}
+				if x.BuildError != "" {
+					fmt.Printf("(BUILD ERROR: %s) ", x.BuildError)
+					hasError = true
+				}
+				if x.Error != "" || x.BuildError != "" {
+					failed(x)
+				}
+				if x.BuildDuration != 0 {
+					fmt.Printf("- build took %s ", x.BuildDuration)
+				}
+				fmt.Println()
+
+				if err := printIndex(x); err != nil {
+					log.Fatal(err)
+				}
+
+				lastRepo = x.Repo
+				lastCommitID = x.CommitID
+				lastUnit = x.Unit
+			}
+			done <- struct{}{}
+		}()
+	default:
+		return fmt.Errorf("unexpected --output value: %q", opt.Output)
+	}
+
+	_, err = f(ctx, s, crit, indexChan)
+	close(indexChan)
+	<-done
+	if firstErr != nil {
+		return firstErr
+	}
+	if err != nil {
+		return err
+	}
+	if hasError {
+		return errors.New("\nindex listing or index building errors occurred (see above)")
+	}
+	return nil
+}
+
+type storeIndexOptions struct {
+	Output   string `short:"o" long:"output" description:"output format (text|json)" default:"text"`
+	Parallel int    `short:"p" long:"parallel" description:"parallelism (may produce out-of-order output)" default:"1"`
+
+	Print bool `long:"print" description:"(debug) print representation of index"`
+
+	FailFast  bool `long:"fail-fast" description:"stop at the first index that can't be listed, read, or built (transient errors, such as S3 timeouts, are retried first)"`
+	KeepGoing bool `long:"keep-going" description:"process all indexes even if some fail, and report the failures at the end (the default)"`
+}
+
+type StoreIndexesCmd struct {
+	storeIndexCriteria
+	storeIndexOptions
+}
+
+var storeIndexesCmd StoreIndexesCmd
+
+func (c *StoreIndexesCmd) Execute(args []string) error {
+	return doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, store.IndexesContext)
+}
+
+type StoreIndexCmd struct {
+	storeIndexCriteria
+	storeIndexOptions
+
+	GlobalRefs bool `long:"global-refs" description:"also update the global ref index with the cross-repo refs of each selected repo's commits (needed for commits imported before the index existed)"`
+
+	Order string `long:"order" description:"order in which to build indexes (listed|smallest-first|by-priority)" default:"listed"`
+}
+
+var storeIndexCmd StoreIndexCmd
+
+func (c *StoreIndexCmd) Execute(args []string) error {
+	order, err := store.ParseIndexOrder(c.Order)
+	if err != nil {
+		return newCmdError(ExitUsage, err)
+	}
+	store.IndexBuildOrder = order
+
+	if err := doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, store.BuildIndexesContext); err != nil {
+		return err
+	}
+	if c.GlobalRefs {
+		return c.indexGlobalRefs()
+	}
+	return nil
+}
+
+// indexGlobalRefs updates the global ref index with the refs in the
+// commits selected by c's --repo and --commit criteria.
+func (c *StoreIndexCmd) indexGlobalRefs() error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	gi, ok := s.(store.GlobalRefIndexer)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not have a global ref index", s)
+	}
+
+	var vfs []store.VersionFilter
+	if c.Repo != "" {
+		vfs = append(vfs, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		vfs = append(vfs, store.ByCommitIDs(c.CommitID))
+	}
+	versions, err := s.(store.MultiRepoStore).Versions(vfs...)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lock(store.StoreLockName, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Warnf("releasing store lock: %s", err)
+		}
+	}()
+	for _, v := range versions {
+		logger.Debugf("# Updating global ref index for %s@%s", v.Repo, v.CommitID)
+		if err := gi.IndexGlobalRefs(v.Repo, v.CommitID); err != nil {
+			return fmt.Errorf("updating global ref index for %s@%s: %s", v.Repo, v.CommitID, err)
+		}
+	}
+	logger.Infof("# Updated global ref index for %d commits.", len(versions))
+	return nil
+}
+
+type StoreWarmCmd struct {
+	storeIndexCriteria
+	storeIndexOptions
+
+	Daemon   bool          `long:"daemon" description:"keep running, re-reading indexes periodically"`
+	Interval time.Duration `long:"interval" description:"with --daemon, how often to re-read indexes" default:"5m"`
+}
+
+var storeWarmCmd StoreWarmCmd
+
+func (c *StoreWarmCmd) Execute(args []string) error {
+	store.CacheOpenStores = true
+	for {
+		start := time.Now()
+		if err := doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, store.WarmIndexesContext); err != nil {
+			return err
+		}
+		if !c.Daemon {
+			return nil
+		}
+		logger.Debugf("# Warmed indexes in %s; re-reading in %s.", time.Since(start), c.Interval)
+		time.Sleep(c.Interval)
+	}
+}
+
+type StoreMigrateCmd struct {
+	DryRun bool `short:"n" long:"dry-run" description:"print the migrations that would be performed but don't modify the store"`
+}
+
+var storeMigrateCmd StoreMigrateCmd
+
+func (c *StoreMigrateCmd) Execute(args []string) error {
+	storeCmd.skipFormatCheck = true
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	from, to, err := store.Migrate(s, store.MigrateOpt{DryRun: c.DryRun, Logf: logger.Infof})
+	if err != nil {
+		return err
+	}
+	if from != to {
+		logger.Infof("# Migrated store from format version %d to %d.", from, to)
+	}
+	return nil
+}
+
+type StoreConvertCodecCmd struct {
+	To string `long:"to" required:"yes" description:"the codec to convert to" value-name:"json|protobuf|protobuf-full|msgpack"`
+}
+
+var storeConvertCodecCmd StoreConvertCodecCmd
+
+func (c *StoreConvertCodecCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	from, err := store.StoreCodec(s)
+	if err != nil {
+		return err
+	}
+	if from == c.To {
+		logger.Infof("# Store is already encoded with codec %s.", c.To)
+		return nil
+	}
+	if err := store.ConvertCodec(s, c.To, logger.Infof); err != nil {
+		return err
+	}
+	logger.Infof("# Converted store from codec %s to %s.", from, c.To)
+	return nil
+}
+
+type StoreReposCmd struct {
+	IDContains string `short:"i" long:"id-contains" description:"filter to repos whose ID contains this substring"`
+
+	CommitCount       int    `long:"commit-count" description:"filter to repos with at least this many imported commits"`
+	LastImportedSince string `long:"last-imported-since" description:"filter to repos whose latest commit was imported at or after this time (RFC 3339 timestamp, or a duration such as 24h meaning that long ago)"`
+
+	Output string `short:"o" long:"output" description:"output format (json includes per-repo stats: latest commit, and unit/def/ref counts)" default:"text" value-name:"text|json"`
+}
+
+func (c *StoreReposCmd) filters() []store.RepoFilter {
+	var fs []store.RepoFilter
+	if c.IDContains != "" {
+		fs = append(fs, store.RepoFilterFunc(func(repo string) bool { return strings.Contains(repo, c.IDContains) }))
+	}
+	return fs
+}
+
+var storeReposCmd StoreReposCmd
+
+func (c *StoreReposCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	mrs, ok := s.(store.MultiRepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing repositories", s)
+	}
+
+	var since time.Time
+	if c.LastImportedSince != "" {
+		since, err = parseTimeOrDurationAgo(c.LastImportedSince)
+		if err != nil {
+			return fmt.Errorf("invalid --last-imported-since value: %s", err)
+		}
+	}
+	if c.Output != "text" && c.Output != "json" {
+		return fmt.Errorf("unexpected --output value: %q", c.Output)
+	}
+
+	repos, err := mrs.Repos(c.filters()...)
+	if err != nil {
+		return err
+	}
+
+	// Only compute stats if they are needed (to filter or to
+	// print), since it's much slower than just listing repos.
+	needStats := c.CommitCount != 0 || !since.IsZero() || c.Output == "json"
+	var allStats []*store.RepoStats
+	for _, repo := range repos {
+		if !needStats {
+			fmt.Println(repo)
+			continue
+		}
+
+		stats, err := store.GetRepoStats(mrs, repo, c.Output == "json")
+		if err != nil {
+			return err
+		}
+		if stats.Commits < c.CommitCount {
+			continue
+		}
+		if !since.IsZero() && (stats.LastImported == nil || stats.LastImported.Before(since)) {
+			continue
+		}
+		if c.Output == "json" {
+			allStats = append(allStats, stats)
+		} else {
+			fmt.Println(repo)
+		}
+	}
+	if c.Output == "json" {
+		if allStats == nil {
+			allStats = []*store.RepoStats{}
+		}
+		PrintJSON(allStats, "")
+	}
+	return nil
+}
+
+// parseTimeOrDurationAgo parses s as either an RFC 3339 timestamp or
+// a duration (which is interpreted as that long before now).
+func parseTimeOrDurationAgo(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+type StoreVersionsCmd struct {
+	Repo           string `long:"repo"`
+	CommitIDPrefix string `long:"commit" description:"commit ID prefix"`
+
+	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
+
+	Verbose bool `long:"verbose" description:"print versions as JSON, with the provenance of each version's data"`
+}
+
+func (c *StoreVersionsCmd) filters() []store.VersionFilter {
+	var fs []store.VersionFilter
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.CommitIDPrefix != "" {
+		fs = append(fs, store.VersionFilterFunc(func(version *store.Version) bool {
+			return strings.HasPrefix(version.CommitID, c.CommitIDPrefix)
+		}))
+	}
+	if c.RepoCommitIDs != "" {
+		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
+	}
+	return fs
+}
+
+var storeVersionsCmd StoreVersionsCmd
+
+func (c *StoreVersionsCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing versions", s)
+	}
+
+	versions, err := rs.Versions(c.filters()...)
+	if err != nil {
+		return err
+	}
+	if c.Verbose {
+		ps, _ := s.(store.ProvenanceStore)
+		type versionWithProvenance struct {
+			*store.Version
+			Provenance *store.Provenance `json:",omitempty"`
+		}
+		vs := make([]versionWithProvenance, len(versions))
+		for i, version := range versions {
+			vs[i].Version = version
+			if ps == nil {
+				continue
+			}
+			p, err := ps.Provenance(version.Repo, version.CommitID)
+			if err != nil && !store.IsNotExist(err) {
+				return err
+			}
+			if p != nil {
+				p.Repo, p.CommitID = "", ""
+			}
+			vs[i].Provenance = p
+		}
+		PrintJSON(vs, "  ")
+		return nil
+	}
+	for _, version := range versions {
+		if version.Repo != "" {
+			fmt.Print(version.Repo, "\t")
+		}
+		fmt.Println(version.CommitID)
+	}
+	return nil
+}
+
+type StoreUnitsCmd struct {
+	Type     string `long:"type" `
+	Name     string `long:"name"`
+	CommitID string `long:"commit"`
+	Repo     string `long:"repo"`
+
+	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
+
+	File string `long:"file" description:"filter by units whose Files list contains this file"`
+
+	Language string `long:"language" description:"comma-separated list of languages (e.g., go,python); only list source units in these languages"`
+
+	FilterExpr string `long:"filter-expr" description:"only list source units for which this expression is true (e.g., 'unit.Language == \"Go\" && size(unit.Files) > 10'; see 'src store defs --help')" value-name:"EXPR"`
+
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
+
+	Explain bool `long:"explain" description:"print the query plan (indexes consulted, filters pushed down to indexes or applied by scanning, source units queried, and the time each step took) to stderr; bypasses the query cache"`
+
+	WithBuildStats bool `long:"with-build-stats" description:"include the resource usage (wall time, CPU time, and peak RSS) of each source unit's toolchain rules in the build that produced the data, if it was recorded (see 'src plan stats')"`
+}
+
+func (c *StoreUnitsCmd) filters() []store.UnitFilter {
+	var fs []store.UnitFilter
+	if c.Type != "" && c.Name != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.Type, Name: c.Name}))
+	}
+	if (c.Type != "" && c.Name == "") || (c.Type == "" && c.Name != "") {
+		logger.Fatalf("must specify either both or neither of --type and --name (to filter by source unit)")
+	}
+	if c.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(c.CommitID))
+	}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.RepoCommitIDs != "" {
+		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
+	}
+	if c.File != "" {
+		fs = append(fs, store.ByFiles(path.Clean(c.File)))
+	}
+	if langs := languagesOpt(c.Language); len(langs) > 0 {
+		fs = append(fs, store.ByLanguages(langs...))
+	}
+	if c.FilterExpr != "" {
+		fs = append(fs, filterExprOpt("unit", c.FilterExpr))
+	}
+	if c.Unordered {
+		fs = append(fs, store.Unordered())
+	}
+	return fs
+}
+
+var storeUnitsCmd StoreUnitsCmd
+
+// languagesOpt parses the comma-separated list of languages given to a
+// --language flag.
+func languagesOpt(s string) []string {
+	var langs []string
+	for _, lang := range strings.Split(s, ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// filterExprOpt parses the expression given to a --filter-expr flag
+// (see store.ParseFilterExpr), which selects records of the given kind.
+func filterExprOpt(kind, src string) *store.FilterExpr {
+	e, err := store.ParseFilterExpr(kind, src)
+	if err != nil {
+		logger.Fatalf("--filter-expr: %s", err)
+	}
+	return e
+}
+
+func (c *StoreUnitsCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	if s, err = storeCmd.overlay(s); err != nil {
+		return err
+	}
+
+	ts, ok := s.(store.TreeStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing source units", s)
+	}
+
+	var units []*unit.SourceUnit
+	err = explainQuery(c.Explain, func() (err error) {
+		units, err = ts.Units(c.filters()...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if c.WithBuildStats {
+		unitsWithStats, err := unitBuildStats(s, units)
+		if err != nil {
+			return err
+		}
+		PrintJSON(unitsWithStats, "  ")
+		return nil
+	}
+	PrintJSON(units, "  ")
+	return nil
+}
+
+type unitWithBuildStats struct {
+	*unit.SourceUnit
+	BuildStats []*store.UnitBuildStats `json:",omitempty"`
+}
+
+// unitBuildStats annotates units with the resource usage of their
+// toolchain rules, from the provenance of their commits' data.
+func unitBuildStats(s interface{}, units []*unit.SourceUnit) ([]unitWithBuildStats, error) {
+	ps, ok := s.(store.ProvenanceStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement provenance", s)
+	}
+	provenances := map[string]*store.Provenance{} // repo@commit -> provenance
+	uws := make([]unitWithBuildStats, len(units))
+	for i, u := range units {
+		uws[i].SourceUnit = u
+		key := u.Repo + "@" + u.CommitID
+		p, seen := provenances[key]
+		if !seen {
+			var err error
+			p, err = ps.Provenance(u.Repo, u.CommitID)
+			if err != nil && !store.IsNotExist(err) {
+				return nil, err
+			}
+			provenances[key] = p
+		}
+		if p == nil {
+			continue
+		}
+		for _, stats := range p.UnitBuildStats {
+			if stats.UnitType == u.Type && stats.Unit == u.Name {
+				uws[i].BuildStats = append(uws[i].BuildStats, stats)
+			}
+		}
+	}
+	return uws, nil
+}
+
+// explainQuery calls query. If explain is true, it prints the plan
+// that the store used to execute the query (see store.Explain) to
+// stderr.
+func explainQuery(explain bool, query func() error) error {
+	if !explain {
+		return query()
+	}
+	plan, err := store.Explain(query)
+	plan.WriteTo(os.Stderr)
+	return err
+}
+
+type StoreDefsCmd struct {
+	Repo     string `long:"repo"`
+	Path     string `long:"path"`
+	UnitType string `long:"unit-type" `
+	Unit     string `long:"unit"`
+	File     string `long:"file"`
+	CommitID string `long:"commit"`
+
+	Language string `long:"language" description:"comma-separated list of languages (e.g., go,python); only list items in source units in these languages"`
+
+	Commits     string `long:"commits" description:"comma-separated list of commit IDs (results include each item's CommitID)"`
+	CommitRange string `long:"commit-range" description:"range of commits A..B (inclusive), resolved in the current repository"`
+
+	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
+
+	Query string `long:"query"`
+
+	NamePrefix string `long:"name-prefix" description:"only list defs whose names begin with this prefix (case-sensitive)"`
+	NameRange  string `long:"name-range" description:"only list defs whose names are in the lexicographic range START..END (END is exclusive; either may be omitted)"`
+	IgnoreCase bool   `long:"ignore-case" description:"match --name-prefix and --name-range case-insensitively"`
+
+	ExcludeGenerated bool `long:"exclude-generated" description:"omit defs in generated code (see src store import --no-tag-generated)"`
+
+	DataFilter []string `long:"data-filter" description:"only list defs whose Data has this value at this path (e.g., Visibility=public, or Annotations=Deprecated to match an element of an array); the path is a dot-separated list of fields and array indexes; may be repeated (fast for paths in the store config's IndexDefData)" value-name:"PATH=VALUE"`
+
+	FilterExpr string `long:"filter-expr" description:"only list defs for which this CEL-like expression is true, e.g., 'def.Kind == \"func\" && def.Exported && size(def.Name) > 20'; the def's fields are those of its JSON (so def.Data.X is a toolchain-specific field), and the functions are size, has, contains, startsWith, endsWith, and matches" value-name:"EXPR"`
+
+	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
+	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
+
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
+
+	SampleRate float64 `long:"sample-rate" description:"print a uniform random sample of the matching defs, each selected with this probability (e.g., 0.01), and an estimate of the total number of matching defs; reads only the sampled defs if an index covers the query" value-name:"RATE"`
+	SampleSeed int64   `long:"sample-seed" description:"seed for --sample-rate (default: random)"`
+
+	Explain bool `long:"explain" description:"print the query plan (indexes consulted, filters pushed down to indexes or applied by scanning, source units queried, and the time each step took) to stderr; bypasses the query cache"`
+
+	Input string `long:"input" description:"run the query once for each JSON object read from FILE ('-' for stdin), which holds a JSON array or a stream of JSON values such as JSON lines (e.g., the output of another 'src store defs' or 'refs' command), and print all of the results: an object with a Path (a def or def key) selects that def, one with a DefPath (a ref) selects the def it refers to, and other objects' Repo, CommitID, UnitType, Unit, and File fields are used as filters; objects' CommitIDs are ignored if --commit, --commits, --commit-range, or --repo-commits is given, and --limit applies to each object's query" value-name:"FILE"`
+
+	// If Filter is non-nil, it is applied along with the above
+	// filters.
+	Filter store.DefFilter
+}
+
+func (c *StoreDefsCmd) filters() []store.DefFilter {
+	var fs []store.DefFilter
+	if c.UnitType != "" && c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		logger.Fatalf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+	}
+	if commitIDs := commitIDsOpt(c.CommitID, c.Commits, c.CommitRange); len(commitIDs) > 0 {
+		fs = append(fs, store.ByCommitIDs(commitIDs...))
+	}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.RepoCommitIDs != "" {
+		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
+	}
+	if c.Path != "" {
+		fs = append(fs, store.ByDefPath(c.Path))
+	}
+	if c.File != "" {
+		fs = append(fs, store.ByFiles(path.Clean(c.File)))
+	}
+	if langs := languagesOpt(c.Language); len(langs) > 0 {
+		fs = append(fs, store.ByLanguages(langs...))
+	}
+	if c.Query != "" {
+		fs = append(fs, store.ByDefQuery(c.Query))
+	}
+	if c.NamePrefix != "" {
+		if c.IgnoreCase {
+			fs = append(fs, store.ByDefNamePrefixIgnoreCase(c.NamePrefix))
+		} else {
+			fs = append(fs, store.ByDefNamePrefix(c.NamePrefix))
+		}
+	}
+	if c.NameRange != "" {
+		i := strings.Index(c.NameRange, "..")
+		if i == -1 || c.NameRange == ".." {
+			logger.Fatalf("--name-range must be of the form START..END, START.., or ..END (got %q)", c.NameRange)
+		}
+		if c.IgnoreCase {
+			fs = append(fs, store.ByDefNameRangeIgnoreCase(c.NameRange[:i], c.NameRange[i+2:]))
+		} else {
+			fs = append(fs, store.ByDefNameRange(c.NameRange[:i], c.NameRange[i+2:]))
+		}
+	}
+	if c.ExcludeGenerated {
+		fs = append(fs, store.ExcludeGenerated())
+	}
+	for _, df := range c.DataFilter {
+		i := strings.Index(df, "=")
+		if i <= 0 {
+			logger.Fatalf("--data-filter must be of the form PATH=VALUE (got %q)", df)
+		}
+		fs = append(fs, store.ByDefData(df[:i], df[i+1:]))
+	}
+	if c.FilterExpr != "" {
+		fs = append(fs, filterExprOpt("def", c.FilterExpr))
+	}
+	if c.Filter != nil {
+		fs = append(fs, c.Filter)
+	}
+	if c.Unordered {
+		fs = append(fs, store.Unordered())
+	}
+	if f := sampleFilter(c.SampleRate, c.SampleSeed); f != nil {
+		fs = append(fs, f)
+	}
+	if c.Limit != 0 || c.Offset != 0 {
+		fs = append(fs, store.Limit(c.Limit, c.Offset))
+	}
+	if f := storeCmd.queryLimits(); f != nil {
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+var storeDefsCmd StoreDefsCmd
+
+func (c *StoreDefsCmd) Execute(args []string) error {
+	if err := checkSampleRate(c.SampleRate); err != nil {
+		return err
+	}
+
+	if storeCmd.MaxMemory > 0 && !c.streamable() {
+		logger.Warnf("--max-memory does not limit defs queries with --limit, --offset, --sample-rate, --query, --filter, --explain, --input, or --overlay (their results are held in memory).")
+	}
+	if storeCmd.MaxMemory > 0 && c.streamable() {
+		s, err := OpenStore()
+		if err != nil {
+			return err
+		}
+		us, ok := s.(store.UnitStore)
+		if !ok {
+			return fmt.Errorf("store (type %T) does not implement listing defs", s)
+		}
+		if us, err = storeCmd.logSlowQueries(us); err != nil {
+			return err
+		}
+		return checkQueryLimit(streamDefs(us, c.filters()))
+	}
+
+	var defs []*graph.Def
+	err := explainQuery(c.Explain, func() (err error) {
+		defs, err = c.Get()
+		return err
+	})
+	if err != nil {
+		return checkQueryLimit(err)
+	}
+	if c.SampleRate != 0 {
+		PrintJSON(newSampleResults(defs, len(defs), c.SampleRate), "  ")
+		return nil
+	}
+	PrintJSON(defs, "  ")
+	return nil
+}
+
+// streamable returns whether the query's results can be streamed one
+// unit at a time (see streamDefs), which is not possible if they are
+// limited, sampled, sorted by name, merged over a base commit's, or
+// read from multiple queries (with --input).
+func (c *StoreDefsCmd) streamable() bool {
+	return c.Limit == 0 && c.Offset == 0 && c.SampleRate == 0 && c.Query == "" && c.Filter == nil && !c.Explain && !storeCmd.Overlay && c.Input == ""
+}
+
+func (c *StoreDefsCmd) Get() ([]*graph.Def, error) {
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	if s, err = storeCmd.overlay(s); err != nil {
+		return nil, err
+	}
+	if c.Input == "" {
+		return c.get(s)
+	}
+
+	inputs, err := readQueryInputs(c.Input)
+	if err != nil {
+		return nil, err
+	}
+	var defs []*graph.Def
+	for _, in := range inputs {
+		inDefs, err := c.withInput(in).get(s)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, inDefs...)
+	}
+	return defs, nil
+}
+
+// get lists the defs in s that match the query.
+func (c *StoreDefsCmd) get(s interface{}) ([]*graph.Def, error) {
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing defs", s)
+	}
+	us, err := storeCmd.logSlowQueries(us)
+	if err != nil {
+		return nil, err
+	}
+
+	qc, err := storeCmd.queryCache()
+	if err != nil {
+		return nil, err
+	}
+	if qc != nil && !c.Explain {
+		return qc.Defs(us, c.filters()...)
+	}
+
+	defs, err := us.Defs(c.filters()...)
+	if err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+type StoreDocsCmd struct {
+	Repo     string `long:"repo"`
+	UnitType string `long:"unit-type" `
+	Unit     string `long:"unit"`
+	File     string `long:"file"`
+	CommitID string `long:"commit"`
+
+	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
+
+	DefPath string `long:"def-path" description:"only list docs for the def with this path"`
+	Format  string `long:"format" description:"only list docs in this format (e.g., text/html or text/plain)"`
+
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
+}
+
+var storeDocsCmd StoreDocsCmd
+
+func (c *StoreDocsCmd) Execute(args []string) error {
+	docs, err := c.Get()
+	if err != nil {
+		return err
+	}
+	PrintJSON(docs, "  ")
+	return nil
+}
+
+// Get returns the docs of the defs that match the filters. Docs are
+// stored on defs (see the import command), so it queries defs and
+// returns each of their docs as a separate graph.Doc.
+func (c *StoreDocsCmd) Get() ([]*graph.Doc, error) {
+	defsCmd := StoreDefsCmd{
+		Repo:          c.Repo,
+		Path:          c.DefPath,
+		UnitType:      c.UnitType,
+		Unit:          c.Unit,
+		File:          c.File,
+		CommitID:      c.CommitID,
+		RepoCommitIDs: c.RepoCommitIDs,
+		Unordered:     c.Unordered,
+		Filter: store.DefFilterFunc(func(def *graph.Def) bool {
+			return len(def.Docs) > 0
+		}),
+	}
+	defs, err := defsCmd.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []*graph.Doc
+	for _, def := range defs {
+		for _, doc := range def.Docs {
+			if c.Format != "" && doc.Format != c.Format {
+				continue
+			}
+			docs = append(docs, &graph.Doc{DefKey: def.DefKey, Format: doc.Format, Data: doc.Data})
+		}
+	}
+	return docs, nil
+}
+
+type StoreRefsCmd struct {
+	Repo     string `long:"repo"`
+	UnitType string `long:"unit-type" `
+	Unit     string `long:"unit"`
+	File     string `long:"file"`
+	CommitID string `long:"commit"`
+
+	Language string `long:"language" description:"comma-separated list of languages (e.g., go,python); only list items in source units in these languages"`
+
+	Commits     string `long:"commits" description:"comma-separated list of commit IDs (results include each item's CommitID)"`
+	CommitRange string `long:"commit-range" description:"range of commits A..B (inclusive), resolved in the current repository"`
+
+	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
+
+	Start uint32 `long:"start" description:"only list refs that start at or after this byte offset (fast with --file)"`
+	End   uint32 `long:"end" description:"only list refs that end at or before this byte offset (fast with --file)"`
+
+	DefRepo     string `long:"def-repo"`
+	DefUnitType string `long:"def-unit-type" `
+	DefUnit     string `long:"def-unit"`
+	DefPath     string `long:"def-path"`
+
+	Broken         bool `long:"broken" description:"only show refs that point to nonexistent defs (fast if the broken refs index has been built)"`
+	Coverage       bool `long:"coverage" description:"print a coverage summary (resolved refs, broken refs, total refs)"`
+	CoverageByUnit bool `long:"coverage-by-unit" description:"print the number of resolved, broken, and cross-repo refs in each source unit (as JSON) instead of refs"`
+	Locations      bool `long:"locations" description:"print the files (in all repos and commits) that contain refs to the def given by --def-repo, --def-unit-type, --def-unit, and --def-path, with the number of refs in each, instead of refs (fast if the global ref index has been built)"`
+
+	ExcludeGenerated bool `long:"exclude-generated" description:"omit refs in generated code (see src store import --no-tag-generated)"`
+
+	FilterExpr string `long:"filter-expr" description:"only list refs for which this expression is true (e.g., 'ref.DefRepo != ref.Repo && !ref.Def'; see 'src store defs --help')" value-name:"EXPR"`
+
+	Format string `long:"format" description:"output format ('json' or 'none')" default:"json"`
+
+	GroupBy   string `long:"group-by" description:"print the refs grouped by the file that contains them ('file'), as an array of objects with Repo, CommitID, File, and Refs fields" value-name:"FIELD"`
+	Positions string `long:"positions" description:"how to print refs' positions: 'offset' (only the byte offsets Start and End) or 'line-col' (also StartLine, StartColumn, EndLine, and EndColumn, which are 1-based, with columns in bytes, and computed from the files in the local repository at the refs' commit; omitted for refs in other repositories or in files that can't be read)" default:"offset"`
+
+	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
+	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
+
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
+
+	SampleRate float64 `long:"sample-rate" description:"print a uniform random sample of the matching refs, each selected with this probability (e.g., 0.01), and an estimate of the total number of matching refs; reads only the sampled refs if an index covers the query" value-name:"RATE"`
+	SampleSeed int64   `long:"sample-seed" description:"seed for --sample-rate (default: random)"`
+
+	Explain bool `long:"explain" description:"print the query plan (indexes consulted, filters pushed down to indexes or applied by scanning, source units queried, and the time each step took) to stderr; bypasses the query cache"`
+
+	Input string `long:"input" description:"run the query once for each JSON object read from FILE ('-' for stdin; see 'src store defs --help'), e.g., to list the refs to each def printed by 'src store defs', and print all of the results: an object with a Path (a def or def key) or a DefPath (a ref) selects the refs to that def (at the def's commit, unless --commit, --commits, --commit-range, or --repo-commits is given), and other objects' Repo, CommitID, UnitType, Unit, and File fields are used as filters" value-name:"FILE"`
+}
+
+func (c *StoreRefsCmd) filters() []store.RefFilter {
+	var fs []store.RefFilter
+	if c.UnitType != "" && c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		logger.Fatalf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+	}
+	if commitIDs := commitIDsOpt(c.CommitID, c.Commits, c.CommitRange); len(commitIDs) > 0 {
+		fs = append(fs, store.ByCommitIDs(commitIDs...))
+	}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.RepoCommitIDs != "" {
+		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
+	}
+	if c.File != "" {
+		fs = append(fs, store.ByFiles(path.Clean(c.File)))
+	}
+	if langs := languagesOpt(c.Language); len(langs) > 0 {
+		fs = append(fs, store.ByLanguages(langs...))
+	}
+	if c.Start != 0 || c.End != 0 {
+		fs = append(fs, store.ByRefRange(c.Start, c.End))
+	}
+	if c.ExcludeGenerated {
+		fs = append(fs, store.ExcludeGenerated())
+	}
+	if c.FilterExpr != "" {
+		fs = append(fs, filterExprOpt("ref", c.FilterExpr))
+	}
+	if c.DefPath != "" {
+		fs = append(fs, store.ByRefDef(graph.RefDefKey{
+			DefRepo:     c.DefRepo,
+			DefUnitType: c.DefUnitType,
+			DefUnit:     c.DefUnit,
+			DefPath:     c.DefPath,
+		}))
+	} else {
+		// Slower filters since they don't use an index.
+		if c.DefRepo != "" {
+			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
+				return ref.DefRepo == c.DefRepo
+			})))
+		}
+		if c.DefUnitType != "" {
+			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
+				return ref.DefUnitType == c.DefUnitType
+			})))
+		}
+		if c.DefUnit != "" {
+			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
+				return ref.DefUnit == c.DefUnit
+			})))
+		}
+	}
+	if c.Unordered {
+		fs = append(fs, store.Unordered())
+	}
+	if f := sampleFilter(c.SampleRate, c.SampleSeed); f != nil {
+		fs = append(fs, f)
+	}
+	if c.Limit != 0 || c.Offset != 0 {
+		fs = append(fs, store.Limit(c.Limit, c.Offset))
+	}
+	if f := storeCmd.queryLimits(); f != nil {
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+// unitFilters returns the filters that select the source units (in
+// the repos and commits) that the query is restricted to.
+func (c *StoreRefsCmd) unitFilters() []store.UnitFilter {
+	var fs []store.UnitFilter
+	if c.UnitType != "" && c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	if commitIDs := commitIDsOpt(c.CommitID, c.Commits, c.CommitRange); len(commitIDs) > 0 {
+		fs = append(fs, store.ByCommitIDs(commitIDs...))
+	}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.RepoCommitIDs != "" {
+		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
+	}
+	if langs := languagesOpt(c.Language); len(langs) > 0 {
+		fs = append(fs, store.ByLanguages(langs...))
+	}
+	return fs
+}
+
+var storeRefsCmd StoreRefsCmd
+
+func (c *StoreRefsCmd) Execute(args []string) error {
+	if err := checkSampleRate(c.SampleRate); err != nil {
+		return err
+	}
+	if c.SampleRate != 0 && (c.Broken || c.Coverage || c.CoverageByUnit || c.Locations) {
+		return newCmdError(ExitUsage, fmt.Errorf("--sample-rate can't be used with --broken, --coverage, --coverage-by-unit, or --locations"))
+	}
+	if c.Input != "" && (c.Coverage || c.CoverageByUnit || c.Locations) {
+		return newCmdError(ExitUsage, fmt.Errorf("--input can't be used with --coverage, --coverage-by-unit, or --locations"))
+	}
+	if c.GroupBy != "" && c.GroupBy != "file" {
+		return newCmdError(ExitUsage, fmt.Errorf("--group-by must be 'file', not %q", c.GroupBy))
+	}
+	if c.Positions != "" && c.Positions != "offset" && c.Positions != "line-col" {
+		return newCmdError(ExitUsage, fmt.Errorf("--positions must be 'offset' or 'line-col', not %q", c.Positions))
+	}
+
+	if c.CoverageByUnit {
+		s, err := OpenStore()
+		if err != nil {
+			return err
+		}
+		rrs, ok := s.(store.RefResolutionStore)
+		if !ok {
+			return fmt.Errorf("store (type %T) does not implement reporting ref resolution", s)
+		}
+		res, err := rrs.RefResolutions(c.unitFilters()...)
+		if err != nil {
+			return err
+		}
+		PrintJSON(res, "  ")
+		return nil
+	}
+
+	if c.Locations {
+		s, err := OpenStore()
+		if err != nil {
+			return err
+		}
+		grs, ok := s.(store.GlobalRefStore)
+		if !ok {
+			return fmt.Errorf("store (type %T) does not implement finding ref locations in all repos", s)
+		}
+		if c.DefRepo == "" || c.DefUnitType == "" || c.DefUnit == "" || c.DefPath == "" {
+			return fmt.Errorf("--locations requires --def-repo, --def-unit-type, --def-unit, and --def-path")
+		}
+		var locs []*store.RefLocation
+		err = explainQuery(c.Explain, func() (err error) {
+			locs, err = grs.RefLocations(graph.RefDefKey{
+				DefRepo:     c.DefRepo,
+				DefUnitType: c.DefUnitType,
+				DefUnit:     c.DefUnit,
+				DefPath:     c.DefPath,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		PrintJSON(locs, "  ")
+		return nil
+	}
+
+	if storeCmd.MaxMemory > 0 && !(c.Format == "json" && c.streamable()) {
+		logger.Warnf("--max-memory only limits refs queries with the default --format=json and without --limit, --offset, --sample-rate, --broken, --coverage, --group-by, --positions=line-col, --explain, --input, or --overlay (the results of other queries are held in memory).")
+	}
+	if storeCmd.MaxMemory > 0 && c.Format == "json" && c.streamable() {
+		s, err := OpenStore()
+		if err != nil {
+			return err
+		}
+		us, ok := s.(store.UnitStore)
+		if !ok {
+			return fmt.Errorf("store (type %T) does not implement listing refs", s)
+		}
+		if us, err = storeCmd.logSlowQueries(us); err != nil {
+			return err
+		}
+		return checkQueryLimit(streamRefs(us, c.filters()))
+	}
+
+	var refs []*graph.Ref
+	err := explainQuery(c.Explain, func() (err error) {
+		refs, err = c.Get()
+		return err
+	})
+	if err != nil {
+		return checkQueryLimit(err)
+	}
+	switch c.Format {
+	case "json":
+		if c.SampleRate != 0 {
+			PrintJSON(newSampleResults(c.results(refs), len(refs), c.SampleRate), "  ")
+			break
+		}
+		PrintJSON(c.results(refs), "  ")
+	}
+	return nil
+}
+
+// streamable returns whether the query's results can be streamed one
+// unit at a time (see streamRefs), which is not possible if they are
+// limited, sampled, merged over a base commit's, read from multiple
+// queries (with --input), grouped or converted to lines and columns
+// (with --group-by or --positions), or must all be checked for broken
+// refs.
+func (c *StoreRefsCmd) streamable() bool {
+	return c.Limit == 0 && c.Offset == 0 && c.SampleRate == 0 && !c.Broken && !c.Coverage && !c.Explain && !storeCmd.Overlay && c.Input == "" && c.GroupBy == "" && c.Positions != "line-col"
+}
+
+// brokenRefs returns the refs that match the query and point to
+// nonexistent defs, using the ref resolution data of the store (see
+// store.RefResolutionStore) to only read the refs of source units
+// that have broken refs.
+func (c *StoreRefsCmd) brokenRefs(us store.UnitStore, rrs store.RefResolutionStore) ([]*graph.Ref, error) {
+	res, err := rrs.RefResolutions(c.unitFilters()...)
+	if err != nil {
+		return nil, err
+	}
+	fs := append(c.filters(), store.ByUnits(store.BrokenUnits(res)...), store.BrokenRefs(res))
+	return us.Refs(fs...)
+}
+
+func (c *StoreRefsCmd) Get() ([]*graph.Ref, error) {
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	if s, err = storeCmd.overlay(s); err != nil {
+		return nil, err
+	}
+	if c.Input == "" {
+		return c.get(s)
+	}
+
+	inputs, err := readQueryInputs(c.Input)
+	if err != nil {
+		return nil, err
+	}
+	var refs []*graph.Ref
+	for _, in := range inputs {
+		inRefs, err := c.withInput(in).get(s)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, inRefs...)
+	}
+	return refs, nil
+}
+
+// get lists the refs in s that match the query.
+func (c *StoreRefsCmd) get(s interface{}) ([]*graph.Ref, error) {
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing refs", s)
+	}
+	us, err := storeCmd.logSlowQueries(us)
+	if err != nil {
+		return nil, err
+	}
+
+	rrs, hasRefResolutions := s.(store.RefResolutionStore)
+	if c.Broken && !c.Coverage && hasRefResolutions {
+		return c.brokenRefs(us, rrs)
+	}
+
+	qc, err := storeCmd.queryCache()
+	if err != nil {
+		return nil, err
+	}
+	var refs []*graph.Ref
+	if qc != nil && !c.Explain {
+		refs, err = qc.Refs(us, c.filters()...)
+	} else {
+		refs, err = us.Refs(c.filters()...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	allRefs := refs
+	var brokenRefs []*graph.Ref
+	if c.Broken || c.Coverage {
+		if hasRefResolutions {
+			brokenRefs, err = c.brokenRefs(us, rrs)
+		} else {
+			brokenRefs, err = brokenRefsOnly(refs, s)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.Broken {
+			refs = brokenRefs
+		}
+	}
+	if c.Coverage {
+		logger.Infof("# Coverage summary:")
+		logger.Infof("#  - %d total refs", len(allRefs))
+		resolvedRefs := len(allRefs) - len(brokenRefs)
+		logger.Infof("#  - %d resolved refs (%.1f)", resolvedRefs, percent(resolvedRefs, len(allRefs)))
+		logger.Infof("#  - %d broken refs (%.1f)", len(brokenRefs), percent(len(brokenRefs), len(allRefs)))
+	}
+
+	return refs, nil
+}
+
+func brokenRefsOnly(refs []*graph.Ref, s interface{}) ([]*graph.Ref, error) {
+	uniqRefDefs := map[graph.DefKey][]*graph.Ref{}
+	loggedDefRepos := map[string]struct{}{}
+	for _, ref := range refs {
+		if ref.Repo != ref.DefRepo {
+			if _, logged := loggedDefRepos[ref.DefRepo]; !logged {
+				// TODO(sqs): need to skip these because we don't know the
+				// "DefCommitID" in the def's repo, and ByDefKey requires
+				// the key to have a CommitID.
+				logger.Warnf("Can't check resolution of cross-repo ref (ref.Repo=%q != ref.DefRepo=%q) - cross-repo ref checking is not yet implemented. (This log message will not be repeated.)", ref.Repo, ref.DefRepo)
+				loggedDefRepos[ref.DefRepo] = struct{}{}
+			}
+			continue
+		}
+		def := ref.DefKey()
+		def.CommitID = ref.CommitID
+		uniqRefDefs[def] = append(uniqRefDefs[def], ref)
+	}
+
+	var (
+		brokenRefs  []*graph.Ref
+		brokenRefMu sync.Mutex
+		par         = parallel.NewRun(runtime.GOMAXPROCS(0))
+	)
+	for def_, refs_ := range uniqRefDefs {
+		def, refs := def_, refs_
+		par.Do(func() error {
+			defs, err := s.(store.RepoStore).Defs(store.ByDefKey(def))
+			if err != nil {
+				return err
+			}
+			if len(defs) == 0 {
+				brokenRefMu.Lock()
+				brokenRefs = append(brokenRefs, refs...)
+				brokenRefMu.Unlock()
+			}
+			return nil
+		})
+	}
+	err := par.Wait()
+	sort.Sort(graph.Refs(brokenRefs))
+	return brokenRefs, err
+}
+
+type StoreHoverCmd struct {
+	Repo     string `long:"repo"`
+	CommitID string `long:"commit"`
+
+	File string `long:"file" description:"file that contains the position" required:"yes"`
+	Byte uint32 `long:"byte" description:"byte offset of the position in the file"`
+}
+
+var storeHoverCmd StoreHoverCmd
+
+// HoverInfo is the output of the hover command (and of the
+// serve-editor command's DefAtPosition method).
+type HoverInfo struct {
+	// Ref is the ref at the position.
+	Ref *graph.Ref
+
+	// Def is the def that Ref refers to. It is nil if the def is not
+	// in the store (e.g., if it is in a repo that has not been
+	// imported).
+	Def *graph.Def `json:",omitempty"`
+
+	Name string `json:",omitempty"`
+	Kind string `json:",omitempty"`
+
+	// Signature is the def's declaration, formatted by its
+	// toolchain. It is empty if the toolchain can't format defs.
+	Signature string `json:",omitempty"`
+
+	// DocHTML is the def's docs, rendered as HTML.
+	DocHTML string `json:",omitempty"`
+
+	// File, DefStart, and DefEnd are the location of the def's
+	// definition.
+	File     string `json:",omitempty"`
+	DefStart uint32 `json:",omitempty"`
+	DefEnd   uint32 `json:",omitempty"`
+}
+
+func (c *StoreHoverCmd) Execute(args []string) error {
+	info, err := c.Get()
+	if err != nil {
+		return err
+	}
+	PrintJSON(info, "  ")
+	return nil
+}
+
+// Get returns the hover info for the position. If there is no ref at
+// the position, it returns nil.
+func (c *StoreHoverCmd) Get() (*HoverInfo, error) {
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing refs and defs", s)
+	}
+	return c.get(us)
+}
+
+func (c *StoreHoverCmd) get(us store.UnitStore) (*HoverInfo, error) {
+	rfs := []store.RefFilter{
+		store.ByFiles(path.Clean(c.File)),
+		store.RefFilterFunc(func(ref *graph.Ref) bool {
+			return ref.Start <= c.Byte && c.Byte < ref.End
+		}),
+		store.Unordered(),
+	}
+	if c.Repo != "" {
+		rfs = append(rfs, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		rfs = append(rfs, store.ByCommitIDs(c.CommitID))
+	}
+	refs, err := us.Refs(rfs...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, nil
 	}
 
-	rs, ok := s.(store.RepoStore)
-	if !ok {
-		return fmt.Errorf("store (type %T) does not implement listing versions", s)
+	// Use the innermost ref at the position.
+	ref := refs[0]
+	for _, r := range refs[1:] {
+		if r.End-r.Start < ref.End-ref.Start {
+			ref = r
+		}
 	}
+	info := &HoverInfo{Ref: ref}
 
-	versions, err := rs.Versions(c.filters()...)
+	dfs := []store.DefFilter{store.ByDefPath(ref.DefPath)}
+	if ref.DefUnitType != "" && ref.DefUnit != "" {
+		dfs = append(dfs, store.ByUnits(unit.ID2{Type: ref.DefUnitType, Name: ref.DefUnit}))
+	}
+	if ref.DefRepo != "" {
+		dfs = append(dfs, store.ByRepos(ref.DefRepo))
+	}
+	if ref.DefRepo == ref.Repo && ref.CommitID != "" {
+		// The def is in the same repo, so it's at the same commit.
+		dfs = append(dfs, store.ByCommitIDs(ref.CommitID))
+	}
+	defs, err := us.Defs(dfs...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, version := range versions {
-		if version.Repo != "" {
-			fmt.Print(version.Repo, "\t")
-		}
-		fmt.Println(version.CommitID)
+	if len(defs) == 0 {
+		return info, nil
 	}
-	return nil
+	def := defs[0]
+
+	info.Def = def
+	info.Name = def.Name
+	info.Kind = def.Kind
+	info.DocHTML = string(docsHTML(def.Docs))
+	info.File = def.File
+	info.DefStart = def.DefStart
+	info.DefEnd = def.DefEnd
+
+	if sig, err := toolchain.FormatDef(def, toolchain.DeclFormat); err == nil {
+		info.Signature = sig
+	} else {
+		logger.Debugf("Warning: unable to format signature of def %s: %s.", def.Path, err)
+	}
+	return info, nil
 }
 
-type StoreUnitsCmd struct {
-	Type     string `long:"type" `
-	Name     string `long:"name"`
-	CommitID string `long:"commit"`
+type StoreCallsCmd struct {
 	Repo     string `long:"repo"`
+	UnitType string `long:"unit-type" `
+	Unit     string `long:"unit"`
+	CommitID string `long:"commit"`
 
 	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
 
-	File string `long:"file" description:"filter by units whose Files list contains this file"`
+	DefPath string `long:"def-path" description:"list the calls made by the def with this path (its callees)"`
+
+	CallersOf      string `long:"callers-of" description:"list the calls to the def with this path (its callers)" value-name:"DEF-PATH"`
+	CalleeRepo     string `long:"callee-repo" description:"with --callers-of, the repo of the called def (default: --repo)"`
+	CalleeUnitType string `long:"callee-unit-type" description:"with --callers-of, the source unit type of the called def (default: --unit-type)"`
+	CalleeUnit     string `long:"callee-unit" description:"with --callers-of, the source unit of the called def (default: --unit)"`
+
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
 }
 
-func (c *StoreUnitsCmd) filters() []store.UnitFilter {
-	var fs []store.UnitFilter
-	if c.Type != "" && c.Name != "" {
-		fs = append(fs, store.ByUnits(unit.ID2{Type: c.Type, Name: c.Name}))
+func (c *StoreCallsCmd) filters() []store.CallFilter {
+	var fs []store.CallFilter
+	if c.UnitType != "" && c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
 	}
-	if (c.Type != "" && c.Name == "") || (c.Type == "" && c.Name != "") {
-		log.Fatal("must specify either both or neither of --type and --name (to filter by source unit)")
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		logger.Fatalf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
 	}
 	if c.CommitID != "" {
 		fs = append(fs, store.ByCommitIDs(c.CommitID))
@@ -874,60 +3330,76 @@ func (c *StoreUnitsCmd) filters() []store.UnitFilter {
 	if c.RepoCommitIDs != "" {
 		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
 	}
-	if c.File != "" {
-		fs = append(fs, store.ByFiles(path.Clean(c.File)))
+	if c.DefPath != "" {
+		fs = append(fs, store.ByCallerPath(c.DefPath))
+	}
+	if c.CallersOf != "" {
+		or := func(a, b string) string {
+			if a != "" {
+				return a
+			}
+			return b
+		}
+		fs = append(fs, store.ByCallee(graph.RefDefKey{
+			DefRepo:     or(c.CalleeRepo, c.Repo),
+			DefUnitType: or(c.CalleeUnitType, c.UnitType),
+			DefUnit:     or(c.CalleeUnit, c.Unit),
+			DefPath:     c.CallersOf,
+		}))
+	}
+	if c.Unordered {
+		fs = append(fs, store.Unordered())
 	}
 	return fs
 }
 
-var storeUnitsCmd StoreUnitsCmd
+var storeCallsCmd StoreCallsCmd
 
-func (c *StoreUnitsCmd) Execute(args []string) error {
+func (c *StoreCallsCmd) Execute(args []string) error {
 	s, err := OpenStore()
 	if err != nil {
 		return err
 	}
 
-	ts, ok := s.(store.TreeStore)
+	cs, ok := s.(store.CallStore)
 	if !ok {
-		return fmt.Errorf("store (type %T) does not implement listing source units", s)
+		return fmt.Errorf("store (type %T) does not implement listing calls", s)
 	}
 
-	units, err := ts.Units(c.filters()...)
+	calls, err := cs.Calls(c.filters()...)
 	if err != nil {
 		return err
 	}
-	PrintJSON(units, "  ")
+	PrintJSON(calls, "  ")
 	return nil
 }
 
-type StoreDefsCmd struct {
+type StoreImplsCmd struct {
 	Repo     string `long:"repo"`
-	Path     string `long:"path"`
 	UnitType string `long:"unit-type" `
 	Unit     string `long:"unit"`
-	File     string `long:"file"`
 	CommitID string `long:"commit"`
 
 	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
 
-	Query string `long:"query"`
+	DefPath     string `long:"def-path" description:"list the relations to the def with this path (its implementations and subtypes)"`
+	DefRepo     string `long:"def-repo" description:"with --def-path, the repo of the def (default: --repo)"`
+	DefUnitType string `long:"def-unit-type" description:"with --def-path, the source unit type of the def (default: --unit-type)"`
+	DefUnit     string `long:"def-unit" description:"with --def-path, the source unit of the def (default: --unit)"`
+	Supertypes  bool   `long:"supertypes" description:"with --def-path, list the relations from the def (the interfaces it implements and the types it extends) instead"`
 
-	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
-	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
+	Kind string `long:"kind" description:"only list relations of this kind (e.g., implements or extends)"`
 
-	// If Filter is non-nil, it is applied along with the above
-	// filters.
-	Filter store.DefFilter
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
 }
 
-func (c *StoreDefsCmd) filters() []store.DefFilter {
-	var fs []store.DefFilter
+func (c *StoreImplsCmd) filters() []store.RelationFilter {
+	var fs []store.RelationFilter
 	if c.UnitType != "" && c.Unit != "" {
 		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
 	}
 	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
-		log.Fatal("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+		logger.Fatalf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
 	}
 	if c.CommitID != "" {
 		fs = append(fs, store.ByCommitIDs(c.CommitID))
@@ -938,86 +3410,152 @@ func (c *StoreDefsCmd) filters() []store.DefFilter {
 	if c.RepoCommitIDs != "" {
 		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
 	}
-	if c.Path != "" {
-		fs = append(fs, store.ByDefPath(c.Path))
-	}
-	if c.File != "" {
-		fs = append(fs, store.ByFiles(path.Clean(c.File)))
+	if c.Supertypes && c.DefPath == "" {
+		logger.Fatalf("--supertypes requires --def-path")
 	}
-	if c.Query != "" {
-		fs = append(fs, store.ByDefQuery(c.Query))
+	if c.DefPath != "" {
+		if c.Supertypes {
+			fs = append(fs, store.ByRelationDefPath(c.DefPath))
+		} else {
+			or := func(a, b string) string {
+				if a != "" {
+					return a
+				}
+				return b
+			}
+			fs = append(fs, store.ByRelationTarget(graph.RefDefKey{
+				DefRepo:     or(c.DefRepo, c.Repo),
+				DefUnitType: or(c.DefUnitType, c.UnitType),
+				DefUnit:     or(c.DefUnit, c.Unit),
+				DefPath:     c.DefPath,
+			}))
+		}
 	}
-	if c.Filter != nil {
-		fs = append(fs, c.Filter)
+	if c.Kind != "" {
+		fs = append(fs, store.ByRelationKind(c.Kind))
 	}
-	if c.Limit != 0 || c.Offset != 0 {
-		fs = append(fs, store.Limit(c.Limit, c.Offset))
+	if c.Unordered {
+		fs = append(fs, store.Unordered())
 	}
 	return fs
 }
 
-var storeDefsCmd StoreDefsCmd
+var storeImplsCmd StoreImplsCmd
 
-func (c *StoreDefsCmd) Execute(args []string) error {
-	defs, err := c.Get()
+func (c *StoreImplsCmd) Execute(args []string) error {
+	s, err := OpenStore()
 	if err != nil {
 		return err
 	}
-	PrintJSON(defs, "  ")
+
+	rs, ok := s.(store.RelationStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing relations", s)
+	}
+
+	rels, err := rs.Relations(c.filters()...)
+	if err != nil {
+		return err
+	}
+	PrintJSON(rels, "  ")
 	return nil
 }
 
-func (c *StoreDefsCmd) Get() ([]*graph.Def, error) {
+type StoreHistoryCmd struct {
+	Repo     string `long:"repo" description:"the repo of the def (required for MultiRepoStores)"`
+	UnitType string `long:"unit-type" required:"yes"`
+	Unit     string `long:"unit" required:"yes"`
+	DefPath  string `long:"def-path" required:"yes"`
+}
+
+var storeHistoryCmd StoreHistoryCmd
+
+func (c *StoreHistoryCmd) Execute(args []string) error {
 	s, err := OpenStore()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	us, ok := s.(store.UnitStore)
+	hs, ok := s.(store.DefHistoryStore)
 	if !ok {
-		return nil, fmt.Errorf("store (type %T) does not implement listing defs", s)
+		return fmt.Errorf("store (type %T) does not implement def history", s)
 	}
 
-	defs, err := us.Defs(c.filters()...)
+	evs, err := hs.DefHistory(graph.DefKey{Repo: c.Repo, UnitType: c.UnitType, Unit: c.Unit, Path: c.DefPath})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return defs, nil
+	PrintJSON(evs, "  ")
+	return nil
 }
 
-type StoreRefsCmd struct {
+type StoreDetectRenamesCmd struct {
+	Repo         string `long:"repo" description:"the repo (required for MultiRepoStores)"`
+	FromCommitID string `long:"from" required:"yes" description:"the old commit"`
+	ToCommitID   string `long:"to" required:"yes" description:"the new commit"`
+
+	Threshold float64 `long:"threshold" description:"minimum similarity score (0-1) for a pair of defs to be considered a rename" default:"0.6"`
+	DryRun    bool    `short:"n" long:"dry-run" description:"print the detected renames but don't record them in the store"`
+}
+
+var storeDetectRenamesCmd StoreDetectRenamesCmd
+
+func (c *StoreDetectRenamesCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	fromDefs, err := (&StoreDefsCmd{Repo: c.Repo, CommitID: c.FromCommitID, Unordered: true}).Get()
+	if err != nil {
+		return err
+	}
+	toDefs, err := (&StoreDefsCmd{Repo: c.Repo, CommitID: c.ToCommitID, Unordered: true}).Get()
+	if err != nil {
+		return err
+	}
+	if len(fromDefs) == 0 || len(toDefs) == 0 {
+		return fmt.Errorf("no defs found at --from %q and/or --to %q (did you run `src store import` for both commits?)", c.FromCommitID, c.ToCommitID)
+	}
+
+	renames := store.DetectDefRenames(fromDefs, toDefs, c.Threshold)
+	logger.Debugf("Detected %d renames between %s and %s.", len(renames), c.FromCommitID, c.ToCommitID)
+
+	if !c.DryRun {
+		imp, ok := s.(store.DefRenameImporter)
+		if !ok {
+			return fmt.Errorf("store (type %T) does not implement recording def renames", s)
+		}
+		if err := imp.ImportDefRenames(c.Repo, c.FromCommitID, c.ToCommitID, renames); err != nil {
+			return err
+		}
+	}
+	PrintJSON(renames, "  ")
+	return nil
+}
+
+type StoreDepsCmd struct {
 	Repo     string `long:"repo"`
 	UnitType string `long:"unit-type" `
 	Unit     string `long:"unit"`
-	File     string `long:"file"`
 	CommitID string `long:"commit"`
 
 	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
 
-	Start uint32 `long:"start"`
-	End   uint32 `long:"end"`
-
-	DefRepo     string `long:"def-repo"`
-	DefUnitType string `long:"def-unit-type" `
-	DefUnit     string `long:"def-unit"`
-	DefPath     string `long:"def-path"`
-
-	Broken   bool `long:"broken" description:"only show refs that point to nonexistent defs"`
-	Coverage bool `long:"coverage" description:"print a coverage summary (resolved refs, broken refs, total refs)"`
+	DependentsOf         string `long:"dependents-of" description:"only list deps on this repo (reverse-dependency mode)" value-name:"REPO"`
+	DependentsOfUnitType string `long:"dependents-of-unit-type" description:"with --dependents-of, only list deps on source units of this type"`
+	DependentsOfUnit     string `long:"dependents-of-unit" description:"with --dependents-of, only list deps on source units with this name"`
 
-	Format string `long:"format" description:"output format ('json' or 'none')" default:"json"`
-
-	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
-	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
+	Unordered bool `long:"unordered" description:"don't sort results (faster, but output order may vary between runs)"`
 }
 
-func (c *StoreRefsCmd) filters() []store.RefFilter {
-	var fs []store.RefFilter
+func (c *StoreDepsCmd) filters() []store.DepFilter {
+	var fs []store.DepFilter
 	if c.UnitType != "" && c.Unit != "" {
 		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
 	}
 	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
-		log.Fatal("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+		logger.Fatalf("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
 	}
 	if c.CommitID != "" {
 		fs = append(fs, store.ByCommitIDs(c.CommitID))
@@ -1028,144 +3566,66 @@ func (c *StoreRefsCmd) filters() []store.RefFilter {
 	if c.RepoCommitIDs != "" {
 		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
 	}
-	if c.File != "" {
-		fs = append(fs, store.ByFiles(path.Clean(c.File)))
-	}
-	if c.Start != 0 {
-		fs = append(fs, store.RefFilterFunc(func(ref *graph.Ref) bool {
-			return ref.Start >= c.Start
-		}))
-	}
-	if c.End != 0 {
-		fs = append(fs, store.RefFilterFunc(func(ref *graph.Ref) bool {
-			return ref.End <= c.End
-		}))
-	}
-	if c.DefPath != "" {
-		fs = append(fs, store.ByRefDef(graph.RefDefKey{
-			DefRepo:     c.DefRepo,
-			DefUnitType: c.DefUnitType,
-			DefUnit:     c.DefUnit,
-			DefPath:     c.DefPath,
-		}))
-	} else {
-		// Slower filters since they don't use an index.
-		if c.DefRepo != "" {
-			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
-				return ref.DefRepo == c.DefRepo
-			})))
-		}
-		if c.DefUnitType != "" {
-			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
-				return ref.DefUnitType == c.DefUnitType
-			})))
-		}
-		if c.DefUnit != "" {
-			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
-				return ref.DefUnit == c.DefUnit
-			})))
-		}
+	if c.DependentsOf != "" {
+		fs = append(fs, store.ByDepTarget(c.DependentsOf, c.DependentsOfUnitType, c.DependentsOfUnit))
+	} else if c.DependentsOfUnitType != "" || c.DependentsOfUnit != "" {
+		logger.Fatalf("--dependents-of-unit-type and --dependents-of-unit require --dependents-of")
 	}
-	if c.Limit != 0 || c.Offset != 0 {
-		fs = append(fs, store.Limit(c.Limit, c.Offset))
+	if c.Unordered {
+		fs = append(fs, store.Unordered())
 	}
 	return fs
 }
 
-var storeRefsCmd StoreRefsCmd
-
-func (c *StoreRefsCmd) Execute(args []string) error {
-	refs, err := c.Get()
-	if err != nil {
-		return err
-	}
-	switch c.Format {
-	case "json":
-		PrintJSON(refs, "  ")
-	}
-	return nil
-}
+var storeDepsCmd StoreDepsCmd
 
-func (c *StoreRefsCmd) Get() ([]*graph.Ref, error) {
+func (c *StoreDepsCmd) Execute(args []string) error {
 	s, err := OpenStore()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	us, ok := s.(store.UnitStore)
+	ds, ok := s.(store.DepStore)
 	if !ok {
-		return nil, fmt.Errorf("store (type %T) does not implement listing refs", s)
+		return fmt.Errorf("store (type %T) does not implement listing deps", s)
 	}
 
-	refs, err := us.Refs(c.filters()...)
+	deps, err := ds.Deps(c.filters()...)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	PrintJSON(deps, "  ")
+	return nil
+}
 
-	allRefs := refs
-	var brokenRefs []*graph.Ref
-	if c.Broken || c.Coverage {
-		brokenRefs, err = brokenRefsOnly(refs, s)
+// commitIDsOpt returns the commit IDs specified by the --commit,
+// --commits, and --commit-range options. Commit ranges are resolved
+// in the current repository.
+func commitIDsOpt(commitID, commits, commitRange string) []string {
+	var commitIDs []string
+	if commitID != "" {
+		commitIDs = append(commitIDs, commitID)
+	}
+	for _, c := range strings.Split(commits, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			commitIDs = append(commitIDs, c)
+		}
+	}
+	if commitRange != "" {
+		lrepo, err := openLocalRepo()
 		if err != nil {
-			return nil, err
+			logger.Fatalf("--commit-range requires a local repository: %s", err)
 		}
-		if c.Broken {
-			refs = brokenRefs
+		rangeIDs, err := resolveCommitRange(lrepo.VCSType, lrepo.RootDir, commitRange)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}
-	if c.Coverage {
-		log.Printf("# Coverage summary:")
-		log.Printf("#  - %d total refs", len(allRefs))
-		resolvedRefs := len(allRefs) - len(brokenRefs)
-		log.Printf("#  - %d resolved refs (%.1f)", resolvedRefs, percent(resolvedRefs, len(allRefs)))
-		log.Printf("#  - %d broken refs (%.1f)", len(brokenRefs), percent(len(brokenRefs), len(allRefs)))
-	}
-
-	return refs, nil
-}
-
-func brokenRefsOnly(refs []*graph.Ref, s interface{}) ([]*graph.Ref, error) {
-	uniqRefDefs := map[graph.DefKey][]*graph.Ref{}
-	loggedDefRepos := map[string]struct{}{}
-	for _, ref := range refs {
-		if ref.Repo != ref.DefRepo {
-			if _, logged := loggedDefRepos[ref.DefRepo]; !logged {
-				// TODO(sqs): need to skip these because we don't know the
-				// "DefCommitID" in the def's repo, and ByDefKey requires
-				// the key to have a CommitID.
-				log.Printf("WARNING: Can't check resolution of cross-repo ref (ref.Repo=%q != ref.DefRepo=%q) - cross-repo ref checking is not yet implemented. (This log message will not be repeated.)", ref.Repo, ref.DefRepo)
-				loggedDefRepos[ref.DefRepo] = struct{}{}
-			}
-			continue
+		if len(rangeIDs) == 0 {
+			logger.Fatalf("commit range %q contains no commits", commitRange)
 		}
-		def := ref.DefKey()
-		def.CommitID = ref.CommitID
-		uniqRefDefs[def] = append(uniqRefDefs[def], ref)
-	}
-
-	var (
-		brokenRefs  []*graph.Ref
-		brokenRefMu sync.Mutex
-		par         = parallel.NewRun(runtime.GOMAXPROCS(0))
-	)
-	for def_, refs_ := range uniqRefDefs {
-		def, refs := def_, refs_
-		par.Do(func() error {
-			defs, err := s.(store.RepoStore).Defs(store.ByDefKey(def))
-			if err != nil {
-				return err
-			}
-			if len(defs) == 0 {
-				brokenRefMu.Lock()
-				brokenRefs = append(brokenRefs, refs...)
-				brokenRefMu.Unlock()
-			}
-			return nil
-		})
+		commitIDs = append(commitIDs, rangeIDs...)
 	}
-	err := par.Wait()
-	sort.Sort(graph.Refs(brokenRefs))
-	return brokenRefs, err
+	return commitIDs
 }
 
 func makeRepoCommitIDsFilter(repoCommitIDs string) interface {
@@ -1174,6 +3634,9 @@ func makeRepoCommitIDsFilter(repoCommitIDs string) interface {
 	store.DefFilter
 	store.UnitFilter
 	store.RefFilter
+	store.DepFilter
+	store.CallFilter
+	store.RelationFilter
 } {
 	if repoCommitIDs == "" {
 		panic("empty repoCommitIDs")
@@ -1184,7 +3647,7 @@ func makeRepoCommitIDsFilter(repoCommitIDs string) interface {
 		rc = strings.TrimSpace(rc)
 		repo, commitID := sourcegraph.ParseRepoAndCommitID(rc)
 		if len(commitID) != 40 {
-			log.Printf("WARNING: --repo-commits entry #%d (%q) has no commit ID or a non-absolute commit ID. Nothing will match it.", i, rc)
+			logger.Warnf("--repo-commits entry #%d (%q) has no commit ID or a non-absolute commit ID. Nothing will match it.", i, rc)
 		}
 		vs[i] = store.Version{Repo: repo, CommitID: commitID}
 	}
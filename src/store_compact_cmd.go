@@ -0,0 +1,57 @@
+package src
+
+import (
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreCompactCmd struct {
+	Repo     string `long:"repo" description:"the repo (required for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"the commit (or snapshot name) whose data to compact"`
+	NoWait   bool   `long:"no-wait" description:"fail instead of waiting if another process is writing data for the same repo and commit"`
+
+	DeltaBase string `long:"delta-base" description:"store the commit's files as deltas against the same files in this commit (or snapshot), usually its parent" value-name:"COMMIT"`
+	Dedup     bool   `long:"dedup" description:"store each source unit's files in a content-addressed blob shared with the repo's other commits (so units that are unchanged across commits are stored once)"`
+}
+
+var storeCompactCmd StoreCompactCmd
+
+func (c *StoreCompactCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	cs, ok := s.(store.Compactor)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement compaction", s)
+	}
+
+	unlock, err := lockCommit(s, c.Repo, c.CommitID, !c.NoWait)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Warnf("releasing store lock: %s", err)
+		}
+	}()
+
+	stats, err := cs.Compact(c.Repo, c.CommitID, &store.CompactOpt{DeltaBase: c.DeltaBase, Dedup: c.Dedup})
+	if err != nil {
+		return err
+	}
+	if stats.Unchanged {
+		logger.Infof("# %s is already compacted (%d files in pack).", versionLabel(c.Repo, c.CommitID), stats.Files)
+		return nil
+	}
+	if c.Dedup {
+		logger.Infof("# Compacted %s: merged %d loose files into %d blobs (%d new, %d shared with other commits; %d bytes written).", versionLabel(c.Repo, c.CommitID), stats.Loose, stats.Blobs, stats.NewBlobs, stats.Blobs-stats.NewBlobs, stats.Size)
+		return nil
+	}
+	logger.Infof("# Compacted %s: merged %d loose files into a pack of %d files (%d stored as deltas; %d bytes).", versionLabel(c.Repo, c.CommitID), stats.Loose, stats.Files, stats.Deltas, stats.Size)
+	return nil
+}
@@ -0,0 +1,98 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreDumpCmd struct {
+	Repo     string `long:"repo" description:"repo to dump (required for a MultiRepoStore)"`
+	CommitID string `long:"commit" description:"commit ID to dump"`
+}
+
+var storeDumpCmd StoreDumpCmd
+
+func (c *StoreDumpCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, errors.New("--commit must be given (outside of a repository)"))
+	}
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := dumpJSON(context.Background(), w, s, c.Repo, c.CommitID, nil); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// dumpJSON writes the records of a dump of the commit (see
+// store.Dump) to w as JSON, one record per line. If flush is non-nil,
+// it is called after each source unit's records are written, so that
+// readers receive them as they are produced. It stops when ctx is done.
+func dumpJSON(ctx context.Context, w io.Writer, s interface{}, repo, commitID string, flush func()) error {
+	enc := json.NewEncoder(w)
+	return store.DumpContext(ctx, s, repo, commitID, func(rec *store.DumpRecord) error {
+		if rec.Type == store.DumpUnit && flush != nil {
+			flush()
+		}
+		return enc.Encode(rec)
+	})
+}
+
+// dumpHandler serves dumps of commits (in the format written by `src
+// store dump`) at /export?repo=R&commit=C. A dump stops when its
+// client goes away.
+type dumpHandler struct {
+	s interface{}
+}
+
+func (h *dumpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	commitID := q.Get("commit")
+	if commitID == "" {
+		http.Error(w, "commit: empty", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/x-ndjson; charset=utf-8")
+	var flush func()
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+	if err := dumpJSON(r.Context(), w, h.s, q.Get("repo"), commitID, flush); err != nil {
+		if err == context.Canceled {
+			logger.Debugf("Canceled export of %s %s: the client went away.", q.Get("repo"), commitID)
+			return
+		}
+		// The status has already been sent if any records were
+		// written, so the error is reported as a final record that
+		// clients can detect.
+		logger.Errorf("Dumping %s %s: %s", q.Get("repo"), commitID, err)
+		fmt.Fprintf(w, "%s\n", mustMarshalJSON(jsonDumpError{Type: "error", Error: err.Error()}))
+	}
+}
+
+// jsonDumpError is the final record of an export that failed.
+type jsonDumpError struct {
+	Type  string
+	Error string
+}
+
+func mustMarshalJSON(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,265 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+type StoreExportMLCmd struct {
+	Repo     string `long:"repo" description:"only export defs in this repo"`
+	CommitID string `long:"commit" description:"only export defs at this commit (default: all of each repo's imported commits)"`
+
+	Exported         bool `long:"exported" description:"only export exported defs"`
+	Documented       bool `long:"documented" description:"only export defs that have docs"`
+	ExcludeGenerated bool `long:"exclude-generated" description:"omit defs in generated code"`
+
+	Sample float64 `long:"sample" description:"export this fraction (between 0 and 1) of the defs, chosen by hashing their keys (so the same defs are chosen each time)" default:"1"`
+	Seed   string  `long:"seed" description:"string that varies which defs --sample chooses"`
+
+	Licenses      string `long:"licenses" description:"JSON file mapping repos (or repo prefixes ending in '/') to their SPDX license IDs (e.g., {\"github.com/foo/\": \"MIT\"})" value-name:"FILE"`
+	AllowLicenses string `long:"allow-licenses" description:"comma-separated list of SPDX license IDs; only export defs in repos with these licenses (per --licenses)"`
+
+	ContextLines int    `long:"context-lines" description:"lines of source code before and after each def to include in its record's context (if the source is available)" default:"3"`
+	Output       string `short:"o" long:"output" description:"write the records to this file (default: stdout)"`
+}
+
+var storeExportMLCmd StoreExportMLCmd
+
+// mlRecord is a record in the output of 'src store export-ml'.
+type mlRecord struct {
+	Repo     string `json:",omitempty"`
+	CommitID string
+	UnitType string
+	Unit     string
+	Path     string
+	Name     string
+	Kind     string `json:",omitempty"`
+	Language string `json:",omitempty"`
+	File     string
+	Exported bool
+	License  string `json:",omitempty"`
+
+	// Signature is the def's signature (if its toolchain registered a
+	// def formatter), and Doc is its documentation (preferring plain
+	// text).
+	Signature string `json:",omitempty"`
+	Doc       string `json:",omitempty"`
+
+	// Context is the def's source code and the lines around it (see
+	// --context-lines). It is only set if the def's file is in the
+	// current repository, checked out at the def's commit.
+	Context string `json:",omitempty"`
+}
+
+func (c *StoreExportMLCmd) Execute(args []string) error {
+	if c.Sample <= 0 || c.Sample > 1 {
+		return newCmdError(ExitUsage, fmt.Errorf("--sample must be in (0, 1], not %g", c.Sample))
+	}
+	licenses := map[string]string{}
+	if c.Licenses != "" {
+		if err := readJSONFile(c.Licenses, &licenses); err != nil {
+			return fmt.Errorf("reading --licenses: %s", err)
+		}
+	}
+	var allowLicenses map[string]struct{}
+	if c.AllowLicenses != "" {
+		if c.Licenses == "" {
+			return newCmdError(ExitUsage, fmt.Errorf("--allow-licenses requires --licenses"))
+		}
+		allowLicenses = map[string]struct{}{}
+		for _, l := range strings.Split(c.AllowLicenses, ",") {
+			allowLicenses[strings.TrimSpace(l)] = struct{}{}
+		}
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing commits and defs", s)
+	}
+	_, isMultiRepo := s.(store.MultiRepoStore)
+
+	var vfs []store.VersionFilter
+	if isMultiRepo && c.Repo != "" {
+		vfs = append(vfs, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		vfs = append(vfs, store.ByCommitIDs(c.CommitID))
+	}
+	versions, err := rs.Versions(vfs...)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Output != "" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	var n int
+	files := map[string][]byte{}
+	for _, v := range versions {
+		repo := v.Repo
+		if !isMultiRepo {
+			repo = c.Repo
+		}
+		license := repoLicense(licenses, repo)
+		if allowLicenses != nil {
+			if _, allowed := allowLicenses[license]; !allowed {
+				logger.Debugf("# Skipping %s (license %q is not allowed)", versionLabel(repo, v.CommitID), license)
+				continue
+			}
+		}
+
+		records, err := c.versionRecords(rs, repo, isMultiRepo, v.CommitID, files)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			rec.License = license
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		n += len(records)
+
+		// Only cache the files of one commit at a time.
+		files = map[string][]byte{}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	logger.Infof("# Exported %d defs from %d commits.", n, len(versions))
+	return nil
+}
+
+// versionRecords returns the records of the selected defs in repo at
+// commitID.
+func (c *StoreExportMLCmd) versionRecords(rs store.RepoStore, repo string, isMultiRepo bool, commitID string, files map[string][]byte) ([]*mlRecord, error) {
+	filterRepo := ""
+	if isMultiRepo {
+		filterRepo = repo
+	}
+	units, err := rs.Units(versionFilter(filterRepo, commitID))
+	if err != nil {
+		return nil, err
+	}
+	languages := make(map[unit.ID2]string, len(units))
+	for _, u := range units {
+		languages[u.ID2()] = u.Language
+	}
+
+	defs, err := rs.Defs(versionFilter(filterRepo, commitID), store.DefFilterFunc(func(def *graph.Def) bool {
+		if def.Local || (c.Exported && !def.Exported) || (c.Documented && len(def.Docs) == 0) || (c.ExcludeGenerated && def.Generated) {
+			return false
+		}
+		return c.Sample == 1 || c.sampled(repo, def)
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*mlRecord, len(defs))
+	for i, def := range defs {
+		rec := &mlRecord{
+			Repo:      repo,
+			CommitID:  commitID,
+			UnitType:  def.UnitType,
+			Unit:      def.Unit,
+			Path:      def.Path,
+			Name:      def.Name,
+			Kind:      def.Kind,
+			Language:  languages[unit.ID2{Type: def.UnitType, Name: def.Unit}],
+			File:      def.File,
+			Exported:  def.Exported,
+			Signature: defSignature(def),
+			Doc:       mlDoc(def.Docs),
+		}
+		if src := localRepoFile(repo, commitID, def.File, files); src != nil && int(def.DefEnd) <= len(src) && def.DefStart <= def.DefEnd {
+			rec.Context = sourceContext(src, int(def.DefStart), int(def.DefEnd), c.ContextLines)
+		}
+		records[i] = rec
+	}
+	return records, nil
+}
+
+// sampled returns whether def is in the --sample. Defs are chosen by
+// hashing their keys (and the seed), so that the same defs are chosen
+// each time (and in each commit).
+func (c *StoreExportMLCmd) sampled(repo string, def *graph.Def) bool {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s", c.Seed, repo, def.UnitType, def.Unit, def.Path)
+	return float64(h.Sum64()%1000000) < c.Sample*1000000
+}
+
+// repoLicense returns the license of repo in licenses, which maps
+// repos and repo prefixes (ending in "/") to licenses. The longest
+// matching prefix wins.
+func repoLicense(licenses map[string]string, repo string) string {
+	if l, present := licenses[repo]; present {
+		return l
+	}
+	var prefixes []string
+	for prefix := range licenses {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(repo, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return ""
+	}
+	sort.Strings(prefixes)
+	return licenses[prefixes[len(prefixes)-1]]
+}
+
+// mlDoc returns a def's docs, preferring plain text.
+func mlDoc(docs []graph.DefDoc) string {
+	for _, doc := range docs {
+		if doc.Format == "text/plain" {
+			return strings.TrimSpace(doc.Data)
+		}
+	}
+	if len(docs) > 0 {
+		return strings.TrimSpace(docs[0].Data)
+	}
+	return ""
+}
+
+// sourceContext returns the lines of src that contain the byte range
+// [start, end), plus n lines before and after.
+func sourceContext(src []byte, start, end, n int) string {
+	lineStart := bytes.LastIndex(src[:start], []byte("\n")) + 1
+	for i := 0; i < n && lineStart > 0; i++ {
+		lineStart = bytes.LastIndex(src[:lineStart-1], []byte("\n")) + 1
+	}
+	lineEnd := end
+	for i := 0; i <= n && lineEnd < len(src); i++ {
+		if j := bytes.IndexByte(src[lineEnd:], '\n'); j == -1 {
+			lineEnd = len(src)
+		} else {
+			lineEnd += j + 1
+		}
+	}
+	return strings.TrimRight(string(src[lineStart:lineEnd]), "\n")
+}
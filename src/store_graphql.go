@@ -0,0 +1,670 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// storeGraphQLSchema describes the GraphQL schema served by 'src store
+// graphql' and the store API server's /graphql endpoint. Arguments
+// that are omitted match all values.
+const storeGraphQLSchema = `type Query {
+  defs(repo: String, commit: String, unitType: String, unit: String, file: String, query: String, kind: String, exported: Boolean, limit: Int): [Def]
+  def(repo: String, commit: String, unitType: String, unit: String, path: String!): Def
+  refs(repo: String, commit: String, unitType: String, unit: String, file: String, defRepo: String, defUnitType: String, defUnit: String, defPath: String, limit: Int): [Ref]
+  units(repo: String, commit: String, unitType: String, unit: String, file: String): [Unit]
+  file(repo: String, commit: String, path: String!): File
+}
+
+type Def {
+  repo: String, commitID: String, unitType: String, unit: String, path: String
+  name: String, kind: String, exported: Boolean, local: Boolean, test: Boolean
+  start: Int, end: Int, treePath: String, data: String
+  docs(format: String): [Doc]
+  file: File
+  sourceUnit: Unit
+  refs(allRepos: Boolean, limit: Int): [Ref]   # allRepos: also refs from other repos
+}
+
+type Doc { format: String, data: String }
+
+type Ref {
+  repo: String, commitID: String, unitType: String, unit: String
+  defRepo: String, defUnitType: String, defUnit: String, defPath: String
+  isDef: Boolean, start: Int, end: Int
+  def: Def   # null if the def is not in the store
+  file: File
+  sourceUnit: Unit
+}
+
+type Unit {
+  repo: String, commitID: String, type: String, name: String
+  language: String, toolchain: String, dir: String, files: [String]
+  defs(file: String, exported: Boolean, limit: Int): [Def]
+}
+
+type File {
+  repo: String, commitID: String, path: String
+  units: [Unit]
+  defs(limit: Int): [Def]
+  refs(limit: Int): [Ref]
+}`
+
+// gqlStore is the store that GraphQL queries are executed against.
+type gqlStore interface {
+	store.TreeStore
+}
+
+// gqlScope is the repo and commit that a GraphQL field's store
+// queries are restricted to. Empty fields match all values.
+type gqlScope struct {
+	repo, commitID string
+}
+
+func (sc gqlScope) defFilters() []store.DefFilter {
+	var fs []store.DefFilter
+	if sc.repo != "" {
+		fs = append(fs, store.ByRepos(sc.repo))
+	}
+	if sc.commitID != "" {
+		fs = append(fs, store.ByCommitIDs(sc.commitID))
+	}
+	return fs
+}
+
+func (sc gqlScope) refFilters() []store.RefFilter {
+	var fs []store.RefFilter
+	if sc.repo != "" {
+		fs = append(fs, store.ByRepos(sc.repo))
+	}
+	if sc.commitID != "" {
+		fs = append(fs, store.ByCommitIDs(sc.commitID))
+	}
+	return fs
+}
+
+func (sc gqlScope) unitFilters() []store.UnitFilter {
+	var fs []store.UnitFilter
+	if sc.repo != "" {
+		fs = append(fs, store.ByRepos(sc.repo))
+	}
+	if sc.commitID != "" {
+		fs = append(fs, store.ByCommitIDs(sc.commitID))
+	}
+	return fs
+}
+
+// gqlUnitArg returns the source unit specified by the unitType and
+// unit arguments, or nil if neither is given.
+func gqlUnitArg(args gqlArgs) (*unit.ID2, error) {
+	unitType, err := args.string("unitType")
+	if err != nil {
+		return nil, err
+	}
+	name, err := args.string("unit")
+	if err != nil {
+		return nil, err
+	}
+	if unitType == "" && name == "" {
+		return nil, nil
+	}
+	if unitType == "" || name == "" {
+		return nil, fmt.Errorf("arguments unitType and unit must be given together")
+	}
+	return &unit.ID2{Type: unitType, Name: name}, nil
+}
+
+// gqlScopeArgs returns the scope specified by the repo and commit
+// arguments.
+func gqlScopeArgs(args gqlArgs) (gqlScope, error) {
+	repo, err := args.string("repo")
+	if err != nil {
+		return gqlScope{}, err
+	}
+	commitID, err := args.string("commit")
+	if err != nil {
+		return gqlScope{}, err
+	}
+	return gqlScope{repo, commitID}, nil
+}
+
+// gqlQuery is the GraphQL query type.
+type gqlQuery struct {
+	s gqlStore
+}
+
+func (q *gqlQuery) typename() string { return "Query" }
+
+func (q *gqlQuery) resolve(field string, args gqlArgs) (interface{}, error) {
+	switch field {
+	case "defs", "def":
+		allowed := []string{"repo", "commit", "unitType", "unit", "file", "query", "kind", "exported", "limit"}
+		if field == "def" {
+			allowed = []string{"repo", "commit", "unitType", "unit", "path"}
+		}
+		if err := args.check(allowed...); err != nil {
+			return nil, err
+		}
+		sc, err := gqlScopeArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		fs := sc.defFilters()
+		if u, err := gqlUnitArg(args); err != nil {
+			return nil, err
+		} else if u != nil {
+			fs = append(fs, store.ByUnits(*u))
+		}
+		if field == "def" {
+			p, err := args.string("path")
+			if err != nil {
+				return nil, err
+			}
+			if p == "" {
+				return nil, fmt.Errorf("argument \"path\" is required")
+			}
+			defs, err := q.s.Defs(append(fs, store.ByDefPath(p))...)
+			if err != nil || len(defs) == 0 {
+				return nil, err
+			}
+			return &gqlDef{q, defs[0]}, nil
+		}
+		fs, err = gqlDefFilters(args, fs)
+		if err != nil {
+			return nil, err
+		}
+		return q.defs(fs...)
+
+	case "refs":
+		if err := args.check("repo", "commit", "unitType", "unit", "file", "defRepo", "defUnitType", "defUnit", "defPath", "limit"); err != nil {
+			return nil, err
+		}
+		sc, err := gqlScopeArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		fs := sc.refFilters()
+		if u, err := gqlUnitArg(args); err != nil {
+			return nil, err
+		} else if u != nil {
+			fs = append(fs, store.ByUnits(*u))
+		}
+		if file, err := args.string("file"); err != nil {
+			return nil, err
+		} else if file != "" {
+			fs = append(fs, store.ByFiles(path.Clean(file)))
+		}
+		var def graph.RefDefKey
+		for name, v := range map[string]*string{"defRepo": &def.DefRepo, "defUnitType": &def.DefUnitType, "defUnit": &def.DefUnit, "defPath": &def.DefPath} {
+			if *v, err = args.string(name); err != nil {
+				return nil, err
+			}
+		}
+		if def.DefPath != "" {
+			fs = append(fs, store.ByRefDef(def))
+		} else if def != (graph.RefDefKey{}) {
+			return nil, fmt.Errorf("argument \"defPath\" is required if defRepo, defUnitType, or defUnit is given")
+		}
+		if limit, err := args.int("limit"); err != nil {
+			return nil, err
+		} else if limit > 0 {
+			fs = append(fs, store.Limit(limit, 0))
+		}
+		return q.refs(fs...)
+
+	case "units":
+		if err := args.check("repo", "commit", "unitType", "unit", "file"); err != nil {
+			return nil, err
+		}
+		sc, err := gqlScopeArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		fs := sc.unitFilters()
+		if u, err := gqlUnitArg(args); err != nil {
+			return nil, err
+		} else if u != nil {
+			fs = append(fs, store.ByUnits(*u))
+		}
+		if file, err := args.string("file"); err != nil {
+			return nil, err
+		} else if file != "" {
+			fs = append(fs, store.ByFiles(path.Clean(file)))
+		}
+		return q.units(fs...)
+
+	case "file":
+		if err := args.check("repo", "commit", "path"); err != nil {
+			return nil, err
+		}
+		sc, err := gqlScopeArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		p, err := args.string("path")
+		if err != nil {
+			return nil, err
+		}
+		if p == "" {
+			return nil, fmt.Errorf("argument \"path\" is required")
+		}
+		return &gqlFile{q, sc, path.Clean(p)}, nil
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+// gqlDefFilters appends the def filters specified by the file, query,
+// kind, exported, and limit arguments (those that are present) to fs.
+func gqlDefFilters(args gqlArgs, fs []store.DefFilter) ([]store.DefFilter, error) {
+	if file, err := args.string("file"); err != nil {
+		return nil, err
+	} else if file != "" {
+		fs = append(fs, store.ByFiles(path.Clean(file)))
+	}
+	if query, err := args.string("query"); err != nil {
+		return nil, err
+	} else if query != "" {
+		fs = append(fs, store.ByDefQuery(query))
+	}
+	if kind, err := args.string("kind"); err != nil {
+		return nil, err
+	} else if kind != "" {
+		fs = append(fs, store.DefFilterFunc(func(def *graph.Def) bool { return def.Kind == kind }))
+	}
+	if _, present := args["exported"]; present {
+		exported, err := args.bool("exported")
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, store.DefFilterFunc(func(def *graph.Def) bool { return def.Exported == exported }))
+	}
+	if limit, err := args.int("limit"); err != nil {
+		return nil, err
+	} else if limit > 0 {
+		fs = append(fs, store.Limit(limit, 0))
+	}
+	return fs, nil
+}
+
+func (q *gqlQuery) defs(fs ...store.DefFilter) ([]gqlObject, error) {
+	defs, err := q.s.Defs(fs...)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]gqlObject, len(defs))
+	for i, def := range defs {
+		objs[i] = &gqlDef{q, def}
+	}
+	return objs, nil
+}
+
+func (q *gqlQuery) refs(fs ...store.RefFilter) ([]gqlObject, error) {
+	refs, err := q.s.Refs(fs...)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]gqlObject, len(refs))
+	for i, ref := range refs {
+		objs[i] = &gqlRef{q, ref}
+	}
+	return objs, nil
+}
+
+func (q *gqlQuery) units(fs ...store.UnitFilter) ([]gqlObject, error) {
+	units, err := q.s.Units(fs...)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]gqlObject, len(units))
+	for i, u := range units {
+		objs[i] = &gqlUnit{q, u}
+	}
+	return objs, nil
+}
+
+// unit returns the source unit (at the scope's repo and commit), or
+// nil if it is not in the store.
+func (q *gqlQuery) unit(sc gqlScope, u unit.ID2) (interface{}, error) {
+	units, err := q.s.Units(append(sc.unitFilters(), store.ByUnits(u))...)
+	if err != nil || len(units) == 0 {
+		return nil, err
+	}
+	return &gqlUnit{q, units[0]}, nil
+}
+
+type gqlDef struct {
+	q   *gqlQuery
+	def *graph.Def
+}
+
+func (d *gqlDef) typename() string { return "Def" }
+
+func (d *gqlDef) resolve(field string, args gqlArgs) (interface{}, error) {
+	def := d.def
+	switch field {
+	case "repo":
+		return def.Repo, nil
+	case "commitID":
+		return def.CommitID, nil
+	case "unitType":
+		return def.UnitType, nil
+	case "unit":
+		return def.Unit, nil
+	case "path":
+		return def.Path, nil
+	case "name":
+		return def.Name, nil
+	case "kind":
+		return def.Kind, nil
+	case "exported":
+		return def.Exported, nil
+	case "local":
+		return def.Local, nil
+	case "test":
+		return def.Test, nil
+	case "start":
+		return def.DefStart, nil
+	case "end":
+		return def.DefEnd, nil
+	case "treePath":
+		return def.TreePath, nil
+	case "data":
+		if len(def.Data) == 0 {
+			return nil, nil
+		}
+		return string(def.Data), nil
+	case "docs":
+		if err := args.check("format"); err != nil {
+			return nil, err
+		}
+		format, err := args.string("format")
+		if err != nil {
+			return nil, err
+		}
+		var docs []gqlObject
+		for i := range def.Docs {
+			if format == "" || def.Docs[i].Format == format {
+				docs = append(docs, gqlDoc(def.Docs[i]))
+			}
+		}
+		return docs, nil
+	case "file":
+		return &gqlFile{d.q, gqlScope{def.Repo, def.CommitID}, def.File}, nil
+	case "sourceUnit":
+		return d.q.unit(gqlScope{def.Repo, def.CommitID}, unit.ID2{Type: def.UnitType, Name: def.Unit})
+	case "refs":
+		if err := args.check("allRepos", "limit"); err != nil {
+			return nil, err
+		}
+		allRepos, err := args.bool("allRepos")
+		if err != nil {
+			return nil, err
+		}
+		fs := []store.RefFilter{store.ByRefDef(graph.RefDefKey{DefRepo: def.Repo, DefUnitType: def.UnitType, DefUnit: def.Unit, DefPath: def.Path})}
+		if !allRepos {
+			fs = append(fs, gqlScope{def.Repo, def.CommitID}.refFilters()...)
+		}
+		if limit, err := args.int("limit"); err != nil {
+			return nil, err
+		} else if limit > 0 {
+			fs = append(fs, store.Limit(limit, 0))
+		}
+		return d.q.refs(fs...)
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+type gqlDoc graph.DefDoc
+
+func (d gqlDoc) typename() string { return "Doc" }
+
+func (d gqlDoc) resolve(field string, args gqlArgs) (interface{}, error) {
+	switch field {
+	case "format":
+		return d.Format, nil
+	case "data":
+		return d.Data, nil
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+type gqlRef struct {
+	q   *gqlQuery
+	ref *graph.Ref
+}
+
+func (r *gqlRef) typename() string { return "Ref" }
+
+func (r *gqlRef) resolve(field string, args gqlArgs) (interface{}, error) {
+	ref := r.ref
+	switch field {
+	case "repo":
+		return ref.Repo, nil
+	case "commitID":
+		return ref.CommitID, nil
+	case "unitType":
+		return ref.UnitType, nil
+	case "unit":
+		return ref.Unit, nil
+	case "defRepo":
+		return ref.DefRepo, nil
+	case "defUnitType":
+		return ref.DefUnitType, nil
+	case "defUnit":
+		return ref.DefUnit, nil
+	case "defPath":
+		return ref.DefPath, nil
+	case "isDef":
+		return ref.Def, nil
+	case "start":
+		return ref.Start, nil
+	case "end":
+		return ref.End, nil
+	case "def":
+		// As in 'src store hover', a def in the same repo is at the
+		// same commit.
+		fs := []store.DefFilter{store.ByDefPath(ref.DefPath)}
+		if ref.DefUnitType != "" && ref.DefUnit != "" {
+			fs = append(fs, store.ByUnits(unit.ID2{Type: ref.DefUnitType, Name: ref.DefUnit}))
+		}
+		if ref.DefRepo != "" {
+			fs = append(fs, store.ByRepos(ref.DefRepo))
+		}
+		if ref.DefRepo == ref.Repo && ref.CommitID != "" {
+			fs = append(fs, store.ByCommitIDs(ref.CommitID))
+		}
+		defs, err := r.q.s.Defs(fs...)
+		if err != nil || len(defs) == 0 {
+			return nil, err
+		}
+		return &gqlDef{r.q, defs[0]}, nil
+	case "file":
+		return &gqlFile{r.q, gqlScope{ref.Repo, ref.CommitID}, ref.File}, nil
+	case "sourceUnit":
+		return r.q.unit(gqlScope{ref.Repo, ref.CommitID}, unit.ID2{Type: ref.UnitType, Name: ref.Unit})
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+type gqlUnit struct {
+	q *gqlQuery
+	u *unit.SourceUnit
+}
+
+func (u *gqlUnit) typename() string { return "Unit" }
+
+func (u *gqlUnit) resolve(field string, args gqlArgs) (interface{}, error) {
+	switch field {
+	case "repo":
+		return u.u.Repo, nil
+	case "commitID":
+		return u.u.CommitID, nil
+	case "type":
+		return u.u.Type, nil
+	case "name":
+		return u.u.Name, nil
+	case "language":
+		return u.u.Language, nil
+	case "toolchain":
+		return u.u.Toolchain, nil
+	case "dir":
+		return u.u.Dir, nil
+	case "files":
+		return u.u.Files, nil
+	case "defs":
+		if err := args.check("file", "exported", "limit"); err != nil {
+			return nil, err
+		}
+		fs := append(gqlScope{u.u.Repo, u.u.CommitID}.defFilters(), store.ByUnits(u.u.ID2()))
+		fs, err := gqlDefFilters(args, fs)
+		if err != nil {
+			return nil, err
+		}
+		return u.q.defs(fs...)
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+// gqlFile is a file in a repo at a commit.
+type gqlFile struct {
+	q    *gqlQuery
+	sc   gqlScope
+	path string
+}
+
+func (f *gqlFile) typename() string { return "File" }
+
+func (f *gqlFile) resolve(field string, args gqlArgs) (interface{}, error) {
+	switch field {
+	case "repo":
+		return f.sc.repo, nil
+	case "commitID":
+		return f.sc.commitID, nil
+	case "path":
+		return f.path, nil
+	case "units":
+		return f.q.units(append(f.sc.unitFilters(), store.ByFiles(f.path))...)
+	case "defs", "refs":
+		if err := args.check("limit"); err != nil {
+			return nil, err
+		}
+		limit, err := args.int("limit")
+		if err != nil {
+			return nil, err
+		}
+		if field == "defs" {
+			fs := append(f.sc.defFilters(), store.ByFiles(f.path))
+			if limit > 0 {
+				fs = append(fs, store.Limit(limit, 0))
+			}
+			return f.q.defs(fs...)
+		}
+		fs := append(f.sc.refFilters(), store.ByFiles(f.path))
+		if limit > 0 {
+			fs = append(fs, store.Limit(limit, 0))
+		}
+		return f.q.refs(fs...)
+	}
+	return nil, fmt.Errorf("no such field")
+}
+
+type StoreGraphQLCmd struct {
+	Schema    bool   `long:"schema" description:"print the GraphQL schema and exit"`
+	Variables string `long:"variables" description:"JSON object of the values of the query's variables"`
+
+	Args struct {
+		Query string `name:"QUERY" description:"GraphQL query (default: read from stdin)"`
+	} `positional-args:"yes"`
+}
+
+var storeGraphQLCmd StoreGraphQLCmd
+
+func (c *StoreGraphQLCmd) Execute(args []string) error {
+	if c.Schema {
+		fmt.Println(storeGraphQLSchema)
+		return nil
+	}
+
+	query := c.Args.Query
+	if query == "" || query == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		query = string(b)
+	}
+	var vars map[string]interface{}
+	if c.Variables != "" {
+		if err := json.Unmarshal([]byte(c.Variables), &vars); err != nil {
+			return fmt.Errorf("--variables: %s", err)
+		}
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	gs, ok := s.(gqlStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing units, defs, and refs", s)
+	}
+	resp := execGraphQL(context.Background(), &gqlQuery{s: gs}, query, vars)
+	PrintJSON(resp, "  ")
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("query failed with %d errors", len(resp.Errors))
+	}
+	return nil
+}
+
+// graphQLHandler serves GraphQL queries over HTTP. Queries are given
+// in the "query" field of a JSON POST body (with their variables in
+// the "variables" field) or in a query=Q URL query parameter (for GET
+// requests). A query stops when its client goes away.
+type graphQLHandler struct {
+	s gqlStore
+}
+
+func (h *graphQLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string
+		Variables map[string]interface{}
+	}
+	switch r.Method {
+	case "GET":
+		req.Query = r.URL.Query().Get("query")
+	case "POST":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			status := http.StatusBadRequest
+			if isRequestTooLarge(err) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		http.Error(w, "method must be GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "query: empty", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	resp := execGraphQL(ctx, &gqlQuery{s: store.ContextTreeStore(ctx, h.s)}, req.Query, req.Variables)
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Writing GraphQL response: %s", err)
+	}
+}
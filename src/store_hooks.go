@@ -0,0 +1,99 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// storeHooks are run after data is imported into the store and after
+// the store's indexes are built, so that downstream systems (e.g.,
+// search indexers and caches) can refresh their copies of the data.
+// They are configured in the Hooks field of the store --config.
+type storeHooks struct {
+	PostImport []storeHook // run after data is imported (before indexes are built)
+	PostIndex  []storeHook // run after indexes are built
+}
+
+// A storeHook either runs a command or POSTs to a URL. The payload (a
+// JSON-encoded hookEvent) is written to the command's stdin or is the
+// body of the POST request.
+type storeHook struct {
+	// Command is run by the shell. The SRCLIB_HOOK_EVENT,
+	// SRCLIB_REPO, and SRCLIB_COMMIT environment variables are set
+	// to the corresponding fields of the payload.
+	Command string `json:",omitempty"`
+
+	// URL is the URL to POST the payload to.
+	URL string `json:",omitempty"`
+}
+
+// hookEvent is the payload sent to hooks.
+type hookEvent struct {
+	Event    string     `json:"event"` // "import" or "index"
+	Repo     string     `json:"repo,omitempty"`
+	CommitID string     `json:"commit_id"`
+	Units    []unit.ID2 `json:"units"`
+	Time     time.Time  `json:"time"`
+}
+
+// hookTimeout is the max duration of a hook's HTTP request.
+const hookTimeout = 30 * time.Second
+
+// runHooks runs the hooks for event e. Hooks are run sequentially.
+// Failures are logged but are not returned, because the data was
+// imported (or indexed) successfully.
+func runHooks(hooks []storeHook, e *hookEvent) {
+	if len(hooks) == 0 {
+		return
+	}
+	e.Time = time.Now().UTC()
+	payload, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	for _, h := range hooks {
+		if err := h.run(e, payload); err != nil {
+			logger.Warnf("post-%s hook failed: %s", e.Event, err)
+		}
+	}
+}
+
+func (h storeHook) run(e *hookEvent, payload []byte) error {
+	switch {
+	case h.Command != "" && h.URL != "":
+		return fmt.Errorf("hook has both Command (%q) and URL (%q)", h.Command, h.URL)
+
+	case h.Command != "":
+		logger.Debugf("# Running post-%s hook: %s", e.Event, h.Command)
+		cmd := exec.Command("sh", "-c", h.Command)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		cmd.Env = append(os.Environ(), "SRCLIB_HOOK_EVENT="+e.Event, "SRCLIB_REPO="+e.Repo, "SRCLIB_COMMIT="+e.CommitID)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("command %q: %s", h.Command, err)
+		}
+
+	case h.URL != "":
+		logger.Debugf("# POSTing post-%s hook payload to %s", e.Event, h.URL)
+		cl := &http.Client{Timeout: hookTimeout}
+		resp, err := cl.Post(h.URL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("POST %s: HTTP %s", h.URL, resp.Status)
+		}
+
+	default:
+		return fmt.Errorf("hook has neither Command nor URL")
+	}
+	return nil
+}
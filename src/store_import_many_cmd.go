@@ -0,0 +1,277 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/rog-go/parallel"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreImportManyCmd struct {
+	WorkDir  string `long:"work-dir" description:"directory to clone repos into (default: $SRCLIBCACHE/import-many)"`
+	Parallel int    `short:"p" long:"parallel" description:"number of repos to process concurrently" default:"4"`
+	Status   string `long:"status" description:"status file, used to resume (default: WORK-DIR/status.json)"`
+	NoUpdate bool   `long:"no-update" description:"don't fetch updates for repos that were already cloned"`
+
+	Args struct {
+		File string `name:"FILE" description:"file listing repo clone URLs or local paths, one per line (default: stdin)"`
+	} `positional-args:"yes"`
+}
+
+var storeImportManyCmd StoreImportManyCmd
+
+// Repo states in the import-many status file.
+const (
+	importManyCloned   = "cloned"
+	importManyBuilt    = "built"
+	importManyImported = "imported"
+	importManyFailed   = "failed"
+)
+
+// importManyRepoStatus is the status of a repo in the import-many
+// status file.
+type importManyRepoStatus struct {
+	URI      string `json:",omitempty"`
+	CommitID string `json:",omitempty"`
+	State    string
+	Error    string `json:",omitempty"`
+	Updated  time.Time
+}
+
+// importManyStatus is the import-many status file, keyed on the repo
+// clone URLs or paths given as input.
+type importManyStatus struct {
+	mu    sync.Mutex
+	file  string
+	Repos map[string]*importManyRepoStatus
+}
+
+func (s *importManyStatus) set(repo string, rs *importManyRepoStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs.Updated = time.Now().UTC()
+	s.Repos[repo] = rs
+	if err := writeJSONFile(s.file, s); err != nil {
+		logger.Errorf("Writing status file %s: %s", s.file, err)
+	}
+}
+
+func (s *importManyStatus) get(repo string) *importManyRepoStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Repos[repo]
+}
+
+func (c *StoreImportManyCmd) Execute(args []string) error {
+	if c.Parallel < 1 {
+		return newCmdError(ExitUsage, fmt.Errorf("--parallel must be positive"))
+	}
+	repos, err := c.readRepoList()
+	if err != nil {
+		return err
+	}
+
+	storeCmd.allowCreate = true
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	if _, ok := s.(store.MultiRepoStore); !ok {
+		return newCmdError(ExitUsage, fmt.Errorf("import-many requires a MultiRepoStore (use --type=MultiRepoStore)"))
+	}
+	conf, err := storeCmd.config()
+	if err != nil {
+		return err
+	}
+
+	if c.WorkDir == "" {
+		c.WorkDir = filepath.Join(srclib.CacheDir, "import-many")
+	}
+	if err := os.MkdirAll(c.WorkDir, 0700); err != nil {
+		return err
+	}
+	if c.Status == "" {
+		c.Status = filepath.Join(c.WorkDir, "status.json")
+	}
+	status := &importManyStatus{file: c.Status, Repos: map[string]*importManyRepoStatus{}}
+	if err := readJSONFile(c.Status, status); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading status file %s: %s", c.Status, err)
+	}
+
+	prog, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	if prog, err = filepath.Abs(prog); err != nil {
+		return err
+	}
+
+	var (
+		mu     sync.Mutex
+		failed []string
+	)
+	par := parallel.NewRun(c.Parallel)
+	for _, repo_ := range repos {
+		repo := repo_
+		par.Do(func() error {
+			if err := c.importRepo(prog, s, &conf.Hooks, status, repo); err != nil {
+				logger.Errorf("%s: %s", repo, err)
+				rs := &importManyRepoStatus{State: importManyFailed, Error: err.Error()}
+				if prev := status.get(repo); prev != nil {
+					rs.URI, rs.CommitID = prev.URI, prev.CommitID
+				}
+				status.set(repo, rs)
+				mu.Lock()
+				failed = append(failed, repo)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	par.Wait()
+
+	logger.Infof("# Imported %d of %d repos (status in %s).", len(repos)-len(failed), len(repos), c.Status)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return newCmdError(ExitPartialImport, fmt.Errorf("%d repos failed (run the same command again to retry them): %s", len(failed), strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// readRepoList reads the list of repos from the FILE argument (or
+// stdin). Blank lines and lines beginning with "#" are ignored.
+func (c *StoreImportManyCmd) readRepoList() ([]string, error) {
+	var r io.Reader = os.Stdin
+	if c.Args.File != "" && c.Args.File != "-" {
+		f, err := os.Open(c.Args.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var repos []string
+	seen := map[string]struct{}{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if _, dup := seen[line]; line == "" || strings.HasPrefix(line, "#") || dup {
+			continue
+		}
+		seen[line] = struct{}{}
+		repos = append(repos, line)
+	}
+	return repos, scanner.Err()
+}
+
+// isCloneURL returns whether repo (from the repo list) is a clone URL
+// (as opposed to a local path).
+func isCloneURL(repo string) bool {
+	return strings.Contains(repo, "://") || strings.HasPrefix(repo, "git@")
+}
+
+// importRepo clones (or updates) the repo, builds it with `src config`
+// and `src make`, and imports its build data. Steps already completed
+// for the repo's current commit (according to the status file) are
+// skipped.
+func (c *StoreImportManyCmd) importRepo(prog string, s interface{}, hooks *storeHooks, status *importManyStatus, repo string) error {
+	dir := repo
+	if isCloneURL(repo) {
+		dir = filepath.Join(c.WorkDir, url.QueryEscape(repo))
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			logger.Infof("# %s: cloning", repo)
+			if err := runImportManyCmd("", "git", "clone", "--quiet", repo, dir); err != nil {
+				return err
+			}
+		} else if !c.NoUpdate {
+			logger.Infof("# %s: updating", repo)
+			if err := runImportManyCmd(dir, "git", "fetch", "--quiet", "origin"); err != nil {
+				return err
+			}
+			if err := runImportManyCmd(dir, "git", "reset", "--quiet", "--hard", "origin/HEAD"); err != nil {
+				return err
+			}
+		}
+	}
+
+	lrepo, err := OpenRepo(dir)
+	if err != nil {
+		return err
+	}
+	uri := lrepo.URI()
+	if uri == "" {
+		return fmt.Errorf("can't determine repo URI (from clone URL %q)", lrepo.CloneURL)
+	}
+	rs := &importManyRepoStatus{URI: uri, CommitID: lrepo.CommitID}
+	if prev := status.get(repo); prev != nil && prev.CommitID == lrepo.CommitID && prev.State == importManyImported {
+		logger.Infof("# %s: already imported at commit %s; skipping", repo, lrepo.CommitID)
+		return nil
+	} else if prev == nil || prev.CommitID != lrepo.CommitID || prev.State == importManyFailed {
+		rs.State = importManyCloned
+		status.set(repo, rs)
+	} else {
+		rs.State = prev.State
+	}
+
+	if rs.State == importManyCloned {
+		logger.Infof("# %s: building commit %s", repo, lrepo.CommitID)
+		if err := runImportManyCmd(lrepo.RootDir, prog, "config"); err != nil {
+			return err
+		}
+		if err := runImportManyCmd(lrepo.RootDir, prog, "make"); err != nil {
+			return newCmdError(ExitToolchainFailure, err)
+		}
+		rs.State = importManyBuilt
+		status.set(repo, rs)
+	}
+
+	logger.Infof("# %s: importing commit %s", repo, lrepo.CommitID)
+	localStore, err := localBuildStore(lrepo.RootDir)
+	if err != nil {
+		return err
+	}
+	opt := ImportOpt{Repo: uri, CommitID: lrepo.CommitID, Hooks: hooks, MaxMemory: int64(storeCmd.MaxMemory), Source: fmt.Sprintf("local repository (root dir %s, commit %s)", lrepo.RootDir, lrepo.CommitID)}
+	unlock, err := lockCommit(s, uri, lrepo.CommitID, true)
+	if err != nil {
+		return err
+	}
+	err = Import(localStore.Commit(lrepo.CommitID), s, opt)
+	if err2 := unlock(); err2 != nil {
+		logger.Warnf("releasing store lock: %s", err2)
+	}
+	if err != nil {
+		return err
+	}
+	rs.State = importManyImported
+	status.set(repo, rs)
+	return nil
+}
+
+// runImportManyCmd runs a command in dir, returning an error that
+// includes its output if it fails. The output is logged at the debug
+// level.
+func runImportManyCmd(dir, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	logger.Debugf("%s %s (in %s):\n%s", name, strings.Join(args, " "), dir, out.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s\n\noutput was:\n%s", name, strings.Join(args, " "), err, out.Bytes())
+	}
+	return nil
+}
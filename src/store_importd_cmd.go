@@ -0,0 +1,304 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kr/fs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// An import spool is a directory of build data bundles waiting to be
+// imported by `src store importd`. Each bundle is a subdirectory that
+// contains a manifest file (spoolManifestFile) and the build data for
+// a single repo and commit (in the spoolDataDir subdirectory, laid out
+// like a commit's dir in .srclib-cache).
+//
+// Bundles are imported in order of their names. Names beginning with
+// "." are ignored, so that producers can write a bundle under a
+// temporary name and then rename it into place (see `src store
+// enqueue`). After a bundle is imported, it is moved to the
+// spoolDoneDir or spoolFailedDir subdirectory, along with a status
+// file describing the import.
+const (
+	spoolManifestFile = "bundle.json"
+	spoolDataDir      = "data"
+	spoolStatusFile   = "status.json"
+	spoolDoneDir      = ".done"
+	spoolFailedDir    = ".failed"
+)
+
+// spoolManifest is the format of a bundle's manifest file.
+type spoolManifest struct {
+	Repo     string
+	CommitID string
+}
+
+type StoreImportdCmd struct {
+	Spool   string        `long:"spool" description:"spool directory to watch for build data bundles" required:"yes"`
+	Poll    time.Duration `long:"poll" description:"how often to check the spool directory for new bundles" default:"5s"`
+	Retries int           `long:"retries" description:"max number of times to retry a failed import" default:"3"`
+	HTTP    string        `long:"http" description:"serve import status (as JSON) and commit exports (see 'src store dump') over HTTP on this address (e.g., :3090)"`
+	NoIndex bool          `long:"no-index" description:"don't build indexes (indexes inside a single source unit are always built)"`
+}
+
+var storeImportdCmd StoreImportdCmd
+
+// importdJob is the status of the import of a bundle.
+type importdJob struct {
+	Bundle   string
+	Repo     string `json:",omitempty"`
+	CommitID string `json:",omitempty"`
+	Attempts int
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Error    string    `json:",omitempty"`
+}
+
+// importdStatus is the status of the import daemon, which is served
+// over HTTP if --http is given.
+type importdStatus struct {
+	mu        sync.Mutex
+	Queued    []string    // names of bundles waiting to be imported
+	Current   *importdJob `json:",omitempty"`
+	Recent    []*importdJob
+	Succeeded int
+	Failed    int
+}
+
+// maxRecentImportdJobs is the number of finished jobs kept in the
+// status.
+const maxRecentImportdJobs = 100
+
+func (s *importdStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *StoreImportdCmd) Execute(args []string) error {
+	storeCmd.allowCreate = true
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	conf, err := storeCmd.config()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{spoolDoneDir, spoolFailedDir} {
+		if err := os.MkdirAll(filepath.Join(c.Spool, dir), 0700); err != nil {
+			return err
+		}
+	}
+
+	status := &importdStatus{}
+	if c.HTTP != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", status)
+		mux.Handle("/export", &dumpHandler{s: s})
+		mux.Handle("/metrics", daemonMetrics)
+		go func() {
+			logger.Infof("# Serving import status and exports on %s.", c.HTTP)
+			if err := http.ListenAndServe(c.HTTP, mux); err != nil {
+				logger.Fatalf("Serving import status: %s", err)
+			}
+		}()
+	}
+
+	logger.Infof("# Watching %s for build data bundles.", c.Spool)
+	for {
+		bundles, err := c.bundles()
+		if err != nil {
+			return err
+		}
+		status.mu.Lock()
+		status.Queued = bundles
+		status.mu.Unlock()
+		daemonMetrics.setQueued(len(bundles))
+
+		for len(bundles) > 0 {
+			c.importBundle(s, bundles[0], &conf.Hooks, status)
+			bundles = bundles[1:]
+			status.mu.Lock()
+			status.Queued = bundles
+			status.mu.Unlock()
+			daemonMetrics.setQueued(len(bundles))
+		}
+		time.Sleep(c.Poll)
+	}
+}
+
+// bundles returns the names of the bundles in the spool directory
+// that are ready to be imported, in the order they should be
+// imported.
+func (c *StoreImportdCmd) bundles() ([]string, error) {
+	fis, err := ioutil.ReadDir(c.Spool)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// importBundle imports a bundle (retrying on failure) and moves it to
+// the done or failed directory.
+func (c *StoreImportdCmd) importBundle(s interface{}, name string, hooks *storeHooks, status *importdStatus) {
+	job := &importdJob{Bundle: name, Started: time.Now()}
+	status.mu.Lock()
+	status.Current = job
+	status.mu.Unlock()
+
+	dir := filepath.Join(c.Spool, name)
+	err := func() error {
+		var m spoolManifest
+		if err := readJSONFile(filepath.Join(dir, spoolManifestFile), &m); err != nil {
+			return err
+		}
+		if m.CommitID == "" {
+			return fmt.Errorf("bundle manifest has no CommitID")
+		}
+		job.Repo, job.CommitID = m.Repo, m.CommitID
+
+		opt := ImportOpt{Repo: m.Repo, CommitID: m.CommitID, NoIndex: c.NoIndex, Hooks: hooks, MaxMemory: int64(storeCmd.MaxMemory), Source: "bundle " + name}
+		bdfs := rwvfs.OS(filepath.Join(dir, spoolDataDir))
+		for backoff := time.Second; ; backoff *= 2 {
+			job.Attempts++
+			logger.Infof("# Importing bundle %s (repo %s, commit %s; attempt %d).", name, m.Repo, m.CommitID, job.Attempts)
+			err := c.importOnce(s, bdfs, opt)
+			if err == nil || !retryableImportError(err) || job.Attempts > c.Retries {
+				return err
+			}
+			logger.Warnf("importing bundle %s failed (retrying in %s): %s", name, backoff, err)
+			time.Sleep(backoff)
+		}
+	}()
+	job.Finished = time.Now()
+
+	dest := spoolDoneDir
+	if err != nil {
+		job.Error = err.Error()
+		dest = spoolFailedDir
+		logger.Errorf("Importing bundle %s failed: %s", name, err)
+	} else {
+		logger.Infof("# Imported bundle %s in %s.", name, job.Finished.Sub(job.Started))
+	}
+	if err := writeJSONFile(filepath.Join(dir, spoolStatusFile), job); err != nil {
+		logger.Errorf("Writing status of bundle %s: %s", name, err)
+	}
+	if err := os.Rename(dir, filepath.Join(c.Spool, dest, name)); err != nil {
+		// Don't import the bundle again.
+		logger.Fatalf("Moving bundle %s to %s: %s", name, dest, err)
+	}
+
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.Current = nil
+	status.Recent = append(status.Recent, job)
+	if len(status.Recent) > maxRecentImportdJobs {
+		status.Recent = status.Recent[len(status.Recent)-maxRecentImportdJobs:]
+	}
+	if err == nil {
+		status.Succeeded++
+	} else {
+		status.Failed++
+	}
+}
+
+// importOnce imports the build data of a bundle while holding the
+// store lock for its repo and commit.
+func (c *StoreImportdCmd) importOnce(s interface{}, bdfs rwvfs.FileSystem, opt ImportOpt) error {
+	unlock, err := lockCommit(s, opt.Repo, opt.CommitID, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Warnf("releasing store lock: %s", err)
+		}
+	}()
+	return Import(bdfs, s, opt)
+}
+
+// retryableImportError returns whether an import that failed with
+// err might succeed if retried. Errors caused by the build data
+// itself are not retried.
+func retryableImportError(err error) bool {
+	switch ExitCode(err) {
+	case ExitUsage, ExitNoDataForCommit, ExitPartialImport:
+		return false
+	}
+	return true
+}
+
+type StoreEnqueueCmd struct {
+	Spool    string `long:"spool" description:"spool directory (watched by 'src store importd')" required:"yes"`
+	Repo     string `long:"repo" description:"repo whose build data to enqueue"`
+	CommitID string `long:"commit" description:"commit ID of build data to enqueue"`
+}
+
+var storeEnqueueCmd StoreEnqueueCmd
+
+func (c *StoreEnqueueCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	bdfs, label, err := getLocalBuildDataFS(c.CommitID)
+	if err != nil {
+		return err
+	}
+	if bdfs == nil {
+		return fmt.Errorf("no local repository (run 'src store enqueue' in a repository)")
+	}
+	if _, err := bdfs.Lstat("."); os.IsNotExist(err) {
+		return newCmdError(ExitNoDataForCommit, fmt.Errorf("no build data for commit %s (run `src make` to build it)", c.CommitID))
+	}
+
+	// Write the bundle under a temporary name so that importd doesn't
+	// see it until it's complete.
+	tmpDir, err := ioutil.TempDir(c.Spool, ".enqueue-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dataFS := rwvfs.OS(filepath.Join(tmpDir, spoolDataDir))
+	w := fs.WalkFS(".", rwvfs.Walkable(bdfs))
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		if fi := w.Stat(); fi.Mode().IsRegular() {
+			if err := fetchFile(bdfs, dataFS, w.Path(), fi, false); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeJSONFile(filepath.Join(tmpDir, spoolManifestFile), spoolManifest{Repo: c.Repo, CommitID: c.CommitID}); err != nil {
+		return err
+	}
+
+	// Bundle names sort in the order they were enqueued.
+	name := fmt.Sprintf("%020d-%s", time.Now().UnixNano(), c.CommitID)
+	if err := os.Rename(tmpDir, filepath.Join(c.Spool, name)); err != nil {
+		return err
+	}
+	logger.Infof("# Enqueued build data from %s as bundle %s.", label, name)
+	return nil
+}
@@ -0,0 +1,164 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A queryInput is a JSON object read from the --input of 'src store
+// defs' or 'src store refs', for each of which the query is run. It
+// is typically a def or ref printed by an earlier 'src store' command
+// in a pipeline (whose other fields are ignored), or a def key, but
+// it may also be an object with only some of these fields, which are
+// used as filters.
+type queryInput struct {
+	Repo     string
+	CommitID string
+	UnitType string
+	Unit     string
+	Path     string
+	File     string
+
+	DefRepo     string
+	DefUnitType string
+	DefUnit     string
+	DefPath     string
+}
+
+// def returns the def that the input identifies: the def itself if it
+// has a Path (i.e., it is a def or def key), or the def that a ref
+// refers to if it has a DefPath. The def's CommitID is empty if it
+// isn't known (because the ref refers to a def in another repo). If
+// the input identifies no def, ok is false.
+func (in *queryInput) def() (def queryInput, ok bool) {
+	switch {
+	case in.DefPath != "":
+		def = queryInput{Repo: in.DefRepo, UnitType: in.DefUnitType, Unit: in.DefUnit, Path: in.DefPath}
+		// Refs to defs in the same repo or source unit may omit
+		// the def's repo or source unit.
+		if def.Repo == "" || def.Repo == in.Repo {
+			def.Repo, def.CommitID = in.Repo, in.CommitID
+		}
+		if def.UnitType == "" && def.Unit == "" {
+			def.UnitType, def.Unit = in.UnitType, in.Unit
+		}
+		return def, true
+	case in.Path != "":
+		return queryInput{Repo: in.Repo, CommitID: in.CommitID, UnitType: in.UnitType, Unit: in.Unit, Path: in.Path}, true
+	}
+	return queryInput{}, false
+}
+
+// readQueryInputs reads the queryInputs in the named file ("-" for
+// stdin), which contains a JSON array of objects (such as the output
+// of 'src store defs') or a stream of JSON values, each an object or
+// an array of objects (such as JSON lines). Duplicate inputs (such as
+// the identical key fields of many refs to the same def) are omitted.
+func readQueryInputs(name string) ([]*queryInput, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var inputs []*queryInput
+	seen := map[queryInput]struct{}{}
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading --input %s: %s", name, err)
+		}
+
+		var ins []*queryInput
+		var err error
+		if v = bytes.TrimSpace(v); len(v) > 0 && v[0] == '[' {
+			err = json.Unmarshal(v, &ins)
+		} else {
+			var in queryInput
+			err = json.Unmarshal(v, &in)
+			ins = []*queryInput{&in}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading --input %s: value %d: %s", name, i, err)
+		}
+
+		for _, in := range ins {
+			if in == nil {
+				continue
+			}
+			if (in.UnitType == "") != (in.Unit == "") {
+				return nil, fmt.Errorf("reading --input %s: value %d: must have either both or neither of UnitType and Unit", name, i)
+			}
+			if _, dup := seen[*in]; dup {
+				continue
+			}
+			seen[*in] = struct{}{}
+			inputs = append(inputs, in)
+		}
+	}
+	return inputs, nil
+}
+
+// hasCommitOpts returns whether any of the flags that select commits
+// are given (in which case an input's CommitID is ignored, so that it
+// doesn't add to the commits that the flags select).
+func hasCommitOpts(commitID, commits, commitRange, repoCommitIDs string) bool {
+	return commitID != "" || commits != "" || commitRange != "" || repoCommitIDs != ""
+}
+
+// setOpt sets *opt to v if v is non-empty.
+func setOpt(opt *string, v string) {
+	if v != "" {
+		*opt = v
+	}
+}
+
+// withInput returns a copy of the query that selects the def that in
+// identifies (see queryInput.def), or that is also filtered by in's
+// fields if it identifies no def.
+func (c StoreDefsCmd) withInput(in *queryInput) *StoreDefsCmd {
+	f := *in
+	if def, ok := in.def(); ok {
+		f = def
+		setOpt(&c.Path, def.Path)
+	} else {
+		setOpt(&c.File, in.File)
+	}
+	setOpt(&c.Repo, f.Repo)
+	setOpt(&c.UnitType, f.UnitType)
+	setOpt(&c.Unit, f.Unit)
+	if !hasCommitOpts(c.CommitID, c.Commits, c.CommitRange, c.RepoCommitIDs) {
+		c.CommitID = f.CommitID
+	}
+	return &c
+}
+
+// withInput returns a copy of the query that selects the refs to the
+// def that in identifies (see queryInput.def), at the def's commit,
+// or that is also filtered by in's fields if it identifies no def.
+func (c StoreRefsCmd) withInput(in *queryInput) *StoreRefsCmd {
+	commitID := in.CommitID
+	if def, ok := in.def(); ok {
+		c.DefRepo, c.DefUnitType, c.DefUnit, c.DefPath = def.Repo, def.UnitType, def.Unit, def.Path
+		commitID = def.CommitID
+	} else {
+		setOpt(&c.Repo, in.Repo)
+		setOpt(&c.UnitType, in.UnitType)
+		setOpt(&c.Unit, in.Unit)
+		setOpt(&c.File, in.File)
+	}
+	if !hasCommitOpts(c.CommitID, c.Commits, c.CommitRange, c.RepoCommitIDs) {
+		c.CommitID = commitID
+	}
+	return &c
+}
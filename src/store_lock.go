@@ -0,0 +1,87 @@
+package src
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// locker returns the store.Locker used to serialize writes to the
+// store (see storeConfig.Lock).
+func (c *StoreCmd) locker(conf *storeConfig) (store.Locker, error) {
+	if conf.Lock.Command != "" {
+		return store.NewCommandLocker(conf.Lock.Command), nil
+	}
+	dir := conf.Lock.Dir
+	if dir == "" {
+		// Lock files can't be stored in the store itself, because
+		// they'd be listed as data (e.g., as versions of a
+		// RepoStore).
+		root := c.Root
+		if !strings.HasPrefix(root, "s3://") {
+			var err error
+			root, err = filepath.Abs(root)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dir = filepath.Join(srclib.CacheDir, "store-locks", url.QueryEscape(root))
+	}
+	return store.NewFileLocker(dir), nil
+}
+
+// lockCommit acquires the store lock that guards writes of the data
+// for a repo and commit to s. If the lock is held by another process
+// and wait is true, it waits for the lock to be released; otherwise it
+// fails.
+func lockCommit(s interface{}, repo, commitID string, wait bool) (unlock func() error, err error) {
+	if _, ok := s.(store.MultiRepoStore); !ok {
+		repo = ""
+	}
+	return lock(store.LockName(repo, commitID), wait)
+}
+
+// lock acquires the store lock with the given name (see lockCommit).
+func lock(name string, wait bool) (unlock func() error, err error) {
+	conf, err := storeCmd.config()
+	if err != nil {
+		return nil, err
+	}
+	l, err := storeCmd.locker(conf)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err = l.Lock(name, false)
+	if store.IsLocked(err) && wait {
+		logger.Infof("# %s; waiting for it to be released.", err)
+		unlock, err = l.Lock(name, true)
+	}
+	return unlock, err
+}
+
+// indexCommit builds the indexes of a commit in s. In a
+// MultiRepoStore, indexing a commit also updates the store-wide global
+// ref index, so it holds the store-wide lock (see
+// store.StoreLockName) in addition to the commit's lock (which the
+// caller must hold).
+func indexCommit(s interface{}, repo, commitID string) error {
+	switch s := s.(type) {
+	case store.RepoIndexer:
+		return s.Index(commitID)
+	case store.MultiRepoIndexer:
+		unlock, err := lock(store.StoreLockName, true)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := unlock(); err != nil {
+				logger.Warnf("releasing store lock: %s", err)
+			}
+		}()
+		return s.Index(repo, commitID)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package src
+
+import (
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreProvenanceCmd struct {
+	Repo     string `long:"repo" description:"the repo (required for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"the commit (or snapshot name) whose provenance to show"`
+}
+
+var storeProvenanceCmd StoreProvenanceCmd
+
+func (c *StoreProvenanceCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	ps, ok := s.(store.ProvenanceStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement provenance", s)
+	}
+	p, err := ps.Provenance(c.Repo, c.CommitID)
+	if store.IsNotExist(err) {
+		return fmt.Errorf("no provenance recorded for %s at %s (it was imported before src recorded provenance, or not imported)", c.Repo, c.CommitID)
+	} else if err != nil {
+		return err
+	}
+	PrintJSON(p, "  ")
+	return nil
+}
@@ -0,0 +1,282 @@
+package src
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+type StorePushESCmd struct {
+	URL       string `long:"url" description:"Elasticsearch (or OpenSearch) URL (may include user:password@)" default:"http://localhost:9200"`
+	Index     string `long:"index" description:"name of the index to push defs to" default:"defs"`
+	Mapping   string `long:"mapping" description:"JSON file with the index settings and mappings, used if the index doesn't exist (default: a mapping suited to symbol search)"`
+	BatchSize int    `long:"batch-size" description:"number of defs per bulk request" default:"500"`
+
+	Repo     string `long:"repo" description:"only push defs in this repo"`
+	CommitID string `long:"commit" description:"only push defs at this commit"`
+
+	DryRun bool `short:"n" long:"dry-run" description:"print the bulk requests to stdout instead of sending them"`
+}
+
+var storePushESCmd StorePushESCmd
+
+// esDef is the document indexed for each def.
+type esDef struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind,omitempty"`
+	File     string `json:"file"`
+	Path     string `json:"path"`
+	Repo     string `json:"repo,omitempty"`
+	CommitID string `json:"commit"`
+	UnitType string `json:"unit_type"`
+	Unit     string `json:"unit"`
+	Exported bool   `json:"exported"`
+	Docs     string `json:"docs,omitempty"`
+}
+
+// defaultESMapping is the index definition used for new indexes if
+// --mapping is not given. Names are analyzed for full-text search and
+// also indexed as keywords (for exact matches and sorting).
+const defaultESMapping = `{
+  "mappings": {
+    "properties": {
+      "name":      {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+      "kind":      {"type": "keyword"},
+      "file":      {"type": "keyword"},
+      "path":      {"type": "keyword"},
+      "repo":      {"type": "keyword"},
+      "commit":    {"type": "keyword"},
+      "unit_type": {"type": "keyword"},
+      "unit":      {"type": "keyword"},
+      "exported":  {"type": "boolean"},
+      "docs":      {"type": "text"}
+    }
+  }
+}`
+
+func newESDef(def *graph.Def) *esDef {
+	d := &esDef{
+		Name:     def.Name,
+		Kind:     def.Kind,
+		File:     def.File,
+		Path:     def.Path,
+		Repo:     def.Repo,
+		CommitID: def.CommitID,
+		UnitType: def.UnitType,
+		Unit:     def.Unit,
+		Exported: def.Exported,
+	}
+	// Prefer plain-text docs, because the docs are only used for
+	// full-text search.
+	for _, doc := range def.Docs {
+		if d.Docs == "" || doc.Format == "text/plain" {
+			d.Docs = doc.Data
+		}
+	}
+	return d
+}
+
+// id returns the document ID of d. It is derived from the def's key,
+// so that pushing the same def again replaces its document.
+func (d *esDef) id() string {
+	h := sha1.Sum([]byte(strings.Join([]string{d.Repo, d.CommitID, d.UnitType, d.Unit, d.Path}, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *StorePushESCmd) Execute(args []string) error {
+	if c.BatchSize < 1 {
+		return newCmdError(ExitUsage, fmt.Errorf("--batch-size must be positive"))
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing versions", s)
+	}
+
+	if !c.DryRun {
+		if err := c.createIndex(); err != nil {
+			return err
+		}
+	}
+
+	var versionFilters []store.VersionFilter
+	if c.Repo != "" {
+		versionFilters = append(versionFilters, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		versionFilters = append(versionFilters, store.ByCommitIDs(c.CommitID))
+	}
+	versions, err := rs.Versions(versionFilters...)
+	if err != nil {
+		return err
+	}
+
+	var batch bytes.Buffer
+	var batchLen, total int
+	flush := func() error {
+		if batchLen == 0 {
+			return nil
+		}
+		if err := c.bulk(&batch); err != nil {
+			return err
+		}
+		total += batchLen
+		logger.Debugf("# Pushed %d defs to index %s.", total, c.Index)
+		batch.Reset()
+		batchLen = 0
+		return nil
+	}
+
+	// Read defs one source unit at a time, so that large stores need
+	// not fit in memory.
+	for _, v := range versions {
+		units, err := rs.Units(versionFilter(v.Repo, v.CommitID))
+		if err != nil {
+			return err
+		}
+		for _, u := range units {
+			defs, err := rs.Defs(versionFilter(v.Repo, v.CommitID), store.ByUnits(unit.ID2{Type: u.Type, Name: u.Name}))
+			if err != nil {
+				return err
+			}
+			for _, def := range defs {
+				d := newESDef(def)
+				if err := writeBulkIndexAction(&batch, c.Index, d.id(), d); err != nil {
+					return err
+				}
+				batchLen++
+				if batchLen == c.BatchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if !c.DryRun {
+		logger.Infof("# Pushed %d defs from %d versions to index %s.", total, len(versions), c.Index)
+	}
+	return nil
+}
+
+// writeBulkIndexAction writes an "index" action for doc to w in the
+// Elasticsearch bulk API format.
+func writeBulkIndexAction(w io.Writer, index, id string, doc interface{}) error {
+	var action struct {
+		Index struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		} `json:"index"`
+	}
+	action.Index.Index, action.Index.ID = index, id
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(action); err != nil {
+		return err
+	}
+	return enc.Encode(doc)
+}
+
+// createIndex creates the index (with the --mapping or default
+// mapping) if it doesn't exist.
+func (c *StorePushESCmd) createIndex() error {
+	indexURL := strings.TrimSuffix(c.URL, "/") + "/" + c.Index
+	resp, err := http.Head(indexURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	} else if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("checking for index %s: HTTP %s", c.Index, resp.Status)
+	}
+
+	mapping := []byte(defaultESMapping)
+	if c.Mapping != "" {
+		mapping, err = ioutil.ReadFile(c.Mapping)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("PUT", indexURL, bytes.NewReader(mapping))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	if err := doESRequest(req, nil); err != nil {
+		return fmt.Errorf("creating index %s: %s", c.Index, err)
+	}
+	logger.Infof("# Created index %s.", c.Index)
+	return nil
+}
+
+// bulk sends a bulk request whose body is the contents of body.
+func (c *StorePushESCmd) bulk(body *bytes.Buffer) error {
+	if c.DryRun {
+		_, err := io.Copy(os.Stdout, body)
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(c.URL, "/")+"/_bulk", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/x-ndjson")
+	var resp struct {
+		Errors bool
+		Items  []map[string]struct {
+			ID    string          `json:"_id"`
+			Error json.RawMessage `json:"error"`
+		}
+	}
+	if err := doESRequest(req, &resp); err != nil {
+		return err
+	}
+	if resp.Errors {
+		// Report the first error; the rest are usually the same.
+		for _, item := range resp.Items {
+			for _, res := range item {
+				if len(res.Error) > 0 {
+					return fmt.Errorf("bulk indexing of document %s failed: %s", res.ID, res.Error)
+				}
+			}
+		}
+		return fmt.Errorf("bulk indexing failed")
+	}
+	return nil
+}
+
+// doESRequest sends an Elasticsearch API request and decodes the JSON
+// response into v (if non-nil).
+func doESRequest(req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: HTTP %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(body))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
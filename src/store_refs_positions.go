@@ -0,0 +1,186 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A fileKey identifies a file in a repository at a commit.
+type fileKey struct {
+	Repo, CommitID, File string
+}
+
+// A refWithPosition is a ref printed with --positions line-col. Its
+// lines and columns are 1-based, and columns are in bytes. They are
+// zero if the file's contents are unavailable.
+type refWithPosition struct {
+	*graph.Ref
+	StartLine   int `json:",omitempty"`
+	StartColumn int `json:",omitempty"`
+	EndLine     int `json:",omitempty"`
+	EndColumn   int `json:",omitempty"`
+}
+
+// A fileRefs is a group of refs printed with --group-by file: the
+// refs in a file.
+type fileRefs struct {
+	Repo     string `json:",omitempty"`
+	CommitID string
+	File     string
+	Refs     []interface{} // *graph.Ref or *refWithPosition
+}
+
+// results returns refs in the form selected by --group-by and
+// --positions.
+func (c *StoreRefsCmd) results(refs []*graph.Ref) interface{} {
+	if c.GroupBy == "" && c.Positions != "line-col" {
+		return refs
+	}
+
+	var lt *lineTables
+	if c.Positions == "line-col" {
+		lt = &lineTables{tables: map[fileKey]*lineTable{}}
+	}
+	item := func(ref *graph.Ref) interface{} {
+		if lt == nil {
+			return ref
+		}
+		rp := &refWithPosition{Ref: ref}
+		k := fileKey{ref.Repo, ref.CommitID, ref.File}
+		if ref.Start <= ref.End {
+			rp.StartLine, rp.StartColumn = lt.lineCol(k, int(ref.Start))
+			rp.EndLine, rp.EndColumn = lt.lineCol(k, int(ref.End))
+		}
+		if rp.StartLine == 0 || rp.EndLine == 0 {
+			rp.StartLine, rp.StartColumn, rp.EndLine, rp.EndColumn = 0, 0, 0, 0
+		}
+		return rp
+	}
+
+	if c.GroupBy == "" {
+		items := make([]interface{}, len(refs))
+		for i, ref := range refs {
+			items[i] = item(ref)
+		}
+		return items
+	}
+
+	// Groups are in the order of their files' first refs (which is
+	// the order of the files if the refs are sorted).
+	groups := []*fileRefs{}
+	byFile := map[fileKey]*fileRefs{}
+	for _, ref := range refs {
+		k := fileKey{ref.Repo, ref.CommitID, ref.File}
+		g, present := byFile[k]
+		if !present {
+			g = &fileRefs{Repo: k.Repo, CommitID: k.CommitID, File: k.File}
+			byFile[k] = g
+			groups = append(groups, g)
+		}
+		g.Refs = append(g.Refs, item(ref))
+	}
+	return groups
+}
+
+// lineTables converts byte offsets in files to lines and columns,
+// using a table of the offsets at which each line of a file starts,
+// which is computed (from the file's contents at the ref's commit in
+// the local repository) the first time the file is needed.
+type lineTables struct {
+	tables map[fileKey]*lineTable // nil if the file's contents are unavailable
+}
+
+// A lineTable holds the byte offsets at which each line of a file
+// starts.
+type lineTable struct {
+	starts []int
+	size   int // length of the file
+}
+
+// lineCol returns the 1-based line and column (in bytes) of the byte
+// offset ofs in the file, or zeroes if the file's contents are
+// unavailable or ofs is past the end of the file.
+func (t *lineTables) lineCol(k fileKey, ofs int) (line, col int) {
+	lt, present := t.tables[k]
+	if !present {
+		src, err := localRepoFileAt(k.Repo, k.CommitID, k.File)
+		if err != nil {
+			logger.Warnf("Can't compute the lines and columns of refs in %s at commit %s: %s", k.File, k.CommitID, err)
+		}
+		if src != nil {
+			lt = newLineTable(src)
+		}
+		t.tables[k] = lt
+	}
+	if lt == nil || ofs > lt.size {
+		return 0, 0
+	}
+	line = sort.Search(len(lt.starts), func(i int) bool { return lt.starts[i] > ofs })
+	return line, ofs - lt.starts[line-1] + 1
+}
+
+// newLineTable computes the line table of src.
+func newLineTable(src []byte) *lineTable {
+	starts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &lineTable{starts: starts, size: len(src)}
+}
+
+// localRepoFileAt returns the contents of file at commitID (or the
+// checked-out commit, if commitID is empty) in the local repository if
+// it is repo (or repo is empty, as in a single-repository store). If
+// the local repository is a different repository, it returns nil and
+// no error.
+//
+// The contents are read from the VCS, not the working tree: even at
+// the checked-out commit, the working tree may have uncommitted
+// changes, and the refs' offsets are in the committed file. The only
+// exception is the synthetic commit ID of the working tree itself (see
+// workingTreeEnv), which identifies the working tree's current
+// contents.
+func localRepoFileAt(repo, commitID, file string) ([]byte, error) {
+	lrepo, err := openLocalRepo()
+	if err != nil || lrepo == nil || lrepo.RootDir == "" || (repo != "" && lrepo.URI() != repo) {
+		return nil, nil
+	}
+	if commitID == "" {
+		commitID = lrepo.CommitID
+	}
+	if commitID == lrepo.CommitID && lrepo.BaseCommitID != "" {
+		return ioutil.ReadFile(filepath.Join(lrepo.RootDir, filepath.FromSlash(file)))
+	}
+	return vcsFileAt(lrepo.VCSType, lrepo.RootDir, commitID, file)
+}
+
+// vcsFileAt returns the contents of file at commitID in the
+// repository (of type vcsType) whose root is dir.
+func vcsFileAt(vcsType, dir, commitID, file string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "show", commitID+":"+file)
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "cat", "-r", commitID, "--", file)
+	default:
+		return nil, fmt.Errorf("reading files at a commit is only supported in git and hg repositories, not %s", vcsType)
+	}
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	src, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s (%s)", strings.Join(cmd.Args, " "), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return src, nil
+}
@@ -0,0 +1,133 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewLineTable(t *testing.T) {
+	tests := []struct {
+		src        string
+		wantStarts []int
+	}{
+		{src: "", wantStarts: []int{0}},
+		{src: "a", wantStarts: []int{0}},
+		{src: "a\n", wantStarts: []int{0, 2}},
+		{src: "a\nbc\n\nd", wantStarts: []int{0, 2, 5, 6}},
+		{src: "a\r\nb\r\n", wantStarts: []int{0, 3, 6}},
+	}
+	for _, test := range tests {
+		lt := newLineTable([]byte(test.src))
+		if !reflect.DeepEqual(lt.starts, test.wantStarts) || lt.size != len(test.src) {
+			t.Errorf("%q: got starts %v and size %d, want %v and %d", test.src, lt.starts, lt.size, test.wantStarts, len(test.src))
+		}
+	}
+}
+
+func TestLineTables_lineCol(t *testing.T) {
+	type lineCol struct{ line, col int }
+	tests := []struct {
+		src  string
+		ofs  []int
+		want []lineCol
+	}{
+		{
+			src:  "ab\ncd",
+			ofs:  []int{0, 1, 2, 3, 4, 5, 6},
+			want: []lineCol{{1, 1}, {1, 2}, {1, 3}, {2, 1}, {2, 2}, {2, 3}, {0, 0}},
+		},
+		{
+			// A trailing newline starts an empty last line, which is
+			// where the offset at EOF is.
+			src:  "ab\n",
+			ofs:  []int{2, 3, 4},
+			want: []lineCol{{1, 3}, {2, 1}, {0, 0}},
+		},
+		{
+			// The \r of a CRLF is the last column of its line.
+			src:  "a\r\nb\r\n",
+			ofs:  []int{1, 2, 3, 4, 6},
+			want: []lineCol{{1, 2}, {1, 3}, {2, 1}, {2, 2}, {3, 1}},
+		},
+		{
+			// Columns are in bytes, not characters.
+			src:  "é€\nx𝄞y",
+			ofs:  []int{2, 5, 6, 7, 11},
+			want: []lineCol{{1, 3}, {1, 6}, {2, 1}, {2, 2}, {2, 6}},
+		},
+		{
+			src:  "",
+			ofs:  []int{0, 1},
+			want: []lineCol{{1, 1}, {0, 0}},
+		},
+	}
+	for _, test := range tests {
+		k := fileKey{CommitID: "c", File: "f"}
+		lt := &lineTables{tables: map[fileKey]*lineTable{k: newLineTable([]byte(test.src))}}
+		for i, ofs := range test.ofs {
+			line, col := lt.lineCol(k, ofs)
+			if got := (lineCol{line, col}); got != test.want[i] {
+				t.Errorf("%q offset %d: got line %d col %d, want line %d col %d", test.src, ofs, line, col, test.want[i].line, test.want[i].col)
+			}
+		}
+	}
+
+	// Files whose contents are unavailable have no lines and columns.
+	k := fileKey{CommitID: "c", File: "f"}
+	lt := &lineTables{tables: map[fileKey]*lineTable{k: nil}}
+	if line, col := lt.lineCol(k, 0); line != 0 || col != 0 {
+		t.Errorf("unavailable file: got line %d col %d, want zeroes", line, col)
+	}
+}
+
+func TestVCSFileAt_git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "srclib-vcs-file-at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s (%s)", args, err, out)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("committed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "f")
+	git("-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "-m", "m")
+	commitID := git("rev-parse", "HEAD")
+	commitID = commitID[:len(commitID)-1]
+
+	// The file is read at the commit, even if the working tree (which
+	// is at that commit) has uncommitted changes.
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("uncommitted\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := vcsFileAt("git", dir, commitID, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "committed\n"; string(src) != want {
+		t.Errorf("got %q, want %q", src, want)
+	}
+
+	if _, err := vcsFileAt("git", dir, commitID, "nope"); err == nil {
+		t.Error("got no error for a file that isn't in the commit")
+	}
+	if _, err := vcsFileAt("svn", dir, commitID, "f"); err == nil {
+		t.Error("got no error for an unsupported VCS")
+	}
+}
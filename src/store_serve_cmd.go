@@ -0,0 +1,188 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/store/pb"
+)
+
+type StoreServeCmd struct {
+	HTTP   string `long:"http" description:"address to serve the store API on" default:":3080"`
+	GRPC   string `long:"grpc" description:"also serve the gRPC export API on this address (e.g., :3081)"`
+	Config string `long:"server-config" description:"JSON file configuring the server's rate limits, API keys and quotas, and request size limits (see 'src store serve --help')" value-name:"FILE"`
+}
+
+var storeServeCmd StoreServeCmd
+
+// storeServeConfig is the format of the 'src store serve'
+// --server-config file.
+type storeServeConfig struct {
+	// RateLimit is the max sustained number of requests per second
+	// from each client (identified by its API key, or by its IP
+	// address if API keys are not required), and Burst is the max
+	// number of requests that a client can make at once. If RateLimit
+	// is 0, requests are not rate limited.
+	RateLimit float64
+	Burst     int
+
+	// Keys maps API keys to the settings of the clients that use them.
+	// If it is non-empty, every request must present one of the keys
+	// (in an "Authorization: token KEY" header or a key=KEY query
+	// parameter).
+	Keys map[string]*apiKeyConfig
+
+	// MaxRequestBytes is the max size of a request body (default 1
+	// MB).
+	MaxRequestBytes int64
+}
+
+// apiKeyConfig configures the limits of the clients that use an API
+// key. Zero fields use the server-wide defaults.
+type apiKeyConfig struct {
+	// Name identifies the client (e.g., "vim-plugin") in logs.
+	Name string
+
+	RateLimit float64
+	Burst     int
+
+	// DailyQuota, if positive, is the max number of requests per day
+	// (in UTC).
+	DailyQuota int
+}
+
+const defaultMaxRequestBytes = 1 << 20
+
+func (c *StoreServeCmd) Execute(args []string) error {
+	conf := &storeServeConfig{}
+	if c.Config != "" {
+		if err := readJSONFile(c.Config, conf); err != nil {
+			return fmt.Errorf("reading --server-config: %s", err)
+		}
+	}
+	if conf.MaxRequestBytes == 0 {
+		conf.MaxRequestBytes = defaultMaxRequestBytes
+	}
+
+	store.CacheOpenStores = true
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing refs and defs", s)
+	}
+	if us, err = storeCmd.logSlowQueries(us); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/Editor.", &editorHTTPHandler{e: &EditorService{s: us}})
+	mux.Handle("/export", &dumpHandler{s: s})
+	mux.Handle("/metrics", daemonMetrics)
+	if gs, ok := s.(gqlStore); ok {
+		mux.Handle("/graphql", &graphQLHandler{s: gs})
+	}
+	if cl, ok := s.(store.ChangeLog); ok {
+		b, err := newChangeBus(cl, changePollInterval)
+		if err != nil {
+			return err
+		}
+		mux.Handle("/changes", &changesHandler{b: b})
+		mux.Handle("/changes/ws", &changesHandler{b: b})
+	}
+
+	limits := newLimitHandler(&metricsHandler{h: mux, m: daemonMetrics}, conf)
+	if c.GRPC != "" {
+		l, err := net.Listen("tcp", c.GRPC)
+		if err != nil {
+			return err
+		}
+		srv := grpc.NewServer(limits.grpcServerOptions()...)
+		pb.RegisterExporterServer(srv, pb.NewExporterServer(s))
+		go func() {
+			logger.Infof("# Serving the gRPC export API on %s.", c.GRPC)
+			if err := srv.Serve(l); err != nil {
+				logger.Fatalf("Serving the gRPC export API: %s", err)
+			}
+		}()
+	}
+
+	logger.Infof("# Serving the store API on %s.", c.HTTP)
+	return http.ListenAndServe(c.HTTP, limits)
+}
+
+// editorHTTPHandler serves the EditorService methods (the same ones
+// that 'src serve-editor' serves over JSON-RPC) over HTTP. Requests
+// are POSTs to /Editor.METHOD whose bodies are the JSON-encoded
+// method arguments, and responses are the JSON-encoded results.
+type editorHTTPHandler struct {
+	e *EditorService
+}
+
+func (h *editorHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Stop the query if the client goes away before it finishes.
+	e := &EditorService{s: h.e.s, ctx: r.Context()}
+
+	var (
+		reply interface{}
+		err   error
+	)
+	switch method := strings.TrimPrefix(r.URL.Path, "/"); method {
+	case "Editor.DefAtPosition":
+		var args DefAtPositionArgs
+		if err = json.NewDecoder(r.Body).Decode(&args); err == nil {
+			var info HoverInfo
+			err = e.DefAtPosition(&args, &info)
+			reply = &info
+		}
+	case "Editor.Refs":
+		var args RefsArgs
+		if err = json.NewDecoder(r.Body).Decode(&args); err == nil {
+			var refs []*graph.Ref
+			err = e.Refs(&args, &refs)
+			reply = refs
+		}
+	case "Editor.Symbols":
+		var args SymbolsArgs
+		if err = json.NewDecoder(r.Body).Decode(&args); err == nil {
+			var defs []*graph.Def
+			err = e.Symbols(&args, &defs)
+			reply = defs
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown method %q", method), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if err == context.Canceled {
+			logger.Debugf("Canceled %s request: the client went away.", r.URL.Path)
+			return
+		}
+		if isRequestTooLarge(err) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		logger.Errorf("Writing %s response: %s", r.URL.Path, err)
+	}
+}
@@ -0,0 +1,288 @@
+package src
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// A tokenBucket rate-limits events: it allows bursts of up to burst
+// events and a sustained rate of rate events per second.
+type tokenBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// take removes a token from the bucket if one is available. If none
+// is, it returns false and the time until one will be.
+func (b *tokenBucket) take(now time.Time) (ok bool, wait time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full returns whether the bucket would be full at now (so that
+// forgetting it would not change the client's limit).
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// statusTooManyRequests is the HTTP status code of requests that
+// exceed a rate limit or quota (RFC 6585).
+const statusTooManyRequests = 429
+
+// maxIdleBuckets is the number of clients' token buckets above which
+// the buckets of idle clients are forgotten.
+const maxIdleBuckets = 10000
+
+// A limitHandler enforces a storeServeConfig's API keys, rate limits,
+// quotas, and request size limits on the requests to h, so that one
+// misbehaving client can't starve the others.
+type limitHandler struct {
+	h    http.Handler
+	conf *storeServeConfig
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // client -> token bucket
+	usage   map[string]*dailyUsage  // API key -> requests today
+}
+
+// dailyUsage is the number of requests made with an API key on a day
+// (in UTC, formatted as 2006-01-02).
+type dailyUsage struct {
+	day string
+	n   int
+}
+
+func newLimitHandler(h http.Handler, conf *storeServeConfig) *limitHandler {
+	return &limitHandler{
+		h:       h,
+		conf:    conf,
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+		usage:   map[string]*dailyUsage{},
+	}
+}
+
+func (h *limitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, name, key, ok := h.client(r)
+	if !ok {
+		http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.ContentLength > h.conf.MaxRequestBytes {
+		http.Error(w, fmt.Sprintf("request body too large (max %d bytes)", h.conf.MaxRequestBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.conf.MaxRequestBytes)
+
+	if err := h.admit(client, key); err != nil {
+		if err.wait > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(err.wait.Seconds()))))
+		}
+		logger.Warnf("Rejected request from %s: %s.", name, err.msg)
+		http.Error(w, err.msg, statusTooManyRequests)
+		return
+	}
+	h.h.ServeHTTP(w, r)
+}
+
+// client returns the ID of the client that made r (for rate limiting
+// and quotas), its name (for logs), and the config of its API key (nil
+// if API keys are not required). It returns false if API keys are
+// required and r does not present a valid one.
+func (h *limitHandler) client(r *http.Request) (id, name string, key *apiKeyConfig, ok bool) {
+	token := r.URL.Query().Get("key")
+	if auth := r.Header.Get("authorization"); strings.HasPrefix(auth, "token ") {
+		token = strings.TrimPrefix(auth, "token ")
+	}
+	return h.clientWithToken(r.RemoteAddr, token)
+}
+
+// clientWithToken is like client, for a client at addr (host:port)
+// that presented the API key token (which may be empty).
+func (h *limitHandler) clientWithToken(addr, token string) (id, name string, key *apiKeyConfig, ok bool) {
+	if len(h.conf.Keys) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		return host, host, nil, true
+	}
+
+	key, ok = h.conf.Keys[token]
+	if !ok || token == "" {
+		return "", "", nil, false
+	}
+	name = key.Name
+	if name == "" {
+		// Don't log the whole key.
+		if len(token) > 4 {
+			name = "key " + token[:4] + "..."
+		} else {
+			name = "key ..."
+		}
+	}
+	return "key:" + token, name, key, true
+}
+
+// grpcClient is like client, for a gRPC call. The API key is read
+// from the call's "authorization" metadata ("token KEY").
+func (h *limitHandler) grpcClient(ctx context.Context) (id, name string, key *apiKeyConfig, ok bool) {
+	var addr, token string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, auth := range md["authorization"] {
+			if strings.HasPrefix(auth, "token ") {
+				token = strings.TrimPrefix(auth, "token ")
+			}
+		}
+	}
+	return h.clientWithToken(addr, token)
+}
+
+// admitGRPC enforces the API keys, rate limits, and quotas on a gRPC
+// call, as ServeHTTP does on HTTP requests (so that clients share the
+// same limits on both). It returns a gRPC error if the call is
+// rejected.
+func (h *limitHandler) admitGRPC(ctx context.Context) error {
+	client, name, key, ok := h.grpcClient(ctx)
+	if !ok {
+		return grpc.Errorf(codes.Unauthenticated, "missing or invalid API key")
+	}
+	if err := h.admit(client, key); err != nil {
+		logger.Warnf("Rejected gRPC call from %s: %s.", name, err.msg)
+		return grpc.Errorf(codes.ResourceExhausted, "%s", err.msg)
+	}
+	return nil
+}
+
+// grpcServerOptions returns the options of a gRPC server whose calls
+// are subject to the same limits as the requests to h.
+func (h *limitHandler) grpcServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(int(h.conf.MaxRequestBytes)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := h.admitGRPC(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := h.admitGRPC(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// errLimited describes why a request was rejected by admit.
+type errLimited struct {
+	msg  string
+	wait time.Duration // when the client may retry (0 if unknown)
+}
+
+// admit counts a request from client against its rate limit and
+// daily quota. It returns a non-nil *errLimited if the request
+// exceeds either.
+func (h *limitHandler) admit(client string, key *apiKeyConfig) *errLimited {
+	rate, burst, quota := h.conf.RateLimit, h.conf.Burst, 0
+	if key != nil {
+		if key.RateLimit != 0 {
+			rate = key.RateLimit
+		}
+		if key.Burst != 0 {
+			burst = key.Burst
+		}
+		quota = key.DailyQuota
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+
+	var u *dailyUsage
+	if quota > 0 {
+		day := now.UTC().Format("2006-01-02")
+		u = h.usage[client]
+		if u == nil || u.day != day {
+			u = &dailyUsage{day: day}
+			h.usage[client] = u
+		}
+		if u.n >= quota {
+			tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			return &errLimited{msg: fmt.Sprintf("daily quota of %d requests exceeded", quota), wait: tomorrow.Sub(now)}
+		}
+	}
+	if err := h.takeToken(client, rate, burst, now); err != nil {
+		// Rejected requests don't count against the quota.
+		return err
+	}
+	if u != nil {
+		u.n++
+	}
+	return nil
+}
+
+// takeToken takes a token from client's token bucket (creating it
+// with the given rate and burst if needed). It returns a non-nil
+// *errLimited if the bucket is empty. The caller must hold h.mu.
+func (h *limitHandler) takeToken(client string, rate float64, burst int, now time.Time) *errLimited {
+	if rate <= 0 {
+		return nil
+	}
+	b := h.buckets[client]
+	if b == nil {
+		if len(h.buckets) >= maxIdleBuckets {
+			for c, b := range h.buckets {
+				if b.full(now) {
+					delete(h.buckets, c)
+				}
+			}
+		}
+		b = newTokenBucket(rate, burst, now)
+		h.buckets[client] = b
+	}
+	if ok, wait := b.take(now); !ok {
+		return &errLimited{msg: fmt.Sprintf("rate limit of %g requests per second exceeded", rate), wait: wait}
+	}
+	return nil
+}
+
+// isRequestTooLarge returns whether err was returned by reading a
+// request body that exceeded the limit set by http.MaxBytesReader.
+func isRequestTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
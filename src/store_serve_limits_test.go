@@ -0,0 +1,214 @@
+package src
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	b := newTokenBucket(2, 3, t0)
+
+	// The bucket starts full, so a burst of 3 is allowed.
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(t0); !ok {
+			t.Fatalf("take %d of burst: got !ok", i)
+		}
+	}
+	if ok, wait := b.take(t0); ok || wait != 500*time.Millisecond {
+		t.Errorf("take after burst: got ok=%v wait=%s, want !ok wait=500ms", ok, wait)
+	}
+
+	// It refills at the rate (2 per second).
+	if ok, _ := b.take(t0.Add(500 * time.Millisecond)); !ok {
+		t.Error("take after refill: got !ok")
+	}
+	if ok, wait := b.take(t0.Add(750 * time.Millisecond)); ok || wait != 250*time.Millisecond {
+		t.Errorf("take before next refill: got ok=%v wait=%s, want !ok wait=250ms", ok, wait)
+	}
+
+	// It never holds more than burst tokens.
+	t1 := t0.Add(time.Hour)
+	if !b.full(t1) {
+		t.Error("got !full after an hour")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(t1); !ok {
+			t.Fatalf("take %d of burst after an hour: got !ok", i)
+		}
+	}
+	if ok, _ := b.take(t1); ok {
+		t.Error("take after burst after an hour: got ok, want tokens capped at burst")
+	}
+
+	// The default burst is the rate (rounded up), and at least 1.
+	if b := newTokenBucket(2.5, 0, t0); b.burst != 3 {
+		t.Errorf("got default burst %g for rate 2.5, want 3", b.burst)
+	}
+	if b := newTokenBucket(0.1, 0, t0); b.burst != 1 {
+		t.Errorf("got default burst %g for rate 0.1, want 1", b.burst)
+	}
+}
+
+// testLimitHandler returns a limitHandler (wrapping a handler that
+// always succeeds) whose clock is *now.
+func testLimitHandler(conf *storeServeConfig, now *time.Time) *limitHandler {
+	if conf.MaxRequestBytes == 0 {
+		conf.MaxRequestBytes = defaultMaxRequestBytes
+	}
+	h := newLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), conf)
+	h.now = func() time.Time { return *now }
+	return h
+}
+
+// serveLimited makes a request to h and returns the response.
+func serveLimited(h http.Handler, remoteAddr, url, auth string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		panic(err)
+	}
+	req.RemoteAddr = remoteAddr
+	if auth != "" {
+		req.Header.Set("authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestLimitHandler_rateLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	h := testLimitHandler(&storeServeConfig{RateLimit: 1, Burst: 2}, &now)
+
+	for i := 0; i < 2; i++ {
+		if w := serveLimited(h, "1.2.3.4:1000", "/x", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d of burst: got status %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	w := serveLimited(h, "1.2.3.4:1001", "/x", "")
+	if w.Code != statusTooManyRequests {
+		t.Errorf("request after burst: got status %d, want %d", w.Code, statusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q, want %q", got, "1")
+	}
+
+	// Other clients have their own limits.
+	if w := serveLimited(h, "5.6.7.8:1000", "/x", ""); w.Code != http.StatusOK {
+		t.Errorf("request from another client: got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The bucket refills.
+	now = now.Add(time.Second)
+	if w := serveLimited(h, "1.2.3.4:1000", "/x", ""); w.Code != http.StatusOK {
+		t.Errorf("request after refill: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestLimitHandler_keys(t *testing.T) {
+	now := time.Date(2015, 6, 1, 23, 0, 0, 0, time.UTC)
+	h := testLimitHandler(&storeServeConfig{Keys: map[string]*apiKeyConfig{
+		"k1": {Name: "c1", DailyQuota: 2},
+		"k2": {Name: "c2", RateLimit: 1, Burst: 1},
+	}}, &now)
+
+	tests := []struct {
+		url, auth string
+		want      int
+	}{
+		{url: "/x", want: http.StatusUnauthorized},
+		{url: "/x?key=", want: http.StatusUnauthorized},
+		{url: "/x?key=bad", want: http.StatusUnauthorized},
+		{url: "/x", auth: "token bad", want: http.StatusUnauthorized},
+		{url: "/x", auth: "k1", want: http.StatusUnauthorized}, // not "token KEY"
+
+		// Requests made with the same key (from any address, and in
+		// either the header or the query) share its quota.
+		{url: "/x?key=k1", want: http.StatusOK},
+		{url: "/x", auth: "token k1", want: http.StatusOK},
+		{url: "/x?key=k1", want: statusTooManyRequests},
+
+		// Keys have their own limits.
+		{url: "/x", auth: "token k2", want: http.StatusOK},
+		{url: "/x", auth: "token k2", want: statusTooManyRequests},
+	}
+	for _, test := range tests {
+		w := serveLimited(h, "1.2.3.4:1000", test.url, test.auth)
+		if w.Code != test.want {
+			t.Errorf("%s (authorization %q): got status %d, want %d", test.url, test.auth, w.Code, test.want)
+		}
+	}
+
+	w := serveLimited(h, "5.6.7.8:1000", "/x?key=k1", "")
+	if w.Code != statusTooManyRequests || !strings.Contains(w.Body.String(), "daily quota of 2 requests exceeded") {
+		t.Errorf("request over quota: got status %d and body %q, want %d and daily quota exceeded", w.Code, w.Body.String(), statusTooManyRequests)
+	}
+	if got, want := w.Header().Get("Retry-After"), "3600"; got != want {
+		t.Errorf("request over quota: got Retry-After %q, want %q (until tomorrow)", got, want)
+	}
+
+	// The quota resets the next day (in UTC).
+	now = now.Add(time.Hour)
+	if w := serveLimited(h, "1.2.3.4:1000", "/x?key=k1", ""); w.Code != http.StatusOK {
+		t.Errorf("request on the next day: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestLimitHandler_maxRequestBytes(t *testing.T) {
+	now := time.Unix(0, 0)
+	h := testLimitHandler(&storeServeConfig{MaxRequestBytes: 10}, &now)
+	req, err := http.NewRequest("POST", "/x", strings.NewReader(strings.Repeat("x", 11)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "1.2.3.4:1000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestLimitHandler_admitGRPC(t *testing.T) {
+	now := time.Unix(0, 0)
+	h := testLimitHandler(&storeServeConfig{Keys: map[string]*apiKeyConfig{
+		"k1": {DailyQuota: 1},
+	}}, &now)
+
+	callCtx := func(auth string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1000}})
+		if auth != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", auth))
+		}
+		return ctx
+	}
+	tests := []struct {
+		auth string
+		want codes.Code
+	}{
+		{auth: "", want: codes.Unauthenticated},
+		{auth: "token bad", want: codes.Unauthenticated},
+		{auth: "token k1", want: codes.OK},
+		{auth: "token k1", want: codes.ResourceExhausted},
+	}
+	for _, test := range tests {
+		if code := grpc.Code(h.admitGRPC(callCtx(test.auth))); code != test.want {
+			t.Errorf("authorization %q: got code %s, want %s", test.auth, code, test.want)
+		}
+	}
+
+	// gRPC calls and HTTP requests with the same key share its quota.
+	if w := serveLimited(h, "1.2.3.4:1000", "/x?key=k1", ""); w.Code != statusTooManyRequests {
+		t.Errorf("HTTP request after gRPC calls used the quota: got status %d, want %d", w.Code, statusTooManyRequests)
+	}
+}
@@ -0,0 +1,88 @@
+package src
+
+import (
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreSnapshotCmd struct{}
+
+var storeSnapshotCmd StoreSnapshotCmd
+
+func (c *StoreSnapshotCmd) Execute(args []string) error { return nil }
+
+type StoreSnapshotCreateCmd struct {
+	Repo     string `long:"repo" description:"the repo (required for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"the commit to name (or the name of another snapshot)"`
+
+	Args struct {
+		Name string `name:"NAME" description:"snapshot name (e.g., release-1.4)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var storeSnapshotCreateCmd StoreSnapshotCreateCmd
+
+func (c *StoreSnapshotCreateCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return newCmdError(ExitUsage, fmt.Errorf("no --commit given"))
+	}
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	sc, ok := s.(store.SnapshotCreator)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement creating snapshots", s)
+	}
+	if err := sc.CreateSnapshot(c.Repo, c.Args.Name, c.CommitID); err != nil {
+		return err
+	}
+	logger.Infof("# Created snapshot %q of %s at %s.", c.Args.Name, c.Repo, c.CommitID)
+	return nil
+}
+
+type StoreSnapshotListCmd struct {
+	Repo string `long:"repo" description:"only list this repo's snapshots"`
+}
+
+var storeSnapshotListCmd StoreSnapshotListCmd
+
+func (c *StoreSnapshotListCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	ss, ok := s.(store.SnapshotStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing snapshots", s)
+	}
+	snapshots, err := ss.Snapshots(c.Repo)
+	if err != nil {
+		return err
+	}
+	PrintJSON(snapshots, "  ")
+	return nil
+}
+
+type StoreSnapshotDeleteCmd struct {
+	Repo string `long:"repo" description:"the repo (required for MultiRepoStores)"`
+
+	Args struct {
+		Name string `name:"NAME" description:"snapshot name"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var storeSnapshotDeleteCmd StoreSnapshotDeleteCmd
+
+func (c *StoreSnapshotDeleteCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	sc, ok := s.(store.SnapshotCreator)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement deleting snapshots", s)
+	}
+	return sc.DeleteSnapshot(c.Repo, c.Args.Name)
+}
@@ -0,0 +1,66 @@
+package src
+
+import (
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreStatsCmd struct {
+	Repo   string `long:"repo" description:"only show stats for this repo (default: all repos in the store)"`
+	Output string `short:"o" long:"output" description:"output format" default:"text" value-name:"text|json"`
+}
+
+var storeStatsCmd StoreStatsCmd
+
+func (c *StoreStatsCmd) Execute(args []string) error {
+	if c.Output != "text" && c.Output != "json" {
+		return newCmdError(ExitUsage, fmt.Errorf("unexpected --output value: %q", c.Output))
+	}
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	ss, ok := s.(store.StorageStatsStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement storage stats", s)
+	}
+
+	repos := []string{c.Repo}
+	if mrs, ok := s.(store.MultiRepoStore); ok && c.Repo == "" {
+		repos, err = mrs.Repos()
+		if err != nil {
+			return err
+		}
+	}
+
+	var allStats []*store.StorageStats
+	for _, repo := range repos {
+		stats, err := ss.StorageStats(repo)
+		if err != nil {
+			return err
+		}
+		if c.Output == "json" {
+			allStats = append(allStats, stats)
+			continue
+		}
+		printStorageStats(stats)
+	}
+	if c.Output == "json" {
+		PrintJSON(allStats, "")
+	}
+	return nil
+}
+
+func printStorageStats(s *store.StorageStats) {
+	if s.Repo != "" {
+		fmt.Println(s.Repo)
+	}
+	fmt.Printf("  commits:      %d (%d compacted)\n", s.Commits, s.Compacted)
+	fmt.Printf("  files:        %d (%d loose, %d deltas)\n", s.Files, s.LooseFiles, s.DeltaFiles)
+	if s.Blobs > 0 {
+		fmt.Printf("  blobs:        %d (%d refs; %d shared by multiple commits)\n", s.Blobs, s.BlobRefs, s.SharedBlobs)
+	}
+	fmt.Printf("  size:         %s\n", bytesString(uint64(s.Size)))
+	fmt.Printf("  stored size:  %s (%.1f%% saved)\n", bytesString(uint64(s.StoredSize)), 100*s.Saved())
+}
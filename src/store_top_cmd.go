@@ -0,0 +1,260 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+type StoreTopCmd struct {
+	URL      string        `long:"url" description:"URL of the daemon ('src store serve' or 'src store importd --http') to monitor" default:"http://localhost:3080"`
+	Key      string        `long:"key" description:"API key to send to the daemon (if it requires one)"`
+	Interval time.Duration `long:"interval" description:"how often to refresh the metrics" default:"2s"`
+	Slow     int           `long:"slow" description:"number of slowest recent queries to show" default:"10"`
+	Once     bool          `long:"once" description:"print one snapshot of the metrics as text and exit (rates are computed over --interval)"`
+}
+
+var storeTopCmd StoreTopCmd
+
+func (c *StoreTopCmd) Execute(args []string) error {
+	if c.Interval <= 0 {
+		return newCmdError(ExitUsage, fmt.Errorf("--interval must be positive"))
+	}
+
+	t := &storeTop{c: c}
+	if c.Once {
+		// Take two samples so that the rates can be computed.
+		if err := t.refresh(); err != nil {
+			return err
+		}
+		time.Sleep(c.Interval)
+		if err := t.refresh(); err != nil {
+			return err
+		}
+		for _, l := range t.lines() {
+			fmt.Println(l.text)
+		}
+		return nil
+	}
+
+	// Fail before opening the terminal UI if the daemon can't be
+	// reached.
+	if err := t.refresh(); err != nil {
+		return err
+	}
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+	return t.run()
+}
+
+// storeTop is a terminal UI that displays a daemon's live metrics
+// (see liveMetrics).
+type storeTop struct {
+	c *StoreTopCmd
+
+	prev, cur *metricsSnapshot // the previous and latest samples
+	err       error            // error from the last refresh
+}
+
+// fetch gets the daemon's current metrics.
+func (t *storeTop) fetch() (*metricsSnapshot, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(t.c.URL, "/")+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.c.Key != "" {
+		req.Header.Set("authorization", "token "+t.c.Key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("GET %s: HTTP %s: %s", req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var m metricsSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("GET %s: %s", req.URL, err)
+	}
+	return &m, nil
+}
+
+// refresh fetches a new sample of the metrics. If it fails, the last
+// sample is kept (and displayed along with the error).
+func (t *storeTop) refresh() error {
+	m, err := t.fetch()
+	t.err = err
+	if err != nil {
+		return err
+	}
+	t.prev, t.cur = t.cur, m
+	return nil
+}
+
+func (t *storeTop) run() error {
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			events <- termbox.PollEvent()
+		}
+	}()
+	tick := time.NewTicker(t.c.Interval)
+	defer tick.Stop()
+
+	for {
+		t.draw()
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case termbox.EventKey:
+				switch {
+				case ev.Key == termbox.KeyCtrlC, ev.Key == termbox.KeyEsc, ev.Ch == 'q':
+					return nil
+				case ev.Ch == 'r':
+					t.refresh()
+				}
+			case termbox.EventError:
+				return ev.Err
+			}
+		case <-tick.C:
+			t.refresh()
+		}
+	}
+}
+
+// A topLine is a line of storeTop's display.
+type topLine struct {
+	text   string
+	header bool
+}
+
+// lines returns the lines that display the latest sample of the
+// metrics.
+func (t *storeTop) lines() []topLine {
+	m := t.cur
+	var ls []topLine
+	add := func(header bool, format string, a ...interface{}) {
+		ls = append(ls, topLine{text: fmt.Sprintf(format, a...), header: header})
+	}
+
+	// rate returns the per-second rate of a counter since the previous
+	// sample (or "-" if there is none).
+	rate := func(f func(m *metricsSnapshot) int64) string {
+		if t.prev == nil {
+			return "-"
+		}
+		dt := m.Time.Sub(t.prev.Time).Seconds()
+		if dt <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", float64(f(m)-f(t.prev))/dt)
+	}
+
+	add(true, " %s  up %s  queries: %d (%s/s)  errors: %d (%s/s)", t.c.URL, m.Uptime/time.Second*time.Second,
+		m.Queries, rate(func(m *metricsSnapshot) int64 { return m.Queries }),
+		m.QueryErrors, rate(func(m *metricsSnapshot) int64 { return m.QueryErrors }))
+	add(false, "")
+
+	add(true, " IMPORTS")
+	add(false, "   active: %d  queued: %d", m.Imports.Active, m.Imports.Queued)
+	add(false, "   units: %d (%s/s)  defs: %d (%s/s)  refs: %d (%s/s)",
+		m.Imports.Units, rate(func(m *metricsSnapshot) int64 { return m.Imports.Units }),
+		m.Imports.Defs, rate(func(m *metricsSnapshot) int64 { return m.Imports.Defs }),
+		m.Imports.Refs, rate(func(m *metricsSnapshot) int64 { return m.Imports.Refs }))
+	add(false, "")
+
+	add(true, " CACHES")
+	caches := make([]string, 0, len(m.Caches))
+	for name := range m.Caches {
+		caches = append(caches, name)
+	}
+	sort.Strings(caches)
+	for _, name := range caches {
+		st := m.Caches[name]
+		add(false, "   %-12s %5.1f%% hits  (%d hits, %d misses)", name, 100*st.HitRate(), st.Hits, st.Misses)
+	}
+	add(false, "")
+
+	add(true, " IN-FLIGHT QUERIES (%d)", len(m.InFlight))
+	for _, q := range m.InFlight {
+		add(false, "   %s", formatTopQuery(q))
+	}
+	add(false, "")
+
+	slow := append([]*queryMetrics{}, m.RecentQueries...)
+	sort.Sort(sort.Reverse(queriesByDuration(slow)))
+	if len(slow) > t.c.Slow {
+		slow = slow[:t.c.Slow]
+	}
+	add(true, " SLOWEST RECENT QUERIES (of the last %d)", len(m.RecentQueries))
+	for _, q := range slow {
+		add(false, "   %s", formatTopQuery(q))
+	}
+	return ls
+}
+
+// formatTopQuery formats a query as a line of storeTop's display.
+func formatTopQuery(q *queryMetrics) string {
+	status := "..."
+	if q.Status != 0 {
+		status = fmt.Sprint(q.Status)
+	}
+	s := fmt.Sprintf("%10s  %3s  %-15s  %s", roundQueryDuration(q.Duration), status, q.Client, q.Method)
+	if q.Query != "" {
+		s += "?" + q.Query
+	}
+	return s
+}
+
+// roundQueryDuration rounds d to a precision suitable for display.
+func roundQueryDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d / (10 * time.Millisecond) * (10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d / (10 * time.Microsecond) * (10 * time.Microsecond)
+	}
+	return d
+}
+
+func (t *storeTop) draw() {
+	const coldef = termbox.ColorDefault
+	termbox.Clear(coldef, coldef)
+	w, h := termbox.Size()
+
+	ls := t.lines()
+	for i := 0; i < h-1 && i < len(ls); i++ {
+		fg := coldef
+		if ls[i].header {
+			fg |= termbox.AttrBold
+		}
+		if i == 0 {
+			fg |= termbox.AttrReverse
+		}
+		drawLine(i, ls[i].text, w, fg, coldef)
+	}
+
+	status := fmt.Sprintf("refreshing every %s  r refresh  q quit", t.c.Interval)
+	if t.err != nil {
+		status = "error: " + t.err.Error()
+	}
+	drawLine(h-1, status, w, coldef|termbox.AttrBold, coldef)
+	termbox.Flush()
+}
+
+type queriesByDuration []*queryMetrics
+
+func (v queriesByDuration) Len() int           { return len(v) }
+func (v queriesByDuration) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v queriesByDuration) Less(i, j int) bool { return v[i].Duration < v[j].Duration }
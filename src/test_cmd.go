@@ -15,7 +15,6 @@ import (
 	"strings"
 
 	"github.com/aybabtme/color/brush"
-	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 )
 
@@ -150,30 +149,26 @@ func testTree(treeDir, expectedDir, actualDir string, exeMethod string, generate
 		return err
 	}
 
-	// Symlink ${treeDir}/.srclib-cache/${commitID} to the desired output dir.
+	// Build into a temporary build data dir (so that nothing is
+	// written to the tree) in which ${commitID} is a symlink to the
+	// desired output dir.
 	//
-	// TODO(sqs): make `src make` not necessarily write to a .srclib-cache/...
-	// path containing the commit ID. When we're just making a tree, we don't
-	// know or care about the commit ID.
+	// TODO(sqs): make `src make` not necessarily write to a path
+	// containing the commit ID. When we're just making a tree, we
+	// don't know or care about the commit ID.
 	treeRepo, err := OpenRepo(treeDir)
 	if err != nil {
 		return err
 	}
-	origOutputDestDir := filepath.Join(treeDir, buildstore.BuildDataDirName, treeRepo.CommitID)
-	if err := os.Mkdir(filepath.Dir(origOutputDestDir), 0755); err != nil && !os.IsExist(err) {
-		return err
-	}
-	if err := os.Remove(origOutputDestDir); err != nil && !os.IsNotExist(err) {
+	buildDataDir, err := ioutil.TempDir("", "srclib-test-build-data")
+	if err != nil {
 		return err
 	}
-	if err := os.Symlink(outputDir, origOutputDestDir); err != nil {
+	defer os.RemoveAll(buildDataDir)
+	if err := os.Symlink(outputDir, filepath.Join(buildDataDir, treeRepo.CommitID)); err != nil {
 		return err
 	}
 
-	// Remove the symlink when we're done so the repo doesn't have
-	// uncommitted changes.
-	defer os.Remove(origOutputDestDir)
-
 	// Run `src make`.
 	var w io.Writer
 	var buf bytes.Buffer
@@ -185,7 +180,7 @@ func testTree(treeDir, expectedDir, actualDir string, exeMethod string, generate
 	cmd := exec.Command("src", "-v", "do-all", "-m", exeMethod)
 	cmd.Dir = treeDir
 	cmd.Stderr, cmd.Stdout = w, w
-	cmd.Env = append(os.Environ(), "SRCLIB_FOLLOW_CROSS_FS_SYMLINKS=true")
+	cmd.Env = append(os.Environ(), "SRCLIB_FOLLOW_CROSS_FS_SYMLINKS=true", buildDataDirEnv+"="+buildDataDir)
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("Command %v in %s failed: %s.\n\nOutput was:\n%s", cmd.Args, treeName, err, buf.String())
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"sourcegraph.com/sourcegraph/go-flags"
 
@@ -27,6 +28,7 @@ func init() {
 
 type ToolCmd struct {
 	ToolchainExecOpt
+	RuleStatsOpt
 
 	Args struct {
 		Toolchain ToolchainPath `name:"TOOLCHAIN" description:"toolchain path of the toolchain to run"`
@@ -38,6 +40,7 @@ type ToolCmd struct {
 var toolCmd ToolCmd
 
 func (c *ToolCmd) Execute(args []string) error {
+	start := time.Now()
 	tc, err := toolchain.Open(string(c.Args.Toolchain), c.ToolchainMode())
 	if err != nil {
 		log.Fatal(err)
@@ -87,6 +90,7 @@ func (c *ToolCmd) Execute(args []string) error {
 			}
 		}
 
+		c.recordRuleStats(start)
 		os.Stdout.Write(b)
 		return nil
 	}
@@ -73,6 +73,24 @@ func init() {
 		log.Fatal(err)
 	}
 
+	_, err = c.AddCommand("init",
+		"create a new toolchain",
+		"Create the skeleton of a new toolchain in DIR: a Srclibtoolchain file, a Dockerfile, a program (written in the language given by --impl) with stub scan, graph, and depresolve tools, and a fixture for 'src toolchain test'. The stub tools must be replaced with real analysis of the toolchain's language.",
+		&toolchainInitCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("test",
+		"test a toolchain against fixtures",
+		toolchainTestLongDesc,
+		&toolchainTestCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = c.AddCommand("temp-dir",
 		"get toolchain's temp dir",
 		"Get toolchain's temp directory. Creates it if it doesn't exists.",
@@ -120,6 +138,7 @@ func (t ToolchainPath) Complete(match string) []flags.Completion {
 
 type ToolchainExecOpt struct {
 	ExeMethods string `short:"m" long:"methods" default:"program,docker" description:"toolchain execution methods" value-name:"METHODS"`
+	Offline    bool   `long:"offline" description:"forbid toolchains from accessing the network (for air-gapped environments); dependency resolvers must use caches"`
 }
 
 func (o *ToolchainExecOpt) ToolchainMode() toolchain.Mode {
@@ -134,6 +153,9 @@ func (o *ToolchainExecOpt) ToolchainMode() toolchain.Mode {
 			mode |= toolchain.AsDockerContainer
 		}
 	}
+	if o.Offline {
+		mode |= toolchain.Offline
+	}
 	return mode
 }
 
@@ -290,6 +312,49 @@ func (c *ToolchainAddCmd) Execute(args []string) error {
 	return toolchain.Add(c.Dir, c.Args.ToolchainPath)
 }
 
+type ToolchainInitCmd struct {
+	Impl       string   `long:"impl" description:"language to write the toolchain's program in (go or python)" default:"go"`
+	Language   string   `long:"language" description:"language that the toolchain analyzes (default: DIR's name without any 'srclib-' prefix)"`
+	UnitType   string   `long:"unit-type" description:"type of the source units that the toolchain scans for (default: LANGUAGE + 'Package')"`
+	Extensions []string `long:"ext" description:"extension of the source files that the toolchain analyzes (can be specified multiple times; default: '.' + LANGUAGE)" value-name:"EXT"`
+	Add        string   `long:"add" description:"add the new toolchain to the SRCLIBPATH at this toolchain path" value-name:"TOOLCHAIN"`
+	Args       struct {
+		Dir string `name:"DIR" default:"." description:"directory to create the toolchain in"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var toolchainInitCmd ToolchainInitCmd
+
+func (c *ToolchainInitCmd) Execute(args []string) error {
+	opt := toolchain.ScaffoldOptions{
+		Language:   c.Language,
+		UnitType:   c.UnitType,
+		Extensions: c.Extensions,
+		Impl:       c.Impl,
+	}
+	if c.Add != "" {
+		// The program must be named after the toolchain path.
+		opt.Program = filepath.Base(c.Add)
+	}
+	files, err := toolchain.Scaffold(c.Args.Dir, opt)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		fmt.Println(brush.Green("created").String(), filepath.Join(c.Args.Dir, f))
+	}
+
+	if c.Add != "" {
+		if err := toolchain.Add(c.Args.Dir, c.Add); err != nil {
+			return err
+		}
+		fmt.Println(brush.Green("added").String(), "toolchain", c.Add)
+	}
+	fmt.Println()
+	fmt.Printf("Next, implement the stub tools, add fixtures to %s, and run 'make test' (see README.md).\n", filepath.Join(c.Args.Dir, "testdata", "conformance", "case"))
+	return nil
+}
+
 type ToolchainTempDirCmd struct {
 	Args struct {
 		ToolchainPath string `name:"TOOLCHAIN" description:"toolchain path for which to get temp dir"`
@@ -0,0 +1,356 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aybabtme/color/brush"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/scan"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+const toolchainTestLongDesc = `The test command checks that a toolchain's scan, graph, and depresolve tools behave correctly on the fixture repositories bundled with the toolchain.
+
+Fixtures are directory trees in FIXTURES/case/NAME, where FIXTURES is the toolchain's testdata/conformance directory (or the --fixtures dir). Fixtures whose name begins with "_" are skipped. For each fixture, the toolchain's scanners are run in the fixture's directory, and each source unit they find is graphed and depresolved by the toolchain's tools for the unit's type.
+
+The output is checked in two ways:
+
+* It is validated (as by 'src lint'): source units, defs, refs, and docs must have the required fields, refer to files that exist in the fixture, and have unique keys, intra-unit refs must resolve, and dep resolutions must have a target or an error.
+
+* It is compared to the golden output in FIXTURES/expected/NAME, which has the same layout as build data (e.g., UNIT/TYPE.graph.json). The actual output is written to FIXTURES/actual/NAME, and any differences are printed.
+
+If the --update flag is given, the golden output is replaced by the actual output instead. Check the new golden output before committing it; it's easy to accidentally commit incorrect output.
+
+EXAMPLE
+
+A toolchain at ~/.srclib/example.com/srclib-foo with the files
+
+  testdata/conformance/case/basic/foo/foo.foo
+  testdata/conformance/expected/basic/foo/FooPackage.unit.json
+  testdata/conformance/expected/basic/foo/FooPackage.graph.json
+  testdata/conformance/expected/basic/foo/FooPackage.depresolve.json
+
+is tested with:
+
+  src toolchain test example.com/srclib-foo
+`
+
+type ToolchainTestCmd struct {
+	ToolchainExecOpt
+
+	Fixtures string `long:"fixtures" description:"directory containing fixtures in case/NAME and golden output in expected/NAME (default: the toolchain's testdata/conformance dir)" value-name:"DIR"`
+	Update   bool   `long:"update" description:"update the golden output to match the actual output"`
+
+	Args struct {
+		Toolchain ToolchainPath `name:"TOOLCHAIN" description:"toolchain path of the toolchain to test"`
+		Fixtures  []string      `name:"FIXTURES" description:"only test these fixtures (by name)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var toolchainTestCmd ToolchainTestCmd
+
+func (c *ToolchainTestCmd) Execute(args []string) error {
+	tc, err := toolchain.Lookup(string(c.Args.Toolchain))
+	if err != nil {
+		return err
+	}
+	tcConfig, err := tc.ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	fixturesDir := c.Fixtures
+	if fixturesDir == "" {
+		fixturesDir = filepath.Join(tc.Dir, "testdata", "conformance")
+	}
+	fixturesDir, err = filepath.Abs(fixturesDir)
+	if err != nil {
+		return err
+	}
+
+	names := c.Args.Fixtures
+	if len(names) == 0 {
+		entries, err := ioutil.ReadDir(filepath.Join(fixturesDir, "case"))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), "_") {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no fixtures found in %s", filepath.Join(fixturesDir, "case"))
+	}
+
+	t := &toolchainTest{
+		toolchain: tc.Path,
+		tools:     tcConfig.Tools,
+		mode:      c.ToolchainMode(),
+	}
+	var failed []string
+	for _, name := range names {
+		logger.Debugf("Testing fixture %s...", name)
+		f := toolchainTestFixture{
+			name:        name,
+			dir:         filepath.Join(fixturesDir, "case", name),
+			expectedDir: filepath.Join(fixturesDir, "expected", name),
+			actualDir:   filepath.Join(fixturesDir, "actual", name),
+		}
+		ok, err := t.testFixture(f, c.Update)
+		if err != nil {
+			return fmt.Errorf("testing fixture %s: %s", name, err)
+		}
+		if !ok {
+			failed = append(failed, name)
+		}
+	}
+
+	if c.Update {
+		fmt.Printf("Updated golden output for %d fixtures. Check it for errors before committing it.\n", len(names))
+		return nil
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d fixtures failed: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// toolchainTest runs a toolchain's tools on fixtures and checks their
+// output.
+type toolchainTest struct {
+	toolchain string // toolchain path
+	tools     []*toolchain.ToolInfo
+	mode      toolchain.Mode
+}
+
+type toolchainTestFixture struct {
+	name string
+
+	dir         string // fixture tree
+	expectedDir string // golden output
+	actualDir   string // actual output
+}
+
+// testFixture runs the toolchain on f and checks its output. If
+// update is true, the output is written to f's golden output dir and
+// only validated. It returns whether the fixture passed.
+func (t *toolchainTest) testFixture(f toolchainTestFixture, update bool) (bool, error) {
+	outputDir := f.actualDir
+	if update {
+		outputDir = f.expectedDir
+	}
+	if err := os.RemoveAll(outputDir); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return false, err
+	}
+
+	files, err := t.run(f.dir, outputDir)
+	if err != nil {
+		return false, err
+	}
+
+	issues, err := t.validate(f.dir, outputDir, files)
+	if err != nil {
+		return false, err
+	}
+
+	var diff []byte
+	if !update {
+		if _, err := os.Stat(f.expectedDir); os.IsNotExist(err) {
+			issues = append(issues, fmt.Sprintf("no golden output in %s (run with --update to create it)", f.expectedDir))
+		} else if out, err := exec.Command("diff", "-ur", f.expectedDir, f.actualDir).CombinedOutput(); err != nil || len(out) > 0 {
+			issues = append(issues, "output differs from golden output (- expected, + actual)")
+			diff = out
+		}
+	}
+
+	if len(issues) == 0 {
+		fmt.Println(brush.Green(f.name + " PASS").String())
+		return true, nil
+	}
+	fmt.Println(brush.Red(f.name + " FAIL").String())
+	for _, issue := range issues {
+		fmt.Println("  " + issue)
+	}
+	if len(diff) > 0 {
+		fmt.Println(string(ColorizeDiff(diff)))
+	}
+	return false, nil
+}
+
+// toolchainTestOutputFile is an output file written by
+// (*toolchainTest).run.
+type toolchainTestOutputFile struct {
+	path string // relative to the output dir
+	unit *unit.SourceUnit
+	data interface{}
+}
+
+// run runs the toolchain's scanners in dir, and its grapher and
+// dependency resolver on each source unit they find, and writes their
+// output to outputDir in the layout of build data.
+func (t *toolchainTest) run(dir, outputDir string) ([]toolchainTestOutputFile, error) {
+	// Tools are run in the current directory.
+	origDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	defer os.Chdir(origDir)
+
+	var scanners []toolchain.Tool
+	for _, ti := range t.tools {
+		if ti.Op == "scan" {
+			tool, err := t.openTool(ti)
+			if err != nil {
+				return nil, err
+			}
+			scanners = append(scanners, tool)
+		}
+	}
+	if len(scanners) == 0 {
+		return nil, fmt.Errorf("toolchain %s has no scanners", t.toolchain)
+	}
+	// The fixtures aren't in a repository, so the Repo fields are left
+	// blank, as they are in the output of the tools.
+	units, err := scan.ScanMulti(scanners, scan.Options{Options: config.Options{Subdir: "."}, Quiet: !GlobalOpt.Verbose}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []toolchainTestOutputFile
+	for _, u := range units {
+		files = append(files, toolchainTestOutputFile{plan.SourceUnitDataFilename(unit.SourceUnit{}, u), u, u})
+
+		if ti := t.unitTool("graph", u.Type); ti != nil {
+			tool, err := t.openTool(ti)
+			if err != nil {
+				return nil, err
+			}
+			var o graph.Output
+			if err := tool.Run(nil, u, &o); err != nil {
+				return nil, fmt.Errorf("graphing source unit %s: %s", u.ID(), err)
+			}
+			if err := grapher.NormalizeData("", u.Type, u.Dir, &o); err != nil {
+				return nil, fmt.Errorf("normalizing graph output for source unit %s: %s", u.ID(), err)
+			}
+			files = append(files, toolchainTestOutputFile{plan.SourceUnitDataFilename(&graph.Output{}, u), u, &o})
+		}
+
+		if ti := t.unitTool("depresolve", u.Type); ti != nil {
+			tool, err := t.openTool(ti)
+			if err != nil {
+				return nil, err
+			}
+			var ress []*dep.Resolution
+			if err := tool.Run(nil, u, &ress); err != nil {
+				return nil, fmt.Errorf("resolving deps of source unit %s: %s", u.ID(), err)
+			}
+			sort.Sort(resolutionsByJSON(ress))
+			files = append(files, toolchainTestOutputFile{plan.SourceUnitDataFilename([]*dep.ResolvedDep{}, u), u, ress})
+		}
+	}
+
+	for _, f := range files {
+		if err := writeIndentedJSONFile(filepath.Join(outputDir, f.path), f.data); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// validate checks the output files in outputDir (of the fixture in
+// dir) for the issues that 'src lint' detects.
+func (t *toolchainTest) validate(dir, outputDir string, files []toolchainTestOutputFile) ([]string, error) {
+	var allIssues []string
+	for _, f := range files {
+		path := filepath.Join(outputDir, f.path)
+		var issues []string
+		var err error
+		switch f.data.(type) {
+		case *unit.SourceUnit:
+			issues, err = lintSourceUnit(dir, path, true)
+		case *graph.Output:
+			issues, err = lintGraphOutput(dir, "", f.unit.Type, f.unit.Name, path, true)
+		case []*dep.Resolution:
+			issues, err = lintDepresolveOutput(dir, path, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		allIssues = append(allIssues, prependLabelToStrings(f.path, issues)...)
+	}
+	return allIssues, nil
+}
+
+// unitTool returns the toolchain's tool that performs op on source
+// units of type unitType, or nil if there is none.
+func (t *toolchainTest) unitTool(op, unitType string) *toolchain.ToolInfo {
+	for _, ti := range t.tools {
+		if ti.Op != op {
+			continue
+		}
+		for _, ut := range ti.SourceUnitTypes {
+			if ut == unitType {
+				return ti
+			}
+		}
+	}
+	return nil
+}
+
+func (t *toolchainTest) openTool(ti *toolchain.ToolInfo) (toolchain.Tool, error) {
+	tool, err := toolchain.OpenTool(t.toolchain, ti.Subcmd, t.mode)
+	if err != nil {
+		return nil, err
+	}
+	if !GlobalOpt.Verbose {
+		tool.SetLogger(log.New(ioutil.Discard, "", 0))
+	}
+	return tool, nil
+}
+
+// writeIndentedJSONFile writes the indented JSON encoding of v to
+// file (creating its parent dirs), so that it can be diffed.
+func writeIndentedJSONFile(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// resolutionsByJSON sorts dep resolutions by their JSON encoding, so
+// that the golden output doesn't depend on the order in which the
+// dependency resolver emits them.
+type resolutionsByJSON []*dep.Resolution
+
+func (r resolutionsByJSON) Len() int      { return len(r) }
+func (r resolutionsByJSON) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r resolutionsByJSON) Less(i, j int) bool {
+	bi, _ := json.Marshal(r[i])
+	bj, _ := json.Marshal(r[j])
+	return string(bi) < string(bj)
+}
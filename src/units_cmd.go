@@ -40,11 +40,35 @@ func scanUnitsIntoConfig(cfg *config.Repository, configOpt config.Options, execO
 		scanners[i] = scanner
 	}
 
-	units, err := scan.ScanMulti(scanners, scan.Options{Options: configOpt, Quiet: quiet}, cfg.Config)
+	// Tell scanners which paths to skip, so that they needn't scan
+	// them.
+	scanConfig := cfg.Config
+	if len(cfg.SkipPaths) > 0 {
+		scanConfig = make(map[string]interface{}, len(cfg.Config)+1)
+		for k, v := range cfg.Config {
+			scanConfig[k] = v
+		}
+		scanConfig["SkipPaths"] = cfg.SkipPaths
+	}
+
+	unitsByScanner, err := scan.ScanEach(scanners, scan.Options{Options: configOpt, Quiet: quiet}, scanConfig)
 	if err != nil {
 		return err
 	}
 
+	// Heed SkipPaths (in case the scanners didn't) and the Srcfiles in
+	// subdirectories (whose Config properties take precedence over the
+	// top-level Srcfile's, so they are merged first).
+	skip := cfg.SkipPathsRules()
+	var units []*unit.SourceUnit
+	for i, units2 := range unitsByScanner {
+		for _, u := range units2 {
+			if skip.FilterUnit(u) && cfg.ApplyOverlays(u, cfg.Scanners[i].Toolchain) {
+				units = append(units, u)
+			}
+		}
+	}
+
 	// Merge the repo/tree config with each source unit's config.
 	if cfg.Config == nil {
 		cfg.Config = map[string]interface{}{}
@@ -64,14 +88,20 @@ func scanUnitsIntoConfig(cfg *config.Repository, configOpt config.Options, execO
 
 	// collect manually specified source units by ID
 	manualUnits := make(map[unit.ID]*unit.SourceUnit, len(cfg.SourceUnits))
-	for _, u := range cfg.SourceUnits {
-		manualUnits[u.ID()] = u
-
+	srcfileUnits := cfg.SourceUnits
+	cfg.SourceUnits = nil
+	for _, u := range srcfileUnits {
 		xf, err := unit.ExpandPaths(".", u.Files)
 		if err != nil {
 			return err
 		}
 		u.Files = xf
+
+		if !skip.FilterUnit(u) || !cfg.ApplyOverlays(u, "") {
+			continue
+		}
+		cfg.SourceUnits = append(cfg.SourceUnits, u)
+		manualUnits[u.ID()] = u
 	}
 
 	for _, u := range units {
@@ -0,0 +1,312 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	urlGroup, err := CLI.AddCommand("url",
+		"generate URLs for graph data",
+		"The url command group contains subcommands that generate URLs (deep links) for graph data, so that other tools can render clickable links from srclib output.",
+		&urlCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := urlGroup.AddCommand("def",
+		"generate a permalink to a def",
+		`The def command prints a stable URL for a def, given its key (--repo, --commit, --unit-type, --unit, and --path), or for each def in a JSON array (or stream) of defs read from stdin if --path is not given (e.g., the output of 'src store defs').
+
+URLs are generated by a template (see --template), which is a built-in template name or a Go text/template string. The built-in templates are:
+
+  sourcegraph  https://sourcegraph.com/REPO@COMMIT/.UNITTYPE/UNIT/.def/PATH
+  github       https://REPO/blob/COMMIT/FILE#LSTART-LEND
+  cgit         https://REPO/tree/FILE?id=COMMIT#nSTART
+
+Templates are executed with the fields Repo, CommitID, UnitType, Unit, Path, Name, and Kind, and (when known) File, Start and End (byte offsets), and StartLine and EndLine. The file and position of a def whose key is given are looked up in the store. Lines are computed from the local repository's files if it is the def's repository and is checked out at the def's commit. Templates may use the functions pathEscape and queryEscape.
+
+More templates can be defined in a JSON file (see --templates) that maps template names to templates. The default template can be set with the SRC_FLAG_TEMPLATE environment variable.`,
+		&urlDefCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setDefaultRepoURIOpt(c)
+	setDefaultCommitIDOpt(c)
+}
+
+type URLCmd struct{}
+
+var urlCmd URLCmd
+
+func (c *URLCmd) Execute(args []string) error { return nil }
+
+// builtinURLTemplates are the URL templates that can be used by name
+// in 'src url def --template'.
+var builtinURLTemplates = map[string]string{
+	"sourcegraph": `https://sourcegraph.com/{{.Repo}}@{{.CommitID}}/.{{.UnitType}}/{{.Unit}}/.def/{{.Path}}`,
+	"github":      `https://{{.Repo}}/blob/{{.CommitID}}/{{.File}}{{if .StartLine}}#L{{.StartLine}}{{if gt .EndLine .StartLine}}-L{{.EndLine}}{{end}}{{end}}`,
+	"cgit":        `https://{{.Repo}}/tree/{{.File}}?id={{.CommitID}}{{if .StartLine}}#n{{.StartLine}}{{end}}`,
+}
+
+type URLDefCmd struct {
+	Repo     string `long:"repo" description:"repository URI of the def"`
+	CommitID string `long:"commit" description:"commit ID of the def"`
+	UnitType string `long:"unit-type" description:"source unit type of the def (default: look up in the store)"`
+	Unit     string `long:"unit" description:"source unit name of the def (default: look up in the store)"`
+	Path     string `long:"path" description:"def path (if omitted, defs are read from stdin)"`
+
+	Template  string `long:"template" description:"URL template (a built-in or --templates name, or a Go text/template string)" default:"sourcegraph"`
+	Templates string `long:"templates" description:"JSON file mapping template names to URL templates" value-name:"FILE"`
+}
+
+var urlDefCmd URLDefCmd
+
+func (c *URLDefCmd) Execute(args []string) error {
+	tmpl, err := c.urlTemplate()
+	if err != nil {
+		return err
+	}
+
+	var defs []*graph.Def
+	if c.Path != "" {
+		def, err := c.lookupDef()
+		if err != nil {
+			return err
+		}
+		defs = []*graph.Def{def}
+	} else {
+		if defs, err = readDefsJSON(os.Stdin); err != nil {
+			return fmt.Errorf("reading defs from stdin: %s", err)
+		}
+		// Defs from RepoStores (and below) don't have their repo and
+		// commit set.
+		for _, def := range defs {
+			if def.Repo == "" {
+				def.Repo = c.Repo
+			}
+			if def.CommitID == "" {
+				def.CommitID = c.CommitID
+			}
+		}
+	}
+
+	files := map[string][]byte{}
+	for _, def := range defs {
+		u, err := defURL(tmpl, def, files)
+		if err != nil {
+			return fmt.Errorf("generating URL for def %s: %s", def.Path, err)
+		}
+		fmt.Println(u)
+	}
+	return nil
+}
+
+// urlTemplate returns the template named (or given) by c.Template.
+func (c *URLDefCmd) urlTemplate() (*template.Template, error) {
+	return parseURLTemplate(c.Template, c.Templates)
+}
+
+// parseURLTemplate returns the URL template with the given name (a
+// built-in template or one defined in the JSON templatesFile, if
+// non-empty), or, if there is none and name is a template string, the
+// template that it defines.
+func parseURLTemplate(name, templatesFile string) (*template.Template, error) {
+	templates := map[string]string{}
+	for n, t := range builtinURLTemplates {
+		templates[n] = t
+	}
+	if templatesFile != "" {
+		var user map[string]string
+		if err := readJSONFile(templatesFile, &user); err != nil {
+			return nil, fmt.Errorf("reading --templates: %s", err)
+		}
+		for n, t := range user {
+			templates[n] = t
+		}
+	}
+
+	text, ok := templates[name]
+	if !ok {
+		if !strings.Contains(name, "{{") {
+			names := make([]string, 0, len(templates))
+			for n := range templates {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, newCmdError(ExitUsage, fmt.Errorf("unknown URL template %q (templates: %s)", name, strings.Join(names, ", ")))
+		}
+		text = name
+	}
+
+	tmpl, err := template.New("url").Option("missingkey=error").Funcs(template.FuncMap{
+		"pathEscape":  pathEscape,
+		"queryEscape": url.QueryEscape,
+	}).Parse(text)
+	if err != nil {
+		return nil, newCmdError(ExitUsage, fmt.Errorf("parsing URL template: %s", err))
+	}
+	return tmpl, nil
+}
+
+// lookupDef returns the def specified by c's flags, with its file and
+// position if it is in the store. Defs that are not in the store are
+// returned with only their key, so that templates that only use the
+// key (such as "sourcegraph") still work.
+func (c *URLDefCmd) lookupDef() (*graph.Def, error) {
+	key := graph.DefKey{Repo: c.Repo, CommitID: c.CommitID, UnitType: c.UnitType, Unit: c.Unit, Path: c.Path}
+	if (key.UnitType == "") != (key.Unit == "") {
+		return nil, newCmdError(ExitUsage, fmt.Errorf("--unit-type and --unit must be given together"))
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return nil, err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return &graph.Def{DefKey: key}, nil
+	}
+	fs := []store.DefFilter{store.ByDefPath(key.Path)}
+	if key.UnitType != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: key.UnitType, Name: key.Unit}))
+	}
+	if _, isMultiRepo := s.(store.MultiRepoStore); isMultiRepo && key.Repo != "" {
+		fs = append(fs, store.ByRepos(key.Repo))
+	}
+	if key.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(key.CommitID))
+	}
+	defs, err := us.Defs(fs...)
+	if err != nil && !store.IsNotExist(err) {
+		return nil, err
+	}
+	switch {
+	case len(defs) == 0:
+		if key.UnitType == "" {
+			return nil, fmt.Errorf("def %q not found in the store (specify --unit-type and --unit to generate URLs for defs that are not in the store)", key.Path)
+		}
+		logger.Debugf("# Def %q not found in the store; its file and position are unknown.", key.Path)
+		return &graph.Def{DefKey: key}, nil
+	case len(defs) > 1 && key.UnitType == "":
+		return nil, fmt.Errorf("def path %q is ambiguous (it is in %d source units); specify --unit-type and --unit", key.Path, len(defs))
+	}
+
+	def := defs[0]
+	// Defs in RepoStores (and below) don't have their repo set.
+	if def.Repo == "" {
+		def.Repo = key.Repo
+	}
+	if def.CommitID == "" {
+		def.CommitID = key.CommitID
+	}
+	return def, nil
+}
+
+// readDefsJSON reads defs from r, which contains a JSON array of defs
+// or a stream of JSON defs.
+func readDefsJSON(r io.Reader) ([]*graph.Def, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var defs []*graph.Def
+		if err := json.Unmarshal(data, &defs); err != nil {
+			return nil, err
+		}
+		return defs, nil
+	}
+
+	var defs []*graph.Def
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var def graph.Def
+		if err := dec.Decode(&def); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		defs = append(defs, &def)
+	}
+	return defs, nil
+}
+
+// defURL executes the URL template for def. The lines of def's
+// position are computed from the local repository's copy of def's
+// file if it has the def's commit checked out; files caches the
+// contents of the files that have been read.
+func defURL(tmpl *template.Template, def *graph.Def, files map[string][]byte) (string, error) {
+	data := map[string]interface{}{
+		"Repo":     def.Repo,
+		"CommitID": def.CommitID,
+		"UnitType": def.UnitType,
+		"Unit":     def.Unit,
+		"Path":     def.Path,
+		"Name":     def.Name,
+		"Kind":     def.Kind,
+	}
+	if def.File != "" {
+		data["File"] = def.File
+		data["Start"] = def.DefStart
+		data["End"] = def.DefEnd
+
+		// Templates can test {{if .StartLine}} when lines are unknown.
+		data["StartLine"], data["EndLine"] = 0, 0
+		if src := localRepoFile(def.Repo, def.CommitID, def.File, files); src != nil && int(def.DefEnd) <= len(src) && def.DefStart <= def.DefEnd {
+			data["StartLine"], _ = lineCol(src, int(def.DefStart))
+			data["EndLine"], _ = lineCol(src, int(def.DefEnd))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		if strings.Contains(err.Error(), "map has no entry for key") {
+			return "", fmt.Errorf("the URL template needs the def's file and position, which are unknown (the def was not found in the store): %s", err)
+		}
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// localRepoFile returns the contents of file in the local repository
+// if it is repo at commitID, or nil otherwise.
+func localRepoFile(repo, commitID, file string, files map[string][]byte) []byte {
+	lrepo, err := openLocalRepo()
+	if err != nil || lrepo == nil || lrepo.RootDir == "" || lrepo.URI() != repo || lrepo.CommitID != commitID {
+		return nil
+	}
+	src, present := files[file]
+	if !present {
+		src, _ = ioutil.ReadFile(filepath.Join(lrepo.RootDir, filepath.FromSlash(file)))
+		files[file] = src
+	}
+	return src
+}
+
+// pathEscape escapes each component of the slash-separated path p for
+// use in a URL path.
+func pathEscape(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(part), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
@@ -121,6 +121,20 @@ func readJSONFile(file string, v interface{}) error {
 	return json.NewDecoder(f).Decode(v)
 }
 
+func writeJSONFile(file string, v interface{}) (err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	return json.NewEncoder(f).Encode(v)
+}
+
 func readJSONFileFS(fs vfs.FileSystem, file string, v interface{}) (err error) {
 	f, err := fs.Open(file)
 	if err != nil {
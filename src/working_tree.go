@@ -0,0 +1,136 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// workingTreeEnv is the name of the env var that, if true, makes src
+// build, import, and query the data of uncommitted working trees under
+// a synthetic commit ID (see store.WorkingTreeCommitID) instead of the
+// commit they are based on. It is an env var (not a flag) because the
+// default --commit of many commands is determined before flags are
+// parsed.
+const workingTreeEnv = "SRCLIB_WORKING_TREE"
+
+// workingTreeKeys returns whether working tree commit IDs are enabled
+// (see workingTreeEnv).
+func workingTreeKeys() bool {
+	v, _ := strconv.ParseBool(os.Getenv(workingTreeEnv))
+	return v
+}
+
+// workingTreeCommitID returns the synthetic commit ID (see
+// store.WorkingTreeCommitID) of the working tree of the repository
+// whose top-level dir is dir, which is based on the baseCommitID
+// commit. It returns "" if the working tree has no uncommitted
+// changes (including untracked files that aren't ignored).
+func workingTreeCommitID(vcsType, dir, baseCommitID string) (string, error) {
+	var diffCmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		diffCmd = exec.Command("git", "diff", "--binary", "HEAD", "--")
+	case "hg":
+		diffCmd = exec.Command("hg", "--config", "trusted.users=root", "diff", "--git")
+	default:
+		return "", fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	diff, err := vcsOutput(diffCmd, dir)
+	if err != nil {
+		return "", err
+	}
+	untracked, err := untrackedFiles(vcsType, dir)
+	if err != nil {
+		return "", err
+	}
+	if len(diff) == 0 && len(untracked) == 0 {
+		return "", nil
+	}
+	contents := []io.Reader{strings.NewReader(baseCommitID + "\n"), bytes.NewReader(diff)}
+
+	// Untracked files aren't in the diff, so hash their names and
+	// contents too.
+	for _, name := range untracked {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		contents = append(contents, strings.NewReader(fmt.Sprintf("\x00%s\x00%d\x00", name, len(data))), bytes.NewReader(data))
+	}
+	return store.WorkingTreeCommitID(io.MultiReader(contents...))
+}
+
+// untrackedFiles returns the untracked files (that aren't ignored) in
+// the working tree of the repository whose top-level dir is dir,
+// relative to dir. The files that src itself writes to the tree are
+// omitted, so that building and importing doesn't change the working
+// tree's commit ID.
+func untrackedFiles(vcsType, dir string) ([]string, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "ls-files", "-z", "--others", "--exclude-standard")
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "status", "--unknown", "--no-status", "--print0")
+	default:
+		return nil, fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := vcsOutput(cmd, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ignoreDirs []string
+	if root, err := localBuildDataRoot(dir); err == nil {
+		ignoreDirs = append(ignoreDirs, root)
+	}
+	ignoreDirs = append(ignoreDirs, filepath.Join(dir, store.SrclibStoreDir))
+	var untracked []string
+	for _, name := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if name != "" && !underAnyDir(filepath.Join(dir, name), ignoreDirs) {
+			untracked = append(untracked, name)
+		}
+	}
+	return untracked, nil
+}
+
+// underAnyDir returns whether path is in (or is) any of dirs.
+func underAnyDir(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// vcsOutput runs cmd in dir and returns its output.
+func vcsOutput(cmd *exec.Cmd, dir string) ([]byte, error) {
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("exec %v failed: %s (%s)", cmd.Args, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// blobsDir is the dir (at the root of a fsRepoStore) that holds the
+// content-addressed blobs of the commits that were compacted with
+// CompactOpt.Dedup. It begins with a "." so that it is skipped when
+// listing versions.
+const blobsDir = ".srclib-blobs"
+
+// blobsFS returns the VFS of the repo's blobs.
+func (s *fsRepoStore) blobsFS() rwvfs.FileSystem {
+	return rwvfs.Sub(s.fs, blobsDir)
+}
+
+// blobFilename returns the name of the blob with the given hash (in
+// blobsDir). Blobs are in subdirs named by the first 2 characters of
+// their hash, like git objects.
+func blobFilename(hash string) string {
+	return path.Join(hash[:2], hash)
+}
+
+// blobGroup returns the source unit (as "UNIT/TYPE") whose data is in
+// the packable file name. All of a unit's data files are stored in the
+// same blob.
+func blobGroup(name string) string {
+	return path.Dir(name)
+}
+
+// isCommitSpecific returns whether the packable file name holds data
+// that refers to the commit it was imported at: a source unit's file
+// (its CommitID) or deps file (their FromCommitID). Such files are
+// never identical in different commits, so they are stored in the
+// commit's pack file instead of in a blob, so that they don't prevent
+// the unit's other files from being shared by the commits.
+func isCommitSpecific(name string) bool {
+	return strings.HasSuffix(name, unitFileSuffix) || strings.HasSuffix(name, unitDepsFileSuffix)
+}
+
+// writeBlobs stores the files (read from fs) in blobs in blobs, one
+// blob per source unit (see blobGroup), and adds their entries to
+// idx. Blobs that already exist (because another commit has a unit
+// with identical data) are not written again.
+func writeBlobs(blobs, fs rwvfs.FileSystem, files []compactFile, idx *packIndex, stats *CompactStats) error {
+	groups := map[string][]compactFile{}
+	for _, file := range files {
+		g := blobGroup(file.name)
+		groups[g] = append(groups[g], file)
+	}
+	groupNames := make([]string, 0, len(groups))
+	for g := range groups {
+		groupNames = append(groupNames, g)
+	}
+	sort.Strings(groupNames)
+	vlog.Printf("compactPack: writing %d files to %d blobs.", len(files), len(groupNames))
+
+	for _, g := range groupNames {
+		var buf bytes.Buffer
+		entries := make([]packEntry, len(groups[g]))
+		for i, file := range groups[g] {
+			data, err := vfs.ReadFile(fs, file.name)
+			if err != nil {
+				return err
+			}
+			entries[i] = packEntry{Offset: int64(buf.Len()), Size: int64(len(data)), ModTime: file.modTime}
+			buf.Write(data)
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		hash := hex.EncodeToString(sum[:])
+		written, err := writeBlob(blobs, hash, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if written {
+			stats.NewBlobs++
+			stats.Size += int64(buf.Len())
+		}
+		for i, file := range groups[g] {
+			entries[i].Blob = hash
+			idx.Files[file.name] = entries[i]
+		}
+	}
+	stats.Blobs = len(groupNames)
+	return nil
+}
+
+// writeBlob writes data to the blob with the given hash (of data),
+// unless the blob already exists. It returns whether it wrote the
+// blob.
+func writeBlob(blobs rwvfs.FileSystem, hash string, data []byte) (written bool, err error) {
+	name := blobFilename(hash)
+	if fi, err := blobs.Stat(name); err == nil && fi.Size() == int64(len(data)) {
+		return false, nil
+	} else if err != nil && !isOSOrVFSNotExist(err) {
+		return false, err
+	}
+	// Otherwise, the blob doesn't exist, or it was only partially
+	// written (e.g., because a previous compaction failed).
+
+	if err := rwvfs.MkdirAll(blobs, path.Dir(name)); err != nil {
+		return false, err
+	}
+	f, err := blobs.Create(name)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alecthomas/binary"
+	"github.com/gogo/protobuf/proto"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+const brokenRefsIndexName = "broken_refs"
+
+// brokenRefsIndex records, for each source unit in a tree, how many
+// of its refs resolve and which defs its broken refs point to (see
+// RefResolution).
+type brokenRefsIndex struct {
+	resolutions []*RefResolution // sorted by unit
+	ready       bool
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	unitRefDefIndexBuilder
+} = (*brokenRefsIndex)(nil)
+
+func (x *brokenRefsIndex) String() string { return fmt.Sprintf("brokenRefsIndex(ready=%v)", x.ready) }
+
+// Covers implements Index. The broken refs index is only consulted by
+// RefResolutions, never to satisfy other queries.
+func (x *brokenRefsIndex) Covers(filters interface{}) int { return 0 }
+
+// Build implements unitRefDefIndexBuilder.
+func (x *brokenRefsIndex) Build(units []*unit.SourceUnit, unitRefIndexes map[unit.ID2]*defRefsIndex, unitDefIndexes map[unit.ID2]*defPathIndex) error {
+	vlog.Printf("brokenRefsIndex: building index (%d units)...", len(units))
+	x.resolutions = make([]*RefResolution, 0, len(units))
+	for _, u := range units {
+		r := &RefResolution{UnitType: u.Type, Unit: u.Name}
+		x.resolutions = append(x.resolutions, r)
+
+		rx := unitRefIndexes[u.ID2()]
+		if rx == nil {
+			continue
+		}
+		for it := rx.phtable.Iterate(); it != nil; it = it.Next() {
+			kb, vb := it.Get()
+			if len(kb) == 0 {
+				// Empty slot in the phtable.
+				continue
+			}
+			var def graph.RefDefKey
+			if err := proto.Unmarshal(kb, &def); err != nil {
+				return err
+			}
+			var ofs byteOffsets
+			if err := binary.Unmarshal(vb, &ofs); err != nil {
+				return err
+			}
+
+			if def.DefRepo != "" {
+				r.CrossRepoRefs += len(ofs)
+				continue
+			}
+
+			// Set implied fields.
+			if def.DefUnit == "" {
+				def.DefUnit = u.Name
+			}
+			if def.DefUnitType == "" {
+				def.DefUnitType = u.Type
+			}
+
+			r.Refs += len(ofs)
+			// Def path indexes that were built before they stored
+			// their keys find every path, so trees must be reindexed
+			// for their broken refs to be found.
+			if dx := unitDefIndexes[unit.ID2{Type: def.DefUnitType, Name: def.DefUnit}]; dx != nil {
+				if _, found := dx.getByPath(def.DefPath); found {
+					continue
+				}
+			}
+			r.Broken += len(ofs)
+			r.BrokenDefs = append(r.BrokenDefs, def)
+		}
+		sort.Sort(refDefKeys(r.BrokenDefs))
+	}
+	sort.Sort(refResolutionsByUnit(x.resolutions))
+	x.ready = true
+	vlog.Printf("brokenRefsIndex: done building index.")
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *brokenRefsIndex) Write(w io.Writer) error {
+	if x.resolutions == nil {
+		panic("no resolutions to write")
+	}
+	return json.NewEncoder(w).Encode(x.resolutions)
+}
+
+// Read implements persistedIndex.
+func (x *brokenRefsIndex) Read(r io.Reader) error {
+	err := json.NewDecoder(r).Decode(&x.resolutions)
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *brokenRefsIndex) Ready() bool { return x.ready }
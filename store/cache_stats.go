@@ -0,0 +1,47 @@
+package store
+
+import "sync/atomic"
+
+// CacheStats are the number of hits and misses of one of the store
+// package's caches since the process started.
+type CacheStats struct {
+	Hits, Misses int64
+}
+
+// HitRate returns the fraction of lookups that were hits (0 if there
+// were no lookups).
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// cacheCounter counts the hits and misses of a cache (atomically).
+type cacheCounter struct{ hits, misses int64 }
+
+func (c *cacheCounter) hit()  { atomic.AddInt64(&c.hits, 1) }
+func (c *cacheCounter) miss() { atomic.AddInt64(&c.misses, 1) }
+
+func (c *cacheCounter) stats() CacheStats {
+	return CacheStats{Hits: atomic.LoadInt64(&c.hits), Misses: atomic.LoadInt64(&c.misses)}
+}
+
+var (
+	diskCacheCounter  cacheCounter // see NewDiskCacheFS
+	queryCacheCounter cacheCounter // see QueryCache
+	openCacheCounter  cacheCounter // see CacheOpenStores
+)
+
+// AllCacheStats returns the hit and miss counts of the store
+// package's caches, keyed by cache: "disk" (the local disk cache of
+// remote stores; see NewDiskCacheFS), "query" (see QueryCache), and
+// "open-stores" (see CacheOpenStores). Caches that are not in use
+// have no hits or misses.
+func AllCacheStats() map[string]CacheStats {
+	return map[string]CacheStats{
+		"disk":        diskCacheCounter.stats(),
+		"query":       queryCacheCounter.stats(),
+		"open-stores": openCacheCounter.stats(),
+	}
+}
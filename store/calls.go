@@ -0,0 +1,437 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A CallStore stores and accesses call edges (see graph.Call). It is
+// implemented by the FS-backed and in-memory stores at all levels
+// (MultiRepoStore, RepoStore, TreeStore, and UnitStore).
+type CallStore interface {
+	// Calls returns all call edges that match the filters.
+	Calls(...CallFilter) ([]*graph.Call, error)
+}
+
+// A CallFilter filters a set of call edges to only those for which
+// SelectCall returns true.
+type CallFilter interface {
+	SelectCall(*graph.Call) bool
+}
+
+type callFilters []CallFilter
+
+func (fs callFilters) SelectCall(call *graph.Call) bool {
+	for _, f := range fs {
+		if !f.SelectCall(call) {
+			return false
+		}
+	}
+	return true
+}
+
+// A CallFilterFunc is a CallFilter that selects only those call
+// edges for which the func returns true.
+type CallFilterFunc func(*graph.Call) bool
+
+// SelectCall calls f(call).
+func (f CallFilterFunc) SelectCall(call *graph.Call) bool { return f(call) }
+func (f CallFilterFunc) String() string                   { return "CallFilterFunc" }
+
+// ByCallerPathFilter is implemented by filters that restrict their
+// selection to calls made by a def with a specific def path.
+type ByCallerPathFilter interface {
+	ByCallerPath() string
+}
+
+// ByCallerPath returns a filter that selects the calls made by the
+// def with the given path (i.e., it can be used to list a def's
+// callees). Because a caller is always in the same source unit as
+// the calls it makes, use it along with ByUnits (or ByUnitKey) to
+// select the calls made by a specific def. It panics if defPath is
+// empty.
+func ByCallerPath(defPath string) interface {
+	CallFilter
+	ByCallerPathFilter
+} {
+	if defPath == "" {
+		panic("defPath: empty")
+	}
+	return byCallerPathFilter(defPath)
+}
+
+type byCallerPathFilter string
+
+func (f byCallerPathFilter) String() string       { return fmt.Sprintf("ByCallerPath(%s)", string(f)) }
+func (f byCallerPathFilter) ByCallerPath() string { return string(f) }
+func (f byCallerPathFilter) SelectCall(call *graph.Call) bool {
+	return call.CallerPath == string(f)
+}
+
+// ByCalleeFilter is implemented by filters that restrict their
+// selection to calls to a specific def.
+type ByCalleeFilter interface {
+	ByCallee() graph.RefDefKey
+
+	withEmptyImpliedValues() graph.RefDefKey // see byRefDefFilter.withEmptyImpliedValues
+}
+
+// ByCallee returns a filter that selects the calls to the given def
+// (i.e., it can be used to list a def's callers). It panics if
+// def.DefPath is empty. As with ByRefDef, the def should be fully
+// specified (DefRepo, DefUnitType, and DefUnit should be set).
+func ByCallee(def graph.RefDefKey) CallFilter {
+	if def.DefPath == "" {
+		panic("def.DefPath: empty")
+	}
+	return &byCalleeFilter{def: def}
+}
+
+// byCalleeFilter is like byRefDefFilter, except that it filters call
+// edges (and not refs). See byRefDefFilter for an explanation of
+// the implied* fields.
+type byCalleeFilter struct {
+	def graph.RefDefKey
+
+	impliedRepo string   // the implied CalleeRepo value when call.CalleeRepo == ""
+	impliedUnit unit.ID2 // the implied CalleeUnit{,Type} value when call.CalleeUnit{,Type} == ""
+}
+
+func (f *byCalleeFilter) String() string {
+	return fmt.Sprintf("ByCallee(%+v, impliedRepo=%q, impliedUnit=%+v)", f.def, f.impliedRepo, f.impliedUnit)
+}
+func (f *byCalleeFilter) ByCallee() graph.RefDefKey  { return f.def }
+func (f *byCalleeFilter) setImpliedRepo(repo string) { f.impliedRepo = repo }
+func (f *byCalleeFilter) setImpliedUnit(u unit.ID2)  { f.impliedUnit = u }
+func (f *byCalleeFilter) SelectCall(call *graph.Call) bool {
+	return ((call.CalleeRepo == "" && f.impliedRepo == f.def.DefRepo) || call.CalleeRepo == f.def.DefRepo) &&
+		((call.CalleeUnitType == "" && f.impliedUnit.Type == f.def.DefUnitType) || call.CalleeUnitType == f.def.DefUnitType) &&
+		((call.CalleeUnit == "" && f.impliedUnit.Name == f.def.DefUnit) || call.CalleeUnit == f.def.DefUnit) &&
+		call.CalleePath == f.def.DefPath
+}
+
+// withEmptyImpliedValues returns the callee def key with empty field
+// values for fields whose value in f.def matches the implied value
+// (which is how callees are keyed in the calls index).
+func (f *byCalleeFilter) withEmptyImpliedValues() graph.RefDefKey {
+	def := graph.RefDefKey{DefPath: f.def.DefPath}
+	if f.def.DefRepo != f.impliedRepo {
+		def.DefRepo = f.def.DefRepo
+	}
+	if f.def.DefUnitType != f.impliedUnit.Type {
+		def.DefUnitType = f.def.DefUnitType
+	}
+	if f.def.DefUnit != f.impliedUnit.Name {
+		def.DefUnit = f.def.DefUnit
+	}
+	return def
+}
+
+var _ impliedRepoSetter = (*byCalleeFilter)(nil)
+var _ impliedUnitSetter = (*byCalleeFilter)(nil)
+
+func (s repoStores) Calls(f ...CallFilter) ([]*graph.Call, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allCalls []*graph.Call
+	for repo, rs := range rss {
+		cs, ok := rs.(CallStore)
+		if !ok {
+			continue
+		}
+
+		setImpliedRepo(f, repo)
+		calls, err := cs.Calls(filtersForRepo(repo, f).([]CallFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, call := range calls {
+			call.Repo = repo
+			if call.CalleeRepo == "" {
+				call.CalleeRepo = repo
+			}
+		}
+		allCalls = append(allCalls, calls...)
+	}
+	sortCalls(allCalls, f)
+	return allCalls, nil
+}
+
+func (s treeStores) Calls(f ...CallFilter) ([]*graph.Call, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]CallFilter)
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allCalls []*graph.Call
+	for commitID, ts := range tss {
+		cs, ok := ts.(CallStore)
+		if !ok {
+			continue
+		}
+
+		setImpliedCommitID(f, commitID)
+		calls, err := cs.Calls(f...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, call := range calls {
+			call.CommitID = commitID
+		}
+		allCalls = append(allCalls, calls...)
+	}
+	sortCalls(allCalls, f)
+	return allCalls, nil
+}
+
+func (s unitStores) Calls(f ...CallFilter) ([]*graph.Call, error) {
+	uss, err := openUnitStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allCalls []*graph.Call
+	for u, us := range uss {
+		cs, ok := us.(CallStore)
+		if !ok {
+			continue
+		}
+
+		setImpliedUnit(f, u)
+		calls, err := cs.Calls(filtersForUnit(u, f).([]CallFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, call := range calls {
+			call.UnitType = u.Type
+			call.Unit = u.Name
+			if call.CalleeUnitType == "" {
+				call.CalleeUnitType = u.Type
+			}
+			if call.CalleeUnit == "" {
+				call.CalleeUnit = u.Name
+			}
+		}
+		allCalls = append(allCalls, calls...)
+	}
+	sortCalls(allCalls, f)
+	return allCalls, nil
+}
+
+func sortCalls(calls []*graph.Call, fs []CallFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(graph.Calls(calls))
+}
+
+const unitCallsFilename = "call.dat"
+
+// Calls implements CallStore. Stores that were imported before call
+// edges were stored have no call data file; they are treated as
+// having no calls.
+func (s *fsUnitStore) Calls(fs ...CallFilter) (calls []*graph.Call, err error) {
+	vlog.Printf("%s: reading calls with filters %v...", s, fs)
+	f, err := s.fs.Open(unitCallsFilename)
+	if err != nil {
+		if isOSOrVFSNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	dec := s.codec.NewDecoder(f)
+	for {
+		var call graph.Call
+		if _, err := dec.Decode(&call); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if callFilters(fs).SelectCall(&call) {
+			calls = append(calls, &call)
+		}
+	}
+	vlog.Printf("%s: read %d calls with filters %v.", s, len(calls), fs)
+	return calls, nil
+}
+
+// callsAtOffsets reads the calls at the given serialized byte
+// offsets from the call data file.
+func (s *fsUnitStore) callsAtOffsets(ofs byteOffsets, fs []CallFilter) (calls []*graph.Call, err error) {
+	vlog.Printf("%s: reading calls at %d offsets with filters %v...", s, len(ofs), fs)
+	f, err := openFetcherOrOpen(s.fs, unitCallsFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	ffs := callFilters(fs)
+
+	var callsLock sync.Mutex
+	err = readAtOffsets(s.fs, unitCallsFilename, f, ofs, decodeBufSize, fs, func(r io.Reader) error {
+		var call graph.Call
+		if _, err := s.codec.NewDecoder(r).Decode(&call); err != nil {
+			return err
+		}
+		if ffs.SelectCall(&call) {
+			callsLock.Lock()
+			calls = append(calls, &call)
+			callsLock.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	vlog.Printf("%s: read %d calls at %d offsets with filters %v.", s, len(calls), len(ofs), fs)
+	return calls, nil
+}
+
+// readCalls reads all calls from the call data file and returns them
+// along with their serialized byte offsets.
+func (s *fsUnitStore) readCalls() (calls []*graph.Call, ofs byteOffsets, err error) {
+	vlog.Printf("%s: reading calls and byte offsets...", s)
+	f, err := s.fs.Open(unitCallsFilename)
+	if err != nil {
+		if isOSOrVFSNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	n := uint64(0)
+	dec := s.codec.NewDecoder(f)
+	for {
+		var call graph.Call
+		o, err := dec.Decode(&call)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		ofs = append(ofs, int64(n))
+		calls = append(calls, &call)
+		n += o
+	}
+	vlog.Printf("%s: read %d calls and byte offsets.", s, len(calls))
+	return calls, ofs, nil
+}
+
+// writeCalls writes the call data file. It also tracks (in ofs) the
+// serialized byte offset where each call's serialized representation
+// begins (which is used during index construction).
+func (s *fsUnitStore) writeCalls(calls []*graph.Call) (ofs byteOffsets, err error) {
+	vlog.Printf("%s: writing %d calls...", s, len(calls))
+	f, err := s.fs.Create(unitCallsFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	enc := s.codec.NewEncoder(bw)
+	ofs = make(byteOffsets, len(calls))
+	var o uint64
+	for i, call := range calls {
+		ofs[i] = int64(o)
+		n, err := enc.Encode(call)
+		if err != nil {
+			return nil, err
+		}
+		o += n
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	vlog.Printf("%s: done writing %d calls.", s, len(calls))
+	return ofs, nil
+}
+
+// Calls implements CallStore.
+func (s *indexedUnitStore) Calls(fs ...CallFilter) ([]*graph.Call, error) {
+	// Try to find an index that covers this query.
+	if xname, bx := bestCoverageIndex(s.indexes, fs, isCallIndex); bx != nil {
+		err := prepareIndex(s.fs, xname, bx)
+		if err == nil {
+			vlog.Printf("indexedUnitStore.Calls(%v): Found covering index %q (%v).", fs, xname, bx)
+			ofs, err := bx.(callIndex).Calls(fs...)
+			if err != nil {
+				return nil, err
+			}
+			return s.callsAtOffsets(ofs, fs)
+		}
+		// Units imported before call edges were stored have no call
+		// indexes.
+		if _, ok := err.(*errIndexNotExist); !ok {
+			return nil, err
+		}
+	}
+
+	// Fall back to full scan.
+	return s.fsUnitStore.Calls(fs...)
+}
+
+// Calls implements CallStore.
+func (s *memoryUnitStore) Calls(f ...CallFilter) ([]*graph.Call, error) {
+	if s.data == nil {
+		return nil, errUnitNoInit
+	}
+
+	var calls []*graph.Call
+	for _, call := range s.data.Calls {
+		if callFilters(f).SelectCall(call) {
+			calls = append(calls, call)
+		}
+	}
+	return calls, nil
+}
+
+var (
+	_ CallStore = (*fsMultiRepoStore)(nil)
+	_ CallStore = (*fsRepoStore)(nil)
+	_ CallStore = (*fsTreeStore)(nil)
+	_ CallStore = (*indexedTreeStore)(nil)
+	_ CallStore = (*fsUnitStore)(nil)
+	_ CallStore = (*indexedUnitStore)(nil)
+	_ CallStore = (*memoryMultiRepoStore)(nil)
+	_ CallStore = (*memoryRepoStore)(nil)
+	_ CallStore = (*memoryTreeStore)(nil)
+	_ CallStore = (*memoryUnitStore)(nil)
+)
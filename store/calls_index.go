@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/alecthomas/binary"
+	"github.com/gogo/protobuf/proto"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+)
+
+// callsIndex makes it fast to determine which calls (within a source
+// unit) are made by a def (if byCallee is false) or are to a def (if
+// byCallee is true).
+type callsIndex struct {
+	byCallee bool
+
+	phtable *phtable.CHD
+	ready   bool
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	callIndex
+	callIndexBuilder
+} = (*callsIndex)(nil)
+
+func (x *callsIndex) String() string {
+	return fmt.Sprintf("callsIndex(byCallee=%v, ready=%v)", x.byCallee, x.ready)
+}
+
+// key returns the index key for call.
+func (x *callsIndex) key(call *graph.Call) ([]byte, error) {
+	if x.byCallee {
+		def := call.CalleeDefKey()
+		return proto.Marshal(&def)
+	}
+	return []byte(call.CallerPath), nil
+}
+
+func (x *callsIndex) get(k []byte) (byteOffsets, bool, error) {
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+	v := x.phtable.Get(k)
+	if v == nil {
+		return nil, false, nil
+	}
+
+	var ofs byteOffsets
+	if err := binary.Unmarshal(v, &ofs); err != nil {
+		return nil, true, err
+	}
+	return ofs, true, nil
+}
+
+// Covers implements Index.
+func (x *callsIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		switch f.(type) {
+		case ByCalleeFilter:
+			if x.byCallee {
+				cov++
+			}
+		case ByCallerPathFilter:
+			if !x.byCallee {
+				cov++
+			}
+		}
+	}
+	return cov
+}
+
+// Calls implements callIndex.
+func (x *callsIndex) Calls(fs ...CallFilter) (byteOffsets, error) {
+	for _, f := range fs {
+		var k []byte
+		switch f := f.(type) {
+		case ByCalleeFilter:
+			if !x.byCallee {
+				continue
+			}
+			def := f.withEmptyImpliedValues()
+			var err error
+			k, err = proto.Marshal(&def)
+			if err != nil {
+				return nil, err
+			}
+		case ByCallerPathFilter:
+			if x.byCallee {
+				continue
+			}
+			k = []byte(f.ByCallerPath())
+		default:
+			continue
+		}
+		ofs, _, err := x.get(k)
+		return ofs, err
+	}
+	return nil, nil
+}
+
+// Build implements callIndexBuilder.
+func (x *callsIndex) Build(calls []*graph.Call, ofs byteOffsets) error {
+	vlog.Printf("%s: building index (%d calls)...", x, len(calls))
+	keyToOfs := map[string]byteOffsets{}
+	for i, call := range calls {
+		k, err := x.key(call)
+		if err != nil {
+			return err
+		}
+		keyToOfs[string(k)] = append(keyToOfs[string(k)], ofs[i])
+	}
+
+	b := phtable.Builder(len(keyToOfs))
+	for k, callOfs := range keyToOfs {
+		v, err := binary.Marshal(callOfs)
+		if err != nil {
+			return err
+		}
+		b.Add([]byte(k), v)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("%s: done building index.", x)
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *callsIndex) Write(w io.Writer) error {
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// Read implements persistedIndex.
+func (x *callsIndex) Read(r io.Reader) error {
+	var err error
+	x.phtable, err = phtable.Read(r)
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *callsIndex) Ready() bool { return x.ready }
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_Calls(t *testing.T) {
+	testMultiRepoStore_Calls(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Calls(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_Calls(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_Calls(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_Calls(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_Calls(t *testing.T, mrs MultiRepoStoreImporter) {
+	cs := mrs.(CallStore)
+
+	lib := &unit.SourceUnit{Type: "t", Name: "lib"}
+	if err := mrs.Import("r", "c", lib, graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "f"}}, {DefKey: graph.DefKey{Path: "g"}}},
+		Calls: []*graph.Call{
+			{CallerPath: "f", CalleePath: "g", File: "lib.go", Start: 10, End: 11},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	app := &unit.SourceUnit{Type: "t", Name: "app"}
+	if err := mrs.Import("r", "c", app, graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "main"}}},
+		Calls: []*graph.Call{
+			{CallerPath: "main", CalleeRepo: "r", CalleeUnitType: "t", CalleeUnit: "lib", CalleePath: "g", File: "main.go", Start: 1, End: 2},
+			{CallerPath: "main", CalleeRepo: "r", CalleeUnitType: "t", CalleeUnit: "lib", CalleePath: "f", File: "main.go", Start: 5, End: 6},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if mrs, ok := mrs.(MultiRepoIndexer); ok {
+		if err := mrs.Index("r", "c"); err != nil {
+			t.Fatalf("%s: Index: %s", mrs, err)
+		}
+	}
+
+	// Callees of main.
+	calls, err := cs.Calls(ByUnits(app.ID2()), ByCallerPath("main"))
+	if err != nil {
+		t.Fatalf("%s: Calls(ByCallerPath(main)): %s", mrs, err)
+	}
+	var callees []string
+	for _, call := range calls {
+		callees = append(callees, call.CalleePath)
+	}
+	if want := []string{"f", "g"}; !reflect.DeepEqual(callees, want) {
+		t.Errorf("%s: Calls(ByCallerPath(main)): got callees %v, want %v", mrs, callees, want)
+	}
+
+	// Callers of lib's g (called from both lib and app).
+	calls, err = cs.Calls(ByCallee(graph.RefDefKey{DefRepo: "r", DefUnitType: "t", DefUnit: "lib", DefPath: "g"}))
+	if err != nil {
+		t.Fatalf("%s: Calls(ByCallee(g)): %s", mrs, err)
+	}
+	want := []*graph.Call{
+		{CallerPath: "main", CalleeRepo: "r", CalleeUnitType: "t", CalleeUnit: "lib", CalleePath: "g", Repo: "r", CommitID: "c", UnitType: "t", Unit: "app", File: "main.go", Start: 1, End: 2},
+		{CallerPath: "f", CalleeRepo: "r", CalleeUnitType: "t", CalleeUnit: "lib", CalleePath: "g", Repo: "r", CommitID: "c", UnitType: "t", Unit: "lib", File: "lib.go", Start: 10, End: 11},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("%s: Calls(ByCallee(g)): got %v, want %v", mrs, calls, want)
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A Change is a notification that data in a store changed, so that
+// UIs and caches can react to new commits appearing in the store.
+type Change struct {
+	// ID identifies the change. IDs are ordered by the time that the
+	// changes were published.
+	ID string
+
+	// Type is the kind of change (ChangeImport or ChangeIndex).
+	Type string
+
+	Repo     string     `json:",omitempty"`
+	CommitID string     `json:",omitempty"`
+	Units    []unit.ID2 `json:",omitempty"`
+
+	Time time.Time
+}
+
+// Types of changes.
+const (
+	ChangeImport = "import" // data for a commit was imported
+	ChangeIndex  = "index"  // a commit's indexes were built
+)
+
+// A ChangePublisher records changes so that they can be listed by
+// ChangeLog.Changes (including by other processes using the same
+// store). It is implemented by the FS-backed and in-memory
+// MultiRepoStores.
+type ChangePublisher interface {
+	// PublishChange records c, setting its ID and (if zero) Time.
+	PublishChange(c *Change) error
+}
+
+// A ChangeLog lists the recent changes to a store. Only the most
+// recent changes are retained (see maxChanges).
+type ChangeLog interface {
+	// Changes returns the retained changes whose IDs are greater than
+	// after (all of them if after is empty), oldest first.
+	Changes(after string) ([]*Change, error)
+}
+
+// maxChanges is the number of most recent changes that are retained.
+const maxChanges = 1000
+
+// newChangeID returns a new change ID for a change published at t.
+// The random suffix distinguishes changes published at the same time
+// by different processes.
+func newChangeID(t time.Time) string {
+	return fmt.Sprintf("%020d-%08x", t.UnixNano(), rand.Uint32())
+}
+
+// changesDir is the dir (at the root of a FS-backed MultiRepoStore)
+// that holds the change log, with each change in the file
+// "CHANGEID.json". It begins with a "." so that it is skipped when
+// listing repos.
+const changesDir = ".srclib-changes"
+
+func (s *fsMultiRepoStore) PublishChange(c *Change) error {
+	if c.Repo != "" {
+		c.Repo = s.canonicalRepo(c.Repo)
+	}
+	if c.Time.IsZero() {
+		c.Time = time.Now().UTC()
+	}
+	c.ID = newChangeID(c.Time)
+	if err := rwvfs.MkdirAll(s.fs, changesDir); err != nil {
+		return err
+	}
+	if err := writeJSONFile(s.fs, s.fs.Join(changesDir, c.ID+".json"), c); err != nil {
+		return err
+	}
+
+	// Remove the oldest changes.
+	ids, err := s.changeIDs()
+	if err != nil {
+		return err
+	}
+	if len(ids) > maxChanges {
+		for _, id := range ids[:len(ids)-maxChanges] {
+			if err := s.fs.Remove(s.fs.Join(changesDir, id+".json")); err != nil && !isOSOrVFSNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changeIDs returns the IDs of the changes in the change log, in
+// order.
+func (s *fsMultiRepoStore) changeIDs() ([]string, error) {
+	fis, err := s.fs.ReadDir(changesDir)
+	if isOSOrVFSNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if name := fi.Name(); strings.HasSuffix(name, ".json") && !strings.HasPrefix(name, ".") {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *fsMultiRepoStore) Changes(after string) ([]*Change, error) {
+	ids, err := s.changeIDs()
+	if err != nil {
+		return nil, err
+	}
+	i := sort.SearchStrings(ids, after)
+	if i < len(ids) && ids[i] == after {
+		i++
+	}
+
+	var changes []*Change
+	for _, id := range ids[i:] {
+		var c Change
+		if err := readJSONFile(s.fs, s.fs.Join(changesDir, id+".json"), &c); isOSOrVFSNotExist(err) {
+			continue // removed since it was listed
+		} else if isPartialJSON(err) {
+			// The change is still being written. Stop here so that the
+			// caller lists it (and the changes after it) next time.
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if c.Repo != "" && !s.inScope(c.Repo) {
+			continue
+		}
+		changes = append(changes, &c)
+	}
+	return changes, nil
+}
+
+// isPartialJSON returns whether err is the error returned by decoding
+// a file that is partially written.
+func isPartialJSON(err error) bool {
+	_, ok := err.(*json.SyntaxError)
+	return ok || err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func (s *memoryMultiRepoStore) PublishChange(c *Change) error {
+	if c.Time.IsZero() {
+		c.Time = time.Now().UTC()
+	}
+	c.ID = newChangeID(c.Time)
+	s.changes = append(s.changes, c)
+	sort.Sort(changesByID(s.changes))
+	if len(s.changes) > maxChanges {
+		s.changes = s.changes[len(s.changes)-maxChanges:]
+	}
+	return nil
+}
+
+func (s *memoryMultiRepoStore) Changes(after string) ([]*Change, error) {
+	var changes []*Change
+	for _, c := range s.changes {
+		if c.ID > after {
+			c2 := *c
+			changes = append(changes, &c2)
+		}
+	}
+	return changes, nil
+}
+
+type changesByID []*Change
+
+func (v changesByID) Len() int           { return len(v) }
+func (v changesByID) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v changesByID) Less(i, j int) bool { return v[i].ID < v[j].ID }
+
+var (
+	_ ChangePublisher = (*fsMultiRepoStore)(nil)
+	_ ChangePublisher = (*memoryMultiRepoStore)(nil)
+	_ ChangeLog       = (*fsMultiRepoStore)(nil)
+	_ ChangeLog       = (*memoryMultiRepoStore)(nil)
+)
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryMultiRepoStore_Changes(t *testing.T) {
+	testMultiRepoStore_Changes(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Changes(t *testing.T) {
+	testMultiRepoStore_Changes(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_Changes(t *testing.T, mrs MultiRepoStoreImporter) {
+	cl := mrs.(ChangeLog)
+	cp := mrs.(ChangePublisher)
+
+	changes, err := cl.Changes("")
+	if err != nil {
+		t.Fatalf("%s: Changes: %s", mrs, err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("%s: Changes before publishing: got %d changes, want none", mrs, len(changes))
+	}
+
+	t0 := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, typ := range []string{ChangeImport, ChangeIndex} {
+		if err := cp.PublishChange(&Change{Type: typ, Repo: "r", CommitID: "c", Time: t0.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("%s: PublishChange: %s", mrs, err)
+		}
+	}
+
+	changes, err = cl.Changes("")
+	if err != nil {
+		t.Fatalf("%s: Changes: %s", mrs, err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("%s: Changes: got %d changes, want 2", mrs, len(changes))
+	}
+	if changes[0].Type != ChangeImport || changes[1].Type != ChangeIndex {
+		t.Errorf("%s: Changes: got types %q and %q, want %q and %q (oldest first)", mrs, changes[0].Type, changes[1].Type, ChangeImport, ChangeIndex)
+	}
+	if changes[0].Repo != "r" || changes[0].CommitID != "c" || !changes[0].Time.Equal(t0) {
+		t.Errorf("%s: Changes: got %+v, want repo r, commit c, time %s", mrs, changes[0], t0)
+	}
+
+	// Only changes after the given ID are listed.
+	after, err := cl.Changes(changes[0].ID)
+	if err != nil {
+		t.Fatalf("%s: Changes(after): %s", mrs, err)
+	}
+	if len(after) != 1 || after[0].ID != changes[1].ID {
+		t.Errorf("%s: Changes(after): got %v, want only the 2nd change", mrs, after)
+	}
+	if after, err := cl.Changes(changes[1].ID); err != nil {
+		t.Fatalf("%s: Changes(after last): %s", mrs, err)
+	} else if len(after) != 0 {
+		t.Errorf("%s: Changes(after last): got %d changes, want none", mrs, len(after))
+	}
+}
+
+func TestFSMultiRepoStore_Changes_outOfScope(t *testing.T) {
+	mrs := NewFSMultiRepoStore(newTestFS(), &FSMultiRepoStoreConf{
+		Namespaces: map[string][]string{"a": {"a/"}, "b": {"b/"}},
+		Scope:      []string{"a"},
+	})
+	for _, repo := range []string{"a/r", "b/r"} {
+		if err := mrs.(ChangePublisher).PublishChange(&Change{Type: ChangeImport, Repo: repo, CommitID: "c"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes, err := mrs.(ChangeLog).Changes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Repo != "a/r" {
+		t.Errorf("got %v, want only the change to a/r", changes)
+	}
+}
@@ -3,19 +3,196 @@ package store
 import (
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
 
+	"sourcegraph.com/sourcegraph/rwvfs"
 	"sourcegraph.com/sourcegraph/srclib/store/pbio"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 
 	"github.com/gogo/protobuf/proto"
+	"gopkg.in/vmihailenco/msgpack.v2"
 )
 
-// Codec is the codec used by all file-backed stores. It should only be
-// set at init time or when you can guarantee that no stores will be
-// using the codec.
+// Codec is the default codec of file-backed stores: the codec used by
+// new stores (unless another is set with SetStoreCodec) and by stores
+// whose format file doesn't record a codec. Stores whose format file
+// records a codec always use that codec. Codec should only be set at
+// init time.
 var Codec codec = ProtobufCodec{}
 
+// Codecs maps codec names (which are recorded in the store's format
+// file and may be passed to SetCodec and SetStoreCodec) to codecs.
+var Codecs = map[string]codec{
+	"json":          JSONCodec{},
+	"protobuf":      ProtobufCodec{},
+	"protobuf-full": FullProtobufCodec{},
+	"msgpack":       MsgpackCodec{},
+}
+
+// defaultCodecName is the name of the codec used by stores whose
+// format file doesn't record a codec (which were all written before
+// the codec was configurable).
+const defaultCodecName = "protobuf"
+
+// SetCodec sets Codec to the codec with the given name (see
+// Codecs). The same caveats apply as for setting Codec directly.
+func SetCodec(name string) error {
+	c, err := codecByName(name)
+	if err != nil {
+		return err
+	}
+	Codec = c
+	return nil
+}
+
+func codecByName(name string) (codec, error) {
+	c, present := Codecs[name]
+	if !present {
+		names := make([]string, 0, len(Codecs))
+		for name := range Codecs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unrecognized codec %q (valid codecs are: %v)", name, names)
+	}
+	return c, nil
+}
+
+// SetStoreCodec sets the codec that the FS-backed store s uses to the
+// codec with the given name (see Codecs). It must be called before s
+// is used. If s already records a different codec in its format file,
+// an error is returned (use ConvertCodec to re-encode a store).
+func SetStoreCodec(s interface{}, name string) error {
+	c, err := codecByName(name)
+	if err != nil {
+		return err
+	}
+	if s, ok := s.(*shardedMultiRepoStore); ok {
+		for _, shard := range s.shards {
+			if err := SetStoreCodec(shard, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sc := storeCodecOf(s)
+	if sc == nil {
+		return fmt.Errorf("store (type %T) does not have an on-disk format", s)
+	}
+	return sc.setNew(c, name)
+}
+
+// storeCodecOf returns the codec of a FS-backed store, or nil if s is
+// not a FS-backed store.
+func storeCodecOf(s interface{}) *storeCodec {
+	switch s := s.(type) {
+	case *fsMultiRepoStore:
+		return s.codec
+	case *fsRepoStore:
+		return s.codec
+	}
+	return nil
+}
+
+// storeCodec is the codec of a FS-backed store. A store and all of
+// the repo, tree, and unit stores that it opens share the same
+// storeCodec, which reads the codec from the store's format file
+// when it is first used (so that each store is read with the codec
+// that it was written with, even if stores with different codecs are
+// open at once). If the store has no format file, it is new (or
+// predates format versioning), and it uses Codec or the codec set
+// with SetStoreCodec.
+//
+// A storeCodec's encoders and decoders return an error if the format
+// file can't be read or records an unknown codec.
+type storeCodec struct {
+	fs rwvfs.FileSystem // root of the store (with the format file)
+
+	mu  sync.Mutex
+	c   codec // the resolved codec, or nil if not yet resolved
+	err error
+}
+
+func newStoreCodec(fs rwvfs.FileSystem) *storeCodec {
+	return &storeCodec{fs: fs}
+}
+
+// get returns the store's codec.
+func (sc *storeCodec) get() (codec, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.c != nil || sc.err != nil {
+		return sc.c, sc.err
+	}
+	sf, err := readFormat(sc.fs)
+	if err != nil {
+		return nil, err // don't cache (it may be a transient error)
+	}
+	if sf.Codec == "" {
+		sc.c = Codec
+	} else {
+		sc.c, sc.err = codecByName(sf.Codec)
+	}
+	return sc.c, sc.err
+}
+
+// set makes the store use c (after ConvertCodec has converted it).
+func (sc *storeCodec) set(c codec) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.c, sc.err = c, nil
+}
+
+// setNew implements SetStoreCodec.
+func (sc *storeCodec) setNew(c codec, name string) error {
+	sf, err := readFormat(sc.fs)
+	if err != nil {
+		return err
+	}
+	if sf.Codec != "" && sf.Codec != name {
+		return fmt.Errorf("store was created with codec %q, not %q", sf.Codec, name)
+	}
+	sc.set(c)
+	return nil
+}
+
+func (sc *storeCodec) NewEncoder(w io.Writer) encoder {
+	c, err := sc.get()
+	if err != nil {
+		return errCoder{err}
+	}
+	return c.NewEncoder(w)
+}
+
+func (sc *storeCodec) NewDecoder(r io.Reader) decoder {
+	c, err := sc.get()
+	if err != nil {
+		return errCoder{err}
+	}
+	return c.NewDecoder(r)
+}
+
+// errCoder is an encoder and decoder that always fail.
+type errCoder struct{ err error }
+
+func (e errCoder) Encode(interface{}) (uint64, error) { return 0, e.err }
+func (e errCoder) Decode(interface{}) (uint64, error) { return 0, e.err }
+
+// codecName returns the name of c in Codecs, or the empty string if
+// it is not a named codec.
+func codecName(c codec) string {
+	for name, c2 := range Codecs {
+		if c == c2 {
+			return name
+		}
+	}
+	return ""
+}
+
 // A codec is an encoder and decoder pair used by the FS-backed store
 // to encode and decode data stored in files.
 type codec interface {
@@ -133,3 +310,180 @@ func (d *protobufDecoder) Decode(v interface{}) (uint64, error) {
 		return d.pbr.ReadMsg(v.(proto.Message))
 	}
 }
+
+// FullProtobufCodec is like ProtobufCodec, except that it encodes
+// source units as protobuf messages too (see sourceUnitMsg) instead
+// of as JSON. Decoding a large unit's file list is much faster than
+// with ProtobufCodec.
+type FullProtobufCodec struct{}
+
+func (FullProtobufCodec) NewEncoder(w io.Writer) encoder {
+	return &fullProtobufEncoder{pbw: pbio.NewDelimitedWriter(w)}
+}
+
+type fullProtobufEncoder struct{ pbw pbio.Writer }
+
+func (e *fullProtobufEncoder) Encode(v interface{}) (uint64, error) {
+	if u, ok := v.(*unit.SourceUnit); ok {
+		m, err := newSourceUnitMsg(u)
+		if err != nil {
+			return 0, err
+		}
+		return e.pbw.WriteMsg(m)
+	}
+	return e.pbw.WriteMsg(v.(proto.Message))
+}
+
+func (FullProtobufCodec) NewDecoder(r io.Reader) decoder {
+	return &fullProtobufDecoder{pbr: pbio.NewDelimitedReader(r, decodeBufSize, 2*1024*1024)}
+}
+
+type fullProtobufDecoder struct{ pbr pbio.Reader }
+
+func (d *fullProtobufDecoder) Decode(v interface{}) (uint64, error) {
+	if u, ok := v.(*unit.SourceUnit); ok {
+		var m sourceUnitMsg
+		n, err := d.pbr.ReadMsg(&m)
+		if err != nil {
+			return n, err
+		}
+		return n, m.toSourceUnit(u)
+	}
+	return d.pbr.ReadMsg(v.(proto.Message))
+}
+
+// sourceUnitMsg is the protobuf message that FullProtobufCodec
+// encodes source units as. The unit's string fields and file lists
+// are protobuf fields. Its other fields are free-form (interface{}
+// values) and have no protobuf representation, so they are stored as
+// JSON in Rest.
+type sourceUnitMsg struct {
+	Name      string   `protobuf:"bytes,1,opt,name=name" json:"name"`
+	Type      string   `protobuf:"bytes,2,opt,name=type" json:"type"`
+	Repo      string   `protobuf:"bytes,3,opt,name=repo" json:"repo,omitempty"`
+	CommitID  string   `protobuf:"bytes,4,opt,name=commit_id" json:"commit_id,omitempty"`
+	Toolchain string   `protobuf:"bytes,5,opt,name=toolchain" json:"toolchain,omitempty"`
+	Language  string   `protobuf:"bytes,6,opt,name=language" json:"language,omitempty"`
+	Dir       string   `protobuf:"bytes,7,opt,name=dir" json:"dir,omitempty"`
+	Globs     []string `protobuf:"bytes,8,rep,name=globs" json:"globs,omitempty"`
+	Files     []string `protobuf:"bytes,9,rep,name=files" json:"files,omitempty"`
+
+	// Rest is the JSON encoding of the unit's other fields.
+	Rest []byte `protobuf:"bytes,10,opt,name=rest" json:"rest,omitempty"`
+}
+
+func (m *sourceUnitMsg) Reset()         { *m = sourceUnitMsg{} }
+func (m *sourceUnitMsg) String() string { return proto.CompactTextString(m) }
+func (*sourceUnitMsg) ProtoMessage()    {}
+
+func newSourceUnitMsg(u *unit.SourceUnit) (*sourceUnitMsg, error) {
+	rest := *u
+	rest.Name, rest.Type, rest.Repo, rest.CommitID, rest.Toolchain, rest.Language, rest.Dir = "", "", "", "", "", "", ""
+	rest.Globs, rest.Files = nil, nil
+	b, err := json.Marshal(&rest)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceUnitMsg{
+		Name:      u.Name,
+		Type:      u.Type,
+		Repo:      u.Repo,
+		CommitID:  u.CommitID,
+		Toolchain: u.Toolchain,
+		Language:  u.Language,
+		Dir:       u.Dir,
+		Globs:     u.Globs,
+		Files:     u.Files,
+		Rest:      b,
+	}, nil
+}
+
+func (m *sourceUnitMsg) toSourceUnit(u *unit.SourceUnit) error {
+	*u = unit.SourceUnit{}
+	if len(m.Rest) > 0 {
+		if err := json.Unmarshal(m.Rest, u); err != nil {
+			return err
+		}
+	}
+	u.Name, u.Type, u.Repo, u.CommitID, u.Toolchain, u.Language, u.Dir = m.Name, m.Type, m.Repo, m.CommitID, m.Toolchain, m.Language, m.Dir
+	u.Globs, u.Files = m.Globs, m.Files
+	return nil
+}
+
+// MsgpackCodec encodes source units as JSON (like ProtobufCodec) and
+// all other values as length-prefixed MessagePack. It is usually
+// faster to encode and decode than JSONCodec and doesn't require
+// values to be protobuf messages.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) NewEncoder(w io.Writer) encoder {
+	return &msgpackEncoder{w: w}
+}
+
+type msgpackEncoder struct {
+	w io.Writer
+	j encoder
+}
+
+func (e *msgpackEncoder) Encode(v interface{}) (uint64, error) {
+	if u, ok := v.(*unit.SourceUnit); ok {
+		if e.j == nil {
+			e.j = JSONCodec{}.NewEncoder(e.w)
+		}
+		return e.j.Encode(u)
+	}
+
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(len(b))
+	n := binary.Size(size)
+	if err := binary.Write(e.w, binary.LittleEndian, size); err != nil {
+		return 0, err
+	}
+	if _, err := e.w.Write(b); err != nil {
+		return 0, err
+	}
+	return uint64(n + len(b)), nil
+}
+
+func (MsgpackCodec) NewDecoder(r io.Reader) decoder {
+	return &msgpackDecoder{r: r}
+}
+
+// maxMsgpackRecordSize is the max size of a single msgpack-encoded
+// value that msgpackDecoder reads.
+const maxMsgpackRecordSize = 256 * 1024 * 1024
+
+type msgpackDecoder struct {
+	r io.Reader
+	j decoder
+}
+
+func (d *msgpackDecoder) Decode(v interface{}) (uint64, error) {
+	if u, ok := v.(*unit.SourceUnit); ok {
+		if d.j == nil {
+			d.j = JSONCodec{}.NewDecoder(d.r)
+		}
+		return d.j.Decode(u)
+	}
+
+	var n uint64
+	if err := binary.Read(d.r, binary.LittleEndian, &n); err != nil {
+		return 0, err
+	}
+	if n > maxMsgpackRecordSize {
+		return 0, fmt.Errorf("msgpack record size %d exceeds the max record size %d (the data is probably corrupt)", n, maxMsgpackRecordSize)
+	}
+	// Read the record without allocating n bytes up front, so that a
+	// corrupt length in a short file doesn't allocate a huge buffer.
+	b, err := ioutil.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(b)) < n {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return uint64(binary.Size(n)) + n, msgpack.Unmarshal(b, v)
+}
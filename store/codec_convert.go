@@ -0,0 +1,163 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// ConvertCodec re-encodes all data files of a FS-backed store with
+// the codec named to (see Codecs), rebuilds the indexes of each
+// commit (which refer to byte offsets in the data files), and records
+// the new codec in the store's format file. Each repo's data is read
+// with the codec recorded in the repo's format file (or the store's
+// codec, if none is recorded). When ConvertCodec returns successfully,
+// s uses the new codec. Converting to a codec other than protobuf
+// upgrades the store's format version (see codecFormatVersion).
+//
+// The store must not be used by anyone else during the conversion.
+// If an error occurs, the store may be left partially converted; run
+// ConvertCodec again to finish.
+func ConvertCodec(s interface{}, to string, logf func(format string, v ...interface{})) error {
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	toCodec, err := codecByName(to)
+	if err != nil {
+		return err
+	}
+	root := formatFS(s)
+	if root == nil {
+		return fmt.Errorf("store (type %T) does not have an on-disk format", s)
+	}
+	sc := storeCodecOf(s)
+	storeFromCodec, err := sc.get()
+	if err != nil {
+		return err
+	}
+
+	rss := map[string]*fsRepoStore{}
+	switch s := s.(type) {
+	case *fsMultiRepoStore:
+		repos, err := s.Repos(Unordered())
+		if err != nil && !isStoreNotExist(err) {
+			return err
+		}
+		for _, repo := range repos {
+			rss[repo] = s.openRepoStore(repo).(*fsRepoStore)
+		}
+		if to != defaultCodecName {
+			if err := upgradeFormat(s.fs, codecFormatVersion, false); err != nil {
+				return err
+			}
+		}
+	case *fsRepoStore:
+		rss[""] = s
+	}
+
+	repos := make([]string, 0, len(rss))
+	for repo := range rss {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		rs := rss[repo]
+		fromCodec := storeFromCodec
+		if sf, err := readFormat(rs.fs); err != nil {
+			return err
+		} else if sf.Codec != "" {
+			// The repo may have been converted by an earlier,
+			// interrupted ConvertCodec.
+			if fromCodec, err = codecByName(sf.Codec); err != nil {
+				return err
+			}
+		}
+		if to != defaultCodecName {
+			if err := upgradeFormat(rs.fs, codecFormatVersion, true); err != nil {
+				return err
+			}
+		}
+
+		versions, err := rs.Versions()
+		if err != nil && !isStoreNotExist(err) {
+			return err
+		}
+		for _, v := range versions {
+			logf("Converting %s@%s to codec %s...", repo, v.CommitID, to)
+			if err := convertTreeCodec(rs, v.CommitID, fromCodec, toCodec); err != nil {
+				return fmt.Errorf("converting %s@%s: %s", repo, v.CommitID, err)
+			}
+		}
+		if err := writeFormatCodec(rs.fs, to); err != nil {
+			return err
+		}
+	}
+	if mrs, ok := s.(*fsMultiRepoStore); ok {
+		if err := writeFormatCodec(mrs.fs, to); err != nil {
+			return err
+		}
+	}
+
+	sc.set(toCodec)
+	return nil
+}
+
+// convertTreeCodec re-encodes the data of a single commit. It reads
+// all of the commit's data with the from codec before writing any of
+// it with the to codec.
+func convertTreeCodec(rs *fsRepoStore, commitID string, from, to codec) error {
+	ts := rs.newTreeStoreCodec(commitID, from)
+	units, err := ts.Units()
+	if err != nil {
+		return err
+	}
+	outputs := make([]graph.Output, len(units))
+	for i, u := range units {
+		uf := ByUnits(unit.ID2{Type: u.Type, Name: u.Name})
+		o := &outputs[i]
+		if o.Defs, err = ts.Defs(uf, Unordered()); err != nil {
+			return err
+		}
+		if o.Refs, err = ts.Refs(uf, Unordered()); err != nil {
+			return err
+		}
+		if cs, ok := ts.(CallStore); ok {
+			if o.Calls, err = cs.Calls(uf, Unordered()); err != nil {
+				return err
+			}
+		}
+		if rels, ok := ts.(RelationStore); ok {
+			if o.Relations, err = rels.Relations(uf, Unordered()); err != nil {
+				return err
+			}
+		}
+	}
+
+	ts = rs.newTreeStoreCodec(commitID, to)
+	for i, u := range units {
+		if err := ts.Import(u, outputs[i]); err != nil {
+			return err
+		}
+	}
+	if xs, ok := ts.(*indexedTreeStore); ok {
+		return xs.Index()
+	}
+	return nil
+}
+
+// writeFormatCodec records the codec named name as the codec of the
+// FS-backed store rooted at fs, preserving its format version.
+func writeFormatCodec(fs rwvfs.FileSystem, name string) error {
+	sf, err := readFormat(fs)
+	if err != nil {
+		return err
+	}
+	if sf.Version == 0 {
+		sf.Version = FormatVersion
+	}
+	sf.Codec = name
+	return writeFormat(fs, sf)
+}
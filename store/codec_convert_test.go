@@ -0,0 +1,117 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestConvertCodec(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		useIndexedStore = indexed
+		fs := newTestFS()
+		rs := NewFSRepoStore(fs)
+
+		u := &unit.SourceUnit{Type: "t", Name: "u"}
+		data := graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f", DefStart: 1, DefEnd: 2}},
+			Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2, Def: true}},
+		}
+		if err := rs.Import("c", u, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.(RepoIndexer).Index("c"); err != nil {
+			t.Fatal(err)
+		}
+		want, err := rs.Defs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, to := range []string{"msgpack", "json", "protobuf-full", "protobuf"} {
+			if err := ConvertCodec(rs, to, nil); err != nil {
+				t.Fatalf("indexed=%v: ConvertCodec(%s): %s", indexed, to, err)
+			}
+			if name, err := StoreCodec(rs); err != nil {
+				t.Fatal(err)
+			} else if name != to {
+				t.Errorf("indexed=%v: after converting to %s: got StoreCodec %q", indexed, to, name)
+			}
+			if c, err := storeCodecOf(rs).get(); err != nil {
+				t.Fatal(err)
+			} else if got := codecName(c); got != to {
+				t.Errorf("indexed=%v: after converting to %s: got store codec %q", indexed, to, got)
+			}
+			if got := codecName(Codec); got != defaultCodecName {
+				t.Errorf("indexed=%v: after converting to %s: got default Codec %q, want it unchanged", indexed, to, got)
+			}
+			if v, err := StoreFormatVersion(rs); err != nil {
+				t.Fatal(err)
+			} else if v < codecFormatVersion {
+				t.Errorf("indexed=%v: after converting to %s: got format version %d, want >= %d", indexed, to, v, codecFormatVersion)
+			}
+
+			// The converted store and a newly opened store (which
+			// reads the codec from the format file) must read the
+			// same data.
+			for _, rs := range []RepoStore{rs, NewFSRepoStore(fs)} {
+				defs, err := rs.Defs()
+				if err != nil {
+					t.Fatalf("indexed=%v: after converting to %s: Defs: %s", indexed, to, err)
+				}
+				if !reflect.DeepEqual(defs, want) {
+					t.Errorf("indexed=%v: after converting to %s: got defs %v, want %v", indexed, to, defs, want)
+				}
+			}
+			refs, err := rs.Refs()
+			if err != nil {
+				t.Fatalf("indexed=%v: after converting to %s: Refs: %s", indexed, to, err)
+			}
+			if len(refs) != 1 {
+				t.Errorf("indexed=%v: after converting to %s: got %d refs, want 1", indexed, to, len(refs))
+			}
+		}
+	}
+}
+
+func TestSetStoreCodec(t *testing.T) {
+	useIndexedStore = false
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f"}}}
+
+	// Stores with different codecs can be used at the same time.
+	stores := map[string]RepoStoreImporter{}
+	for _, name := range []string{"json", "msgpack", "protobuf"} {
+		rs := NewFSRepoStore(newTestFS())
+		if err := SetStoreCodec(rs, name); err != nil {
+			t.Fatal(err)
+		}
+		stores[name] = rs
+	}
+	for name, rs := range stores {
+		if err := rs.Import("c", u, data); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := StoreCodec(rs); err != nil {
+			t.Fatal(err)
+		} else if got != name {
+			t.Errorf("got StoreCodec %q, want %q", got, name)
+		}
+	}
+	for name, rs := range stores {
+		defs, err := rs.Defs()
+		if err != nil {
+			t.Fatalf("%s: Defs: %s", name, err)
+		}
+		if len(defs) != 1 || defs[0].Name != "n" {
+			t.Errorf("%s: got defs %v, want def n", name, defs)
+		}
+	}
+
+	// A store's codec can't be changed after it is created.
+	if err := SetStoreCodec(stores["json"], "msgpack"); err == nil {
+		t.Error("SetStoreCodec on store created with another codec: got nil err, want error")
+	}
+}
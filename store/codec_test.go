@@ -2,6 +2,7 @@ package store
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"reflect"
@@ -11,8 +12,23 @@ import (
 	"strings"
 
 	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
+func TestMsgpackCodec_corruptLength(t *testing.T) {
+	for _, n := range []uint64{1 << 20, maxMsgpackRecordSize + 1} {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, n); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString("short")
+		var v graph.Def
+		if _, err := (MsgpackCodec{}).NewDecoder(&buf).Decode(&v); err == nil {
+			t.Errorf("length %d: got nil err, want error", n)
+		}
+	}
+}
+
 func TestCodec(t *testing.T) {
 	ns := []int{0, 1, 2, 3, 4, 5, 10, 100, 1000, 5000}
 	lengths := map[codec]map[int]int{}
@@ -25,6 +41,12 @@ func TestCodec(t *testing.T) {
 		{
 			codec: JSONCodec{},
 		},
+		{
+			codec: FullProtobufCodec{},
+		},
+		{
+			codec: MsgpackCodec{},
+		},
 	}
 	for _, test := range tests {
 		for _, n := range ns {
@@ -72,6 +94,35 @@ func TestCodec(t *testing.T) {
 	}
 }
 
+func TestCodec_SourceUnit(t *testing.T) {
+	orig := &unit.SourceUnit{
+		Name:     "u",
+		Type:     "t",
+		Repo:     "r",
+		CommitID: "c",
+		Files:    []string{"f1", "f2"},
+		Dir:      "d",
+		Info:     &unit.Info{GlobalName: "g"},
+		Data:     map[string]interface{}{"k": "v"},
+		Config:   map[string]interface{}{"k": []interface{}{"v"}},
+	}
+	for name, c := range Codecs {
+		var buf bytes.Buffer
+		if _, err := c.NewEncoder(&buf).Encode(orig); err != nil {
+			t.Errorf("%s: Encode: %s", name, err)
+			continue
+		}
+		var decoded unit.SourceUnit
+		if _, err := c.NewDecoder(&buf).Decode(&decoded); err != nil {
+			t.Errorf("%s: Decode: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&decoded, orig) {
+			t.Errorf("%s: got decoded unit %+v, want %+v", name, &decoded, orig)
+		}
+	}
+}
+
 func BenchmarkJSONCodec_Encode_1(b *testing.B)     { benchmarkCodec_Encode(b, JSONCodec{}, 1) }
 func BenchmarkJSONCodec_Encode_500(b *testing.B)   { benchmarkCodec_Encode(b, JSONCodec{}, 500) }
 func BenchmarkJSONCodec_Encode_5000(b *testing.B)  { benchmarkCodec_Encode(b, JSONCodec{}, 5000) }
@@ -96,6 +147,16 @@ func BenchmarkProtobufCodec_Decode_50000(b *testing.B) {
 	benchmarkCodec_Decode(b, ProtobufCodec{}, 50000)
 }
 
+func BenchmarkMsgpackCodec_Encode_1(b *testing.B)     { benchmarkCodec_Encode(b, MsgpackCodec{}, 1) }
+func BenchmarkMsgpackCodec_Encode_500(b *testing.B)   { benchmarkCodec_Encode(b, MsgpackCodec{}, 500) }
+func BenchmarkMsgpackCodec_Encode_5000(b *testing.B)  { benchmarkCodec_Encode(b, MsgpackCodec{}, 5000) }
+func BenchmarkMsgpackCodec_Encode_50000(b *testing.B) { benchmarkCodec_Encode(b, MsgpackCodec{}, 50000) }
+
+func BenchmarkMsgpackCodec_Decode_1(b *testing.B)     { benchmarkCodec_Decode(b, MsgpackCodec{}, 1) }
+func BenchmarkMsgpackCodec_Decode_500(b *testing.B)   { benchmarkCodec_Decode(b, MsgpackCodec{}, 500) }
+func BenchmarkMsgpackCodec_Decode_5000(b *testing.B)  { benchmarkCodec_Decode(b, MsgpackCodec{}, 5000) }
+func BenchmarkMsgpackCodec_Decode_50000(b *testing.B) { benchmarkCodec_Decode(b, MsgpackCodec{}, 50000) }
+
 func makeGraphData(t testing.TB, n int) graph.Output {
 	data := graph.Output{}
 	if n > 0 {
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// WithContext returns a filter that makes the query it is passed to
+// stop and return ctx.Err() when ctx is canceled or its deadline
+// passes (e.g., because the client that requested the query went
+// away). It selects everything, so it doesn't otherwise affect the
+// query's results.
+//
+// The FS-backed stores check ctx before opening each source unit's
+// data, before each ranged fetch from a network VFS (such as S3),
+// and between the defs and refs that they decode, so a canceled query
+// stops within a single read.
+func WithContext(ctx context.Context) interface {
+	UnitFilter
+	DefFilter
+	RefFilter
+} {
+	return contextFilter{ctx}
+}
+
+type contextFilter struct{ ctx context.Context }
+
+func (f contextFilter) String() string                   { return "WithContext" }
+func (f contextFilter) SelectUnit(*unit.SourceUnit) bool { return true }
+func (f contextFilter) SelectDef(*graph.Def) bool        { return true }
+func (f contextFilter) SelectRef(*graph.Ref) bool        { return true }
+
+// queryControls are the per-query filters that can stop a query
+// before it finishes: its context (see WithContext) and its limits
+// (see WithQueryLimits). They also include the query's stats, if it
+// is being logged (see SlowQueryLog). The zero value never stops a
+// query.
+type queryControls struct {
+	ctx     context.Context
+	limiter *queryLimiter
+	stats   *queryStats
+}
+
+// getQueryControls returns the query controls in filters.
+func getQueryControls(filters interface{}) queryControls {
+	var c queryControls
+	for _, f := range storeFilters(filters) {
+		switch f := f.(type) {
+		case contextFilter:
+			c.ctx = f.ctx
+		case *queryLimiter:
+			c.limiter = f
+		case *queryStats:
+			c.stats = f
+		}
+	}
+	return c
+}
+
+// isQueryControl returns whether f is one of the query controls. They
+// select everything, so they must not be used to narrow a query (e.g.,
+// as unit filters that scope a defs query to the units they select).
+func isQueryControl(f interface{}) bool {
+	switch f.(type) {
+	case contextFilter, *queryLimiter, *queryStats:
+		return true
+	}
+	return false
+}
+
+// check returns an error if the query should stop: because its
+// context is done or because it has already exceeded its limits.
+func (c queryControls) check() error {
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return c.limiter.check()
+}
+
+// err returns the error that a query that failed with err should
+// return: ctx.Err() if the query's context is done, or the limit error
+// if the query exceeded its limits (so callers can compare it to
+// context.Canceled or pass it to IsQueryLimitExceeded, even if it was
+// wrapped when it was returned from parallel fetches), and otherwise
+// err.
+func (c queryControls) err(err error) error {
+	if err == nil {
+		return nil
+	}
+	if c.ctx != nil {
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+	}
+	if limitErr := c.limiter.check(); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// scanned records that n bytes of data were read (see
+// queryLimiter.scanned), and returns an error if the query should
+// stop.
+func (c queryControls) scanned(n uint64) error {
+	c.stats.scanned(n)
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return c.limiter.scanned(n)
+}
+
+// unitScanned records that the query read a source unit's data, and
+// returns an error if the query should stop.
+func (c queryControls) unitScanned() error {
+	c.stats.unitScanned()
+	return c.check()
+}
+
+// selected records that n results were selected (see
+// queryLimiter.selected), and returns an error if the query has
+// exceeded its limits.
+func (c queryControls) selected(n int) error { return c.limiter.selected(n) }
+
+// ContextUnitStore returns a UnitStore whose queries stop when ctx is
+// done: it adds WithContext(ctx) to the filters of each query that it
+// passes to s.
+func ContextUnitStore(ctx context.Context, s UnitStore) UnitStore {
+	return contextUnitStore{s: s, ctx: ctx}
+}
+
+type contextUnitStore struct {
+	s   UnitStore
+	ctx context.Context
+}
+
+func (s contextUnitStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	return s.s.Defs(append(append([]DefFilter{}, f...), WithContext(s.ctx))...)
+}
+
+func (s contextUnitStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	return s.s.Refs(append(append([]RefFilter{}, f...), WithContext(s.ctx))...)
+}
+
+// ContextTreeStore is like ContextUnitStore, but it returns a
+// TreeStore (whose Units queries also stop when ctx is done).
+func ContextTreeStore(ctx context.Context, s TreeStore) TreeStore {
+	return contextTreeStore{contextUnitStore{s: s, ctx: ctx}, s}
+}
+
+type contextTreeStore struct {
+	contextUnitStore
+	ts TreeStore
+}
+
+func (s contextTreeStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
+	return s.ts.Units(append(append([]UnitFilter{}, f...), WithContext(s.ctx))...)
+}
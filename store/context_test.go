@@ -0,0 +1,59 @@
+package store
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestWithContext(t *testing.T) {
+	useIndexedStore = true
+	mrs := NewFSMultiRepoStore(newTestFS(), nil)
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"}},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+	}
+	if err := mrs.Import("r", "c", u, data); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defs, err := mrs.Defs(WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 {
+		t.Errorf("got defs %v, want 1 def", defs)
+	}
+
+	cancel()
+	if _, err := mrs.Defs(WithContext(ctx)); err != context.Canceled {
+		t.Errorf("Defs: got err %v, want %v", err, context.Canceled)
+	}
+	if _, err := mrs.Refs(WithContext(ctx)); err != context.Canceled {
+		t.Errorf("Refs: got err %v, want %v", err, context.Canceled)
+	}
+	if _, err := mrs.Units(WithContext(ctx)); err != context.Canceled {
+		t.Errorf("Units: got err %v, want %v", err, context.Canceled)
+	}
+	if _, err := ContextUnitStore(ctx, mrs).Defs(ByRepos("r")); err != context.Canceled {
+		t.Errorf("ContextUnitStore Defs: got err %v, want %v", err, context.Canceled)
+	}
+	if _, err := ContextTreeStore(ctx, mrs).Units(ByRepos("r")); err != context.Canceled {
+		t.Errorf("ContextTreeStore Units: got err %v, want %v", err, context.Canceled)
+	}
+
+	built, err := BuildIndexesContext(ctx, mrs, IndexCriteria{}, nil)
+	if err != context.Canceled {
+		t.Errorf("BuildIndexesContext: got err %v, want %v", err, context.Canceled)
+	}
+	for _, x := range built {
+		if x.BuildError == "" {
+			t.Errorf("index %s was built after the context was canceled", x.Name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// DefDataIndexKeys are the Def.Data paths (see ByDefData) whose values
+// are indexed by the def data index when a tree's indexes are built.
+// The index is opt-in: if DefDataIndexKeys is empty, no def data is
+// indexed, and ByDefData queries scan all of the defs.
+var DefDataIndexKeys []string
+
+// ByDefDataFilter is implemented by filters that select defs by a
+// value in their Data. It allows the store to use the def data index
+// (see DefDataIndexKeys) to find the defs without scanning.
+type ByDefDataFilter interface {
+	ByDefData() (path, value string)
+}
+
+// ByDefData creates a new filter that selects defs whose Data has the
+// given value at path. The path is a dot-separated list of object
+// fields and array indexes (e.g., "Annotations" or "Type.Params.0"). If
+// the value at path is an array, the filter matches if any of its
+// elements equals value. Strings are compared to value directly, and
+// numbers, bools, and null are compared in their JSON form (e.g.,
+// "true" or "3"). It panics if path is empty.
+func ByDefData(path, value string) interface {
+	DefFilter
+	ByDefDataFilter
+} {
+	if path == "" {
+		panic("empty def data path")
+	}
+	return byDefDataFilter{path: path, value: value}
+}
+
+type byDefDataFilter struct{ path, value string }
+
+func (f byDefDataFilter) String() string {
+	return fmt.Sprintf("ByDefData(%s=%q)", f.path, f.value)
+}
+func (f byDefDataFilter) ByDefData() (path, value string) { return f.path, f.value }
+func (f byDefDataFilter) SelectDef(def *graph.Def) bool {
+	for _, v := range defDataValues(def.Data, f.path) {
+		if v == f.value {
+			return true
+		}
+	}
+	return false
+}
+
+// defDataValues returns the string forms (see ByDefData) of the
+// scalar values in data at path. It returns nil if data is not JSON
+// or has no scalar values at path.
+func defDataValues(data json.RawMessage, path string) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var present bool
+			if v, present = x[key]; !present {
+				return nil
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil
+			}
+			v = x[i]
+		default:
+			return nil
+		}
+	}
+
+	vals := []interface{}{v}
+	if list, ok := v.([]interface{}); ok {
+		vals = list
+	}
+	var strs []string
+	for _, v := range vals {
+		switch v := v.(type) {
+		case string:
+			strs = append(strs, v)
+		case map[string]interface{}, []interface{}:
+			// Not a scalar.
+		default:
+			b, _ := json.Marshal(v)
+			strs = append(strs, string(b))
+		}
+	}
+	return strs
+}
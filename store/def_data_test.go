@@ -0,0 +1,103 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestByDefData(t *testing.T) {
+	def := &graph.Def{Data: json.RawMessage(`{"Visibility":"public","Annotations":["Deprecated","Override"],"Static":true,"Arity":2,"Type":{"Params":["int"]},"Nil":null}`)}
+	tests := []struct {
+		path, value string
+		want        bool
+	}{
+		{"Visibility", "public", true},
+		{"Visibility", "private", false},
+		{"Annotations", "Override", true},
+		{"Annotations.0", "Deprecated", true},
+		{"Annotations.0", "Override", false},
+		{"Annotations.5", "Override", false},
+		{"Static", "true", true},
+		{"Arity", "2", true},
+		{"Type.Params", "int", true},
+		{"Type", "int", false},
+		{"Nil", "null", true},
+		{"Missing", "null", false},
+		{"Visibility.x", "public", false},
+	}
+	for _, test := range tests {
+		if got := ByDefData(test.path, test.value).SelectDef(def); got != test.want {
+			t.Errorf("%s=%s: got %v, want %v", test.path, test.value, got, test.want)
+		}
+	}
+	if ByDefData("Visibility", "public").SelectDef(&graph.Def{}) {
+		t.Error("got def without Data selected, want not selected")
+	}
+}
+
+func TestIndexedTreeStore_DefsByDefData(t *testing.T) {
+	defer func(keys []string) { DefDataIndexKeys = keys }(DefDataIndexKeys)
+
+	for _, indexKeys := range [][]string{nil, {"Visibility", "Annotations"}} {
+		DefDataIndexKeys = indexKeys
+		useIndexedStore = true
+		rs := NewFSRepoStore(newTestFS())
+		for _, name := range []string{"u1", "u2"} {
+			u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{"f"}}
+			var data graph.Output
+			for i := 0; i < 10; i++ {
+				vis := "private"
+				if i%2 == 0 {
+					vis = "public"
+				}
+				data.Defs = append(data.Defs, &graph.Def{
+					DefKey: graph.DefKey{Path: fmt.Sprintf("%s/%d", name, i)},
+					File:   "f",
+					Data:   json.RawMessage(fmt.Sprintf(`{"Visibility":%q,"Annotations":["A%d"],"Kind":"k"}`, vis, i%3)),
+				})
+			}
+			if err := rs.Import("c", u, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rs.(RepoIndexer).Index("c"); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			filters []DefFilter
+			want    []string
+			indexed bool
+		}{
+			{[]DefFilter{ByDefData("Visibility", "public")}, []string{"u1/0", "u1/2", "u1/4", "u1/6", "u1/8", "u2/0", "u2/2", "u2/4", "u2/6", "u2/8"}, true},
+			{[]DefFilter{ByDefData("Annotations", "A1"), ByUnits(unit.ID2{Type: "t", Name: "u2"})}, []string{"u2/1", "u2/4", "u2/7"}, true},
+			{[]DefFilter{ByDefData("Visibility", "public"), ByDefData("Annotations", "A1")}, []string{"u1/4", "u2/4"}, true},
+			{[]DefFilter{ByDefData("Visibility", "x")}, nil, true},
+			{[]DefFilter{ByDefData("Kind", "k"), ByDefPath("u1/3")}, []string{"u1/3"}, false},
+		}
+		for _, test := range tests {
+			c_defDataTreeIndex_defs = 0
+			defs, err := rs.Defs(append(test.filters, ByCommitIDs("c"))...)
+			if err != nil {
+				t.Fatalf("%v: %s", test.filters, err)
+			}
+			var got []string
+			for _, def := range defs {
+				got = append(got, def.Path)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("index keys %v: %v: got defs %v, want %v", indexKeys, test.filters, got, test.want)
+			}
+			if usedIndex, want := c_defDataTreeIndex_defs > 0, test.indexed && indexKeys != nil; usedIndex != want {
+				t.Errorf("index keys %v: %v: got used index %v, want %v", indexKeys, test.filters, usedIndex, want)
+			}
+		}
+	}
+}
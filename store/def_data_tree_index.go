@@ -0,0 +1,265 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// defDataTreeIndex makes it fast to find the defs (in all source
+// units in a tree) whose Data has a value at one of the
+// DefDataIndexKeys paths (see ByDefData), so that, e.g., Java defs can
+// be found by visibility or annotation without decoding the Data of
+// every def.
+//
+// It has the same columnar layout as defFilesTreeIndex, with each
+// entry being a path and a value (separated by a NUL byte) instead of
+// a file name. The indexed paths are stored in the index, so that
+// queries by paths that were not indexed (because DefDataIndexKeys
+// was different when the index was built) fall back to scanning.
+type defDataTreeIndex struct {
+	t     *defDataTreeTable
+	ready bool
+}
+
+// defDataTreeTable is the serialized form of a defDataTreeIndex.
+type defDataTreeTable struct {
+	Keys       []string   // indexed Def.Data paths, sorted
+	Units      []unit.ID2 // source units, indexed by DefUnits values
+	Entries    []byte     // concatenated distinct "PATH\x00VALUE" entries, in sorted order
+	EntryEnds  []uint32   // EntryEnds[i] is the end of the i'th entry in Entries
+	DefEnds    []uint32   // DefEnds[i] is the end of the i'th entry's defs in DefUnits and Offsets
+	DefUnits   []uint32   // DefUnits[j] is the index in Units of the j'th def's source unit
+	DefOffsets []int64    // DefOffsets[j] is the byte offset of the j'th def in its source unit's def data file
+}
+
+func (t *defDataTreeTable) Len() int { return len(t.EntryEnds) }
+
+func (t *defDataTreeTable) entry(i int) string {
+	var start uint32
+	if i > 0 {
+		start = t.EntryEnds[i-1]
+	}
+	return string(t.Entries[start:t.EntryEnds[i]])
+}
+
+// defs returns the range of indexes in DefUnits and DefOffsets of the
+// i'th entry's defs.
+func (t *defDataTreeTable) defs(i int) (start, end uint32) {
+	if i > 0 {
+		start = t.DefEnds[i-1]
+	}
+	return start, t.DefEnds[i]
+}
+
+func (t *defDataTreeTable) hasKey(path string) bool {
+	i := sort.SearchStrings(t.Keys, path)
+	return i < len(t.Keys) && t.Keys[i] == path
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	unitDefsIndexBuilder
+	defTreeIndex
+} = (*defDataTreeIndex)(nil)
+
+var c_defDataTreeIndex_defs = 0 // counter
+
+func (x *defDataTreeIndex) String() string {
+	return fmt.Sprintf("defDataTreeIndex(ready=%v)", x.ready)
+}
+
+// Covers implements defTreeIndex.
+func (x *defDataTreeIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if _, ok := f.(ByDefDataFilter); ok {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Defs implements defTreeIndex. It returns errNotIndexed if none of
+// the ByDefData filters' paths were indexed.
+func (x *defDataTreeIndex) Defs(fs ...DefFilter) (map[unit.ID2]byteOffsets, error) {
+	if x.t == nil {
+		panic("defDataTreeTable not built/read")
+	}
+	for _, f := range fs {
+		ff, ok := f.(ByDefDataFilter)
+		if !ok {
+			continue
+		}
+		path, value := ff.ByDefData()
+		if !x.t.hasKey(path) {
+			continue
+		}
+		c_defDataTreeIndex_defs++
+
+		uoffs := map[unit.ID2]byteOffsets{}
+		entry := path + "\x00" + value
+		n := x.t.Len()
+		if i := sort.Search(n, func(i int) bool { return x.t.entry(i) >= entry }); i < n && x.t.entry(i) == entry {
+			start, end := x.t.defs(i)
+			for j := start; j < end; j++ {
+				u := x.t.Units[x.t.DefUnits[j]]
+				uoffs[u] = append(uoffs[u], x.t.DefOffsets[j])
+			}
+		}
+		vlog.Printf("defDataTreeIndex.Defs(%s=%q): found defs in %d units.", path, value, len(uoffs))
+		return uoffs, nil
+	}
+	return nil, errNotIndexed
+}
+
+type defDataEntry struct {
+	entry string
+	unit  uint32
+	ofs   int64
+}
+
+type defDataEntries []defDataEntry
+
+func (ds defDataEntries) Len() int      { return len(ds) }
+func (ds defDataEntries) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+func (ds defDataEntries) Less(i, j int) bool {
+	if ds[i].entry != ds[j].entry {
+		return ds[i].entry < ds[j].entry
+	}
+	if ds[i].unit != ds[j].unit {
+		return ds[i].unit < ds[j].unit
+	}
+	return ds[i].ofs < ds[j].ofs
+}
+
+// Build implements unitDefsIndexBuilder. It indexes the values of the
+// DefDataIndexKeys paths.
+func (x *defDataTreeIndex) Build(units []*unit.SourceUnit, readDefs func(unit.ID2) ([]*graph.Def, byteOffsets, error)) error {
+	keys := make([]string, 0, len(DefDataIndexKeys))
+	for _, k := range DefDataIndexKeys {
+		if k != "" && !strings.Contains(k, "\x00") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	vlog.Printf("defDataTreeIndex: building index of Def.Data paths %v... (%d units)", keys, len(units))
+
+	unitIDs := make([]unit.ID2, len(units))
+	for i, u := range units {
+		unitIDs[i] = u.ID2()
+	}
+	sort.Sort(unitID2s(unitIDs))
+
+	var ds defDataEntries
+	if len(keys) > 0 {
+		for i, u := range unitIDs {
+			defs, ofs, err := readDefs(u)
+			if err != nil {
+				return err
+			}
+			for j, def := range defs {
+				if len(def.Data) == 0 {
+					continue
+				}
+				for _, k := range keys {
+					for _, v := range uniqueStrings(defDataValues(def.Data, k)) {
+						ds = append(ds, defDataEntry{entry: k + "\x00" + v, unit: uint32(i), ofs: ofs[j]})
+					}
+				}
+			}
+		}
+	}
+	sort.Sort(ds)
+
+	t := &defDataTreeTable{
+		Keys:       keys,
+		Units:      unitIDs,
+		DefUnits:   make([]uint32, len(ds)),
+		DefOffsets: make([]int64, len(ds)),
+	}
+	for j, d := range ds {
+		if j == 0 || d.entry != ds[j-1].entry {
+			t.Entries = append(t.Entries, d.entry...)
+			t.EntryEnds = append(t.EntryEnds, uint32(len(t.Entries)))
+			t.DefEnds = append(t.DefEnds, 0)
+		}
+		t.DefUnits[j] = d.unit
+		t.DefOffsets[j] = d.ofs
+		t.DefEnds[len(t.DefEnds)-1] = uint32(j + 1)
+	}
+	x.t = t
+	x.ready = true
+	vlog.Printf("defDataTreeIndex: done building index (%d entries, %d defs).", t.Len(), len(ds))
+	return nil
+}
+
+// uniqueStrings returns the distinct strings in ss.
+func uniqueStrings(ss []string) []string {
+	if len(ss) < 2 {
+		return ss
+	}
+	seen := make(map[string]struct{}, len(ss))
+	uniq := ss[:0]
+	for _, s := range ss {
+		if _, dup := seen[s]; !dup {
+			seen[s] = struct{}{}
+			uniq = append(uniq, s)
+		}
+	}
+	return uniq
+}
+
+// Write implements persistedIndex.
+func (x *defDataTreeIndex) Write(w io.Writer) error {
+	if x.t == nil {
+		panic("no defDataTreeTable to write")
+	}
+	b, err := binary.Marshal(x.t)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read implements persistedIndex.
+func (x *defDataTreeIndex) Read(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var t defDataTreeTable
+	err = binary.Unmarshal(b, &t)
+	x.t = &t
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defDataTreeIndex) Ready() bool { return x.ready }
+
+// Fprint prints a human-readable representation of the index.
+func (x *defDataTreeIndex) Fprint(w io.Writer) error {
+	if x.t == nil {
+		panic("defDataTreeTable not built/read")
+	}
+	fmt.Fprintf(w, "keys: %v\n", x.t.Keys)
+	for i := 0; i < x.t.Len(); i++ {
+		e := strings.SplitN(x.t.entry(i), "\x00", 2)
+		fmt.Fprintf(w, "%s=%q\n", e[0], e[1])
+		start, end := x.t.defs(i)
+		for j := start; j < end; j++ {
+			fmt.Fprintf(w, "\t%v @ %d\n", x.t.Units[x.t.DefUnits[j]], x.t.DefOffsets[j])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A DefDiff describes how a def differs between two sets of defs
+// (typically the defs of a repository at two commits).
+type DefDiff struct {
+	// Change is the kind of change (DefAdded, DefChanged, or
+	// DefRemoved).
+	Change string
+
+	// From and To are the def in the first and second set. From is
+	// nil for DefAdded diffs, and To is nil for DefRemoved diffs.
+	From, To *graph.Def `json:",omitempty"`
+
+	// Changed lists the aspects of the def that changed (see
+	// DefHistoryEvent.Changed). It is only set for DefChanged diffs.
+	Changed []string `json:",omitempty"`
+}
+
+// DiffDefs compares two sets of defs and returns the defs that were
+// added, changed, or removed, sorted by key. Defs are matched by
+// their commit-independent key (their UnitType, Unit, and Path); the
+// Repo and CommitID of their keys are ignored.
+func DiffDefs(from, to []*graph.Def) []*DefDiff {
+	prev := make(map[string]*graph.Def, len(from))
+	for _, def := range from {
+		prev[defHistoryKey(def.DefKey)] = def
+	}
+
+	var diffs []*DefDiff
+	cur := make(map[string]struct{}, len(to))
+	for _, def := range to {
+		k := defHistoryKey(def.DefKey)
+		cur[k] = struct{}{}
+		if p, present := prev[k]; !present {
+			diffs = append(diffs, &DefDiff{Change: DefAdded, To: def})
+		} else if changed := defChanges(p, def); len(changed) > 0 {
+			diffs = append(diffs, &DefDiff{Change: DefChanged, From: p, To: def, Changed: changed})
+		}
+	}
+	for _, def := range from {
+		if _, present := cur[defHistoryKey(def.DefKey)]; !present {
+			diffs = append(diffs, &DefDiff{Change: DefRemoved, From: def})
+		}
+	}
+	sort.Sort(defDiffsByKey(diffs))
+	return diffs
+}
+
+// Key returns the key of the def (in the second set of defs, unless
+// it was removed).
+func (d *DefDiff) Key() graph.DefKey {
+	if d.To != nil {
+		return d.To.DefKey
+	}
+	return d.From.DefKey
+}
+
+type defDiffsByKey []*DefDiff
+
+func (v defDiffsByKey) Len() int      { return len(v) }
+func (v defDiffsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v defDiffsByKey) Less(i, j int) bool {
+	return defHistoryKey(v[i].Key()) < defHistoryKey(v[j].Key())
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDiffDefs(t *testing.T) {
+	def := func(commitID, path, kind, data string) *graph.Def {
+		return &graph.Def{
+			DefKey: graph.DefKey{CommitID: commitID, UnitType: "t", Unit: "u", Path: path},
+			Kind:   kind,
+			File:   "f",
+			Data:   []byte(data),
+		}
+	}
+	from := []*graph.Def{def("c1", "a", "func", `{}`), def("c1", "b", "func", `{}`), def("c1", "c", "func", `{}`)}
+	to := []*graph.Def{def("c2", "a", "func", `{}`), def("c2", "c", "var", `{"x":1}`), def("c2", "d", "func", `{}`)}
+
+	diffs := DiffDefs(from, to)
+	type diff struct {
+		path, change string
+		changed      []string
+	}
+	var got []diff
+	for _, d := range diffs {
+		got = append(got, diff{d.Key().Path, d.Change, d.Changed})
+	}
+	want := []diff{
+		{"b", DefRemoved, nil},
+		{"c", DefChanged, []string{"kind", "data"}},
+		{"d", DefAdded, nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diffs %+v, want %+v", got, want)
+	}
+}
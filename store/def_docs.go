@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"go/doc"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// Doc formats that ConvertDocs can convert plain text docs to.
+const (
+	DocFormatHTML     = "text/html"
+	DocFormatMarkdown = "text/x-markdown"
+	docFormatPlain    = "text/plain"
+)
+
+// attachDocs attaches each of the docs in data to the def (in data)
+// with the same path, as one of the def's Docs. A def may have docs
+// in multiple formats; a doc is not attached if the def already has
+// a doc in its format.
+func attachDocs(data *graph.Output) {
+	if len(data.Docs) == 0 {
+		return
+	}
+	docsByPath := make(map[string][]*graph.Doc, len(data.Docs))
+	for _, doc := range data.Docs {
+		docsByPath[doc.Path] = append(docsByPath[doc.Path], doc)
+	}
+	for _, def := range data.Defs {
+		for _, doc := range docsByPath[def.Path] {
+			if !hasDocFormat(def, doc.Format) {
+				def.Docs = append(def.Docs, graph.DefDoc{Format: doc.Format, Data: doc.Data})
+			}
+		}
+	}
+}
+
+// hasDocFormat returns whether def has a doc in the given format.
+func hasDocFormat(def *graph.Def, format string) bool {
+	for _, d := range def.Docs {
+		if d.Format == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertDocs adds docs in each of the given formats (DocFormatHTML
+// or DocFormatMarkdown) to defs in data that have a plain text doc
+// (e.g., a Go doc comment) but no doc in that format. It first
+// attaches data's docs to defs (as importing does).
+//
+// Plain text is converted using Go doc comment conventions:
+// paragraphs are separated by blank lines, and indented lines are
+// preformatted. The HTML is escaped, so it is safe to display.
+func ConvertDocs(data *graph.Output, formats ...string) {
+	attachDocs(data)
+	for _, def := range data.Defs {
+		var plain *graph.DefDoc
+		for i := range def.Docs {
+			if def.Docs[i].Format == docFormatPlain {
+				plain = &def.Docs[i]
+				break
+			}
+		}
+		if plain == nil {
+			continue
+		}
+		text := plain.Data
+		for _, format := range formats {
+			if hasDocFormat(def, format) {
+				continue
+			}
+			switch format {
+			case DocFormatHTML:
+				var buf bytes.Buffer
+				doc.ToHTML(&buf, text, nil)
+				def.Docs = append(def.Docs, graph.DefDoc{Format: format, Data: buf.String()})
+			case DocFormatMarkdown:
+				def.Docs = append(def.Docs, graph.DefDoc{Format: format, Data: plainToMarkdown(text)})
+			}
+		}
+	}
+}
+
+// markdownEscaper escapes characters that have special meaning in
+// Markdown text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", "&lt;", ">", "&gt;", "#", `\#`,
+)
+
+// plainToMarkdown converts plain text (following Go doc comment
+// conventions) to Markdown. Indented blocks become fenced code
+// blocks, and other text is escaped.
+func plainToMarkdown(text string) string {
+	var buf bytes.Buffer
+	inCode := false
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		switch {
+		case indented && !inCode:
+			buf.WriteString("```\n")
+			inCode = true
+		case !indented && inCode && strings.TrimSpace(line) != "":
+			buf.WriteString("```\n")
+			inCode = false
+		}
+		if inCode {
+			buf.WriteString(line)
+		} else {
+			buf.WriteString(markdownEscaper.Replace(line))
+		}
+		buf.WriteByte('\n')
+	}
+	if inCode {
+		buf.WriteString("```\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestAttachDocs(t *testing.T) {
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}}, {DefKey: graph.DefKey{Path: "q"}}},
+		Docs: []*graph.Doc{
+			{DefKey: graph.DefKey{Path: "p"}, Format: "text/plain", Data: "a"},
+			{DefKey: graph.DefKey{Path: "p"}, Format: "text/html", Data: "<p>a</p>"},
+		},
+	}
+	attachDocs(&data)
+	attachDocs(&data) // should be idempotent
+
+	want := []graph.DefDoc{{Format: "text/plain", Data: "a"}, {Format: "text/html", Data: "<p>a</p>"}}
+	if !reflect.DeepEqual(data.Defs[0].Docs, want) {
+		t.Errorf("got docs %+v, want %+v", data.Defs[0].Docs, want)
+	}
+	if len(data.Defs[1].Docs) != 0 {
+		t.Errorf("got docs %+v for def with no docs, want none", data.Defs[1].Docs)
+	}
+}
+
+func TestConvertDocs(t *testing.T) {
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}}},
+		Docs: []*graph.Doc{
+			{DefKey: graph.DefKey{Path: "p"}, Format: "text/plain", Data: "F returns <x> * 2.\n\n\tF(1)\n"},
+		},
+	}
+	ConvertDocs(&data, DocFormatHTML, DocFormatMarkdown)
+
+	docs := data.Defs[0].Docs
+	if len(docs) != 3 {
+		t.Fatalf("got %d docs, want 3 (plain text, HTML, and Markdown)", len(docs))
+	}
+	// The exact HTML depends on the version of go/doc.
+	if html := docs[1].Data; docs[1].Format != DocFormatHTML || !strings.Contains(html, "F returns &lt;x&gt; * 2.") || !strings.Contains(html, "<pre>F(1)") {
+		t.Errorf("got HTML doc %+v, want escaped HTML with a <pre> block", docs[1])
+	}
+	if want := (graph.DefDoc{Format: DocFormatMarkdown, Data: "F returns &lt;x&gt; \\* 2.\n\n```\n\tF(1)\n```\n"}); docs[2] != want {
+		t.Errorf("got Markdown doc %+v, want %+v", docs[2], want)
+	}
+
+	// Converting again should not add more docs.
+	ConvertDocs(&data, DocFormatHTML, DocFormatMarkdown)
+	if len(data.Defs[0].Docs) != 3 {
+		t.Errorf("after converting again, got %d docs, want 3", len(data.Defs[0].Docs))
+	}
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// defFilesTreeIndex makes it fast to find all of the defs in a file
+// (or in files underneath a dir), in all source units in a tree,
+// without first narrowing the query to the source units that contain
+// the file and then scanning all of their defs. Unlike defFilesIndex,
+// it includes every def in each file.
+//
+// It is stored in a columnar layout (like defNameIndex): the distinct
+// file names, sorted, are concatenated into a single byte slice, and
+// the source units and byte offsets of the defs in each file are held
+// in parallel arrays. Lookups are binary searches over the sorted
+// file names.
+type defFilesTreeIndex struct {
+	t     *defFilesTreeTable
+	ready bool
+}
+
+// defFilesTreeTable is the serialized form of a defFilesTreeIndex.
+type defFilesTreeTable struct {
+	Units    []unit.ID2 // source units, indexed by DefUnits values
+	Files    []byte     // concatenated distinct file names, in sorted order
+	FileEnds []uint32   // FileEnds[i] is the end of the i'th file name in Files
+	DefEnds  []uint32   // DefEnds[i] is the end of the i'th file's defs in DefUnits and Offsets
+	DefUnits []uint32   // DefUnits[j] is the index in Units of the j'th def's source unit
+	Offsets  []int64    // Offsets[j] is the byte offset of the j'th def in its source unit's def data file
+}
+
+func (t *defFilesTreeTable) Len() int { return len(t.FileEnds) }
+
+func (t *defFilesTreeTable) file(i int) string {
+	var start uint32
+	if i > 0 {
+		start = t.FileEnds[i-1]
+	}
+	return string(t.Files[start:t.FileEnds[i]])
+}
+
+// defs returns the range of indexes in DefUnits and Offsets of the
+// i'th file's defs.
+func (t *defFilesTreeTable) defs(i int) (start, end uint32) {
+	if i > 0 {
+		start = t.DefEnds[i-1]
+	}
+	return start, t.DefEnds[i]
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	unitDefsIndexBuilder
+	defTreeIndex
+} = (*defFilesTreeIndex)(nil)
+
+var c_defFilesTreeIndex_getByPath = 0 // counter
+
+func (x *defFilesTreeIndex) String() string {
+	return fmt.Sprintf("defFilesTreeIndex(ready=%v)", x.ready)
+}
+
+// getByPath adds the source units and byte offsets of the defs in
+// the file specified by the path to uoffs. The path can also be a
+// directory, in which case the defs in all files underneath that
+// directory are added. It returns the number of defs added.
+func (x *defFilesTreeIndex) getByPath(path string, uoffs map[unit.ID2]byteOffsets) int {
+	vlog.Printf("defFilesTreeIndex.getByPath(%s)", path)
+	c_defFilesTreeIndex_getByPath++
+
+	if x.t == nil {
+		panic("defFilesTreeTable not built/read")
+	}
+
+	numDefs := 0
+	add := func(i int) {
+		start, end := x.t.defs(i)
+		for j := start; j < end; j++ {
+			u := x.t.Units[x.t.DefUnits[j]]
+			uoffs[u] = append(uoffs[u], x.t.Offsets[j])
+			numDefs++
+		}
+	}
+
+	// Files underneath the dir are not necessarily adjacent to the
+	// file with the same name (e.g., "a.go" sorts between "a" and
+	// "a/b.go"), so look them up separately.
+	n := x.t.Len()
+	if i := sort.Search(n, func(i int) bool { return x.t.file(i) >= path }); i < n && x.t.file(i) == path {
+		add(i)
+	}
+	dirPrefix := path + "/"
+	for i := sort.Search(n, func(i int) bool { return x.t.file(i) >= dirPrefix }); i < n && strings.HasPrefix(x.t.file(i), dirPrefix); i++ {
+		add(i)
+	}
+	vlog.Printf("defFilesTreeIndex.getByPath(%s): found %d defs.", path, numDefs)
+	return numDefs
+}
+
+// Covers implements defTreeIndex.
+func (x *defFilesTreeIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if _, ok := f.(ByFilesFilter); ok {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Defs implements defTreeIndex.
+func (x *defFilesTreeIndex) Defs(fs ...DefFilter) (map[unit.ID2]byteOffsets, error) {
+	for _, f := range fs {
+		if ff, ok := f.(ByFilesFilter); ok {
+			uoffs := map[unit.ID2]byteOffsets{}
+			for _, file := range ff.ByFiles() {
+				x.getByPath(file, uoffs)
+			}
+
+			// Overlapping paths (such as "a" and "a/b.go") yield
+			// duplicate offsets, and offsets from different files are
+			// interleaved.
+			for u, ofs := range uoffs {
+				uoffs[u] = uniqueByteOffsets(ofs)
+			}
+			return uoffs, nil
+		}
+	}
+	return nil, nil
+}
+
+// uniqueByteOffsets sorts ofs and removes duplicates from it.
+func uniqueByteOffsets(ofs byteOffsets) byteOffsets {
+	sort.Sort(int64Slice(ofs))
+	uniq := ofs[:0]
+	for i, o := range ofs {
+		if i == 0 || o != ofs[i-1] {
+			uniq = append(uniq, o)
+		}
+	}
+	return uniq
+}
+
+type fileDefOffset struct {
+	file string
+	unit uint32
+	ofs  int64
+}
+
+type defsByFile []fileDefOffset
+
+func (ds defsByFile) Len() int      { return len(ds) }
+func (ds defsByFile) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+func (ds defsByFile) Less(i, j int) bool {
+	if ds[i].file != ds[j].file {
+		return ds[i].file < ds[j].file
+	}
+	if ds[i].unit != ds[j].unit {
+		return ds[i].unit < ds[j].unit
+	}
+	return ds[i].ofs < ds[j].ofs
+}
+
+// Build implements unitDefsIndexBuilder.
+func (x *defFilesTreeIndex) Build(units []*unit.SourceUnit, readDefs func(unit.ID2) ([]*graph.Def, byteOffsets, error)) error {
+	vlog.Printf("defFilesTreeIndex: building index... (%d units)", len(units))
+
+	unitIDs := make([]unit.ID2, len(units))
+	for i, u := range units {
+		unitIDs[i] = u.ID2()
+	}
+	sort.Sort(unitID2s(unitIDs))
+
+	var dofs defsByFile
+	for i, u := range unitIDs {
+		defs, ofs, err := readDefs(u)
+		if err != nil {
+			return err
+		}
+		for j, def := range defs {
+			if def.File == "" {
+				continue
+			}
+			dofs = append(dofs, fileDefOffset{file: def.File, unit: uint32(i), ofs: ofs[j]})
+		}
+	}
+	sort.Sort(dofs)
+
+	t := &defFilesTreeTable{
+		Units:    unitIDs,
+		DefUnits: make([]uint32, len(dofs)),
+		Offsets:  make([]int64, len(dofs)),
+	}
+	for j, d := range dofs {
+		if j == 0 || d.file != dofs[j-1].file {
+			t.Files = append(t.Files, d.file...)
+			t.FileEnds = append(t.FileEnds, uint32(len(t.Files)))
+			t.DefEnds = append(t.DefEnds, 0)
+		}
+		t.DefUnits[j] = d.unit
+		t.Offsets[j] = d.ofs
+		t.DefEnds[len(t.DefEnds)-1] = uint32(j + 1)
+	}
+	x.t = t
+	x.ready = true
+	vlog.Printf("defFilesTreeIndex: done building index (%d files, %d defs).", t.Len(), len(dofs))
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defFilesTreeIndex) Write(w io.Writer) error {
+	if x.t == nil {
+		panic("no defFilesTreeTable to write")
+	}
+	b, err := binary.Marshal(x.t)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read implements persistedIndex.
+func (x *defFilesTreeIndex) Read(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var t defFilesTreeTable
+	err = binary.Unmarshal(b, &t)
+	x.t = &t
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defFilesTreeIndex) Ready() bool { return x.ready }
+
+// Fprint prints a human-readable representation of the index.
+func (x *defFilesTreeIndex) Fprint(w io.Writer) error {
+	if x.t == nil {
+		panic("defFilesTreeTable not built/read")
+	}
+	for i := 0; i < x.t.Len(); i++ {
+		fmt.Fprintf(w, "%s\n", x.t.file(i))
+		start, end := x.t.defs(i)
+		for j := start; j < end; j++ {
+			fmt.Fprintf(w, "\t%v @ %d\n", x.t.Units[x.t.DefUnits[j]], x.t.Offsets[j])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestIndexedTreeStore_DefsByFiles(t *testing.T) {
+	useIndexedStore = true
+	rs := NewFSRepoStore(newTestFS())
+
+	// More defs per file than the source unit-level defFilesIndex
+	// holds, including unexported local defs, in 2 source units.
+	files := map[string][]string{
+		"u1": {"a", "a.go", "a/b.go", "a/c/d.go"},
+		"u2": {"a/b.go", "e.go"},
+	}
+	for _, name := range []string{"u1", "u2"} {
+		u := &unit.SourceUnit{Type: "t", Name: name, Files: files[name]}
+		var data graph.Output
+		for _, file := range files[name] {
+			for i := 0; i < 10; i++ {
+				data.Defs = append(data.Defs, &graph.Def{
+					DefKey: graph.DefKey{Path: fmt.Sprintf("%s/%d", file, i)},
+					File:   file,
+					Local:  i%2 == 0,
+				})
+			}
+		}
+		if err := rs.Import("c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.(RepoIndexer).Index("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		files []string
+		want  map[string]int // unit -> number of defs
+	}{
+		{[]string{"a.go"}, map[string]int{"u1": 10}},
+		{[]string{"a/b.go"}, map[string]int{"u1": 10, "u2": 10}},
+		{[]string{"a"}, map[string]int{"u1": 30, "u2": 10}},
+		{[]string{"a", "a/c/d.go"}, map[string]int{"u1": 30, "u2": 10}},
+		{[]string{"a/c"}, map[string]int{"u1": 10}},
+		{[]string{"e.go", "a.go"}, map[string]int{"u1": 10, "u2": 10}},
+		{[]string{"x"}, map[string]int{}},
+	}
+	for _, test := range tests {
+		c_defFilesTreeIndex_getByPath = 0
+		c_defFilesIndex_getByPath = 0
+		defs, err := rs.Defs(ByCommitIDs("c"), ByFiles(test.files...))
+		if err != nil {
+			t.Fatalf("%v: %s", test.files, err)
+		}
+		got := map[string]int{}
+		var paths []string
+		for _, def := range defs {
+			got[def.Unit]++
+			paths = append(paths, def.Unit+":"+def.Path)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got defs per unit %v, want %v", test.files, got, test.want)
+		}
+		sorted := append([]string(nil), paths...)
+		sort.Strings(sorted)
+		for i := 1; i < len(sorted); i++ {
+			if sorted[i] == sorted[i-1] {
+				t.Errorf("%v: got duplicate def %s", test.files, sorted[i])
+			}
+		}
+		if want := len(test.files); c_defFilesTreeIndex_getByPath != want {
+			t.Errorf("%v: got %d defFilesTreeIndex hits, want %d", test.files, c_defFilesTreeIndex_getByPath, want)
+		}
+		if c_defFilesIndex_getByPath != 0 {
+			t.Errorf("%v: got %d defFilesIndex hits, want 0", test.files, c_defFilesIndex_getByPath)
+		}
+	}
+}
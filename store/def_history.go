@@ -0,0 +1,389 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// Kinds of def history events.
+const (
+	DefAdded   = "added"
+	DefChanged = "changed"
+	DefRemoved = "removed"
+)
+
+// A DefHistoryEvent describes a change to a def between two
+// consecutive imported commits of a repository.
+type DefHistoryEvent struct {
+	// CommitID is the commit at which the change was first seen.
+	CommitID string
+
+	// Change is the kind of change (DefAdded, DefChanged, or
+	// DefRemoved).
+	Change string
+
+	// File, DefStart, and DefEnd are the location of the def at
+	// CommitID. They are empty if the def was removed.
+	File     string `json:",omitempty"`
+	DefStart uint32 `json:",omitempty"`
+	DefEnd   uint32 `json:",omitempty"`
+
+	// Changed lists the aspects of the def that changed ("file",
+	// "range", "kind", and/or "data", which holds the def's
+	// signature and other toolchain-specific information). It is only
+	// set for DefChanged events.
+	Changed []string `json:",omitempty"`
+
+	// RenamedFrom is the key of the def that this def was renamed
+	// from, for DefAdded events. RenamedTo is the key of the def that
+	// this def was renamed to, for DefRemoved events. They are only
+	// set if a rename was recorded between the previous commit and
+	// CommitID (see DefRename); only their UnitType, Unit, and Path
+	// are set.
+	RenamedFrom *graph.DefKey `json:",omitempty"`
+	RenamedTo   *graph.DefKey `json:",omitempty"`
+}
+
+// A DefHistoryStore reports the history of defs across the imported
+// commits of a repository. It is implemented by the FS-backed and
+// in-memory stores at the MultiRepoStore and RepoStore levels.
+//
+// Commits are ordered by when they were imported (which is the order
+// in which they are typically built), not by VCS ancestry.
+type DefHistoryStore interface {
+	// DefHistory returns the history of the def with the given key,
+	// oldest first. The key's CommitID is ignored, and its Repo is
+	// only used by MultiRepoStores.
+	DefHistory(key graph.DefKey) ([]*DefHistoryEvent, error)
+}
+
+const defHistoryIndexName = "def_history"
+
+// newRepoIndexes returns the (unbuilt) repo-level indexes of a
+// fsRepoStore.
+func newRepoIndexes() map[string]Index {
+	return map[string]Index{
+		defHistoryIndexName: &defHistoryIndex{},
+	}
+}
+
+func (s *fsMultiRepoStore) DefHistory(key graph.DefKey) ([]*DefHistoryEvent, error) {
+	if key.Repo == "" {
+		return nil, fmt.Errorf("DefHistory: key.Repo: empty")
+	}
+	return s.openRepoStore(key.Repo).(DefHistoryStore).DefHistory(key)
+}
+
+func (s *memoryMultiRepoStore) DefHistory(key graph.DefKey) ([]*DefHistoryEvent, error) {
+	if key.Repo == "" {
+		return nil, fmt.Errorf("DefHistory: key.Repo: empty")
+	}
+	rs, present := s.repos[key.Repo]
+	if !present {
+		return nil, nil
+	}
+	return rs.DefHistory(key)
+}
+
+// DefHistory implements DefHistoryStore. It uses the def history
+// index if it has been built (see "src store index"); otherwise it
+// scans the defs of all commits.
+func (s *fsRepoStore) DefHistory(key graph.DefKey) ([]*DefHistoryEvent, error) {
+	if x, ok := s.indexes[defHistoryIndexName].(*defHistoryIndex); ok {
+		err := prepareIndex(s.fs, defHistoryIndexName, x)
+		if err == nil {
+			vlog.Printf("fsRepoStore.DefHistory(%+v): Using index %q.", key, defHistoryIndexName)
+			return x.get(defHistoryKey(key))
+		}
+		if _, ok := err.(*errIndexNotExist); !ok {
+			return nil, err
+		}
+	}
+
+	vlog.Printf("fsRepoStore.DefHistory(%+v): No index; scanning all commits.", key)
+	return scanDefHistory(s, key)
+}
+
+func (s *memoryRepoStore) DefHistory(key graph.DefKey) ([]*DefHistoryEvent, error) {
+	return scanDefHistory(s, key)
+}
+
+// scanDefHistory computes the history of a single def by scanning the
+// defs of all commits in rs.
+func scanDefHistory(rs RepoStore, key graph.DefKey) ([]*DefHistoryEvent, error) {
+	fs := []DefFilter{ByDefPath(key.Path)}
+	if key.UnitType != "" && key.Unit != "" {
+		fs = append(fs, ByUnits(unit.ID2{Type: key.UnitType, Name: key.Unit}))
+	}
+	hs, err := defHistories(rs, fs...)
+	if err != nil {
+		return nil, err
+	}
+	return hs[defHistoryKey(key)], nil
+}
+
+// defHistories computes the history of all defs in rs that match the
+// filters, keyed by defHistoryKey.
+func defHistories(rs RepoStore, fs ...DefFilter) (map[string][]*DefHistoryEvent, error) {
+	commitIDs, err := commitIDsByImportTime(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	hs := map[string][]*DefHistoryEvent{}
+	var prev map[string]*graph.Def
+	var prevCommitID string
+	for _, commitID := range commitIDs {
+		defs, err := rs.Defs(append(fs, ByCommitIDs(commitID), Unordered())...)
+		if err != nil {
+			return nil, err
+		}
+		renamedFrom, renamedTo, err := defRenamesBetween(rs, prevCommitID, commitID)
+		if err != nil {
+			return nil, err
+		}
+		cur := make(map[string]*graph.Def, len(defs))
+		for _, def := range defs {
+			k := defHistoryKey(def.DefKey)
+			cur[k] = def
+			if p, present := prev[k]; !present {
+				ev := newDefHistoryEvent(commitID, DefAdded, def)
+				ev.RenamedFrom = renamedFrom[k]
+				hs[k] = append(hs[k], ev)
+			} else if changed := defChanges(p, def); len(changed) > 0 {
+				ev := newDefHistoryEvent(commitID, DefChanged, def)
+				ev.Changed = changed
+				hs[k] = append(hs[k], ev)
+			}
+		}
+		for k := range prev {
+			if _, present := cur[k]; !present {
+				hs[k] = append(hs[k], &DefHistoryEvent{CommitID: commitID, Change: DefRemoved, RenamedTo: renamedTo[k]})
+			}
+		}
+		prev, prevCommitID = cur, commitID
+	}
+	return hs, nil
+}
+
+// defRenamesBetween returns the renames recorded in rs between the
+// two commits, indexed by the defHistoryKey of the new def
+// (renamedFrom) and of the old def (renamedTo).
+func defRenamesBetween(rs RepoStore, fromCommitID, toCommitID string) (renamedFrom, renamedTo map[string]*graph.DefKey, err error) {
+	rrs, ok := rs.(DefRenameStore)
+	if !ok || fromCommitID == "" {
+		return nil, nil, nil
+	}
+	renames, err := rrs.DefRenames("", fromCommitID, toCommitID)
+	if err != nil {
+		return nil, nil, err
+	}
+	renamedFrom = make(map[string]*graph.DefKey, len(renames))
+	renamedTo = make(map[string]*graph.DefKey, len(renames))
+	for _, r := range renames {
+		from, to := r.FromDefKey(), r.ToDefKey()
+		from.Repo, from.CommitID = "", ""
+		to.Repo, to.CommitID = "", ""
+		renamedFrom[defHistoryKey(to)] = &from
+		renamedTo[defHistoryKey(from)] = &to
+	}
+	return renamedFrom, renamedTo, nil
+}
+
+func newDefHistoryEvent(commitID, change string, def *graph.Def) *DefHistoryEvent {
+	return &DefHistoryEvent{CommitID: commitID, Change: change, File: def.File, DefStart: def.DefStart, DefEnd: def.DefEnd}
+}
+
+// defChanges returns the aspects of the def that differ between a and
+// b (see DefHistoryEvent.Changed).
+func defChanges(a, b *graph.Def) []string {
+	var changed []string
+	if a.File != b.File {
+		changed = append(changed, "file")
+	}
+	if a.DefStart != b.DefStart || a.DefEnd != b.DefEnd {
+		changed = append(changed, "range")
+	}
+	if a.Kind != b.Kind {
+		changed = append(changed, "kind")
+	}
+	if !bytes.Equal(a.Data, b.Data) {
+		changed = append(changed, "data")
+	}
+	return changed
+}
+
+// defHistoryKey returns the commit-independent key of a def within a
+// repository.
+func defHistoryKey(key graph.DefKey) string {
+	return key.UnitType + "\x00" + key.Unit + "\x00" + key.Path
+}
+
+// commitIDsByImportTime returns the IDs of the commits in rs, ordered
+// by when they were imported (and then by commit ID).
+func commitIDsByImportTime(rs RepoStore) ([]string, error) {
+	versions, err := rs.Versions()
+	if err != nil {
+		return nil, err
+	}
+	timer, _ := rs.(versionImportTimer)
+	cs := make(commitsByImportTime, len(versions))
+	for i, v := range versions {
+		cs[i].commitID = v.CommitID
+		if timer != nil {
+			cs[i].t = timer.importTime(v.CommitID).UnixNano()
+		}
+	}
+	sort.Sort(cs)
+	commitIDs := make([]string, len(cs))
+	for i, c := range cs {
+		commitIDs[i] = c.commitID
+	}
+	return commitIDs, nil
+}
+
+type commitsByImportTime []struct {
+	commitID string
+	t        int64
+}
+
+func (v commitsByImportTime) Len() int      { return len(v) }
+func (v commitsByImportTime) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v commitsByImportTime) Less(i, j int) bool {
+	return v[i].t < v[j].t || (v[i].t == v[j].t && v[i].commitID < v[j].commitID)
+}
+
+// Indexes implements indexedStore.
+func (s *fsRepoStore) Indexes() map[string]Index { return s.indexes }
+
+// BuildIndex implements indexedStore.
+func (s *fsRepoStore) BuildIndex(name string, x Index) error {
+	switch x := x.(type) {
+	case *defHistoryIndex:
+		hs, err := defHistories(s)
+		if err != nil {
+			return err
+		}
+		if err := x.Build(hs); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unrecognized repo index type: %T", x)
+	}
+	return writeIndex(s.fs, name, x.(persistedIndex))
+}
+
+func (s *fsRepoStore) readIndex(name string, x persistedIndex) error {
+	return readIndex(s.fs, name, x)
+}
+
+func (s *fsRepoStore) statIndex(name string) (os.FileInfo, error) {
+	return statIndex(s.fs, name)
+}
+
+// invalidateIndexes removes the repo-level indexes, which become
+// stale when a commit is imported and indexed. They are rebuilt by
+// "src store index".
+func (s *fsRepoStore) invalidateIndexes() error {
+	if s.indexes == nil {
+		return nil
+	}
+	for name := range s.indexes {
+		if err := s.fs.Remove(fmt.Sprintf(indexFilename, name)); err != nil && !isOSOrVFSNotExist(err) {
+			return err
+		}
+	}
+	s.indexes = newRepoIndexes()
+	return nil
+}
+
+// defHistoryIndex makes it fast to look up the history of a def
+// across all commits in a repository.
+type defHistoryIndex struct {
+	phtable *phtable.CHD
+	ready   bool
+}
+
+var _ interface {
+	Index
+	persistedIndex
+} = (*defHistoryIndex)(nil)
+
+func (x *defHistoryIndex) String() string { return fmt.Sprintf("defHistoryIndex(ready=%v)", x.ready) }
+
+// get returns the history of the def with the given defHistoryKey.
+func (x *defHistoryIndex) get(key string) ([]*DefHistoryEvent, error) {
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+	v := x.phtable.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	var evs []*DefHistoryEvent
+	if err := json.Unmarshal(v, &evs); err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// Covers implements Index. The def history index is not used to
+// satisfy filtered queries, so it covers no filters.
+func (x *defHistoryIndex) Covers(filters interface{}) int { return 0 }
+
+// Build builds the index from the def histories (keyed by
+// defHistoryKey).
+func (x *defHistoryIndex) Build(hs map[string][]*DefHistoryEvent) error {
+	vlog.Printf("defHistoryIndex: building index...")
+	b := phtable.Builder(len(hs))
+	for k, evs := range hs {
+		v, err := json.Marshal(evs)
+		if err != nil {
+			return err
+		}
+		b.Add([]byte(k), v)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("defHistoryIndex: done building index (%d defs).", len(hs))
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defHistoryIndex) Write(w io.Writer) error {
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// Read implements persistedIndex.
+func (x *defHistoryIndex) Read(r io.Reader) error {
+	var err error
+	x.phtable, err = phtable.Read(r)
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defHistoryIndex) Ready() bool { return x.ready }
+
+var (
+	_ DefHistoryStore = (*fsMultiRepoStore)(nil)
+	_ DefHistoryStore = (*fsRepoStore)(nil)
+	_ DefHistoryStore = (*memoryMultiRepoStore)(nil)
+	_ DefHistoryStore = (*memoryRepoStore)(nil)
+	_ indexedStore    = (*fsRepoStore)(nil)
+)
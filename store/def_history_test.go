@@ -0,0 +1,78 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_DefHistory(t *testing.T) {
+	testMultiRepoStore_DefHistory(t, newMemoryMultiRepoStore(), false)
+}
+
+func TestFSMultiRepoStore_DefHistory(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_DefHistory(t, NewFSMultiRepoStore(newTestFS(), nil), false)
+}
+
+func TestIndexedFSMultiRepoStore_DefHistory(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_DefHistory(t, NewFSMultiRepoStore(newTestFS(), nil), true)
+}
+
+func testMultiRepoStore_DefHistory(t *testing.T, mrs MultiRepoStoreImporter, buildIndexes bool) {
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	def := func(path, file string, start, end uint32, data string) *graph.Def {
+		return &graph.Def{
+			DefKey:   graph.DefKey{Path: path},
+			File:     file,
+			DefStart: start,
+			DefEnd:   end,
+			Data:     []byte(data),
+		}
+	}
+	commits := []struct {
+		commitID string
+		defs     []*graph.Def
+	}{
+		{"c1", []*graph.Def{def("p", "f", 10, 20, `{}`), def("q", "f", 30, 40, `{}`)}},
+		{"c2", []*graph.Def{def("p", "f", 10, 20, `{}`), def("q", "g", 5, 15, `{}`)}},
+		{"c3", []*graph.Def{def("q", "g", 5, 15, `{"sig":1}`)}},
+	}
+	for _, c := range commits {
+		if err := mrs.Import("r", c.commitID, u, graph.Output{Defs: c.defs}); err != nil {
+			t.Fatalf("%s: Import(%s): %s", mrs, c.commitID, err)
+		}
+	}
+	if buildIndexes {
+		if _, err := BuildIndexes(mrs, IndexCriteria{}, nil); err != nil {
+			t.Fatalf("%s: BuildIndexes: %s", mrs, err)
+		}
+	}
+
+	s := mrs.(DefHistoryStore)
+	tests := map[string][]*DefHistoryEvent{
+		"p": {
+			{CommitID: "c1", Change: DefAdded, File: "f", DefStart: 10, DefEnd: 20},
+			{CommitID: "c3", Change: DefRemoved},
+		},
+		"q": {
+			{CommitID: "c1", Change: DefAdded, File: "f", DefStart: 30, DefEnd: 40},
+			{CommitID: "c2", Change: DefChanged, File: "g", DefStart: 5, DefEnd: 15, Changed: []string{"file", "range"}},
+			{CommitID: "c3", Change: DefChanged, File: "g", DefStart: 5, DefEnd: 15, Changed: []string{"data"}},
+		},
+		"x": nil,
+	}
+	for path, want := range tests {
+		evs, err := s.DefHistory(graph.DefKey{Repo: "r", UnitType: "t", Unit: "u", Path: path})
+		if err != nil {
+			t.Errorf("%s: DefHistory(%s): %s", s, path, err)
+			continue
+		}
+		if !reflect.DeepEqual(evs, want) {
+			t.Errorf("%s: DefHistory(%s): got %v, want %v", s, path, evs, want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/binary"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// defNameIndex makes it fast to find the defs (within a source unit)
+// whose names are in a lexicographic range, such as all names with a
+// given prefix (see ByDefNameRangeFilter). Unlike defQueryIndex, it
+// is case-sensitive and includes all defs. Names are indexed in
+// Unicode normalization form C (see NormalizeDefName), so that
+// equivalent names emitted in different forms are found together.
+//
+// If fold is true, the index holds case-folded names (see
+// FoldDefName) and is used for case-insensitive lookups (see
+// ByDefFoldedNameRangeFilter) instead.
+//
+// It is stored in a columnar layout: the names, sorted, are
+// concatenated into a single byte slice, and two parallel arrays
+// hold the end of each name and the byte offset of its def. Lookups
+// are binary searches over the sorted names.
+type defNameIndex struct {
+	fold bool
+
+	t     *defNameTable
+	ready bool
+}
+
+// NormalizeDefName returns the Unicode normalization form C (NFC) of
+// a def name. Def name filters compare normalized names.
+func NormalizeDefName(name string) string {
+	// Nearly all names are already normalized (and filters call this
+	// for every def they scan), so avoid copying them.
+	if norm.NFC.IsNormalString(name) {
+		return name
+	}
+	return norm.NFC.String(name)
+}
+
+// FoldDefName returns the case-folded, normalized form of a def name,
+// which case-insensitive def name filters compare. It uses Unicode
+// full case folding (so, e.g., "ß" and "SS" are equal), not
+// lowercasing.
+func FoldDefName(name string) string {
+	// A Caser is stateful, so it can't be shared between goroutines.
+	return NormalizeDefName(cases.Fold().String(name))
+}
+
+// key returns the name under which def is indexed.
+func (x *defNameIndex) key(def *graph.Def) string {
+	if x.fold {
+		return FoldDefName(def.Name)
+	}
+	return NormalizeDefName(def.Name)
+}
+
+// defNameTable is the serialized form of a defNameIndex.
+type defNameTable struct {
+	Names    []byte   // concatenated names, in sorted order
+	NameEnds []uint32 // NameEnds[i] is the end of the i'th name in Names
+	Offsets  []int64  // Offsets[i] is the byte offset of the i'th name's def
+}
+
+func (t *defNameTable) Len() int { return len(t.NameEnds) }
+
+func (t *defNameTable) name(i int) string {
+	var start uint32
+	if i > 0 {
+		start = t.NameEnds[i-1]
+	}
+	return string(t.Names[start:t.NameEnds[i]])
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	defIndexBuilder
+	defIndex
+} = (*defNameIndex)(nil)
+
+var c_defNameIndex_getByRange = 0 // counter
+
+func (x *defNameIndex) String() string {
+	return fmt.Sprintf("defNameIndex(fold=%v, ready=%v)", x.fold, x.ready)
+}
+
+// getByRange returns the byte offsets of the defs whose names are in
+// the range [start, end) (or [start, ∞) if end is empty).
+func (x *defNameIndex) getByRange(start, end string) byteOffsets {
+	vlog.Printf("defNameIndex.getByRange(%q, %q)", start, end)
+	c_defNameIndex_getByRange++
+
+	if x.t == nil {
+		panic("defNameTable not built/read")
+	}
+
+	n := x.t.Len()
+	lo := sort.Search(n, func(i int) bool { return x.t.name(i) >= start })
+	hi := n
+	if end != "" {
+		hi = sort.Search(n, func(i int) bool { return x.t.name(i) >= end })
+	}
+	if lo >= hi {
+		return nil
+	}
+	ofs := make(byteOffsets, hi-lo)
+	copy(ofs, x.t.Offsets[lo:hi])
+	vlog.Printf("defNameIndex.getByRange(%q, %q): found %d defs.", start, end, len(ofs))
+	return ofs
+}
+
+// Covers implements defIndex.
+func (x *defNameIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if _, _, ok := x.nameRange(f); ok {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Defs implements defIndex.
+func (x *defNameIndex) Defs(f ...DefFilter) (byteOffsets, error) {
+	for _, ff := range f {
+		if start, end, ok := x.nameRange(ff); ok {
+			return x.getByRange(start, end), nil
+		}
+	}
+	return nil, nil
+}
+
+// nameRange returns the range of indexed names that f selects, if f
+// is a filter that the index can satisfy.
+func (x *defNameIndex) nameRange(f interface{}) (start, end string, ok bool) {
+	if x.fold {
+		if f, ok := f.(ByDefFoldedNameRangeFilter); ok {
+			start, end = f.ByDefFoldedNameRange()
+			return start, end, true
+		}
+	} else if f, ok := f.(ByDefNameRangeFilter); ok {
+		start, end = f.ByDefNameRange()
+		return start, end, true
+	}
+	return "", "", false
+}
+
+type defNameAndOffset struct {
+	name string
+	ofs  int64
+}
+
+type defsByName []defNameAndOffset
+
+func (ds defsByName) Len() int      { return len(ds) }
+func (ds defsByName) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+func (ds defsByName) Less(i, j int) bool {
+	return ds[i].name < ds[j].name || (ds[i].name == ds[j].name && ds[i].ofs < ds[j].ofs)
+}
+
+// Build implements defIndexBuilder.
+func (x *defNameIndex) Build(defs []*graph.Def, ofs byteOffsets) error {
+	vlog.Printf("defNameIndex: building index... (%d defs)", len(defs))
+	dofs := make(defsByName, len(defs))
+	size := 0
+	for i, def := range defs {
+		dofs[i] = defNameAndOffset{x.key(def), ofs[i]}
+		size += len(dofs[i].name)
+	}
+	sort.Sort(dofs)
+
+	t := &defNameTable{
+		Names:    make([]byte, 0, size),
+		NameEnds: make([]uint32, len(dofs)),
+		Offsets:  make([]int64, len(dofs)),
+	}
+	for i, d := range dofs {
+		t.Names = append(t.Names, d.name...)
+		t.NameEnds[i] = uint32(len(t.Names))
+		t.Offsets[i] = d.ofs
+	}
+	x.t = t
+	x.ready = true
+	vlog.Printf("defNameIndex: done building index (%d defs).", len(defs))
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defNameIndex) Write(w io.Writer) error {
+	if x.t == nil {
+		panic("no defNameTable to write")
+	}
+	b, err := binary.Marshal(x.t)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read implements persistedIndex.
+func (x *defNameIndex) Read(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var t defNameTable
+	err = binary.Unmarshal(b, &t)
+	x.t = &t
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defNameIndex) Ready() bool { return x.ready }
+
+// Fprint prints a human-readable representation of the index.
+func (x *defNameIndex) Fprint(w io.Writer) error {
+	if x.t == nil {
+		panic("defNameTable not built/read")
+	}
+	names := make([]string, x.t.Len())
+	for i := range names {
+		names[i] = fmt.Sprintf("%s @ %d", x.t.name(i), x.t.Offsets[i])
+	}
+	_, err := fmt.Fprintln(w, strings.Join(names, "\n"))
+	return err
+}
+
+// rebuildFoldedDefNameIndexes rebuilds the case-folded def name
+// indexes (see FoldDefName) of all source units of all commits in the
+// RepoStore rooted at fs, because format version 3 indexed lowercased
+// instead of case-folded names. Units without a case-folded def name
+// index are skipped.
+func rebuildFoldedDefNameIndexes(fs rwvfs.FileSystem) error {
+	rs := NewFSRepoStore(fs).(*fsRepoStore)
+	commitIDs, err := rs.versionDirs()
+	if isOSOrVFSNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, commitID := range commitIDs {
+		ts := newFSTreeStore(rs.treeStoreFS(commitID), rs.codec)
+		unitFiles, err := ts.unitFilenames()
+		if err != nil {
+			return err
+		}
+		for _, unitFile := range unitFiles {
+			dir := strings.TrimSuffix(unitFile, unitFileSuffix)
+			us := newIndexedUnitStore(rwvfs.Sub(ts.fs, dir), dir, rs.codec).(*indexedUnitStore)
+			if _, err := us.statIndex(defFoldedNameIndexName); isOSOrVFSNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := us.BuildIndex(defFoldedNameIndexName, &defNameIndex{fold: true}); err != nil {
+				return fmt.Errorf("rebuilding case-folded def name index of %s@%s: %s", dir, commitID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestDefNameIndex(t *testing.T) {
+	names := []string{"foo", "Foo", "fooBar", "fob", "bar", "foo", "g"}
+	defs := make([]*graph.Def, len(names))
+	ofs := make(byteOffsets, len(names))
+	for i, name := range names {
+		defs[i] = &graph.Def{Name: name}
+		ofs[i] = int64(i * 10)
+	}
+
+	var x defNameIndex
+	if err := x.Build(defs, ofs); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var x2 defNameIndex
+	if err := x2.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter DefFilter
+		want   byteOffsets
+	}{
+		{ByDefNamePrefix("foo"), byteOffsets{0, 20, 50}},
+		{ByDefNamePrefix("fo"), byteOffsets{0, 20, 30, 50}},
+		{ByDefNamePrefix("F"), byteOffsets{10}},
+		{ByDefNamePrefix("x"), nil},
+		{ByDefNameRange("bar", "fob"), byteOffsets{40}},
+		{ByDefNameRange("fooB", ""), byteOffsets{20, 60}},
+		{ByDefNameRange("", "Foo"), nil},
+	}
+	for _, test := range tests {
+		got, err := x2.Defs(test.filter)
+		if err != nil {
+			t.Errorf("%v: Defs: %s", test.filter, err)
+			continue
+		}
+		sort.Sort(int64Slice(got))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got offsets %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestIndexedUnitStore_DefsByNamePrefix(t *testing.T) {
+	useIndexedStore = true
+	rs := NewFSRepoStore(newTestFS())
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	data := graph.Output{Defs: []*graph.Def{
+		{DefKey: graph.DefKey{Path: "a"}, Name: "NewReader"},
+		{DefKey: graph.DefKey{Path: "b"}, Name: "NewWriter"},
+		{DefKey: graph.DefKey{Path: "c"}, Name: "Reader"},
+	}}
+	if err := rs.Import("c", u, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.(RepoIndexer).Index("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	c_defNameIndex_getByRange = 0
+	defs, err := rs.Defs(ByDefNamePrefix("New"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, def := range defs {
+		paths = append(paths, def.Path)
+	}
+	sort.Strings(paths)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got defs %v, want %v", paths, want)
+	}
+	if c_defNameIndex_getByRange == 0 {
+		t.Error("def name index was not used")
+	}
+}
+
+func TestDefNameIndex_unicodeAndFolded(t *testing.T) {
+	// Names 0 and 1 are the same name in NFC and NFD.
+	// Names 5 and 6 are equal under Unicode case folding, but not
+	// when lowercased.
+	names := []string{"\u00e9t\u00e9", "e\u0301te\u0301", "\u00c9T\u00c9", "Eta", "eta", "Stra\u00dfe", "STRASSE"}
+	defs := make([]*graph.Def, len(names))
+	ofs := make(byteOffsets, len(names))
+	for i, name := range names {
+		defs[i] = &graph.Def{Name: name}
+		ofs[i] = int64(i * 10)
+	}
+
+	x := defNameIndex{}
+	xf := defNameIndex{fold: true}
+	for _, x := range []*defNameIndex{&x, &xf} {
+		if err := x.Build(defs, ofs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		x      *defNameIndex
+		filter DefFilter
+		want   byteOffsets
+	}{
+		{&x, ByDefNamePrefix("\u00e9"), byteOffsets{0, 10}},
+		{&x, ByDefNamePrefix("e\u0301"), byteOffsets{0, 10}},
+		{&x, ByDefNamePrefix("E"), byteOffsets{30}},
+		{&xf, ByDefNamePrefixIgnoreCase("\u00c9"), byteOffsets{0, 10, 20}},
+		{&xf, ByDefNamePrefixIgnoreCase("ET"), byteOffsets{30, 40}},
+		{&xf, ByDefNameRangeIgnoreCase("A", "F"), byteOffsets{30, 40}},
+		{&xf, ByDefNamePrefixIgnoreCase("strass"), byteOffsets{50, 60}},
+		{&xf, ByDefNamePrefixIgnoreCase("STRA\u00df"), byteOffsets{50, 60}},
+	}
+	for _, test := range tests {
+		if test.x.Covers([]DefFilter{test.filter}) != 1 {
+			t.Errorf("%v: %v does not cover filter", test.filter, test.x)
+			continue
+		}
+		got, err := test.x.Defs(test.filter)
+		if err != nil {
+			t.Errorf("%v: Defs: %s", test.filter, err)
+			continue
+		}
+		sort.Sort(int64Slice(got))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got offsets %v, want %v", test.filter, got, test.want)
+		}
+
+		// The filter must agree with the index.
+		var selected byteOffsets
+		for i, def := range defs {
+			if test.filter.SelectDef(def) {
+				selected = append(selected, ofs[i])
+			}
+		}
+		if !reflect.DeepEqual(selected, test.want) {
+			t.Errorf("%v: filter selected %v, want %v", test.filter, selected, test.want)
+		}
+	}
+
+	if x.Covers([]DefFilter{ByDefNamePrefixIgnoreCase("e")}) != 0 {
+		t.Error("case-sensitive index covers case-insensitive filter")
+	}
+	if xf.Covers([]DefFilter{ByDefNamePrefix("e")}) != 0 {
+		t.Error("case-folded index covers case-sensitive filter")
+	}
+}
+
+func TestMigrate_foldedDefNameIndex(t *testing.T) {
+	orig := useIndexedStore
+	defer func() { useIndexedStore = orig }()
+	useIndexedStore = true
+
+	fs := newTestFS()
+	rs := NewFSRepoStore(fs)
+	u := unit.ID2{Type: "t", Name: "u"}
+	data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{UnitType: u.Type, Unit: u.Name, Path: "p"}, Name: "Stra\u00dfe"}}}
+	if err := rs.Import("c", &unit.SourceUnit{Type: u.Type, Name: u.Name}, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a format version 3 store, whose case-folded def name
+	// index holds lowercased names.
+	us := rs.(*fsRepoStore).newTreeStore("c").(*indexedTreeStore).openUnitStore(u).(*indexedUnitStore)
+	old := &defNameIndex{fold: true, t: &defNameTable{Names: []byte("stra\u00dfe"), NameEnds: []uint32{uint32(len("stra\u00dfe"))}, Offsets: []int64{0}}}
+	if err := writeIndex(us.fs, defFoldedNameIndexName, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFormatVersion(fs, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Migrate(rs, MigrateOpt{}); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := NewFSRepoStore(fs).Defs(ByCommitIDs("c"), ByUnits(u), ByDefNamePrefixIgnoreCase("strass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 {
+		t.Errorf("after Migrate: got %d defs with case-folded name prefix, want 1", len(defs))
+	}
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A DefRefCount is the number of refs to a def. It can be used to
+// rank defs by how much they are used (e.g., to decide which defs to
+// document first, or which defs are riskiest to change).
+//
+// Refs from the def's own repository are counted at the same commit as
+// the def. Refs from other repositories are counted separately in
+// CrossRepoRefs (see GlobalRefStore.CrossRepoRefCounts).
+type DefRefCount struct {
+	Repo     string `json:",omitempty"`
+	CommitID string `json:",omitempty"`
+	UnitType string
+	Unit     string
+	Path     string
+
+	// Refs is the number of refs to the def in the same repository.
+	// Like the def-to-refs index that it is computed from, it includes
+	// the refs at the def's own definition site, if the toolchain emits
+	// them.
+	Refs int
+
+	// CrossRepoRefs is the number of refs to the def in other
+	// repositories, and CrossRepos is the number of those
+	// repositories.
+	CrossRepoRefs int `json:",omitempty"`
+	CrossRepos    int `json:",omitempty"`
+}
+
+// A DefRefCountStore counts the refs to defs. It is implemented by the
+// FS-backed and in-memory stores at the MultiRepoStore, RepoStore, and
+// TreeStore levels. At the TreeStore level, it uses the def ref counts
+// index if it has been built; otherwise it reads the refs of the
+// source units.
+type DefRefCountStore interface {
+	// DefRefCounts returns the number of refs to each def (in the
+	// same repository) that is referred to by the refs in the source
+	// units that match the filters, sorted by def. Defs that are not
+	// referred to are omitted.
+	DefRefCounts(...UnitFilter) ([]*DefRefCount, error)
+}
+
+func (s repoStores) DefRefCounts(f ...UnitFilter) ([]*DefRefCount, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*DefRefCount
+	for repo, rs := range rss {
+		rcs, ok := rs.(DefRefCountStore)
+		if !ok {
+			continue
+		}
+
+		counts, err := rcs.DefRefCounts(filtersForRepo(repo, f).([]UnitFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, c := range counts {
+			c.Repo = repo
+		}
+		all = append(all, counts...)
+	}
+	sort.Sort(defRefCountsByDef(all))
+	return all, nil
+}
+
+func (s treeStores) DefRefCounts(f ...UnitFilter) ([]*DefRefCount, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]UnitFilter)
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*DefRefCount
+	for commitID, ts := range tss {
+		if ts == nil {
+			continue
+		}
+
+		var counts []*DefRefCount
+		if rcs, ok := ts.(DefRefCountStore); ok {
+			counts, err = rcs.DefRefCounts(f...)
+		} else {
+			counts, err = scanDefRefCounts(ts, f)
+		}
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, c := range counts {
+			c.CommitID = commitID
+		}
+		all = append(all, counts...)
+	}
+	sort.Sort(defRefCountsByDef(all))
+	return all, nil
+}
+
+// DefRefCounts implements DefRefCountStore.
+func (s *fsMultiRepoStore) DefRefCounts(f ...UnitFilter) ([]*DefRefCount, error) {
+	return s.repoStores.DefRefCounts(s.resolveRepoAliases(f).([]UnitFilter)...)
+}
+
+// DefRefCounts implements DefRefCountStore. It uses the def ref counts
+// index if it has been built.
+func (s *indexedTreeStore) DefRefCounts(f ...UnitFilter) ([]*DefRefCount, error) {
+	x := s.indexes[defRefCountsIndexName].(*defRefCountsIndex)
+	if err := prepareIndex(s.fs, defRefCountsIndexName, x); err != nil {
+		// Trees indexed before the def ref counts index existed don't
+		// have it.
+		if _, ok := err.(*errIndexNotExist); !ok {
+			return nil, err
+		}
+		return scanDefRefCounts(s, f)
+	}
+
+	var scope map[unit.ID2]struct{}
+	if len(f) > 0 {
+		unitIDs, err := s.unitIDs(false, f...)
+		if err != nil {
+			return nil, err
+		}
+		scope = make(map[unit.ID2]struct{}, len(unitIDs))
+		for _, u := range unitIDs {
+			scope[u] = struct{}{}
+		}
+	}
+
+	counts := map[graph.RefDefKey]int{}
+	for _, uc := range x.units {
+		if scope != nil {
+			if _, ok := scope[unit.ID2{Type: uc.UnitType, Name: uc.Unit}]; !ok {
+				continue
+			}
+		}
+		for _, c := range uc.Defs {
+			counts[graph.RefDefKey{DefUnitType: c.UnitType, DefUnit: c.Unit, DefPath: c.Path}] += c.Refs
+		}
+	}
+	return defRefCountsFromMap(counts), nil
+}
+
+// scanDefRefCounts counts the refs to defs in the same repository by
+// reading the refs of the source units in ts that match the filters.
+func scanDefRefCounts(ts TreeStore, f []UnitFilter) ([]*DefRefCount, error) {
+	units, err := ts.Units(f...)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, nil
+	}
+
+	unitIDs := make([]unit.ID2, len(units))
+	for i, u := range units {
+		unitIDs[i] = u.ID2()
+	}
+	refs, err := ts.Refs(ByUnits(unitIDs...), Unordered())
+	if err != nil {
+		return nil, err
+	}
+	counts := map[graph.RefDefKey]int{}
+	for _, ref := range refs {
+		if ref.DefRepo != "" {
+			continue
+		}
+		counts[graph.RefDefKey{DefUnitType: ref.DefUnitType, DefUnit: ref.DefUnit, DefPath: ref.DefPath}]++
+	}
+	return defRefCountsFromMap(counts), nil
+}
+
+// defRefCountsFromMap converts a map of def keys (whose DefRepo is
+// empty) to ref counts into a list of DefRefCounts, sorted by def.
+func defRefCountsFromMap(counts map[graph.RefDefKey]int) []*DefRefCount {
+	all := make([]*DefRefCount, 0, len(counts))
+	for def, n := range counts {
+		all = append(all, &DefRefCount{UnitType: def.DefUnitType, Unit: def.DefUnit, Path: def.DefPath, Refs: n})
+	}
+	sort.Sort(defRefCountsByDef(all))
+	return all
+}
+
+// CrossRepoRefCounts implements GlobalRefStore. Each other repository
+// is counted once, using the number of refs to the def in the commit
+// (of the ones in the global ref index) that has the most of them.
+func (s *fsMultiRepoStore) CrossRepoRefCounts(defRepo string) ([]*DefRefCount, error) {
+	defRepo = s.canonicalRepo(defRepo)
+
+	maxRefs := map[crossRepoDef]int{}
+	for _, dr := range append([]string{defRepo}, s.repoAliasesOf(defRepo)...) {
+		dir := s.globalRefsDefRepoDir(dr)
+		fis, err := s.fs.ReadDir(dir)
+		if isOSOrVFSNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			repo, _, err := parseGlobalRefsSourceFilename(fi.Name())
+			if err != nil {
+				return nil, err
+			}
+			if !s.inScope(repo) {
+				continue
+			}
+			var entries globalRefEntries
+			if err := readJSONFile(s.fs, s.fs.Join(dir, fi.Name()), &entries); err != nil {
+				return nil, err
+			}
+			commitRefs := map[graph.RefDefKey]int{}
+			for _, e := range entries {
+				commitRefs[graph.RefDefKey{DefUnitType: e.DefUnitType, DefUnit: e.DefUnit, DefPath: e.DefPath}] += e.Refs
+			}
+			for def, n := range commitRefs {
+				if k := (crossRepoDef{repo, def}); n > maxRefs[k] {
+					maxRefs[k] = n
+				}
+			}
+		}
+	}
+	return crossRepoRefCounts(defRepo, maxRefs), nil
+}
+
+// CrossRepoRefCounts implements GlobalRefStore. The in-memory store
+// has no index, so it scans the refs of all repos.
+func (s *memoryMultiRepoStore) CrossRepoRefCounts(defRepo string) ([]*DefRefCount, error) {
+	refs, err := s.Refs(AbsRefFilterFunc(func(ref *graph.Ref) bool {
+		return ref.DefRepo == defRepo && ref.Repo != defRepo
+	}), Unordered())
+	if err != nil && !isStoreNotExist(err) {
+		return nil, err
+	}
+
+	type commitDef struct {
+		crossRepoDef
+		commitID string
+	}
+	commitRefs := map[commitDef]int{}
+	for _, ref := range refs {
+		def := graph.RefDefKey{DefUnitType: ref.DefUnitType, DefUnit: ref.DefUnit, DefPath: ref.DefPath}
+		commitRefs[commitDef{crossRepoDef{ref.Repo, def}, ref.CommitID}]++
+	}
+	maxRefs := map[crossRepoDef]int{}
+	for k, n := range commitRefs {
+		if n > maxRefs[k.crossRepoDef] {
+			maxRefs[k.crossRepoDef] = n
+		}
+	}
+	return crossRepoRefCounts(defRepo, maxRefs), nil
+}
+
+// A crossRepoDef is a def (in the repository whose cross-repo ref
+// counts are being computed) that is referred to from repo.
+type crossRepoDef struct {
+	repo string
+	def  graph.RefDefKey
+}
+
+// crossRepoRefCounts sums the number of refs from each other
+// repository to each def in defRepo into a list of DefRefCounts,
+// sorted by def.
+func crossRepoRefCounts(defRepo string, refs map[crossRepoDef]int) []*DefRefCount {
+	byDef := map[graph.RefDefKey]*DefRefCount{}
+	all := []*DefRefCount{}
+	for k, n := range refs {
+		c, present := byDef[k.def]
+		if !present {
+			c = &DefRefCount{Repo: defRepo, UnitType: k.def.DefUnitType, Unit: k.def.DefUnit, Path: k.def.DefPath}
+			byDef[k.def] = c
+			all = append(all, c)
+		}
+		c.CrossRepoRefs += n
+		c.CrossRepos++
+	}
+	sort.Sort(defRefCountsByDef(all))
+	return all
+}
+
+type defRefCountsByDef []*DefRefCount
+
+func (v defRefCountsByDef) Len() int      { return len(v) }
+func (v defRefCountsByDef) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v defRefCountsByDef) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ak := []string{a.Repo, a.CommitID, a.UnitType, a.Unit, a.Path}
+	bk := []string{b.Repo, b.CommitID, b.UnitType, b.Unit, b.Path}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+var (
+	_ DefRefCountStore = (*fsMultiRepoStore)(nil)
+	_ DefRefCountStore = (*memoryMultiRepoStore)(nil)
+	_ DefRefCountStore = (*indexedTreeStore)(nil)
+)
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alecthomas/binary"
+	"github.com/gogo/protobuf/proto"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+const defRefCountsIndexName = "def_ref_counts"
+
+// defRefCountsIndex records, for each source unit in a tree, the
+// number of its refs to each def in the same repository. It is built
+// from the source units' def-to-refs indexes, so counting refs doesn't
+// require reading any ref data.
+type defRefCountsIndex struct {
+	units []*unitDefRefCounts // sorted by unit
+	ready bool
+}
+
+// unitDefRefCounts holds the number of refs in a source unit to each
+// def in the same repository.
+type unitDefRefCounts struct {
+	UnitType string
+	Unit     string
+
+	// Defs is sorted by def. The Repo and CommitID fields are empty.
+	Defs []*DefRefCount
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	unitRefDefIndexBuilder
+} = (*defRefCountsIndex)(nil)
+
+func (x *defRefCountsIndex) String() string {
+	return fmt.Sprintf("defRefCountsIndex(ready=%v)", x.ready)
+}
+
+// Covers implements Index. The def ref counts index is only consulted
+// by DefRefCounts, never to satisfy other queries.
+func (x *defRefCountsIndex) Covers(filters interface{}) int { return 0 }
+
+// Build implements unitRefDefIndexBuilder.
+func (x *defRefCountsIndex) Build(units []*unit.SourceUnit, unitRefIndexes map[unit.ID2]*defRefsIndex, unitDefIndexes map[unit.ID2]*defPathIndex) error {
+	vlog.Printf("defRefCountsIndex: building index (%d units)...", len(units))
+	x.units = make([]*unitDefRefCounts, 0, len(units))
+	for _, u := range units {
+		uc := &unitDefRefCounts{UnitType: u.Type, Unit: u.Name}
+		x.units = append(x.units, uc)
+
+		rx := unitRefIndexes[u.ID2()]
+		if rx == nil {
+			continue
+		}
+		for it := rx.phtable.Iterate(); it != nil; it = it.Next() {
+			kb, vb := it.Get()
+			if len(kb) == 0 {
+				// Empty slot in the phtable.
+				continue
+			}
+			var def graph.RefDefKey
+			if err := proto.Unmarshal(kb, &def); err != nil {
+				return err
+			}
+			if def.DefRepo != "" {
+				continue
+			}
+			var ofs byteOffsets
+			if err := binary.Unmarshal(vb, &ofs); err != nil {
+				return err
+			}
+
+			// Set implied fields.
+			if def.DefUnit == "" {
+				def.DefUnit = u.Name
+			}
+			if def.DefUnitType == "" {
+				def.DefUnitType = u.Type
+			}
+
+			uc.Defs = append(uc.Defs, &DefRefCount{UnitType: def.DefUnitType, Unit: def.DefUnit, Path: def.DefPath, Refs: len(ofs)})
+		}
+		sort.Sort(defRefCountsByDef(uc.Defs))
+	}
+	sort.Sort(unitDefRefCountsByUnit(x.units))
+	x.ready = true
+	vlog.Printf("defRefCountsIndex: done building index.")
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defRefCountsIndex) Write(w io.Writer) error {
+	if x.units == nil {
+		panic("no def ref counts to write")
+	}
+	return json.NewEncoder(w).Encode(x.units)
+}
+
+// Read implements persistedIndex.
+func (x *defRefCountsIndex) Read(r io.Reader) error {
+	err := json.NewDecoder(r).Decode(&x.units)
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defRefCountsIndex) Ready() bool { return x.ready }
+
+type unitDefRefCountsByUnit []*unitDefRefCounts
+
+func (v unitDefRefCountsByUnit) Len() int      { return len(v) }
+func (v unitDefRefCountsByUnit) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v unitDefRefCountsByUnit) Less(i, j int) bool {
+	if v[i].UnitType != v[j].UnitType {
+		return v[i].UnitType < v[j].UnitType
+	}
+	return v[i].Unit < v[j].Unit
+}
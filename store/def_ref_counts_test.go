@@ -0,0 +1,111 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_DefRefCounts(t *testing.T) {
+	testMultiRepoStore_DefRefCounts(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_DefRefCounts(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_DefRefCounts(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_DefRefCounts(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_DefRefCounts(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_DefRefCounts(t *testing.T, mrs MultiRepoStoreImporter) {
+	imports := []struct {
+		repo, commitID string
+		unit           string
+		data           graph.Output
+	}{
+		{"lib", "c", "u1", graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}}, {DefKey: graph.DefKey{Path: "q"}}},
+			Refs: []*graph.Ref{
+				{DefPath: "p", File: "f", Start: 1, End: 2},
+				{DefPath: "p", File: "f", Start: 3, End: 4},
+				{DefPath: "q", File: "f", Start: 5, End: 6},
+			},
+		}},
+		{"lib", "c", "u2", graph.Output{Refs: []*graph.Ref{
+			{DefUnitType: "t", DefUnit: "u1", DefPath: "p", File: "g", Start: 1, End: 2},
+			{DefRepo: "x", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "g", Start: 3, End: 4},
+		}}},
+		{"app", "c1", "u", graph.Output{Refs: []*graph.Ref{
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u1", DefPath: "p", File: "h", Start: 1, End: 2},
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u1", DefPath: "p", File: "h", Start: 3, End: 4},
+		}}},
+		{"app", "c2", "u", graph.Output{Refs: []*graph.Ref{
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u1", DefPath: "p", File: "h", Start: 1, End: 2},
+		}}},
+		{"other", "c", "u", graph.Output{Refs: []*graph.Ref{
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u1", DefPath: "q", File: "h", Start: 1, End: 2},
+		}}},
+	}
+	for _, imp := range imports {
+		u := &unit.SourceUnit{Type: "t", Name: imp.unit, Files: []string{"f", "g", "h"}}
+		if err := mrs.Import(imp.repo, imp.commitID, u, imp.data); err != nil {
+			t.Fatalf("%s: Import(%s, %s, %s): %s", mrs, imp.repo, imp.commitID, imp.unit, err)
+		}
+	}
+	if mrs, ok := mrs.(MultiRepoIndexer); ok {
+		for _, v := range [][2]string{{"lib", "c"}, {"app", "c1"}, {"app", "c2"}, {"other", "c"}} {
+			if err := mrs.Index(v[0], v[1]); err != nil {
+				t.Fatalf("%s: Index(%s, %s): %s", mrs, v[0], v[1], err)
+			}
+		}
+	}
+
+	rcs, ok := mrs.(DefRefCountStore)
+	if !ok {
+		t.Fatalf("%s: does not implement DefRefCountStore", mrs)
+	}
+	counts, err := rcs.DefRefCounts(ByRepos("lib"))
+	if err != nil {
+		t.Fatalf("%s: DefRefCounts: %s", mrs, err)
+	}
+	want := []*DefRefCount{
+		{Repo: "lib", CommitID: "c", UnitType: "t", Unit: "u1", Path: "p", Refs: 3},
+		{Repo: "lib", CommitID: "c", UnitType: "t", Unit: "u1", Path: "q", Refs: 1},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("%s: DefRefCounts: got %v, want %v", mrs, counts, want)
+	}
+
+	// Only the refs in the source units that match the filters are
+	// counted.
+	counts, err = rcs.DefRefCounts(ByRepos("lib"), ByUnits(unit.ID2{Type: "t", Name: "u2"}))
+	if err != nil {
+		t.Fatalf("%s: DefRefCounts(unit u2): %s", mrs, err)
+	}
+	if want := []*DefRefCount{{Repo: "lib", CommitID: "c", UnitType: "t", Unit: "u1", Path: "p", Refs: 1}}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("%s: DefRefCounts(unit u2): got %v, want %v", mrs, counts, want)
+	}
+
+	// Each other repo is counted once, at its commit with the most
+	// refs to the def.
+	grs, ok := mrs.(GlobalRefStore)
+	if !ok {
+		t.Fatalf("%s: does not implement GlobalRefStore", mrs)
+	}
+	xcounts, err := grs.CrossRepoRefCounts("lib")
+	if err != nil {
+		t.Fatalf("%s: CrossRepoRefCounts: %s", mrs, err)
+	}
+	wantX := []*DefRefCount{
+		{Repo: "lib", UnitType: "t", Unit: "u1", Path: "p", CrossRepoRefs: 2, CrossRepos: 1},
+		{Repo: "lib", UnitType: "t", Unit: "u1", Path: "q", CrossRepoRefs: 1, CrossRepos: 1},
+	}
+	if !reflect.DeepEqual(xcounts, wantX) {
+		t.Errorf("%s: CrossRepoRefCounts: got %v, want %v", mrs, xcounts, wantX)
+	}
+}
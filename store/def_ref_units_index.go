@@ -73,7 +73,13 @@ func (x *defRefUnitsIndex) Covers(filters interface{}) int {
 func (x *defRefUnitsIndex) Units(fs ...UnitFilter) ([]unit.ID2, error) {
 	for _, f := range fs {
 		if ff, ok := f.(ByRefDefFilter); ok {
-			us, found, err := x.getByDef(ff.withEmptyImpliedValues())
+			// The index's keys have their DefUnit{,Type} set (see
+			// Build), so only the DefRepo is implied. The filter's
+			// implied unit may be left over from querying another
+			// tree, so it must not be used here.
+			def := ff.withEmptyImpliedValues()
+			def.DefUnitType, def.DefUnit = ff.ByDefUnitType(), ff.ByDefUnit()
+			us, found, err := x.getByDef(def)
 			if err != nil {
 				return nil, err
 			}
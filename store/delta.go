@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// deltaBlockSize is the size of the blocks of the base that
+// deltaEncode looks for in the target. Shorter matches are stored as
+// literal data.
+const deltaBlockSize = 16
+
+// deltaEncode returns a delta that deltaApply uses to reconstruct
+// target from base. Files in consecutive commits' data are usually
+// mostly identical, so their deltas are much smaller than the files.
+//
+// A delta consists of a header (the lengths of target and base and
+// the checksum of base, as uvarints) followed by ops. Each op starts
+// with a uvarint n. If n is even, it is followed by n/2 bytes of
+// literal data to insert; otherwise, it is followed by a uvarint
+// offset, and n/2 bytes of base at that offset are copied.
+func deltaEncode(base, target []byte) []byte {
+	var buf bytes.Buffer
+	putUvarint := func(x uint64) {
+		var b [binary.MaxVarintLen64]byte
+		buf.Write(b[:binary.PutUvarint(b[:], x)])
+	}
+	putUvarint(uint64(len(target)))
+	putUvarint(uint64(len(base)))
+	putUvarint(uint64(crc32.ChecksumIEEE(base)))
+
+	// Index the offsets of the (aligned) blocks of base.
+	blocks := make(map[string]int, len(base)/deltaBlockSize)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		if _, dup := blocks[string(base[i:i+deltaBlockSize])]; !dup {
+			blocks[string(base[i:i+deltaBlockSize])] = i
+		}
+	}
+
+	insert := func(data []byte) {
+		if len(data) > 0 {
+			putUvarint(uint64(len(data)) << 1)
+			buf.Write(data)
+		}
+	}
+
+	lit := 0 // start of the literal data not yet written
+	for i := 0; i+deltaBlockSize <= len(target); {
+		j, ok := blocks[string(target[i:i+deltaBlockSize])]
+		if !ok {
+			i++
+			continue
+		}
+
+		// Extend the match backward (into the pending literal data)
+		// and forward.
+		for i > lit && j > 0 && target[i-1] == base[j-1] {
+			i--
+			j--
+		}
+		n := deltaBlockSize
+		for i+n < len(target) && j+n < len(base) && target[i+n] == base[j+n] {
+			n++
+		}
+
+		insert(target[lit:i])
+		putUvarint(uint64(n)<<1 | 1)
+		putUvarint(uint64(j))
+		i += n
+		lit = i
+	}
+	insert(target[lit:])
+	return buf.Bytes()
+}
+
+// errDeltaBaseChanged is returned by deltaApply when the base differs
+// from the base that the delta was created against (e.g., because the
+// base commit was reimported after the delta was created).
+var errDeltaBaseChanged = errors.New("delta base changed since the delta was created")
+
+// deltaApply reconstructs the target of delta (see deltaEncode) from
+// base.
+func deltaApply(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	targetLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errDeltaCorrupt(err)
+	}
+	baseLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errDeltaCorrupt(err)
+	}
+	baseSum, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errDeltaCorrupt(err)
+	}
+	if baseLen != uint64(len(base)) || baseSum != uint64(crc32.ChecksumIEEE(base)) {
+		return nil, errDeltaBaseChanged
+	}
+
+	target := make([]byte, 0, targetLen)
+	for r.Len() > 0 {
+		op, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errDeltaCorrupt(err)
+		}
+		n := op >> 1
+		if n > targetLen-uint64(len(target)) {
+			return nil, errDeltaCorrupt(fmt.Errorf("op of %d bytes overflows target", n))
+		}
+		if op&1 == 0 {
+			start := len(target)
+			target = target[:start+int(n)]
+			if _, err := io.ReadFull(r, target[start:]); err != nil {
+				return nil, errDeltaCorrupt(err)
+			}
+			continue
+		}
+		ofs, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errDeltaCorrupt(err)
+		}
+		if ofs > uint64(len(base)) || n > uint64(len(base))-ofs {
+			return nil, errDeltaCorrupt(fmt.Errorf("copy of %d bytes at offset %d is out of range", n, ofs))
+		}
+		target = append(target, base[ofs:ofs+n]...)
+	}
+	if uint64(len(target)) != targetLen {
+		return nil, errDeltaCorrupt(fmt.Errorf("got %d bytes, want %d", len(target), targetLen))
+	}
+	return target, nil
+}
+
+func errDeltaCorrupt(err error) error {
+	return fmt.Errorf("corrupt delta: %v", err)
+}
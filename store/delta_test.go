@@ -0,0 +1,39 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	base := []byte(strings.Repeat("abcdefghijklmnopqrstuvwxyz0123456789", 20))
+	tests := map[string][]byte{
+		"identical": base,
+		"empty":     []byte{},
+		"unrelated": []byte("hello, world"),
+		"edited":    append(append([]byte("prefix"), base[:300]...), append([]byte("inserted"), base[310:]...)...),
+		"repeated":  append(append([]byte{}, base...), base...),
+	}
+	for label, target := range tests {
+		delta := deltaEncode(base, target)
+		got, err := deltaApply(base, delta)
+		if err != nil {
+			t.Errorf("%s: %s", label, err)
+			continue
+		}
+		if !bytes.Equal(got, target) {
+			t.Errorf("%s: got %q, want %q", label, got, target)
+		}
+	}
+
+	if delta := deltaEncode(base, tests["edited"]); len(delta) > 50 {
+		t.Errorf("got delta of %d bytes for a small edit, want at most 50", len(delta))
+	}
+
+	changed := append([]byte{}, base...)
+	changed[0] = 'A'
+	if _, err := deltaApply(changed, deltaEncode(base, tests["edited"])); err != errDeltaBaseChanged {
+		t.Errorf("got err %v, want errDeltaBaseChanged", err)
+	}
+}
@@ -0,0 +1,345 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A DepStore stores and accesses the resolved dependencies of source
+// units. It is implemented by the FS-backed and in-memory stores at
+// the MultiRepoStore, RepoStore, and TreeStore levels. (Resolved deps
+// are stored per source unit, alongside the source unit definition,
+// so there is no UnitStore-level DepStore.)
+type DepStore interface {
+	// Deps returns all resolved deps that match the filters.
+	Deps(...DepFilter) ([]*dep.ResolvedDep, error)
+}
+
+// A TreeDepImporter imports the resolved deps of a source unit into
+// a TreeStore. It overwrites any previously imported deps for the
+// source unit.
+type TreeDepImporter interface {
+	ImportDeps(u unit.ID2, deps []*dep.ResolvedDep) error
+}
+
+// A RepoDepImporter imports the resolved deps of a source unit at a
+// specific commit into a RepoStore.
+type RepoDepImporter interface {
+	ImportDeps(commitID string, u unit.ID2, deps []*dep.ResolvedDep) error
+}
+
+// A MultiRepoDepImporter imports the resolved deps of a source unit
+// in a repository at a specific commit into a MultiRepoStore.
+type MultiRepoDepImporter interface {
+	ImportDeps(repo, commitID string, u unit.ID2, deps []*dep.ResolvedDep) error
+}
+
+// A DepFilter filters a set of resolved deps to only those for which
+// SelectDep returns true.
+//
+// As with other stored data, the From{Repo,CommitID,UnitType,Unit}
+// fields of deps are empty in the stores whose scope includes only a
+// single value of those fields (see the package documentation).
+type DepFilter interface {
+	SelectDep(*dep.ResolvedDep) bool
+}
+
+type depFilters []DepFilter
+
+func (fs depFilters) SelectDep(d *dep.ResolvedDep) bool {
+	for _, f := range fs {
+		if !f.SelectDep(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// A DepFilterFunc is a DepFilter that selects only those deps for
+// which the func returns true.
+type DepFilterFunc func(*dep.ResolvedDep) bool
+
+// SelectDep calls f(d).
+func (f DepFilterFunc) SelectDep(d *dep.ResolvedDep) bool { return f(d) }
+func (f DepFilterFunc) String() string                    { return "DepFilterFunc" }
+
+// ByDepTarget returns a filter that selects deps on the given
+// repository. If unitType and unit are non-empty, only deps on that
+// source unit are selected. It can be used to find the dependents of
+// a library. It panics if toRepo is empty.
+func ByDepTarget(toRepo, toUnitType, toUnit string) DepFilter {
+	if toRepo == "" {
+		panic("toRepo: empty")
+	}
+	return byDepTargetFilter{repo: toRepo, unitType: toUnitType, unit: toUnit}
+}
+
+type byDepTargetFilter struct{ repo, unitType, unit string }
+
+func (f byDepTargetFilter) String() string {
+	return fmt.Sprintf("ByDepTarget(%s %s %s)", f.repo, f.unitType, f.unit)
+}
+func (f byDepTargetFilter) SelectDep(d *dep.ResolvedDep) bool {
+	return d.ToRepo == f.repo && (f.unitType == "" || d.ToUnitType == f.unitType) && (f.unit == "" || d.ToUnit == f.unit)
+}
+
+// resolvedDepsByKey sorts deps by (from, to).
+type resolvedDepsByKey []*dep.ResolvedDep
+
+func (v resolvedDepsByKey) Len() int      { return len(v) }
+func (v resolvedDepsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v resolvedDepsByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ak := []string{a.FromRepo, a.FromCommitID, a.FromUnitType, a.FromUnit, a.ToRepo, a.ToUnitType, a.ToUnit, a.ToVersionString, a.ToRevSpec}
+	bk := []string{b.FromRepo, b.FromCommitID, b.FromUnitType, b.FromUnit, b.ToRepo, b.ToUnitType, b.ToUnit, b.ToVersionString, b.ToRevSpec}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+func sortDeps(deps []*dep.ResolvedDep, fs []DepFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(resolvedDepsByKey(deps))
+}
+
+func cleanDepsForImport(deps []*dep.ResolvedDep) {
+	for _, d := range deps {
+		d.FromRepo = ""
+		d.FromCommitID = ""
+		d.FromUnitType = ""
+		d.FromUnit = ""
+	}
+}
+
+func (s repoStores) Deps(f ...DepFilter) ([]*dep.ResolvedDep, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allDeps []*dep.ResolvedDep
+	for repo, rs := range rss {
+		ds, ok := rs.(DepStore)
+		if !ok {
+			continue
+		}
+
+		deps, err := ds.Deps(filtersForRepo(repo, f).([]DepFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, d := range deps {
+			d.FromRepo = repo
+		}
+		allDeps = append(allDeps, deps...)
+	}
+	sortDeps(allDeps, f)
+	return allDeps, nil
+}
+
+func (s treeStores) Deps(f ...DepFilter) ([]*dep.ResolvedDep, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]DepFilter)
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allDeps []*dep.ResolvedDep
+	for commitID, ts := range tss {
+		ds, ok := ts.(DepStore)
+		if !ok {
+			continue
+		}
+
+		deps, err := ds.Deps(f...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, d := range deps {
+			d.FromCommitID = commitID
+		}
+		allDeps = append(allDeps, deps...)
+	}
+	sortDeps(allDeps, f)
+	return allDeps, nil
+}
+
+func (s *fsMultiRepoStore) ImportDeps(repo, commitID string, u unit.ID2, deps []*dep.ResolvedDep) error {
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	subpath := s.fs.Join(s.RepoToPath(repo)...)
+	if err := rwvfs.MkdirAll(s.fs, subpath); err != nil {
+		return err
+	}
+	return s.openRepoStore(repo).(RepoDepImporter).ImportDeps(commitID, u, deps)
+}
+
+func (s *fsRepoStore) ImportDeps(commitID string, u unit.ID2, deps []*dep.ResolvedDep) error {
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	return s.newTreeStore(commitID).(TreeDepImporter).ImportDeps(u, deps)
+}
+
+const unitDepsFileSuffix = ".deps.json"
+
+// unitDepsFilename returns the name of the file that holds the
+// resolved deps of u. It is stored next to the source unit file.
+func (s *fsTreeStore) unitDepsFilename(u unit.ID2) string {
+	return strings.TrimSuffix(s.unitFilename(u.Type, u.Name), unitFileSuffix) + unitDepsFileSuffix
+}
+
+func (s *fsTreeStore) ImportDeps(u unit.ID2, deps []*dep.ResolvedDep) (err error) {
+	cleanDepsForImport(deps)
+	filename := s.unitDepsFilename(u)
+	if err := rwvfs.MkdirAll(s.fs, path.Dir(filename)); err != nil {
+		return err
+	}
+	f, err := s.fs.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	if deps == nil {
+		deps = []*dep.ResolvedDep{}
+	}
+	return json.NewEncoder(f).Encode(deps)
+}
+
+func (s *fsTreeStore) Deps(f ...DepFilter) ([]*dep.ResolvedDep, error) {
+	unitIDs, err := scopeUnits(storeFilters(f))
+	if err != nil {
+		return nil, err
+	}
+	if unitIDs == nil {
+		unitFiles, err := s.unitFilenames()
+		if err != nil {
+			return nil, err
+		}
+		for _, unitFile := range unitFiles {
+			dir := strings.TrimSuffix(unitFile, unitFileSuffix)
+			unitIDs = append(unitIDs, unit.ID2{Type: path.Base(dir), Name: path.Dir(dir)})
+		}
+	}
+
+	var allDeps []*dep.ResolvedDep
+	for _, u := range unitIDs {
+		deps, err := s.readUnitDeps(u)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deps {
+			d.FromUnitType = u.Type
+			d.FromUnit = u.Name
+			if depFilters(f).SelectDep(d) {
+				allDeps = append(allDeps, d)
+			}
+		}
+	}
+	sortDeps(allDeps, f)
+	return allDeps, nil
+}
+
+// readUnitDeps reads the resolved deps of u. If none have been
+// imported, it returns an empty list.
+func (s *fsTreeStore) readUnitDeps(u unit.ID2) (deps []*dep.ResolvedDep, err error) {
+	f, err := s.fs.Open(s.unitDepsFilename(u))
+	if err != nil {
+		if isOSOrVFSNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	if err := json.NewDecoder(f).Decode(&deps); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+func (s *memoryMultiRepoStore) ImportDeps(repo, commitID string, u unit.ID2, deps []*dep.ResolvedDep) error {
+	if s.repos == nil {
+		s.repos = map[string]*memoryRepoStore{}
+	}
+	if _, present := s.repos[repo]; !present {
+		s.repos[repo] = newMemoryRepoStore()
+	}
+	return s.repos[repo].ImportDeps(commitID, u, deps)
+}
+
+func (s *memoryRepoStore) ImportDeps(commitID string, u unit.ID2, deps []*dep.ResolvedDep) error {
+	if s.trees == nil {
+		s.trees = map[string]*memoryTreeStore{}
+	}
+	if _, present := s.trees[commitID]; !present {
+		s.versions = append(s.versions, &Version{CommitID: commitID})
+		s.trees[commitID] = newMemoryTreeStore()
+	}
+	return s.trees[commitID].ImportDeps(u, deps)
+}
+
+func (s *memoryTreeStore) ImportDeps(u unit.ID2, deps []*dep.ResolvedDep) error {
+	cleanDepsForImport(deps)
+	if s.deps == nil {
+		s.deps = map[unit.ID2][]*dep.ResolvedDep{}
+	}
+	s.deps[u] = deps
+	return nil
+}
+
+func (s *memoryTreeStore) Deps(f ...DepFilter) ([]*dep.ResolvedDep, error) {
+	var allDeps []*dep.ResolvedDep
+	for u, deps := range s.deps {
+		for _, d := range deps {
+			d.FromUnitType = u.Type
+			d.FromUnit = u.Name
+			if depFilters(f).SelectDep(d) {
+				allDeps = append(allDeps, d)
+			}
+		}
+	}
+	sortDeps(allDeps, f)
+	return allDeps, nil
+}
+
+var (
+	_ DepStore             = (*fsMultiRepoStore)(nil)
+	_ DepStore             = (*fsRepoStore)(nil)
+	_ DepStore             = (*fsTreeStore)(nil)
+	_ DepStore             = (*memoryMultiRepoStore)(nil)
+	_ DepStore             = (*memoryRepoStore)(nil)
+	_ DepStore             = (*memoryTreeStore)(nil)
+	_ MultiRepoDepImporter = (*fsMultiRepoStore)(nil)
+	_ RepoDepImporter      = (*fsRepoStore)(nil)
+	_ TreeDepImporter      = (*fsTreeStore)(nil)
+	_ MultiRepoDepImporter = (*memoryMultiRepoStore)(nil)
+	_ RepoDepImporter      = (*memoryRepoStore)(nil)
+	_ TreeDepImporter      = (*memoryTreeStore)(nil)
+)
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_Deps(t *testing.T) {
+	testMultiRepoStore_Deps(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Deps(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_Deps(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_Deps(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_Deps(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_Deps(t *testing.T, mrs MultiRepoStoreImporter) {
+	type importer interface {
+		MultiRepoStoreImporter
+		MultiRepoDepImporter
+		DepStore
+	}
+	s := mrs.(importer)
+
+	imports := []struct {
+		repo string
+		u    unit.ID2
+		deps []*dep.ResolvedDep
+	}{
+		{"app1", unit.ID2{Type: "t", Name: "u"}, []*dep.ResolvedDep{{ToRepo: "lib", ToUnitType: "t", ToUnit: "l1"}, {ToRepo: "other", ToUnitType: "t", ToUnit: "o"}}},
+		{"app2", unit.ID2{Type: "t", Name: "u"}, []*dep.ResolvedDep{{ToRepo: "lib", ToUnitType: "t", ToUnit: "l2"}}},
+		{"lib", unit.ID2{Type: "t", Name: "l1"}, nil},
+	}
+	for _, imp := range imports {
+		u := &unit.SourceUnit{Type: imp.u.Type, Name: imp.u.Name}
+		if err := s.Import(imp.repo, "c", u, graph.Output{}); err != nil {
+			t.Fatalf("%s: Import: %s", s, err)
+		}
+		if err := s.ImportDeps(imp.repo, "c", imp.u, imp.deps); err != nil {
+			t.Fatalf("%s: ImportDeps: %s", s, err)
+		}
+	}
+
+	deps, err := s.Deps(ByRepoCommitIDs(Version{Repo: "app1", CommitID: "c"}))
+	if err != nil {
+		t.Fatalf("%s: Deps(app1@c): %s", s, err)
+	}
+	want := []*dep.ResolvedDep{
+		{FromRepo: "app1", FromCommitID: "c", FromUnitType: "t", FromUnit: "u", ToRepo: "lib", ToUnitType: "t", ToUnit: "l1"},
+		{FromRepo: "app1", FromCommitID: "c", FromUnitType: "t", FromUnit: "u", ToRepo: "other", ToUnitType: "t", ToUnit: "o"},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("%s: Deps(app1@c): got %v, want %v", s, deps, want)
+	}
+
+	dependents, err := s.Deps(ByDepTarget("lib", "", ""))
+	if err != nil {
+		t.Fatalf("%s: Deps(ByDepTarget(lib)): %s", s, err)
+	}
+	var fromRepos []string
+	for _, d := range dependents {
+		fromRepos = append(fromRepos, d.FromRepo)
+	}
+	if want := []string{"app1", "app2"}; !reflect.DeepEqual(fromRepos, want) {
+		t.Errorf("%s: Deps(ByDepTarget(lib)): got dependents %v, want %v", s, fromRepos, want)
+	}
+
+	dependents, err = s.Deps(ByDepTarget("lib", "t", "l2"))
+	if err != nil {
+		t.Fatalf("%s: Deps(ByDepTarget(lib t l2)): %s", s, err)
+	}
+	if len(dependents) != 1 || dependents[0].FromRepo != "app2" {
+		t.Errorf("%s: Deps(ByDepTarget(lib t l2)): got %v, want only the dep from app2", s, dependents)
+	}
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// NewDiskCacheFS returns a VFS that caches files read from fs (which
+// is typically a network VFS, such as an s3vfs root) in the local
+// directory dir. Files are downloaded in full when they are first
+// opened; later opens only stat the file in fs to check that the
+// cached copy is current (i.e., that the file's size and modification
+// time have not changed) and then read it from disk.
+//
+// The total size of the cached files is capped at maxSize bytes; the
+// least recently used files are evicted when it is exceeded. The
+// cache persists across processes (it is rebuilt from the contents of
+// dir when NewDiskCacheFS is called), but it must not be shared by
+// concurrently running processes.
+//
+// Writes and removals go directly to fs. They need not invalidate the
+// cache, because the cached copies of modified files no longer match
+// the files' size and modification time.
+//
+// The returned VFS does not implement rwvfs.FetcherOpener, so the
+// store reads whole files through the cache instead of issuing ranged
+// fetches to fs.
+func NewDiskCacheFS(fs rwvfs.FileSystem, dir string, maxSize int64) (rwvfs.FileSystem, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &diskCacheFS{
+		FileSystem: fs,
+		dir:        dir,
+		maxSize:    maxSize,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type diskCacheFS struct {
+	rwvfs.FileSystem // the underlying (uncached) VFS
+
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64                    // total size of cached files
+	lru     *list.List               // *diskCacheEntry; front is most recently used
+	entries map[string]*list.Element // cache file name -> lru element
+}
+
+// A diskCacheEntry is a file in the disk cache.
+type diskCacheEntry struct {
+	name string // cache file name (relative to the cache dir)
+	size int64
+}
+
+// cacheName returns the name of the cache file for the file name in
+// the underlying VFS with the given FileInfo. The size and
+// modification time are encoded in the name so that stale cache files
+// are never used.
+func cacheName(name string, fi os.FileInfo) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return filepath.FromSlash(fmt.Sprintf("%s@%d-%d", name, fi.Size(), fi.ModTime().UnixNano()))
+}
+
+// load adds the files in the cache dir to the LRU list, in order of
+// their last access (as recorded by their modification times).
+func (c *diskCacheFS) load() error {
+	var files []cacheFile
+	err := filepath.Walk(c.dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			if strings.HasPrefix(fi.Name(), ".tmp") {
+				// Left over from an interrupted download.
+				return os.Remove(p)
+			}
+			name, err := filepath.Rel(c.dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, cacheFile{name, fi})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Sort(cacheFilesByModTime(files))
+	for _, f := range files {
+		c.entries[f.name] = c.lru.PushFront(&diskCacheEntry{name: f.name, size: f.fi.Size()})
+		c.size += f.fi.Size()
+	}
+	return c.evict()
+}
+
+type cacheFile struct {
+	name string // relative to the cache dir
+	fi   os.FileInfo
+}
+
+type cacheFilesByModTime []cacheFile
+
+func (v cacheFilesByModTime) Len() int      { return len(v) }
+func (v cacheFilesByModTime) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v cacheFilesByModTime) Less(i, j int) bool {
+	return v[i].fi.ModTime().Before(v[j].fi.ModTime())
+}
+
+// Open implements vfs.Opener. It opens the cached copy of the named
+// file, downloading it first if necessary.
+func (c *diskCacheFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	fi, err := c.FileSystem.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	cname := cacheName(name, fi)
+
+	c.mu.Lock()
+	e, present := c.entries[cname]
+	if present {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+
+	if present {
+		f, err := os.Open(filepath.Join(c.dir, cname))
+		if err == nil {
+			diskCacheCounter.hit()
+			vlog.Printf("diskCacheFS: cache hit for %s.", name)
+			// Record the access so the LRU order persists.
+			now := time.Now()
+			os.Chtimes(filepath.Join(c.dir, cname), now, now)
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		// The cache file was removed by someone else; download it
+		// again.
+		c.remove(cname)
+	}
+
+	diskCacheCounter.miss()
+	vlog.Printf("diskCacheFS: cache miss for %s, downloading...", name)
+	if err := c.download(name, cname); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(c.dir, cname))
+}
+
+// download copies the named file from the underlying VFS to the
+// cache file cname and adds it to the cache.
+func (c *diskCacheFS) download(name, cname string) error {
+	dst := filepath.Join(c.dir, cname)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	f, err := c.FileSystem.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".tmp")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tmp, f)
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, present := c.entries[cname]; present {
+		// Downloaded concurrently by another caller.
+		c.lru.MoveToFront(e)
+		return nil
+	}
+	c.entries[cname] = c.lru.PushFront(&diskCacheEntry{name: cname, size: n})
+	c.size += n
+	return c.evict()
+}
+
+// evict removes least recently used cache files until the cache's
+// total size is at most maxSize. The most recently used file is never
+// evicted, even if it alone exceeds maxSize. The caller must hold
+// c.mu.
+func (c *diskCacheFS) evict() error {
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		e := c.lru.Back()
+		ent := e.Value.(*diskCacheEntry)
+		vlog.Printf("diskCacheFS: evicting %s (%d bytes).", ent.name, ent.size)
+		if err := os.Remove(filepath.Join(c.dir, ent.name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.lru.Remove(e)
+		delete(c.entries, ent.name)
+		c.size -= ent.size
+	}
+	return nil
+}
+
+// remove removes the cache file cname from the LRU list.
+func (c *diskCacheFS) remove(cname string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, present := c.entries[cname]; present {
+		c.lru.Remove(e)
+		delete(c.entries, cname)
+		c.size -= e.Value.(*diskCacheEntry).size
+	}
+}
+
+func (c *diskCacheFS) String() string {
+	return "diskCacheFS(" + c.FileSystem.String() + ", " + c.dir + ", max " + strconv.FormatInt(c.maxSize, 10) + " bytes)"
+}
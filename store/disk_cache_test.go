@@ -0,0 +1,81 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// countingOpenFS counts the number of times each file is opened.
+type countingOpenFS struct {
+	rwvfs.FileSystem
+	opens map[string]int
+}
+
+func (fs *countingOpenFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	fs.opens[name]++
+	return fs.FileSystem.Open(name)
+}
+
+func TestDiskCacheFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-disk-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := map[string]string{"a": "aaaa", "b": "bbbb", "d/c": "cccc"}
+	under := &countingOpenFS{FileSystem: rwvfs.Map(m), opens: map[string]int{}}
+	fs, err := NewDiskCacheFS(under, dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(fs rwvfs.FileSystem, name, want string) {
+		data, err := vfs.ReadFile(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", name, data, want)
+		}
+	}
+	checkOpens := func(name string, want int) {
+		if n := under.opens[name]; n != want {
+			t.Errorf("%s: got %d opens of the underlying file, want %d", name, n, want)
+		}
+	}
+
+	read(fs, "a", "aaaa")
+	read(fs, "a", "aaaa")
+	checkOpens("a", 1)
+
+	// Changed files are downloaded again.
+	m["a"] = "aaaaa"
+	read(fs, "a", "aaaaa")
+	checkOpens("a", 2)
+
+	// The cache is persistent.
+	fs, err = NewDiskCacheFS(under, dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(fs, "a", "aaaaa")
+	checkOpens("a", 2)
+
+	// Least recently used files are evicted when the cache exceeds
+	// its max size.
+	read(fs, "d/c", "cccc")
+	read(fs, "b", "bbbb")
+	read(fs, "d/c", "cccc")
+	read(fs, "a", "aaaaa")
+	checkOpens("b", 1)
+	checkOpens("d/c", 1)
+	checkOpens("a", 3)
+	read(fs, "b", "bbbb")
+	checkOpens("b", 2)
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// Types of DumpRecords.
+const (
+	DumpUnit = "unit"
+	DumpDef  = "def"
+	DumpDoc  = "doc"
+	DumpRef  = "ref"
+)
+
+// A DumpRecord is a single item in a dump of a commit's data (see
+// Dump). The field named by Type is set; the others are nil.
+type DumpRecord struct {
+	Type string // DumpUnit, DumpDef, DumpDoc, or DumpRef
+
+	Unit *unit.SourceUnit `json:",omitempty"`
+	Def  *graph.Def       `json:",omitempty"`
+	Doc  *graph.Doc       `json:",omitempty"`
+	Ref  *graph.Ref       `json:",omitempty"`
+}
+
+// Dump calls emit with a record for every source unit, def, doc, and
+// ref of a commit, so that the commit's data can be mirrored without
+// issuing many filtered queries. If s is a MultiRepoStore, repo must
+// be given; otherwise it is ignored.
+//
+// Records are emitted one source unit at a time (in the order of
+// s.Units): first the unit, then its defs (each followed by its docs),
+// then its refs. Docs are emitted as separate records (with their
+// def's key), so the Docs field of emitted defs is always empty. Only
+// one unit's data is held in memory at a time.
+//
+// If emit returns an error, Dump stops and returns it.
+func Dump(s interface{}, repo, commitID string, emit func(*DumpRecord) error) error {
+	return DumpContext(context.Background(), s, repo, commitID, emit)
+}
+
+// DumpContext is like Dump, but it stops and returns ctx.Err() when
+// ctx is done (e.g., because the client that requested the dump went
+// away). Its store queries are also stopped (see WithContext).
+func DumpContext(ctx context.Context, s interface{}, repo, commitID string, emit func(*DumpRecord) error) error {
+	ts, ok := s.(TreeStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement dumping data", s)
+	}
+
+	scope := []interface{}{ByCommitIDs(commitID), WithContext(ctx)}
+	if _, isMulti := s.(MultiRepoStore); isMulti {
+		if repo == "" {
+			return fmt.Errorf("Dump: repo must be given for a multi-repo store")
+		}
+		scope = append(scope, ByRepos(repo))
+	}
+
+	units, err := ts.Units(dumpFilters(scope).unit()...)
+	if err != nil {
+		return err
+	}
+	for _, u := range units {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(&DumpRecord{Type: DumpUnit, Unit: u}); err != nil {
+			return err
+		}
+
+		uscope := dumpFilters(append([]interface{}{ByUnits(u.ID2())}, scope...))
+		defs, err := ts.Defs(uscope.def()...)
+		if err != nil {
+			return err
+		}
+		for _, def := range defs {
+			docs := def.Docs
+			def.Docs = nil
+			if err := emit(&DumpRecord{Type: DumpDef, Def: def}); err != nil {
+				return err
+			}
+			for _, doc := range docs {
+				d := &graph.Doc{DefKey: def.DefKey, Format: doc.Format, Data: doc.Data}
+				if err := emit(&DumpRecord{Type: DumpDoc, Doc: d}); err != nil {
+					return err
+				}
+			}
+		}
+
+		refs, err := ts.Refs(uscope.ref()...)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if err := emit(&DumpRecord{Type: DumpRef, Ref: ref}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dumpFilters are the scoping filters used by Dump. Each of them
+// implements all of the filter interfaces.
+type dumpFilters []interface{}
+
+func (fs dumpFilters) unit() []UnitFilter {
+	ufs := make([]UnitFilter, len(fs))
+	for i, f := range fs {
+		ufs[i] = f.(UnitFilter)
+	}
+	return ufs
+}
+
+func (fs dumpFilters) def() []DefFilter {
+	dfs := make([]DefFilter, len(fs))
+	for i, f := range fs {
+		dfs[i] = f.(DefFilter)
+	}
+	return dfs
+}
+
+func (fs dumpFilters) ref() []RefFilter {
+	rfs := make([]RefFilter, len(fs))
+	for i, f := range fs {
+		rfs[i] = f.(RefFilter)
+	}
+	return rfs
+}
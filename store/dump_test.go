@@ -0,0 +1,86 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestDump(t *testing.T) {
+	useIndexedStore = false
+	rs := NewFSRepoStore(newTestFS())
+	for _, name := range []string{"u1", "u2"} {
+		u := &unit.SourceUnit{Type: "t", Name: name}
+		data := graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f", Docs: []graph.DefDoc{{Format: "text/plain", Data: "d"}}}},
+			Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+		}
+		if err := rs.Import("c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Import("c2", &unit.SourceUnit{Type: "t", Name: "u3"}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := Dump(rs, "", "c", func(rec *DumpRecord) error {
+		switch rec.Type {
+		case DumpUnit:
+			got = append(got, "unit "+rec.Unit.Name)
+		case DumpDef:
+			if len(rec.Def.Docs) != 0 {
+				t.Errorf("got def with docs %v, want docs emitted separately", rec.Def.Docs)
+			}
+			got = append(got, "def "+rec.Def.Unit+" "+rec.Def.Path)
+		case DumpDoc:
+			got = append(got, "doc "+rec.Doc.Unit+" "+rec.Doc.Path+" "+rec.Doc.Data)
+		case DumpRef:
+			got = append(got, "ref "+rec.Ref.Unit+" "+rec.Ref.DefPath)
+		default:
+			t.Errorf("unexpected record type %q", rec.Type)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"unit u1", "def u1 p", "doc u1 p d", "ref u1 p",
+		"unit u2", "def u2 p", "doc u2 p d", "ref u2 p",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %v, want %v", got, want)
+	}
+}
+
+func TestDumpContext(t *testing.T) {
+	useIndexedStore = false
+	rs := NewFSRepoStore(newTestFS())
+	for _, name := range []string{"u1", "u2"} {
+		if err := rs.Import("c", &unit.SourceUnit{Type: "t", Name: name}, graph.Output{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Cancel the dump (as a client that goes away would) after the
+	// first record.
+	ctx, cancel := context.WithCancel(context.Background())
+	var n int
+	err := DumpContext(ctx, rs, "", "c", func(rec *DumpRecord) error {
+		n++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("got err %v, want %v", err, context.Canceled)
+	}
+	if n != 1 {
+		t.Errorf("got %d records, want 1 (before the dump was canceled)", n)
+	}
+}
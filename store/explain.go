@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A QueryPlan records how the store executed a query: which indexes
+// it consulted, which source units it scoped the query to, and which
+// filters it applied by scanning data files instead of using an
+// index. It is produced by Explain and is intended for debugging slow
+// queries; its steps are informational and their format may change.
+type QueryPlan struct {
+	// Steps are the steps that the store performed, in the order in
+	// which they finished.
+	Steps []*PlanStep
+
+	// Elapsed is the total duration of the query.
+	Elapsed time.Duration
+
+	start time.Time
+	mu    sync.Mutex
+}
+
+// A PlanStep is a single step in executing a query.
+type PlanStep struct {
+	// Store is the store that performed the step (e.g.,
+	// "indexedTreeStore" or "fsUnitStore(UNIT)").
+	Store string
+
+	// Stage is the kind of step: "scope" (narrowing the source units
+	// to query), "index" (consulting an index), or "scan" (reading a
+	// data file and applying filters to each item).
+	Stage string
+
+	// Detail describes the step: the index used, the filters that
+	// were pushed down to it or applied by scanning, and how many
+	// candidates remained.
+	Detail string
+
+	// Start is when the step started, relative to the start of the
+	// query, and Elapsed is how long it took.
+	Start, Elapsed time.Duration
+}
+
+var (
+	explainMu sync.Mutex // serializes calls to Explain
+
+	explainPlanMu sync.Mutex
+	explainPlan   *QueryPlan // the plan of the query being explained
+)
+
+// Explain calls f, which should perform a store query, and records the
+// steps that the store takes to execute it. Only one query may be
+// explained at a time; concurrent calls to Explain are serialized.
+func Explain(f func() error) (*QueryPlan, error) {
+	explainMu.Lock()
+	defer explainMu.Unlock()
+	p := &QueryPlan{start: time.Now()}
+	setExplainPlan(p)
+	defer setExplainPlan(nil)
+
+	err := f()
+	p.Elapsed = time.Since(p.start)
+	return p, err
+}
+
+func setExplainPlan(p *QueryPlan) {
+	explainPlanMu.Lock()
+	explainPlan = p
+	explainPlanMu.Unlock()
+}
+
+// explaining returns whether a query is being explained. Callers use
+// it to avoid computing the details of steps when none are recorded.
+func explaining() bool {
+	explainPlanMu.Lock()
+	defer explainPlanMu.Unlock()
+	return explainPlan != nil
+}
+
+// explainStep records a step in the plan of the query that is being
+// explained (if any). The step started at start.
+func explainStep(store interface{}, stage string, start time.Time, format string, args ...interface{}) {
+	explainPlanMu.Lock()
+	p := explainPlan
+	explainPlanMu.Unlock()
+	if p == nil {
+		return
+	}
+	step := &PlanStep{
+		Store:   fmt.Sprint(store),
+		Stage:   stage,
+		Detail:  fmt.Sprintf(format, args...),
+		Start:   start.Sub(p.start),
+		Elapsed: time.Since(start),
+	}
+	p.mu.Lock()
+	p.Steps = append(p.Steps, step)
+	p.mu.Unlock()
+}
+
+// splitCoveredFilters returns the filters that x covers (and that are
+// therefore pushed down to it) and the rest of the filters (which are
+// applied by scanning the items that x returns).
+func splitCoveredFilters(x Index, filters interface{}) (covered, scanned []interface{}) {
+	for _, f := range storeFilters(filters) {
+		if x.Covers([]interface{}{f}) > 0 {
+			covered = append(covered, f)
+		} else {
+			scanned = append(scanned, f)
+		}
+	}
+	return covered, scanned
+}
+
+// WriteTo writes a human-readable description of the plan to w.
+func (p *QueryPlan) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Query plan (%d steps, %s total):\n", len(p.Steps), p.Elapsed)
+	for _, step := range p.Steps {
+		fmt.Fprintf(&b, "#  %10s %10s  %-6s %s: %s\n", "+"+step.Start.String(), step.Elapsed, step.Stage, step.Store, step.Detail)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestExplain(t *testing.T) {
+	useIndexedStore = true
+	mrs := NewFSMultiRepoStore(newTestFS(), nil)
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"},
+			{DefKey: graph.DefKey{Path: "q"}, Name: "q", File: "f"},
+		},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+	}
+	if err := mrs.Import("r", "c", u, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mrs.(MultiRepoIndexer).Index("r", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	var defs []*graph.Def
+	plan, err := Explain(func() (err error) {
+		defs, err = mrs.Defs(ByRepos("r"), ByCommitIDs("c"), ByUnits(u.ID2()), ByDefPath("p"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 {
+		t.Errorf("got %d defs, want 1", len(defs))
+	}
+	var stages []string
+	var usedIndex bool
+	for _, step := range plan.Steps {
+		stages = append(stages, step.Stage)
+		if step.Stage == "index" && strings.Contains(step.Detail, `"path_to_def"`) {
+			usedIndex = true
+		}
+	}
+	if !usedIndex {
+		t.Errorf("got plan stages %v, want an index step using path_to_def", stages)
+	}
+
+	var buf bytes.Buffer
+	if _, err := plan.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "path_to_def") {
+		t.Errorf("plan text does not mention path_to_def:\n%s", buf.String())
+	}
+
+	// Queries that aren't being explained aren't recorded.
+	if _, err := mrs.Defs(ByDefPath("p")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(plan.Steps); n != len(stages) {
+		t.Errorf("got %d steps after Explain returned, want %d", n, len(stages))
+	}
+}
@@ -0,0 +1,316 @@
+package store
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// FailoverConfig configures a FailoverFS.
+type FailoverConfig struct {
+	// HealthCheckInterval is how long a root that failed is skipped
+	// (while other roots are healthy) before it is tried again. If
+	// zero, 30 seconds is used.
+	HealthCheckInterval time.Duration
+}
+
+// FailoverFS returns a VFS that reads from primary while it is
+// healthy, and fails over to the replicas (in order) when it isn't.
+// It is used to serve queries from a store that is replicated to
+// other roots (e.g., by S3 cross-region replication), so that queries
+// keep working when the primary root is unavailable.
+//
+// A root becomes unhealthy when a read from it (Open, Stat, Lstat,
+// ReadDir, or a read or fetch of an opened file) fails with an error
+// other than "not exist", and the read is retried on the next healthy
+// root. Files that were opened on the failed root are reopened on the
+// next root at the same offset. An unhealthy root is skipped for
+// conf.HealthCheckInterval, after which the next read is tried on it
+// again (as a health check); if that read succeeds, the root is
+// healthy again. If all roots are unhealthy, they are all tried in
+// order.
+//
+// Writes (Create, Mkdir, and Remove) always go to primary, because
+// replicas are expected to be updated from the primary.
+//
+// If primary implements rwvfs.FetcherOpener, so does the returned
+// VFS.
+func FailoverFS(primary rwvfs.FileSystem, replicas []rwvfs.FileSystem, conf FailoverConfig) rwvfs.FileSystem {
+	if conf.HealthCheckInterval == 0 {
+		conf.HealthCheckInterval = 30 * time.Second
+	}
+	fs := &failoverFS{
+		roots:    make([]*failoverRoot, 0, 1+len(replicas)),
+		interval: conf.HealthCheckInterval,
+	}
+	for _, r := range append([]rwvfs.FileSystem{primary}, replicas...) {
+		fs.roots = append(fs.roots, &failoverRoot{fs: r})
+	}
+	if _, ok := primary.(rwvfs.FetcherOpener); ok {
+		return &failoverFetcherFS{fs}
+	}
+	return fs
+}
+
+// timeNow is time.Now (overridden in tests).
+var timeNow = time.Now
+
+type failoverRoot struct {
+	fs rwvfs.FileSystem
+
+	// unhealthyUntil is when the root is next tried after a failure
+	// (zero if the root is healthy). It is guarded by the
+	// failoverFS's mu.
+	unhealthyUntil time.Time
+}
+
+type failoverFS struct {
+	roots    []*failoverRoot // primary, then replicas
+	interval time.Duration
+
+	mu sync.Mutex
+}
+
+// order returns the indexes of the roots in the order in which they
+// should be tried: the healthy roots (including those that are due
+// for a health check), and then the unhealthy roots.
+func (fs *failoverFS) order() []int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	now := timeNow()
+	healthy := make([]int, 0, len(fs.roots))
+	var unhealthy []int
+	for i, r := range fs.roots {
+		if now.Before(r.unhealthyUntil) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (fs *failoverFS) markHealthy(i int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	r := fs.roots[i]
+	if !r.unhealthyUntil.IsZero() {
+		log.Printf("Store root %s is healthy again.", r.fs)
+		r.unhealthyUntil = time.Time{}
+	}
+}
+
+func (fs *failoverFS) markUnhealthy(i int, op, name string, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	r := fs.roots[i]
+	if r.unhealthyUntil.IsZero() {
+		log.Printf("Warning: Store root %s is unhealthy (%s %s failed: %s); failing over to its replicas for %s.", r.fs, op, name, err, fs.interval)
+	}
+	r.unhealthyUntil = timeNow().Add(fs.interval)
+}
+
+// isFailoverError returns whether err indicates that a root is
+// unhealthy (and that the operation should be retried on another
+// root). A file that doesn't exist is not a failure of the root.
+func isFailoverError(err error) bool {
+	return err != nil && err != io.EOF && !isOSOrVFSNotExist(err)
+}
+
+// do calls f with the fs of each root (in order) until it succeeds or
+// returns an error that is not a failover error, and returns the
+// index of the last root that f was called with.
+func (fs *failoverFS) do(op, name string, f func(rwvfs.FileSystem) error) (int, error) {
+	var (
+		i   int
+		err error
+	)
+	for _, i = range fs.order() {
+		err = f(fs.roots[i].fs)
+		if !isFailoverError(err) {
+			fs.markHealthy(i)
+			return i, err
+		}
+		fs.markUnhealthy(i, op, name, err)
+		vlog.Printf("%s %s failed on store root %s, failing over: %s", op, name, fs.roots[i].fs, err)
+	}
+	return i, err
+}
+
+func (fs *failoverFS) open(name string, open func(rwvfs.FileSystem, string) (vfs.ReadSeekCloser, error)) (*failoverFile, error) {
+	var f vfs.ReadSeekCloser
+	i, err := fs.do("open", name, func(root rwvfs.FileSystem) (err error) {
+		f, err = open(root, name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &failoverFile{fs: fs, name: name, open: open, root: i, f: f}, nil
+}
+
+func (fs *failoverFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	f, err := fs.open(name, func(root rwvfs.FileSystem, name string) (vfs.ReadSeekCloser, error) {
+		return root.Open(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *failoverFS) Stat(name string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	_, err := fs.do("stat", name, func(root rwvfs.FileSystem) (err error) {
+		fi, err = root.Stat(name)
+		return
+	})
+	return fi, err
+}
+
+func (fs *failoverFS) Lstat(name string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	_, err := fs.do("lstat", name, func(root rwvfs.FileSystem) (err error) {
+		fi, err = root.Lstat(name)
+		return
+	})
+	return fi, err
+}
+
+func (fs *failoverFS) ReadDir(name string) ([]os.FileInfo, error) {
+	var fis []os.FileInfo
+	_, err := fs.do("readdir", name, func(root rwvfs.FileSystem) (err error) {
+		fis, err = root.ReadDir(name)
+		return
+	})
+	return fis, err
+}
+
+func (fs *failoverFS) Create(name string) (io.WriteCloser, error) {
+	return fs.roots[0].fs.Create(name)
+}
+
+func (fs *failoverFS) Mkdir(name string) error { return fs.roots[0].fs.Mkdir(name) }
+
+func (fs *failoverFS) Remove(name string) error { return fs.roots[0].fs.Remove(name) }
+
+func (fs *failoverFS) String() string {
+	roots := make([]string, len(fs.roots))
+	for i, r := range fs.roots {
+		roots[i] = r.fs.String()
+	}
+	return "failover(" + strings.Join(roots, ", ") + ")"
+}
+
+type failoverFetcherFS struct{ *failoverFS }
+
+// OpenFetcher implements rwvfs.FetcherOpener. On replicas that don't
+// implement rwvfs.FetcherOpener, the file is opened with Open (and
+// fetches are no-ops).
+func (fs *failoverFetcherFS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
+	f, err := fs.open(name, func(root rwvfs.FileSystem, name string) (vfs.ReadSeekCloser, error) {
+		if fo, ok := root.(rwvfs.FetcherOpener); ok {
+			return fo.OpenFetcher(name)
+		}
+		return root.Open(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &failoverFetcherFile{f}, nil
+}
+
+// A failoverFile is a file opened by a failoverFS. If a read from it
+// fails, it is reopened on the next healthy root.
+type failoverFile struct {
+	fs   *failoverFS
+	name string
+	open func(rwvfs.FileSystem, string) (vfs.ReadSeekCloser, error)
+
+	root      int                // index of the root that f was opened on
+	f         vfs.ReadSeekCloser // the file opened on the root
+	off       int64              // current offset
+	failovers int                // number of times the file was reopened
+}
+
+// reopen marks the file's current root as unhealthy (because of
+// cause) and reopens the file at its current offset on the next root
+// that it can be opened on. If there is no such root, cause is
+// returned.
+func (f *failoverFile) reopen(op string, cause error) error {
+	f.fs.markUnhealthy(f.root, op, f.name, cause)
+	if f.failovers >= len(f.fs.roots)-1 {
+		return cause
+	}
+	for _, i := range f.fs.order() {
+		if i == f.root {
+			continue
+		}
+		nf, err := f.open(f.fs.roots[i].fs, f.name)
+		if err == nil {
+			if _, err = nf.Seek(f.off, 0); err != nil {
+				nf.Close()
+			}
+		}
+		if err != nil {
+			f.fs.markUnhealthy(i, "open", f.name, err)
+			continue
+		}
+		vlog.Printf("%s %s failed on store root %s, reopened on %s: %s", op, f.name, f.fs.roots[f.root].fs, f.fs.roots[i].fs, cause)
+		f.f.Close()
+		f.root, f.f = i, nf
+		f.failovers++
+		return nil
+	}
+	return cause
+}
+
+func (f *failoverFile) Read(p []byte) (int, error) {
+	for {
+		n, err := f.f.Read(p)
+		f.off += int64(n)
+		if n > 0 || !isFailoverError(err) {
+			return n, err
+		}
+		if err := f.reopen("read", err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (f *failoverFile) Seek(offset int64, whence int) (int64, error) {
+	off, err := f.f.Seek(offset, whence)
+	if err != nil {
+		return off, err
+	}
+	f.off = off
+	return off, nil
+}
+
+func (f *failoverFile) Close() error { return f.f.Close() }
+
+type failoverFetcherFile struct{ *failoverFile }
+
+// Fetch implements rwvfs.Fetcher.
+func (f *failoverFetcherFile) Fetch(start, end int64) error {
+	for {
+		fr, ok := f.f.(rwvfs.Fetcher)
+		if !ok {
+			return nil
+		}
+		err := fr.Fetch(start, end)
+		if !isFailoverError(err) {
+			return err
+		}
+		if err := f.reopen("fetch", err); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// downFS is a VFS whose reads fail while it is down. Reads of opened
+// files fail after readOK bytes while it is down.
+type downFS struct {
+	rwvfs.FileSystem
+
+	mu     sync.Mutex
+	down   bool
+	readOK int64
+	reads  int // number of Open and Stat calls
+}
+
+var errDown = errors.New("read tcp 1.2.3.4:443: connection reset by peer")
+
+func (fs *downFS) isDown() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.down
+}
+
+func (fs *downFS) setDown(down bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.down = down
+}
+
+func (fs *downFS) call() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.reads++
+	if fs.down {
+		return errDown
+	}
+	return nil
+}
+
+func (fs *downFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	if err := fs.call(); err != nil {
+		return nil, err
+	}
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &downFile{f, fs, 0}, nil
+}
+
+func (fs *downFS) Stat(name string) (os.FileInfo, error) {
+	if err := fs.call(); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Stat(name)
+}
+
+type downFile struct {
+	vfs.ReadSeekCloser
+	fs  *downFS
+	off int64
+}
+
+func (f *downFile) Read(p []byte) (int, error) {
+	if f.fs.isDown() {
+		if f.off >= f.fs.readOK {
+			return 0, errDown
+		}
+		if max := f.fs.readOK - f.off; int64(len(p)) > max {
+			p = p[:max]
+		}
+	}
+	n, err := f.ReadSeekCloser.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+func TestFailoverFS(t *testing.T) {
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	now := time.Unix(0, 0)
+	timeNow = func() time.Time { return now }
+
+	files := map[string]string{"f": "0123456789", "g": "abc"}
+	primary := &downFS{FileSystem: rwvfs.Map(map[string]string{"f": files["f"]})}
+	replica := &downFS{FileSystem: rwvfs.Map(files)}
+	fs := FailoverFS(primary, []rwvfs.FileSystem{replica}, FailoverConfig{HealthCheckInterval: time.Minute})
+
+	readFile := func(name string) string {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%s): %s", name, err)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatalf("ReadAll(%s): %s", name, err)
+		}
+		return string(data)
+	}
+
+	// A file that doesn't exist on the (healthy) primary is not read
+	// from a replica.
+	if _, err := fs.Stat("g"); !os.IsNotExist(err) {
+		t.Errorf("Stat(g): got err %v, want not exist", err)
+	}
+	if replica.reads != 0 {
+		t.Errorf("got %d reads from the replica, want 0", replica.reads)
+	}
+
+	// Reads fail over to the replica when the primary is down, and
+	// the primary is skipped until the health check interval passes.
+	primary.setDown(true)
+	if got := readFile("f"); got != files["f"] {
+		t.Errorf("got %q, want %q", got, files["f"])
+	}
+	primaryReads := primary.reads
+	if _, err := fs.Stat("f"); err != nil {
+		t.Fatal(err)
+	}
+	if primary.reads != primaryReads {
+		t.Errorf("unhealthy primary was read from before the health check interval passed")
+	}
+
+	// The primary is tried again after the interval, and used once it
+	// is up.
+	primary.setDown(false)
+	now = now.Add(2 * time.Minute)
+	replicaReads := replica.reads
+	if got := readFile("f"); got != files["f"] {
+		t.Errorf("got %q, want %q", got, files["f"])
+	}
+	if replica.reads != replicaReads {
+		t.Errorf("got %d reads from the replica after the primary recovered, want 0", replica.reads-replicaReads)
+	}
+
+	// A file whose reads fail partway through is reopened on the
+	// replica at the same offset.
+	f, err := fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.mu.Lock()
+	primary.down, primary.readOK = true, 4
+	primary.mu.Unlock()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != files["f"] {
+		t.Errorf("got %q after failing over while reading, want %q", data, files["f"])
+	}
+	f.Close()
+
+	// If all roots are down, the error is returned.
+	replica.setDown(true)
+	if _, err := fs.Stat("f"); err != errDown {
+		t.Errorf("got err %v, want %v", err, errDown)
+	}
+
+	// Writes go to the primary.
+	if err := fs.Mkdir("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.FileSystem.Stat("d"); err != nil {
+		t.Errorf("Mkdir did not create the dir on the primary: %s", err)
+	}
+}
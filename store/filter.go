@@ -10,6 +10,7 @@ import (
 
 	"sort"
 
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -158,6 +159,9 @@ func ByUnits(units ...unit.ID2) interface {
 	DefFilter
 	RefFilter
 	UnitFilter
+	DepFilter
+	CallFilter
+	RelationFilter
 	ByUnitsFilter
 } {
 	for _, u := range units {
@@ -193,6 +197,15 @@ func (f byUnitsFilter) SelectRef(ref *graph.Ref) bool {
 func (f byUnitsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Type == "" && unit.Name == "") || f.contains(unit.ID2())
 }
+func (f byUnitsFilter) SelectDep(d *dep.ResolvedDep) bool {
+	return (d.FromUnit == "" && d.FromUnitType == "") || f.contains(unit.ID2{Type: d.FromUnitType, Name: d.FromUnit})
+}
+func (f byUnitsFilter) SelectCall(call *graph.Call) bool {
+	return (call.Unit == "" && call.UnitType == "") || f.contains(unit.ID2{Type: call.UnitType, Name: call.Unit})
+}
+func (f byUnitsFilter) SelectRelation(r *graph.Relation) bool {
+	return (r.Unit == "" && r.UnitType == "") || f.contains(unit.ID2{Type: r.UnitType, Name: r.Unit})
+}
 
 // ByCommitIDsFilter is implemented by filters that restrict their
 // selection to items at specific commit IDs. It allows the store to
@@ -208,6 +221,9 @@ func ByCommitIDs(commitIDs ...string) interface {
 	DefFilter
 	RefFilter
 	UnitFilter
+	DepFilter
+	CallFilter
+	RelationFilter
 	VersionFilter
 	ByCommitIDsFilter
 } {
@@ -240,6 +256,15 @@ func (f byCommitIDsFilter) SelectRef(ref *graph.Ref) bool {
 func (f byCommitIDsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return unit.CommitID == "" || f.contains(unit.CommitID)
 }
+func (f byCommitIDsFilter) SelectDep(d *dep.ResolvedDep) bool {
+	return d.FromCommitID == "" || f.contains(d.FromCommitID)
+}
+func (f byCommitIDsFilter) SelectCall(call *graph.Call) bool {
+	return call.CommitID == "" || f.contains(call.CommitID)
+}
+func (f byCommitIDsFilter) SelectRelation(r *graph.Relation) bool {
+	return r.CommitID == "" || f.contains(r.CommitID)
+}
 func (f byCommitIDsFilter) SelectVersion(version *Version) bool {
 	return version.CommitID == "" || f.contains(version.CommitID)
 }
@@ -258,6 +283,9 @@ func ByRepos(repos ...string) interface {
 	DefFilter
 	RefFilter
 	UnitFilter
+	DepFilter
+	CallFilter
+	RelationFilter
 	VersionFilter
 	RepoFilter
 	ByReposFilter
@@ -291,6 +319,15 @@ func (f byReposFilter) SelectRef(ref *graph.Ref) bool {
 func (f byReposFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return unit.Repo == "" || f.contains(unit.Repo)
 }
+func (f byReposFilter) SelectDep(d *dep.ResolvedDep) bool {
+	return d.FromRepo == "" || f.contains(d.FromRepo)
+}
+func (f byReposFilter) SelectCall(call *graph.Call) bool {
+	return call.Repo == "" || f.contains(call.Repo)
+}
+func (f byReposFilter) SelectRelation(r *graph.Relation) bool {
+	return r.Repo == "" || f.contains(r.Repo)
+}
 func (f byReposFilter) SelectVersion(version *Version) bool {
 	return version.Repo == "" || f.contains(version.Repo)
 }
@@ -313,6 +350,9 @@ func ByRepoCommitIDs(versions ...Version) interface {
 	DefFilter
 	RefFilter
 	UnitFilter
+	DepFilter
+	CallFilter
+	RelationFilter
 	VersionFilter
 	RepoFilter
 	ByReposFilter
@@ -359,6 +399,15 @@ func (f byRepoCommitIDsFilter) SelectRef(ref *graph.Ref) bool {
 func (f byRepoCommitIDsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Repo == "" && unit.CommitID == "") || f.contains(unit.Repo, unit.CommitID)
 }
+func (f byRepoCommitIDsFilter) SelectDep(d *dep.ResolvedDep) bool {
+	return (d.FromRepo == "" && d.FromCommitID == "") || f.contains(d.FromRepo, d.FromCommitID)
+}
+func (f byRepoCommitIDsFilter) SelectCall(call *graph.Call) bool {
+	return (call.Repo == "" && call.CommitID == "") || f.contains(call.Repo, call.CommitID)
+}
+func (f byRepoCommitIDsFilter) SelectRelation(r *graph.Relation) bool {
+	return (r.Repo == "" && r.CommitID == "") || f.contains(r.Repo, r.CommitID)
+}
 func (f byRepoCommitIDsFilter) SelectVersion(version *Version) bool {
 	return (version.Repo == "" && version.CommitID == "") || f.contains(version.Repo, version.CommitID)
 }
@@ -378,6 +427,9 @@ func ByUnitKey(key unit.Key) interface {
 	DefFilter
 	RefFilter
 	UnitFilter
+	DepFilter
+	CallFilter
+	RelationFilter
 	ByReposFilter
 	ByCommitIDsFilter
 	ByUnitsFilter
@@ -415,6 +467,18 @@ func (f byUnitKeyFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Repo == "" || unit.Repo == f.key.Repo) && (unit.CommitID == "" || unit.CommitID == f.key.CommitID) &&
 		(unit.Type == "" || unit.Type == f.key.UnitType) && (unit.Name == "" || unit.Name == f.key.Unit)
 }
+func (f byUnitKeyFilter) SelectDep(d *dep.ResolvedDep) bool {
+	return (d.FromRepo == "" || d.FromRepo == f.key.Repo) && (d.FromCommitID == "" || d.FromCommitID == f.key.CommitID) &&
+		(d.FromUnitType == "" || d.FromUnitType == f.key.UnitType) && (d.FromUnit == "" || d.FromUnit == f.key.Unit)
+}
+func (f byUnitKeyFilter) SelectCall(call *graph.Call) bool {
+	return (call.Repo == "" || call.Repo == f.key.Repo) && (call.CommitID == "" || call.CommitID == f.key.CommitID) &&
+		(call.UnitType == "" || call.UnitType == f.key.UnitType) && (call.Unit == "" || call.Unit == f.key.Unit)
+}
+func (f byUnitKeyFilter) SelectRelation(r *graph.Relation) bool {
+	return (r.Repo == "" || r.Repo == f.key.Repo) && (r.CommitID == "" || r.CommitID == f.key.CommitID) &&
+		(r.UnitType == "" || r.UnitType == f.key.UnitType) && (r.Unit == "" || r.Unit == f.key.Unit)
+}
 
 // ByDefKey returns a filter by a def key. It panics if the def path
 // is not set. If you pass a ByDefKey filter to a store that's scoped
@@ -593,24 +657,24 @@ type impliedUnitSetter interface {
 	setImpliedUnit(unit.ID2)
 }
 
-func setImpliedRepo(fs []RefFilter, repo string) {
-	for _, f := range fs {
+func setImpliedRepo(filters interface{}, repo string) {
+	for _, f := range storeFilters(filters) {
 		if f, ok := f.(impliedRepoSetter); ok {
 			f.setImpliedRepo(repo)
 		}
 	}
 }
 
-func setImpliedCommitID(fs []RefFilter, commitID string) {
-	for _, f := range fs {
+func setImpliedCommitID(filters interface{}, commitID string) {
+	for _, f := range storeFilters(filters) {
 		if f, ok := f.(impliedCommitIDSetter); ok {
 			f.setImpliedCommitID(commitID)
 		}
 	}
 }
 
-func setImpliedUnit(fs []RefFilter, u unit.ID2) {
-	for _, f := range fs {
+func setImpliedUnit(filters interface{}, u unit.ID2) {
+	for _, f := range storeFilters(filters) {
 		if f, ok := f.(impliedUnitSetter); ok {
 			f.setImpliedUnit(u)
 		}
@@ -669,6 +733,174 @@ func (f byDefQueryFilter) SelectDef(def *graph.Def) bool {
 	return strings.HasPrefix(strings.ToLower(def.Name), strings.ToLower(string(f)))
 }
 
+// ByDefNameRangeFilter is implemented by filters that restrict their
+// selection to defs whose normalized names (see NormalizeDefName) are
+// in a lexicographic (byte-wise, case-sensitive) range.
+type ByDefNameRangeFilter interface {
+	// ByDefNameRange returns the range [start, end) of normalized
+	// def names. If end is empty, the range is unbounded above.
+	ByDefNameRange() (start, end string)
+}
+
+// ByDefFoldedNameRangeFilter is implemented by filters that restrict
+// their selection to defs whose case-folded names (see FoldDefName)
+// are in a lexicographic range.
+type ByDefFoldedNameRangeFilter interface {
+	// ByDefFoldedNameRange returns the range [start, end) of
+	// case-folded def names. If end is empty, the range is unbounded
+	// above.
+	ByDefFoldedNameRange() (start, end string)
+}
+
+// ByDefNamePrefix returns a filter that selects defs whose names
+// begin with prefix (case-sensitive). Names and the prefix are
+// compared in Unicode normalization form C. It panics if prefix is
+// empty.
+func ByDefNamePrefix(prefix string) interface {
+	DefFilter
+	ByDefNameRangeFilter
+} {
+	if prefix == "" {
+		panic("ByDefNamePrefix: empty")
+	}
+	return byDefNamePrefixFilter(NormalizeDefName(prefix))
+}
+
+type byDefNamePrefixFilter string
+
+func (f byDefNamePrefixFilter) String() string { return fmt.Sprintf("ByDefNamePrefix(%q)", string(f)) }
+func (f byDefNamePrefixFilter) ByDefNameRange() (start, end string) {
+	return string(f), prefixSuccessor(string(f))
+}
+func (f byDefNamePrefixFilter) SelectDef(def *graph.Def) bool {
+	return strings.HasPrefix(NormalizeDefName(def.Name), string(f))
+}
+
+// ByDefNamePrefixIgnoreCase is like ByDefNamePrefix, but it ignores
+// case (see FoldDefName).
+func ByDefNamePrefixIgnoreCase(prefix string) interface {
+	DefFilter
+	ByDefFoldedNameRangeFilter
+} {
+	if prefix == "" {
+		panic("ByDefNamePrefixIgnoreCase: empty")
+	}
+	return byDefFoldedNamePrefixFilter(FoldDefName(prefix))
+}
+
+type byDefFoldedNamePrefixFilter string
+
+func (f byDefFoldedNamePrefixFilter) String() string {
+	return fmt.Sprintf("ByDefNamePrefixIgnoreCase(%q)", string(f))
+}
+func (f byDefFoldedNamePrefixFilter) ByDefFoldedNameRange() (start, end string) {
+	return string(f), prefixSuccessor(string(f))
+}
+func (f byDefFoldedNamePrefixFilter) SelectDef(def *graph.Def) bool {
+	return strings.HasPrefix(FoldDefName(def.Name), string(f))
+}
+
+// prefixSuccessor returns the smallest string that is greater than
+// all strings with the given prefix, or the empty string if there is
+// no such string (i.e., if prefix consists only of 0xFF bytes).
+func prefixSuccessor(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xFF {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// ByDefNameRange returns a filter that selects defs whose names are
+// in the lexicographic (byte-wise, case-sensitive) range [start,
+// end). Names and the range are compared in Unicode normalization
+// form C. If end is empty, the range is unbounded above. It panics if
+// both are empty.
+func ByDefNameRange(start, end string) interface {
+	DefFilter
+	ByDefNameRangeFilter
+} {
+	if start == "" && end == "" {
+		panic("ByDefNameRange: empty")
+	}
+	return byDefNameRangeFilter{NormalizeDefName(start), NormalizeDefName(end)}
+}
+
+type byDefNameRangeFilter struct{ start, end string }
+
+func (f byDefNameRangeFilter) String() string {
+	return fmt.Sprintf("ByDefNameRange(%q, %q)", f.start, f.end)
+}
+func (f byDefNameRangeFilter) ByDefNameRange() (start, end string) { return f.start, f.end }
+func (f byDefNameRangeFilter) SelectDef(def *graph.Def) bool {
+	return inNameRange(NormalizeDefName(def.Name), f.start, f.end)
+}
+
+// ByDefNameRangeIgnoreCase is like ByDefNameRange, but it selects
+// defs whose case-folded names (see FoldDefName) are in the range of
+// the case-folded start and end.
+func ByDefNameRangeIgnoreCase(start, end string) interface {
+	DefFilter
+	ByDefFoldedNameRangeFilter
+} {
+	if start == "" && end == "" {
+		panic("ByDefNameRangeIgnoreCase: empty")
+	}
+	return byDefFoldedNameRangeFilter{FoldDefName(start), FoldDefName(end)}
+}
+
+type byDefFoldedNameRangeFilter struct{ start, end string }
+
+func (f byDefFoldedNameRangeFilter) String() string {
+	return fmt.Sprintf("ByDefNameRangeIgnoreCase(%q, %q)", f.start, f.end)
+}
+func (f byDefFoldedNameRangeFilter) ByDefFoldedNameRange() (start, end string) {
+	return f.start, f.end
+}
+func (f byDefFoldedNameRangeFilter) SelectDef(def *graph.Def) bool {
+	return inNameRange(FoldDefName(def.Name), f.start, f.end)
+}
+
+func inNameRange(name, start, end string) bool {
+	return name >= start && (end == "" || name < end)
+}
+
+// ByRefRangeFilter is implemented by filters that restrict their
+// selection to refs that are within a byte range of their file.
+type ByRefRangeFilter interface {
+	// ByRefRange returns the byte range. Refs are selected if
+	// ref.Start >= start and ref.End <= end. If end is 0, the range
+	// is unbounded above.
+	ByRefRange() (start, end uint32)
+}
+
+// ByRefRange returns a filter that selects refs that are within the
+// byte range (i.e., ref.Start >= start and ref.End <= end). If end is
+// 0, the range is unbounded above. It is typically used along with
+// ByFiles (a byte range is only meaningful within a single file), in
+// which case an index can be used to satisfy the query. It panics if
+// start and end are both 0.
+func ByRefRange(start, end uint32) interface {
+	RefFilter
+	ByRefRangeFilter
+} {
+	if start == 0 && end == 0 {
+		panic("ByRefRange: empty")
+	}
+	return byRefRangeFilter{start, end}
+}
+
+type byRefRangeFilter struct{ start, end uint32 }
+
+func (f byRefRangeFilter) String() string                  { return fmt.Sprintf("ByRefRange(%d, %d)", f.start, f.end) }
+func (f byRefRangeFilter) ByRefRange() (start, end uint32) { return f.start, f.end }
+func (f byRefRangeFilter) SelectRef(ref *graph.Ref) bool {
+	return ref.Start >= f.start && (f.end == 0 || ref.End <= f.end)
+}
+
 // ByFilesFilter is implemented by filters that restrict their
 // selection to defs, refs, etc., that exist in any file in a set, or
 // source units that contain any of the files in the set.
@@ -728,6 +960,64 @@ func (f byFilesFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return false
 }
 
+// ByLanguagesFilter is implemented by filters that restrict their
+// selection to source units written in any of a set of languages (and
+// the defs, refs, etc., in those source units).
+type ByLanguagesFilter interface {
+	ByLanguages() []string
+}
+
+// ByLanguages returns a filter that selects source units whose
+// Language is any of the listed languages, and the defs and refs in
+// those source units. It panics if any language is empty.
+//
+// Defs and refs do not record their language, so the stores apply
+// this filter to them by scoping queries to the matching source units
+// (and SelectDef and SelectRef always return true).
+func ByLanguages(langs ...string) interface {
+	DefFilter
+	RefFilter
+	UnitFilter
+	ByLanguagesFilter
+} {
+	for _, lang := range langs {
+		if lang == "" {
+			panic("lang: empty")
+		}
+	}
+	return byLanguagesFilter(langs)
+}
+
+type byLanguagesFilter []string
+
+func (f byLanguagesFilter) String() string                { return fmt.Sprintf("ByLanguages(%v)", ([]string)(f)) }
+func (f byLanguagesFilter) ByLanguages() []string         { return f }
+func (f byLanguagesFilter) SelectDef(def *graph.Def) bool { return true }
+func (f byLanguagesFilter) SelectRef(ref *graph.Ref) bool { return true }
+func (f byLanguagesFilter) SelectUnit(unit *unit.SourceUnit) bool {
+	for _, lang := range f {
+		if unit.Language == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludeGenerated returns a filter that excludes defs and refs in
+// generated code (those whose Generated field is set; see TagGenerated).
+func ExcludeGenerated() interface {
+	DefFilter
+	RefFilter
+} {
+	return excludeGeneratedFilter{}
+}
+
+type excludeGeneratedFilter struct{}
+
+func (excludeGeneratedFilter) String() string                { return "ExcludeGenerated" }
+func (excludeGeneratedFilter) SelectDef(def *graph.Def) bool { return !def.Generated }
+func (excludeGeneratedFilter) SelectRef(ref *graph.Ref) bool { return !ref.Generated }
+
 // Limit is an EXPERIMENTAL filter for limiting the number of
 // results. It is not correct because it assumes that if it is called
 // on an object, it gets to decide whether that object appears in the
@@ -0,0 +1,921 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A FilterExpr is a filter that selects the defs, refs, or source
+// units for which a boolean expression is true, so that queries can
+// filter on any field without a dedicated filter for each. See
+// ParseFilterExpr for the expression syntax.
+//
+// Records for which the expression can't be evaluated (e.g., because
+// it compares a string to a number) are not selected.
+type FilterExpr struct {
+	kind string // "def", "ref", or "unit"
+	src  string
+	root exprNode
+
+	impliedRepo     string
+	impliedCommitID string
+	impliedUnit     unit.ID2
+}
+
+// filterExprTypes are the types of the records that filter
+// expressions can select, keyed by the name of the variable that
+// refers to the record in the expression.
+var filterExprTypes = map[string]reflect.Type{
+	"def":  reflect.TypeOf(graph.Def{}),
+	"ref":  reflect.TypeOf(graph.Ref{}),
+	"unit": reflect.TypeOf(unit.SourceUnit{}),
+}
+
+// ParseFilterExpr parses an expression that selects records of the
+// given kind ("def", "ref", or "unit"), such as:
+//
+//	def.Kind == "func" && def.Exported && size(def.Name) > 20
+//
+// Expressions are a subset of CEL (https://github.com/google/cel-spec).
+// The record is the variable named by kind, and its fields are those
+// of its JSON representation (so def.Data.X is the field X of the
+// toolchain-specific data of a def). Fields that are omitted from the
+// JSON (because they are empty) are null, and null is equal to the
+// zero value of the other operand (false, 0, "", or an empty list or
+// map) in comparisons and is false in boolean operations.
+//
+// The operators are, from lowest to highest precedence: ||; &&; the
+// comparisons ==, !=, <, <=, >, >=, and in (list membership or map
+// key presence); + and - (+ also concatenates strings and lists); *,
+// /, and %; and the unary ! and -. Literals are numbers, strings
+// (single- or double-quoted), true, false, null, and lists ([a, b]).
+//
+// The functions are size(x) (the length of a string, list, or map)
+// and has(x.f) (whether the field f is present), and the string
+// methods s.contains(t), s.startsWith(t), s.endsWith(t), and
+// s.matches(re) (whether s contains a match of the regexp re).
+func ParseFilterExpr(kind, src string) (*FilterExpr, error) {
+	typ, ok := filterExprTypes[kind]
+	if !ok {
+		return nil, fmt.Errorf("filter expressions can't select %ss", kind)
+	}
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks, kind: kind, fields: jsonFieldNames(typ)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d in filter expression", t.text, t.pos)
+	}
+	return &FilterExpr{kind: kind, src: src, root: root}, nil
+}
+
+func (e *FilterExpr) String() string { return fmt.Sprintf("FilterExpr(%s: %q)", e.kind, e.src) }
+
+func (e *FilterExpr) SelectDef(def *graph.Def) bool {
+	if e.kind != "def" {
+		return true
+	}
+	copy := *def
+	if copy.Repo == "" {
+		copy.Repo = e.impliedRepo
+	}
+	if copy.CommitID == "" {
+		copy.CommitID = e.impliedCommitID
+	}
+	if copy.UnitType == "" && copy.Unit == "" {
+		copy.UnitType, copy.Unit = e.impliedUnit.Type, e.impliedUnit.Name
+	}
+	return e.selectRecord(&copy)
+}
+
+func (e *FilterExpr) SelectRef(ref *graph.Ref) bool {
+	if e.kind != "ref" {
+		return true
+	}
+	copy := *ref
+	if copy.Repo == "" {
+		copy.Repo = e.impliedRepo
+	}
+	if copy.CommitID == "" {
+		copy.CommitID = e.impliedCommitID
+	}
+	if copy.UnitType == "" && copy.Unit == "" {
+		copy.UnitType, copy.Unit = e.impliedUnit.Type, e.impliedUnit.Name
+	}
+	if copy.DefRepo == "" {
+		copy.DefRepo = copy.Repo
+	}
+	if copy.DefUnitType == "" && copy.DefUnit == "" {
+		copy.DefUnitType, copy.DefUnit = copy.UnitType, copy.Unit
+	}
+	return e.selectRecord(&copy)
+}
+
+func (e *FilterExpr) SelectUnit(u *unit.SourceUnit) bool {
+	if e.kind != "unit" {
+		return true
+	}
+	return e.selectRecord(u)
+}
+
+func (e *FilterExpr) setImpliedRepo(repo string)         { e.impliedRepo = repo }
+func (e *FilterExpr) setImpliedCommitID(commitID string) { e.impliedCommitID = commitID }
+func (e *FilterExpr) setImpliedUnit(u unit.ID2)          { e.impliedUnit = u }
+
+var _ impliedRepoSetter = (*FilterExpr)(nil)
+var _ impliedCommitIDSetter = (*FilterExpr)(nil)
+var _ impliedUnitSetter = (*FilterExpr)(nil)
+
+// selectRecord evaluates the expression with the record (converted to
+// its JSON representation) as the expression's variable.
+func (e *FilterExpr) selectRecord(record interface{}) bool {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return false
+	}
+	result, err := e.root.eval(v)
+	if err != nil {
+		vlog.Printf("FilterExpr %q: %s (not selecting record).", e.src, err)
+		return false
+	}
+	b, _ := result.(bool)
+	return b
+}
+
+// jsonFieldNames returns the names of the fields in the JSON
+// representation of a value of type t (a struct type).
+func jsonFieldNames(t reflect.Type) map[string]struct{} {
+	names := map[string]struct{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		if i := strings.Index(tag, ","); i != -1 {
+			name = tag[:i]
+		}
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for n := range jsonFieldNames(f.Type) {
+				names[n] = struct{}{}
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = struct{}{}
+	}
+	return names
+}
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string      // the token's source text (for errors)
+	val  interface{} // the value of a number or string token
+	pos  int
+}
+
+// exprOps are the operators and punctuation of filter expressions,
+// with longer operators first so that they are matched first.
+var exprOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	i := 0
+	for {
+		for i < len(src) && unicode.IsSpace(rune(src[i])) {
+			i++
+		}
+		if i == len(src) {
+			return append(toks, exprToken{kind: tokEOF, text: "end of expression", pos: i}), nil
+		}
+		start := i
+		switch c := src[i]; {
+		case c == '_' || unicode.IsLetter(rune(c)):
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			toks = append(toks, exprToken{kind: tokIdent, text: src[start:i], pos: start})
+
+		case unicode.IsDigit(rune(c)):
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.' || src[i] == 'e' || src[i] == 'E' || ((src[i] == '+' || src[i] == '-') && (src[i-1] == 'e' || src[i-1] == 'E'))) {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d in filter expression", src[start:i], start)
+			}
+			toks = append(toks, exprToken{kind: tokNumber, text: src[start:i], val: n, pos: start})
+
+		case c == '"' || c == '\'':
+			i++
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d in filter expression", start)
+			}
+			i++
+			lit := src[start:i]
+			if c == '\'' {
+				// Convert to a double-quoted Go string literal.
+				body := lit[1 : len(lit)-1]
+				body = strings.Replace(body, `\'`, `'`, -1)
+				body = strings.Replace(body, `"`, `\"`, -1)
+				lit = `"` + body + `"`
+			}
+			s, err := strconv.Unquote(lit)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s at offset %d in filter expression", src[start:i], start)
+			}
+			toks = append(toks, exprToken{kind: tokString, text: src[start:i], val: s, pos: start})
+
+		default:
+			var op string
+			for _, o := range exprOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d in filter expression", src[i:i+1], i)
+			}
+			i += len(op)
+			toks = append(toks, exprToken{kind: tokOp, text: op, pos: start})
+		}
+	}
+}
+
+type exprParser struct {
+	toks   []exprToken
+	kind   string              // the name of the record variable
+	fields map[string]struct{} // the record's fields
+}
+
+func (p *exprParser) peek() exprToken { return p.toks[0] }
+
+func (p *exprParser) next() exprToken {
+	t := p.toks[0]
+	if t.kind != tokEOF {
+		p.toks = p.toks[1:]
+	}
+	return t
+}
+
+// accept consumes the next token and returns true if it is the
+// operator (or keyword) s.
+func (p *exprParser) accept(s string) bool {
+	if t := p.peek(); (t.kind == tokOp || t.kind == tokIdent) && t.text == s {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(s string) error {
+	if !p.accept(s) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at offset %d in filter expression, found %q", s, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary([]string{"&&"}, p.parseComparison)
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	return p.parseBinary([]string{"==", "!=", "<=", ">=", "<", ">", "in"}, p.parseAdditive)
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinary([]string{"*", "/", "%"}, p.parseUnary)
+}
+
+// parseBinary parses a left-associative sequence of operands (parsed
+// by operand) joined by the operators ops.
+func (p *exprParser) parseBinary(ops []string, operand func() (exprNode, error)) (exprNode, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		for _, o := range ops {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{op: op, x: x}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected field or method name at offset %d in filter expression, found %q", t.pos, t.text)
+			}
+			if p.accept("(") {
+				args, err := p.parseList(")")
+				if err != nil {
+					return nil, err
+				}
+				if x, err = newCallExpr(t.text, append([]exprNode{x}, args...)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if _, isVar := x.(varExpr); isVar {
+				if _, present := p.fields[t.text]; !present {
+					return nil, fmt.Errorf("%s has no field %q (at offset %d in filter expression)", p.kind, t.text, t.pos)
+				}
+			}
+			x = &fieldExpr{x: x, name: t.text}
+		case p.accept("["):
+			i, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{x: x, i: i}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber, tokString:
+		return literalExpr{t.val}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literalExpr{true}, nil
+		case "false":
+			return literalExpr{false}, nil
+		case "null":
+			return literalExpr{nil}, nil
+		case p.kind:
+			return varExpr{}, nil
+		}
+		if p.accept("(") {
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			if t.text == "has" {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("has takes 1 argument (at offset %d in filter expression)", t.pos)
+				}
+				f, ok := args[0].(*fieldExpr)
+				if !ok {
+					return nil, fmt.Errorf("the argument of has must be a field (at offset %d in filter expression)", t.pos)
+				}
+				return &hasExpr{f}, nil
+			}
+			return newCallExpr(t.text, args)
+		}
+		return nil, fmt.Errorf("unknown name %q at offset %d in filter expression (the %s being filtered is %q)", t.text, t.pos, p.kind, p.kind)
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			elems, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return listExpr(elems), nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d in filter expression", t.text, t.pos)
+}
+
+// parseList parses a comma-separated list of expressions, ending with
+// the closing token end.
+func (p *exprParser) parseList(end string) ([]exprNode, error) {
+	var xs []exprNode
+	if p.accept(end) {
+		return xs, nil
+	}
+	for {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+		if p.accept(end) {
+			return xs, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// An exprNode is a node in the syntax tree of a filter expression.
+// Values are the types that encoding/json decodes to: nil, bool,
+// float64, string, []interface{}, and map[string]interface{}.
+type exprNode interface {
+	eval(record interface{}) (interface{}, error)
+}
+
+type literalExpr struct{ v interface{} }
+
+func (e literalExpr) eval(interface{}) (interface{}, error) { return e.v, nil }
+
+// varExpr is the record being filtered.
+type varExpr struct{}
+
+func (varExpr) eval(record interface{}) (interface{}, error) { return record, nil }
+
+type listExpr []exprNode
+
+func (e listExpr) eval(record interface{}) (interface{}, error) {
+	list := make([]interface{}, len(e))
+	for i, x := range e {
+		v, err := x.eval(record)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+type fieldExpr struct {
+	x    exprNode
+	name string
+}
+
+func (e *fieldExpr) eval(record interface{}) (interface{}, error) {
+	v, _, err := e.lookup(record)
+	return v, err
+}
+
+// lookup returns the value of the field and whether it is present.
+func (e *fieldExpr) lookup(record interface{}) (interface{}, bool, error) {
+	x, err := e.x.eval(record)
+	if err != nil {
+		return nil, false, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, false, nil
+	case map[string]interface{}:
+		v, present := x[e.name]
+		return v, present, nil
+	}
+	return nil, false, fmt.Errorf("can't get field %q of %s", e.name, exprTypeName(x))
+}
+
+type hasExpr struct{ f *fieldExpr }
+
+func (e *hasExpr) eval(record interface{}) (interface{}, error) {
+	_, present, err := e.f.lookup(record)
+	return present, err
+}
+
+type indexExpr struct{ x, i exprNode }
+
+func (e *indexExpr) eval(record interface{}) (interface{}, error) {
+	x, err := e.x.eval(record)
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.i.eval(record)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		n, ok := i.(float64)
+		if !ok || n != math.Trunc(n) {
+			return nil, fmt.Errorf("list index must be an integer, not %s", exprTypeName(i))
+		}
+		if n < 0 || int(n) >= len(x) {
+			return nil, fmt.Errorf("list index %d out of range (size %d)", int(n), len(x))
+		}
+		return x[int(n)], nil
+	case map[string]interface{}:
+		k, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, not %s", exprTypeName(i))
+		}
+		return x[k], nil
+	}
+	return nil, fmt.Errorf("can't index %s", exprTypeName(x))
+}
+
+type unaryExpr struct {
+	op string
+	x  exprNode
+}
+
+func (e *unaryExpr) eval(record interface{}) (interface{}, error) {
+	x, err := e.x.eval(record)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		b, err := exprBool(x)
+		return !b, err
+	}
+	if x == nil {
+		x = float64(0)
+	}
+	n, ok := x.(float64)
+	if !ok {
+		return nil, fmt.Errorf("can't negate %s", exprTypeName(x))
+	}
+	return -n, nil
+}
+
+type binaryExpr struct {
+	op   string
+	x, y exprNode
+}
+
+func (e *binaryExpr) eval(record interface{}) (interface{}, error) {
+	x, err := e.x.eval(record)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit boolean operators.
+	if e.op == "&&" || e.op == "||" {
+		b, err := exprBool(x)
+		if err != nil {
+			return nil, err
+		}
+		if b == (e.op == "||") {
+			return b, nil
+		}
+		y, err := e.y.eval(record)
+		if err != nil {
+			return nil, err
+		}
+		return exprBool(y)
+	}
+
+	y, err := e.y.eval(record)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.op == "in" {
+		switch y := y.(type) {
+		case nil:
+			return false, nil
+		case []interface{}:
+			for _, v := range y {
+				if eq, _ := exprEqual(x, v); eq {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			k, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key must be a string, not %s", exprTypeName(x))
+			}
+			_, present := y[k]
+			return present, nil
+		}
+		return nil, fmt.Errorf("can't use 'in' with %s", exprTypeName(y))
+	}
+
+	x, y = exprZeroNull(x, y), exprZeroNull(y, x)
+	switch e.op {
+	case "==", "!=":
+		eq, err := exprEqual(x, y)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (e.op == "=="), nil
+	case "<", "<=", ">", ">=":
+		c, err := exprCompare(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+
+	switch x := x.(type) {
+	case string:
+		if y, ok := y.(string); ok && e.op == "+" {
+			return x + y, nil
+		}
+	case []interface{}:
+		if y, ok := y.([]interface{}); ok && e.op == "+" {
+			return append(append([]interface{}{}, x...), y...), nil
+		}
+	case float64:
+		y, ok := y.(float64)
+		if !ok {
+			break
+		}
+		switch e.op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/", "%":
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if e.op == "/" {
+				return x / y, nil
+			}
+			return math.Mod(x, y), nil
+		}
+	}
+	return nil, fmt.Errorf("can't apply %s to %s and %s", e.op, exprTypeName(x), exprTypeName(y))
+}
+
+// callExpr is a call of a function. Methods (x.f(y)) are calls with
+// the receiver as the first argument.
+type callExpr struct {
+	name string
+	args []exprNode
+	re   *regexp.Regexp // the compiled regexp of matches, if constant
+}
+
+// exprFuncArgs are the number of arguments (including the receiver of
+// methods) of each function.
+var exprFuncArgs = map[string]int{
+	"size":       1,
+	"contains":   2,
+	"startsWith": 2,
+	"endsWith":   2,
+	"matches":    2,
+}
+
+func newCallExpr(name string, args []exprNode) (exprNode, error) {
+	n, ok := exprFuncArgs[name]
+	if !ok {
+		names := make([]string, 0, len(exprFuncArgs)+1)
+		for name := range exprFuncArgs {
+			names = append(names, name)
+		}
+		names = append(names, "has")
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown function %q in filter expression (functions: %s)", name, strings.Join(names, ", "))
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s takes %d argument(s), not %d", name, n, len(args))
+	}
+	e := &callExpr{name: name, args: args}
+	if name == "matches" {
+		if lit, ok := args[1].(literalExpr); ok {
+			s, ok := lit.v.(string)
+			if !ok {
+				return nil, fmt.Errorf("the argument of matches must be a string")
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, err
+			}
+			e.re = re
+		}
+	}
+	return e, nil
+}
+
+func (e *callExpr) eval(record interface{}) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(record)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	if e.name == "size" {
+		switch x := args[0].(type) {
+		case nil:
+			return float64(0), nil
+		case string:
+			return float64(len([]rune(x))), nil
+		case []interface{}:
+			return float64(len(x)), nil
+		case map[string]interface{}:
+			return float64(len(x)), nil
+		}
+		return nil, fmt.Errorf("can't get size of %s", exprTypeName(args[0]))
+	}
+
+	// The other functions are string methods.
+	var strs [2]string
+	for i, a := range args {
+		if a == nil {
+			continue // "" (see exprZeroNull)
+		}
+		s, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires strings, not %s", e.name, exprTypeName(a))
+		}
+		strs[i] = s
+	}
+	s, t := strs[0], strs[1]
+	switch e.name {
+	case "contains":
+		return strings.Contains(s, t), nil
+	case "startsWith":
+		return strings.HasPrefix(s, t), nil
+	case "endsWith":
+		return strings.HasSuffix(s, t), nil
+	case "matches":
+		re := e.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(t); err != nil {
+				return nil, err
+			}
+		}
+		return re.MatchString(s), nil
+	}
+	panic("unreachable")
+}
+
+// exprBool returns the boolean value of v, which must be a bool or
+// null (false).
+func exprBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("expected bool, found %s", exprTypeName(v))
+}
+
+// exprZeroNull returns v, or, if v is null, the zero value of the
+// type of other.
+func exprZeroNull(v, other interface{}) interface{} {
+	if v != nil {
+		return v
+	}
+	switch other.(type) {
+	case bool:
+		return false
+	case float64:
+		return float64(0)
+	case string:
+		return ""
+	case []interface{}:
+		return []interface{}{}
+	case map[string]interface{}:
+		return map[string]interface{}{}
+	}
+	return nil
+}
+
+func exprEqual(x, y interface{}) (bool, error) {
+	if exprTypeName(x) != exprTypeName(y) {
+		return false, fmt.Errorf("can't compare %s and %s", exprTypeName(x), exprTypeName(y))
+	}
+	return reflect.DeepEqual(x, y), nil
+}
+
+// exprCompare returns -1, 0, or 1 if x is less than, equal to, or
+// greater than y (which must both be numbers or strings).
+func exprCompare(x, y interface{}) (int, error) {
+	switch x := x.(type) {
+	case float64:
+		if y, ok := y.(float64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("can't order %s and %s", exprTypeName(x), exprTypeName(y))
+}
+
+func exprTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
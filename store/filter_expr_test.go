@@ -0,0 +1,91 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFilterExpr_SelectDef(t *testing.T) {
+	def := &graph.Def{
+		DefKey:   graph.DefKey{Path: "a/LongFunctionName"},
+		Name:     "LongFunctionName",
+		Kind:     "func",
+		File:     "a.go",
+		Exported: true,
+		Data:     json.RawMessage(`{"Visibility":"public","Annotations":["Deprecated"],"Arity":2}`),
+	}
+	tests := map[string]bool{
+		`def.Kind == "func" && def.Exported && size(def.Name) > 10`: true,
+		`def.Kind == 'type' || def.Local`:                           false,
+		`!def.Local && !def.Test`:                                   true,
+		`def.Local == false`:                                        true,
+		`def.Name.startsWith("Long") && def.File.endsWith(".go")`:   true,
+		`def.Name.matches("^[A-Z]") && def.Path.contains("/")`:      true,
+		`def.Data.Visibility == "public"`:                           true,
+		`"Deprecated" in def.Data.Annotations`:                      true,
+		`def.Data.Arity * 2 + 1 == 5`:                               true,
+		`def.Data.Annotations[0] == "Deprecated"`:                   true,
+		`has(def.Data.Arity) && !has(def.Data.Missing)`:             true,
+		`def.Kind in ["var", "const"]`:                              false,
+		`def.DefStart >= 0 && def.Repo == "r" && def.Unit == "u"`:   true,
+
+		// Type errors don't select the record.
+		`def.Name > 10`: false,
+	}
+	for src, want := range tests {
+		e, err := ParseFilterExpr("def", src)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		e.setImpliedRepo("r")
+		e.setImpliedUnit(unit.ID2{Type: "t", Name: "u"})
+		if got := e.SelectDef(def); got != want {
+			t.Errorf("%s: got %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestFilterExpr_SelectRefAndUnit(t *testing.T) {
+	e, err := ParseFilterExpr("ref", `ref.DefRepo != ref.Repo && !ref.Def`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.setImpliedRepo("r")
+	if e.SelectRef(&graph.Ref{DefPath: "p"}) {
+		t.Error("got same-repo ref selected, want not selected")
+	}
+	if !e.SelectRef(&graph.Ref{DefRepo: "r2", DefPath: "p"}) {
+		t.Error("got cross-repo ref not selected, want selected")
+	}
+
+	e, err = ParseFilterExpr("unit", `unit.Language == "Go" && size(unit.Files) >= 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.SelectUnit(&unit.SourceUnit{Language: "Go", Files: []string{"a.go", "b.go"}}) {
+		t.Error("got unit not selected, want selected")
+	}
+}
+
+func TestParseFilterExpr_errors(t *testing.T) {
+	tests := []struct{ kind, src string }{
+		{"def", `def.Knd == "func"`}, // unknown field
+		{"def", `ref.Def`},           // wrong variable
+		{"def", `def.Name ==`},
+		{"def", `def.Name == "x`},
+		{"def", `def.Name.frobnicate()`},
+		{"def", `def.Name.matches("(")`},
+		{"def", `has(def)`},
+		{"def", `(def.Local`},
+		{"version", `true`},
+	}
+	for _, test := range tests {
+		if _, err := ParseFilterExpr(test.kind, test.src); err == nil {
+			t.Errorf("%s %s: got no error, want error", test.kind, test.src)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// FormatVersion is the version of the on-disk format (data files and
+// indexes) written by FS-backed stores. It must be incremented (and
+// a migration must be added to the migrations list) whenever a codec
+// or index change makes existing stores unreadable.
+const FormatVersion = 4
+
+// packFormatVersion is the first format version in which commits'
+// data may be compacted (see Compactor): stored in pack files, as
+// deltas against other commits, or in deduplicated blobs, instead of
+// in loose per-unit files. Older versions of the store can't read
+// compacted commits (they'd see no data), so Compact upgrades a
+// store to this version before compacting.
+const packFormatVersion = 2
+
+// codecFormatVersion is the first format version in which a store's
+// data files may be encoded with a codec other than the default
+// (protobuf) codec, which is recorded in the store's format file.
+// Older versions of the store ignore the recorded codec (they'd fail
+// to decode the data), so ConvertCodec upgrades a store to this
+// version before converting it to another codec.
+const codecFormatVersion = 3
+
+// minReadableFormatVersion is the oldest format version that this
+// version of the store can read without migrating first. Stores
+// created before format versioning was introduced have version 0.
+const minReadableFormatVersion = 0
+
+// formatFilename is the name of the file (at the root of a
+// FS-backed RepoStore or MultiRepoStore) that records the store's
+// format version. It begins with a "." so that it is skipped when
+// listing repos in a multi-repo store.
+const formatFilename = ".srclib-store-format"
+
+// storeFormat is the JSON-encoded contents of the format file.
+type storeFormat struct {
+	Version int
+
+	// Codec is the name of the codec (in Codecs) that the store's
+	// data files are encoded with. If empty, the store uses the
+	// default codec (protobuf).
+	Codec string `json:",omitempty"`
+}
+
+// A FormatVersionError is returned by CheckFormat when a store's
+// on-disk format can't be read by this version of the store package.
+type FormatVersionError struct {
+	Found int // the format version recorded in the store
+}
+
+func (e *FormatVersionError) Error() string {
+	if e.Found > FormatVersion {
+		return fmt.Sprintf("store format version %d is newer than the newest supported format version %d (upgrade srclib to read this store)", e.Found, FormatVersion)
+	}
+	return fmt.Sprintf("store format version %d is older than the oldest readable format version %d (run `src store migrate` to upgrade it)", e.Found, minReadableFormatVersion)
+}
+
+// NeedsMigration returns whether the store can be made readable by
+// running Migrate.
+func (e *FormatVersionError) NeedsMigration() bool { return e.Found < minReadableFormatVersion }
+
+// readFormatVersion reads the format version of the store rooted at
+// fs. If the store has no format file, it is assumed to predate
+// format versioning, and version 0 is returned.
+func readFormatVersion(fs rwvfs.FileSystem) (int, error) {
+	sf, err := readFormat(fs)
+	if err != nil {
+		return 0, err
+	}
+	return sf.Version, nil
+}
+
+// readFormat reads the format file of the store rooted at fs. If the
+// store has no format file, the zero value is returned.
+func readFormat(fs rwvfs.FileSystem) (*storeFormat, error) {
+	f, err := fs.Open(formatFilename)
+	if err != nil {
+		if isOSOrVFSNotExist(err) {
+			return &storeFormat{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var sf storeFormat
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, fmt.Errorf("parsing store format file %s: %s", formatFilename, err)
+	}
+	return &sf, nil
+}
+
+// writeFormatVersion records version as the format version of the
+// store rooted at fs. The store's recorded codec is preserved.
+func writeFormatVersion(fs rwvfs.FileSystem, version int) error {
+	sf, err := readFormat(fs)
+	if err != nil {
+		return err
+	}
+	sf.Version = version
+	return writeFormat(fs, sf)
+}
+
+// writeFormat writes the format file of the store rooted at fs.
+func writeFormat(fs rwvfs.FileSystem, sf *storeFormat) (err error) {
+	b, err := json.Marshal(sf)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Create(formatFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	_, err = f.Write(b)
+	return err
+}
+
+// ensureFormatVersion writes the current format version (and the name
+// of the store's codec, c) to the store rooted at fs if the store
+// doesn't yet have a format file. It is called (through
+// formatEnsurer) on a store's first import, so that newly created
+// stores are always versioned. It doesn't overwrite the format file
+// of an existing store (that's Migrate's job).
+func ensureFormatVersion(fs rwvfs.FileSystem, c codec) error {
+	if _, err := fs.Stat(formatFilename); err == nil {
+		return nil
+	} else if !isOSOrVFSNotExist(err) {
+		return err
+	}
+	if err := rwvfs.MkdirAll(fs, "."); err != nil {
+		return err
+	}
+	return writeFormat(fs, &storeFormat{Version: FormatVersion, Codec: codecName(c)})
+}
+
+// formatEnsurer calls ensureFormatVersion once per opened store (on
+// its first write), instead of on every import.
+type formatEnsurer struct {
+	mu   sync.Mutex
+	done bool
+}
+
+func (e *formatEnsurer) ensure(fs rwvfs.FileSystem, sc *storeCodec) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return nil
+	}
+	c, err := sc.get()
+	if err != nil {
+		return err
+	}
+	if err := ensureFormatVersion(fs, c); err != nil {
+		return err
+	}
+	e.done = true
+	return nil
+}
+
+// formatFS returns the root VFS of a FS-backed store, or nil if s is
+// not a FS-backed store.
+func formatFS(s interface{}) rwvfs.FileSystem {
+	switch s := s.(type) {
+	case *fsMultiRepoStore:
+		return s.fs
+	case *fsRepoStore:
+		return s.fs
+	}
+	return nil
+}
+
+// StoreFormatVersion returns the on-disk format version of a
+// FS-backed store. Stores that predate format versioning (and stores
+// that haven't been imported into yet) have version 0.
+func StoreFormatVersion(s interface{}) (int, error) {
+	fs := formatFS(s)
+	if fs == nil {
+		return 0, fmt.Errorf("store (type %T) does not have an on-disk format", s)
+	}
+	return readFormatVersion(fs)
+}
+
+// StoreCodec returns the name of the codec (in Codecs) that a
+// FS-backed store's data files are encoded with. If the store hasn't
+// been imported into yet (so it can be written with any codec), the
+// empty string is returned.
+func StoreCodec(s interface{}) (string, error) {
+	if s, ok := s.(*shardedMultiRepoStore); ok {
+		// All shards must use the same codec.
+		var name string
+		for i, shard := range s.shards {
+			shardName, err := StoreCodec(shard)
+			if err != nil {
+				return "", err
+			}
+			if shardName == "" {
+				continue
+			}
+			if name != "" && shardName != name {
+				return "", fmt.Errorf("store shard %d was created with codec %q, but other shards use %q", i, shardName, name)
+			}
+			name = shardName
+		}
+		return name, nil
+	}
+
+	fs := formatFS(s)
+	if fs == nil {
+		return "", fmt.Errorf("store (type %T) does not have an on-disk format", s)
+	}
+	if _, err := fs.Stat(formatFilename); isOSOrVFSNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	sf, err := readFormat(fs)
+	if err != nil {
+		return "", err
+	}
+	if sf.Codec == "" {
+		return defaultCodecName, nil
+	}
+	return sf.Codec, nil
+}
+
+// CheckFormat returns a *FormatVersionError if s is a FS-backed store
+// whose on-disk format can't be read by this version of the store
+// package. Other stores are always compatible.
+func CheckFormat(s interface{}) error {
+	if s, ok := s.(*shardedMultiRepoStore); ok {
+		for _, shard := range s.shards {
+			if err := CheckFormat(shard); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fs := formatFS(s)
+	if fs == nil {
+		return nil
+	}
+	v, err := readFormatVersion(fs)
+	if err != nil {
+		return err
+	}
+	if v > FormatVersion || v < minReadableFormatVersion {
+		return &FormatVersionError{Found: v}
+	}
+	return nil
+}
+
+// A migration upgrades a store from one format version to the next.
+type migration struct {
+	// from is the format version that this migration upgrades
+	// from. It upgrades the store to from+1.
+	from int
+
+	// desc is a human-readable description of the migration.
+	desc string
+
+	// migrate performs the migration on the store's root VFS. It is
+	// called for the root of a RepoStore, and for the root of each
+	// repo's RepoStore in a MultiRepoStore.
+	migrate func(fs rwvfs.FileSystem) error
+}
+
+// migrations lists all format migrations, ordered by from version.
+var migrations = []migration{
+	{
+		from: 0,
+		desc: "record format version (data and index formats are unchanged)",
+		migrate: func(fs rwvfs.FileSystem) error {
+			return nil
+		},
+	},
+	{
+		from: 1,
+		desc: "allow compacted commits (pack files, deltas, and deduplicated blobs; existing data is unchanged)",
+		migrate: func(fs rwvfs.FileSystem) error {
+			return nil
+		},
+	},
+	{
+		from: 2,
+		desc: "allow data files encoded with codecs other than protobuf (existing data is unchanged)",
+		migrate: func(fs rwvfs.FileSystem) error {
+			return nil
+		},
+	},
+	{
+		from:    3,
+		desc:    "rebuild case-folded def name indexes (names are now case-folded with Unicode case folding instead of lowercased)",
+		migrate: rebuildFoldedDefNameIndexes,
+	},
+}
+
+// upgradeFormat applies the migrations that upgrade the store rooted
+// at fs to at least the given format version (if it is older). If
+// repoStore is false, fs is the root of a MultiRepoStore, whose own
+// data doesn't need migrating (only its format file is updated).
+func upgradeFormat(fs rwvfs.FileSystem, version int, repoStore bool) error {
+	from, err := readFormatVersion(fs)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.from < from || m.from >= version {
+			continue
+		}
+		vlog.Printf("Migrating store from format version %d to %d: %s", m.from, m.from+1, m.desc)
+		if repoStore {
+			if err := m.migrate(fs); err != nil {
+				return err
+			}
+		}
+		if err := writeFormatVersion(fs, m.from+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateOpt configures Migrate.
+type MigrateOpt struct {
+	// DryRun indicates that Migrate should only log the migrations
+	// it would perform, without modifying the store.
+	DryRun bool
+
+	// Logf, if non-nil, is called to report progress.
+	Logf func(format string, v ...interface{})
+}
+
+// Migrate upgrades the on-disk format of a FS-backed store to
+// FormatVersion in place, applying each migration between the
+// store's current format version and FormatVersion in order. It
+// returns the format versions before and after migrating.
+func Migrate(s interface{}, opt MigrateOpt) (from, to int, err error) {
+	logf := opt.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	root := formatFS(s)
+	if root == nil {
+		return 0, 0, fmt.Errorf("store (type %T) does not have an on-disk format", s)
+	}
+	from, err = readFormatVersion(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from > FormatVersion {
+		return from, from, &FormatVersionError{Found: from}
+	}
+	if from == FormatVersion {
+		logf("Store is already at format version %d.", from)
+		return from, from, nil
+	}
+
+	// Collect the root VFSs of all RepoStores to migrate.
+	var fss []rwvfs.FileSystem
+	switch s := s.(type) {
+	case *fsMultiRepoStore:
+		repos, err := s.Repos(Unordered())
+		if err != nil && !isStoreNotExist(err) {
+			return from, from, err
+		}
+		for _, repo := range repos {
+			fss = append(fss, s.openRepoStore(repo).(*fsRepoStore).fs)
+		}
+	case *fsRepoStore:
+		fss = append(fss, s.fs)
+	}
+
+	for _, m := range migrations {
+		if m.from < from {
+			continue
+		}
+		logf("Migrating from format version %d to %d: %s", m.from, m.from+1, m.desc)
+		if opt.DryRun {
+			continue
+		}
+		for _, fs := range fss {
+			if err := m.migrate(fs); err != nil {
+				return from, m.from, err
+			}
+			if err := writeFormatVersion(fs, m.from+1); err != nil {
+				return from, m.from, err
+			}
+		}
+		if fs, ok := s.(*fsMultiRepoStore); ok {
+			if err := writeFormatVersion(fs.fs, m.from+1); err != nil {
+				return from, m.from, err
+			}
+		}
+	}
+	if opt.DryRun {
+		return from, from, nil
+	}
+	return from, FormatVersion, nil
+}
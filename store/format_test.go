@@ -0,0 +1,105 @@
+package store
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFormatVersion_writtenOnImport(t *testing.T) {
+	rs := NewFSRepoStore(newTestFS())
+	if v, err := StoreFormatVersion(rs); err != nil {
+		t.Fatal(err)
+	} else if v != 0 {
+		t.Errorf("before import: got format version %d, want 0", v)
+	}
+
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	if err := rs.Import("c", u, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := StoreFormatVersion(rs); err != nil {
+		t.Fatal(err)
+	} else if v != FormatVersion {
+		t.Errorf("after import: got format version %d, want %d", v, FormatVersion)
+	}
+	if err := CheckFormat(rs); err != nil {
+		t.Errorf("CheckFormat: %s", err)
+	}
+
+	// The format file must not be mistaken for a version.
+	versions, err := rs.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].CommitID != "c" {
+		t.Errorf("Versions(): got %v, want only commit c", versions)
+	}
+}
+
+func TestFormatVersion_writtenOncePerOpen(t *testing.T) {
+	fs := newTestFS()
+	rs := NewFSRepoStore(fs)
+	if err := rs.Import("c", &unit.SourceUnit{Type: "t", Name: "u1"}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(formatFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	// Later imports into the same opened store don't check (or
+	// rewrite) the format file.
+	if err := rs.Import("c", &unit.SourceUnit{Type: "t", Name: "u2"}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(formatFilename); !isOSOrVFSNotExist(err) {
+		t.Errorf("got err %v from Stat of format file, want not exist", err)
+	}
+
+	// A newly opened store does.
+	if err := NewFSRepoStore(fs).Import("c", &unit.SourceUnit{Type: "t", Name: "u3"}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(formatFilename); err != nil {
+		t.Errorf("after import into newly opened store: %s", err)
+	}
+}
+
+func TestCheckFormat_tooNew(t *testing.T) {
+	fs := newTestFS()
+	rs := NewFSRepoStore(fs)
+	if err := writeFormatVersion(fs, FormatVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckFormat(rs)
+	if _, ok := err.(*FormatVersionError); !ok {
+		t.Errorf("CheckFormat: got err %v, want *FormatVersionError", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, nil)
+	if err := mrs.Import("r", "c", &unit.SourceUnit{Type: "t", Name: "u"}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a store that was created before format versioning.
+	if err := fs.Remove(formatFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to, err := Migrate(mrs, MigrateOpt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 || to != FormatVersion {
+		t.Errorf("Migrate: got from=%d to=%d, want from=0 to=%d", from, to, FormatVersion)
+	}
+	if v, err := StoreFormatVersion(mrs); err != nil {
+		t.Fatal(err)
+	} else if v != FormatVersion {
+		t.Errorf("after Migrate: got format version %d, want %d", v, FormatVersion)
+	}
+}
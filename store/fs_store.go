@@ -36,6 +36,10 @@ type fsMultiRepoStore struct {
 	fs rwvfs.WalkableFileSystem
 	FSMultiRepoStoreConf
 	repoStores
+
+	opened openStoreCache // opened repo stores (see CacheOpenStores)
+	format formatEnsurer
+	codec  *storeCodec // shared by the repo stores
 }
 
 var _ MultiRepoStoreImporter = (*fsMultiRepoStore)(nil)
@@ -51,7 +55,7 @@ func NewFSMultiRepoStore(fs rwvfs.WalkableFileSystem, conf *FSMultiRepoStoreConf
 	}
 
 	setCreateParentDirs(fs)
-	mrs := &fsMultiRepoStore{fs: fs, FSMultiRepoStoreConf: *conf}
+	mrs := &fsMultiRepoStore{fs: fs, FSMultiRepoStoreConf: *conf, codec: newStoreCodec(fs)}
 	mrs.repoStores = repoStores{mrs}
 	return mrs
 }
@@ -64,6 +68,30 @@ type FSMultiRepoStoreConf struct {
 	// repository data. If nil, DefaultRepoPaths is used, which stores
 	// repos at "${REPO}/.srclib-store".
 	RepoPaths
+
+	// RepoAliases maps alias repo URIs to the canonical URIs of the
+	// repos they refer to (e.g., the URI of a mirror to the URI of
+	// the repo it mirrors). Data for an alias is stored under the
+	// canonical repo, filters that refer to an alias (such as ByRepos
+	// and ByRefDef) match the canonical repo, and results refer to
+	// the canonical repo. A canonical repo must not itself be an
+	// alias.
+	RepoAliases map[string]string
+
+	// Namespaces maps the names of namespaces (such as orgs or teams)
+	// to the repo URI prefixes of the repos in them (e.g.,
+	// {"payments": ["github.com/acme/pay-", "git.acme.corp/payments/"]}).
+	// A repo is in the namespace with the longest matching prefix.
+	Namespaces map[string][]string
+
+	// Scope, if non-nil, restricts the store to the repos in the named
+	// namespaces (which must be defined in Namespaces), so that a
+	// shared store can serve many teams without exposing all of its
+	// repos to each. Other repos appear to have no data: they are
+	// omitted from listings and query results (including the global
+	// ref index), and writes to them fail with an error for which
+	// IsOutOfScope returns true.
+	Scope []string
 }
 
 // getRepo gets a single repo.
@@ -82,7 +110,8 @@ func (s *fsMultiRepoStore) getRepo(repo string) (string, error) {
 	return repo, nil
 }
 
-func (s *fsMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
+// repos implements Repos (see repo_aliases.go).
+func (s *fsMultiRepoStore) repos(f ...RepoFilter) ([]string, error) {
 	scopeRepos, err := scopeRepos(storeFilters(f))
 	if err != nil {
 		return nil, err
@@ -131,24 +160,35 @@ func (s *fsMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
 			}
 			after = s.fs.Join(paths[len(paths)-1]...)
 		}
-		repos = make([]string, len(allPaths))
-		for i, path := range allPaths {
-			repos[i] = s.PathToRepo(path)
+		repos = make([]string, 0, len(allPaths))
+		for _, path := range allPaths {
+			if len(path) > 0 && (path[0] == globalRefsDir || path[0] == formatFilename) {
+				// Not a repo (custom RepoPaths may list it).
+				continue
+			}
+			repos = append(repos, s.PathToRepo(path))
 		}
 	}
 
 	filteredRepos := make([]string, 0, len(repos))
 	for _, repo := range repos {
-		if repoFilters(f).SelectRepo(repo) {
+		if repoFilters(f).SelectRepo(repo) && s.inScope(repo) {
 			filteredRepos = append(filteredRepos, repo)
 		}
 	}
+	sortRepos(filteredRepos, f)
 	return filteredRepos, nil
 }
 
 func (s *fsMultiRepoStore) openRepoStore(repo string) RepoStore {
-	subpath := s.fs.Join(s.RepoToPath(repo)...)
-	return NewFSRepoStore(rwvfs.Sub(s.fs, subpath))
+	repo = s.canonicalRepo(repo)
+	return s.opened.get(repo, func() interface{} {
+		if !s.inScope(repo) {
+			return newFSRepoStoreCodec(outOfScopeFS{repo}, s.codec)
+		}
+		subpath := s.fs.Join(s.RepoToPath(repo)...)
+		return newFSRepoStoreCodec(rwvfs.Sub(s.fs, subpath), s.codec)
+	}).(RepoStore)
 }
 
 func (s *fsMultiRepoStore) openAllRepoStores() (map[string]RepoStore, error) {
@@ -167,9 +207,17 @@ func (s *fsMultiRepoStore) openAllRepoStores() (map[string]RepoStore, error) {
 var _ repoStoreOpener = (*fsMultiRepoStore)(nil)
 
 func (s *fsMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUnit, data graph.Output) error {
+	repo = s.canonicalRepo(repo)
+	if err := s.checkInScope(repo); err != nil {
+		return err
+	}
+	s.canonicalizeRepoRefs(&data)
 	if unit != nil {
 		cleanForImport(&data, repo, unit.Type, unit.Name)
 	}
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
 	subpath := s.fs.Join(s.RepoToPath(repo)...)
 	if err := rwvfs.MkdirAll(s.fs, subpath); err != nil {
 		return err
@@ -177,12 +225,19 @@ func (s *fsMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUnit,
 	return s.openRepoStore(repo).(RepoImporter).Import(commitID, unit, data)
 }
 
+// Index builds the indexes of the repo's commit and updates the
+// global ref index with the commit's refs to defs in other repos.
 func (s *fsMultiRepoStore) Index(repo, commitID string) error {
+	if err := s.checkInScope(s.canonicalRepo(repo)); err != nil {
+		return err
+	}
 	switch rs := s.openRepoStore(repo).(type) {
 	case RepoIndexer:
-		return rs.Index(commitID)
+		if err := rs.Index(commitID); err != nil {
+			return err
+		}
 	}
-	return nil
+	return s.IndexGlobalRefs(repo, commitID)
 }
 
 func (s *fsMultiRepoStore) String() string { return "fsMultiRepoStore" }
@@ -191,6 +246,14 @@ func (s *fsMultiRepoStore) String() string { return "fsMultiRepoStore" }
 type fsRepoStore struct {
 	fs rwvfs.FileSystem
 	treeStores
+
+	// indexes are the repo-level indexes, which span all commits. It
+	// is nil if indexing is disabled (see useIndexedStore).
+	indexes map[string]Index
+
+	opened openStoreCache // opened tree stores (see CacheOpenStores)
+	format formatEnsurer
+	codec  *storeCodec // the store's codec (or its MultiRepoStore's)
 }
 
 // SrclibStoreDir is the name of the directory under which a RepoStore's data is stored.
@@ -199,13 +262,27 @@ const SrclibStoreDir = ".srclib-store"
 // NewFSRepoStore creates a new repository store (that can be
 // imported into) that is backed by files on a filesystem.
 func NewFSRepoStore(fs rwvfs.FileSystem) RepoStoreImporter {
+	return newFSRepoStoreCodec(fs, newStoreCodec(fs))
+}
+
+// newFSRepoStoreCodec creates a FS-backed repository store that encodes
+// its data with codec.
+func newFSRepoStoreCodec(fs rwvfs.FileSystem, codec *storeCodec) *fsRepoStore {
 	setCreateParentDirs(fs)
-	rs := &fsRepoStore{fs: fs}
+	rs := &fsRepoStore{fs: fs, codec: codec}
 	rs.treeStores = treeStores{rs}
+	if useIndexedStore {
+		rs.indexes = newRepoIndexes()
+	}
 	return rs
 }
 
 func (s *fsRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
+	rf, err := resolveSnapshots(s, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]VersionFilter)
 	versionDirs, err := s.versionDirs()
 	if err != nil {
 		return nil, err
@@ -226,9 +303,14 @@ func (s *fsRepoStore) versionDirs() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	dirs := make([]string, len(entries))
-	for i, e := range entries {
-		dirs[i] = e.Name()
+	dirs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// Skip non-dirs (such as the store format file) and dirs
+		// that don't hold commits (such as blobsDir).
+		if !e.Mode().IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		dirs = append(dirs, e.Name())
 	}
 	return dirs, nil
 }
@@ -237,31 +319,50 @@ func (s *fsRepoStore) Import(commitID string, unit *unit.SourceUnit, data graph.
 	if unit != nil {
 		cleanForImport(&data, "", unit.Type, unit.Name)
 	}
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	if err := s.checkNotDeltaBase(commitID); err != nil {
+		return err
+	}
 	ts := s.newTreeStore(commitID)
 	return ts.Import(unit, data)
 }
 
 func (s *fsRepoStore) Index(commitID string) error {
+	if err := s.invalidateIndexes(); err != nil {
+		return err
+	}
 	if xs, ok := s.newTreeStore(commitID).(*indexedTreeStore); ok {
 		return xs.Index()
 	}
 	return nil // nothing to do
 }
 
+// treeStoreFS returns the VFS of the commit's dir, in which packed
+// files (see Compactor) are read as though they were loose.
 func (s *fsRepoStore) treeStoreFS(commitID string) rwvfs.FileSystem {
-	return rwvfs.Sub(s.fs, commitID)
+	return newPackFS(rwvfs.Sub(s.fs, commitID), s.treeStoreFS, s.blobsFS())
 }
 
 func (s *fsRepoStore) newTreeStore(commitID string) TreeStoreImporter {
+	return s.newTreeStoreCodec(commitID, s.codec)
+}
+
+// newTreeStoreCodec is like newTreeStore, but the tree store encodes
+// and decodes its data with c (see ConvertCodec).
+func (s *fsRepoStore) newTreeStoreCodec(commitID string, c codec) TreeStoreImporter {
 	fs := s.treeStoreFS(commitID)
 	if useIndexedStore {
-		return newIndexedTreeStore(fs)
+		return newIndexedTreeStore(fs, c)
 	}
-	return newFSTreeStore(fs)
+	return newFSTreeStore(fs, c)
 }
 
 func (s *fsRepoStore) openTreeStore(commitID string) TreeStore {
-	return s.newTreeStore(commitID)
+	return s.opened.get(commitID, func() interface{} {
+		return s.newTreeStore(commitID)
+	}).(TreeStore)
 }
 
 func (s *fsRepoStore) openAllTreeStores() (map[string]TreeStore, error) {
@@ -286,10 +387,13 @@ func (s *fsRepoStore) String() string { return "fsRepoStore" }
 type fsTreeStore struct {
 	fs rwvfs.FileSystem
 	unitStores
+
+	opened openStoreCache // opened unit stores (see CacheOpenStores)
+	codec  codec          // encodes and decodes the unit files and data files
 }
 
-func newFSTreeStore(fs rwvfs.FileSystem) *fsTreeStore {
-	ts := &fsTreeStore{fs: fs}
+func newFSTreeStore(fs rwvfs.FileSystem, c codec) *fsTreeStore {
+	ts := &fsTreeStore{fs: fs, codec: c}
 	ts.unitStores = unitStores{ts}
 	return ts
 }
@@ -316,8 +420,12 @@ func (s *fsTreeStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 		}
 	}
 
+	ctl := getQueryControls(f)
 	var units []*unit.SourceUnit
 	for _, filename := range unitFilenames {
+		if err := ctl.check(); err != nil {
+			return nil, err
+		}
 		c_fsTreeStore_unitsOpened++
 		unit, err := s.openUnitFile(filename)
 		if err != nil {
@@ -346,7 +454,7 @@ func (s *fsTreeStore) openUnitFile(filename string) (u *unit.SourceUnit, err err
 	}()
 
 	var unit unit.SourceUnit
-	_, err = Codec.NewDecoder(f).Decode(&unit)
+	_, err = s.codec.NewDecoder(f).Decode(&unit)
 	return &unit, err
 }
 
@@ -390,7 +498,7 @@ func (s *fsTreeStore) Import(u *unit.SourceUnit, data graph.Output) (err error)
 			err = err2
 		}
 	}()
-	if _, err := Codec.NewEncoder(f).Encode(u); err != nil {
+	if _, err := s.codec.NewEncoder(f).Encode(u); err != nil {
 		return err
 	}
 
@@ -403,12 +511,14 @@ func (s *fsTreeStore) Import(u *unit.SourceUnit, data graph.Output) (err error)
 }
 
 func (s *fsTreeStore) openUnitStore(u unit.ID2) UnitStore {
-	filename := s.unitFilename(u.Type, u.Name)
-	dir := strings.TrimSuffix(filename, unitFileSuffix)
-	if useIndexedStore {
-		return newIndexedUnitStore(rwvfs.Sub(s.fs, dir), u.String())
-	}
-	return &fsUnitStore{fs: rwvfs.Sub(s.fs, dir), label: u.String()}
+	return s.opened.get(u, func() interface{} {
+		filename := s.unitFilename(u.Type, u.Name)
+		dir := strings.TrimSuffix(filename, unitFileSuffix)
+		if useIndexedStore {
+			return newIndexedUnitStore(rwvfs.Sub(s.fs, dir), u.String(), s.codec)
+		}
+		return &fsUnitStore{fs: rwvfs.Sub(s.fs, dir), label: u.String(), codec: s.codec}
+	}).(UnitStore)
 }
 
 func (s *fsTreeStore) openAllUnitStores() (map[unit.ID2]UnitStore, error) {
@@ -445,6 +555,8 @@ type fsUnitStore struct {
 	fs rwvfs.FileSystem
 
 	label string // a human-readable label (included in String() output)
+
+	codec codec // encodes and decodes the data files
 }
 
 const (
@@ -457,7 +569,13 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 		return s.defsAtOffsets(byteOffsets(f), fs)
 	}
 
+	ctl := getQueryControls(fs)
+	if err := ctl.unitScanned(); err != nil {
+		return nil, err
+	}
+
 	vlog.Printf("%s: reading defs with filters %v...", s, fs)
+	start := time.Now()
 	f, err := s.fs.Open(unitDefsFilename)
 	if err != nil {
 		return nil, err
@@ -469,15 +587,30 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 		}
 	}()
 
-	dec := Codec.NewDecoder(f)
+	var n int
+	defer func() {
+		if err == nil {
+			explainStep(s, "scan", start, "read all %d defs; %d selected by filters %v", n, len(defs), fs)
+		}
+	}()
+
+	dec := s.codec.NewDecoder(f)
 	for {
 		def := &graph.Def{}
-		if _, err := dec.Decode(def); err == io.EOF {
+		o, err := dec.Decode(def)
+		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
+		if err := ctl.scanned(o); err != nil {
+			return nil, err
+		}
+		n++
 		if defFilters(fs).SelectDef(def) {
+			if err := ctl.selected(1); err != nil {
+				return nil, err
+			}
 			defs = append(defs, def)
 		}
 	}
@@ -494,6 +627,11 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 // defsAtOffsets reads the defs at the given serialized byte offsets
 // from the def data file and returns them in arbitrary order.
 func (s *fsUnitStore) defsAtOffsets(ofs byteOffsets, fs []DefFilter) (defs []*graph.Def, err error) {
+	ctl := getQueryControls(fs)
+	if err := ctl.unitScanned(); err != nil {
+		return nil, err
+	}
+
 	vlog.Printf("%s: reading defs at %d offsets with filters %v...", s, len(ofs), fs)
 	f, err := openFetcherOrOpen(s.fs, unitDefsFilename)
 	if err != nil {
@@ -507,42 +645,36 @@ func (s *fsUnitStore) defsAtOffsets(ofs byteOffsets, fs []DefFilter) (defs []*gr
 	}()
 
 	ffs := defFilters(fs)
-
-	p := parFetches(s.fs, fs)
-	if p == 0 {
-		return nil, nil
+	if getSampler(fs) != nil {
+		// Only read the sampled defs.
+		ofs, ffs = sampleOffsets(ofs, fs), defFiltersWithoutSampler(fs)
 	}
 
-	var defsLock sync.Mutex
-	par := parallel.NewRun(p)
-	for _, ofs_ := range ofs {
-		ofs := ofs_
-		par.Do(func() error {
-			if _, moreOK := LimitRemaining(fs); !moreOK {
-				return nil
-			}
+	// Guess how many bytes each def is. The s3vfs (if that's the VFS
+	// impl in use) will autofetch beyond that if needed.
+	const byteEstimate = 2 * decodeBufSize
 
-			// Guess how many bytes this def is. The s3vfs (if that's the
-			// VFS impl in use) will autofetch beyond that if needed.
-			const byteEstimate = 2 * decodeBufSize
-			r, err := rangeReader(s.fs, unitDefsFilename, f, ofs, byteEstimate)
-			if err != nil {
-				return err
-			}
-			dec := Codec.NewDecoder(r)
-			var def graph.Def
-			if _, err := dec.Decode(&def); err != nil {
+	var defsLock sync.Mutex
+	err = readAtOffsets(s.fs, unitDefsFilename, f, ofs, byteEstimate, fs, func(r io.Reader) error {
+		var def graph.Def
+		n, err := s.codec.NewDecoder(r).Decode(&def)
+		if err != nil {
+			return err
+		}
+		if err := ctl.scanned(n); err != nil {
+			return err
+		}
+		if ffs.SelectDef(&def) {
+			if err := ctl.selected(1); err != nil {
 				return err
 			}
-			if ffs.SelectDef(&def) {
-				defsLock.Lock()
-				defs = append(defs, &def)
-				defsLock.Unlock()
-			}
-			return nil
-		})
-	}
-	if err := par.Wait(); err != nil {
+			defsLock.Lock()
+			defs = append(defs, &def)
+			defsLock.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
 		return defs, err
 	}
 	sort.Sort(graph.Defs(defs))
@@ -566,7 +698,7 @@ func (s *fsUnitStore) readDefs() (defs []*graph.Def, ofs byteOffsets, err error)
 	}()
 
 	n := uint64(0)
-	dec := Codec.NewDecoder(f)
+	dec := s.codec.NewDecoder(f)
 	for {
 		var def graph.Def
 		o, err := dec.Decode(&def)
@@ -586,7 +718,13 @@ func (s *fsUnitStore) readDefs() (defs []*graph.Def, ofs byteOffsets, err error)
 }
 
 func (s *fsUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
+	ctl := getQueryControls(fs)
+	if err := ctl.unitScanned(); err != nil {
+		return nil, err
+	}
+
 	vlog.Printf("%s: reading refs with filters %v...", s, fs)
+	start := time.Now()
 	f, err := s.fs.Open(unitRefsFilename)
 	if err != nil {
 		return nil, err
@@ -598,25 +736,40 @@ func (s *fsUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
 		}
 	}()
 
-	dec := Codec.NewDecoder(f)
+	var n int
+	dec := s.codec.NewDecoder(f)
 	for {
 		var ref graph.Ref
-		if _, err := dec.Decode(&ref); err == io.EOF {
+		o, err := dec.Decode(&ref)
+		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
+		if err := ctl.scanned(o); err != nil {
+			return nil, err
+		}
+		n++
 		if refFilters(fs).SelectRef(&ref) {
+			if err := ctl.selected(1); err != nil {
+				return nil, err
+			}
 			refs = append(refs, &ref)
 		}
 	}
 	vlog.Printf("%s: read %d refs with filters %v.", s, len(refs), fs)
+	explainStep(s, "scan", start, "read all %d refs; %d selected by filters %v", n, len(refs), fs)
 	return refs, nil
 }
 
 // refsAtByteRanges reads the refs at the given serialized byte ranges
 // from the ref data file and returns them in arbitrary order.
 func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs []*graph.Ref, err error) {
+	ctl := getQueryControls(fs)
+	if err := ctl.unitScanned(); err != nil {
+		return nil, err
+	}
+
 	vlog.Printf("%s: reading refs at %d byte ranges with filters %v...", s, len(brs), fs)
 	f, err := openFetcherOrOpen(s.fs, unitRefsFilename)
 	if err != nil {
@@ -657,18 +810,28 @@ func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs [
 			if _, moreOK := LimitRemaining(fs); !moreOK {
 				return nil
 			}
+			if err := ctl.check(); err != nil {
+				return err
+			}
 
 			r, err := rangeReader(s.fs, unitRefsFilename, f, br.start(), readLengths[i])
 			if err != nil {
 				return err
 			}
-			dec := Codec.NewDecoder(r)
+			dec := s.codec.NewDecoder(r)
 			for range br[1:] {
 				var ref graph.Ref
-				if _, err := dec.Decode(&ref); err != nil {
+				n, err := dec.Decode(&ref)
+				if err != nil {
+					return err
+				}
+				if err := ctl.scanned(n); err != nil {
 					return err
 				}
 				if ffs.SelectRef(&ref) {
+					if err := ctl.selected(1); err != nil {
+						return err
+					}
 					refsLock.Lock()
 					refs = append(refs, &ref)
 					refsLock.Unlock()
@@ -677,7 +840,7 @@ func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs [
 			return nil
 		})
 	}
-	if err := par.Wait(); err != nil {
+	if err := ctl.err(par.Wait()); err != nil {
 		return refs, err
 	}
 	sort.Sort(refsByFileStartEnd(refs))
@@ -688,6 +851,11 @@ func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs [
 // refsAtOffsets reads the refs at the given serialized byte offsets
 // from the ref data file and returns them in arbitrary order.
 func (s *fsUnitStore) refsAtOffsets(ofs byteOffsets, fs []RefFilter) (refs []*graph.Ref, err error) {
+	ctl := getQueryControls(fs)
+	if err := ctl.unitScanned(); err != nil {
+		return nil, err
+	}
+
 	vlog.Printf("%s: reading refs at %d offsets with filters %v...", s, len(ofs), fs)
 	f, err := openFetcherOrOpen(s.fs, unitRefsFilename)
 	if err != nil {
@@ -701,42 +869,36 @@ func (s *fsUnitStore) refsAtOffsets(ofs byteOffsets, fs []RefFilter) (refs []*gr
 	}()
 
 	ffs := refFilters(fs)
-
-	p := parFetches(s.fs, fs)
-	if p == 0 {
-		return nil, nil
+	if getSampler(fs) != nil {
+		// Only read the sampled refs.
+		ofs, ffs = sampleOffsets(ofs, fs), refFiltersWithoutSampler(fs)
 	}
 
-	var refsLock sync.Mutex
-	par := parallel.NewRun(p)
-	for _, ofs_ := range ofs {
-		ofs := ofs_
-		par.Do(func() error {
-			if _, moreOK := LimitRemaining(fs); !moreOK {
-				return nil
-			}
+	// Guess how many bytes each ref is. The s3vfs (if that's the VFS
+	// impl in use) will autofetch beyond that if needed.
+	const byteEstimate = decodeBufSize
 
-			// Guess how many bytes this ref is. The s3vfs (if that's the
-			// VFS impl in use) will autofetch beyond that if needed.
-			const byteEstimate = decodeBufSize
-			r, err := rangeReader(s.fs, unitRefsFilename, f, ofs, byteEstimate)
-			if err != nil {
-				return err
-			}
-			dec := Codec.NewDecoder(r)
-			var ref graph.Ref
-			if _, err := dec.Decode(&ref); err != nil {
+	var refsLock sync.Mutex
+	err = readAtOffsets(s.fs, unitRefsFilename, f, ofs, byteEstimate, fs, func(r io.Reader) error {
+		var ref graph.Ref
+		n, err := s.codec.NewDecoder(r).Decode(&ref)
+		if err != nil {
+			return err
+		}
+		if err := ctl.scanned(n); err != nil {
+			return err
+		}
+		if ffs.SelectRef(&ref) {
+			if err := ctl.selected(1); err != nil {
 				return err
 			}
-			if ffs.SelectRef(&ref) {
-				refsLock.Lock()
-				refs = append(refs, &ref)
-				refsLock.Unlock()
-			}
-			return nil
-		})
-	}
-	if err := par.Wait(); err != nil {
+			refsLock.Lock()
+			refs = append(refs, &ref)
+			refsLock.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
 		return refs, err
 	}
 	sort.Sort(refsByFileStartEnd(refs))
@@ -744,10 +906,8 @@ func (s *fsUnitStore) refsAtOffsets(ofs byteOffsets, fs []RefFilter) (refs []*gr
 	return refs, nil
 }
 
-const maxNetPar = 4
-
 // parFetches returns the number of parallel fetches that should be
-// attempted given the VFS and filters.
+// attempted given the VFS and filters (see NetFetch.Parallel).
 func parFetches(fs rwvfs.FileSystem, filters interface{}) int {
 	// It's almost always faster to read local files serially
 	// (FetcherOpener is currently only implemented by network VFSs).
@@ -755,36 +915,49 @@ func parFetches(fs rwvfs.FileSystem, filters interface{}) int {
 		return 1
 	}
 
+	maxPar := NetFetch.Parallel
+	if maxPar < 1 {
+		maxPar = 1
+	}
 	n, moreOK := LimitRemaining(filters)
 	if moreOK {
 		if n == 0 {
-			return maxNetPar
+			return maxPar
 		}
-		return min(maxNetPar, n)
+		return min(maxPar, n)
 	}
 	return 0
 }
 
 // openFetcher calls fs.OpenFetcher if it implemented the
-// FetcherOpener interface; otherwise it calls fs.Open.
+// FetcherOpener interface (retrying on failure; see NetFetch);
+// otherwise it calls fs.Open.
 func openFetcherOrOpen(fs rwvfs.FileSystem, name string) (vfs.ReadSeekCloser, error) {
 	if fo, ok := fs.(rwvfs.FetcherOpener); ok {
-		return fo.OpenFetcher(name)
+		var f vfs.ReadSeekCloser
+		err := withNetRetries(fs, func() (err error) {
+			f, err = fo.OpenFetcher(name)
+			return
+		})
+		return f, err
 	}
 	return fs.Open(name)
 }
 
 // rangeReader calls ioutil.ReadAll on the given byte range [start, n). It uses
 // optimizations for different kinds of VFSs.
-func rangeReader(fs rwvfs.FileSystem, name string, f io.ReadSeeker, start, n int64) (io.Reader, error) {
-	if fs, ok := fs.(rwvfs.FetcherOpener); ok {
+func rangeReader(fs rwvfs.FileSystem, name string, f io.ReadSeeker, start, n int64) (io.ReadSeeker, error) {
+	if _, ok := fs.(rwvfs.FetcherOpener); ok {
 		// Clone f so we can parallelize it.
-		var err error
-		f, err = fs.OpenFetcher(name)
+		fc, err := openFetcherOrOpen(fs, name)
 		if err != nil {
 			return nil, err
 		}
-		if err := f.(rwvfs.Fetcher).Fetch(start, start+n); err != nil {
+		f = fc
+		err = withNetRetries(fs, func() error {
+			return f.(rwvfs.Fetcher).Fetch(start, start+n)
+		})
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -810,7 +983,7 @@ func (s *fsUnitStore) readRefs() (refs []*graph.Ref, fbrs fileByteRanges, ofs by
 	}()
 
 	o := int64(0)
-	dec := Codec.NewDecoder(f)
+	dec := s.codec.NewDecoder(f)
 	fbrs = fileByteRanges{}
 	lastFile := ""
 	for {
@@ -850,6 +1023,7 @@ func (s *fsUnitStore) readRefs() (refs []*graph.Ref, fbrs fileByteRanges, ofs by
 }
 
 func (s *fsUnitStore) Import(data graph.Output) error {
+	attachDocs(&data)
 	cleanForImport(&data, "", "", "")
 	if _, err := s.writeDefs(data.Defs); err != nil {
 		return err
@@ -857,6 +1031,12 @@ func (s *fsUnitStore) Import(data graph.Output) error {
 	if _, _, err := s.writeRefs(data.Refs); err != nil {
 		return err
 	}
+	if _, err := s.writeCalls(data.Calls); err != nil {
+		return err
+	}
+	if err := s.writeRelations(data.Relations); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -877,7 +1057,7 @@ func (s *fsUnitStore) writeDefs(defs []*graph.Def) (ofs byteOffsets, err error)
 	}()
 
 	bw := bufio.NewWriter(f)
-	enc := Codec.NewEncoder(bw)
+	enc := s.codec.NewEncoder(bw)
 	ofs = make(byteOffsets, len(defs))
 	var o uint64 // number of bytes read
 	for i, def := range defs {
@@ -919,7 +1099,7 @@ func (s *fsUnitStore) writeRefs(refs []*graph.Ref) (fbr fileByteRanges, ofs byte
 	}
 
 	bw := bufio.NewWriter(f)
-	enc := Codec.NewEncoder(bw)
+	enc := s.codec.NewEncoder(bw)
 	var o uint64
 	fbr = fileByteRanges{}
 	ofs = make(byteOffsets, len(refs))
@@ -5,14 +5,14 @@ import "testing"
 func TestFSUnitStore(t *testing.T) {
 	useIndexedStore = false
 	testUnitStore(t, func() UnitStoreImporter {
-		return &fsUnitStore{fs: newTestFS()}
+		return &fsUnitStore{fs: newTestFS(), codec: Codec}
 	})
 }
 
 func TestFSTreeStore(t *testing.T) {
 	useIndexedStore = false
 	testTreeStore(t, func() TreeStoreImporter {
-		return newFSTreeStore(newTestFS())
+		return newFSTreeStore(newTestFS(), Codec)
 	})
 }
 
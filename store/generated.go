@@ -0,0 +1,101 @@
+package store
+
+import (
+	"path"
+	"regexp"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// generatedPathPatterns match the base names of files that are
+// generated by common tools (protoc, gRPC, go generate, bundlers,
+// etc.).
+var generatedPathPatterns = []string{
+	"*.pb.go", "*.pb.gw.go", "*_generated.go", "*.gen.go", "zz_generated.*.go", "bindata.go",
+	"*_pb2.py", "*_pb2_grpc.py",
+	"*.pb.cc", "*.pb.h", "*.grpc.pb.cc", "*.grpc.pb.h",
+	"*_pb.js", "*_grpc_pb.js", "*.min.js",
+	"*.Designer.cs", "*.g.cs", "*.g.i.cs",
+}
+
+// IsGeneratedPath reports whether file's name matches a pattern of
+// the names of generated files (such as "*.pb.go").
+func IsGeneratedPath(file string) bool {
+	base := path.Base(file)
+	for _, pat := range generatedPathPatterns {
+		if ok, _ := path.Match(pat, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GeneratedHeaderSize is the number of bytes at the beginning of a
+// file that IsGeneratedHeader needs to see.
+const GeneratedHeaderSize = 1024
+
+// generatedHeaderMarkers match the comments that code generators
+// emit near the top of generated files.
+var generatedHeaderMarkers = []*regexp.Regexp{
+	// Go (see https://golang.org/s/generatedcode).
+	regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`),
+
+	// "@generated" (used by many Python, JavaScript, C++, and Thrift
+	// tools).
+	regexp.MustCompile(`(?m)^\s*(//|#|/?\*).*@generated\b`),
+
+	// protoc's and many other tools' "DO NOT EDIT" banners.
+	regexp.MustCompile(`(?m)^\s*(//|#|/?\*|--|<!--).*\bDO NOT EDIT\b`),
+
+	// C#'s <auto-generated> tags and other "auto-generated" comments.
+	regexp.MustCompile(`(?m)^\s*(//|#|/?\*|<!--).*(?i:<auto-generated|\bauto-?generated\b|\bautomatically generated\b)`),
+}
+
+// IsGeneratedHeader reports whether head, the beginning of a file
+// (usually its first GeneratedHeaderSize bytes), contains a comment
+// that marks the file as generated.
+func IsGeneratedHeader(head []byte) bool {
+	if len(head) > GeneratedHeaderSize {
+		head = head[:GeneratedHeaderSize]
+	}
+	for _, re := range generatedHeaderMarkers {
+		if re.Match(head) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagGenerated sets the Generated field of the defs and refs in data
+// that are in files for which isGenerated returns true (it is called
+// once per file). It returns the generated files that it found.
+func TagGenerated(data *graph.Output, isGenerated func(file string) bool) []string {
+	gen := map[string]bool{}
+	var genFiles []string
+	check := func(file string) bool {
+		if file == "" {
+			return false
+		}
+		g, seen := gen[file]
+		if !seen {
+			g = isGenerated(file)
+			gen[file] = g
+			if g {
+				genFiles = append(genFiles, file)
+			}
+		}
+		return g
+	}
+
+	for _, def := range data.Defs {
+		if check(def.File) {
+			def.Generated = true
+		}
+	}
+	for _, ref := range data.Refs {
+		if check(ref.File) {
+			ref.Generated = true
+		}
+	}
+	return genFiles
+}
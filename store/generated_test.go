@@ -0,0 +1,89 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestIsGeneratedPath(t *testing.T) {
+	tests := map[string]bool{
+		"a/b/foo.pb.go":              true,
+		"foo_pb2.py":                 true,
+		"x/zz_generated.deepcopy.go": true,
+		"dist/app.min.js":            true,
+		"foo.go":                     false,
+		"pb.go":                      false,
+		"foo_pb2.pyc.txt":            false,
+	}
+	for file, want := range tests {
+		if got := IsGeneratedPath(file); got != want {
+			t.Errorf("%s: got %v, want %v", file, got, want)
+		}
+	}
+}
+
+func TestIsGeneratedHeader(t *testing.T) {
+	tests := map[string]bool{
+		"// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage x\n":                        true,
+		"// Copyright 2015\n\n// Code generated by stringer -type=T; DO NOT EDIT.\n":             true,
+		"# Generated by the protocol buffer compiler.  DO NOT EDIT!\n":                           true,
+		"/**\n * @generated\n */\n":                                                              true,
+		"// <auto-generated>\n//     This code was generated by a tool.\n// </auto-generated>\n": true,
+		"package x\n\n// Code generated here is fine.\n":                                         false,
+		"package x\n\nconst s = \"DO NOT EDIT\"\n":                                               false,
+		"": false,
+	}
+	for head, want := range tests {
+		if got := IsGeneratedHeader([]byte(head)); got != want {
+			t.Errorf("%q: got %v, want %v", head, got, want)
+		}
+	}
+}
+
+func TestTagGenerated(t *testing.T) {
+	useIndexedStore = false
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "a"}, File: "a.go"},
+			{DefKey: graph.DefKey{Path: "b"}, File: "b.pb.go"},
+		},
+		Refs: []*graph.Ref{
+			{DefPath: "a", File: "a.go", Start: 1, End: 2},
+			{DefPath: "b", File: "b.pb.go", Start: 1, End: 2},
+			{DefPath: "b", File: "b.pb.go", Start: 3, End: 4},
+		},
+	}
+	calls := 0
+	files := TagGenerated(&data, func(file string) bool {
+		calls++
+		return IsGeneratedPath(file)
+	})
+	if want := []string{"b.pb.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got generated files %v, want %v", files, want)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls to isGenerated, want 2 (1 per file)", calls)
+	}
+
+	rs := NewFSRepoStore(newTestFS())
+	if err := rs.Import("c", &unit.SourceUnit{Type: "t", Name: "u"}, data); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := rs.Defs(ExcludeGenerated())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Path != "a" {
+		t.Errorf("got defs %v, want only def a", defs)
+	}
+	refs, err := rs.Refs(ExcludeGenerated())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].File != "a.go" {
+		t.Errorf("got refs %v, want only the ref in a.go", refs)
+	}
+}
@@ -0,0 +1,414 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A RefLocation is a file (in a source unit in a repository at a
+// specific commit) that contains refs to a def.
+type RefLocation struct {
+	Repo     string
+	CommitID string
+	UnitType string
+	Unit     string
+	File     string
+
+	// Refs is the number of refs to the def in the file.
+	Refs int
+}
+
+// A GlobalRefStore finds the locations of the refs to a def in all
+// repositories ("find all usages"). It is implemented by the
+// FS-backed and in-memory MultiRepoStores.
+//
+// The FS-backed store uses the global ref index, which maps the defs
+// in each repository to the locations in other repositories that
+// refer to them. The index is updated incrementally whenever a
+// repository's commit is indexed (see GlobalRefIndexer), so that
+// finding the usages of a def doesn't require scanning the ref data
+// of every repository.
+type GlobalRefStore interface {
+	// RefLocations returns the locations of the refs to def (in its
+	// own repository and in others), sorted by repo, commit, source
+	// unit, and file. The def's DefRepo, DefUnitType, DefUnit, and
+	// DefPath must be set.
+	RefLocations(def graph.RefDefKey) ([]*RefLocation, error)
+
+	// CrossRepoRefCounts returns the number of refs to each def in
+	// defRepo from other repositories, sorted by def. The returned
+	// DefRefCounts' Repo is defRepo, their CommitID is empty (refs in
+	// other repositories don't specify the def's commit), and only
+	// their CrossRepoRefs and CrossRepos fields are set.
+	CrossRepoRefCounts(defRepo string) ([]*DefRefCount, error)
+}
+
+// A GlobalRefIndexer updates a MultiRepoStore's global ref index
+// with the refs to defs in other repositories that are in a
+// repository at a specific commit. The FS-backed MultiRepoStore's
+// Index method calls it after building the commit's indexes.
+//
+// Commits that were imported before the global ref index existed
+// must be indexed again (see "src store index --global-refs") before
+// their refs are found by the index.
+type GlobalRefIndexer interface {
+	IndexGlobalRefs(repo, commitID string) error
+}
+
+// globalRefsDir is the dir (at the root of a FS-backed
+// MultiRepoStore) that holds the global ref index. It begins with a
+// "." so that DefaultRepoPaths skips it when listing repos, and
+// fsMultiRepoStore.repos skips it explicitly in case custom RepoPaths
+// list it.
+//
+// The refs to the defs in a repository DEFREPO that are in REPO at
+// COMMIT are stored in the file
+// "by-def-repo/DEFREPO/REPO@COMMIT.json" (with each path component
+// query-escaped). The file "by-source/REPO@COMMIT.json" lists the
+// DEFREPOs that REPO at COMMIT refers to, so that stale files can be
+// removed when the commit is reindexed.
+const globalRefsDir = ".srclib-global-refs"
+
+// A globalRefEntry is a RefLocation of the refs to a def, as stored
+// in the global ref index (relative to the DEFREPO, REPO, and COMMIT
+// in the file's name).
+type globalRefEntry struct {
+	DefUnitType string
+	DefUnit     string
+	DefPath     string
+	UnitType    string
+	Unit        string
+	File        string
+	Refs        int
+}
+
+func (e *globalRefEntry) refersTo(def graph.RefDefKey) bool {
+	return e.DefUnitType == def.DefUnitType && e.DefUnit == def.DefUnit && e.DefPath == def.DefPath
+}
+
+type globalRefEntries []*globalRefEntry
+
+func (v globalRefEntries) Len() int      { return len(v) }
+func (v globalRefEntries) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v globalRefEntries) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ak := []string{a.DefUnitType, a.DefUnit, a.DefPath, a.UnitType, a.Unit, a.File}
+	bk := []string{b.DefUnitType, b.DefUnit, b.DefPath, b.UnitType, b.Unit, b.File}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+func globalRefsSourceFilename(repo, commitID string) string {
+	return url.QueryEscape(repo) + "@" + url.QueryEscape(commitID) + ".json"
+}
+
+// parseGlobalRefsSourceFilename is the inverse of
+// globalRefsSourceFilename.
+func parseGlobalRefsSourceFilename(name string) (repo, commitID string, err error) {
+	i := strings.LastIndex(name, "@")
+	if i == -1 || !strings.HasSuffix(name, ".json") {
+		return "", "", fmt.Errorf("bad global ref index filename %q", name)
+	}
+	if repo, err = url.QueryUnescape(name[:i]); err != nil {
+		return "", "", err
+	}
+	if commitID, err = url.QueryUnescape(strings.TrimSuffix(name[i+1:], ".json")); err != nil {
+		return "", "", err
+	}
+	return repo, commitID, nil
+}
+
+func (s *fsMultiRepoStore) globalRefsDefRepoDir(defRepo string) string {
+	return s.fs.Join(globalRefsDir, "by-def-repo", url.QueryEscape(defRepo))
+}
+
+func (s *fsMultiRepoStore) globalRefsSourcesDir() string {
+	return s.fs.Join(globalRefsDir, "by-source")
+}
+
+// hasGlobalRefs returns whether the global ref index has been
+// created (by indexing any commit).
+func (s *fsMultiRepoStore) hasGlobalRefs() (bool, error) {
+	if _, err := s.fs.Stat(globalRefsDir); isOSOrVFSNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IndexGlobalRefs implements GlobalRefIndexer.
+func (s *fsMultiRepoStore) IndexGlobalRefs(repo, commitID string) error {
+	repo = s.canonicalRepo(repo)
+	vlog.Printf("fsMultiRepoStore.IndexGlobalRefs(%s, %s)", repo, commitID)
+
+	// Refs to defs in the same repo have an empty DefRepo.
+	refs, err := s.openRepoStore(repo).Refs(ByCommitIDs(commitID), RefFilterFunc(func(ref *graph.Ref) bool {
+		return ref.DefRepo != "" && ref.DefRepo != repo
+	}), Unordered())
+	if err != nil && !isStoreNotExist(err) {
+		return err
+	}
+
+	byDefRepo := map[string]map[globalRefEntry]int{}
+	for _, ref := range refs {
+		e := globalRefEntry{
+			DefUnitType: ref.DefUnitType,
+			DefUnit:     ref.DefUnit,
+			DefPath:     ref.DefPath,
+			UnitType:    ref.UnitType,
+			Unit:        ref.Unit,
+			File:        ref.File,
+		}
+		if byDefRepo[ref.DefRepo] == nil {
+			byDefRepo[ref.DefRepo] = map[globalRefEntry]int{}
+		}
+		byDefRepo[ref.DefRepo][e]++
+	}
+
+	name := globalRefsSourceFilename(repo, commitID)
+	sourceFile := s.fs.Join(s.globalRefsSourcesDir(), name)
+
+	// Remove the entries for the def repos that the commit no longer
+	// refers to (if it was indexed before).
+	var prevDefRepos []string
+	if err := readJSONFile(s.fs, sourceFile, &prevDefRepos); err != nil && !isOSOrVFSNotExist(err) {
+		return err
+	}
+	for _, defRepo := range prevDefRepos {
+		if _, present := byDefRepo[defRepo]; present {
+			continue
+		}
+		if err := s.fs.Remove(s.fs.Join(s.globalRefsDefRepoDir(defRepo), name)); err != nil && !isOSOrVFSNotExist(err) {
+			return err
+		}
+	}
+
+	defRepos := make([]string, 0, len(byDefRepo))
+	for defRepo, counts := range byDefRepo {
+		entries := make(globalRefEntries, 0, len(counts))
+		for e, n := range counts {
+			e := e
+			e.Refs = n
+			entries = append(entries, &e)
+		}
+		sort.Sort(entries)
+		dir := s.globalRefsDefRepoDir(defRepo)
+		if err := rwvfs.MkdirAll(s.fs, dir); err != nil {
+			return err
+		}
+		if err := writeJSONFile(s.fs, s.fs.Join(dir, name), entries); err != nil {
+			return err
+		}
+		defRepos = append(defRepos, defRepo)
+	}
+	sort.Strings(defRepos)
+	if err := rwvfs.MkdirAll(s.fs, s.globalRefsSourcesDir()); err != nil {
+		return err
+	}
+	vlog.Printf("fsMultiRepoStore.IndexGlobalRefs(%s, %s): indexed %d cross-repo refs to defs in %d repos.", repo, commitID, len(refs), len(defRepos))
+	return writeJSONFile(s.fs, sourceFile, defRepos)
+}
+
+// globalRefLocations returns the locations of the refs to def (in
+// repos other than def.DefRepo) that are in the global ref index. Refs
+// that were imported before def.DefRepo was configured as an alias
+// refer to the alias, so its aliases' entries are also consulted.
+func (s *fsMultiRepoStore) globalRefLocations(def graph.RefDefKey) ([]*RefLocation, error) {
+	var locs []*RefLocation
+	for _, defRepo := range append([]string{def.DefRepo}, s.repoAliasesOf(def.DefRepo)...) {
+		dir := s.globalRefsDefRepoDir(defRepo)
+		fis, err := s.fs.ReadDir(dir)
+		if isOSOrVFSNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			repo, commitID, err := parseGlobalRefsSourceFilename(fi.Name())
+			if err != nil {
+				return nil, err
+			}
+			if !s.inScope(repo) {
+				continue
+			}
+			var entries globalRefEntries
+			if err := readJSONFile(s.fs, s.fs.Join(dir, fi.Name()), &entries); err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.refersTo(def) {
+					locs = append(locs, &RefLocation{Repo: repo, CommitID: commitID, UnitType: e.UnitType, Unit: e.Unit, File: e.File, Refs: e.Refs})
+				}
+			}
+		}
+	}
+	return locs, nil
+}
+
+// globalRefsScope returns a filter that restricts a query for the
+// refs to a def (specified by a ByRefDef filter with a DefRepo in f)
+// to the def's repo and the repos that the global ref index says
+// refer to the def. If f has no such filter, or if the global ref
+// index has not been created, it returns nil.
+func (s *fsMultiRepoStore) globalRefsScope(f []RefFilter) (RefFilter, error) {
+	var def *graph.RefDefKey
+	for _, ff := range f {
+		if rd, ok := ff.(*byRefDefFilter); ok && rd.def.DefRepo != "" {
+			def = &rd.def
+			break
+		}
+	}
+	if def == nil {
+		return nil, nil
+	}
+	if ok, err := s.hasGlobalRefs(); err != nil || !ok {
+		return nil, err
+	}
+
+	start := time.Now()
+	locs, err := s.globalRefLocations(*def)
+	if err != nil {
+		return nil, err
+	}
+	repos := []string{def.DefRepo}
+	seen := map[string]struct{}{def.DefRepo: struct{}{}}
+	for _, loc := range locs {
+		if _, present := seen[loc.Repo]; !present {
+			repos = append(repos, loc.Repo)
+			seen[loc.Repo] = struct{}{}
+		}
+	}
+	vlog.Printf("fsMultiRepoStore.globalRefsScope(%v): Global ref index narrowed query to %d repos.", f, len(repos))
+	explainStep(s, "index", start, "global ref index scoped query for refs to %s %s %s %s to %d repos", def.DefRepo, def.DefUnitType, def.DefUnit, def.DefPath, len(repos))
+	return ByRepos(repos...), nil
+}
+
+// RefLocations implements GlobalRefStore.
+func (s *fsMultiRepoStore) RefLocations(def graph.RefDefKey) ([]*RefLocation, error) {
+	if def.DefRepo == "" {
+		return nil, fmt.Errorf("RefLocations: def.DefRepo: empty")
+	}
+	def.DefRepo = s.canonicalRepo(def.DefRepo)
+
+	// Refs from within the def's repo aren't in the global ref index.
+	// They are only returned if the def's repo is in scope (see
+	// FSMultiRepoStoreConf.Scope); globalRefLocations skips the
+	// out-of-scope repos that refer to the def.
+	var locs []*RefLocation
+	if s.inScope(def.DefRepo) {
+		refs, err := s.repoStores.Refs(ByRepos(def.DefRepo), ByRefDef(def), Unordered())
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		locs = refLocations(refs)
+	}
+
+	xlocs, err := s.globalRefLocations(def)
+	if err != nil {
+		return nil, err
+	}
+	locs = append(locs, xlocs...)
+	sort.Sort(refLocationsByKey(locs))
+	return locs, nil
+}
+
+// RefLocations implements GlobalRefStore. The in-memory store has no
+// index, so it scans the refs of all repos.
+func (s *memoryMultiRepoStore) RefLocations(def graph.RefDefKey) ([]*RefLocation, error) {
+	if def.DefRepo == "" {
+		return nil, fmt.Errorf("RefLocations: def.DefRepo: empty")
+	}
+	refs, err := s.Refs(ByRefDef(def), Unordered())
+	if err != nil && !isStoreNotExist(err) {
+		return nil, err
+	}
+	locs := refLocations(refs)
+	sort.Sort(refLocationsByKey(locs))
+	return locs, nil
+}
+
+// refLocations groups refs (which must have their Repo, CommitID,
+// UnitType, and Unit fields set) by location.
+func refLocations(refs []*graph.Ref) []*RefLocation {
+	type key struct {
+		repo, commitID string
+		unit           unit.ID2
+		file           string
+	}
+	byKey := map[key]*RefLocation{}
+	var locs []*RefLocation
+	for _, ref := range refs {
+		k := key{ref.Repo, ref.CommitID, unit.ID2{Type: ref.UnitType, Name: ref.Unit}, ref.File}
+		loc, present := byKey[k]
+		if !present {
+			loc = &RefLocation{Repo: ref.Repo, CommitID: ref.CommitID, UnitType: ref.UnitType, Unit: ref.Unit, File: ref.File}
+			byKey[k] = loc
+			locs = append(locs, loc)
+		}
+		loc.Refs++
+	}
+	return locs
+}
+
+type refLocationsByKey []*RefLocation
+
+func (v refLocationsByKey) Len() int      { return len(v) }
+func (v refLocationsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v refLocationsByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ak := []string{a.Repo, a.CommitID, a.UnitType, a.Unit, a.File}
+	bk := []string{b.Repo, b.CommitID, b.UnitType, b.Unit, b.File}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+func readJSONFile(fs rwvfs.FileSystem, name string, v interface{}) (err error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	return json.NewDecoder(f).Decode(v)
+}
+
+func writeJSONFile(fs rwvfs.FileSystem, name string, v interface{}) (err error) {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	return json.NewEncoder(f).Encode(v)
+}
+
+var (
+	_ GlobalRefStore   = (*fsMultiRepoStore)(nil)
+	_ GlobalRefStore   = (*memoryMultiRepoStore)(nil)
+	_ GlobalRefIndexer = (*fsMultiRepoStore)(nil)
+)
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_RefLocations(t *testing.T) {
+	testMultiRepoStore_RefLocations(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_RefLocations(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_RefLocations(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_RefLocations(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_RefLocations(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_RefLocations(t *testing.T, mrs MultiRepoStoreImporter) {
+	lib := graph.RefDefKey{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p"}
+	imports := []struct {
+		repo, commitID string
+		data           graph.Output
+	}{
+		{"lib", "c1", graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, File: "f"}},
+			Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+		}},
+		{"app", "c1", graph.Output{Refs: []*graph.Ref{
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "g", Start: 1, End: 2},
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "g", Start: 3, End: 4},
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "q", File: "h", Start: 1, End: 2},
+		}}},
+		{"app", "c2", graph.Output{Refs: []*graph.Ref{
+			{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "h", Start: 1, End: 2},
+		}}},
+		{"other", "c1", graph.Output{Refs: []*graph.Ref{
+			{DefRepo: "x", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "g", Start: 1, End: 2},
+		}}},
+	}
+	for _, imp := range imports {
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f", "g", "h"}}
+		if err := mrs.Import(imp.repo, imp.commitID, u, imp.data); err != nil {
+			t.Fatalf("%s: Import(%s, %s): %s", mrs, imp.repo, imp.commitID, err)
+		}
+		if mrs, ok := mrs.(MultiRepoIndexer); ok {
+			if err := mrs.Index(imp.repo, imp.commitID); err != nil {
+				t.Fatalf("%s: Index(%s, %s): %s", mrs, imp.repo, imp.commitID, err)
+			}
+		}
+	}
+
+	grs, ok := mrs.(GlobalRefStore)
+	if !ok {
+		t.Fatalf("%s: does not implement GlobalRefStore", mrs)
+	}
+	locs, err := grs.RefLocations(lib)
+	if err != nil {
+		t.Fatalf("%s: RefLocations: %s", mrs, err)
+	}
+	want := []*RefLocation{
+		{Repo: "app", CommitID: "c1", UnitType: "t", Unit: "u", File: "g", Refs: 2},
+		{Repo: "app", CommitID: "c2", UnitType: "t", Unit: "u", File: "h", Refs: 1},
+		{Repo: "lib", CommitID: "c1", UnitType: "t", Unit: "u", File: "f", Refs: 1},
+	}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("%s: RefLocations: got %v, want %v", mrs, locs, want)
+	}
+
+	// The global ref index doesn't change the result of ref queries.
+	refs, err := mrs.Refs(ByRefDef(lib))
+	if err != nil {
+		t.Fatalf("%s: Refs: %s", mrs, err)
+	}
+	if want := 4; len(refs) != want {
+		t.Errorf("%s: Refs: got %d refs, want %d", mrs, len(refs), want)
+	}
+
+	// Reimporting (and reindexing) a commit replaces its entries.
+	if err := mrs.Import("app", "c1", &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"g"}}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	if mrs, ok := mrs.(MultiRepoIndexer); ok {
+		if err := mrs.Index("app", "c1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locs, err = grs.RefLocations(lib)
+	if err != nil {
+		t.Fatalf("%s: RefLocations after reimport: %s", mrs, err)
+	}
+	if want := want[1:]; !reflect.DeepEqual(locs, want) {
+		t.Errorf("%s: RefLocations after reimport: got %v, want %v", mrs, locs, want)
+	}
+}
+
+func TestFSMultiRepoStore_globalRefsScope(t *testing.T) {
+	useIndexedStore = true
+	mrs := NewFSMultiRepoStore(newTestFS(), nil).(*fsMultiRepoStore)
+	for _, repo := range []string{"lib", "app", "other"} {
+		data := graph.Output{Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}}}
+		if repo == "app" {
+			data.Refs[0].DefRepo, data.Refs[0].DefUnitType, data.Refs[0].DefUnit = "lib", "t", "u"
+		}
+		if err := mrs.Import(repo, "c", &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := mrs.Index(repo, "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scope, err := mrs.globalRefsScope([]RefFilter{ByRefDef(graph.RefDefKey{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope == nil {
+		t.Fatal("got no scope, want a ByRepos filter")
+	}
+	if got, want := scope.(ByReposFilter).ByRepos(), []string{"lib", "app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got repos %v, want %v", got, want)
+	}
+}
@@ -166,6 +166,7 @@ func bestCoverageIndex(indexes map[string]Index, filters interface{}, test func(
 func isUnitIndex(x interface{}) bool    { _, ok := x.(unitIndex); return ok }
 func isDefIndex(x interface{}) bool     { _, ok := x.(defIndex); return ok }
 func isDefTreeIndex(x interface{}) bool { _, ok := x.(defTreeIndex); return ok }
+func isCallIndex(x interface{}) bool    { _, ok := x.(callIndex); return ok }
 func isRefIndex(x interface{}) bool {
 	switch x.(type) {
 	case refIndexByteRanges, refIndexByteOffsets:
@@ -257,6 +258,18 @@ type refIndexBuilder interface {
 	Build([]*graph.Ref, fileByteRanges, byteOffsets) error
 }
 
+// callIndexBuilder is implemented by indexes of call edges that are
+// built from a source unit's calls.
+type callIndexBuilder interface {
+	Build([]*graph.Call, byteOffsets) error
+}
+
+// callIndex is implemented by indexes that return the byte offsets
+// (in the call data file) of the calls that match the filters.
+type callIndex interface {
+	Calls(...CallFilter) (byteOffsets, error)
+}
+
 type unitIndex interface {
 	// Units returns the unit IDs units that match the unit filters.
 	Units(...UnitFilter) ([]unit.ID2, error)
@@ -279,6 +292,18 @@ type defQueryTreeIndexBuilder interface {
 	Build(map[unit.ID2]*defQueryIndex) error
 }
 
+type unitRefDefIndexBuilder interface {
+	Build([]*unit.SourceUnit, map[unit.ID2]*defRefsIndex, map[unit.ID2]*defPathIndex) error
+}
+
+// unitDefsIndexBuilder is implemented by tree indexes that are built
+// from the defs of all source units in the tree. The readDefs func
+// reads a source unit's defs and their byte offsets in the unit's def
+// data file.
+type unitDefsIndexBuilder interface {
+	Build(units []*unit.SourceUnit, readDefs func(unit.ID2) ([]*graph.Def, byteOffsets, error)) error
+}
+
 // unitIndexOnlyFilter wraps a non-UnitFilter that can be used by an
 // IndexedUnitStore to scope the list of source units. Currently there
 // is only a RefFilter that does this, so we simplify it by using that
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+
+	"code.google.com/p/rog-go/parallel"
+	"golang.org/x/net/context"
+)
+
+// IndexPriority describes how soon an index should be built relative
+// to other indexes. Indexes that serve interactive queries (such as
+// looking up a def by path or name) have the highest priority, so that
+// a store becomes useful before the long tail of indexes that only
+// speed up less common or batch queries is built.
+type IndexPriority int
+
+const (
+	// LookupIndexPriority is the priority of indexes that are used
+	// to find defs and source units (e.g., by def path, name, or
+	// query, or by file).
+	LookupIndexPriority IndexPriority = iota
+
+	// NavigationIndexPriority is the priority of indexes that are
+	// used to navigate between defs and refs (e.g., to find the refs
+	// to a def, or the refs in a file).
+	NavigationIndexPriority
+
+	// AnalysisIndexPriority is the priority of indexes that are
+	// used for reports and analysis (e.g., call graphs, broken refs,
+	// ref counts, and def history).
+	AnalysisIndexPriority
+)
+
+func (p IndexPriority) String() string {
+	switch p {
+	case LookupIndexPriority:
+		return "lookup"
+	case NavigationIndexPriority:
+		return "navigation"
+	case AnalysisIndexPriority:
+		return "analysis"
+	}
+	return fmt.Sprintf("IndexPriority(%d)", int(p))
+}
+
+// indexPriority returns the build priority of x, based on its type.
+func indexPriority(x Index) IndexPriority {
+	switch x.(type) {
+	case *defPathIndex, *defQueryIndex, *defNameIndex, *defQueryTreeIndex, *unitsIndex, *unitFilesIndex:
+		return LookupIndexPriority
+	case *defRefsIndex, *refFileIndex, *refIntervalIndex, *defRefUnitsIndex, *defFilesIndex, *defFilesTreeIndex:
+		return NavigationIndexPriority
+	}
+	return AnalysisIndexPriority
+}
+
+// IndexOrder is the order in which BuildIndexes builds indexes.
+type IndexOrder string
+
+const (
+	// IndexOrderListed builds indexes in the order in which they are
+	// listed (see Indexes), starting each build as soon as the index
+	// is listed.
+	IndexOrderListed IndexOrder = ""
+
+	// IndexOrderSmallestFirst builds the indexes with the least data
+	// first. An index's size is estimated from its previous build,
+	// or (for source unit indexes that haven't been built) from the
+	// size of the source unit's def and ref data.
+	IndexOrderSmallestFirst IndexOrder = "smallest-first"
+
+	// IndexOrderByPriority builds indexes in order of their
+	// IndexPriority (and then smallest first).
+	IndexOrderByPriority IndexOrder = "by-priority"
+)
+
+// IndexBuildOrder is the order in which BuildIndexes builds indexes.
+// In all orders, an index that depends on its children's indexes
+// (such as a tree-level index built from its source units' indexes)
+// is built after them.
+//
+// All orders other than IndexOrderListed list all of the matching
+// indexes before building any of them.
+var IndexBuildOrder = IndexOrderListed
+
+// ParseIndexOrder parses an IndexOrder from its string
+// representation ("smallest-first", "by-priority", or "" or "listed"
+// for IndexOrderListed).
+func ParseIndexOrder(s string) (IndexOrder, error) {
+	switch o := IndexOrder(s); o {
+	case IndexOrderSmallestFirst, IndexOrderByPriority:
+		return o, nil
+	case IndexOrderListed, "listed":
+		return IndexOrderListed, nil
+	}
+	return "", fmt.Errorf("unknown index build order %q (valid values are: listed, smallest-first, by-priority)", s)
+}
+
+// buildIndexesOrdered lists all indexes in store that match c, and
+// then calls build on each of them in the specified order.
+//
+// Indexes are built in phases so that an index that depends on its
+// children's indexes is built after them: first, all indexes that
+// don't depend on other indexes; then, tree-level indexes that depend
+// on source unit indexes; and finally, repo-level indexes that depend
+// on tree-level indexes. Within each phase, up to MaxIndexParallel
+// indexes are built at a time.
+func buildIndexesOrdered(ctx context.Context, store interface{}, c IndexCriteria, order IndexOrder, build func(IndexStatus)) error {
+	var phases [3][]IndexStatus
+	ch := make(chan IndexStatus)
+	done := make(chan struct{})
+	go func() {
+		for sx := range ch {
+			sx.estSize = estimateIndexSize(sx)
+			phase := indexBuildPhase(sx)
+			phases[phase] = append(phases[phase], sx)
+		}
+		done <- struct{}{}
+	}()
+	err := listIndexes(ctx, store, c, ch, nil)
+	close(ch)
+	<-done
+	if err != nil {
+		return err
+	}
+
+	for _, xs := range phases {
+		sortIndexStatuses(xs, order)
+		par := parallel.NewRun(MaxIndexParallel)
+		for _, sx := range xs {
+			sx_ := sx
+			par.Do(func() error { build(sx_); return nil })
+		}
+		par.Wait()
+	}
+	return nil
+}
+
+// indexBuildPhase returns the phase in which sx's index is built (see
+// buildIndexesOrdered).
+func indexBuildPhase(sx IndexStatus) int {
+	if !sx.DependsOnChildren {
+		return 0
+	}
+	if _, isRepo := sx.store.(*fsRepoStore); isRepo {
+		return 2
+	}
+	return 1
+}
+
+// estimateIndexSize returns an estimate of the size of sx's index. If
+// the index has been built, its current size is used. Otherwise, if it
+// is a source unit index, the size of the source unit's data is used.
+func estimateIndexSize(sx IndexStatus) int64 {
+	if sx.Size != 0 {
+		return sx.Size
+	}
+	if us, ok := sx.store.(*indexedUnitStore); ok {
+		var size int64
+		for _, name := range []string{unitDefsFilename, unitRefsFilename} {
+			if fi, err := us.fs.Stat(name); err == nil {
+				size += fi.Size()
+			}
+		}
+		return size
+	}
+	return 0
+}
+
+// sortIndexStatuses sorts xs in the specified order. Ties are broken
+// by index name (and then by repo, commit, and source unit) so that
+// the order is deterministic.
+func sortIndexStatuses(xs []IndexStatus, order IndexOrder) {
+	sort.Sort(indexStatusesByOrder{xs, order})
+}
+
+type indexStatusesByOrder struct {
+	xs    []IndexStatus
+	order IndexOrder
+}
+
+func (v indexStatusesByOrder) Len() int      { return len(v.xs) }
+func (v indexStatusesByOrder) Swap(i, j int) { v.xs[i], v.xs[j] = v.xs[j], v.xs[i] }
+func (v indexStatusesByOrder) Less(i, j int) bool {
+	a, b := v.xs[i], v.xs[j]
+	if v.order == IndexOrderByPriority && a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	if a.estSize != b.estSize {
+		return a.estSize < b.estSize
+	}
+	if v.order == IndexOrderSmallestFirst && a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.CommitID != b.CommitID {
+		return a.CommitID < b.CommitID
+	}
+	if a.Unit == nil || b.Unit == nil {
+		return a.Unit == nil && b.Unit != nil
+	}
+	return a.Unit.String() < b.Unit.String()
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestBuildIndexes_byPriority(t *testing.T) {
+	defer func(o IndexOrder) { IndexBuildOrder = o }(IndexBuildOrder)
+	IndexBuildOrder = IndexOrderByPriority
+
+	useIndexedStore = true
+	mrs := NewFSMultiRepoStore(newTestFS(), nil)
+	for _, name := range []string{"u1", "u2"} {
+		u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{"f"}}
+		data := graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"}},
+			Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+		}
+		if err := mrs.Import("r", "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	built, err := BuildIndexes(mrs, IndexCriteria{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) == 0 {
+		t.Fatal("no indexes were built")
+	}
+	var lastPhase int
+	var lastPriority IndexPriority
+	for _, x := range built {
+		if x.BuildError != "" {
+			t.Errorf("index %s: build error: %s", x.Name, x.BuildError)
+		}
+		phase := indexBuildPhase(x)
+		if phase < lastPhase {
+			t.Errorf("index %s (phase %d) was built after an index in phase %d", x.Name, phase, lastPhase)
+		}
+		if phase == lastPhase && x.Priority < lastPriority {
+			t.Errorf("index %s (priority %s) was built after an index with priority %s", x.Name, x.Priority, lastPriority)
+		}
+		lastPhase, lastPriority = phase, x.Priority
+	}
+}
+
+func TestSortIndexStatuses(t *testing.T) {
+	u1, u2 := &unit.ID2{Type: "t", Name: "u1"}, &unit.ID2{Type: "t", Name: "u2"}
+	xs := []IndexStatus{
+		{Name: "calls", Unit: u1, Priority: AnalysisIndexPriority, estSize: 10},
+		{Name: "def_query", Unit: u2, Priority: LookupIndexPriority, estSize: 300},
+		{Name: "def_query", Unit: u1, Priority: LookupIndexPriority, estSize: 20},
+		{Name: "def_to_refs", Unit: u1, Priority: NavigationIndexPriority, estSize: 20},
+	}
+	names := func(xs []IndexStatus) []string {
+		var s []string
+		for _, x := range xs {
+			s = append(s, x.Name+" "+x.Unit.Name)
+		}
+		return s
+	}
+
+	tests := map[IndexOrder][]string{
+		IndexOrderSmallestFirst: {"calls u1", "def_query u1", "def_to_refs u1", "def_query u2"},
+		IndexOrderByPriority:    {"def_query u1", "def_query u2", "def_to_refs u1", "calls u1"},
+	}
+	for order, want := range tests {
+		xs := append([]IndexStatus{}, xs...)
+		sortIndexStatuses(xs, order)
+		if got := names(xs); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", order, got, want)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"sourcegraph.com/sourcegraph/rwvfs"
 	"sourcegraph.com/sourcegraph/srclib/graph"
@@ -64,15 +65,20 @@ const (
 
 // newIndexedTreeStore creates a new indexed tree store that stores
 // data and indexes in fs.
-func newIndexedTreeStore(fs rwvfs.FileSystem) TreeStoreImporter {
+func newIndexedTreeStore(fs rwvfs.FileSystem, c codec) TreeStoreImporter {
 	return &indexedTreeStore{
 		indexes: map[string]Index{
-			"file_to_units":     &unitFilesIndex{},
-			"def_to_ref_units":  &defRefUnitsIndex{},
-			"def_query_to_defs": &defQueryTreeIndex{},
-			unitsIndexName:      &unitsIndex{},
+			"file_to_units":       &unitFilesIndex{},
+			"unit_languages":      &unitLanguagesIndex{},
+			"def_to_ref_units":    &defRefUnitsIndex{},
+			"def_query_to_defs":   &defQueryTreeIndex{},
+			"file_to_defs":        &defFilesTreeIndex{},
+			"def_data_to_defs":    &defDataTreeIndex{},
+			brokenRefsIndexName:   &brokenRefsIndex{},
+			defRefCountsIndexName: &defRefCountsIndex{},
+			unitsIndexName:        &unitsIndex{},
 		},
-		fsTreeStore: newFSTreeStore(fs),
+		fsTreeStore: newFSTreeStore(fs, c),
 	}
 }
 
@@ -91,6 +97,7 @@ var errNotIndexed = errors.New("no index satisfies query")
 // full scan would otherwise occur, errNotIndexed is returned.
 func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2, error) {
 	vlog.Printf("indexedTreeStore.unitIDs(indexOnly=%v, %v)", indexOnly, fs)
+	start := time.Now()
 
 	scopedUnits, err := scopeUnits(storeFilters(fs))
 	if err != nil {
@@ -98,6 +105,7 @@ func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2
 	}
 	if scopedUnits != nil {
 		vlog.Printf("indexedTreeStore.unitIDs(indexOnly=%v, %v): Returning scoped units (from filters) without performing external lookup.", indexOnly, fs)
+		explainStep(s, "scope", start, "scoped to %d source units by ByUnits filters", len(scopedUnits))
 		return scopedUnits, nil
 	}
 
@@ -107,7 +115,12 @@ func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2
 			return nil, err
 		}
 		vlog.Printf("indexedTreeStore.unitIDs(%v): Found covering index %q (%v).", fs, xname, bx)
-		return bx.(unitIndex).Units(fs...)
+		unitIDs, err := bx.(unitIndex).Units(fs...)
+		if err == nil && explaining() {
+			covered, _ := splitCoveredFilters(bx, fs)
+			explainStep(s, "index", start, "unit index %q (covers %v) scoped query to %d source units", xname, covered, len(unitIDs))
+		}
+		return unitIDs, err
 	}
 	if indexOnly {
 		return nil, errNotIndexed
@@ -123,6 +136,7 @@ func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2
 	for _, u := range units {
 		unitIDs = append(unitIDs, u.ID2())
 	}
+	explainStep(s, "scan", start, "no unit index covers %v; scanned source unit definitions (units index) and selected %d source units", fs, len(unitIDs))
 	return unitIDs, nil
 }
 
@@ -132,6 +146,10 @@ func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2
 var maxIndividualFetches = 5
 
 func (s *indexedTreeStore) Units(fs ...UnitFilter) ([]*unit.SourceUnit, error) {
+	if err := getQueryControls(fs).check(); err != nil {
+		return nil, err
+	}
+
 	// Attempt to use the index.
 	scopedUnits, err := s.unitIDs(true, fs...)
 	if err != nil && err != errNotIndexed {
@@ -161,15 +179,30 @@ func (s *indexedTreeStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 	// First, check if any defs indexes at the tree level cover this
 	// query.
 	if xname, bx := bestCoverageIndex(s.indexes, fs, isDefTreeIndex); bx != nil {
-		if err := prepareIndex(s.fs, xname, bx); err != nil {
-			return nil, err
-		}
-		vlog.Printf("indexedTreeStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
-		uoffs, err := bx.(defTreeIndex).Defs(fs...)
-		if err != nil {
+		err := prepareIndex(s.fs, xname, bx)
+		if err == nil {
+			vlog.Printf("indexedTreeStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
+			start := time.Now()
+			uoffs, err := bx.(defTreeIndex).Defs(fs...)
+			if err != nil && err != errNotIndexed {
+				return nil, err
+			}
+			// errNotIndexed means that the index can't satisfy this
+			// query after all (e.g., the def data index was built
+			// without the queried path); fall through.
+			if err == nil {
+				if explaining() {
+					covered, _ := splitCoveredFilters(bx, fs)
+					explainStep(s, "index", start, "tree def index %q (covers %v) found candidate defs in %d source units", xname, covered, len(uoffs))
+				}
+				fs = append(fs, unitDefOffsetsFilter(uoffs))
+			}
+		} else if _, ok := err.(*errIndexNotExist); !ok {
 			return nil, err
 		}
-		fs = append(fs, unitDefOffsetsFilter(uoffs))
+		// Trees indexed before an index was added don't have it; in
+		// that case, fall through and narrow the query by source
+		// unit.
 	}
 
 	// We have File->Unit index (that tells us which source units
@@ -179,6 +212,9 @@ func (s *indexedTreeStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 
 	var ufs []UnitFilter
 	for _, f := range fs {
+		if isQueryControl(f) {
+			continue
+		}
 		switch f := f.(type) {
 		case UnitFilter:
 			ufs = append(ufs, f)
@@ -189,6 +225,7 @@ func (s *indexedTreeStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 	// underlying store.
 	if len(ufs) == 0 {
 		vlog.Printf("indexedTreeStore.Defs(%v): No unit indexes found to narrow scope; forwarding to underlying store.", fs)
+		explainStep(s, "scope", time.Now(), "no filters narrow the source units; querying all source units")
 		return s.fsTreeStore.Defs(fs...)
 	}
 
@@ -219,6 +256,9 @@ func (s *indexedTreeStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 
 	var ufs []UnitFilter
 	for _, f := range fs {
+		if isQueryControl(f) {
+			continue
+		}
 		switch f := f.(type) {
 		case UnitFilter:
 			ufs = append(ufs, f)
@@ -234,6 +274,7 @@ func (s *indexedTreeStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 	// underlying store.
 	if len(ufs) == 0 {
 		vlog.Printf("indexedTreeStore.Refs(%v): No unit indexes found to narrow scope; forwarding to underlying store.", fs)
+		explainStep(s, "scope", time.Now(), "no filters narrow the source units; querying all source units")
 		return s.fsTreeStore.Refs(fs...)
 	}
 
@@ -439,6 +480,44 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 		return unitDefQueryIndexes, getUnitDefQueryIndexesErr
 	}
 
+	var getUnitDefIndexesErr error
+	var getUnitDefIndexesOnce sync.Once
+	var unitDefIndexes map[unit.ID2]*defPathIndex
+	var unitDefIndexesLock sync.Mutex
+	getUnitDefIndexes := func() (map[unit.ID2]*defPathIndex, error) {
+		getUnitDefIndexesOnce.Do(func() {
+			// Read in the defPathIndex for all source units.
+			units, err := getUnits()
+			if err != nil {
+				getUnitDefIndexesErr = err
+				return
+			}
+
+			unitDefIndexes = make(map[unit.ID2]*defPathIndex, len(units))
+			par := parallel.NewRun(runtime.GOMAXPROCS(0))
+			for _, u_ := range units {
+				u := u_.ID2()
+				us, ok := s.fsTreeStore.openUnitStore(u).(*indexedUnitStore)
+				if !ok {
+					continue
+				}
+
+				par.Do(func() error {
+					x := us.indexes[defPathIndexName]
+					if err := prepareIndex(us.fs, defPathIndexName, x); err != nil {
+						return err
+					}
+					unitDefIndexesLock.Lock()
+					defer unitDefIndexesLock.Unlock()
+					unitDefIndexes[u] = x.(*defPathIndex)
+					return nil
+				})
+			}
+			getUnitDefIndexesErr = par.Wait()
+		})
+		return unitDefIndexes, getUnitDefIndexesErr
+	}
+
 	par := parallel.NewRun(len(xs))
 	for name_, x_ := range xs {
 		name, x := name_, x_
@@ -468,6 +547,30 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 				if err := x.Build(unitDefQueryIndexes); err != nil {
 					return err
 				}
+			case unitRefDefIndexBuilder:
+				units, err := getUnits()
+				if err != nil {
+					return err
+				}
+				unitRefIndexes, err := getUnitRefIndexes()
+				if err != nil {
+					return err
+				}
+				unitDefIndexes, err := getUnitDefIndexes()
+				if err != nil {
+					return err
+				}
+				if err := x.Build(units, unitRefIndexes, unitDefIndexes); err != nil {
+					return err
+				}
+			case unitDefsIndexBuilder:
+				units, err := getUnits()
+				if err != nil {
+					return err
+				}
+				if err := x.Build(units, s.readUnitDefs); err != nil {
+					return err
+				}
 			default:
 				return fmt.Errorf("don't know how to build index %q of type %T", name, x)
 			}
@@ -482,6 +585,19 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 	return par.Wait()
 }
 
+// readUnitDefs reads all of the defs (and their byte offsets) in a
+// source unit. It is used to build tree indexes of defs.
+func (s *indexedTreeStore) readUnitDefs(u unit.ID2) ([]*graph.Def, byteOffsets, error) {
+	switch us := s.fsTreeStore.openUnitStore(u).(type) {
+	case *indexedUnitStore:
+		return us.readDefs()
+	case *fsUnitStore:
+		return us.readDefs()
+	default:
+		return nil, nil, fmt.Errorf("can't read defs from unit store %T for source unit %+v", us, u)
+	}
+}
+
 func (s *indexedTreeStore) statIndex(name string) (os.FileInfo, error) {
 	return statIndex(s.fs, name)
 }
@@ -509,11 +625,11 @@ var _ interface {
 
 // newIndexedUnitStore creates a new indexed unit store that stores
 // data and indexes in fs.
-func newIndexedUnitStore(fs rwvfs.FileSystem, label string) UnitStoreImporter {
+func newIndexedUnitStore(fs rwvfs.FileSystem, label string, c codec) UnitStoreImporter {
 	return &indexedUnitStore{
 		indexes: map[string]Index{
-			"path_to_def":  &defPathIndex{},
-			"file_to_refs": &refFileIndex{},
+			defPathIndexName: &defPathIndex{},
+			"file_to_refs":   &refFileIndex{},
 			"file_to_7_exported_non_local_defs": &defFilesIndex{
 				filters: []DefFilter{
 					DefFilterFunc(func(def *graph.Def) bool {
@@ -522,17 +638,28 @@ func newIndexedUnitStore(fs rwvfs.FileSystem, label string) UnitStoreImporter {
 				},
 				perFile: 7,
 			},
-			defToRefsIndexName: &defRefsIndex{},
-			defQueryIndexName:  &defQueryIndex{f: defQueryFilter},
+			defToRefsIndexName:     &defRefsIndex{},
+			defQueryIndexName:      &defQueryIndex{f: defQueryFilter},
+			defNameIndexName:       &defNameIndex{},
+			defFoldedNameIndexName: &defNameIndex{fold: true},
+			refIntervalIndexName:   &refIntervalIndex{},
+			callerToCallsIndexName: &callsIndex{},
+			calleeToCallsIndexName: &callsIndex{byCallee: true},
 		},
-		fsUnitStore: &fsUnitStore{fs: fs, label: label},
+		fsUnitStore: &fsUnitStore{fs: fs, label: label, codec: c},
 	}
 }
 
 const (
-	defToRefsIndexName = "def_to_refs"
-	defQueryIndexName  = "def_query"
-	indexFilename      = "%s.idx"
+	defPathIndexName       = "path_to_def"
+	defToRefsIndexName     = "def_to_refs"
+	defQueryIndexName      = "def_query"
+	defNameIndexName       = "def_name"
+	defFoldedNameIndexName = "def_name_folded"
+	refIntervalIndexName   = "file_ref_intervals"
+	callerToCallsIndexName = "caller_to_calls"
+	calleeToCallsIndexName = "callee_to_calls"
+	indexFilename          = "%s.idx"
 )
 
 func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
@@ -542,6 +669,7 @@ func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 	if hasDefOffsetsFilter := getDefOffsetsFilter(fs) != nil; !hasDefOffsetsFilter {
 		// Try to find an index that covers this query.
 		if xname, bx := bestCoverageIndex(s.indexes, fs, isDefIndex); bx != nil {
+			start := time.Now()
 			if err := prepareIndex(s.fs, xname, bx); err != nil {
 				return nil, err
 			}
@@ -550,7 +678,12 @@ func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 			if err != nil {
 				return nil, err
 			}
-			return s.defsAtOffsets(ofs, fs)
+			defs, err := s.defsAtOffsets(ofs, fs)
+			if err == nil && explaining() {
+				covered, scanned := splitCoveredFilters(bx, fs)
+				explainStep(s.fsUnitStore, "index", start, "def index %q (covers %v) returned %d defs; %d selected by filters %v", xname, covered, len(ofs), len(defs), scanned)
+			}
+			return defs, err
 		}
 	}
 
@@ -566,19 +699,34 @@ func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 			return nil, err
 		}
 		vlog.Printf("indexedUnitStore.Refs(%v): Found covering index %q (%v).", fs, xname, bx)
+		start := time.Now()
+		explain := func(candidates string, refs []*graph.Ref) {
+			if explaining() {
+				covered, scanned := splitCoveredFilters(bx, fs)
+				explainStep(s.fsUnitStore, "index", start, "ref index %q (covers %v) returned %s; %d refs selected by filters %v", xname, covered, candidates, len(refs), scanned)
+			}
+		}
 		switch bx := bx.(type) {
 		case refIndexByteRanges:
 			brs, err := bx.Refs(fs...)
 			if err != nil {
 				return nil, err
 			}
-			return s.refsAtByteRanges(brs, fs)
+			refs, err := s.refsAtByteRanges(brs, fs)
+			if err == nil {
+				explain(fmt.Sprintf("%d byte ranges", len(brs)), refs)
+			}
+			return refs, err
 		case refIndexByteOffsets:
 			ofs, err := bx.Refs(fs...)
 			if err != nil {
 				return nil, err
 			}
-			return s.refsAtOffsets(ofs, fs)
+			refs, err := s.refsAtOffsets(ofs, fs)
+			if err == nil {
+				explain(fmt.Sprintf("%d refs", len(ofs)), refs)
+			}
+			return refs, err
 		}
 	}
 
@@ -589,12 +737,13 @@ func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 // Import calls to the underlying fsUnitStore to write the def
 // and ref data files. It also builds and writes the indexes.
 func (s *indexedUnitStore) Import(data graph.Output) error {
+	attachDocs(&data)
 	cleanForImport(&data, "", "", "")
 
-	var defOfs, refOfs byteOffsets
+	var defOfs, refOfs, callOfs byteOffsets
 	var refFBRs fileByteRanges
 
-	par := parallel.NewRun(2)
+	par := parallel.NewRun(4)
 	par.Do(func() (err error) {
 		defOfs, err = s.fsUnitStore.writeDefs(data.Defs)
 		return err
@@ -603,11 +752,18 @@ func (s *indexedUnitStore) Import(data graph.Output) error {
 		refFBRs, refOfs, err = s.fsUnitStore.writeRefs(data.Refs)
 		return err
 	})
+	par.Do(func() (err error) {
+		callOfs, err = s.fsUnitStore.writeCalls(data.Calls)
+		return err
+	})
+	par.Do(func() error {
+		return s.fsUnitStore.writeRelations(data.Relations)
+	})
 	if err := par.Wait(); err != nil {
 		return err
 	}
 
-	if err := s.buildIndexes(s.Indexes(), &data, defOfs, refFBRs, refOfs); err != nil {
+	if err := s.buildIndexes(s.Indexes(), &data, defOfs, refFBRs, refOfs, callOfs); err != nil {
 		return err
 	}
 	return nil
@@ -616,16 +772,17 @@ func (s *indexedUnitStore) Import(data graph.Output) error {
 func (s *indexedUnitStore) Indexes() map[string]Index { return s.indexes }
 
 func (s *indexedUnitStore) BuildIndex(name string, x Index) error {
-	return s.buildIndexes(map[string]Index{name: x}, nil, nil, nil, nil)
+	return s.buildIndexes(map[string]Index{name: x}, nil, nil, nil, nil, nil)
 }
 
 func (s *indexedUnitStore) readIndex(name string, x persistedIndex) error {
 	return readIndex(s.fs, name, x)
 }
 
-func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output, defOfs byteOffsets, refFBRs fileByteRanges, refOfs byteOffsets) error {
+func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output, defOfs byteOffsets, refFBRs fileByteRanges, refOfs byteOffsets, callOfs byteOffsets) error {
 	var defs []*graph.Def
 	var refs []*graph.Ref
+	var calls []*graph.Call
 	if data != nil {
 		// Allow us to distinguish between empty (empty slice) and not-yet-fetched (nil).
 		defs = data.Defs
@@ -636,6 +793,10 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 		if refs == nil {
 			refs = []*graph.Ref{}
 		}
+		calls = data.Calls
+		if calls == nil {
+			calls = []*graph.Call{}
+		}
 	}
 
 	var getDefsErr error
@@ -670,6 +831,20 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 		return refs, refFBRs, refOfs, getRefsErr
 	}
 
+	var getCallsErr error
+	var getCallsOnce sync.Once
+	getCalls := func() ([]*graph.Call, byteOffsets, error) {
+		getCallsOnce.Do(func() {
+			if calls == nil {
+				calls, callOfs, getCallsErr = s.fsUnitStore.readCalls()
+			}
+			if calls == nil {
+				calls = []*graph.Call{}
+			}
+		})
+		return calls, callOfs, getCallsErr
+	}
+
 	par := parallel.NewRun(len(xs))
 	for name_, x_ := range xs {
 		name, x := name_, x_
@@ -691,6 +866,14 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 				if err := x.Build(refs, refFBRs, refOfs); err != nil {
 					return err
 				}
+			case callIndexBuilder:
+				calls, callOfs, err := getCalls()
+				if err != nil {
+					return err
+				}
+				if err := x.Build(calls, callOfs); err != nil {
+					return err
+				}
 			default:
 				return fmt.Errorf("don't know how to build index %q of type %T", name, x)
 			}
@@ -769,6 +952,15 @@ func (e *errIndexNotExist) Error() string {
 	return fmt.Sprintf("index %q does not exist: %s", e.name, e.err)
 }
 
+type errIndexCorrupt struct {
+	name string
+	err  error
+}
+
+func (e *errIndexCorrupt) Error() string {
+	return fmt.Sprintf("index %q is corrupt (rebuild it with `src store index`): %s", e.name, e.err)
+}
+
 // readIndex calls x.Read with the index's backing file.
 func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 	vlog.Printf("%s: reading index...", name)
@@ -790,14 +982,14 @@ func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 
 	r, err := gzip.NewReader(f)
 	if err != nil {
-		return err
+		return &errIndexCorrupt{name: name, err: err}
 	}
 
 	if err := x.Read(r); err != nil {
-		return err
+		return &errIndexCorrupt{name: name, err: err}
 	}
 	if err := r.Close(); err != nil {
-		return err
+		return &errIndexCorrupt{name: name, err: err}
 	}
 	vlog.Printf("%s: done reading index.", name)
 	return nil
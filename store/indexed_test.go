@@ -5,21 +5,21 @@ import "testing"
 func TestIndexedUnitStore(t *testing.T) {
 	useIndexedStore = true
 	testUnitStore(t, func() UnitStoreImporter {
-		return newIndexedUnitStore(newTestFS(), "")
+		return newIndexedUnitStore(newTestFS(), "", Codec)
 	})
 }
 
 func TestIndexedTreeStore(t *testing.T) {
 	useIndexedStore = true
 	testTreeStore(t, func() TreeStoreImporter {
-		return newIndexedTreeStore(newTestFS())
+		return newIndexedTreeStore(newTestFS(), Codec)
 	})
 }
 
 func TestIndexedFSTreeStore(t *testing.T) {
 	useIndexedStore = true
 	testTreeStore(t, func() TreeStoreImporter {
-		return newFSTreeStore(newTestFS())
+		return newFSTreeStore(newTestFS(), Codec)
 	})
 }
 
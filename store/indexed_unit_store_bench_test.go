@@ -46,12 +46,12 @@ func BenchmarkIndexedUnitStore_Defs_byFile(b *testing.B) {
 
 func newFSUnitStore() UnitStoreImporter {
 	fs := rwvfs.Map(map[string]string{})
-	return &fsUnitStore{fs: fs}
+	return &fsUnitStore{fs: fs, codec: Codec}
 }
 
 func idxUnitStore() UnitStoreImporter {
 	fs := rwvfs.Map(map[string]string{})
-	return newIndexedUnitStore(fs, "")
+	return newIndexedUnitStore(fs, "", Codec)
 }
 
 func benchmarkUnitStore_Def(b *testing.B, us UnitStoreImporter, numDefs int) {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"code.google.com/p/rog-go/parallel"
+	"golang.org/x/net/context"
 
 	"strings"
 	"sync"
@@ -56,6 +57,11 @@ type IndexStatus struct {
 	// status, if any.
 	Error string `json:",omitempty"`
 
+	// Priority is the index's build priority (see IndexPriority). When
+	// building indexes in IndexOrderByPriority, lower values are built
+	// first.
+	Priority IndexPriority
+
 	// DependsOnChildren is true if this index needs its child indexes
 	// to be built first before it can be built.
 	DependsOnChildren bool `json:",omitempty"`
@@ -68,6 +74,10 @@ type IndexStatus struct {
 	// only returned by BuildIndexes (not Indexes).
 	BuildDuration time.Duration `json:",omitempty"`
 
+	// estSize is the estimated size of the index, used to order
+	// index builds (see IndexOrderSmallestFirst).
+	estSize int64
+
 	// index is the actual index object. It is used to support Print.
 	index Index
 
@@ -113,31 +123,53 @@ var NoSourceUnit = &unit.ID2{}
 // that match the specified criteria. It returns the status of each
 // index that was built (or rebuilt).
 func BuildIndexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
+	return BuildIndexesContext(context.Background(), store, c, indexChan)
+}
+
+// BuildIndexesContext is like BuildIndexes, but it stops building
+// indexes when ctx is canceled or its deadline passes. Indexes that
+// are being built at that time are finished, and the remaining
+// indexes are not built (their BuildError is ctx.Err()). It returns
+// ctx.Err() if any indexes were not built.
+func BuildIndexesContext(ctx context.Context, store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
 	var built []IndexStatus
 	var builtMu sync.Mutex
+	doBuild := func(sx IndexStatus) {
+		start := time.Now()
+		err := ctx.Err()
+		if err == nil {
+			err = withTransientRetries("Building index "+sx.Name, func() error {
+				return sx.store.BuildIndex(sx.Name, sx.index)
+			})
+		}
+		sx.BuildDuration = time.Since(start)
+		if err == nil {
+			sx.Stale = false
+		} else {
+			sx.BuildError = err.Error()
+		}
+		builtMu.Lock()
+		built = append(built, sx)
+		builtMu.Unlock()
+		if indexChan != nil {
+			indexChan <- sx
+		}
+	}
+
+	if IndexBuildOrder != IndexOrderListed {
+		err := buildIndexesOrdered(ctx, store, c, IndexBuildOrder, doBuild)
+		if err == nil {
+			err = ctx.Err()
+		}
+		return built, err
+	}
+
 	indexChan2 := make(chan IndexStatus)
 	done := make(chan struct{})
 	go func() {
 		var par *parallel.Run
 		lastDependsOnChildren := false
 		for sx := range indexChan2 {
-			doBuild := func(sx IndexStatus) {
-				start := time.Now()
-				err := sx.store.BuildIndex(sx.Name, sx.index)
-				sx.BuildDuration = time.Since(start)
-				if err == nil {
-					sx.Stale = false
-				} else {
-					sx.BuildError = err.Error()
-				}
-				builtMu.Lock()
-				built = append(built, sx)
-				builtMu.Unlock()
-				if indexChan != nil {
-					indexChan <- sx
-				}
-			}
-
 			// Run indexes in parallel, but if we
 			// encounter an index that depends on children, wait for
 			// all previously seen indexes to finish before building
@@ -159,12 +191,77 @@ func BuildIndexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStat
 		}
 		done <- struct{}{}
 	}()
-	err := listIndexes(store, c, indexChan2, nil)
+	err := listIndexes(ctx, store, c, indexChan2, nil)
 	close(indexChan2)
 	<-done
+	if err == nil {
+		err = ctx.Err()
+	}
 	return built, err
 }
 
+// WarmIndexes reads all built indexes on store and its lower-level
+// stores that match the specified criteria (stale indexes are
+// skipped). Reading an index loads it into memory and brings its
+// backing file into the OS's page cache, so that subsequent queries
+// (including those in other processes) don't pay the cost of reading
+// it from cold storage. It returns the status of each index that was
+// read; if reading an index failed, its Error field is set.
+//
+// To keep the indexes in memory after WarmIndexes returns, set
+// CacheOpenStores before opening store (and keep using the same store
+// for subsequent queries).
+func WarmIndexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
+	return WarmIndexesContext(context.Background(), store, c, indexChan)
+}
+
+// WarmIndexesContext is like WarmIndexes, but it stops reading indexes
+// when ctx is done (see BuildIndexesContext).
+func WarmIndexesContext(ctx context.Context, store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
+	notStale := false
+	c.Stale = &notStale
+
+	var warmed []IndexStatus
+	var warmedMu sync.Mutex
+	indexChan2 := make(chan IndexStatus)
+	done := make(chan struct{})
+	go func() {
+		par := parallel.NewRun(MaxIndexParallel)
+		for sx := range indexChan2 {
+			sx_ := sx
+			par.Do(func() error {
+				if px, ok := sx_.index.(persistedIndex); ok && !sx_.index.Ready() {
+					err := ctx.Err()
+					if err == nil {
+						err = withTransientRetries("Reading index "+sx_.Name, func() error {
+							return sx_.store.readIndex(sx_.Name, px)
+						})
+					}
+					if err != nil {
+						sx_.Error = err.Error()
+					}
+				}
+				warmedMu.Lock()
+				warmed = append(warmed, sx_)
+				warmedMu.Unlock()
+				if indexChan != nil {
+					indexChan <- sx_
+				}
+				return nil
+			})
+		}
+		par.Wait()
+		done <- struct{}{}
+	}()
+	err := listIndexes(ctx, store, c, indexChan2, nil)
+	close(indexChan2)
+	<-done
+	if err == nil {
+		err = ctx.Err()
+	}
+	return warmed, err
+}
+
 // Indexes returns a list of indexes and their statuses for store and
 // its lower-level stores. Only indexes matching the criteria are
 // returned. If indexChan is non-nil, it receives indexes as soon as
@@ -174,6 +271,12 @@ func BuildIndexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStat
 // The caller is responsible for closing indexChan after Indexes
 // returns (if desired).
 func Indexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
+	return IndexesContext(context.Background(), store, c, indexChan)
+}
+
+// IndexesContext is like Indexes, but it stops listing indexes (and
+// returns ctx.Err()) when ctx is done.
+func IndexesContext(ctx context.Context, store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
 	var xs []IndexStatus
 	indexChan2 := make(chan IndexStatus)
 	done := make(chan struct{})
@@ -186,7 +289,7 @@ func Indexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) (
 		}
 		done <- struct{}{}
 	}()
-	err := listIndexes(store, c, indexChan2, nil)
+	err := listIndexes(ctx, store, c, indexChan2, nil)
 	close(indexChan2)
 	<-done
 	return xs, err
@@ -196,7 +299,11 @@ func Indexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) (
 // status objects to ch. If f != nil, it is called to set/modify
 // fields on each status object before the IndexStatus object is sent to
 // the channel.
-func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*IndexStatus)) error {
+func listIndexes(ctx context.Context, s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*IndexStatus)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	switch s := s.(type) {
 	case indexedStore:
 		xx := s.Indexes()
@@ -208,6 +315,7 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 				index: x,
 				store: s,
 			}
+			st.Priority = indexPriority(x)
 
 			if !strings.Contains(st.Name, c.Name) {
 				continue
@@ -216,7 +324,11 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 				continue
 			}
 
-			fi, err := s.statIndex(name)
+			var fi os.FileInfo
+			err := withTransientRetries("Checking index "+name, func() (err error) {
+				fi, err = s.statIndex(name)
+				return err
+			})
 			if os.IsNotExist(err) {
 				st.Stale = true
 			} else if err != nil {
@@ -226,7 +338,7 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 			}
 
 			switch x.(type) {
-			case unitRefIndexBuilder, defQueryTreeIndexBuilder:
+			case unitRefIndexBuilder, defQueryTreeIndexBuilder, *defHistoryIndex:
 				st.DependsOnChildren = true
 			}
 
@@ -244,6 +356,12 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 				}
 			}
 
+			// Repo-level indexes span all commits, so they don't
+			// match a specific commit.
+			if _, isRepo := s.(*fsRepoStore); isRepo && c.CommitID != "" {
+				continue
+			}
+
 			if st.DependsOnChildren {
 				waitingOnChildren = append(waitingOnChildren, st)
 			} else {
@@ -253,11 +371,15 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 
 		switch s := s.(type) {
 		case *indexedTreeStore:
-			if err := listIndexes(s.fsTreeStore, c, ch, f); err != nil {
+			if err := listIndexes(ctx, s.fsTreeStore, c, ch, f); err != nil {
 				return err
 			}
 		case *indexedUnitStore:
-			if err := listIndexes(s.fsUnitStore, c, ch, f); err != nil {
+			if err := listIndexes(ctx, s.fsUnitStore, c, ch, f); err != nil {
+				return err
+			}
+		case *fsRepoStore:
+			if err := listTreeStoreIndexes(ctx, s, c, ch, f); err != nil {
 				return err
 			}
 		}
@@ -270,7 +392,10 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 		var rss map[string]RepoStore
 		if c.Repo == "" {
 			var err error
-			rss, err = s.openAllRepoStores()
+			err = withTransientRetries("Listing repos", func() (err error) {
+				rss, err = s.openAllRepoStores()
+				return err
+			})
 			if err != nil && !isStoreNotExist(err) {
 				return err
 			}
@@ -298,7 +423,7 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 
 		for _, repo := range repos {
 			rs := rss[repo]
-			err := listIndexes(rs, c, ch, func(x *IndexStatus) {
+			err := listIndexes(ctx, rs, c, ch, func(x *IndexStatus) {
 				x.Repo = repo
 				if f != nil {
 					f(x)
@@ -310,27 +435,7 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 		}
 
 	case treeStoreOpener:
-		var tss map[string]TreeStore
-		if c.CommitID == "" {
-			var err error
-			tss, err = s.openAllTreeStores()
-			if err != nil && !isStoreNotExist(err) {
-				return err
-			}
-		} else {
-			tss = map[string]TreeStore{c.CommitID: s.openTreeStore(c.CommitID)}
-		}
-		for commitID, ts := range tss {
-			err := listIndexes(ts, c, ch, func(x *IndexStatus) {
-				x.CommitID = commitID
-				if f != nil {
-					f(x)
-				}
-			})
-			if err != nil {
-				return err
-			}
-		}
+		return listTreeStoreIndexes(ctx, s, c, ch, f)
 
 	case unitStoreOpener:
 		if c.Unit == NoSourceUnit {
@@ -339,7 +444,10 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 		var uss map[unit.ID2]UnitStore
 		if c.Unit == nil {
 			var err error
-			uss, err = s.openAllUnitStores()
+			err = withTransientRetries("Listing source units", func() (err error) {
+				uss, err = s.openAllUnitStores()
+				return err
+			})
 			if err != nil && !isStoreNotExist(err) {
 				return err
 			}
@@ -352,7 +460,7 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 				unit, us := unit_, us_
 				par.Do(func() error {
 					unitCopy := unit
-					return listIndexes(us, c, ch, func(x *IndexStatus) {
+					return listIndexes(ctx, us, c, ch, func(x *IndexStatus) {
 						x.Unit = &unitCopy
 						if f != nil {
 							f(x)
@@ -369,4 +477,34 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 	return nil
 }
 
+// listTreeStoreIndexes lists the indexes in the tree stores opened
+// by s (see listIndexes).
+func listTreeStoreIndexes(ctx context.Context, s treeStoreOpener, c IndexCriteria, ch chan<- IndexStatus, f func(*IndexStatus)) error {
+	var tss map[string]TreeStore
+	if c.CommitID == "" {
+		var err error
+		err = withTransientRetries("Listing commits", func() (err error) {
+			tss, err = s.openAllTreeStores()
+			return err
+		})
+		if err != nil && !isStoreNotExist(err) {
+			return err
+		}
+	} else {
+		tss = map[string]TreeStore{c.CommitID: s.openTreeStore(c.CommitID)}
+	}
+	for commitID, ts := range tss {
+		err := listIndexes(ctx, ts, c, ch, func(x *IndexStatus) {
+			x.CommitID = commitID
+			if f != nil {
+				f(x)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var MaxIndexParallel = 1
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_ByLanguages(t *testing.T) {
+	testMultiRepoStore_ByLanguages(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_ByLanguages(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_ByLanguages(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_ByLanguages(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_ByLanguages(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_ByLanguages(t *testing.T, mrs MultiRepoStoreImporter) {
+	for _, u := range []*unit.SourceUnit{
+		{Type: "GoPackage", Name: "a", Language: "go"},
+		{Type: "PipPackage", Name: "b", Language: "python"},
+		{Type: "GoPackage", Name: "c", Language: "go"},
+	} {
+		data := graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: u.Name + "/f"}},
+			Refs: []*graph.Ref{{DefPath: "p", File: u.Name + "/f", Start: 1, End: 2}},
+		}
+		if err := mrs.Import("r", "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mrs, ok := mrs.(MultiRepoIndexer); ok {
+		if err := mrs.Index("r", "c"); err != nil {
+			t.Fatalf("%s: Index: %s", mrs, err)
+		}
+	}
+
+	// Results are sorted by unit type and then unit name, so the
+	// GoPackages come before the PipPackage.
+	tests := []struct {
+		langs []string
+		want  []string
+	}{
+		{[]string{"go"}, []string{"a", "c"}},
+		{[]string{"python"}, []string{"b"}},
+		{[]string{"go", "python"}, []string{"a", "c", "b"}},
+		{[]string{"rust"}, nil},
+	}
+	for _, test := range tests {
+		units, err := mrs.Units(ByLanguages(test.langs...))
+		if err != nil {
+			t.Fatalf("%s: Units(%v): %s", mrs, test.langs, err)
+		}
+		var got []string
+		for _, u := range units {
+			got = append(got, u.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Units(%v): got %v, want %v", mrs, test.langs, got, test.want)
+		}
+
+		defs, err := mrs.Defs(ByLanguages(test.langs...))
+		if err != nil {
+			t.Fatalf("%s: Defs(%v): %s", mrs, test.langs, err)
+		}
+		got = nil
+		for _, def := range defs {
+			got = append(got, def.Unit)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Defs(%v): got defs in units %v, want %v", mrs, test.langs, got, test.want)
+		}
+
+		refs, err := mrs.Refs(ByLanguages(test.langs...), ByRepos("r"))
+		if err != nil {
+			t.Fatalf("%s: Refs(%v): %s", mrs, test.langs, err)
+		}
+		got = nil
+		for _, ref := range refs {
+			got = append(got, ref.Unit)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Refs(%v): got refs in units %v, want %v", mrs, test.langs, got, test.want)
+		}
+	}
+}
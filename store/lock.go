@@ -0,0 +1,178 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A Locker acquires advisory locks that serialize writes to a store
+// by concurrent processes (e.g., two simultaneous imports of the same
+// repo and commit, which would otherwise interleave writes and corrupt
+// indexes).
+type Locker interface {
+	// Lock acquires the lock with the given name (see LockName) and
+	// returns a func that releases it. If the lock is held and wait
+	// is false, it returns an error for which IsLocked returns
+	// true; otherwise it waits until the lock is released.
+	Lock(name string, wait bool) (unlock func() error, err error)
+}
+
+// LockName returns the name of the lock that guards writes of the
+// data for a commit of a repo. The repo is empty for RepoStores.
+func LockName(repo, commitID string) string {
+	if repo == "" {
+		return commitID
+	}
+	return url.QueryEscape(repo) + "@" + commitID
+}
+
+// StoreLockName is the name of the lock that guards writes of the
+// store-wide data of a MultiRepoStore (the global ref index, which is
+// updated when any repo's commit is indexed). It can't be the name of
+// a commit's lock (see LockName).
+const StoreLockName = ".store"
+
+// errLocked is returned by a Locker's Lock method when the lock is
+// held and the caller chose not to wait.
+type errLocked struct {
+	name   string
+	holder string // information about the holder (if known)
+}
+
+func (e *errLocked) Error() string {
+	msg := fmt.Sprintf("store lock %s is held by another process", e.name)
+	if e.holder != "" {
+		msg += " (" + e.holder + ")"
+	}
+	return msg
+}
+
+// IsLocked returns a boolean indicating whether err reports that a
+// store lock could not be acquired because it is held by another
+// process.
+func IsLocked(err error) bool {
+	_, ok := err.(*errLocked)
+	return ok
+}
+
+// LockPollInterval is how often a Locker checks whether a held lock
+// was released when waiting for it.
+var LockPollInterval = 250 * time.Millisecond
+
+// NewFileLocker returns a Locker that locks a lock file for each lock
+// in the local directory dir. On Unix, lock files are locked with
+// flock(2), so the lock is released by the kernel when its holder
+// exits, even if it is killed. Elsewhere, lock files are created
+// exclusively (with O_EXCL), and the lock file of a killed holder must
+// be removed manually.
+//
+// A lock file records the host and PID of its holder (for error
+// messages).
+func NewFileLocker(dir string) Locker {
+	return &fileLocker{dir: dir}
+}
+
+type fileLocker struct {
+	dir string
+}
+
+func (l *fileLocker) Lock(name string, wait bool) (func() error, error) {
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return nil, err
+	}
+	file := filepath.Join(l.dir, name+".lock")
+	host, _ := os.Hostname()
+	holder := "host " + host + ", pid " + strconv.Itoa(os.Getpid())
+	for {
+		f, err := tryLockFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			if err := f.Truncate(0); err == nil {
+				_, err = f.WriteString(holder + "\n")
+			}
+			if err != nil {
+				os.Remove(file)
+				f.Close()
+				return nil, err
+			}
+			vlog.Printf("Acquired store lock %s.", file)
+			return func() error {
+				vlog.Printf("Releasing store lock %s.", file)
+				// Remove the file before unlocking it, so that
+				// processes waiting for it know to try again (see
+				// tryLockFile).
+				err := os.Remove(file)
+				if err2 := f.Close(); err == nil {
+					err = err2
+				}
+				return err
+			}, nil
+		}
+		if !wait {
+			b, _ := ioutil.ReadFile(file)
+			return nil, &errLocked{name: file, holder: strings.TrimSpace(string(b))}
+		}
+		vlog.Printf("Waiting for store lock %s.", file)
+		time.Sleep(LockPollInterval)
+	}
+}
+
+// NewCommandLocker returns a Locker that runs a command to acquire and
+// release locks, so that a lock service can serialize writes to a
+// store shared by many hosts (e.g., an S3 store).
+//
+// The command is run by the shell with the arguments "lock NAME" or
+// "unlock NAME". When acquiring a lock, it must exit with status 0 if
+// it acquired the lock and 1 if the lock is held by another process;
+// any other exit status is an error.
+func NewCommandLocker(command string) Locker {
+	return &commandLocker{command: command}
+}
+
+type commandLocker struct {
+	command string
+}
+
+func (l *commandLocker) Lock(name string, wait bool) (func() error, error) {
+	for {
+		err := l.run("lock", name)
+		if err == nil {
+			return func() error { return l.run("unlock", name) }, nil
+		}
+		if !isExitStatus(err, 1) {
+			return nil, fmt.Errorf("store lock command %q: %s", l.command, err)
+		}
+		if !wait {
+			return nil, &errLocked{name: name}
+		}
+		vlog.Printf("Waiting for store lock %s.", name)
+		time.Sleep(LockPollInterval)
+	}
+}
+
+func (l *commandLocker) run(op, name string) error {
+	cmd := exec.Command("sh", "-c", l.command+` "$@"`, "sh", op, name)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
+
+// isExitStatus returns whether err reports that a command exited
+// with the given status.
+func isExitStatus(err error, status int) bool {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return ws.ExitStatus() == status
+		}
+	}
+	return false
+}
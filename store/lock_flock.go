@@ -0,0 +1,44 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile opens (creating if needed) and locks the lock file. It
+// returns nil and no error if the lock is held by another process.
+// The lock is released when the returned file is closed (or the
+// process exits).
+func tryLockFile(file string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		// The previous holder removes the file before releasing
+		// the lock, so if we locked a removed file (that we opened
+		// before it was removed), try again with the current file.
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if fi2, err := os.Stat(file); err == nil && os.SameFile(fi, fi2) {
+			return f, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			f.Close()
+			return nil, err
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,70 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestFileLockerHolder acquires a lock and holds it until it is
+// killed. It is run in a child process by
+// TestFileLocker_killedHolder.
+func TestFileLockerHolder(t *testing.T) {
+	dir := os.Getenv("SRCLIB_TEST_LOCK_DIR")
+	if dir == "" {
+		return
+	}
+	if _, err := NewFileLocker(dir).Lock("x", false); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	time.Sleep(time.Minute)
+	os.Exit(1)
+}
+
+func TestFileLocker_killedHolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileLockerHolder$")
+	cmd.Env = append(os.Environ(), "SRCLIB_TEST_LOCK_DIR="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "locked\n" {
+		t.Fatalf("lock holder: got %q (error: %v), want locked", line, err)
+	}
+
+	l := NewFileLocker(dir)
+	if _, err := l.Lock("x", false); !IsLocked(err) {
+		t.Fatalf("got error %v, want locked error", err)
+	}
+
+	// The lock is released when its holder is killed.
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+	unlock, err := l.Lock("x", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,16 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package store
+
+import "os"
+
+// tryLockFile creates the lock file exclusively. It returns nil and no
+// error if the file already exists (because the lock is held by
+// another process, or because its holder was killed).
+func tryLockFile(file string) (*os.File, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, nil
+	}
+	return f, err
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileLocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(d time.Duration) { LockPollInterval = d }(LockPollInterval)
+	LockPollInterval = time.Millisecond
+
+	l := NewFileLocker(dir)
+	name := LockName("example.com/repo", "c")
+	unlock, err := l.Lock(name, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other locks can be acquired.
+	unlock2, err := l.Lock(LockName("example.com/repo", "c2"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unlock2(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Lock(name, false); !IsLocked(err) {
+		t.Fatalf("got error %v, want locked error", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		unlock, err := l.Lock(name, true)
+		if err == nil {
+			err = unlock()
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquired held lock (error: %v)", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommandLocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A lock "service" that uses mkdir for mutual exclusion.
+	l := NewCommandLocker(`f() { case "$1" in lock) mkdir "` + dir + `/$2" 2>/dev/null || exit 1 ;; unlock) rmdir "` + dir + `/$2" ;; esac; }; f`)
+	unlock, err := l.Lock("x", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Lock("x", false); !IsLocked(err) {
+		t.Fatalf("got error %v, want locked error", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = l.Lock("x", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,8 +8,13 @@ import (
 	"strconv"
 )
 
+func vlogEnabled() bool {
+	v, _ := strconv.ParseBool(os.Getenv("V"))
+	return v
+}
+
 func vlogWriter() io.Writer {
-	if v, _ := strconv.ParseBool(os.Getenv("V")); v {
+	if vlogEnabled() {
 		return os.Stderr
 	}
 	return ioutil.Discard
@@ -20,3 +25,15 @@ func cyan(s string) string {
 }
 
 var vlog = log.New(vlogWriter(), cyan("▶ "), log.Lmicroseconds|log.Lshortfile)
+
+// SetVerboseLogOutput directs the store's verbose log messages (which
+// are only written if the V environment variable is true) to w,
+// without a prefix or flags. It is used by programs that need all log
+// output to be in a certain format.
+func SetVerboseLogOutput(w io.Writer) {
+	if vlogEnabled() {
+		vlog.SetOutput(w)
+		vlog.SetPrefix("")
+		vlog.SetFlags(0)
+	}
+}
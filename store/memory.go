@@ -2,7 +2,9 @@ package store
 
 import (
 	"errors"
+	"time"
 
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -12,6 +14,8 @@ import (
 type memoryMultiRepoStore struct {
 	repos map[string]*memoryRepoStore
 
+	changes []*Change // see ChangeLog
+
 	repoStores
 }
 
@@ -34,6 +38,7 @@ func (s *memoryMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
 			repos = append(repos, repo)
 		}
 	}
+	sortRepos(repos, f)
 	return repos, nil
 }
 
@@ -77,6 +82,11 @@ func (s *memoryMultiRepoStore) String() string { return "memoryMultiRepoStore" }
 type memoryRepoStore struct {
 	versions []*Version
 	trees    map[string]*memoryTreeStore
+	imported map[string]time.Time       // commit ID -> last import time
+	renames  map[[2]string][]*DefRename // (from, to) commit IDs -> renames
+
+	snapshots  []*Snapshot            // sorted by name
+	provenance map[string]*Provenance // commit ID -> provenance
 	treeStores
 }
 
@@ -92,6 +102,11 @@ func (s *memoryRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
 	if s.versions == nil {
 		return nil, errRepoNoInit
 	}
+	rf, err := resolveSnapshots(s, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]VersionFilter)
 
 	var versions []*Version
 	for _, version := range s.versions {
@@ -104,10 +119,16 @@ func (s *memoryRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
 }
 
 func (s *memoryRepoStore) Import(commitID string, unit *unit.SourceUnit, data graph.Output) error {
-	s.versions = append(s.versions, &Version{CommitID: commitID})
+	if _, present := s.trees[commitID]; !present {
+		s.versions = append(s.versions, &Version{CommitID: commitID})
+	}
 	if s.trees == nil {
 		s.trees = map[string]*memoryTreeStore{}
 	}
+	if s.imported == nil {
+		s.imported = map[string]time.Time{}
+	}
+	s.imported[commitID] = time.Now()
 	if _, present := s.trees[commitID]; !present {
 		s.trees[commitID] = newMemoryTreeStore()
 	}
@@ -144,6 +165,7 @@ func (s *memoryRepoStore) String() string { return "memoryRepoStore" }
 type memoryTreeStore struct {
 	units []*unit.SourceUnit
 	data  map[unit.ID2]*graph.Output
+	deps  map[unit.ID2][]*dep.ResolvedDep
 	unitStores
 }
 
@@ -254,6 +276,7 @@ func (s *memoryUnitStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 }
 
 func (s *memoryUnitStore) Import(data graph.Output) error {
+	attachDocs(&data)
 	cleanForImport(&data, "", "", "")
 	s.data = &data
 	return nil
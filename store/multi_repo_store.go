@@ -12,12 +12,14 @@ import (
 // RepoStore allows aliasing repository URIs and supporting both ID
 // and URI lookups.
 type MultiRepoStore interface {
-	// Repos returns all repositories that match the RepoFilter.
+	// Repos returns all repositories that match the RepoFilter,
+	// sorted lexicographically (unless an Unordered filter is given).
 	Repos(...RepoFilter) ([]string, error)
 
 	// RepoStore's methods call the corresponding methods on the
 	// RepoStore of each repository contained within this multi-repo
-	// store. The combined results are returned (in undefined order).
+	// store. The combined results are sorted by key (unless an
+	// Unordered filter is given).
 	RepoStore
 }
 
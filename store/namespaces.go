@@ -0,0 +1,130 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// RepoNamespace returns the namespace (see
+// FSMultiRepoStoreConf.Namespaces) that repo is in, or "" if it is in
+// none. If repo matches the prefixes of more than one namespace, the
+// namespace with the longest matching prefix is returned.
+func (c *FSMultiRepoStoreConf) RepoNamespace(repo string) string {
+	var ns, longest string
+	for name, prefixes := range c.Namespaces {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(repo, prefix) && (len(prefix) > len(longest) || (len(prefix) == len(longest) && name < ns)) {
+				ns, longest = name, prefix
+			}
+		}
+	}
+	return ns
+}
+
+// CheckScope returns an error if c.Scope names a namespace that is not
+// defined in c.Namespaces.
+func (c *FSMultiRepoStoreConf) CheckScope() error {
+	for _, ns := range c.Scope {
+		if _, ok := c.Namespaces[ns]; !ok {
+			var names []string
+			for name := range c.Namespaces {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("scope refers to undefined namespace %q (defined namespaces: %s)", ns, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// inScope returns whether repo (a canonical repo) is accessible in the
+// store's scope (see FSMultiRepoStoreConf.Scope).
+func (s *fsMultiRepoStore) inScope(repo string) bool {
+	if s.Scope == nil {
+		return true
+	}
+	ns := s.RepoNamespace(repo)
+	if ns == "" {
+		return false
+	}
+	for _, ns2 := range s.Scope {
+		if ns2 == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInScope returns an error for which IsOutOfScope returns true if
+// repo is not in the store's scope.
+func (s *fsMultiRepoStore) checkInScope(repo string) error {
+	if !s.inScope(repo) {
+		return &errOutOfScope{"write", repo}
+	}
+	return nil
+}
+
+// errOutOfScope is the error returned when data for a repo that is not
+// in a store's scope is written.
+type errOutOfScope struct {
+	op, name string
+}
+
+func (e *errOutOfScope) Error() string {
+	return fmt.Sprintf("%s %s: repo is not in the store's scope", e.op, e.name)
+}
+
+// IsOutOfScope returns a boolean indicating whether err reports that
+// a write to a repo that is not in the store's scope (see
+// FSMultiRepoStoreConf.Scope) was attempted.
+func IsOutOfScope(err error) bool {
+	_, ok := err.(*errOutOfScope)
+	return ok
+}
+
+// outOfScopeFS is the VFS of the repo stores of repos that are not in
+// a fsMultiRepoStore's scope. It has no files (so the repos appear to
+// have no data), and all writes to it fail with an error for which
+// IsOutOfScope returns true.
+type outOfScopeFS struct {
+	repo string
+}
+
+func (fs outOfScopeFS) notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func (fs outOfScopeFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	return nil, fs.notExist("open", name)
+}
+
+func (fs outOfScopeFS) Lstat(name string) (os.FileInfo, error) {
+	return nil, fs.notExist("lstat", name)
+}
+
+func (fs outOfScopeFS) Stat(name string) (os.FileInfo, error) {
+	return nil, fs.notExist("stat", name)
+}
+
+func (fs outOfScopeFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return nil, fs.notExist("readdir", name)
+}
+
+func (fs outOfScopeFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &errOutOfScope{"create", fs.repo}
+}
+
+func (fs outOfScopeFS) Mkdir(name string) error { return &errOutOfScope{"mkdir", fs.repo} }
+
+func (fs outOfScopeFS) Remove(name string) error { return &errOutOfScope{"remove", fs.repo} }
+
+func (fs outOfScopeFS) String() string { return "outofscope(" + fs.repo + ")" }
+
+var _ rwvfs.FileSystem = outOfScopeFS{}
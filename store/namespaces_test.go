@@ -0,0 +1,103 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFSMultiRepoStoreConf_RepoNamespace(t *testing.T) {
+	conf := &FSMultiRepoStoreConf{Namespaces: map[string][]string{
+		"acme":     {"github.com/acme/"},
+		"payments": {"github.com/acme/pay-", "git.acme.corp/payments/"},
+	}}
+	tests := map[string]string{
+		"github.com/acme/web":            "acme",
+		"github.com/acme/pay-api":        "payments",
+		"git.acme.corp/payments/ledger":  "payments",
+		"github.com/other/x":             "",
+		"git.acme.corp/infra/monitoring": "",
+	}
+	for repo, want := range tests {
+		if ns := conf.RepoNamespace(repo); ns != want {
+			t.Errorf("RepoNamespace(%q): got %q, want %q", repo, ns, want)
+		}
+	}
+
+	conf.Scope = []string{"acme", "nope"}
+	if err := conf.CheckScope(); err == nil {
+		t.Error("CheckScope with an undefined namespace: got no error")
+	}
+}
+
+func TestFSMultiRepoStore_Scope(t *testing.T) {
+	useIndexedStore = false
+	const (
+		web = "github.com/acme/web"
+		pay = "github.com/acme/pay-api"
+	)
+	fs := newTestFS()
+	all := NewFSMultiRepoStore(fs, nil)
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+	payData := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, File: "f"}},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+	}
+	if err := all.Import(pay, "c", u, payData); err != nil {
+		t.Fatal(err)
+	}
+	def := graph.RefDefKey{DefRepo: pay, DefUnitType: "t", DefUnit: "u", DefPath: "p"}
+	for _, repo := range []string{web, "github.com/other/x"} {
+		// Import modifies the refs (removing their implied fields), so
+		// each repo needs its own.
+		ref := &graph.Ref{DefRepo: def.DefRepo, DefUnitType: def.DefUnitType, DefUnit: def.DefUnit, DefPath: def.DefPath, File: "f", Start: 1, End: 2}
+		if err := all.Import(repo, "c", u, graph.Output{Refs: []*graph.Ref{ref}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, repo := range []string{pay, web, "github.com/other/x"} {
+		if err := all.(MultiRepoIndexer).Index(repo, "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scoped := NewFSMultiRepoStore(fs, &FSMultiRepoStoreConf{
+		Namespaces: map[string][]string{"web": {"github.com/acme/web"}, "payments": {"github.com/acme/pay-"}},
+		Scope:      []string{"web"},
+	})
+
+	repos, err := scoped.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{web}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("got repos %v, want %v", repos, want)
+	}
+
+	defs, err := scoped.Defs(ByRepos(pay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 0 {
+		t.Errorf("got %d defs in out-of-scope repo, want 0", len(defs))
+	}
+
+	// Only refs from in-scope repos are returned (including via the
+	// global ref index).
+	locs, err := scoped.(GlobalRefStore).RefLocations(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 1 || locs[0].Repo != web {
+		t.Errorf("got ref locations %v, want 1 in %s", locs, web)
+	}
+
+	if err := scoped.Import(pay, "c2", u, graph.Output{}); !IsOutOfScope(err) {
+		t.Errorf("Import into out-of-scope repo: got err %v, want out-of-scope error", err)
+	}
+	if err := scoped.Import(web, "c2", u, graph.Output{}); err != nil {
+		t.Errorf("Import into in-scope repo: %s", err)
+	}
+}
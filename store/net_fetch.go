@@ -0,0 +1,168 @@
+package store
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"code.google.com/p/rog-go/parallel"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// NetFetchConfig configures how FS-backed stores read data files
+// from network VFSs (those that implement rwvfs.FetcherOpener, such
+// as s3vfs). It has no effect on stores backed by local VFSs.
+type NetFetchConfig struct {
+	// Parallel is the maximum number of concurrent fetches issued by
+	// a single query.
+	Parallel int
+
+	// Retries is the number of times that a failed fetch is retried
+	// before the error is returned.
+	Retries int
+
+	// RetryBackoff is the delay before the first retry. It doubles
+	// after each subsequent failure.
+	RetryBackoff time.Duration
+
+	// CoalesceGap is the maximum number of bytes between two records
+	// that are read using a single ranged fetch. If negative, each
+	// record is fetched separately.
+	CoalesceGap int64
+
+	// MaxFetchSize is the maximum size (in bytes) of a ranged fetch
+	// that contains coalesced records. A single record may exceed it.
+	MaxFetchSize int64
+}
+
+// NetFetch is the configuration used by all FS-backed stores when
+// reading from network VFSs. It must not be modified while stores
+// are in use.
+var NetFetch = NetFetchConfig{
+	Parallel:     4,
+	Retries:      3,
+	RetryBackoff: 100 * time.Millisecond,
+	CoalesceGap:  16 * 1024,
+	MaxFetchSize: 1024 * 1024,
+}
+
+// sleep is time.Sleep, overridden in tests.
+var sleep = time.Sleep
+
+// withNetRetries calls f until it succeeds, returns a "not exist"
+// error, or has failed NetFetch.Retries+1 times, waiting
+// exponentially longer between attempts. It returns the last error.
+func withNetRetries(fs rwvfs.FileSystem, f func() error) error {
+	if _, ok := fs.(rwvfs.FetcherOpener); !ok {
+		return f()
+	}
+	backoff := NetFetch.RetryBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || isOSOrVFSNotExist(err) || i >= NetFetch.Retries {
+			return err
+		}
+		vlog.Printf("Network fetch failed (attempt %d of %d), retrying in %s: %s", i+1, NetFetch.Retries+1, backoff, err)
+		sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// A fetchGroup is a set of nearby byte offsets that are read using a
+// single ranged fetch of [start, end).
+type fetchGroup struct {
+	start, end int64
+	ofs        []int64
+}
+
+// coalesceOffsets groups ofs into fetchGroups according to
+// NetFetch.CoalesceGap and NetFetch.MaxFetchSize. Each record is
+// assumed to be at most byteEstimate bytes (readers fetch beyond the
+// group's range if needed). The groups, and the offsets within each
+// group, are sorted.
+func coalesceOffsets(ofs byteOffsets, byteEstimate int64) []*fetchGroup {
+	sorted := make([]int64, len(ofs))
+	copy(sorted, ofs)
+	sort.Sort(int64Slice(sorted))
+
+	var groups []*fetchGroup
+	var g *fetchGroup
+	for _, o := range sorted {
+		end := o + byteEstimate
+		if g != nil && NetFetch.CoalesceGap >= 0 && o <= g.end+NetFetch.CoalesceGap && end-g.start <= NetFetch.MaxFetchSize {
+			g.ofs = append(g.ofs, o)
+			if end > g.end {
+				g.end = end
+			}
+			continue
+		}
+		g = &fetchGroup{start: o, end: end, ofs: []int64{o}}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// readAtOffsets calls read once for each offset in ofs with a reader
+// positioned at that offset of the named file (f must be the result
+// of openFetcherOrOpen(fs, name)). It stops early if filters' limit
+// (see LimitRemaining) is reached, and fails if the query's context
+// is done (see WithContext).
+//
+// On network VFSs, nearby offsets are read using a single ranged
+// fetch (see coalesceOffsets), up to NetFetch.Parallel fetches are
+// issued concurrently, and read may be called concurrently (and in
+// any order). On other VFSs, read is called serially in the order of
+// ofs.
+func readAtOffsets(fs rwvfs.FileSystem, name string, f io.ReadSeeker, ofs byteOffsets, byteEstimate int64, filters interface{}, read func(r io.Reader) error) error {
+	p := parFetches(fs, filters)
+	if p == 0 {
+		return nil
+	}
+
+	if _, ok := fs.(rwvfs.FetcherOpener); !ok {
+		for _, o := range ofs {
+			if _, moreOK := LimitRemaining(filters); !moreOK {
+				return nil
+			}
+			if _, err := f.Seek(o, 0); err != nil {
+				return err
+			}
+			if err := read(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctl := getQueryControls(filters)
+	par := parallel.NewRun(p)
+	for _, g_ := range coalesceOffsets(ofs, byteEstimate) {
+		g := g_
+		par.Do(func() error {
+			if _, moreOK := LimitRemaining(filters); !moreOK {
+				return nil
+			}
+			if err := ctl.check(); err != nil {
+				return err
+			}
+			r, err := rangeReader(fs, name, f, g.start, g.end-g.start)
+			if err != nil {
+				return err
+			}
+			for _, o := range g.ofs {
+				if _, moreOK := LimitRemaining(filters); !moreOK {
+					return nil
+				}
+				if _, err := r.Seek(o, 0); err != nil {
+					return err
+				}
+				if err := read(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return par.Wait()
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestCoalesceOffsets(t *testing.T) {
+	defer func(c NetFetchConfig) { NetFetch = c }(NetFetch)
+	NetFetch.CoalesceGap = 10
+	NetFetch.MaxFetchSize = 100
+
+	tests := []struct {
+		ofs          byteOffsets
+		byteEstimate int64
+		gap          int64
+		want         []*fetchGroup
+	}{
+		{ofs: nil, byteEstimate: 5, gap: 10, want: nil},
+		{
+			ofs:          byteOffsets{0},
+			byteEstimate: 5,
+			gap:          10,
+			want:         []*fetchGroup{{0, 5, []int64{0}}},
+		},
+		{
+			ofs:          byteOffsets{30, 0, 10},
+			byteEstimate: 5,
+			gap:          10,
+			want:         []*fetchGroup{{0, 15, []int64{0, 10}}, {30, 35, []int64{30}}},
+		},
+		{
+			// Limited by MaxFetchSize.
+			ofs:          byteOffsets{0, 50, 90},
+			byteEstimate: 20,
+			gap:          100,
+			want:         []*fetchGroup{{0, 70, []int64{0, 50}}, {90, 110, []int64{90}}},
+		},
+		{
+			// Coalescing disabled.
+			ofs:          byteOffsets{0, 1},
+			byteEstimate: 5,
+			gap:          -1,
+			want:         []*fetchGroup{{0, 5, []int64{0}}, {1, 6, []int64{1}}},
+		},
+	}
+	for _, test := range tests {
+		NetFetch.CoalesceGap = test.gap
+		groups := coalesceOffsets(test.ofs, test.byteEstimate)
+		if !reflect.DeepEqual(groups, test.want) {
+			t.Errorf("%v (gap %d): got groups %v, want %v", test.ofs, test.gap, groups, test.want)
+		}
+	}
+}
+
+// flakyFetcherFS is a FetcherOpener whose fetches fail until failures
+// is decremented to 0.
+type flakyFetcherFS struct {
+	rwvfs.FileSystem
+
+	mu       sync.Mutex
+	failures int
+	fetches  [][2]int64
+}
+
+func (fs *flakyFetcherFS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyFetcher{f, fs}, nil
+}
+
+type flakyFetcher struct {
+	vfs.ReadSeekCloser
+	fs *flakyFetcherFS
+}
+
+func (f *flakyFetcher) Fetch(start, end int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.fs.failures > 0 {
+		f.fs.failures--
+		return errors.New("fetch failed")
+	}
+	f.fs.fetches = append(f.fs.fetches, [2]int64{start, end})
+	return nil
+}
+
+func TestReadAtOffsets_net(t *testing.T) {
+	defer func(c NetFetchConfig) { NetFetch = c }(NetFetch)
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	NetFetch = NetFetchConfig{Parallel: 1, Retries: 2, RetryBackoff: time.Millisecond, CoalesceGap: 2, MaxFetchSize: 100}
+
+	fs := &flakyFetcherFS{FileSystem: rwvfs.Map(map[string]string{"f": "abcdefghij"}), failures: 2}
+	f, err := openFetcherOrOpen(fs, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []string
+	err = readAtOffsets(fs, "f", f, byteOffsets{8, 0, 2}, 1, nil, func(r io.Reader) error {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		got = append(got, string(b))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if want := []string{"a", "c", "i"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if want := [][2]int64{{0, 3}, {8, 9}}; !reflect.DeepEqual(fs.fetches, want) {
+		t.Errorf("got fetches %v, want %v", fs.fetches, want)
+	}
+	if want := []time.Duration{time.Millisecond, 2 * time.Millisecond}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("got backoffs %v, want %v", slept, want)
+	}
+
+	// Give up after NetFetch.Retries retries.
+	fs.failures = 3
+	err = readAtOffsets(fs, "f", f, byteOffsets{0}, 1, nil, func(io.Reader) error { return nil })
+	if err == nil {
+		t.Error("got err == nil, want fetch error")
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// NormalizeReport describes the changes made by Normalize.
+type NormalizeReport struct {
+	DuplicateRefs int // identical refs that were removed
+	InvalidDefs   int // defs with invalid keys that were removed
+	CleanedPaths  int // file paths that were canonicalized
+}
+
+// Changed returns whether Normalize changed anything.
+func (r NormalizeReport) Changed() bool {
+	return r.DuplicateRefs > 0 || r.InvalidDefs > 0 || r.CleanedPaths > 0
+}
+
+func (r NormalizeReport) String() string {
+	return fmt.Sprintf("removed %d duplicate refs and %d defs with invalid keys, cleaned %d file paths", r.DuplicateRefs, r.InvalidDefs, r.CleanedPaths)
+}
+
+// Normalize normalizes a source unit's graph data before it is
+// imported. It canonicalizes file paths (cleaning them and using
+// forward slashes), removes defs whose keys are invalid (i.e., that
+// have no path), and removes refs that are identical to a previous
+// ref (after their file paths are canonicalized).
+func Normalize(data *graph.Output) NormalizeReport {
+	var r NormalizeReport
+	clean := func(file *string) {
+		if *file == "" {
+			return
+		}
+		if c := cleanFilePath(*file); c != *file {
+			*file = c
+			r.CleanedPaths++
+		}
+	}
+
+	defs := data.Defs[:0]
+	for _, def := range data.Defs {
+		if def.Path == "" {
+			r.InvalidDefs++
+			continue
+		}
+		clean(&def.File)
+		defs = append(defs, def)
+	}
+	for i := len(defs); i < len(data.Defs); i++ {
+		data.Defs[i] = nil
+	}
+	data.Defs = defs
+
+	seen := make(map[graph.Ref]struct{}, len(data.Refs))
+	refs := data.Refs[:0]
+	for _, ref := range data.Refs {
+		clean(&ref.File)
+		if _, dup := seen[*ref]; dup {
+			r.DuplicateRefs++
+			continue
+		}
+		seen[*ref] = struct{}{}
+		refs = append(refs, ref)
+	}
+	for i := len(refs); i < len(data.Refs); i++ {
+		data.Refs[i] = nil
+	}
+	data.Refs = refs
+
+	for _, doc := range data.Docs {
+		clean(&doc.File)
+	}
+	for _, ann := range data.Anns {
+		clean(&ann.File)
+	}
+	for _, call := range data.Calls {
+		clean(&call.File)
+	}
+	return r
+}
+
+// cleanFilePath returns the canonical form of a file path in graph
+// data: cleaned, with forward slashes, and relative (without a
+// leading "./").
+func cleanFilePath(file string) string {
+	return path.Clean(strings.Replace(file, `\`, "/", -1))
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestNormalize(t *testing.T) {
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "p"}, File: "./a/b.go"},
+			{DefKey: graph.DefKey{Path: ""}, File: "a/b.go"},
+		},
+		Refs: []*graph.Ref{
+			{DefPath: "p", File: `a\b.go`, Start: 1, End: 2},
+			{DefPath: "p", File: "a/b.go", Start: 1, End: 2},
+			{DefPath: "p", File: "a/b.go", Start: 3, End: 4},
+		},
+	}
+	r := Normalize(&data)
+
+	if want := (NormalizeReport{DuplicateRefs: 1, InvalidDefs: 1, CleanedPaths: 2}); r != want {
+		t.Errorf("got report %+v, want %+v", r, want)
+	}
+	if !r.Changed() {
+		t.Error("got Changed() == false, want true")
+	}
+	if len(data.Defs) != 1 || data.Defs[0].File != "a/b.go" {
+		t.Errorf("got defs %v, want 1 def in file a/b.go", data.Defs)
+	}
+	wantRefs := []*graph.Ref{
+		{DefPath: "p", File: "a/b.go", Start: 1, End: 2},
+		{DefPath: "p", File: "a/b.go", Start: 3, End: 4},
+	}
+	if !reflect.DeepEqual(data.Refs, wantRefs) {
+		t.Errorf("got refs %v, want %v", data.Refs, wantRefs)
+	}
+
+	if r := Normalize(&data); r.Changed() {
+		t.Errorf("normalizing again: got report %+v, want no changes", r)
+	}
+}
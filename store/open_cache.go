@@ -0,0 +1,43 @@
+package store
+
+import "sync"
+
+// CacheOpenStores is whether FS-backed stores cache the repo, tree,
+// and unit stores that they open (instead of opening them anew for
+// each query). Indexes are read lazily by the stores that use them,
+// so caching the stores keeps indexes in memory ("warm") after their
+// first use, which makes subsequent queries much faster.
+//
+// The cache is never invalidated, so it should only be enabled in
+// long-running processes that do not import data into (or otherwise
+// modify) the stores they query, such as editor backends.
+var CacheOpenStores = false
+
+// openStoreCache caches opened stores when CacheOpenStores is true.
+type openStoreCache struct {
+	mu     sync.Mutex
+	stores map[interface{}]interface{}
+}
+
+// get returns the cached store for key, calling open to open (and
+// cache) it if it is not yet cached. If CacheOpenStores is false, it
+// just calls open.
+func (c *openStoreCache) get(key interface{}, open func() interface{}) interface{} {
+	if !CacheOpenStores {
+		return open()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, present := c.stores[key]; present {
+		openCacheCounter.hit()
+		return s
+	}
+	openCacheCounter.miss()
+	if c.stores == nil {
+		c.stores = map[interface{}]interface{}{}
+	}
+	s := open()
+	c.stores[key] = s
+	return s
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestWarmIndexes_CacheOpenStores(t *testing.T) {
+	useIndexedStore = true
+	CacheOpenStores = true
+	defer func() { CacheOpenStores = false }()
+
+	mrs := NewFSMultiRepoStore(newTestFS(), nil)
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	if err := mrs.Import("r", "c", u, graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mrs.(MultiRepoIndexer).Index("r", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	openUnitStore := func() *indexedUnitStore {
+		rs := mrs.(*fsMultiRepoStore).openRepoStore("r")
+		ts := rs.(*fsRepoStore).openTreeStore("c")
+		return ts.(*indexedTreeStore).openUnitStore(u.ID2()).(*indexedUnitStore)
+	}
+	if us1, us2 := openUnitStore(), openUnitStore(); us1 != us2 {
+		t.Fatalf("got different unit stores %p and %p, want the cached store to be reused", us1, us2)
+	}
+
+	// Make the cached store's index unready, as it would be in a
+	// newly opened store.
+	x := openUnitStore().indexes["path_to_def"].(*defPathIndex)
+	x.ready = false
+
+	xs, err := WarmIndexes(mrs, IndexCriteria{Repo: "r", CommitID: "c"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xs) == 0 {
+		t.Fatal("got no warmed indexes")
+	}
+	for _, sx := range xs {
+		if sx.Error != "" {
+			t.Errorf("index %s: %s", sx.Name, sx.Error)
+		}
+	}
+	if !x.Ready() {
+		t.Error("index is not ready after WarmIndexes")
+	}
+}
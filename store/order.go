@@ -0,0 +1,177 @@
+package store
+
+import (
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// Unordered returns a filter that tells the store that the caller
+// does not care about the order of results. By default, stores
+// return repos, versions, units, defs, refs, deps, calls, and
+// relations sorted by their keys so that output is identical across
+// runs. Sorting large result sets is not free, so callers that don't
+// need deterministic output (and that are sensitive to latency) can
+// pass Unordered to skip it.
+//
+// Unordered selects all items; it only affects ordering.
+func Unordered() interface {
+	DefFilter
+	RefFilter
+	UnitFilter
+	VersionFilter
+	RepoFilter
+	DepFilter
+	CallFilter
+	RelationFilter
+} {
+	return unorderedFilter{}
+}
+
+type unorderedFilter struct{}
+
+func (unorderedFilter) String() string                      { return "Unordered" }
+func (unorderedFilter) SelectDef(*graph.Def) bool           { return true }
+func (unorderedFilter) SelectRef(*graph.Ref) bool           { return true }
+func (unorderedFilter) SelectUnit(*unit.SourceUnit) bool    { return true }
+func (unorderedFilter) SelectVersion(*Version) bool         { return true }
+func (unorderedFilter) SelectRepo(string) bool              { return true }
+func (unorderedFilter) SelectDep(*dep.ResolvedDep) bool     { return true }
+func (unorderedFilter) SelectCall(*graph.Call) bool         { return true }
+func (unorderedFilter) SelectRelation(*graph.Relation) bool { return true }
+
+// isUnordered returns whether filters contains an Unordered filter.
+func isUnordered(filters interface{}) bool {
+	for _, f := range storeFilters(filters) {
+		if _, ok := f.(unorderedFilter); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sortDefs sorts defs in place. If fs contains a DefsSorter, its
+// ordering is used; otherwise defs are sorted by their DefKey (unless
+// fs contains an Unordered filter).
+func sortDefs(defs []*graph.Def, fs []DefFilter) {
+	for _, f := range fs {
+		if dSort, ok := f.(DefsSorter); ok {
+			dSort.DefsSort(defs)
+			return
+		}
+	}
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(graph.Defs(defs))
+}
+
+// sortRefs sorts refs in place by their key (unless fs contains an
+// Unordered filter).
+func sortRefs(refs []*graph.Ref, fs []RefFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(refsByKey(refs))
+}
+
+// sortUnits sorts units in place by (repo, commit ID, type, name)
+// (unless fs contains an Unordered filter).
+func sortUnits(units []*unit.SourceUnit, fs []UnitFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(unitsByKey(units))
+}
+
+// sortVersions sorts versions in place by (repo, commit ID) (unless
+// fs contains an Unordered filter).
+func sortVersions(versions []*Version, fs []VersionFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(versionsByKey(versions))
+}
+
+// sortRepos sorts repos in place (unless fs contains an Unordered
+// filter).
+func sortRepos(repos []string, fs []RepoFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Strings(repos)
+}
+
+// refsByKey sorts refs by (repo, commit ID, unit type, unit, file,
+// start, end, def key). Unlike graph.Refs, it does not allocate a
+// sort key string for each comparison.
+type refsByKey []*graph.Ref
+
+func (v refsByKey) Len() int      { return len(v) }
+func (v refsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v refsByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.CommitID != b.CommitID {
+		return a.CommitID < b.CommitID
+	}
+	if a.UnitType != b.UnitType {
+		return a.UnitType < b.UnitType
+	}
+	if a.Unit != b.Unit {
+		return a.Unit < b.Unit
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Start != b.Start {
+		return a.Start < b.Start
+	}
+	if a.End != b.End {
+		return a.End < b.End
+	}
+	if a.DefRepo != b.DefRepo {
+		return a.DefRepo < b.DefRepo
+	}
+	if a.DefUnitType != b.DefUnitType {
+		return a.DefUnitType < b.DefUnitType
+	}
+	if a.DefUnit != b.DefUnit {
+		return a.DefUnit < b.DefUnit
+	}
+	return a.DefPath < b.DefPath
+}
+
+type unitsByKey []*unit.SourceUnit
+
+func (v unitsByKey) Len() int      { return len(v) }
+func (v unitsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v unitsByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.CommitID != b.CommitID {
+		return a.CommitID < b.CommitID
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return a.Name < b.Name
+}
+
+type versionsByKey []*Version
+
+func (v versionsByKey) Len() int      { return len(v) }
+func (v versionsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v versionsByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	return a.CommitID < b.CommitID
+}
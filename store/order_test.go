@@ -0,0 +1,105 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMultiRepoStore_deterministicOrder(t *testing.T) {
+	mrs := newMemoryMultiRepoStore()
+	for _, repo := range []string{"r3", "r1", "r2"} {
+		for _, name := range []string{"u2", "u1"} {
+			u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{"f"}}
+			data := graph.Output{
+				Defs: []*graph.Def{
+					{DefKey: graph.DefKey{Path: "p2"}},
+					{DefKey: graph.DefKey{Path: "p1"}},
+				},
+				Refs: []*graph.Ref{
+					{DefPath: "p1", File: "f", Start: 5, End: 6},
+					{DefPath: "p2", File: "f", Start: 1, End: 2},
+				},
+			}
+			if err := mrs.Import(repo, "c", u, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	repos, err := mrs.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"r1", "r2", "r3"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("Repos(): got %v, want %v", repos, want)
+	}
+
+	units, err := mrs.Units()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unitKeys []unit.Key
+	for _, u := range units {
+		unitKeys = append(unitKeys, u.Key())
+	}
+	wantUnitKeys := []unit.Key{
+		{Repo: "r1", CommitID: "c", UnitType: "t", Unit: "u1"},
+		{Repo: "r1", CommitID: "c", UnitType: "t", Unit: "u2"},
+		{Repo: "r2", CommitID: "c", UnitType: "t", Unit: "u1"},
+		{Repo: "r2", CommitID: "c", UnitType: "t", Unit: "u2"},
+		{Repo: "r3", CommitID: "c", UnitType: "t", Unit: "u1"},
+		{Repo: "r3", CommitID: "c", UnitType: "t", Unit: "u2"},
+	}
+	if !reflect.DeepEqual(unitKeys, wantUnitKeys) {
+		t.Errorf("Units(): got %v, want %v", unitKeys, wantUnitKeys)
+	}
+
+	// Run the queries multiple times to make sure that map iteration
+	// order doesn't leak into the results.
+	var firstDefs []graph.DefKey
+	var firstRefs []graph.RefKey
+	for i := 0; i < 5; i++ {
+		defs, err := mrs.Defs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defKeys := graph.Defs(defs).Keys()
+		if i == 0 {
+			firstDefs = defKeys
+			if defKeys[0].Repo != "r1" || defKeys[0].Unit != "u1" || defKeys[0].Path != "p1" {
+				t.Errorf("Defs(): got first def %+v, want r1 u1 p1", defKeys[0])
+			}
+		} else if !reflect.DeepEqual(defKeys, firstDefs) {
+			t.Errorf("Defs(): got %v, want %v (same order as first call)", defKeys, firstDefs)
+		}
+
+		refs, err := mrs.Refs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var refKeys []graph.RefKey
+		for _, ref := range refs {
+			refKeys = append(refKeys, ref.RefKey())
+		}
+		if i == 0 {
+			firstRefs = refKeys
+			if refKeys[0].Repo != "r1" || refKeys[0].Unit != "u1" || refKeys[0].Start != 1 {
+				t.Errorf("Refs(): got first ref %+v, want r1 u1 start=1", refKeys[0])
+			}
+		} else if !reflect.DeepEqual(refKeys, firstRefs) {
+			t.Errorf("Refs(): got %v, want %v (same order as first call)", refKeys, firstRefs)
+		}
+	}
+
+	// Unordered should return the same set of results.
+	defs, err := mrs.Defs(Unordered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != len(firstDefs) {
+		t.Errorf("Defs(Unordered): got %d defs, want %d", len(defs), len(firstDefs))
+	}
+}
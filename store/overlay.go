@@ -0,0 +1,325 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// OverlayStore returns a RepoStore that merges the data of overlay
+// commits over the data of their base commits. An overlay commit is
+// one whose data was imported for only the source units that changed
+// since another commit, its base, which is recorded as the
+// BaseCommitID of its provenance (see Provenance). This is typically
+// the commit of an uncommitted working tree (see
+// WorkingTreeCommitID), so that only the source units affected by
+// local changes need to be re-analyzed.
+//
+// When a query selects an overlay commit (with a ByCommitIDs,
+// ByRepoCommitIDs, ByUnitKey, or ByDefKey filter), the overlay
+// commit's source units shadow the same source units at its base
+// commit, and the data of the base commit's other source units is
+// returned as though it were at the overlay commit. In a
+// MultiRepoStore, a ByCommitIDs filter only selects an overlay commit
+// if the query also has a ByRepos filter.
+//
+// A Limit filter is applied to the merged results, not to each
+// commit's results.
+func OverlayStore(s interface {
+	RepoStore
+	ProvenanceStore
+}) RepoStore {
+	_, multi := s.(MultiRepoStore)
+	return &overlayStore{s: s, multi: multi}
+}
+
+type overlayStore struct {
+	s interface {
+		RepoStore
+		ProvenanceStore
+	}
+	multi bool // whether s is a MultiRepoStore
+}
+
+// An overlay is an overlay commit selected by a query.
+type overlay struct {
+	Version             // the overlay commit (Repo is empty in a RepoStore)
+	baseCommitID string // the base commit
+	units        map[unit.ID2]struct{}
+}
+
+// shadows returns whether the overlay's data for the source unit
+// replaces its data at the base commit.
+func (o *overlay) shadows(unitType, unitName string) bool {
+	_, present := o.units[unit.ID2{Type: unitType, Name: unitName}]
+	return present
+}
+
+// overlays returns the overlay commits that filters select.
+func (s *overlayStore) overlays(filters interface{}) ([]*overlay, error) {
+	sf := storeFilters(filters)
+
+	var repos []string
+	if !s.multi {
+		repos = []string{""}
+	}
+	for _, f := range sf {
+		if f, ok := f.(byReposFilter); ok && s.multi {
+			repos = f
+		}
+	}
+
+	var versions []Version
+	addVersion := func(repo, commitID string) {
+		if !s.multi {
+			repo = ""
+		}
+		if (s.multi && repo == "") || commitID == "" {
+			return
+		}
+		v := Version{Repo: repo, CommitID: commitID}
+		for _, v2 := range versions {
+			if v2 == v {
+				return
+			}
+		}
+		versions = append(versions, v)
+	}
+	for _, f := range sf {
+		switch f := f.(type) {
+		case byCommitIDsFilter:
+			for _, commitID := range f {
+				for _, repo := range repos {
+					addVersion(repo, commitID)
+				}
+			}
+		case byRepoCommitIDsFilter:
+			for _, v := range f {
+				addVersion(v.Repo, v.CommitID)
+			}
+		case byUnitKeyFilter:
+			addVersion(f.key.Repo, f.key.CommitID)
+		case byDefKeyFilter:
+			addVersion(f.key.Repo, f.key.CommitID)
+		}
+	}
+
+	var ovs []*overlay
+	for _, v := range versions {
+		p, err := s.s.Provenance(v.Repo, v.CommitID)
+		if IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if p.BaseCommitID == "" || p.BaseCommitID == v.CommitID {
+			continue
+		}
+
+		var uf UnitFilter = ByCommitIDs(v.CommitID)
+		if v.Repo != "" {
+			uf = ByRepoCommitIDs(v)
+		}
+		units, err := s.s.Units(uf, Unordered())
+		if err != nil {
+			return nil, err
+		}
+		o := &overlay{Version: v, baseCommitID: p.BaseCommitID, units: make(map[unit.ID2]struct{}, len(units))}
+		for _, u := range units {
+			o.units[u.ID2()] = struct{}{}
+		}
+		ovs = append(ovs, o)
+	}
+	return ovs, nil
+}
+
+// overlayOf returns the overlay (of ovs) whose base commit is the
+// repo's commitID, or nil if there is none.
+func overlayOf(ovs []*overlay, repo, commitID string) *overlay {
+	for _, o := range ovs {
+		if o.baseCommitID == commitID && (o.Repo == "" || o.Repo == repo) {
+			return o
+		}
+	}
+	return nil
+}
+
+// overlayFor returns the overlay (of ovs) that is the repo's
+// commitID, or nil if there is none.
+func overlayFor(ovs []*overlay, repo, commitID string) *overlay {
+	for _, o := range ovs {
+		if o.CommitID == commitID && (o.Repo == "" || o.Repo == repo) {
+			return o
+		}
+	}
+	return nil
+}
+
+// withoutLimit returns a copy of filters (a typed slice of filters,
+// such as []DefFilter) without its Limit filter, which is returned
+// separately (or nil if there is none).
+func withoutLimit(filters interface{}) (interface{}, *limiter) {
+	var l *limiter
+	var fs []interface{}
+	for _, f := range storeFilters(filters) {
+		if f, ok := f.(*limiter); ok {
+			l = f
+			continue
+		}
+		fs = append(fs, f)
+	}
+	return toTypedFilterSlice(reflect.TypeOf(filters), fs), l
+}
+
+// limitBounds returns the bounds of the n merged results that l
+// selects.
+func limitBounds(l *limiter, n int) (start, end int) {
+	if l == nil {
+		return 0, n
+	}
+	return min(l.ofs, n), min(l.ofs+l.n, n)
+}
+
+// baseFilters returns a copy of filters (a typed slice of filters,
+// such as []DefFilter) that selects the base commits of ovs instead
+// of the overlay commits.
+func baseFilters(filters interface{}, ovs []*overlay) interface{} {
+	sf := storeFilters(filters)
+	based := make([]interface{}, len(sf))
+	for i, f := range sf {
+		switch f := f.(type) {
+		case byCommitIDsFilter:
+			var commitIDs []string
+			for _, o := range ovs {
+				if f.contains(o.CommitID) && !byCommitIDsFilter(commitIDs).contains(o.baseCommitID) {
+					commitIDs = append(commitIDs, o.baseCommitID)
+				}
+			}
+			based[i] = byCommitIDsFilter(commitIDs)
+
+		case byRepoCommitIDsFilter:
+			var versions []Version
+			for _, v := range f {
+				if o := overlayFor(ovs, v.Repo, v.CommitID); o != nil {
+					v.CommitID = o.baseCommitID
+					versions = append(versions, v)
+				}
+			}
+			based[i] = byRepoCommitIDsFilter(versions)
+
+		case byUnitKeyFilter:
+			if o := overlayFor(ovs, f.key.Repo, f.key.CommitID); o != nil {
+				f.key.CommitID = o.baseCommitID
+			}
+			based[i] = f
+
+		case byDefKeyFilter:
+			if o := overlayFor(ovs, f.key.Repo, f.key.CommitID); o != nil {
+				f.key.CommitID = o.baseCommitID
+			}
+			based[i] = f
+
+		default:
+			based[i] = f
+		}
+	}
+	return toTypedFilterSlice(reflect.TypeOf(filters), based)
+}
+
+func (s *overlayStore) Versions(f ...VersionFilter) ([]*Version, error) {
+	return s.s.Versions(f...)
+}
+
+func (s *overlayStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
+	ovs, err := s.overlays(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(ovs) == 0 {
+		return s.s.Units(f...)
+	}
+
+	units, err := s.s.Units(f...)
+	if err != nil {
+		return nil, err
+	}
+	baseUnits, err := s.s.Units(baseFilters(f, ovs).([]UnitFilter)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range baseUnits {
+		if o := overlayOf(ovs, u.Repo, u.CommitID); o != nil && !o.shadows(u.Type, u.Name) {
+			u2 := *u
+			u2.CommitID = o.CommitID
+			units = append(units, &u2)
+		}
+	}
+	sortUnits(units, f)
+	return units, nil
+}
+
+func (s *overlayStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	ovs, err := s.overlays(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(ovs) == 0 {
+		return s.s.Defs(f...)
+	}
+
+	fs, l := withoutLimit(f)
+	f = fs.([]DefFilter)
+	defs, err := s.s.Defs(f...)
+	if err != nil {
+		return nil, err
+	}
+	baseDefs, err := s.s.Defs(baseFilters(f, ovs).([]DefFilter)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range baseDefs {
+		if o := overlayOf(ovs, def.Repo, def.CommitID); o != nil && !o.shadows(def.UnitType, def.Unit) {
+			def2 := *def
+			def2.CommitID = o.CommitID
+			defs = append(defs, &def2)
+		}
+	}
+	sortDefs(defs, f)
+	start, end := limitBounds(l, len(defs))
+	return defs[start:end], nil
+}
+
+func (s *overlayStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	ovs, err := s.overlays(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(ovs) == 0 {
+		return s.s.Refs(f...)
+	}
+
+	fs, l := withoutLimit(f)
+	f = fs.([]RefFilter)
+	refs, err := s.s.Refs(f...)
+	if err != nil {
+		return nil, err
+	}
+	baseRefs, err := s.s.Refs(baseFilters(f, ovs).([]RefFilter)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range baseRefs {
+		if o := overlayOf(ovs, ref.Repo, ref.CommitID); o != nil && !o.shadows(ref.UnitType, ref.Unit) {
+			ref2 := *ref
+			ref2.CommitID = o.CommitID
+			refs = append(refs, &ref2)
+		}
+	}
+	sortRefs(refs, f)
+	start, end := limitBounds(l, len(refs))
+	return refs[start:end], nil
+}
+
+func (s *overlayStore) String() string { return fmt.Sprintf("OverlayStore(%s)", s.s) }
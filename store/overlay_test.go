@@ -0,0 +1,100 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_Overlay(t *testing.T) {
+	testOverlayStore(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Overlay(t *testing.T) {
+	useIndexedStore = false
+	testOverlayStore(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testOverlayStore(t *testing.T, mrs MultiRepoStoreImporter) {
+	const repo = "r"
+	imp := func(commitID, unitName, defPath string) {
+		u := &unit.SourceUnit{Type: "t", Name: unitName, Files: []string{unitName + ".f"}}
+		data := graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: defPath}, Name: defPath, File: unitName + ".f"}},
+			Refs: []*graph.Ref{{DefUnitType: "t", DefUnit: unitName, DefPath: defPath, File: unitName + ".f", Start: 1, End: 2}},
+		}
+		if err := mrs.Import(repo, commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imp("base", "u1", "p1")
+	imp("base", "u2", "p2")
+	imp("wt", "u2", "p2new") // u2 changed in the working tree
+	if err := mrs.(ProvenanceImporter).ImportProvenance(repo, "wt", &Provenance{Units: 1, BaseCommitID: "base"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := OverlayStore(mrs.(interface {
+		RepoStore
+		ProvenanceStore
+	}))
+
+	defPaths := func(filters ...DefFilter) []string {
+		defs, err := s.Defs(filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, def := range defs {
+			if def.CommitID != "wt" {
+				t.Errorf("%v: got def %s at commit %q, want %q", filters, def.Path, def.CommitID, "wt")
+			}
+			paths = append(paths, def.Path)
+		}
+		return paths
+	}
+	if got, want := defPaths(ByRepoCommitIDs(Version{Repo: repo, CommitID: "wt"})), []string{"p1", "p2new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByRepoCommitIDs: got defs %v, want %v", got, want)
+	}
+	if got, want := defPaths(ByRepos(repo), ByCommitIDs("wt")), []string{"p1", "p2new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByRepos and ByCommitIDs: got defs %v, want %v", got, want)
+	}
+	if got, want := defPaths(ByDefKey(graph.DefKey{Repo: repo, CommitID: "wt", UnitType: "t", Unit: "u1", Path: "p1"})), []string{"p1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByDefKey of unchanged def: got defs %v, want %v", got, want)
+	}
+	if got := defPaths(ByDefKey(graph.DefKey{Repo: repo, CommitID: "wt", UnitType: "t", Unit: "u2", Path: "p2"})); len(got) != 0 {
+		t.Errorf("ByDefKey of shadowed def: got defs %v, want none", got)
+	}
+	if got, want := defPaths(ByRepoCommitIDs(Version{Repo: repo, CommitID: "wt"}), Limit(1, 1)), []string{"p2new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Limit: got defs %v, want %v", got, want)
+	}
+
+	refs, err := s.Refs(ByRepoCommitIDs(Version{Repo: repo, CommitID: "wt"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refDefPaths []string
+	for _, ref := range refs {
+		refDefPaths = append(refDefPaths, ref.DefPath)
+	}
+	if want := []string{"p1", "p2new"}; !reflect.DeepEqual(refDefPaths, want) {
+		t.Errorf("got refs to %v, want refs to %v", refDefPaths, want)
+	}
+
+	units, err := s.Units(ByRepoCommitIDs(Version{Repo: repo, CommitID: "wt"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 || units[0].Name != "u1" || units[0].CommitID != "wt" || units[1].Name != "u2" {
+		t.Errorf("got units %v, want u1 and u2 at wt", units)
+	}
+
+	// The base commit's data is unchanged.
+	if defs, err := s.Defs(ByRepoCommitIDs(Version{Repo: repo, CommitID: "base"})); err != nil {
+		t.Fatal(err)
+	} else if len(defs) != 2 || defs[1].Path != "p2" || defs[1].CommitID != "base" {
+		t.Errorf("got base defs %v, want p1 and p2 at base", defs)
+	}
+}
@@ -0,0 +1,952 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kr/fs"
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// A Compactor merges the many small per-unit data files of a commit
+// into a single pack file (with an index of the offsets of the files
+// in it, like a git packfile). Commits with many source units are
+// much faster to enumerate and read once their data is compacted,
+// especially on network VFSs such as S3.
+//
+// Packed files are read transparently by the FS-backed stores, so
+// compaction doesn't change the results of queries. Files written
+// after compaction (e.g., by a reimport) are stored as loose files
+// until the commit is compacted again.
+type Compactor interface {
+	// Compact merges the loose per-unit data files of repo at
+	// commitID (and the files in its existing pack file, if any)
+	// into a new pack file. The repo is only used by
+	// MultiRepoStores. If opt is nil, the default options are used.
+	Compact(repo, commitID string, opt *CompactOpt) (*CompactStats, error)
+}
+
+// CompactOpt configures compaction.
+type CompactOpt struct {
+	// DeltaBase, if set, is the commit (or snapshot name) whose data
+	// the packed files are stored as deltas against. Most defs and
+	// refs are identical in consecutive commits, so storing a commit
+	// as deltas against its parent takes much less space. Deltas are
+	// resolved when the files are read, which is slower than reading
+	// full files. The base commit must remain in the store, and it
+	// can't be reimported (Import fails) until the commits stored as
+	// deltas against it are recompacted without it.
+	//
+	// If DeltaBase is empty, the packed files are stored in full.
+	DeltaBase string
+
+	// Dedup, if set, stores each source unit's data files in a blob
+	// (in a dir shared by all of the repo's commits) that is named by
+	// the hash of its contents, instead of in the commit's pack file.
+	// Units that are unchanged between commits share a single blob.
+	// The unit and deps files, which refer to the commit, are still
+	// stored in the pack file. It can't be used with DeltaBase.
+	Dedup bool
+}
+
+// maxDeltaChain is the max number of commits whose data must be read
+// to read a commit whose data is stored as deltas (whose base commit
+// may itself be stored as deltas, and so on).
+const maxDeltaChain = 10
+
+// CompactStats describes the result of compacting a commit's data.
+type CompactStats struct {
+	Files  int   // number of files in the pack file
+	Loose  int   // number of loose files merged into the pack file
+	Deltas int   // number of files stored as deltas (see CompactOpt.DeltaBase)
+	Size   int64 // size (in bytes) of the pack file (or of the new blobs)
+
+	// Blobs is the number of blobs that the commit's data is stored
+	// in (see CompactOpt.Dedup), and NewBlobs is the number of them
+	// that were written (because no other commit had a unit with
+	// identical data).
+	Blobs, NewBlobs int
+
+	// Unchanged is whether the commit was already compacted (with the
+	// same options), in which case no new pack file was written.
+	Unchanged bool
+}
+
+func (s *fsMultiRepoStore) Compact(repo, commitID string, opt *CompactOpt) (*CompactStats, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("Compact: repo: empty")
+	}
+	repo = s.canonicalRepo(repo)
+	if err := s.checkInScope(repo); err != nil {
+		return nil, err
+	}
+	if err := upgradeFormat(s.fs, packFormatVersion, false); err != nil {
+		return nil, err
+	}
+	return s.openRepoStore(repo).(Compactor).Compact(repo, commitID, opt)
+}
+
+func (s *fsRepoStore) Compact(repo, commitID string, opt *CompactOpt) (*CompactStats, error) {
+	if opt == nil {
+		opt = &CompactOpt{}
+	}
+	snapshots, err := s.Snapshots(repo)
+	if err != nil {
+		return nil, err
+	}
+	commitID = snapshotCommitID(snapshots, commitID)
+	if _, err := s.fs.Stat(commitID); err != nil {
+		return nil, err
+	}
+
+	opt2 := *opt
+	if opt.DeltaBase != "" {
+		if opt.Dedup {
+			return nil, fmt.Errorf("Compact: DeltaBase and Dedup can't both be set")
+		}
+		opt2.DeltaBase = snapshotCommitID(snapshots, opt.DeltaBase)
+		if _, err := s.fs.Stat(opt2.DeltaBase); err != nil {
+			return nil, err
+		}
+		if err := s.checkDeltaBase(commitID, opt2.DeltaBase); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Dedup {
+		if err := rwvfs.MkdirAll(s.fs, blobsDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := upgradeFormat(s.fs, packFormatVersion, true); err != nil {
+		return nil, err
+	}
+	stats, err := compactPack(rwvfs.Sub(s.fs, commitID), s.treeStoreFS, s.blobsFS(), opt2)
+	if err != nil {
+		return nil, err
+	}
+	if opt2.DeltaBase != "" {
+		if err := s.addDeltaDependent(opt2.DeltaBase, commitID); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// deltaDependentsFilename is the name of the file (in a commit's dir
+// in a fsRepoStore) that lists the commits that were compacted with
+// the commit as their delta base (see CompactOpt.DeltaBase). The list
+// may be stale (if those commits were recompacted since), so it is
+// checked against their pack indexes before it is used.
+const deltaDependentsFilename = "delta-dependents.json"
+
+// addDeltaDependent records that commitID's data is stored as deltas
+// against base's.
+func (s *fsRepoStore) addDeltaDependent(base, commitID string) error {
+	file := path.Join(base, deltaDependentsFilename)
+	var deps []string
+	if err := readJSONFile(s.fs, file, &deps); err != nil && !isOSOrVFSNotExist(err) {
+		return err
+	}
+	for _, dep := range deps {
+		if dep == commitID {
+			return nil
+		}
+	}
+	deps = append(deps, commitID)
+	sort.Strings(deps)
+	return writeJSONFile(s.fs, file, deps)
+}
+
+// checkNotDeltaBase returns an error if the data of other commits is
+// stored as deltas against commitID's data, which would be corrupted
+// if commitID were reimported.
+func (s *fsRepoStore) checkNotDeltaBase(commitID string) error {
+	file := path.Join(commitID, deltaDependentsFilename)
+	var deps []string
+	if err := readJSONFile(s.fs, file, &deps); isOSOrVFSNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var current []string
+	for _, dep := range deps {
+		var idx packIndex
+		if err := readJSONFile(s.fs, path.Join(dep, packIndexFilename), &idx); err != nil && !isOSOrVFSNotExist(err) {
+			return err
+		}
+		if idx.Base == commitID {
+			current = append(current, dep)
+		}
+	}
+	if len(current) > 0 {
+		return fmt.Errorf("can't import %s: the data of commits %v is stored as deltas against its data (compact them without a delta base first)", commitID, current)
+	}
+	// The list is stale.
+	if err := s.fs.Remove(file); err != nil && !isOSOrVFSNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// checkDeltaBase returns an error if commitID's data can't be stored
+// as deltas against base's data: because base's data is (directly or
+// indirectly) stored as deltas against commitID's, or because the
+// chain of delta bases would be longer than maxDeltaChain.
+func (s *fsRepoStore) checkDeltaBase(commitID, base string) error {
+	for n := 1; base != ""; n++ {
+		if base == commitID {
+			return fmt.Errorf("can't store %s as deltas: its delta base is (directly or indirectly) stored as deltas against it", commitID)
+		}
+		if n > maxDeltaChain {
+			return fmt.Errorf("can't store %s as deltas: the chain of delta bases would be longer than %d commits", commitID, maxDeltaChain)
+		}
+		var idx packIndex
+		if err := readJSONFile(s.fs, path.Join(base, packIndexFilename), &idx); err != nil && !isOSOrVFSNotExist(err) {
+			return err
+		}
+		base = idx.Base
+	}
+	return nil
+}
+
+var (
+	_ Compactor = (*fsMultiRepoStore)(nil)
+	_ Compactor = (*fsRepoStore)(nil)
+)
+
+// packIndexFilename is the name of the file (in a commit's dir in a
+// fsRepoStore) that lists the files in the commit's pack file.
+const packIndexFilename = "pack-index.json"
+
+// packIndex is the serialized form of a commit's pack index.
+type packIndex struct {
+	// Generation is incremented each time the commit is compacted.
+	Generation int
+
+	// Pack is the name of the pack file (in the commit's dir).
+	Pack string
+
+	// Base is the commit whose data the files stored as deltas (see
+	// packEntry.Delta) are deltas against.
+	Base string `json:",omitempty"`
+
+	// Dedup is whether the files are stored in blobs (see
+	// CompactOpt.Dedup and packEntry.Blob).
+	Dedup bool `json:",omitempty"`
+
+	// Files maps the names of the packed files to their locations in
+	// the pack file.
+	Files map[string]packEntry
+
+	// dirs maps the dirs that contain packed files to their children
+	// (files and dirs), which map to whether the child is a dir.
+	dirs map[string]map[string]bool
+}
+
+// packEntry is the location (and metadata) of a file in a pack file.
+type packEntry struct {
+	Offset, Size int64
+	ModTime      time.Time
+
+	// Delta is whether the file is stored as a delta against the
+	// same file in the pack index's Base commit. If so, Size is the
+	// size of the delta, and FileSize is the size of the file.
+	Delta    bool  `json:",omitempty"`
+	FileSize int64 `json:",omitempty"`
+
+	// Blob, if set, is the hash of the blob (see CompactOpt.Dedup)
+	// that holds the file, and Offset is relative to the start of
+	// the blob (instead of the pack file).
+	Blob string `json:",omitempty"`
+}
+
+// fileSize returns the size of the file (not of its data in the
+// pack).
+func (e packEntry) fileSize() int64 {
+	if e.Delta {
+		return e.FileSize
+	}
+	return e.Size
+}
+
+// packFilename returns the name of the pack file (in a commit's dir)
+// of the given generation (see packIndex).
+func packFilename(generation int) string {
+	return fmt.Sprintf("pack-%d.pack", generation)
+}
+
+func isPackFilename(name string) bool {
+	return strings.HasPrefix(name, "pack-") && strings.HasSuffix(name, ".pack")
+}
+
+// isPackable returns whether the file name (relative to a commit's
+// dir) holds per-unit data, which is merged into the commit's pack
+// file. Other files in the commit's dir (such as the provenance and
+// the pack itself) are always loose.
+func isPackable(name string) bool {
+	return path.Dir(name) != "." || strings.HasSuffix(name, unitFileSuffix) || strings.HasSuffix(name, unitDepsFileSuffix)
+}
+
+// packPath cleans name and makes it relative to the root of a packFS.
+func packPath(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "/"))
+}
+
+// newPackFS returns a VFS that reads the files in the pack file of the
+// commit whose dir is fs (see Compactor) as though they were loose
+// files in fs. Files that are not packed are read from fs. Writes to
+// packed files remove them from the pack and write them as loose
+// files. Files stored as deltas are resolved against the files in the
+// VFS that openBase returns for the base commit, and files stored in
+// blobs are read from blobs.
+//
+// If fs implements rwvfs.FetcherOpener, so does the returned VFS.
+func newPackFS(fs rwvfs.FileSystem, openBase func(commitID string) rwvfs.FileSystem, blobs rwvfs.FileSystem) rwvfs.FileSystem {
+	pfs := &packFS{FileSystem: fs, openBase: openBase, blobs: blobs}
+	if fo, ok := fs.(rwvfs.FetcherOpener); ok {
+		pfs.fo = fo
+		return &packFetcherFS{pfs}
+	}
+	return pfs
+}
+
+type packFS struct {
+	rwvfs.FileSystem
+	fo rwvfs.FetcherOpener // non-nil if FileSystem is a FetcherOpener
+
+	openBase func(commitID string) rwvfs.FileSystem
+	blobs    rwvfs.FileSystem
+
+	mu  sync.Mutex
+	idx *packIndex // nil until read
+
+	base   rwvfs.FileSystem // VFS of the delta base commit (opened lazily)
+	baseID string           // commit ID of base
+
+	// lastDelta is the most recently resolved delta file (see
+	// openDelta).
+	lastDelta struct {
+		name string
+		data []byte
+	}
+}
+
+// index returns the pack index, reading it if it hasn't been read
+// yet. The returned index must not be modified.
+func (fs *packFS) index() (*packIndex, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.idx == nil {
+		if err := fs.readIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return fs.idx, nil
+}
+
+// readIndex reads the pack index. If there is none (because the
+// commit was never compacted), all files are loose. The caller must
+// hold fs.mu.
+func (fs *packFS) readIndex() error {
+	var idx packIndex
+	if err := readJSONFile(fs.FileSystem, packIndexFilename, &idx); err != nil && !isOSOrVFSNotExist(err) {
+		return fmt.Errorf("reading pack index: %s", err)
+	}
+	fs.setIndex(&idx)
+	return nil
+}
+
+// setIndex sets the pack index (and computes its dirs). The caller
+// must hold fs.mu.
+func (fs *packFS) setIndex(idx *packIndex) {
+	idx.dirs = map[string]map[string]bool{}
+	for name := range idx.Files {
+		child, isDir := path.Base(name), false
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			if idx.dirs[dir] == nil {
+				idx.dirs[dir] = map[string]bool{}
+			}
+			idx.dirs[dir][child] = isDir
+			if dir == "." {
+				break
+			}
+			child, isDir = path.Base(dir), true
+		}
+	}
+	fs.idx = idx
+}
+
+// reload rereads the pack index and returns whether the commit was
+// compacted since it was last read. Files that were loose may have
+// been packed (and removed) by another process's compaction since
+// then.
+func (fs *packFS) reload() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	old := fs.idx
+	if err := fs.readIndex(); err != nil {
+		vlog.Printf("packFS: %s.", err)
+		return false
+	}
+	return old == nil || fs.idx.Generation != old.Generation
+}
+
+func (fs *packFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	return fs.open(name, false)
+}
+
+// open opens name (from the pack file, if it's packed). If fetcher is
+// true, it opens name using OpenFetcher.
+func (fs *packFS) open(name string, fetcher bool) (vfs.ReadSeekCloser, error) {
+	name = packPath(name)
+	idx, err := fs.index()
+	if err != nil {
+		return nil, err
+	}
+
+	var f vfs.ReadSeekCloser
+	if e, packed := idx.Files[name]; packed && e.Delta {
+		f, err = fs.openDelta(name, idx, e)
+	} else if packed {
+		dataFS, dataName := fs.entryFile(idx, e)
+		f, err = openPackEntry(dataFS, dataName, e, fetcher)
+	} else if fetcher {
+		f, err = fs.fo.OpenFetcher(name)
+	} else {
+		f, err = fs.FileSystem.Open(name)
+	}
+	if err != nil && isOSOrVFSNotExist(err) && fs.reload() {
+		return fs.open(name, fetcher)
+	}
+	return f, err
+}
+
+// entryFile returns the VFS and name of the file that holds e: the
+// pack file or, if e is stored in a blob, the blob.
+func (fs *packFS) entryFile(idx *packIndex, e packEntry) (rwvfs.FileSystem, string) {
+	if e.Blob != "" {
+		return fs.blobs, blobFilename(e.Blob)
+	}
+	return fs.FileSystem, idx.Pack
+}
+
+// openPackEntry opens the section of the file name in dataFS (a pack
+// file or blob) that holds e.
+func openPackEntry(dataFS rwvfs.FileSystem, name string, e packEntry, fetcher bool) (vfs.ReadSeekCloser, error) {
+	fo, ok := dataFS.(rwvfs.FetcherOpener)
+	if !ok {
+		f, err := dataFS.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &packedFile{f: f, e: e}, nil
+	}
+
+	f, err := fo.OpenFetcher(name)
+	if err != nil {
+		return nil, err
+	}
+	pf := &packedFile{f: f, e: e}
+	if !fetcher && e.Size > 0 {
+		// Files returned by Open must be readable without calling
+		// Fetch, so fetch the file's section (but not the rest) of
+		// the pack file now.
+		if err := pf.Fetch(0, e.Size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return pf, nil
+}
+
+// openDelta opens the packed file name, which is stored as a delta
+// against the same file in the base commit. The file is resolved in
+// memory (and the most recently resolved file is cached, because
+// indexes are read by opening the same file many times).
+func (fs *packFS) openDelta(name string, idx *packIndex, e packEntry) (vfs.ReadSeekCloser, error) {
+	fs.mu.Lock()
+	if fs.idx == idx && fs.lastDelta.name == name {
+		data := fs.lastDelta.data
+		fs.mu.Unlock()
+		return deltaFile{bytes.NewReader(data)}, nil
+	}
+	fs.mu.Unlock()
+
+	dataFS, dataName := fs.entryFile(idx, e)
+	f, err := openPackEntry(dataFS, dataName, e, false)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	base, err := vfs.ReadFile(fs.baseFS(idx.Base), name)
+	if err != nil {
+		return nil, fmt.Errorf("reading delta base of %s: %s", name, err)
+	}
+	data, err := deltaApply(base, delta)
+	if err != nil {
+		return nil, fmt.Errorf("resolving delta of %s against commit %s: %s", name, idx.Base, err)
+	}
+
+	fs.mu.Lock()
+	if fs.idx == idx {
+		fs.lastDelta.name, fs.lastDelta.data = name, data
+	}
+	fs.mu.Unlock()
+	return deltaFile{bytes.NewReader(data)}, nil
+}
+
+// baseFS returns the VFS of the base commit.
+func (fs *packFS) baseFS(base string) rwvfs.FileSystem {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.base == nil || fs.baseID != base {
+		fs.base, fs.baseID = fs.openBase(base), base
+	}
+	return fs.base
+}
+
+func (fs *packFS) Stat(name string) (os.FileInfo, error) {
+	return fs.stat(name, fs.FileSystem.Stat)
+}
+
+func (fs *packFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.stat(name, fs.FileSystem.Lstat)
+}
+
+func (fs *packFS) stat(name string, statFunc func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	name = packPath(name)
+	idx, err := fs.index()
+	if err != nil {
+		return nil, err
+	}
+	if e, packed := idx.Files[name]; packed {
+		return packFileInfo{name: path.Base(name), size: e.fileSize(), modTime: e.ModTime}, nil
+	}
+	fi, err := statFunc(name)
+	if err != nil && isOSOrVFSNotExist(err) {
+		if _, isDir := idx.dirs[name]; isDir {
+			return packFileInfo{name: path.Base(name), dir: true}, nil
+		}
+		if fs.reload() {
+			return fs.stat(name, statFunc)
+		}
+	}
+	return fi, err
+}
+
+func (fs *packFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	dir = packPath(dir)
+	idx, err := fs.index()
+	if err != nil {
+		return nil, err
+	}
+	children, packedDir := idx.dirs[dir]
+
+	fis, err := fs.FileSystem.ReadDir(dir)
+	if err != nil && !(packedDir && isOSOrVFSNotExist(err)) {
+		if isOSOrVFSNotExist(err) && fs.reload() {
+			return fs.ReadDir(dir)
+		}
+		return nil, err
+	}
+	if dir == "." {
+		// Listing a pack file other than the current one means the
+		// commit was compacted since the index was read.
+		for _, fi := range fis {
+			if name := fi.Name(); isPackFilename(name) && name != idx.Pack && fs.reload() {
+				return fs.ReadDir(dir)
+			}
+		}
+	}
+	if !packedDir {
+		return fis, nil
+	}
+
+	merged := make([]os.FileInfo, 0, len(fis)+len(children))
+	for _, fi := range fis {
+		if _, packed := children[fi.Name()]; !packed {
+			merged = append(merged, fi)
+		}
+	}
+	for name, isDir := range children {
+		if isDir {
+			merged = append(merged, packFileInfo{name: name, dir: true})
+		} else {
+			e := idx.Files[path.Join(dir, name)]
+			merged = append(merged, packFileInfo{name: name, size: e.fileSize(), modTime: e.ModTime})
+		}
+	}
+	sort.Sort(fileInfosByName(merged))
+	return merged, nil
+}
+
+func (fs *packFS) Create(name string) (io.WriteCloser, error) {
+	name = packPath(name)
+	if err := fs.mkdirParent(name); err != nil {
+		return nil, err
+	}
+	if _, err := fs.unpack(name); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Create(name)
+}
+
+func (fs *packFS) Mkdir(name string) error {
+	name = packPath(name)
+	if err := fs.mkdirParent(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(name)
+}
+
+func (fs *packFS) Remove(name string) error {
+	name = packPath(name)
+	packed, err := fs.unpack(name)
+	if err != nil {
+		return err
+	}
+	err = fs.FileSystem.Remove(name)
+	if packed && isOSOrVFSNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// mkdirParent creates the parent dir of name in the underlying VFS if
+// it only exists in the pack (e.g., because compaction removed it
+// after packing all of its files).
+func (fs *packFS) mkdirParent(name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	idx, err := fs.index()
+	if err != nil {
+		return err
+	}
+	if _, packedDir := idx.dirs[dir]; packedDir {
+		return rwvfs.MkdirAll(fs.FileSystem, dir)
+	}
+	return nil
+}
+
+// unpack removes name from the pack index (so that it's read from a
+// loose file, if any), and returns whether it was packed.
+func (fs *packFS) unpack(name string) (packed bool, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.idx == nil {
+		if err := fs.readIndex(); err != nil {
+			return false, err
+		}
+	}
+	if _, packed := fs.idx.Files[name]; !packed {
+		return false, nil
+	}
+
+	idx := &packIndex{Generation: fs.idx.Generation, Pack: fs.idx.Pack, Files: make(map[string]packEntry, len(fs.idx.Files))}
+	for name2, e := range fs.idx.Files {
+		if name2 != name {
+			idx.Files[name2] = e
+		}
+	}
+	if err := writeJSONFile(fs.FileSystem, packIndexFilename, idx); err != nil {
+		return false, err
+	}
+	fs.setIndex(idx)
+	return true, nil
+}
+
+func (fs *packFS) String() string { return "pack(" + fs.FileSystem.String() + ")" }
+
+type packFetcherFS struct{ *packFS }
+
+func (fs *packFetcherFS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
+	return fs.open(name, true)
+}
+
+// packedFile is a file in a pack file. Its offsets are relative to the
+// start of the file's section of the pack file.
+type packedFile struct {
+	f      vfs.ReadSeekCloser // the pack file
+	e      packEntry
+	pos    int64 // current offset (relative to e.Offset)
+	seeked bool  // whether f's offset is e.Offset+pos
+}
+
+func (f *packedFile) Read(p []byte) (int, error) {
+	if f.pos >= f.e.Size {
+		return 0, io.EOF
+	}
+	if !f.seeked {
+		if _, err := f.f.Seek(f.e.Offset+f.pos, 0); err != nil {
+			return 0, err
+		}
+		f.seeked = true
+	}
+	if max := f.e.Size - f.pos; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := f.f.Read(p)
+	f.pos += int64(n)
+	if err == io.EOF && f.pos < f.e.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *packedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += f.pos
+	case 2:
+		offset += f.e.Size
+	default:
+		return 0, errors.New("packedFile.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("packedFile.Seek: negative position")
+	}
+	f.pos, f.seeked = offset, false
+	return offset, nil
+}
+
+// Fetch implements rwvfs.Fetcher.
+func (f *packedFile) Fetch(start, end int64) error {
+	fr, ok := f.f.(rwvfs.Fetcher)
+	if !ok {
+		return fmt.Errorf("packedFile.Fetch: pack file (type %T) is not a Fetcher", f.f)
+	}
+	if end > f.e.Size {
+		end = f.e.Size
+	}
+	return fr.Fetch(f.e.Offset+start, f.e.Offset+end)
+}
+
+func (f *packedFile) Close() error { return f.f.Close() }
+
+// deltaFile is a file that was resolved from a delta (in memory, so
+// fetching it is a no-op).
+type deltaFile struct{ *bytes.Reader }
+
+func (f deltaFile) Close() error                 { return nil }
+func (f deltaFile) Fetch(start, end int64) error { return nil }
+
+type packFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi packFileInfo) Name() string       { return fi.name }
+func (fi packFileInfo) Size() int64        { return fi.size }
+func (fi packFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi packFileInfo) IsDir() bool        { return fi.dir }
+func (fi packFileInfo) Sys() interface{}   { return nil }
+func (fi packFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+type fileInfosByName []os.FileInfo
+
+func (fis fileInfosByName) Len() int           { return len(fis) }
+func (fis fileInfosByName) Swap(i, j int)      { fis[i], fis[j] = fis[j], fis[i] }
+func (fis fileInfosByName) Less(i, j int) bool { return fis[i].Name() < fis[j].Name() }
+
+// compactFile is a file to merge into a pack file (or blob).
+type compactFile struct {
+	name    string
+	modTime time.Time
+}
+
+// compactPack merges the packable files in commitFS (the dir of a
+// commit in a fsRepoStore), both loose and in the current pack file,
+// into a new pack file (or, if opt.Dedup is set, mostly into blobs in
+// blobs). If opt.DeltaBase is set, files are stored as deltas against
+// the same files in the VFS that openBase returns for the base commit
+// (when the delta is smaller than the file). It writes the new pack
+// file and index before removing the old pack file and loose files,
+// so readers never see a commit with missing files.
+func compactPack(commitFS rwvfs.FileSystem, openBase func(commitID string) rwvfs.FileSystem, blobs rwvfs.FileSystem, opt CompactOpt) (*CompactStats, error) {
+	pfs := &packFS{FileSystem: commitFS, openBase: openBase, blobs: blobs}
+	if fo, ok := commitFS.(rwvfs.FetcherOpener); ok {
+		pfs.fo = fo
+	}
+	old, err := pfs.index()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []compactFile
+	var loose []string
+	w := fs.WalkFS(".", rwvfs.Walkable(pfs))
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return nil, err
+		}
+		name := packPath(w.Path())
+		if fi := w.Stat(); fi.Mode().IsRegular() && isPackable(name) {
+			files = append(files, compactFile{name: name, modTime: fi.ModTime()})
+			if _, packed := old.Files[name]; !packed {
+				loose = append(loose, name)
+			}
+		}
+	}
+
+	stats := &CompactStats{Files: len(files), Loose: len(loose)}
+	if len(loose) == 0 && opt.DeltaBase == old.Base && opt.Dedup == old.Dedup {
+		// Already compacted (or there's nothing to compact).
+		blobs := map[string]struct{}{}
+		for _, e := range old.Files {
+			if e.Blob != "" {
+				blobs[e.Blob] = struct{}{}
+				continue
+			}
+			stats.Size += e.Size
+			if e.Delta {
+				stats.Deltas++
+			}
+		}
+		stats.Blobs = len(blobs)
+		stats.Unchanged = true
+		return stats, nil
+	}
+
+	idx := &packIndex{Generation: old.Generation + 1, Base: opt.DeltaBase, Dedup: opt.Dedup, Files: make(map[string]packEntry, len(files))}
+	if opt.Dedup {
+		var packFiles, blobFiles []compactFile
+		for _, file := range files {
+			if isCommitSpecific(file.name) {
+				packFiles = append(packFiles, file)
+			} else {
+				blobFiles = append(blobFiles, file)
+			}
+		}
+		if len(packFiles) > 0 {
+			idx.Pack = packFilename(idx.Generation)
+			err = writePack(commitFS, pfs, packFiles, nil, idx, stats)
+		}
+		if err == nil {
+			err = writeBlobs(blobs, pfs, blobFiles, idx, stats)
+		}
+	} else {
+		var baseFS rwvfs.FileSystem
+		if opt.DeltaBase != "" {
+			baseFS = openBase(opt.DeltaBase)
+		}
+		idx.Pack = packFilename(idx.Generation)
+		err = writePack(commitFS, pfs, files, baseFS, idx, stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(commitFS, packIndexFilename, idx); err != nil {
+		return nil, err
+	}
+
+	// The new pack is now in use, so the files it replaced can be
+	// removed.
+	if old.Pack != "" {
+		if err := commitFS.Remove(old.Pack); err != nil && !isOSOrVFSNotExist(err) {
+			return nil, err
+		}
+	}
+	dirs := map[string]struct{}{}
+	for _, name := range loose {
+		if err := commitFS.Remove(name); err != nil && !isOSOrVFSNotExist(err) {
+			return nil, err
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = struct{}{}
+		}
+	}
+
+	// Remove the dirs that are now empty, children first. Removing a
+	// non-empty dir fails, which is OK.
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sortedDirs)))
+	for _, dir := range sortedDirs {
+		commitFS.Remove(dir)
+	}
+	return stats, nil
+}
+
+// writePack writes the files (read from fs) to the pack file idx.Pack
+// in commitFS, and adds their entries to idx.
+func writePack(commitFS, fs rwvfs.FileSystem, files []compactFile, baseFS rwvfs.FileSystem, idx *packIndex, stats *CompactStats) error {
+	vlog.Printf("compactPack: writing %d files to %s.", len(files), idx.Pack)
+	pw, err := commitFS.Create(idx.Pack)
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{Writer: pw}
+	for _, file := range files {
+		e, err := writePackFile(cw, fs, file.name, baseFS)
+		if err != nil {
+			pw.Close()
+			return err
+		}
+		e.ModTime = file.modTime
+		idx.Files[file.name] = e
+		if e.Delta {
+			stats.Deltas++
+		}
+	}
+	if err := pw.Close(); err != nil {
+		return err
+	}
+	stats.Size = cw.n
+	return nil
+}
+
+// writePackFile writes the file name in fs to the pack file being
+// written to w, as a delta against the same file in baseFS if baseFS
+// is non-nil and the delta is smaller than the file. It returns the
+// file's entry in the pack index.
+func writePackFile(w *countingWriter, fs rwvfs.FileSystem, name string, baseFS rwvfs.FileSystem) (packEntry, error) {
+	e := packEntry{Offset: w.n}
+	data, err := vfs.ReadFile(fs, name)
+	if err != nil {
+		return e, err
+	}
+	if baseFS != nil {
+		base, err := vfs.ReadFile(baseFS, name)
+		if err != nil && !isOSOrVFSNotExist(err) {
+			return e, err
+		}
+		if len(base) > 0 {
+			if delta := deltaEncode(base, data); len(delta) < len(data) {
+				e.Delta, e.FileSize = true, int64(len(data))
+				data = delta
+			}
+		}
+	}
+	if _, err := w.Write(data); err != nil {
+		return e, err
+	}
+	e.Size = int64(len(data))
+	return e, nil
+}
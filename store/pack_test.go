@@ -0,0 +1,266 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFSMultiRepoStore_Compact(t *testing.T) {
+	useIndexedStore = true
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, nil)
+	for _, name := range []string{"u1", "u2"} {
+		u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{"f"}}
+		data := graph.Output{
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: name + "/p"}, Name: "p", File: "f"}},
+			Refs: []*graph.Ref{{DefPath: name + "/p", File: "f", Start: 1, End: 2}},
+		}
+		if err := mrs.Import("r", "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mrs.(MultiRepoIndexer).Index("r", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// query returns a summary of the data of the commit, read from a
+	// newly opened store (so nothing is cached).
+	query := func() []string {
+		s := NewFSMultiRepoStore(fs, nil)
+		units, err := s.Units(ByRepoCommitIDs(Version{Repo: "r", CommitID: "c"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defs, err := s.Defs(ByRepoCommitIDs(Version{Repo: "r", CommitID: "c"}), ByDefQuery("p"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs, err := s.Refs(ByRepoCommitIDs(Version{Repo: "r", CommitID: "c"}), ByFiles("f"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var summary []string
+		for _, u := range units {
+			summary = append(summary, "unit "+u.Name)
+		}
+		for _, def := range defs {
+			summary = append(summary, "def "+def.Path)
+		}
+		for _, ref := range refs {
+			summary = append(summary, "ref "+ref.DefPath)
+		}
+		sort.Strings(summary)
+		return summary
+	}
+	want := query()
+	if len(want) != 6 {
+		t.Fatalf("got %v before compaction, want 2 units, 2 defs, and 2 refs", want)
+	}
+
+	stats, err := mrs.(Compactor).Compact("r", "c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Loose == 0 || stats.Files != stats.Loose || stats.Size == 0 {
+		t.Errorf("got stats %+v, want all files to have been loose", stats)
+	}
+	if got := query(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after compaction: got %v, want %v", got, want)
+	}
+
+	// Compacting again does nothing.
+	stats2, err := mrs.(Compactor).Compact("r", "c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats2.Unchanged || stats2.Loose != 0 || stats2.Files != stats.Files {
+		t.Errorf("recompaction: got stats %+v, want unchanged with %d files", stats2, stats.Files)
+	}
+
+	// Reimporting a unit replaces its packed files.
+	u := &unit.SourceUnit{Type: "t", Name: "u1", Files: []string{"f"}}
+	data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "u1/p2"}, Name: "p2", File: "f"}}}
+	if err := NewFSMultiRepoStore(fs, nil).Import("r", "c", u, data); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := NewFSMultiRepoStore(fs, nil).Defs(ByRepoCommitIDs(Version{Repo: "r", CommitID: "c"}), ByUnits(unit.ID2{Type: "t", Name: "u1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Path != "u1/p2" {
+		t.Errorf("after reimport: got defs %v, want u1/p2", defs)
+	}
+}
+
+func TestFSMultiRepoStore_CompactDelta(t *testing.T) {
+	useIndexedStore = true
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, nil)
+	for i, commitID := range []string{"c1", "c2"} {
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+		var data graph.Output
+		for j := 0; j < 50; j++ {
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: fmt.Sprintf("p%d", j)}, Name: "p", File: "f", DefStart: uint32(j * 10), DefEnd: uint32(j*10 + 5)})
+		}
+		data.Defs[10].Name = fmt.Sprintf("changed%d", i)
+		if err := mrs.Import("r", commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := mrs.(MultiRepoIndexer).Index("r", commitID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defs := func(commitID string) []*graph.Def {
+		defs, err := NewFSMultiRepoStore(fs, nil).Defs(ByRepoCommitIDs(Version{Repo: "r", CommitID: commitID}), ByUnits(unit.ID2{Type: "t", Name: "u"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Sort(graph.Defs(defs))
+		return defs
+	}
+	want := defs("c2")
+
+	stats, err := mrs.(Compactor).Compact("r", "c2", &CompactOpt{DeltaBase: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Deltas == 0 {
+		t.Errorf("got stats %+v, want some files stored as deltas", stats)
+	}
+	if got := defs("c2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after delta compaction: got defs %v, want %v", got, want)
+	}
+
+	// c1 can't be stored as deltas against c2, which is stored as
+	// deltas against c1.
+	if _, err := mrs.(Compactor).Compact("r", "c1", &CompactOpt{DeltaBase: "c2"}); err == nil {
+		t.Error("got no error for a delta cycle, want error")
+	}
+
+	// c1 can't be reimported while c2 is stored as deltas against it.
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+	if err := mrs.Import("r", "c1", u, graph.Output{}); err == nil {
+		t.Error("got no error reimporting a delta base, want error")
+	}
+
+	// Compacting without a delta base stores the files in full.
+	stats, err = mrs.(Compactor).Compact("r", "c2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unchanged || stats.Deltas != 0 {
+		t.Errorf("got stats %+v, want no deltas", stats)
+	}
+	if got := defs("c2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after full compaction: got defs %v, want %v", got, want)
+	}
+	if err := mrs.Import("r", "c1", u, graph.Output{}); err != nil {
+		t.Errorf("reimporting a former delta base: %s", err)
+	}
+}
+
+func TestFSMultiRepoStore_CompactUpgradesFormat(t *testing.T) {
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, nil)
+	if err := mrs.Import("r", "c", &unit.SourceUnit{Type: "t", Name: "u"}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a store created before compaction existed.
+	repoFS := mrs.(*fsMultiRepoStore).openRepoStore("r").(*fsRepoStore).fs
+	for _, fs := range []rwvfs.FileSystem{fs, repoFS} {
+		if err := writeFormatVersion(fs, packFormatVersion-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := mrs.(Compactor).Compact("r", "c", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range []rwvfs.FileSystem{fs, repoFS} {
+		if v, err := readFormatVersion(fs); err != nil {
+			t.Fatal(err)
+		} else if v < packFormatVersion {
+			t.Errorf("%s: after Compact: got format version %d, want at least %d", fs, v, packFormatVersion)
+		}
+	}
+}
+
+func TestFSMultiRepoStore_CompactDedup(t *testing.T) {
+	useIndexedStore = true
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, nil)
+	for _, commitID := range []string{"c1", "c2"} {
+		for _, name := range []string{"u1", "u2"} {
+			u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{"f"}}
+			data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: name + "/p"}, Name: "p", File: "f"}}}
+			if name == "u2" {
+				// u2 changes between the commits; u1 doesn't.
+				data.Defs[0].Name = "p" + commitID
+			}
+			if err := mrs.Import("r", commitID, u, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := mrs.(MultiRepoIndexer).Index("r", commitID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defs := func(commitID string) []*graph.Def {
+		defs, err := NewFSMultiRepoStore(fs, nil).Defs(ByRepoCommitIDs(Version{Repo: "r", CommitID: commitID}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Sort(graph.Defs(defs))
+		return defs
+	}
+	want1, want2 := defs("c1"), defs("c2")
+
+	stats1, err := mrs.(Compactor).Compact("r", "c1", &CompactOpt{Dedup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats1.Blobs == 0 || stats1.NewBlobs != stats1.Blobs {
+		t.Errorf("c1: got stats %+v, want all blobs to be new", stats1)
+	}
+	stats2, err := mrs.(Compactor).Compact("r", "c2", &CompactOpt{Dedup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats2.NewBlobs == 0 || stats2.NewBlobs >= stats2.Blobs {
+		t.Errorf("c2: got stats %+v, want only the changed unit's blobs to be new", stats2)
+	}
+	if got := defs("c1"); !reflect.DeepEqual(got, want1) {
+		t.Errorf("c1 after dedup: got defs %v, want %v", got, want1)
+	}
+	if got := defs("c2"); !reflect.DeepEqual(got, want2) {
+		t.Errorf("c2 after dedup: got defs %v, want %v", got, want2)
+	}
+
+	// The blobs dir isn't a commit.
+	versions, err := NewFSMultiRepoStore(fs, nil).Versions(ByRepos("r"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Errorf("got versions %v, want c1 and c2", versions)
+	}
+
+	ss, err := mrs.(StorageStatsStore).StorageStats("r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.Commits != 2 || ss.Compacted != 2 || ss.LooseFiles != 0 {
+		t.Errorf("got storage stats %+v, want 2 compacted commits", ss)
+	}
+	if ss.SharedBlobs == 0 || ss.BlobRefs <= ss.Blobs || ss.StoredSize >= ss.Size {
+		t.Errorf("got storage stats %+v, want some blobs shared by both commits", ss)
+	}
+}
@@ -0,0 +1,161 @@
+// Code generated by protoc-gen-gogo.
+// source: export.proto
+// DO NOT EDIT!
+
+/*
+	Package pb is a generated protocol buffer package.
+
+	It is generated from these files:
+		export.proto
+
+	It has these top-level messages:
+		ExportOp
+		ExportRecord
+*/
+package pb
+
+import proto "github.com/gogo/protobuf/proto"
+import math "math"
+
+// discarding unused import gogoproto "github.com/gogo/protobuf/gogoproto/gogo.pb"
+
+import graph "sourcegraph.com/sourcegraph/srclib/graph"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// ExportOp specifies the commit to export.
+type ExportOp struct {
+	// Repo is the URI of the repo to export. It must be given if the
+	// server's store is a MultiRepoStore.
+	Repo string `protobuf:"bytes,1,opt,name=repo" json:"Repo,omitempty"`
+	// CommitID is the commit to export.
+	CommitID string `protobuf:"bytes,2,opt,name=commit_id" json:"CommitID"`
+}
+
+func (m *ExportOp) Reset()         { *m = ExportOp{} }
+func (m *ExportOp) String() string { return proto.CompactTextString(m) }
+func (*ExportOp) ProtoMessage()    {}
+
+// ExportRecord is a single item of an export. Exactly one of its
+// fields is set.
+type ExportRecord struct {
+	// Unit is the JSON encoding of a unit.SourceUnit. (Source units
+	// have free-form fields with no protobuf representation, so they
+	// are always stored and transmitted as JSON.)
+	Unit []byte     `protobuf:"bytes,1,opt,name=unit" json:"Unit,omitempty"`
+	Def  *graph.Def `protobuf:"bytes,2,opt,name=def,customtype=sourcegraph.com/sourcegraph/srclib/graph.Def" json:"Def,omitempty"`
+	Doc  *graph.Doc `protobuf:"bytes,3,opt,name=doc,customtype=sourcegraph.com/sourcegraph/srclib/graph.Doc" json:"Doc,omitempty"`
+	Ref  *graph.Ref `protobuf:"bytes,4,opt,name=ref,customtype=sourcegraph.com/sourcegraph/srclib/graph.Ref" json:"Ref,omitempty"`
+}
+
+func (m *ExportRecord) Reset()         { *m = ExportRecord{} }
+func (m *ExportRecord) String() string { return proto.CompactTextString(m) }
+func (*ExportRecord) ProtoMessage()    {}
+
+func init() {
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for Exporter service
+
+type ExporterClient interface {
+	// Export streams every source unit, def, doc, and ref of a commit
+	// (in the order of store.Dump).
+	Export(ctx context.Context, in *ExportOp, opts ...grpc.CallOption) (Exporter_ExportClient, error)
+}
+
+type exporterClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewExporterClient(cc *grpc.ClientConn) ExporterClient {
+	return &exporterClient{cc}
+}
+
+func (c *exporterClient) Export(ctx context.Context, in *ExportOp, opts ...grpc.CallOption) (Exporter_ExportClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Exporter_serviceDesc.Streams[0], c.cc, "/pb.Exporter/Export", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &exporterExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Exporter_ExportClient interface {
+	Recv() (*ExportRecord, error)
+	grpc.ClientStream
+}
+
+type exporterExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *exporterExportClient) Recv() (*ExportRecord, error) {
+	m := new(ExportRecord)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for Exporter service
+
+type ExporterServer interface {
+	// Export streams every source unit, def, doc, and ref of a commit
+	// (in the order of store.Dump).
+	Export(*ExportOp, Exporter_ExportServer) error
+}
+
+func RegisterExporterServer(s *grpc.Server, srv ExporterServer) {
+	s.RegisterService(&_Exporter_serviceDesc, srv)
+}
+
+func _Exporter_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportOp)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExporterServer).Export(m, &exporterExportServer{stream})
+}
+
+type Exporter_ExportServer interface {
+	Send(*ExportRecord) error
+	grpc.ServerStream
+}
+
+type exporterExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *exporterExportServer) Send(m *ExportRecord) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Exporter_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.Exporter",
+	HandlerType: (*ExporterServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Export",
+			Handler:       _Exporter_Export_Handler,
+			ServerStreams: true,
+		},
+	},
+}
@@ -0,0 +1,82 @@
+package pb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// NewExporterServer returns an ExporterServer that exports the data
+// of s (which must be a store.RepoStore or store.MultiRepoStore).
+func NewExporterServer(s interface{}) ExporterServer {
+	return &exporterServer{s: s}
+}
+
+type exporterServer struct {
+	s interface{}
+}
+
+func (s *exporterServer) Export(op *ExportOp, stream Exporter_ExportServer) error {
+	if op.CommitID == "" {
+		return grpc.Errorf(codes.InvalidArgument, "CommitID must be given")
+	}
+	if _, isMulti := s.s.(store.MultiRepoStore); isMulti && op.Repo == "" {
+		return grpc.Errorf(codes.InvalidArgument, "Repo must be given for a multi-repo store")
+	}
+	// Stop dumping (and querying the store) if the client goes away.
+	ctx := stream.Context()
+	return store.DumpContext(ctx, s.s, op.Repo, op.CommitID, func(rec *store.DumpRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, err := NewExportRecord(rec)
+		if err != nil {
+			return err
+		}
+		return stream.Send(r)
+	})
+}
+
+// NewExportRecord converts a record of a store.Dump to an
+// ExportRecord.
+func NewExportRecord(rec *store.DumpRecord) (*ExportRecord, error) {
+	switch rec.Type {
+	case store.DumpUnit:
+		b, err := json.Marshal(rec.Unit)
+		if err != nil {
+			return nil, err
+		}
+		return &ExportRecord{Unit: b}, nil
+	case store.DumpDef:
+		return &ExportRecord{Def: rec.Def}, nil
+	case store.DumpDoc:
+		return &ExportRecord{Doc: rec.Doc}, nil
+	case store.DumpRef:
+		return &ExportRecord{Ref: rec.Ref}, nil
+	}
+	return nil, fmt.Errorf("unrecognized dump record type %q", rec.Type)
+}
+
+// DumpRecord converts r back to a store.DumpRecord.
+func (r *ExportRecord) DumpRecord() (*store.DumpRecord, error) {
+	switch {
+	case r.Unit != nil:
+		var u unit.SourceUnit
+		if err := json.Unmarshal(r.Unit, &u); err != nil {
+			return nil, err
+		}
+		return &store.DumpRecord{Type: store.DumpUnit, Unit: &u}, nil
+	case r.Def != nil:
+		return &store.DumpRecord{Type: store.DumpDef, Def: r.Def}, nil
+	case r.Doc != nil:
+		return &store.DumpRecord{Type: store.DumpDoc, Doc: r.Doc}, nil
+	case r.Ref != nil:
+		return &store.DumpRecord{Type: store.DumpRef, Ref: r.Ref}, nil
+	}
+	return nil, fmt.Errorf("empty export record")
+}
@@ -0,0 +1,88 @@
+package pb
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestExporter(t *testing.T) {
+	rs := store.NewFSRepoStore(rwvfs.Walkable(rwvfs.Sub(rwvfs.Map(map[string]string{}), "/testdata")))
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f", Docs: []graph.DefDoc{{Format: "text/plain", Data: "d"}}}},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+	}
+	if err := rs.Import("c", u, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.(store.RepoIndexer).Index("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	srv := grpc.NewServer()
+	RegisterExporterServer(srv, NewExporterServer(rs))
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	stream, err := NewExporterClient(cc).Export(context.Background(), &ExportOp{CommitID: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := r.DumpRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch rec.Type {
+		case store.DumpUnit:
+			got = append(got, "unit "+rec.Unit.Name)
+		case store.DumpDef:
+			got = append(got, "def "+rec.Def.Path+" "+rec.Def.Name)
+		case store.DumpDoc:
+			got = append(got, "doc "+rec.Doc.Path+" "+rec.Doc.Data)
+		case store.DumpRef:
+			got = append(got, "ref "+rec.Ref.DefPath)
+		}
+	}
+	want := []string{"unit u", "def p n", "doc p d", "ref p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %v, want %v", got, want)
+	}
+
+	stream, err = NewExporterClient(cc).Export(context.Background(), &ExportOp{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v with no CommitID, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,5 @@
+package pb
+
+//go:generate protoc --proto_path=/usr/include:$HOME/src:$HOME/src/github.com/gogo/protobuf/protobuf/google/protobuf:../../graph:. --gogo_out=plugins=grpc:. export.proto
+//go:generate sed -i "s/^import graph .*$//" export.pb.go
+//go:generate sed -i "s/sourcegraph_com_sourcegraph_srclib_graph/graph/g" export.pb.go
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
-	"time"
 )
 
 type chdHasher struct {
@@ -42,6 +41,10 @@ type CHDBuilder struct {
 	keys         [][]byte
 	values       [][]byte
 	valueVarints []uint64
+
+	// attempts is the number of times Build has failed. It seeds the
+	// hasher, so that calling Build again tries other hash functions.
+	attempts int64
 }
 
 // Create a new CHD hash table builder.
@@ -137,7 +140,7 @@ func (b *CHDBuilder) Build() (*CHD, error) {
 	if b.valueVarints != nil {
 		valueVarints = make([]uint64, n)
 	}
-	hasher := newCHDHasher(n, m)
+	hasher := newCHDHasher(n, m, b.attempts)
 	buckets := make(bucketVector, m)
 	indices := make([]uint16, m)
 	// An extra check to make sure we don't use an invalid index
@@ -201,6 +204,7 @@ nextBucket:
 		}
 
 		// Failed to find a hash function with no collisions.
+		b.attempts++
 		return nil, fmt.Errorf(
 			"failed to find a collision-free hash function after ~10000000 attempts, for bucket %d/%d with %d entries: %s",
 			i, len(buckets), len(bucket.keys), &bucket)
@@ -221,8 +225,12 @@ nextBucket:
 	}, nil
 }
 
-func newCHDHasher(size uint64, buckets uint64) *chdHasher {
-	rs := rand.NewSource(time.Now().UnixNano())
+// newCHDHasher returns a hasher whose random values are the same
+// sequence for the same seed, so that building a table from the same
+// keys produces the same table. (Indexes built from identical data are
+// then identical, which lets them be deduplicated.)
+func newCHDHasher(size uint64, buckets uint64, seed int64) *chdHasher {
+	rs := rand.NewSource(seed + 1)
 	c := &chdHasher{size: size, buckets: buckets, rand: rand.New(rs)}
 	c.Add(c.random())
 	return c
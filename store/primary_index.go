@@ -57,13 +57,13 @@ func (x *defPathIndex) Defs(f ...DefFilter) (byteOffsets, error) {
 // Build implements defIndexBuilder.
 func (x *defPathIndex) Build(defs []*graph.Def, ofs byteOffsets) error {
 	tries := 0
-retry:
 	vlog.Printf("defPathIndex: building index... (%d defs)", len(defs))
 	b := phtable.Uvarint64Builder(len(defs))
 	for i, def := range defs {
 		b.AddUvarint64([]byte(def.Path), uint64(ofs[i]))
 	}
 	vlog.Printf("defPathIndex: done adding index (%d defs).", len(defs))
+retry:
 	h, err := b.Build()
 	if err != nil {
 		if tries < 10 && strings.Contains(err.Error(), "failed to find a collision-free hash function") {
@@ -73,6 +73,7 @@ retry:
 		return err
 	}
 	h.ValuesAreVarints = true
+	h.StoreKeys = true // so getByPath reports whether a def exists (see brokenRefsIndex)
 	x.phtable = h
 	x.ready = true
 	vlog.Printf("defPathIndex: done building index (%d defs).", len(defs))
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// A Provenance records how a commit's data in the store was produced
+// and imported, so that analysis results can be reproduced and
+// audited.
+type Provenance struct {
+	Repo     string `json:",omitempty"`
+	CommitID string `json:",omitempty"`
+
+	// ImporterVersion is the version of the program that imported the
+	// data, and ImportHost is the hostname of the machine that ran
+	// it.
+	ImporterVersion string
+	ImportHost      string `json:",omitempty"`
+
+	// Imported is when the import started, and ImportDuration is how
+	// long it took (including building indexes).
+	Imported       time.Time
+	ImportDuration time.Duration
+
+	// Source describes where the build data was read from (such as a
+	// local build data dir or a remote build data URL).
+	Source string
+
+	// Units is the number of source units that were imported.
+	Units int
+
+	// BaseCommitID is set if only the source units that changed since
+	// the BaseCommitID commit were imported, so that the commit's
+	// data is merged over BaseCommitID's data when queried (see
+	// OverlayStore).
+	BaseCommitID string `json:",omitempty"`
+
+	// BuilderVersion, BuildHost, Toolchains, Built, and BuildDuration
+	// describe the build that produced the data, if the build data
+	// recorded it. Toolchains maps the paths of the toolchains that
+	// produced the data to their versions ("" if unknown).
+	BuilderVersion string            `json:",omitempty"`
+	BuildHost      string            `json:",omitempty"`
+	Toolchains     map[string]string `json:",omitempty"`
+	Built          *time.Time        `json:",omitempty"`
+	BuildDuration  time.Duration     `json:",omitempty"`
+
+	// UnitBuildStats describes the resource usage of the toolchain
+	// rules that ran on each source unit during the build, if the
+	// build data recorded it.
+	UnitBuildStats []*UnitBuildStats `json:",omitempty"`
+}
+
+// UnitBuildStats describes the resource usage of a toolchain operation
+// (such as "graph" or "depresolve") on a source unit during a build.
+// MaxRSS is in bytes.
+type UnitBuildStats struct {
+	UnitType string
+	Unit     string
+	Op       string
+	Wall     time.Duration
+	UserCPU  time.Duration `json:",omitempty"`
+	SysCPU   time.Duration `json:",omitempty"`
+	MaxRSS   int64         `json:",omitempty"`
+}
+
+// A ProvenanceStore reports the provenance of commits' data. It is
+// implemented by the FS-backed and in-memory stores at the
+// MultiRepoStore and RepoStore levels.
+type ProvenanceStore interface {
+	// Provenance returns the provenance of the data of repo at
+	// commitID. If none was recorded (e.g., because the commit was
+	// imported before provenance was recorded), it returns an error
+	// for which IsNotExist returns true. The repo is only used by
+	// MultiRepoStores.
+	Provenance(repo, commitID string) (*Provenance, error)
+}
+
+// A ProvenanceImporter records the provenance of a commit's data,
+// replacing any previously recorded provenance. The repo is only used
+// by MultiRepoStores.
+type ProvenanceImporter interface {
+	ImportProvenance(repo, commitID string, p *Provenance) error
+}
+
+func (s *fsMultiRepoStore) Provenance(repo, commitID string) (*Provenance, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("Provenance: repo: empty")
+	}
+	repo = s.canonicalRepo(repo)
+	p, err := s.openRepoStore(repo).(ProvenanceStore).Provenance(repo, commitID)
+	if err != nil {
+		return nil, err
+	}
+	p.Repo = repo
+	return p, nil
+}
+
+func (s *fsMultiRepoStore) ImportProvenance(repo, commitID string, p *Provenance) error {
+	if repo == "" {
+		return fmt.Errorf("ImportProvenance: repo: empty")
+	}
+	return s.openRepoStore(s.canonicalRepo(repo)).(ProvenanceImporter).ImportProvenance(repo, commitID, p)
+}
+
+// provenanceFilename is the name of the file (in a commit's dir in a
+// fsRepoStore) that holds the provenance of the commit's data.
+const provenanceFilename = "provenance.json"
+
+func (s *fsRepoStore) Provenance(repo, commitID string) (*Provenance, error) {
+	snapshots, err := s.Snapshots(repo)
+	if err != nil {
+		return nil, err
+	}
+	commitID = snapshotCommitID(snapshots, commitID)
+
+	var p Provenance
+	if err := readJSONFile(s.fs, path.Join(commitID, provenanceFilename), &p); err != nil {
+		if isOSOrVFSNotExist(err) {
+			return nil, errNoProvenance(commitID)
+		}
+		return nil, err
+	}
+	p.CommitID = commitID
+	return &p, nil
+}
+
+func (s *fsRepoStore) ImportProvenance(repo, commitID string, p *Provenance) error {
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	return writeJSONFile(s.fs, path.Join(commitID, provenanceFilename), cleanProvenanceForImport(p))
+}
+
+func (s *memoryMultiRepoStore) Provenance(repo, commitID string) (*Provenance, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("Provenance: repo: empty")
+	}
+	rs, present := s.repos[repo]
+	if !present {
+		return nil, errNoProvenance(commitID)
+	}
+	p, err := rs.Provenance(repo, commitID)
+	if err != nil {
+		return nil, err
+	}
+	p.Repo = repo
+	return p, nil
+}
+
+func (s *memoryMultiRepoStore) ImportProvenance(repo, commitID string, p *Provenance) error {
+	rs, present := s.repos[repo]
+	if !present {
+		return fmt.Errorf("ImportProvenance: repo %q not found", repo)
+	}
+	return rs.ImportProvenance(repo, commitID, p)
+}
+
+func (s *memoryRepoStore) Provenance(repo, commitID string) (*Provenance, error) {
+	commitID = snapshotCommitID(s.snapshots, commitID)
+	p, present := s.provenance[commitID]
+	if !present {
+		return nil, errNoProvenance(commitID)
+	}
+	p2 := *p
+	p2.CommitID = commitID
+	return &p2, nil
+}
+
+func (s *memoryRepoStore) ImportProvenance(repo, commitID string, p *Provenance) error {
+	if s.provenance == nil {
+		s.provenance = map[string]*Provenance{}
+	}
+	s.provenance[commitID] = cleanProvenanceForImport(p)
+	return nil
+}
+
+// cleanProvenanceForImport returns a copy of p with the fields that
+// are implied by its location in the store cleared.
+func cleanProvenanceForImport(p *Provenance) *Provenance {
+	p2 := *p
+	p2.Repo, p2.CommitID = "", ""
+	return &p2
+}
+
+// errNoProvenance returns an error (for which IsNotExist returns true)
+// reporting that no provenance was recorded for commitID.
+func errNoProvenance(commitID string) error {
+	return &os.PathError{Op: "provenance", Path: commitID, Err: os.ErrNotExist}
+}
+
+var (
+	_ ProvenanceStore    = (*fsMultiRepoStore)(nil)
+	_ ProvenanceStore    = (*fsRepoStore)(nil)
+	_ ProvenanceStore    = (*memoryMultiRepoStore)(nil)
+	_ ProvenanceStore    = (*memoryRepoStore)(nil)
+	_ ProvenanceImporter = (*fsMultiRepoStore)(nil)
+	_ ProvenanceImporter = (*fsRepoStore)(nil)
+	_ ProvenanceImporter = (*memoryMultiRepoStore)(nil)
+	_ ProvenanceImporter = (*memoryRepoStore)(nil)
+)
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_Provenance(t *testing.T) {
+	testMultiRepoStore_Provenance(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Provenance(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_Provenance(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_Provenance(t *testing.T, mrs MultiRepoStoreImporter) {
+	for _, commitID := range []string{"c1", "c2"} {
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, File: "f"}}}
+		if err := mrs.Import("r", commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ps, ok := mrs.(ProvenanceStore)
+	if !ok {
+		t.Fatalf("%s: does not implement ProvenanceStore", mrs)
+	}
+	if _, err := ps.Provenance("r", "c1"); !IsNotExist(err) {
+		t.Errorf("%s: Provenance before import: got err %v, want not-exist error", mrs, err)
+	}
+
+	built := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &Provenance{
+		ImporterVersion: "0.1",
+		ImportHost:      "h",
+		Imported:        built.Add(time.Minute),
+		ImportDuration:  time.Second,
+		Source:          "s",
+		Units:           1,
+		BuilderVersion:  "0.1",
+		Toolchains:      map[string]string{"sourcegraph.com/sourcegraph/srclib-go": "abc"},
+		Built:           &built,
+		BuildDuration:   time.Minute,
+		UnitBuildStats: []*UnitBuildStats{
+			{UnitType: "t", Unit: "u", Op: "graph", Wall: time.Second, UserCPU: time.Second, MaxRSS: 1 << 20},
+		},
+	}
+	if err := mrs.(ProvenanceImporter).ImportProvenance("r", "c1", p); err != nil {
+		t.Fatalf("%s: ImportProvenance: %s", mrs, err)
+	}
+
+	got, err := ps.Provenance("r", "c1")
+	if err != nil {
+		t.Fatalf("%s: Provenance: %s", mrs, err)
+	}
+	want := *p
+	want.Repo, want.CommitID = "r", "c1"
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("%s: Provenance: got %+v, want %+v", mrs, got, &want)
+	}
+
+	// Provenance is per-commit.
+	if _, err := ps.Provenance("r", "c2"); !IsNotExist(err) {
+		t.Errorf("%s: Provenance of other commit: got err %v, want not-exist error", mrs, err)
+	}
+
+	// Snapshot names resolve to their commits.
+	if err := mrs.(SnapshotCreator).CreateSnapshot("r", "rel", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ps.Provenance("r", "rel")
+	if err != nil {
+		t.Fatalf("%s: Provenance(snapshot): %s", mrs, err)
+	}
+	if got.CommitID != "c1" {
+		t.Errorf("%s: Provenance(snapshot): got commit %q, want %q", mrs, got.CommitID, "c1")
+	}
+}
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/kr/fs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A QueryCache caches the results of defs and refs queries in a local
+// directory, so that repeated identical queries (e.g., from editor
+// plugins) do not need to read and decode the store's data files.
+//
+// Results are keyed by the store, the normalized set of filters, and
+// a fingerprint of the queried commits' data and index files (their
+// names, sizes, and modification times). Importing or indexing a
+// commit changes its fingerprint, so stale results are never
+// returned; they are removed when they have not been used for
+// QueryCacheMaxAge.
+//
+// Only queries against FS-backed stores (see NewFSRepoStore and
+// NewFSMultiRepoStore) that are scoped to specific commits (and, for
+// multi-repo stores, to specific repos) are cached. Queries with
+// filters that have no distinctive string representation (such as
+// DefFilterFunc) are never cached. Other queries are passed through
+// to the store.
+type QueryCache struct {
+	dir     string
+	storeID string
+}
+
+// QueryCacheMaxAge is how long cached query results are kept after
+// they were last used.
+var QueryCacheMaxAge = 7 * 24 * time.Hour
+
+// NewQueryCache returns a query cache that stores results in dir.
+// The storeID distinguishes the results of queries against different
+// stores that share the cache dir (it is typically the store's root).
+func NewQueryCache(dir, storeID string) (*QueryCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &QueryCache{dir: dir, storeID: storeID}
+	c.removeExpired()
+	return c, nil
+}
+
+// Defs returns the result of s.Defs(f...), reading it from the cache
+// if possible.
+func (c *QueryCache) Defs(s UnitStore, f ...DefFilter) ([]*graph.Def, error) {
+	key, ok := c.key(s, "Defs", storeFilters(f))
+	if !ok {
+		return s.Defs(f...)
+	}
+	var defs []*graph.Def
+	if c.get(key, &defs) {
+		queryCacheCounter.hit()
+		return defs, nil
+	}
+	queryCacheCounter.miss()
+	defs, err := s.Defs(f...)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, defs)
+	return defs, nil
+}
+
+// Refs returns the result of s.Refs(f...), reading it from the cache
+// if possible.
+func (c *QueryCache) Refs(s UnitStore, f ...RefFilter) ([]*graph.Ref, error) {
+	key, ok := c.key(s, "Refs", storeFilters(f))
+	if !ok {
+		return s.Refs(f...)
+	}
+	var refs []*graph.Ref
+	if c.get(key, &refs) {
+		queryCacheCounter.hit()
+		return refs, nil
+	}
+	queryCacheCounter.miss()
+	refs, err := s.Refs(f...)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, refs)
+	return refs, nil
+}
+
+// key returns the cache key for a query of the given kind ("Defs" or
+// "Refs") against s. If the query is not cacheable, ok is false.
+func (c *QueryCache) key(s interface{}, kind string, filters []interface{}) (key string, ok bool) {
+	filterStrs := make([]string, len(filters))
+	for i, f := range filters {
+		// Func filters' strings do not identify what they select.
+		str, ok := f.(fmt.Stringer)
+		if _, isAbsFunc := f.(*absRefFilterFunc); !ok || isAbsFunc || reflect.ValueOf(f).Kind() == reflect.Func {
+			return "", false
+		}
+		// Samples are random, so they are not cached.
+		if _, isSample := f.(*sampler); isSample {
+			return "", false
+		}
+		filterStrs[i] = str.String()
+	}
+	// Filters are ANDed together, so their order does not matter.
+	sort.Strings(filterStrs)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q\n", c.storeID, kind, filterStrs)
+	if err := writeQueryFingerprint(h, s, filters); err != nil {
+		if err != errNotCacheable {
+			vlog.Printf("QueryCache: not caching %s query (%v): %s.", kind, filterStrs, err)
+		}
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+var errNotCacheable = errors.New("query is not cacheable")
+
+// writeQueryFingerprint writes the fingerprints of all of the commits
+// that a query (with the given filters) against s could read to h.
+func writeQueryFingerprint(h hash.Hash, s interface{}, filters []interface{}) error {
+	commitIDs, err := scopeTrees(filters)
+	if err != nil {
+		return err
+	}
+	sort.Strings(commitIDs)
+
+	switch s := s.(type) {
+	case *slowQueryLogUnitStore:
+		return writeQueryFingerprint(h, s.s, filters)
+
+	case *fsRepoStore:
+		if commitIDs == nil {
+			return errNotCacheable
+		}
+		for _, commitID := range commitIDs {
+			if err := s.writeCommitFingerprint(h, commitID); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *fsMultiRepoStore:
+		versions, err := scopeVersions(filters, commitIDs)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if !s.inScope(s.canonicalRepo(v.Repo)) {
+				// Don't serve results cached by a store with a
+				// different scope.
+				return errNotCacheable
+			}
+			rs, ok := s.openRepoStore(v.Repo).(*fsRepoStore)
+			if !ok {
+				return errNotCacheable
+			}
+			fmt.Fprintf(h, "repo %q\n", v.Repo)
+			if err := rs.writeCommitFingerprint(h, v.CommitID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errNotCacheable
+}
+
+// scopeVersions returns the (sorted) repo commits that a query with
+// the given filters against a multi-repo store could read. The
+// commitIDs are the result of scopeTrees(filters).
+func scopeVersions(filters []interface{}, commitIDs []string) ([]Version, error) {
+	for _, f := range filters {
+		if f, ok := f.(ByRepoCommitIDsFilter); ok {
+			// All filters must match, so the query can only read
+			// the commits that this filter selects.
+			versions := append([]Version(nil), f.ByRepoCommitIDs()...)
+			sort.Sort(versionsByRepoCommitID(versions))
+			return versions, nil
+		}
+	}
+
+	repos, err := scopeRepos(filters)
+	if err != nil {
+		return nil, err
+	}
+	if repos == nil || commitIDs == nil {
+		return nil, errNotCacheable
+	}
+	sort.Strings(repos)
+	versions := make([]Version, 0, len(repos)*len(commitIDs))
+	for _, repo := range repos {
+		for _, commitID := range commitIDs {
+			versions = append(versions, Version{Repo: repo, CommitID: commitID})
+		}
+	}
+	return versions, nil
+}
+
+type versionsByRepoCommitID []Version
+
+func (vs versionsByRepoCommitID) Len() int      { return len(vs) }
+func (vs versionsByRepoCommitID) Swap(i, j int) { vs[i], vs[j] = vs[j], vs[i] }
+func (vs versionsByRepoCommitID) Less(i, j int) bool {
+	if vs[i].Repo != vs[j].Repo {
+		return vs[i].Repo < vs[j].Repo
+	}
+	return vs[i].CommitID < vs[j].CommitID
+}
+
+// writeCommitFingerprint writes the names, sizes, and modification
+// times of the commit's data and index files, and of the repo-level
+// files (such as repo-wide indexes and snapshots), to h. A commit that
+// does not exist has an empty fingerprint. If commitID is a snapshot
+// name, the data of the commit that it refers to is used.
+//
+// The snapshots file's size and modification time are part of the
+// fingerprint, so it is only read if commitID is not the ID of an
+// imported commit (which is never a snapshot name; see
+// checkNewSnapshot). Otherwise every cached query would read it.
+func (s *fsRepoStore) writeCommitFingerprint(h hash.Hash, commitID string) error {
+	writeFile := func(name string, fi os.FileInfo) {
+		fmt.Fprintf(h, "%q %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+	}
+
+	entries, err := s.fs.ReadDir(".")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	hasSnapshots := false
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			writeFile(e.Name(), e)
+			if e.Name() == snapshotsFilename {
+				hasSnapshots = true
+			}
+		}
+	}
+
+	_, err = s.fs.Stat(commitID)
+	if os.IsNotExist(err) && hasSnapshots {
+		snapshots, err2 := s.Snapshots("")
+		if err2 != nil {
+			return err2
+		}
+		if c := snapshotCommitID(snapshots, commitID); c != commitID {
+			commitID = c
+			_, err = s.fs.Stat(commitID)
+		}
+	}
+	fmt.Fprintf(h, "commit %q\n", commitID)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	w := fs.WalkFS(commitID, rwvfs.Walkable(s.fs))
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		if fi := w.Stat(); fi.Mode().IsRegular() {
+			writeFile(w.Path(), fi)
+		}
+	}
+	return nil
+}
+
+func (c *QueryCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get reads the cached result for key into v. It returns false if
+// there is no (readable) cached result.
+func (c *QueryCache) get(key string, v interface{}) bool {
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			vlog.Printf("QueryCache: reading cached result: %s.", err)
+		}
+		return false
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		vlog.Printf("QueryCache: decoding cached result %s: %s.", f.Name(), err)
+		return false
+	}
+
+	// Record the use, so that the result does not expire.
+	now := time.Now()
+	os.Chtimes(f.Name(), now, now)
+	return true
+}
+
+// put caches v as the result for key. Errors are logged and otherwise
+// ignored, because the cache is only an optimization.
+func (c *QueryCache) put(key string, v interface{}) {
+	// Write to a temp file and rename it so that concurrent readers
+	// never see a partially written result.
+	f, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		vlog.Printf("QueryCache: writing cached result: %s.", err)
+		return
+	}
+	err = json.NewEncoder(f).Encode(v)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		vlog.Printf("QueryCache: writing cached result: %s.", err)
+	}
+}
+
+// removeExpired removes cached results (and leftover temp files) that
+// have not been used for QueryCacheMaxAge.
+func (c *QueryCache) removeExpired() {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		vlog.Printf("QueryCache: listing cached results: %s.", err)
+		return
+	}
+	for _, e := range entries {
+		if e.Mode().IsRegular() && time.Since(e.ModTime()) > QueryCacheMaxAge {
+			if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+				vlog.Printf("QueryCache: removing expired result: %s.", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestQueryCache(t *testing.T) {
+	useIndexedStore = false
+	dir, err := ioutil.TempDir("", "srclib-query-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := &countingOpenFS{FileSystem: rwvfs.Sub(rwvfs.Map(map[string]string{}), "/testdata"), opens: map[string]int{}}
+	rs := NewFSRepoStore(fs)
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	importDefs := func(paths ...string) {
+		var data graph.Output
+		for _, p := range paths {
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: p}, Name: p, File: "f"})
+		}
+		if err := rs.Import("c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	importDefs("p")
+
+	c, err := NewQueryCache(dir, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totalOpens := func() int {
+		var n int
+		for _, v := range fs.opens {
+			n += v
+		}
+		return n
+	}
+	query := func(wantOpens bool, wantPaths ...string) {
+		before := totalOpens()
+		defs, err := c.Defs(rs, ByCommitIDs("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, def := range defs {
+			paths = append(paths, def.Path)
+		}
+		if !reflect.DeepEqual(paths, wantPaths) {
+			t.Errorf("got def paths %v, want %v", paths, wantPaths)
+		}
+		if opened := totalOpens() > before; opened != wantOpens {
+			t.Errorf("got store files opened %v, want %v", opened, wantOpens)
+		}
+	}
+
+	query(true, "p")  // not yet cached
+	query(false, "p") // cached
+
+	// Re-importing the commit must invalidate the cached result.
+	importDefs("p", "q")
+	query(true, "p", "q")
+	query(false, "p", "q")
+
+	// Queries that are not scoped to commits are not cached.
+	if _, ok := c.key(rs, "Defs", storeFilters([]DefFilter{ByDefPath("p")})); ok {
+		t.Error("got unscoped query cacheable, want not cacheable")
+	}
+
+	// Nor are queries with func filters.
+	f := DefFilterFunc(func(*graph.Def) bool { return true })
+	if _, ok := c.key(rs, "Defs", storeFilters([]DefFilter{ByCommitIDs("c"), f})); ok {
+		t.Error("got query with func filter cacheable, want not cacheable")
+	}
+}
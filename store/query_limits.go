@@ -0,0 +1,108 @@
+package store
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// QueryLimits are hard limits on the work that a single defs or refs
+// query may do. They keep an overly broad query (e.g., for all refs
+// in a MultiRepoStore with hundreds of repos) from running for hours
+// or exhausting memory: once a limit is exceeded, the query fails with
+// an error for which IsQueryLimitExceeded returns true.
+type QueryLimits struct {
+	// MaxResults is the max number of defs or refs that the query
+	// may select (0 means no limit).
+	MaxResults int
+
+	// MaxBytesScanned is the max number of bytes of def and ref data
+	// files that the query may read, including data that is read
+	// and then rejected by filters (0 means no limit).
+	MaxBytesScanned int64
+}
+
+// WithQueryLimits returns a filter that enforces limits on the query
+// it is passed to. It selects all defs and refs, so it doesn't
+// otherwise affect the query's results. The filter keeps track of the
+// query's usage, so a new one must be created for each query.
+func WithQueryLimits(limits QueryLimits) interface {
+	DefFilter
+	RefFilter
+} {
+	return &queryLimiter{limits: limits}
+}
+
+type queryLimiter struct {
+	limits QueryLimits
+
+	// results and bytesScanned are the usage of the query so far
+	// (accessed atomically).
+	results, bytesScanned int64
+}
+
+func (l *queryLimiter) String() string {
+	return fmt.Sprintf("QueryLimits(max %d results, %d bytes scanned)", l.limits.MaxResults, l.limits.MaxBytesScanned)
+}
+
+func (l *queryLimiter) SelectDef(*graph.Def) bool { return true }
+func (l *queryLimiter) SelectRef(*graph.Ref) bool { return true }
+
+// scanned records that n bytes of data were read. It returns an error
+// if the query has exceeded its limits. It may be called on a nil
+// *queryLimiter (for queries without limits).
+func (l *queryLimiter) scanned(n uint64) error {
+	if l == nil {
+		return nil
+	}
+	if v := atomic.AddInt64(&l.bytesScanned, int64(n)); l.limits.MaxBytesScanned > 0 && v > l.limits.MaxBytesScanned {
+		return &errQueryLimitExceeded{limit: "bytes scanned", max: l.limits.MaxBytesScanned}
+	}
+	return l.check()
+}
+
+// selected records that n results were selected, and returns an
+// error if the query has exceeded its limits. Like scanned, it may be
+// called on a nil *queryLimiter.
+func (l *queryLimiter) selected(n int) error {
+	if l == nil {
+		return nil
+	}
+	if v := atomic.AddInt64(&l.results, int64(n)); l.limits.MaxResults > 0 && v > int64(l.limits.MaxResults) {
+		return &errQueryLimitExceeded{limit: "results", max: int64(l.limits.MaxResults)}
+	}
+	return l.check()
+}
+
+// check returns an error if the query has already exceeded its
+// limits (e.g., in another goroutine that is reading a different
+// source unit), so that the remaining reads stop early.
+func (l *queryLimiter) check() error {
+	if l == nil {
+		return nil
+	}
+	if max := l.limits.MaxResults; max > 0 && atomic.LoadInt64(&l.results) > int64(max) {
+		return &errQueryLimitExceeded{limit: "results", max: int64(max)}
+	}
+	if max := l.limits.MaxBytesScanned; max > 0 && atomic.LoadInt64(&l.bytesScanned) > max {
+		return &errQueryLimitExceeded{limit: "bytes scanned", max: max}
+	}
+	return nil
+}
+
+type errQueryLimitExceeded struct {
+	limit string // "results" or "bytes scanned"
+	max   int64
+}
+
+func (e *errQueryLimitExceeded) Error() string {
+	return fmt.Sprintf("query exceeded the limit of %d %s", e.max, e.limit)
+}
+
+// IsQueryLimitExceeded returns a boolean indicating whether err
+// reports that a query exceeded its QueryLimits.
+func IsQueryLimitExceeded(err error) bool {
+	_, ok := err.(*errQueryLimitExceeded)
+	return ok
+}
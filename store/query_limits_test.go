@@ -0,0 +1,73 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestQueryLimits(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		useIndexedStore = indexed
+		mrs := NewFSMultiRepoStore(newTestFS(), nil)
+		for _, repo := range []string{"r1", "r2"} {
+			u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+			var data graph.Output
+			for i := 0; i < 10; i++ {
+				path := fmt.Sprintf("p%d", i)
+				data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}, Name: path, File: "f"})
+				data.Refs = append(data.Refs, &graph.Ref{DefPath: path, File: "f", Start: uint32(i), End: uint32(i + 1)})
+			}
+			if err := mrs.Import(repo, "c", u, data); err != nil {
+				t.Fatal(err)
+			}
+			if indexed {
+				if err := mrs.(MultiRepoIndexer).Index(repo, "c"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		tests := []struct {
+			limits   QueryLimits
+			filters  []interface{}
+			exceeded bool
+		}{
+			{limits: QueryLimits{}, exceeded: false},
+			{limits: QueryLimits{MaxResults: 20}, exceeded: false},
+			{limits: QueryLimits{MaxResults: 19}, exceeded: true},
+			{limits: QueryLimits{MaxBytesScanned: 1 << 20}, exceeded: false},
+			{limits: QueryLimits{MaxBytesScanned: 100}, exceeded: true},
+
+			// Filters that narrow the query keep it within the limits.
+			{limits: QueryLimits{MaxResults: 10}, filters: []interface{}{ByRepos("r1")}, exceeded: false},
+		}
+		for _, test := range tests {
+			label := fmt.Sprintf("indexed=%v limits=%+v filters=%v", indexed, test.limits, test.filters)
+
+			var dfs []DefFilter
+			for _, f := range test.filters {
+				if f, ok := f.(DefFilter); ok {
+					dfs = append(dfs, f)
+				}
+			}
+			_, err := mrs.Defs(append(dfs, WithQueryLimits(test.limits))...)
+			if got := IsQueryLimitExceeded(err); got != test.exceeded {
+				t.Errorf("%s: Defs: got err %v, want exceeded == %v", label, err, test.exceeded)
+			}
+
+			var rfs []RefFilter
+			for _, f := range test.filters {
+				if f, ok := f.(RefFilter); ok {
+					rfs = append(rfs, f)
+				}
+			}
+			_, err = mrs.Refs(append(rfs, WithQueryLimits(test.limits))...)
+			if got := IsQueryLimitExceeded(err); got != test.exceeded {
+				t.Errorf("%s: Refs: got err %v, want exceeded == %v", label, err, test.exceeded)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// ReadOnlyFS returns a VFS that reads from fs but fails all writes
+// (Create, Mkdir, and Remove) with an error for which IsReadOnly
+// returns true. A store whose root is a ReadOnlyFS can be queried but
+// never modified (e.g., by a query that would otherwise build a
+// missing index).
+//
+// If fs implements rwvfs.FetcherOpener, so does the returned VFS.
+func ReadOnlyFS(fs rwvfs.FileSystem) rwvfs.FileSystem {
+	if fo, ok := fs.(rwvfs.FetcherOpener); ok {
+		return &readOnlyFetcherFS{readOnlyFS{fs}, fo}
+	}
+	return &readOnlyFS{fs}
+}
+
+// errReadOnly is the error returned by ReadOnlyFS's write operations.
+type errReadOnly struct {
+	op, name string
+}
+
+func (e *errReadOnly) Error() string {
+	return fmt.Sprintf("%s %s: store is read-only", e.op, e.name)
+}
+
+// IsReadOnly returns a boolean indicating whether err reports that a
+// write to a read-only store (see ReadOnlyFS) was attempted.
+func IsReadOnly(err error) bool {
+	_, ok := err.(*errReadOnly)
+	return ok
+}
+
+type readOnlyFS struct {
+	rwvfs.FileSystem
+}
+
+func (fs *readOnlyFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &errReadOnly{"create", name}
+}
+
+func (fs *readOnlyFS) Mkdir(name string) error { return &errReadOnly{"mkdir", name} }
+
+func (fs *readOnlyFS) Remove(name string) error { return &errReadOnly{"remove", name} }
+
+func (fs *readOnlyFS) String() string { return "readonly(" + fs.FileSystem.String() + ")" }
+
+type readOnlyFetcherFS struct {
+	readOnlyFS
+	fo rwvfs.FetcherOpener
+}
+
+func (fs *readOnlyFetcherFS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
+	return fs.fo.OpenFetcher(name)
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestReadOnlyFS(t *testing.T) {
+	m := map[string]string{"a": "aaaa"}
+	fs := ReadOnlyFS(rwvfs.Map(m))
+
+	data, err := vfs.ReadFile(fs, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "aaaa" {
+		t.Errorf("got %q, want %q", data, "aaaa")
+	}
+
+	if _, err := fs.Create("b"); !IsReadOnly(err) {
+		t.Errorf("Create: got error %v, want read-only error", err)
+	}
+	if err := fs.Remove("a"); !IsReadOnly(err) {
+		t.Errorf("Remove: got error %v, want read-only error", err)
+	}
+	if err := rwvfs.MkdirAll(fs, "d/e"); !IsReadOnly(err) {
+		t.Errorf("MkdirAll: got error %v, want read-only error", err)
+	}
+	if want := (map[string]string{"a": "aaaa"}); len(m) != len(want) || m["a"] != want["a"] {
+		t.Errorf("underlying VFS was modified: got %v, want %v", m, want)
+	}
+
+	if _, ok := fs.(rwvfs.FetcherOpener); ok {
+		t.Error("ReadOnlyFS of a non-FetcherOpener VFS implements FetcherOpener")
+	}
+	fs = ReadOnlyFS(&flakyFetcherFS{FileSystem: rwvfs.Map(m)})
+	if _, ok := fs.(rwvfs.FetcherOpener); !ok {
+		t.Error("ReadOnlyFS of a FetcherOpener VFS does not implement FetcherOpener")
+	}
+}
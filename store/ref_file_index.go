@@ -47,8 +47,8 @@ func (x *refFileIndex) getByFile(file string) (byteRanges, bool, error) {
 
 // Covers implements defIndex.
 func (x *refFileIndex) Covers(filters interface{}) int {
-	// TODO(sqs): this index also covers RefStart/End range filters
-	// (when those are added).
+	// Queries that also filter by byte range (ByRefRange) are
+	// better served by refIntervalIndex.
 	cov := 0
 	for _, f := range storeFilters(filters) {
 		if _, ok := f.(ByFilesFilter); ok {
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+)
+
+// refIntervalIndex makes it fast to determine which refs (within a
+// source unit) are within a byte range of a file (see ByRefRange),
+// without reading all of the refs in the file. This matters for large
+// (often generated) files, which can contain hundreds of thousands of
+// refs.
+//
+// For each file, it stores the Start, End, and byte offset (in the
+// ref data file) of each ref, in columns sorted by Start.
+type refIntervalIndex struct {
+	phtable *phtable.CHD
+	ready   bool
+}
+
+// refIntervals is the serialized form of the refs in a single file
+// in a refIntervalIndex. The slices are parallel and sorted by
+// Starts.
+type refIntervals struct {
+	Starts  []uint32
+	Ends    []uint32
+	Offsets []int64
+}
+
+func (v *refIntervals) Len() int { return len(v.Starts) }
+func (v *refIntervals) Swap(i, j int) {
+	v.Starts[i], v.Starts[j] = v.Starts[j], v.Starts[i]
+	v.Ends[i], v.Ends[j] = v.Ends[j], v.Ends[i]
+	v.Offsets[i], v.Offsets[j] = v.Offsets[j], v.Offsets[i]
+}
+func (v *refIntervals) Less(i, j int) bool {
+	return v.Starts[i] < v.Starts[j] || (v.Starts[i] == v.Starts[j] && v.Offsets[i] < v.Offsets[j])
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	refIndexByteOffsets
+	refIndexBuilder
+} = (*refIntervalIndex)(nil)
+
+var c_refIntervalIndex_getByRange = 0 // counter
+
+func (x *refIntervalIndex) String() string { return fmt.Sprintf("refIntervalIndex(ready=%v)", x.ready) }
+
+// getByRange returns the byte offsets (in the ref data file) of the
+// refs in file that are within the byte range [start, end] (or
+// [start, ∞) if end is 0).
+func (x *refIntervalIndex) getByRange(file string, start, end uint32) (byteOffsets, error) {
+	c_refIntervalIndex_getByRange++
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+	b := x.phtable.Get([]byte(file))
+	if b == nil {
+		return nil, nil
+	}
+	var v refIntervals
+	if err := binary.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	var ofs byteOffsets
+	for i := sort.Search(v.Len(), func(i int) bool { return v.Starts[i] >= start }); i < v.Len(); i++ {
+		if end != 0 && v.Starts[i] > end {
+			break
+		}
+		if end == 0 || v.Ends[i] <= end {
+			ofs = append(ofs, v.Offsets[i])
+		}
+	}
+	return ofs, nil
+}
+
+// Covers implements Index. It only covers queries that filter by
+// both file and byte range.
+func (x *refIntervalIndex) Covers(filters interface{}) int {
+	var files, rng bool
+	for _, f := range storeFilters(filters) {
+		switch f.(type) {
+		case ByFilesFilter:
+			files = true
+		case ByRefRangeFilter:
+			rng = true
+		}
+	}
+	if files && rng {
+		return 2
+	}
+	return 0
+}
+
+// Refs implements refIndexByteOffsets.
+func (x *refIntervalIndex) Refs(fs ...RefFilter) (byteOffsets, error) {
+	var files []string
+	var start, end uint32
+	for _, f := range fs {
+		switch f := f.(type) {
+		case ByFilesFilter:
+			files = f.ByFiles()
+		case ByRefRangeFilter:
+			start, end = f.ByRefRange()
+		}
+	}
+	var ofs byteOffsets
+	for _, file := range files {
+		fileOfs, err := x.getByRange(file, start, end)
+		if err != nil {
+			return nil, err
+		}
+		ofs = append(ofs, fileOfs...)
+	}
+	return ofs, nil
+}
+
+// Build implements refIndexBuilder.
+func (x *refIntervalIndex) Build(refs []*graph.Ref, fbr fileByteRanges, ofs byteOffsets) error {
+	vlog.Printf("refIntervalIndex: building index (%d refs)...", len(refs))
+	byFile := make(map[string]*refIntervals, len(fbr))
+	for i, ref := range refs {
+		v, present := byFile[ref.File]
+		if !present {
+			v = &refIntervals{}
+			byFile[ref.File] = v
+		}
+		v.Starts = append(v.Starts, ref.Start)
+		v.Ends = append(v.Ends, ref.End)
+		v.Offsets = append(v.Offsets, ofs[i])
+	}
+
+	b := phtable.Builder(len(byFile))
+	for file, v := range byFile {
+		sort.Sort(v)
+		bv, err := binary.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Add([]byte(file), bv)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("refIntervalIndex: done building index (%d files).", len(byFile))
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *refIntervalIndex) Write(w io.Writer) error {
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// Read implements persistedIndex.
+func (x *refIntervalIndex) Read(r io.Reader) error {
+	var err error
+	x.phtable, err = phtable.Read(r)
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *refIntervalIndex) Ready() bool { return x.ready }
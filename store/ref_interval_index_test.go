@@ -0,0 +1,58 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestIndexedUnitStore_RefsByRange(t *testing.T) {
+	useIndexedStore = true
+	rs := NewFSRepoStore(newTestFS())
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f", "g"}}
+	data := graph.Output{Refs: []*graph.Ref{
+		{DefPath: "a", File: "f", Start: 0, End: 5},
+		{DefPath: "b", File: "f", Start: 10, End: 15},
+		{DefPath: "c", File: "f", Start: 12, End: 30},
+		{DefPath: "d", File: "f", Start: 20, End: 25},
+		{DefPath: "e", File: "g", Start: 10, End: 15},
+	}}
+	if err := rs.Import("c", u, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.(RepoIndexer).Index("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start, end uint32
+		want       []string
+	}{
+		{10, 25, []string{"b", "d"}},
+		{10, 0, []string{"b", "c", "d"}},
+		{0, 5, []string{"a"}},
+		{31, 0, nil},
+	}
+	for _, test := range tests {
+		c_refIntervalIndex_getByRange = 0
+		refs, err := rs.Refs(ByFiles("f"), ByRefRange(test.start, test.end))
+		if err != nil {
+			t.Errorf("[%d, %d]: Refs: %s", test.start, test.end, err)
+			continue
+		}
+		var got []string
+		for _, ref := range refs {
+			got = append(got, ref.DefPath)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("[%d, %d]: got refs %v, want %v", test.start, test.end, got, test.want)
+		}
+		if c_refIntervalIndex_getByRange == 0 {
+			t.Errorf("[%d, %d]: ref interval index was not used", test.start, test.end)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package store
+
+import (
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A RefResolution summarizes how well the refs in a source unit
+// resolve: how many of them point to defs that do not exist in the
+// store ("broken refs"). Toolchain maintainers can use it to measure
+// unresolved refs per source unit and track them over time.
+//
+// Only refs to defs in the same repository are checked. Refs to defs
+// in other repositories are counted in CrossRepoRefs.
+type RefResolution struct {
+	Repo     string `json:",omitempty"`
+	CommitID string `json:",omitempty"`
+	UnitType string
+	Unit     string
+
+	// Refs is the number of refs in the source unit to defs in the
+	// same repository.
+	Refs int
+
+	// Broken is the number of Refs whose defs do not exist.
+	Broken int
+
+	// CrossRepoRefs is the number of refs in the source unit to defs
+	// in other repositories, which are not checked.
+	CrossRepoRefs int
+
+	// BrokenDefs are the keys of the nonexistent defs that the broken
+	// refs point to. Their DefRepo is always empty.
+	BrokenDefs []graph.RefDefKey `json:",omitempty"`
+}
+
+// Resolved returns the fraction of the checked refs that resolve to
+// defs (1 if there are no checked refs).
+func (r *RefResolution) Resolved() float64 {
+	if r.Refs == 0 {
+		return 1
+	}
+	return float64(r.Refs-r.Broken) / float64(r.Refs)
+}
+
+// A RefResolutionStore reports the resolution quality of the refs in
+// source units. It is implemented by the FS-backed and in-memory
+// stores at the MultiRepoStore, RepoStore, and TreeStore levels. At
+// the TreeStore level, it uses the broken refs index if it has been
+// built; otherwise it reads all of the tree's defs and refs.
+type RefResolutionStore interface {
+	// RefResolutions returns the ref resolution summaries of the
+	// source units that match the filters.
+	RefResolutions(...UnitFilter) ([]*RefResolution, error)
+}
+
+// BrokenRefs returns a filter that selects the broken refs described
+// by rs (usually the result of a RefResolutions query with the same
+// repo and commit filters). To avoid reading the refs of source units
+// that have no broken refs, use it along with ByUnits(BrokenUnits(rs)...).
+func BrokenRefs(rs []*RefResolution) RefFilter {
+	type brokenKey struct {
+		repo, commitID string
+		unit           unit.ID2
+		def            graph.RefDefKey
+	}
+	broken := map[brokenKey]struct{}{}
+	for _, r := range rs {
+		for _, def := range r.BrokenDefs {
+			broken[brokenKey{r.Repo, r.CommitID, unit.ID2{Type: r.UnitType, Name: r.Unit}, def}] = struct{}{}
+		}
+	}
+	return AbsRefFilterFunc(func(ref *graph.Ref) bool {
+		if ref.DefRepo != ref.Repo {
+			return false
+		}
+		k := brokenKey{
+			repo:     ref.Repo,
+			commitID: ref.CommitID,
+			unit:     unit.ID2{Type: ref.UnitType, Name: ref.Unit},
+			def:      graph.RefDefKey{DefUnitType: ref.DefUnitType, DefUnit: ref.DefUnit, DefPath: ref.DefPath},
+		}
+		_, ok := broken[k]
+		return ok
+	})
+}
+
+// BrokenUnits returns the source units in rs that have broken refs.
+func BrokenUnits(rs []*RefResolution) []unit.ID2 {
+	var us []unit.ID2
+	seen := map[unit.ID2]struct{}{}
+	for _, r := range rs {
+		u := unit.ID2{Type: r.UnitType, Name: r.Unit}
+		if _, seen_ := seen[u]; r.Broken > 0 && !seen_ {
+			seen[u] = struct{}{}
+			us = append(us, u)
+		}
+	}
+	return us
+}
+
+type refResolutionsByUnit []*RefResolution
+
+func (v refResolutionsByUnit) Len() int      { return len(v) }
+func (v refResolutionsByUnit) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v refResolutionsByUnit) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ak := []string{a.Repo, a.CommitID, a.UnitType, a.Unit}
+	bk := []string{b.Repo, b.CommitID, b.UnitType, b.Unit}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+type refDefKeys []graph.RefDefKey
+
+func (v refDefKeys) Len() int      { return len(v) }
+func (v refDefKeys) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v refDefKeys) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.DefUnitType != b.DefUnitType {
+		return a.DefUnitType < b.DefUnitType
+	}
+	if a.DefUnit != b.DefUnit {
+		return a.DefUnit < b.DefUnit
+	}
+	return a.DefPath < b.DefPath
+}
+
+func (s repoStores) RefResolutions(f ...UnitFilter) ([]*RefResolution, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*RefResolution
+	for repo, rs := range rss {
+		rrs, ok := rs.(RefResolutionStore)
+		if !ok {
+			continue
+		}
+
+		res, err := rrs.RefResolutions(filtersForRepo(repo, f).([]UnitFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, r := range res {
+			r.Repo = repo
+		}
+		all = append(all, res...)
+	}
+	sort.Sort(refResolutionsByUnit(all))
+	return all, nil
+}
+
+func (s treeStores) RefResolutions(f ...UnitFilter) ([]*RefResolution, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]UnitFilter)
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*RefResolution
+	for commitID, ts := range tss {
+		if ts == nil {
+			continue
+		}
+
+		var res []*RefResolution
+		if rrs, ok := ts.(RefResolutionStore); ok {
+			res, err = rrs.RefResolutions(f...)
+		} else {
+			res, err = scanRefResolutions(ts, f)
+		}
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, r := range res {
+			r.CommitID = commitID
+		}
+		all = append(all, res...)
+	}
+	sort.Sort(refResolutionsByUnit(all))
+	return all, nil
+}
+
+// RefResolutions implements RefResolutionStore.
+func (s *fsMultiRepoStore) RefResolutions(f ...UnitFilter) ([]*RefResolution, error) {
+	return s.repoStores.RefResolutions(s.resolveRepoAliases(f).([]UnitFilter)...)
+}
+
+// RefResolutions implements RefResolutionStore. It uses the broken
+// refs index if it has been built.
+func (s *indexedTreeStore) RefResolutions(f ...UnitFilter) ([]*RefResolution, error) {
+	x := s.indexes[brokenRefsIndexName].(*brokenRefsIndex)
+	if err := prepareIndex(s.fs, brokenRefsIndexName, x); err != nil {
+		// Trees indexed before the broken refs index existed don't
+		// have it.
+		if _, ok := err.(*errIndexNotExist); !ok {
+			return nil, err
+		}
+		return scanRefResolutions(s, f)
+	}
+
+	var scope map[unit.ID2]struct{}
+	if len(f) > 0 {
+		unitIDs, err := s.unitIDs(false, f...)
+		if err != nil {
+			return nil, err
+		}
+		scope = make(map[unit.ID2]struct{}, len(unitIDs))
+		for _, u := range unitIDs {
+			scope[u] = struct{}{}
+		}
+	}
+
+	var res []*RefResolution
+	for _, r := range x.resolutions {
+		if scope != nil {
+			if _, ok := scope[unit.ID2{Type: r.UnitType, Name: r.Unit}]; !ok {
+				continue
+			}
+		}
+		rc := *r
+		res = append(res, &rc)
+	}
+	return res, nil
+}
+
+// scanRefResolutions computes the ref resolutions of the source units
+// in ts that match the filters by reading all of the tree's defs and
+// the refs of those source units.
+func scanRefResolutions(ts TreeStore, f []UnitFilter) ([]*RefResolution, error) {
+	units, err := ts.Units(f...)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, nil
+	}
+
+	defs, err := ts.Defs()
+	if err != nil {
+		return nil, err
+	}
+	defKeys := make(map[graph.RefDefKey]struct{}, len(defs))
+	for _, def := range defs {
+		defKeys[graph.RefDefKey{DefUnitType: def.UnitType, DefUnit: def.Unit, DefPath: def.Path}] = struct{}{}
+	}
+
+	unitIDs := make([]unit.ID2, len(units))
+	res := make(map[unit.ID2]*RefResolution, len(units))
+	for i, u := range units {
+		unitIDs[i] = u.ID2()
+		res[u.ID2()] = &RefResolution{UnitType: u.Type, Unit: u.Name}
+	}
+	refs, err := ts.Refs(ByUnits(unitIDs...))
+	if err != nil {
+		return nil, err
+	}
+	brokenDefs := map[unit.ID2]map[graph.RefDefKey]struct{}{}
+	for _, ref := range refs {
+		u := unit.ID2{Type: ref.UnitType, Name: ref.Unit}
+		r, ok := res[u]
+		if !ok {
+			continue
+		}
+		if ref.DefRepo != "" {
+			r.CrossRepoRefs++
+			continue
+		}
+		r.Refs++
+		def := graph.RefDefKey{DefUnitType: ref.DefUnitType, DefUnit: ref.DefUnit, DefPath: ref.DefPath}
+		if _, ok := defKeys[def]; ok {
+			continue
+		}
+		r.Broken++
+		if brokenDefs[u] == nil {
+			brokenDefs[u] = map[graph.RefDefKey]struct{}{}
+		}
+		if _, seen := brokenDefs[u][def]; !seen {
+			brokenDefs[u][def] = struct{}{}
+			r.BrokenDefs = append(r.BrokenDefs, def)
+		}
+	}
+
+	all := make([]*RefResolution, 0, len(res))
+	for _, r := range res {
+		sort.Sort(refDefKeys(r.BrokenDefs))
+		all = append(all, r)
+	}
+	sort.Sort(refResolutionsByUnit(all))
+	return all, nil
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_RefResolutions(t *testing.T) {
+	testMultiRepoStore_RefResolutions(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_RefResolutions(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_RefResolutions(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_RefResolutions(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_RefResolutions(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_RefResolutions(t *testing.T, mrs MultiRepoStoreImporter) {
+	data := map[string]graph.Output{
+		"a": {
+			Defs: []*graph.Def{
+				{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "a/f"},
+				{DefKey: graph.DefKey{Path: "q"}, Name: "q", File: "a/f"},
+			},
+			Refs: []*graph.Ref{
+				{DefPath: "p", File: "a/f", Start: 1, End: 2},
+				{DefPath: "x", File: "a/f", Start: 3, End: 4},
+				{DefUnitType: "GoPackage", DefUnit: "b", DefPath: "y", File: "a/f", Start: 5, End: 6},
+				{DefUnitType: "GoPackage", DefUnit: "b", DefPath: "z", File: "a/f", Start: 7, End: 8},
+				{DefRepo: "other", DefUnitType: "GoPackage", DefUnit: "o", DefPath: "w", File: "a/f", Start: 9, End: 10},
+			},
+		},
+		"b": {
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "y"}, Name: "y", File: "b/f"}},
+			Refs: []*graph.Ref{{DefPath: "y", File: "b/f", Start: 1, End: 2}},
+		},
+	}
+	for name, data := range data {
+		u := &unit.SourceUnit{Type: "GoPackage", Name: name, Files: []string{name + "/f"}}
+		if err := mrs.Import("r", "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mrs, ok := mrs.(MultiRepoIndexer); ok {
+		if err := mrs.Index("r", "c"); err != nil {
+			t.Fatalf("%s: Index: %s", mrs, err)
+		}
+	}
+
+	rrs, ok := mrs.(RefResolutionStore)
+	if !ok {
+		t.Fatalf("%s: does not implement RefResolutionStore", mrs)
+	}
+	res, err := rrs.RefResolutions(ByRepos("r"))
+	if err != nil {
+		t.Fatalf("%s: RefResolutions: %s", mrs, err)
+	}
+	want := []*RefResolution{
+		{
+			Repo: "r", CommitID: "c", UnitType: "GoPackage", Unit: "a",
+			Refs: 4, Broken: 2, CrossRepoRefs: 1,
+			BrokenDefs: []graph.RefDefKey{
+				{DefUnitType: "GoPackage", DefUnit: "a", DefPath: "x"},
+				{DefUnitType: "GoPackage", DefUnit: "b", DefPath: "z"},
+			},
+		},
+		{Repo: "r", CommitID: "c", UnitType: "GoPackage", Unit: "b", Refs: 1},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("%s: RefResolutions: got %+v, want %+v", mrs, res, want)
+	}
+	if r := res[0].Resolved(); r != 0.5 {
+		t.Errorf("%s: got Resolved %v, want 0.5", mrs, r)
+	}
+
+	res, err = rrs.RefResolutions(ByRepos("r"), ByUnits(unit.ID2{Type: "GoPackage", Name: "b"}))
+	if err != nil {
+		t.Fatalf("%s: RefResolutions(unit b): %s", mrs, err)
+	}
+	if len(res) != 1 || res[0].Unit != "b" {
+		t.Errorf("%s: RefResolutions(unit b): got %+v, want only unit b", mrs, res)
+	}
+
+	res, err = rrs.RefResolutions(ByRepos("r"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, err := mrs.Refs(ByRepos("r"), ByUnits(BrokenUnits(res)...), BrokenRefs(res))
+	if err != nil {
+		t.Fatalf("%s: Refs(BrokenRefs): %s", mrs, err)
+	}
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.DefUnit+"/"+ref.DefPath)
+	}
+	if want := []string{"a/x", "b/z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: Refs(BrokenRefs): got %v, want %v", mrs, got, want)
+	}
+}
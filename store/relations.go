@@ -0,0 +1,320 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A RelationStore stores and accesses type-hierarchy relations (see
+// graph.Relation). It is implemented by the FS-backed and in-memory
+// stores at all levels (MultiRepoStore, RepoStore, TreeStore, and
+// UnitStore).
+//
+// Relations are much sparser than refs, so they are not indexed;
+// queries scan the relations of each source unit in scope.
+type RelationStore interface {
+	// Relations returns all relations that match the filters.
+	Relations(...RelationFilter) ([]*graph.Relation, error)
+}
+
+// A RelationFilter filters a set of relations to only those for
+// which SelectRelation returns true.
+type RelationFilter interface {
+	SelectRelation(*graph.Relation) bool
+}
+
+type relationFilters []RelationFilter
+
+func (fs relationFilters) SelectRelation(r *graph.Relation) bool {
+	for _, f := range fs {
+		if !f.SelectRelation(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// A RelationFilterFunc is a RelationFilter that selects only those
+// relations for which the func returns true.
+type RelationFilterFunc func(*graph.Relation) bool
+
+// SelectRelation calls f(r).
+func (f RelationFilterFunc) SelectRelation(r *graph.Relation) bool { return f(r) }
+func (f RelationFilterFunc) String() string                        { return "RelationFilterFunc" }
+
+// ByRelationKind returns a filter that selects relations of the
+// given kind (e.g., graph.RelationImplements). It panics if kind is
+// empty.
+func ByRelationKind(kind string) RelationFilter {
+	if kind == "" {
+		panic("kind: empty")
+	}
+	return byRelationKindFilter(kind)
+}
+
+type byRelationKindFilter string
+
+func (f byRelationKindFilter) String() string { return fmt.Sprintf("ByRelationKind(%s)", string(f)) }
+func (f byRelationKindFilter) SelectRelation(r *graph.Relation) bool {
+	return r.Kind == string(f)
+}
+
+// ByRelationDefPath returns a filter that selects the relations from
+// the def with the given path (i.e., it can be used to list the
+// interfaces a type implements or the types it extends). Because the
+// subtype def is always in the same source unit as its relations, use
+// it along with ByUnits (or ByUnitKey) to select the relations of a
+// specific def. It panics if defPath is empty.
+func ByRelationDefPath(defPath string) RelationFilter {
+	if defPath == "" {
+		panic("defPath: empty")
+	}
+	return byRelationDefPathFilter(defPath)
+}
+
+type byRelationDefPathFilter string
+
+func (f byRelationDefPathFilter) String() string {
+	return fmt.Sprintf("ByRelationDefPath(%s)", string(f))
+}
+func (f byRelationDefPathFilter) SelectRelation(r *graph.Relation) bool {
+	return r.DefPath == string(f)
+}
+
+// ByRelationTarget returns a filter that selects the relations to the
+// given def (i.e., it can be used to list the implementations of an
+// interface or the subtypes of a type). It panics if def.DefPath is
+// empty. As with ByRefDef, the def should be fully specified
+// (DefRepo, DefUnitType, and DefUnit should be set).
+func ByRelationTarget(def graph.RefDefKey) RelationFilter {
+	if def.DefPath == "" {
+		panic("def.DefPath: empty")
+	}
+	return &byRelationTargetFilter{def: def}
+}
+
+// byRelationTargetFilter is like byRefDefFilter, except that it
+// filters relations (and not refs). See byRefDefFilter for an
+// explanation of the implied* fields.
+type byRelationTargetFilter struct {
+	def graph.RefDefKey
+
+	impliedRepo string   // the implied TargetRepo value when r.TargetRepo == ""
+	impliedUnit unit.ID2 // the implied TargetUnit{,Type} value when r.TargetUnit{,Type} == ""
+}
+
+func (f *byRelationTargetFilter) String() string {
+	return fmt.Sprintf("ByRelationTarget(%+v, impliedRepo=%q, impliedUnit=%+v)", f.def, f.impliedRepo, f.impliedUnit)
+}
+func (f *byRelationTargetFilter) setImpliedRepo(repo string) { f.impliedRepo = repo }
+func (f *byRelationTargetFilter) setImpliedUnit(u unit.ID2)  { f.impliedUnit = u }
+func (f *byRelationTargetFilter) SelectRelation(r *graph.Relation) bool {
+	return ((r.TargetRepo == "" && f.impliedRepo == f.def.DefRepo) || r.TargetRepo == f.def.DefRepo) &&
+		((r.TargetUnitType == "" && f.impliedUnit.Type == f.def.DefUnitType) || r.TargetUnitType == f.def.DefUnitType) &&
+		((r.TargetUnit == "" && f.impliedUnit.Name == f.def.DefUnit) || r.TargetUnit == f.def.DefUnit) &&
+		r.TargetPath == f.def.DefPath
+}
+
+var _ impliedRepoSetter = (*byRelationTargetFilter)(nil)
+var _ impliedUnitSetter = (*byRelationTargetFilter)(nil)
+
+func (s repoStores) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allRels []*graph.Relation
+	for repo, rs := range rss {
+		relStore, ok := rs.(RelationStore)
+		if !ok {
+			continue
+		}
+
+		setImpliedRepo(f, repo)
+		rels, err := relStore.Relations(filtersForRepo(repo, f).([]RelationFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, r := range rels {
+			r.Repo = repo
+			if r.TargetRepo == "" {
+				r.TargetRepo = repo
+			}
+		}
+		allRels = append(allRels, rels...)
+	}
+	sortRelations(allRels, f)
+	return allRels, nil
+}
+
+func (s treeStores) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]RelationFilter)
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allRels []*graph.Relation
+	for commitID, ts := range tss {
+		relStore, ok := ts.(RelationStore)
+		if !ok {
+			continue
+		}
+
+		setImpliedCommitID(f, commitID)
+		rels, err := relStore.Relations(f...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, r := range rels {
+			r.CommitID = commitID
+		}
+		allRels = append(allRels, rels...)
+	}
+	sortRelations(allRels, f)
+	return allRels, nil
+}
+
+func (s unitStores) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	uss, err := openUnitStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allRels []*graph.Relation
+	for u, us := range uss {
+		relStore, ok := us.(RelationStore)
+		if !ok {
+			continue
+		}
+
+		setImpliedUnit(f, u)
+		rels, err := relStore.Relations(filtersForUnit(u, f).([]RelationFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, r := range rels {
+			r.UnitType = u.Type
+			r.Unit = u.Name
+			if r.TargetUnitType == "" {
+				r.TargetUnitType = u.Type
+			}
+			if r.TargetUnit == "" {
+				r.TargetUnit = u.Name
+			}
+		}
+		allRels = append(allRels, rels...)
+	}
+	sortRelations(allRels, f)
+	return allRels, nil
+}
+
+func sortRelations(rels []*graph.Relation, fs []RelationFilter) {
+	if isUnordered(fs) {
+		return
+	}
+	sort.Sort(graph.Relations(rels))
+}
+
+const unitRelationsFilename = "relation.dat"
+
+// Relations implements RelationStore. Stores that were imported
+// before relations were stored have no relation data file; they are
+// treated as having no relations.
+func (s *fsUnitStore) Relations(fs ...RelationFilter) (rels []*graph.Relation, err error) {
+	vlog.Printf("%s: reading relations with filters %v...", s, fs)
+	f, err := s.fs.Open(unitRelationsFilename)
+	if err != nil {
+		if isOSOrVFSNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	dec := s.codec.NewDecoder(f)
+	for {
+		var r graph.Relation
+		if _, err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if relationFilters(fs).SelectRelation(&r) {
+			rels = append(rels, &r)
+		}
+	}
+	vlog.Printf("%s: read %d relations with filters %v.", s, len(rels), fs)
+	return rels, nil
+}
+
+// writeRelations writes the relation data file.
+func (s *fsUnitStore) writeRelations(rels []*graph.Relation) (err error) {
+	vlog.Printf("%s: writing %d relations...", s, len(rels))
+	f, err := s.fs.Create(unitRelationsFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	enc := s.codec.NewEncoder(bw)
+	for _, r := range rels {
+		if _, err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	vlog.Printf("%s: done writing %d relations.", s, len(rels))
+	return nil
+}
+
+// Relations implements RelationStore.
+func (s *memoryUnitStore) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	if s.data == nil {
+		return nil, errUnitNoInit
+	}
+
+	var rels []*graph.Relation
+	for _, r := range s.data.Relations {
+		if relationFilters(f).SelectRelation(r) {
+			rels = append(rels, r)
+		}
+	}
+	return rels, nil
+}
+
+var (
+	_ RelationStore = (*fsMultiRepoStore)(nil)
+	_ RelationStore = (*fsRepoStore)(nil)
+	_ RelationStore = (*fsTreeStore)(nil)
+	_ RelationStore = (*indexedTreeStore)(nil)
+	_ RelationStore = (*fsUnitStore)(nil)
+	_ RelationStore = (*indexedUnitStore)(nil)
+	_ RelationStore = (*memoryMultiRepoStore)(nil)
+	_ RelationStore = (*memoryRepoStore)(nil)
+	_ RelationStore = (*memoryTreeStore)(nil)
+	_ RelationStore = (*memoryUnitStore)(nil)
+)
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_Relations(t *testing.T) {
+	testMultiRepoStore_Relations(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Relations(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_Relations(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_Relations(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_Relations(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_Relations(t *testing.T, mrs MultiRepoStoreImporter) {
+	rs := mrs.(RelationStore)
+
+	lib := &unit.SourceUnit{Type: "t", Name: "lib"}
+	if err := mrs.Import("r", "c", lib, graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "I"}}, {DefKey: graph.DefKey{Path: "T"}}},
+		Relations: []*graph.Relation{
+			{Kind: graph.RelationImplements, DefPath: "T", TargetPath: "I"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	app := &unit.SourceUnit{Type: "t", Name: "app"}
+	if err := mrs.Import("r", "c", app, graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "A"}}},
+		Relations: []*graph.Relation{
+			{Kind: graph.RelationImplements, DefPath: "A", TargetRepo: "r", TargetUnitType: "t", TargetUnit: "lib", TargetPath: "I"},
+			{Kind: graph.RelationExtends, DefPath: "A", TargetRepo: "r", TargetUnitType: "t", TargetUnit: "lib", TargetPath: "T"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Implementations of lib's I (in both lib and app).
+	rels, err := rs.Relations(ByRelationTarget(graph.RefDefKey{DefRepo: "r", DefUnitType: "t", DefUnit: "lib", DefPath: "I"}))
+	if err != nil {
+		t.Fatalf("%s: Relations(ByRelationTarget(I)): %s", mrs, err)
+	}
+	want := []*graph.Relation{
+		{Kind: "implements", DefPath: "A", TargetRepo: "r", TargetUnitType: "t", TargetUnit: "lib", TargetPath: "I", Repo: "r", CommitID: "c", UnitType: "t", Unit: "app"},
+		{Kind: "implements", DefPath: "T", TargetRepo: "r", TargetUnitType: "t", TargetUnit: "lib", TargetPath: "I", Repo: "r", CommitID: "c", UnitType: "t", Unit: "lib"},
+	}
+	if !reflect.DeepEqual(rels, want) {
+		t.Errorf("%s: Relations(ByRelationTarget(I)): got %v, want %v", mrs, rels, want)
+	}
+
+	// Supertypes of A, restricted to "extends".
+	rels, err = rs.Relations(ByUnits(app.ID2()), ByRelationDefPath("A"), ByRelationKind(graph.RelationExtends))
+	if err != nil {
+		t.Fatalf("%s: Relations(ByRelationDefPath(A)): %s", mrs, err)
+	}
+	if len(rels) != 1 || rels[0].TargetPath != "T" {
+		t.Errorf("%s: Relations(ByRelationDefPath(A), extends): got %v, want only the relation to T", mrs, rels)
+	}
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A DefRename records that a def was renamed (or moved) between two
+// commits of a repository: the def identified by the From* fields at
+// FromCommitID is the same def as the one identified by the To*
+// fields at ToCommitID.
+//
+// Renames are not emitted by toolchains; they are detected by
+// DetectDefRenames and imported into the store (see "src store
+// detect-renames").
+type DefRename struct {
+	Repo         string `json:",omitempty"`
+	FromCommitID string
+	ToCommitID   string
+
+	FromUnitType string
+	FromUnit     string
+	FromPath     string
+
+	ToUnitType string
+	ToUnit     string
+	ToPath     string
+
+	// Score is the similarity of the two defs, from 0 (dissimilar) to
+	// 1 (identical except for their path).
+	Score float64
+}
+
+// FromDefKey returns the key of the def at FromCommitID.
+func (r *DefRename) FromDefKey() graph.DefKey {
+	return graph.DefKey{Repo: r.Repo, CommitID: r.FromCommitID, UnitType: r.FromUnitType, Unit: r.FromUnit, Path: r.FromPath}
+}
+
+// ToDefKey returns the key of the def at ToCommitID.
+func (r *DefRename) ToDefKey() graph.DefKey {
+	return graph.DefKey{Repo: r.Repo, CommitID: r.ToCommitID, UnitType: r.ToUnitType, Unit: r.ToUnit, Path: r.ToPath}
+}
+
+// A DefRenameStore stores and accesses def renames. It is implemented
+// by the FS-backed and in-memory stores at the MultiRepoStore and
+// RepoStore levels.
+type DefRenameStore interface {
+	// DefRenames returns the renames recorded in repo. If
+	// fromCommitID and/or toCommitID are non-empty, only renames
+	// between those commits are returned. The repo is only used by
+	// MultiRepoStores.
+	DefRenames(repo, fromCommitID, toCommitID string) ([]*DefRename, error)
+}
+
+// A DefRenameImporter imports def renames into a store. It overwrites
+// any previously imported renames between the same two commits. The
+// repo is only used by MultiRepoStores.
+type DefRenameImporter interface {
+	ImportDefRenames(repo, fromCommitID, toCommitID string, renames []*DefRename) error
+}
+
+// DefaultRenameThreshold is the minimum similarity score for a pair
+// of defs to be considered a rename by DetectDefRenames.
+const DefaultRenameThreshold = 0.6
+
+// DetectDefRenames matches the defs that were removed between two
+// commits (i.e., that are in fromDefs but not in toDefs) to the defs
+// that were added (that are in toDefs but not in fromDefs), based on
+// the similarity of their names, toolchain-specific data (which
+// typically includes their signature), and docs. Only defs of the
+// same source unit type and kind are matched, and each def is matched
+// at most once (the most similar pairs are matched first). Pairs with
+// a score below threshold are not matched.
+//
+// The returned renames' commit IDs and repo are taken from the defs.
+func DetectDefRenames(fromDefs, toDefs []*graph.Def, threshold float64) []*DefRename {
+	fromKeys := make(map[string]struct{}, len(fromDefs))
+	for _, def := range fromDefs {
+		fromKeys[defHistoryKey(def.DefKey)] = struct{}{}
+	}
+	toKeys := make(map[string]struct{}, len(toDefs))
+	for _, def := range toDefs {
+		toKeys[defHistoryKey(def.DefKey)] = struct{}{}
+	}
+
+	var removed, added []*graph.Def
+	for _, def := range fromDefs {
+		if _, present := toKeys[defHistoryKey(def.DefKey)]; !present && !def.Local {
+			removed = append(removed, def)
+		}
+	}
+	for _, def := range toDefs {
+		if _, present := fromKeys[defHistoryKey(def.DefKey)]; !present && !def.Local {
+			added = append(added, def)
+		}
+	}
+
+	var cs renameCandidates
+	for _, a := range removed {
+		for _, b := range added {
+			if a.UnitType != b.UnitType || a.Kind != b.Kind {
+				continue
+			}
+			if score := defSimilarity(a, b); score >= threshold {
+				cs = append(cs, renameCandidate{a, b, score})
+			}
+		}
+	}
+	sort.Stable(cs)
+
+	matched := map[*graph.Def]struct{}{}
+	var renames []*DefRename
+	for _, c := range cs {
+		if _, m := matched[c.from]; m {
+			continue
+		}
+		if _, m := matched[c.to]; m {
+			continue
+		}
+		matched[c.from] = struct{}{}
+		matched[c.to] = struct{}{}
+		renames = append(renames, &DefRename{
+			Repo:         c.to.Repo,
+			FromCommitID: c.from.CommitID,
+			ToCommitID:   c.to.CommitID,
+			FromUnitType: c.from.UnitType,
+			FromUnit:     c.from.Unit,
+			FromPath:     c.from.Path,
+			ToUnitType:   c.to.UnitType,
+			ToUnit:       c.to.Unit,
+			ToPath:       c.to.Path,
+			Score:        c.score,
+		})
+	}
+	sort.Sort(defRenamesByFrom(renames))
+	return renames
+}
+
+type renameCandidate struct {
+	from, to *graph.Def
+	score    float64
+}
+
+// renameCandidates sorts candidates by descending score.
+type renameCandidates []renameCandidate
+
+func (v renameCandidates) Len() int           { return len(v) }
+func (v renameCandidates) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v renameCandidates) Less(i, j int) bool { return v[i].score > v[j].score }
+
+// defSimilarity returns the similarity of a and b, from 0 to 1. The
+// name counts for 40%, the toolchain-specific data for 30%, and the
+// docs for 30%. Docs are only compared if both defs have docs (and
+// otherwise the other parts are weighted proportionally).
+func defSimilarity(a, b *graph.Def) float64 {
+	score := 0.4*stringSimilarity(a.Name, b.Name) + 0.3*stringSimilarity(string(a.Data), string(b.Data))
+	if len(a.Docs) == 0 || len(b.Docs) == 0 {
+		return score / 0.7
+	}
+	return score + 0.3*stringSimilarity(a.Docs[0].Data, b.Docs[0].Data)
+}
+
+// stringSimilarity returns the Dice coefficient of the character
+// bigrams of a and b (1 if they are equal).
+func stringSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	bigrams := make(map[string]int, len(a)-1)
+	for i := 0; i < len(a)-1; i++ {
+		bigrams[a[i:i+2]]++
+	}
+	common := 0
+	for i := 0; i < len(b)-1; i++ {
+		if n := bigrams[b[i:i+2]]; n > 0 {
+			bigrams[b[i:i+2]] = n - 1
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)-1+len(b)-1)
+}
+
+type defRenamesByFrom []*DefRename
+
+func (v defRenamesByFrom) Len() int      { return len(v) }
+func (v defRenamesByFrom) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v defRenamesByFrom) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ak := []string{a.Repo, a.FromCommitID, a.ToCommitID, a.FromUnitType, a.FromUnit, a.FromPath}
+	bk := []string{b.Repo, b.FromCommitID, b.ToCommitID, b.FromUnitType, b.FromUnit, b.FromPath}
+	for i := range ak {
+		if ak[i] != bk[i] {
+			return ak[i] < bk[i]
+		}
+	}
+	return false
+}
+
+func (s *fsMultiRepoStore) DefRenames(repo, fromCommitID, toCommitID string) ([]*DefRename, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("DefRenames: repo: empty")
+	}
+	renames, err := s.openRepoStore(repo).(DefRenameStore).DefRenames(repo, fromCommitID, toCommitID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range renames {
+		r.Repo = repo
+	}
+	return renames, nil
+}
+
+func (s *fsMultiRepoStore) ImportDefRenames(repo, fromCommitID, toCommitID string, renames []*DefRename) error {
+	if repo == "" {
+		return fmt.Errorf("ImportDefRenames: repo: empty")
+	}
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	subpath := s.fs.Join(s.RepoToPath(repo)...)
+	if err := rwvfs.MkdirAll(s.fs, subpath); err != nil {
+		return err
+	}
+	return s.openRepoStore(repo).(DefRenameImporter).ImportDefRenames(repo, fromCommitID, toCommitID, renames)
+}
+
+// defRenamesFileSuffix is the suffix of the files (in the top-level
+// dir of a fsRepoStore) that hold the renames between two commits.
+// The files are named "FROM..TO" + defRenamesFileSuffix.
+const defRenamesFileSuffix = ".renames.json"
+
+func (s *fsRepoStore) DefRenames(repo, fromCommitID, toCommitID string) ([]*DefRename, error) {
+	entries, err := s.fs.ReadDir(".")
+	if isOSOrVFSNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var renames []*DefRename
+	for _, e := range entries {
+		name := e.Name()
+		if e.Mode().IsDir() || !strings.HasSuffix(name, defRenamesFileSuffix) {
+			continue
+		}
+		commits := strings.SplitN(strings.TrimSuffix(name, defRenamesFileSuffix), "..", 2)
+		if len(commits) != 2 || (fromCommitID != "" && commits[0] != fromCommitID) || (toCommitID != "" && commits[1] != toCommitID) {
+			continue
+		}
+		rs, err := s.readDefRenames(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			r.FromCommitID, r.ToCommitID = commits[0], commits[1]
+		}
+		renames = append(renames, rs...)
+	}
+	sort.Sort(defRenamesByFrom(renames))
+	return renames, nil
+}
+
+func (s *fsRepoStore) readDefRenames(filename string) (renames []*DefRename, err error) {
+	f, err := s.fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	return renames, json.NewDecoder(f).Decode(&renames)
+}
+
+// ImportDefRenames implements DefRenameImporter. It invalidates the
+// repo-level indexes (the def history index uses renames).
+func (s *fsRepoStore) ImportDefRenames(repo, fromCommitID, toCommitID string, renames []*DefRename) (err error) {
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	renames = cleanDefRenamesForImport(renames)
+	f, err := s.fs.Create(fromCommitID + ".." + toCommitID + defRenamesFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	if err := json.NewEncoder(f).Encode(renames); err != nil {
+		return err
+	}
+	return s.invalidateIndexes()
+}
+
+func (s *memoryMultiRepoStore) DefRenames(repo, fromCommitID, toCommitID string) ([]*DefRename, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("DefRenames: repo: empty")
+	}
+	rs, present := s.repos[repo]
+	if !present {
+		return nil, nil
+	}
+	renames, err := rs.DefRenames(repo, fromCommitID, toCommitID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range renames {
+		r.Repo = repo
+	}
+	return renames, nil
+}
+
+func (s *memoryMultiRepoStore) ImportDefRenames(repo, fromCommitID, toCommitID string, renames []*DefRename) error {
+	rs, present := s.repos[repo]
+	if !present {
+		return fmt.Errorf("ImportDefRenames: repo %q not found", repo)
+	}
+	return rs.ImportDefRenames(repo, fromCommitID, toCommitID, renames)
+}
+
+func (s *memoryRepoStore) DefRenames(repo, fromCommitID, toCommitID string) ([]*DefRename, error) {
+	var renames []*DefRename
+	for commits, rs := range s.renames {
+		if (fromCommitID != "" && commits[0] != fromCommitID) || (toCommitID != "" && commits[1] != toCommitID) {
+			continue
+		}
+		for _, r := range rs {
+			r2 := *r
+			r2.FromCommitID, r2.ToCommitID = commits[0], commits[1]
+			renames = append(renames, &r2)
+		}
+	}
+	sort.Sort(defRenamesByFrom(renames))
+	return renames, nil
+}
+
+func (s *memoryRepoStore) ImportDefRenames(repo, fromCommitID, toCommitID string, renames []*DefRename) error {
+	if s.renames == nil {
+		s.renames = map[[2]string][]*DefRename{}
+	}
+	s.renames[[2]string{fromCommitID, toCommitID}] = cleanDefRenamesForImport(renames)
+	return nil
+}
+
+// cleanDefRenamesForImport returns copies of renames with the fields
+// that are implied by their location in the store cleared.
+func cleanDefRenamesForImport(renames []*DefRename) []*DefRename {
+	clean := make([]*DefRename, len(renames))
+	for i, r := range renames {
+		r2 := *r
+		r2.Repo, r2.FromCommitID, r2.ToCommitID = "", "", ""
+		clean[i] = &r2
+	}
+	return clean
+}
+
+var (
+	_ DefRenameStore    = (*fsMultiRepoStore)(nil)
+	_ DefRenameStore    = (*fsRepoStore)(nil)
+	_ DefRenameStore    = (*memoryMultiRepoStore)(nil)
+	_ DefRenameStore    = (*memoryRepoStore)(nil)
+	_ DefRenameImporter = (*fsMultiRepoStore)(nil)
+	_ DefRenameImporter = (*fsRepoStore)(nil)
+	_ DefRenameImporter = (*memoryMultiRepoStore)(nil)
+	_ DefRenameImporter = (*memoryRepoStore)(nil)
+)
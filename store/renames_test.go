@@ -0,0 +1,87 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestDetectDefRenames(t *testing.T) {
+	def := func(commitID, path, name, kind, data string) *graph.Def {
+		return &graph.Def{
+			DefKey: graph.DefKey{CommitID: commitID, UnitType: "t", Unit: "u", Path: path},
+			Name:   name,
+			Kind:   kind,
+			Data:   []byte(data),
+		}
+	}
+	from := []*graph.Def{
+		def("c1", "a/Foo", "Foo", "func", `func Foo(x int) error`),
+		def("c1", "a/Bar", "Bar", "func", `func Bar()`),
+		def("c1", "a/Same", "Same", "func", `func Same()`),
+		def("c1", "a/T", "T", "type", `type T struct{}`),
+	}
+	to := []*graph.Def{
+		def("c2", "b/Foo", "Foo", "func", `func Foo(x int) error`),   // moved
+		def("c2", "a/Bar2", "Bar2", "func", `func Bar2()`),           // renamed
+		def("c2", "a/Same", "Same", "func", `func Same()`),           // unchanged
+		def("c2", "a/Other", "Other", "type", `type Other struct{}`), // unrelated
+	}
+
+	renames := DetectDefRenames(from, to, DefaultRenameThreshold)
+	got := map[string]string{}
+	for _, r := range renames {
+		if r.FromCommitID != "c1" || r.ToCommitID != "c2" {
+			t.Errorf("got rename commits %s..%s, want c1..c2", r.FromCommitID, r.ToCommitID)
+		}
+		got[r.FromPath] = r.ToPath
+	}
+	want := map[string]string{"a/Foo": "b/Foo", "a/Bar": "a/Bar2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got renames %v, want %v", got, want)
+	}
+}
+
+func TestMemoryMultiRepoStore_DefRenames(t *testing.T) {
+	testMultiRepoStore_DefRenames(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_DefRenames(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_DefRenames(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_DefRenames(t *testing.T, mrs MultiRepoStoreImporter) {
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	for _, c := range []struct{ commitID, path string }{{"c1", "p"}, {"c2", "q"}} {
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: c.path}, Name: "x"}}}
+		if err := mrs.Import("r", c.commitID, u, data); err != nil {
+			t.Fatalf("%s: Import(%s): %s", mrs, c.commitID, err)
+		}
+	}
+
+	rename := &DefRename{FromUnitType: "t", FromUnit: "u", FromPath: "p", ToUnitType: "t", ToUnit: "u", ToPath: "q", Score: 1}
+	if err := mrs.(DefRenameImporter).ImportDefRenames("r", "c1", "c2", []*DefRename{rename}); err != nil {
+		t.Fatalf("%s: ImportDefRenames: %s", mrs, err)
+	}
+
+	renames, err := mrs.(DefRenameStore).DefRenames("r", "", "c2")
+	if err != nil {
+		t.Fatalf("%s: DefRenames: %s", mrs, err)
+	}
+	want := *rename
+	want.Repo, want.FromCommitID, want.ToCommitID = "r", "c1", "c2"
+	if len(renames) != 1 || !reflect.DeepEqual(*renames[0], want) {
+		t.Errorf("%s: DefRenames: got %+v, want [%+v]", mrs, renames, want)
+	}
+
+	evs, err := mrs.(DefHistoryStore).DefHistory(graph.DefKey{Repo: "r", UnitType: "t", Unit: "u", Path: "p"})
+	if err != nil {
+		t.Fatalf("%s: DefHistory: %s", mrs, err)
+	}
+	if len(evs) != 2 || evs[1].Change != DefRemoved || evs[1].RenamedTo == nil || evs[1].RenamedTo.Path != "q" {
+		t.Errorf("%s: DefHistory: got %+v, want removed event renamed to q", mrs, evs)
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// canonicalRepo returns the repo that repo is an alias of (see
+// FSMultiRepoStoreConf.RepoAliases), or repo itself if it is not an
+// alias.
+func (s *fsMultiRepoStore) canonicalRepo(repo string) string {
+	if canon, ok := s.RepoAliases[repo]; ok {
+		return canon
+	}
+	return repo
+}
+
+// repoAliasesOf returns the (sorted) aliases of the canonical repo.
+func (s *fsMultiRepoStore) repoAliasesOf(canon string) []string {
+	var aliases []string
+	for alias, c := range s.RepoAliases {
+		if c == canon {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// resolveRepoAliases returns a copy of filters (a typed slice of
+// filters, such as []DefFilter) in which the repos that filters refer
+// to are replaced by their canonical repos.
+func (s *fsMultiRepoStore) resolveRepoAliases(filters interface{}) interface{} {
+	if len(s.RepoAliases) == 0 {
+		return filters
+	}
+
+	sf := storeFilters(filters)
+	resolved := make([]interface{}, len(sf))
+	for i, f := range sf {
+		switch f := f.(type) {
+		case byReposFilter:
+			seen := make(map[string]struct{}, len(f))
+			var repos []string
+			for _, repo := range f {
+				repo = s.canonicalRepo(repo)
+				if _, ok := seen[repo]; !ok {
+					seen[repo] = struct{}{}
+					repos = append(repos, repo)
+				}
+			}
+			resolved[i] = byReposFilter(repos)
+
+		case byRepoCommitIDsFilter:
+			versions := make([]Version, len(f))
+			for j, v := range f {
+				v.Repo = s.canonicalRepo(v.Repo)
+				versions[j] = v
+			}
+			resolved[i] = byRepoCommitIDsFilter(versions)
+
+		case byUnitKeyFilter:
+			f.key.Repo = s.canonicalRepo(f.key.Repo)
+			resolved[i] = f
+
+		case byDefKeyFilter:
+			f.key.Repo = s.canonicalRepo(f.key.Repo)
+			resolved[i] = f
+
+		case *byRefDefFilter:
+			if f.def.DefRepo != "" && s.canonicalRepo(f.def.DefRepo) != f.def.DefRepo {
+				def := f.def
+				def.DefRepo = s.canonicalRepo(def.DefRepo)
+				resolved[i] = &byRefDefFilter{def: def}
+			} else {
+				resolved[i] = f
+			}
+
+		default:
+			resolved[i] = f
+		}
+	}
+	return toTypedFilterSlice(reflect.TypeOf(filters), resolved)
+}
+
+func (s *fsMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
+	return s.repos(s.resolveRepoAliases(f).([]RepoFilter)...)
+}
+
+func (s *fsMultiRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
+	return s.repoStores.Versions(s.resolveRepoAliases(f).([]VersionFilter)...)
+}
+
+func (s *fsMultiRepoStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
+	return s.repoStores.Units(s.resolveRepoAliases(f).([]UnitFilter)...)
+}
+
+func (s *fsMultiRepoStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	return s.repoStores.Defs(s.resolveRepoAliases(f).([]DefFilter)...)
+}
+
+func (s *fsMultiRepoStore) Deps(f ...DepFilter) ([]*dep.ResolvedDep, error) {
+	return s.repoStores.Deps(s.resolveRepoAliases(f).([]DepFilter)...)
+}
+
+func (s *fsMultiRepoStore) Calls(f ...CallFilter) ([]*graph.Call, error) {
+	return s.repoStores.Calls(s.resolveRepoAliases(f).([]CallFilter)...)
+}
+
+func (s *fsMultiRepoStore) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	return s.repoStores.Relations(s.resolveRepoAliases(f).([]RelationFilter)...)
+}
+
+// Refs implements UnitStore. Refs to defs in an aliased repo are
+// stored with the canonical repo as their DefRepo (see Import), but
+// refs imported before the alias was configured still refer to the
+// alias, so ByRefDef lookups also query for refs to each alias of the
+// def's repo.
+func (s *fsMultiRepoStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	f = s.resolveRepoAliases(f).([]RefFilter)
+	if scope, err := s.globalRefsScope(f); err != nil {
+		return nil, err
+	} else if scope != nil {
+		f = append(f, scope)
+	}
+
+	refDefIdx := -1
+	var aliases []string
+	for i, ff := range f {
+		if rd, ok := ff.(*byRefDefFilter); ok && rd.def.DefRepo != "" {
+			if aliases = s.repoAliasesOf(rd.def.DefRepo); len(aliases) > 0 {
+				refDefIdx = i
+				break
+			}
+		}
+	}
+	if refDefIdx == -1 {
+		return s.repoStores.Refs(f...)
+	}
+
+	refs, err := s.repoStores.Refs(f...)
+	if err != nil {
+		return nil, err
+	}
+	canon := f[refDefIdx].(*byRefDefFilter).def
+	for _, alias := range aliases {
+		def := canon
+		def.DefRepo = alias
+		af := make([]RefFilter, len(f))
+		copy(af, f)
+		af[refDefIdx] = &byRefDefFilter{def: def}
+		aliasRefs, err := s.repoStores.Refs(af...)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range aliasRefs {
+			ref.DefRepo = canon.DefRepo
+		}
+		refs = append(refs, aliasRefs...)
+	}
+	sortRefs(refs, f)
+	return refs, nil
+}
+
+// canonicalizeRepoRefs replaces aliased repos in the references to
+// other repos in data (refs' DefRepos and calls' CalleeRepos) with
+// their canonical repos.
+func (s *fsMultiRepoStore) canonicalizeRepoRefs(data *graph.Output) {
+	if len(s.RepoAliases) == 0 {
+		return
+	}
+	for _, ref := range data.Refs {
+		ref.DefRepo = s.canonicalRepo(ref.DefRepo)
+	}
+	for _, call := range data.Calls {
+		call.CalleeRepo = s.canonicalRepo(call.CalleeRepo)
+	}
+}
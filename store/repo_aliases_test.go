@@ -0,0 +1,72 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFSMultiRepoStore_RepoAliases(t *testing.T) {
+	useIndexedStore = false
+	const (
+		canon = "github.com/org/x"
+		alias = "internal.git.corp/x"
+	)
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, &FSMultiRepoStoreConf{RepoAliases: map[string]string{alias: canon}})
+
+	u := &unit.SourceUnit{Type: "t", Name: "u"}
+	if err := mrs.Import(alias, "c", u, graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f"}}}); err != nil {
+		t.Fatal(err)
+	}
+	ref := func(defRepo string) *graph.Ref {
+		return &graph.Ref{DefRepo: defRepo, DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "f", Start: 1, End: 2}
+	}
+	if err := mrs.Import("a", "c", u, graph.Output{Refs: []*graph.Ref{ref(alias)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refs imported before the alias was configured refer to the
+	// alias.
+	plain := NewFSMultiRepoStore(fs, nil)
+	if err := plain.Import("b", "c", u, graph.Output{Refs: []*graph.Ref{ref(alias)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := mrs.Repos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", canon}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("got repos %v, want %v", repos, want)
+	}
+
+	for _, repo := range []string{alias, canon} {
+		defs, err := mrs.Defs(ByRepos(repo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(defs) != 1 || defs[0].Repo != canon {
+			t.Errorf("ByRepos(%s): got defs %v, want 1 def in %s", repo, defs, canon)
+		}
+	}
+
+	for _, defRepo := range []string{alias, canon} {
+		refs, err := mrs.Refs(ByRefDef(graph.RefDefKey{DefRepo: defRepo, DefUnitType: "t", DefUnit: "u", DefPath: "p"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, ref := range refs {
+			if ref.DefRepo != canon {
+				t.Errorf("ByRefDef(%s): got ref.DefRepo %q, want %q", defRepo, ref.DefRepo, canon)
+			}
+			got = append(got, ref.Repo)
+		}
+		if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("ByRefDef(%s): got refs in repos %v, want %v", defRepo, got, want)
+		}
+	}
+}
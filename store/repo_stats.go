@@ -0,0 +1,104 @@
+package store
+
+import "time"
+
+// RepoStats summarizes the data that a MultiRepoStore holds for a
+// repository.
+type RepoStats struct {
+	// Repo is the repository URI.
+	Repo string
+
+	// Commits is the number of commits imported for the repository.
+	Commits int
+
+	// LatestCommitID is the ID of the most recently imported
+	// commit. If import times are not known, it is the
+	// lexicographically greatest commit ID.
+	LatestCommitID string `json:",omitempty"`
+
+	// LastImported is when the latest commit was imported, if known.
+	LastImported *time.Time `json:",omitempty"`
+
+	// Units, Defs, and Refs are the number of source units, defs,
+	// and refs at the latest commit. They are only computed if
+	// requested (because counting defs and refs requires a full scan
+	// of the commit's data).
+	Units int `json:",omitempty"`
+	Defs  int `json:",omitempty"`
+	Refs  int `json:",omitempty"`
+}
+
+// versionImportTimer is implemented by RepoStores that know when
+// each version was imported.
+type versionImportTimer interface {
+	// importTime returns when data for commitID was last imported,
+	// or the zero time if it is not known.
+	importTime(commitID string) time.Time
+}
+
+// GetRepoStats computes stats for repo in mrs. If counts is true,
+// the number of units, defs, and refs at the latest commit are also
+// computed (which is slow for large repositories).
+func GetRepoStats(mrs MultiRepoStore, repo string, counts bool) (*RepoStats, error) {
+	stats := &RepoStats{Repo: repo}
+
+	versions, err := mrs.Versions(ByRepos(repo))
+	if err != nil {
+		return nil, err
+	}
+	stats.Commits = len(versions)
+	if len(versions) == 0 {
+		return stats, nil
+	}
+
+	var timer versionImportTimer
+	if o, ok := mrs.(repoStoreOpener); ok {
+		timer, _ = o.openRepoStore(repo).(versionImportTimer)
+	}
+	var latest time.Time
+	for _, v := range versions {
+		var t time.Time
+		if timer != nil {
+			t = timer.importTime(v.CommitID)
+		}
+		if stats.LatestCommitID == "" || t.After(latest) || (t.Equal(latest) && v.CommitID > stats.LatestCommitID) {
+			stats.LatestCommitID = v.CommitID
+			latest = t
+		}
+	}
+	if !latest.IsZero() {
+		stats.LastImported = &latest
+	}
+
+	if counts {
+		version := ByRepoCommitIDs(Version{Repo: repo, CommitID: stats.LatestCommitID})
+		units, err := mrs.Units(version, Unordered())
+		if err != nil {
+			return nil, err
+		}
+		stats.Units = len(units)
+		defs, err := mrs.Defs(version, Unordered())
+		if err != nil {
+			return nil, err
+		}
+		stats.Defs = len(defs)
+		refs, err := mrs.Refs(version, Unordered())
+		if err != nil {
+			return nil, err
+		}
+		stats.Refs = len(refs)
+	}
+	return stats, nil
+}
+
+func (s *fsRepoStore) importTime(commitID string) time.Time {
+	fi, err := s.fs.Stat(commitID)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func (s *memoryRepoStore) importTime(commitID string) time.Time {
+	return s.imported[commitID]
+}
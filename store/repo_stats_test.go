@@ -0,0 +1,41 @@
+package store
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestGetRepoStats(t *testing.T) {
+	mrs := newMemoryMultiRepoStore()
+	for _, commitID := range []string{"c1", "c2"} {
+		for _, name := range []string{"u1", "u2"} {
+			u := &unit.SourceUnit{Type: "t", Name: name}
+			data := graph.Output{
+				Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}}},
+				Refs: []*graph.Ref{{DefPath: "p", File: "f"}, {DefPath: "q", File: "f"}},
+			}
+			if err := mrs.Import("r", commitID, u, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stats, err := GetRepoStats(mrs, "r", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Commits != 2 {
+		t.Errorf("got Commits == %d, want 2", stats.Commits)
+	}
+	if stats.LatestCommitID != "c2" {
+		t.Errorf("got LatestCommitID == %q, want c2", stats.LatestCommitID)
+	}
+	if stats.LastImported == nil {
+		t.Error("got LastImported == nil, want non-nil")
+	}
+	if stats.Units != 2 || stats.Defs != 2 || stats.Refs != 4 {
+		t.Errorf("got Units/Defs/Refs == %d/%d/%d, want 2/2/4", stats.Units, stats.Defs, stats.Refs)
+	}
+}
@@ -17,7 +17,8 @@ type RepoStore interface {
 
 	// TreeStore's methods call the corresponding methods on the
 	// TreeStore of each version contained within this repository. The
-	// combined results are returned (in undefined order).
+	// combined results are sorted by key (unless an Unordered filter
+	// is given).
 	TreeStore
 }
 
@@ -100,6 +101,7 @@ func (s repoStores) Versions(f ...VersionFilter) ([]*Version, error) {
 		}
 		allVersions = append(allVersions, versions...)
 	}
+	sortVersions(allVersions, f)
 	return allVersions, nil
 }
 
@@ -134,7 +136,8 @@ func (s repoStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 			return nil
 		})
 	}
-	err = par.Wait()
+	err = getQueryControls(f).err(par.Wait())
+	sortUnits(allUnits, f)
 	return allUnits, err
 }
 
@@ -169,7 +172,8 @@ func (s repoStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
 			return nil
 		})
 	}
-	err = par.Wait()
+	err = getQueryControls(f).err(par.Wait())
+	sortDefs(allDefs, f)
 	return allDefs, err
 }
 
@@ -198,5 +202,6 @@ func (s repoStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 		}
 		allRefs = append(allRefs, refs...)
 	}
+	sortRefs(allRefs, f)
 	return allRefs, nil
 }
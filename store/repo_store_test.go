@@ -262,8 +262,7 @@ func testRepoStore_Defs_ByCommitIDs_ByFile(t *testing.T, rs RepoStoreImporter) {
 		{DefKey: graph.DefKey{CommitID: "c2", UnitType: "t", Unit: "u", Path: "p1"}, File: "f1"},
 	}
 
-	c_unitFilesIndex_getByPath = 0
-	c_defFilesIndex_getByPath = 0
+	c_defFilesTreeIndex_getByPath = 0
 	defs, err := rs.Defs(ByCommitIDs("c2"), ByFiles("f1"))
 	if err != nil {
 		t.Fatalf("%s: Defs: %s", rs, err)
@@ -272,11 +271,8 @@ func testRepoStore_Defs_ByCommitIDs_ByFile(t *testing.T, rs RepoStoreImporter) {
 		t.Errorf("%s: Defs: got defs %v, want %v", rs, defs, want)
 	}
 	if isIndexedStore(rs) {
-		if want := 1; c_unitFilesIndex_getByPath != want {
-			t.Errorf("%s: Defs: got %d unitFilesIndex hits, want %d", rs, c_unitFilesIndex_getByPath, want)
-		}
-		if want := 1; c_defFilesIndex_getByPath != want {
-			t.Errorf("%s: Defs: got %d defFilesIndex hits, want %d", rs, c_defFilesIndex_getByPath, want)
+		if want := 1; c_defFilesTreeIndex_getByPath != want {
+			t.Errorf("%s: Defs: got %d defFilesTreeIndex hits, want %d", rs, c_defFilesTreeIndex_getByPath, want)
 		}
 	}
 }
@@ -65,13 +65,16 @@ func openRepoStores(o repoStoreOpener, filters interface{}) (map[string]RepoStor
 	}
 
 	if repos == nil {
-		return o.openAllRepoStores()
+		rss, err := o.openAllRepoStores()
+		getQueryControls(filters).stats.reposScanned(len(rss))
+		return rss, err
 	}
 
 	rss := make(map[string]RepoStore, len(repos))
 	for _, repo := range repos {
 		rss[repo] = o.openRepoStore(repo)
 	}
+	getQueryControls(filters).stats.reposScanned(len(rss))
 	return rss, nil
 }
 
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// Sample returns a filter that selects a uniform random sample of the
+// defs or refs that match the query's other filters: each one is
+// selected independently with probability rate (0 < rate <= 1). The
+// number of defs or refs that match the other filters can be
+// estimated from the size of the sample with EstimateTotal.
+//
+// When the store reads defs or refs at offsets that it found in an
+// index, it only reads the sampled offsets, so the query reads about
+// rate as much data. Otherwise (e.g., when there is no index that
+// covers the query's filters) every def or ref must still be read, and
+// sampling only reduces the number of results.
+//
+// The sample is drawn from a pseudo-random source seeded with seed.
+// Because source units are read in parallel, the same seed does not
+// always produce the same sample.
+//
+// Sample should come before a Limit filter in a query's filters, so
+// that the limit applies to the sample.
+func Sample(rate float64, seed int64) interface {
+	DefFilter
+	RefFilter
+} {
+	if rate <= 0 || rate > 1 {
+		panic(fmt.Sprintf("Sample: rate %v is not in (0, 1]", rate))
+	}
+	return &sampler{rate: rate, seed: seed, rnd: rand.New(rand.NewSource(seed))}
+}
+
+type sampler struct {
+	rate float64
+	seed int64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *sampler) String() string { return fmt.Sprintf("Sample(%v seed %d)", s.rate, s.seed) }
+
+func (s *sampler) SelectDef(*graph.Def) bool { return s.sample() }
+func (s *sampler) SelectRef(*graph.Ref) bool { return s.sample() }
+
+// sample returns whether to select an item.
+func (s *sampler) sample() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < s.rate
+}
+
+// getSampler returns the sampler in filters, or nil if there is none.
+func getSampler(filters interface{}) *sampler {
+	for _, f := range storeFilters(filters) {
+		if s, ok := f.(*sampler); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// sampleOffsets returns the sample of ofs that is chosen by the
+// sampler in filters. If there is no sampler, ofs is returned. The
+// sampler must not also be applied to the items at the sampled
+// offsets (see defFiltersWithoutSampler and refFiltersWithoutSampler).
+func sampleOffsets(ofs byteOffsets, filters interface{}) byteOffsets {
+	s := getSampler(filters)
+	if s == nil {
+		return ofs
+	}
+	sampled := make(byteOffsets, 0, int(float64(len(ofs))*s.rate)+1)
+	for _, o := range ofs {
+		if s.sample() {
+			sampled = append(sampled, o)
+		}
+	}
+	return sampled
+}
+
+// defFiltersWithoutSampler returns the filters in fs other than the
+// sampler (for use after sampleOffsets).
+func defFiltersWithoutSampler(fs []DefFilter) defFilters {
+	fs2 := make(defFilters, 0, len(fs))
+	for _, f := range fs {
+		if _, ok := f.(*sampler); !ok {
+			fs2 = append(fs2, f)
+		}
+	}
+	return fs2
+}
+
+// refFiltersWithoutSampler is like defFiltersWithoutSampler, but for
+// ref filters.
+func refFiltersWithoutSampler(fs []RefFilter) refFilters {
+	fs2 := make(refFilters, 0, len(fs))
+	for _, f := range fs {
+		if _, ok := f.(*sampler); !ok {
+			fs2 = append(fs2, f)
+		}
+	}
+	return fs2
+}
+
+// EstimateTotal returns an estimate of the number of items that match
+// a query from the size n of a sample of its results that was
+// selected by Sample(rate, ...).
+func EstimateTotal(n int, rate float64) int {
+	return int(float64(n)/rate + 0.5)
+}
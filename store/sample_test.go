@@ -0,0 +1,84 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestSample(t *testing.T) {
+	const n = 1000
+	for _, indexed := range []bool{false, true} {
+		useIndexedStore = indexed
+		mrs := NewFSMultiRepoStore(newTestFS(), nil)
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+		var data graph.Output
+		for i := 0; i < n; i++ {
+			path := fmt.Sprintf("p%d", i)
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}, Name: path, File: "f"})
+			data.Refs = append(data.Refs, &graph.Ref{DefPath: path, File: "f", Start: uint32(i), End: uint32(i + 1)})
+		}
+		if err := mrs.Import("r", "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+		if indexed {
+			if err := mrs.(MultiRepoIndexer).Index("r", "c"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The sample sizes are binomially distributed with a standard
+		// deviation of about 9.5, so these bounds are very unlikely to
+		// be exceeded.
+		const rate, min, max = 0.1, 50, 150
+
+		defs, err := mrs.Defs(ByRepos("r"), ByFiles("f"), Sample(rate, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(defs) < min || len(defs) > max {
+			t.Errorf("indexed=%v: got a sample of %d defs, want about %d", indexed, len(defs), n/10)
+		}
+		if est := EstimateTotal(len(defs), rate); est != len(defs)*10 {
+			t.Errorf("indexed=%v: got estimated total %d, want %d", indexed, est, len(defs)*10)
+		}
+
+		refs, err := mrs.Refs(ByRepos("r"), ByFiles("f"), Sample(rate, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) < min || len(refs) > max {
+			t.Errorf("indexed=%v: got a sample of %d refs, want about %d", indexed, len(refs), n/10)
+		}
+
+		// A rate of 1 selects everything.
+		defs, err = mrs.Defs(ByRepos("r"), Sample(1, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(defs) != n {
+			t.Errorf("indexed=%v: got %d defs with rate 1, want %d", indexed, len(defs), n)
+		}
+	}
+}
+
+func TestSampleOffsets(t *testing.T) {
+	ofs := make(byteOffsets, 1000)
+	for i := range ofs {
+		ofs[i] = int64(i)
+	}
+	if got := sampleOffsets(ofs, []DefFilter{ByFiles("f")}); len(got) != len(ofs) {
+		t.Errorf("got %d offsets without a sampler, want all %d", len(got), len(ofs))
+	}
+
+	fs := []DefFilter{ByFiles("f"), Sample(0.1, 1)}
+	sampled := sampleOffsets(ofs, fs)
+	if len(sampled) < 50 || len(sampled) > 150 {
+		t.Errorf("got %d sampled offsets, want about 100", len(sampled))
+	}
+	if ffs := defFiltersWithoutSampler(fs); len(ffs) != 1 {
+		t.Errorf("got filters %v, want the sampler removed", ffs)
+	}
+}
@@ -0,0 +1,432 @@
+package store
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"code.google.com/p/rog-go/parallel"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A shardedMultiRepoStore is a MultiRepoStore that partitions repos
+// across several FS-backed multi-repo stores (shards), each with its
+// own root (such as a different S3 bucket or disk). Each repo is
+// stored in exactly one shard, chosen by hashing its (canonical) URI,
+// and the shards share nothing. Queries are sent to all of the shards
+// that may contain matching repos (only the shards of the repos in a
+// ByRepos filter, if there is one) in parallel, and their results are
+// merged.
+type shardedMultiRepoStore struct {
+	shards []*fsMultiRepoStore
+}
+
+var (
+	_ MultiRepoStoreImporter = (*shardedMultiRepoStore)(nil)
+	_ MultiRepoIndexer       = (*shardedMultiRepoStore)(nil)
+	_ GlobalRefStore         = (*shardedMultiRepoStore)(nil)
+	_ repoStoreOpener        = (*shardedMultiRepoStore)(nil)
+)
+
+// NewShardedMultiRepoStore creates a new multi-repo store (that can be
+// imported into) that partitions repos across the filesystems in
+// fss, one shard per filesystem. All shards use the same conf (see
+// NewFSMultiRepoStore).
+//
+// A repo's shard depends on the number and order of the filesystems,
+// so they must not change after data has been imported (unless the
+// data is reimported).
+func NewShardedMultiRepoStore(fss []rwvfs.WalkableFileSystem, conf *FSMultiRepoStoreConf) MultiRepoStoreImporter {
+	if len(fss) == 0 {
+		panic("NewShardedMultiRepoStore: no shards")
+	}
+	s := &shardedMultiRepoStore{shards: make([]*fsMultiRepoStore, len(fss))}
+	for i, fs := range fss {
+		s.shards[i] = NewFSMultiRepoStore(fs, conf).(*fsMultiRepoStore)
+	}
+	return s
+}
+
+// shardIndex returns the index of the shard that stores repo.
+func (s *shardedMultiRepoStore) shardIndex(repo string) int {
+	h := fnv.New32a()
+	h.Write([]byte(s.shards[0].canonicalRepo(repo)))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// ignoreShardNotExist returns nil if err reports that a shard (or its
+// data) does not exist, and err otherwise.
+func ignoreShardNotExist(err error) error {
+	if isStoreNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// shard returns the shard that stores repo.
+func (s *shardedMultiRepoStore) shard(repo string) *fsMultiRepoStore {
+	return s.shards[s.shardIndex(repo)]
+}
+
+// scatter calls f on each shard that may contain repos that match
+// filters, in parallel, and returns the first error. A shard that has
+// never been written to (and so doesn't exist) has no data, so its
+// not-exist errors are ignored, unless all of the shards return one
+// (as an uninitialized single store would).
+func (s *shardedMultiRepoStore) scatter(filters interface{}, f func(shard *fsMultiRepoStore) error) error {
+	repos, err := scopeRepos(storeFilters(s.shards[0].resolveRepoAliases(filters)))
+	if err != nil {
+		return err
+	}
+
+	shards := s.shards
+	if repos != nil {
+		scoped := make([]bool, len(s.shards))
+		for _, repo := range repos {
+			scoped[s.shardIndex(repo)] = true
+		}
+		shards = nil
+		for i, shard := range s.shards {
+			if scoped[i] {
+				shards = append(shards, shard)
+			}
+		}
+	}
+
+	var (
+		notExistErr error
+		numNotExist int
+		mu          sync.Mutex
+	)
+	par := parallel.NewRun(len(s.shards))
+	for _, shard_ := range shards {
+		shard := shard_
+		par.Do(func() error {
+			err := f(shard)
+			if isStoreNotExist(err) {
+				mu.Lock()
+				notExistErr = err
+				numNotExist++
+				mu.Unlock()
+				return nil
+			}
+			return err
+		})
+	}
+	if err := par.Wait(); err != nil {
+		return err
+	}
+	if len(shards) > 0 && numNotExist == len(shards) {
+		return notExistErr
+	}
+	return nil
+}
+
+func (s *shardedMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
+	var (
+		allRepos = []string{}
+		mu       sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		repos, err := shard.Repos(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allRepos = append(allRepos, repos...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortRepos(allRepos, f)
+	return allRepos, nil
+}
+
+func (s *shardedMultiRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
+	var (
+		allVersions []*Version
+		mu          sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		versions, err := shard.Versions(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allVersions = append(allVersions, versions...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(allVersions, f)
+	return allVersions, nil
+}
+
+func (s *shardedMultiRepoStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
+	var (
+		allUnits []*unit.SourceUnit
+		mu       sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		units, err := shard.Units(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allUnits = append(allUnits, units...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortUnits(allUnits, f)
+	return allUnits, nil
+}
+
+func (s *shardedMultiRepoStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	var (
+		allDefs []*graph.Def
+		mu      sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		defs, err := shard.Defs(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allDefs = append(allDefs, defs...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortDefs(allDefs, f)
+	return allDefs, nil
+}
+
+// Refs implements UnitStore. Each shard's global ref index only
+// contains the refs in that shard's repos, so queries for the refs to
+// a def (ByRefDef) are sent to all shards.
+func (s *shardedMultiRepoStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	var (
+		allRefs []*graph.Ref
+		mu      sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		refs, err := shard.Refs(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allRefs = append(allRefs, refs...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortRefs(allRefs, f)
+	return allRefs, nil
+}
+
+func (s *shardedMultiRepoStore) Deps(f ...DepFilter) ([]*dep.ResolvedDep, error) {
+	var (
+		allDeps []*dep.ResolvedDep
+		mu      sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		deps, err := shard.Deps(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allDeps = append(allDeps, deps...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortDeps(allDeps, f)
+	return allDeps, nil
+}
+
+func (s *shardedMultiRepoStore) Calls(f ...CallFilter) ([]*graph.Call, error) {
+	var (
+		allCalls []*graph.Call
+		mu       sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		calls, err := shard.Calls(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allCalls = append(allCalls, calls...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortCalls(allCalls, f)
+	return allCalls, nil
+}
+
+func (s *shardedMultiRepoStore) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	var (
+		allRels []*graph.Relation
+		mu      sync.Mutex
+	)
+	err := s.scatter(f, func(shard *fsMultiRepoStore) error {
+		rels, err := shard.Relations(f...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		allRels = append(allRels, rels...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortRelations(allRels, f)
+	return allRels, nil
+}
+
+func (s *shardedMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUnit, data graph.Output) error {
+	return s.shard(repo).Import(repo, commitID, unit, data)
+}
+
+func (s *shardedMultiRepoStore) Index(repo, commitID string) error {
+	return s.shard(repo).Index(repo, commitID)
+}
+
+// RefLocations implements GlobalRefStore. The refs from within the
+// def's repo are read from its shard, and the refs from other repos
+// are read from the global ref index of each shard.
+func (s *shardedMultiRepoStore) RefLocations(def graph.RefDefKey) ([]*RefLocation, error) {
+	if def.DefRepo == "" {
+		return nil, fmt.Errorf("RefLocations: def.DefRepo: empty")
+	}
+	def.DefRepo = s.shards[0].canonicalRepo(def.DefRepo)
+	defShard := s.shard(def.DefRepo)
+
+	var (
+		allLocs []*RefLocation
+		mu      sync.Mutex
+	)
+	par := parallel.NewRun(len(s.shards))
+	for _, shard_ := range s.shards {
+		shard := shard_
+		par.Do(func() error {
+			var locs []*RefLocation
+			var err error
+			if shard == defShard {
+				locs, err = shard.RefLocations(def)
+			} else {
+				locs, err = shard.globalRefLocations(def)
+			}
+			if err != nil {
+				return ignoreShardNotExist(err)
+			}
+			mu.Lock()
+			allLocs = append(allLocs, locs...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+	sort.Sort(refLocationsByKey(allLocs))
+	return allLocs, nil
+}
+
+// CrossRepoRefCounts implements GlobalRefStore. Each repo is stored in
+// only one shard, so the counts of the shards are summed.
+func (s *shardedMultiRepoStore) CrossRepoRefCounts(defRepo string) ([]*DefRefCount, error) {
+	type defKey struct{ unitType, unit, path string }
+	var (
+		counts = map[defKey]*DefRefCount{}
+		mu     sync.Mutex
+	)
+	par := parallel.NewRun(len(s.shards))
+	for _, shard_ := range s.shards {
+		shard := shard_
+		par.Do(func() error {
+			shardCounts, err := shard.CrossRepoRefCounts(defRepo)
+			if err != nil {
+				return ignoreShardNotExist(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, c := range shardCounts {
+				k := defKey{c.UnitType, c.Unit, c.Path}
+				if c0, present := counts[k]; present {
+					c0.CrossRepoRefs += c.CrossRepoRefs
+					c0.CrossRepos += c.CrossRepos
+				} else {
+					counts[k] = c
+				}
+			}
+			return nil
+		})
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+	all := make([]*DefRefCount, 0, len(counts))
+	for _, c := range counts {
+		all = append(all, c)
+	}
+	sort.Sort(defRefCountsByDef(all))
+	return all, nil
+}
+
+func (s *shardedMultiRepoStore) openRepoStore(repo string) RepoStore {
+	return s.shard(repo).openRepoStore(repo)
+}
+
+func (s *shardedMultiRepoStore) openAllRepoStores() (map[string]RepoStore, error) {
+	var (
+		all = map[string]RepoStore{}
+		mu  sync.Mutex
+	)
+	par := parallel.NewRun(len(s.shards))
+	for _, shard_ := range s.shards {
+		shard := shard_
+		par.Do(func() error {
+			rss, err := shard.openAllRepoStores()
+			if err != nil {
+				return ignoreShardNotExist(err)
+			}
+			mu.Lock()
+			for repo, rs := range rss {
+				all[repo] = rs
+			}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *shardedMultiRepoStore) String() string {
+	return fmt.Sprintf("shardedMultiRepoStore(%d shards)", len(s.shards))
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func newTestShardedMultiRepoStore(shards int) MultiRepoStoreImporter {
+	fss := make([]rwvfs.WalkableFileSystem, shards)
+	for i := range fss {
+		fss[i] = newTestFS()
+	}
+	return NewShardedMultiRepoStore(fss, nil)
+}
+
+func TestShardedMultiRepoStore(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore(t, func() MultiRepoStoreImporter {
+		return newTestShardedMultiRepoStore(3)
+	})
+}
+
+func TestIndexedShardedMultiRepoStore(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore(t, func() MultiRepoStoreImporter {
+		return newTestShardedMultiRepoStore(3)
+	})
+}
+
+func TestShardedMultiRepoStore_RefLocations(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_RefLocations(t, newTestShardedMultiRepoStore(3))
+}
+
+func TestShardedMultiRepoStore_placement(t *testing.T) {
+	useIndexedStore = true
+	mrs := newTestShardedMultiRepoStore(3).(*shardedMultiRepoStore)
+	for i := 0; i < 10; i++ {
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"}}}
+		if err := mrs.Import(fmt.Sprintf("r%d", i), "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each repo is stored only in its own shard.
+	nonEmpty := 0
+	for i, shard := range mrs.shards {
+		repos, err := shard.Repos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(repos) > 0 {
+			nonEmpty++
+		}
+		for _, repo := range repos {
+			if want := mrs.shardIndex(repo); i != want {
+				t.Errorf("repo %s is stored in shard %d, want shard %d", repo, i, want)
+			}
+		}
+	}
+	if nonEmpty < 2 {
+		t.Errorf("got %d non-empty shards, want the repos to be spread across shards", nonEmpty)
+	}
+
+	// Queries scoped to a repo only read its shard.
+	defs, err := mrs.Defs(ByRepos("r3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Repo != "r3" {
+		t.Errorf("got defs %v, want the def in r3", defs)
+	}
+
+	xs, err := Indexes(mrs, IndexCriteria{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := map[string]struct{}{}
+	for _, x := range xs {
+		repos[x.Repo] = struct{}{}
+	}
+	if len(repos) != 10 {
+		t.Errorf("got indexes for %d repos, want 10", len(repos))
+	}
+}
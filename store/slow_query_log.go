@@ -0,0 +1,209 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A SlowQueryLog records the defs and refs queries that take longer
+// than a threshold, so that operators can find the query shapes that
+// are run often and are slow (and add indexes or narrower filters for
+// them). Queries are only logged when they are made through a store
+// returned by SlowQueryLogUnitStore.
+//
+// Each slow query is written as a line of JSON (a SlowQuery).
+type SlowQueryLog struct {
+	// Threshold is the min duration of a query that is logged.
+	Threshold time.Duration
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewSlowQueryLog creates a slow query log that writes the queries
+// that take at least threshold to w.
+func NewSlowQueryLog(w io.Writer, threshold time.Duration) *SlowQueryLog {
+	return &SlowQueryLog{Threshold: threshold, w: w}
+}
+
+// A SlowQuery is an entry in a SlowQueryLog.
+type SlowQuery struct {
+	Time time.Time // when the query started
+	Kind string    // "Defs" or "Refs"
+
+	// Shape is the kind of query and the sorted names of the types of
+	// its filters (without their values), such as "Defs ByFiles
+	// ByRepos ByUnits". Queries with the same shape are executed the
+	// same way (e.g., with the same indexes), so they are usually
+	// optimized together. Fingerprint is a short hash of Shape.
+	Shape       string
+	Fingerprint string
+
+	// Filters are the query's filters (with their values).
+	Filters []string
+
+	Duration time.Duration
+
+	// ReposScanned and UnitsScanned are the number of repos and
+	// source units whose data the query read, and BytesScanned is the
+	// number of bytes of def and ref data that it decoded (including
+	// data that was rejected by filters).
+	ReposScanned, UnitsScanned int64
+	BytesScanned               int64
+
+	Results int    // number of results
+	Error   string `json:",omitempty"`
+}
+
+// record logs the query if it took at least the threshold.
+func (l *SlowQueryLog) record(kind string, filters interface{}, stats *queryStats, start time.Time, results int, err error) {
+	d := time.Since(start)
+	if d < l.Threshold {
+		return
+	}
+
+	shape, fingerprint, filterStrs := queryShape(kind, filters)
+	q := &SlowQuery{
+		Time:         start,
+		Kind:         kind,
+		Shape:        shape,
+		Fingerprint:  fingerprint,
+		Filters:      filterStrs,
+		Duration:     d,
+		ReposScanned: atomic.LoadInt64(&stats.repos),
+		UnitsScanned: atomic.LoadInt64(&stats.units),
+		BytesScanned: atomic.LoadInt64(&stats.bytes),
+		Results:      results,
+	}
+	if err != nil {
+		q.Error = err.Error()
+	}
+
+	data, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("Warning: encoding slow query log entry: %s.", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		log.Printf("Warning: writing slow query log entry: %s.", err)
+	}
+}
+
+// queryShape returns the shape and fingerprint (see SlowQuery) and the
+// filter strings of a query of the given kind. The query controls
+// (WithContext and WithQueryLimits) are omitted.
+func queryShape(kind string, filters interface{}) (shape, fingerprint string, filterStrs []string) {
+	seen := map[string]struct{}{}
+	var names []string
+	for _, f := range storeFilters(filters) {
+		if isQueryControl(f) {
+			continue
+		}
+		filterStrs = append(filterStrs, fmt.Sprint(f))
+		name := filterTypeName(f)
+		if _, seen := seen[name]; !seen {
+			names = append(names, name)
+		}
+		seen[name] = struct{}{}
+	}
+	sort.Strings(names)
+
+	shape = strings.Join(append([]string{kind}, names...), " ")
+	h := fnv.New64a()
+	io.WriteString(h, shape)
+	return shape, fmt.Sprintf("%016x", h.Sum64()), filterStrs
+}
+
+// filterTypeName returns the name of a filter's type, without its
+// package and a "Filter" suffix (e.g., "ByRepos" for the filter
+// returned by ByRepos).
+func filterTypeName(f interface{}) string {
+	t := reflect.TypeOf(f)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if name == "" {
+		return t.Kind().String()
+	}
+	name = strings.TrimSuffix(name, "Filter")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// queryStats is a filter that records how much data a query read (for
+// a SlowQueryLog). Like the other query controls (see queryControls),
+// it selects everything.
+type queryStats struct {
+	// repos, units, and bytes are the number of repos, source units,
+	// and bytes of data that the query read (accessed atomically).
+	repos, units, bytes int64
+}
+
+func (s *queryStats) String() string                   { return "QueryStats" }
+func (s *queryStats) SelectUnit(*unit.SourceUnit) bool { return true }
+func (s *queryStats) SelectDef(*graph.Def) bool        { return true }
+func (s *queryStats) SelectRef(*graph.Ref) bool        { return true }
+
+// reposScanned, unitScanned, and scanned record that the query read n
+// repos, a source unit, and n bytes of data, respectively. They may be
+// called on a nil *queryStats (for queries that aren't logged).
+func (s *queryStats) reposScanned(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.repos, int64(n))
+	}
+}
+
+func (s *queryStats) unitScanned() {
+	if s != nil {
+		atomic.AddInt64(&s.units, 1)
+	}
+}
+
+func (s *queryStats) scanned(n uint64) {
+	if s != nil {
+		atomic.AddInt64(&s.bytes, int64(n))
+	}
+}
+
+// SlowQueryLogUnitStore returns a UnitStore that logs the defs and
+// refs queries to s that are slower than l's threshold to l.
+func SlowQueryLogUnitStore(s UnitStore, l *SlowQueryLog) UnitStore {
+	return &slowQueryLogUnitStore{s: s, l: l}
+}
+
+type slowQueryLogUnitStore struct {
+	s UnitStore
+	l *SlowQueryLog
+}
+
+func (s *slowQueryLogUnitStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	stats := &queryStats{}
+	start := time.Now()
+	defs, err := s.s.Defs(append(append([]DefFilter{}, f...), stats)...)
+	s.l.record("Defs", f, stats, start, len(defs), err)
+	return defs, err
+}
+
+func (s *slowQueryLogUnitStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	stats := &queryStats{}
+	start := time.Now()
+	refs, err := s.s.Refs(append(append([]RefFilter{}, f...), stats)...)
+	s.l.record("Refs", f, stats, start, len(refs), err)
+	return refs, err
+}
+
+func (s *slowQueryLogUnitStore) String() string { return fmt.Sprint(s.s) }
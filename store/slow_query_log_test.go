@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	useIndexedStore = false
+	mrs := NewFSMultiRepoStore(newTestFS(), nil)
+	for _, repo := range []string{"r1", "r2"} {
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+		var data graph.Output
+		for i := 0; i < 10; i++ {
+			path := fmt.Sprintf("p%d", i)
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}, Name: path, File: "f"})
+			data.Refs = append(data.Refs, &graph.Ref{DefPath: path, File: "f", Start: uint32(i), End: uint32(i + 1)})
+		}
+		if err := mrs.Import(repo, "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	l := NewSlowQueryLog(&buf, 0)
+	s := SlowQueryLogUnitStore(mrs, l)
+
+	if _, err := s.Defs(ByRepos("r1"), ByFiles("f"), WithQueryLimits(QueryLimits{MaxResults: 100})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refs(ByRepos("r2"), ByFiles("f")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Defs(ByRepos("r2"), ByFiles("g")); err != nil {
+		t.Fatal(err)
+	}
+
+	var qs []*SlowQuery
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var q SlowQuery
+		if err := json.Unmarshal([]byte(line), &q); err != nil {
+			t.Fatal(err)
+		}
+		qs = append(qs, &q)
+	}
+	buf.Reset()
+	if len(qs) != 3 {
+		t.Fatalf("got %d slow queries, want 3", len(qs))
+	}
+
+	if want := "Defs ByFiles ByRepos"; qs[0].Shape != want {
+		t.Errorf("got shape %q, want %q", qs[0].Shape, want)
+	}
+	if qs[0].Fingerprint != qs[2].Fingerprint {
+		t.Errorf("got different fingerprints %q and %q for queries with the same shape", qs[0].Fingerprint, qs[2].Fingerprint)
+	}
+	if qs[0].Fingerprint == qs[1].Fingerprint {
+		t.Errorf("got the same fingerprint for defs and refs queries")
+	}
+	if len(qs[0].Filters) != 2 {
+		t.Errorf("got filters %q, want the query limits omitted", qs[0].Filters)
+	}
+	for i, q := range qs[:2] {
+		if q.ReposScanned != 1 || q.UnitsScanned != 1 || q.BytesScanned == 0 || q.Results != 10 {
+			t.Errorf("query %d: got %d repos and %d units (%d bytes) scanned and %d results, want 1 repo and 1 unit (> 0 bytes) scanned and 10 results", i, q.ReposScanned, q.UnitsScanned, q.BytesScanned, q.Results)
+		}
+	}
+
+	// Queries faster than the threshold are not logged.
+	l.Threshold = time.Hour
+	if _, err := s.Defs(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %q, want fast queries not logged", buf.String())
+	}
+}
@@ -0,0 +1,337 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// A Snapshot is a name (such as "release-1.4") for a commit of a
+// repository. Snapshot names can be used in place of commit IDs in
+// queries (in ByCommitIDs and ByRepoCommitIDs filters), so that
+// documentation sites and dashboards can query "the release graph"
+// without hard-coding its commit ID. Results still refer to the
+// commit by its ID.
+type Snapshot struct {
+	Repo      string `json:",omitempty"`
+	Name      string
+	CommitID  string
+	CreatedAt time.Time
+}
+
+// A SnapshotStore lists snapshots. It is implemented by the FS-backed
+// and in-memory stores at the MultiRepoStore and RepoStore levels.
+type SnapshotStore interface {
+	// Snapshots returns the snapshots of repo, sorted by name. The
+	// repo is only used by MultiRepoStores, which return the
+	// snapshots of all repos if it is empty.
+	Snapshots(repo string) ([]*Snapshot, error)
+}
+
+// A SnapshotCreator creates and deletes snapshots. The repo is only
+// used by MultiRepoStores.
+type SnapshotCreator interface {
+	// CreateSnapshot names the commit of repo, which must have been
+	// imported. If a snapshot with the same name exists, it is moved
+	// to the commit. The commitID may itself be a snapshot name.
+	CreateSnapshot(repo, name, commitID string) error
+
+	// DeleteSnapshot deletes the named snapshot of repo (but not the
+	// commit's data).
+	DeleteSnapshot(repo, name string) error
+}
+
+// snapshotNamePattern matches valid snapshot names. Names are used in
+// the same places as commit IDs (including in paths and URLs), so
+// they are restricted to a safe set of characters.
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// checkNewSnapshot returns an error if name is not a valid snapshot
+// name or is the ID of a commit in rs (which would make the commit
+// inaccessible by its ID), or if commitID is not a commit in rs.
+func checkNewSnapshot(rs RepoStore, name, commitID string) error {
+	if !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q (it must begin with a letter or digit and contain only letters, digits, '.', '-', and '_')", name)
+	}
+	versions, err := rs.Versions()
+	if err != nil && !isStoreNotExist(err) {
+		return err
+	}
+	found := false
+	for _, v := range versions {
+		if v.CommitID == name {
+			return fmt.Errorf("invalid snapshot name %q: it is the ID of an imported commit", name)
+		}
+		if v.CommitID == commitID {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("commit %q has not been imported", commitID)
+	}
+	return nil
+}
+
+// snapshotCommitID returns the commit ID that the snapshot name
+// refers to in snapshots, or name itself if there is no such
+// snapshot.
+func snapshotCommitID(snapshots []*Snapshot, name string) string {
+	for _, sn := range snapshots {
+		if sn.Name == name {
+			return sn.CommitID
+		}
+	}
+	return name
+}
+
+// putSnapshot adds sn to snapshots (replacing the snapshot with the
+// same name, if any) and returns the result, sorted by name.
+func putSnapshot(snapshots []*Snapshot, sn *Snapshot) []*Snapshot {
+	for i, sn2 := range snapshots {
+		if sn2.Name == sn.Name {
+			snapshots[i] = sn
+			return snapshots
+		}
+	}
+	snapshots = append(snapshots, sn)
+	sort.Sort(snapshotsByName(snapshots))
+	return snapshots
+}
+
+// removeSnapshot removes the named snapshot from snapshots. It
+// returns an error if there is no such snapshot.
+func removeSnapshot(snapshots []*Snapshot, name string) ([]*Snapshot, error) {
+	for i, sn := range snapshots {
+		if sn.Name == name {
+			return append(snapshots[:i], snapshots[i+1:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshot named %q", name)
+}
+
+// resolveSnapshots returns a copy of filters (a typed slice of
+// filters, such as []DefFilter) in which the snapshot names in
+// ByCommitIDs filters are replaced by the commit IDs that they refer
+// to. The snapshots are those of the repo store o, if it implements
+// SnapshotStore; otherwise filters is returned unchanged.
+//
+// MultiRepoStores don't need to resolve snapshots themselves, because
+// they pass ByRepoCommitIDs filters to each repo store as ByCommitIDs
+// filters (see filtersForRepo).
+func resolveSnapshots(o treeStoreOpener, filters interface{}) (interface{}, error) {
+	ss, ok := o.(SnapshotStore)
+	if !ok {
+		return filters, nil
+	}
+
+	sf := storeFilters(filters)
+	hasCommitIDs := false
+	for _, f := range sf {
+		if _, ok := f.(byCommitIDsFilter); ok {
+			hasCommitIDs = true
+			break
+		}
+	}
+	if !hasCommitIDs {
+		return filters, nil
+	}
+
+	snapshots, err := ss.Snapshots("")
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return filters, nil
+	}
+
+	resolved := make([]interface{}, len(sf))
+	for i, f := range sf {
+		if f, ok := f.(byCommitIDsFilter); ok {
+			commitIDs := make(byCommitIDsFilter, len(f))
+			for j, c := range f {
+				commitIDs[j] = snapshotCommitID(snapshots, c)
+			}
+			resolved[i] = commitIDs
+		} else {
+			resolved[i] = f
+		}
+	}
+	return toTypedFilterSlice(reflect.TypeOf(filters), resolved), nil
+}
+
+func (s *fsMultiRepoStore) Snapshots(repo string) ([]*Snapshot, error) {
+	var repos []string
+	if repo != "" {
+		repos = []string{s.canonicalRepo(repo)}
+	} else {
+		var err error
+		repos, err = s.Repos()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var all []*Snapshot
+	for _, repo := range repos {
+		snapshots, err := s.openRepoStore(repo).(SnapshotStore).Snapshots(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, sn := range snapshots {
+			sn.Repo = repo
+		}
+		all = append(all, snapshots...)
+	}
+	return all, nil
+}
+
+func (s *fsMultiRepoStore) CreateSnapshot(repo, name, commitID string) error {
+	if repo == "" {
+		return fmt.Errorf("CreateSnapshot: repo: empty")
+	}
+	return s.openRepoStore(s.canonicalRepo(repo)).(SnapshotCreator).CreateSnapshot(repo, name, commitID)
+}
+
+func (s *fsMultiRepoStore) DeleteSnapshot(repo, name string) error {
+	if repo == "" {
+		return fmt.Errorf("DeleteSnapshot: repo: empty")
+	}
+	return s.openRepoStore(s.canonicalRepo(repo)).(SnapshotCreator).DeleteSnapshot(repo, name)
+}
+
+// snapshotsFilename is the name of the file (in the top-level dir of
+// a fsRepoStore) that holds the repo's snapshots.
+const snapshotsFilename = "snapshots.json"
+
+func (s *fsRepoStore) Snapshots(repo string) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	if err := readJSONFile(s.fs, snapshotsFilename, &snapshots); isOSOrVFSNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (s *fsRepoStore) CreateSnapshot(repo, name, commitID string) error {
+	snapshots, err := s.Snapshots(repo)
+	if err != nil {
+		return err
+	}
+	commitID = snapshotCommitID(snapshots, commitID)
+	if err := checkNewSnapshot(s, name, commitID); err != nil {
+		return err
+	}
+	snapshots = putSnapshot(snapshots, &Snapshot{Name: name, CommitID: commitID, CreatedAt: time.Now()})
+	return s.writeSnapshots(snapshots)
+}
+
+func (s *fsRepoStore) DeleteSnapshot(repo, name string) error {
+	snapshots, err := s.Snapshots(repo)
+	if err != nil {
+		return err
+	}
+	snapshots, err = removeSnapshot(snapshots, name)
+	if err != nil {
+		return err
+	}
+	return s.writeSnapshots(snapshots)
+}
+
+func (s *fsRepoStore) writeSnapshots(snapshots []*Snapshot) error {
+	if err := s.format.ensure(s.fs, s.codec); err != nil {
+		return err
+	}
+	return writeJSONFile(s.fs, snapshotsFilename, snapshots)
+}
+
+func (s *memoryMultiRepoStore) Snapshots(repo string) ([]*Snapshot, error) {
+	var repos []string
+	if repo != "" {
+		repos = []string{repo}
+	} else {
+		for repo := range s.repos {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+	}
+
+	var all []*Snapshot
+	for _, repo := range repos {
+		rs, present := s.repos[repo]
+		if !present {
+			continue
+		}
+		snapshots, err := rs.Snapshots(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, sn := range snapshots {
+			sn.Repo = repo
+		}
+		all = append(all, snapshots...)
+	}
+	return all, nil
+}
+
+func (s *memoryMultiRepoStore) CreateSnapshot(repo, name, commitID string) error {
+	rs, present := s.repos[repo]
+	if !present {
+		return fmt.Errorf("CreateSnapshot: repo %q not found", repo)
+	}
+	return rs.CreateSnapshot(repo, name, commitID)
+}
+
+func (s *memoryMultiRepoStore) DeleteSnapshot(repo, name string) error {
+	rs, present := s.repos[repo]
+	if !present {
+		return fmt.Errorf("DeleteSnapshot: repo %q not found", repo)
+	}
+	return rs.DeleteSnapshot(repo, name)
+}
+
+func (s *memoryRepoStore) Snapshots(repo string) ([]*Snapshot, error) {
+	snapshots := make([]*Snapshot, len(s.snapshots))
+	for i, sn := range s.snapshots {
+		sn2 := *sn
+		snapshots[i] = &sn2
+	}
+	return snapshots, nil
+}
+
+func (s *memoryRepoStore) CreateSnapshot(repo, name, commitID string) error {
+	commitID = snapshotCommitID(s.snapshots, commitID)
+	if err := checkNewSnapshot(s, name, commitID); err != nil {
+		return err
+	}
+	s.snapshots = putSnapshot(s.snapshots, &Snapshot{Name: name, CommitID: commitID, CreatedAt: time.Now()})
+	return nil
+}
+
+func (s *memoryRepoStore) DeleteSnapshot(repo, name string) error {
+	snapshots, err := removeSnapshot(s.snapshots, name)
+	if err != nil {
+		return err
+	}
+	s.snapshots = snapshots
+	return nil
+}
+
+type snapshotsByName []*Snapshot
+
+func (v snapshotsByName) Len() int           { return len(v) }
+func (v snapshotsByName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v snapshotsByName) Less(i, j int) bool { return v[i].Name < v[j].Name }
+
+var (
+	_ SnapshotStore   = (*fsMultiRepoStore)(nil)
+	_ SnapshotStore   = (*fsRepoStore)(nil)
+	_ SnapshotStore   = (*memoryMultiRepoStore)(nil)
+	_ SnapshotStore   = (*memoryRepoStore)(nil)
+	_ SnapshotCreator = (*fsMultiRepoStore)(nil)
+	_ SnapshotCreator = (*fsRepoStore)(nil)
+	_ SnapshotCreator = (*memoryMultiRepoStore)(nil)
+	_ SnapshotCreator = (*memoryRepoStore)(nil)
+)
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMemoryMultiRepoStore_Snapshots(t *testing.T) {
+	testMultiRepoStore_Snapshots(t, newMemoryMultiRepoStore())
+}
+
+func TestFSMultiRepoStore_Snapshots(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore_Snapshots(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func TestIndexedFSMultiRepoStore_Snapshots(t *testing.T) {
+	useIndexedStore = true
+	testMultiRepoStore_Snapshots(t, NewFSMultiRepoStore(newTestFS(), nil))
+}
+
+func testMultiRepoStore_Snapshots(t *testing.T, mrs MultiRepoStoreImporter) {
+	for _, commitID := range []string{"c1", "c2"} {
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p-" + commitID}, File: "f"}}}
+		if err := mrs.Import("r", commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+		if mrs, ok := mrs.(MultiRepoIndexer); ok {
+			if err := mrs.Index("r", commitID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	sc, ok := mrs.(SnapshotCreator)
+	if !ok {
+		t.Fatalf("%s: does not implement SnapshotCreator", mrs)
+	}
+	for _, bad := range []struct{ name, commitID string }{
+		{"", "c1"},
+		{"a/b", "c1"},
+		{"c2", "c1"}, // name is an imported commit ID
+		{"rel", "c3"},
+	} {
+		if err := sc.CreateSnapshot("r", bad.name, bad.commitID); err == nil {
+			t.Errorf("%s: CreateSnapshot(%q, %q): got no error", mrs, bad.name, bad.commitID)
+		}
+	}
+	if err := sc.CreateSnapshot("r", "rel", "c1"); err != nil {
+		t.Fatalf("%s: CreateSnapshot: %s", mrs, err)
+	}
+
+	checkDefs := func(label string, f DefFilter, wantCommitID string) {
+		defs, err := mrs.Defs(f)
+		if err != nil {
+			t.Fatalf("%s: %s: Defs: %s", mrs, label, err)
+		}
+		if len(defs) != 1 || defs[0].CommitID != wantCommitID || defs[0].Path != "p-"+wantCommitID {
+			t.Errorf("%s: %s: got defs %v, want the def at %s", mrs, label, defs, wantCommitID)
+		}
+	}
+	checkDefs("ByRepoCommitIDs", ByRepoCommitIDs(Version{Repo: "r", CommitID: "rel"}), "c1")
+	checkDefs("ByCommitIDs", ByCommitIDs("rel"), "c1")
+	versions, err := mrs.Versions(ByRepoCommitIDs(Version{Repo: "r", CommitID: "rel"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Version{{Repo: "r", CommitID: "c1"}}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("%s: Versions: got %v, want %v", mrs, versions, want)
+	}
+
+	// Creating a snapshot with an existing name moves it.
+	if err := sc.CreateSnapshot("r", "rel", "c2"); err != nil {
+		t.Fatalf("%s: CreateSnapshot (move): %s", mrs, err)
+	}
+	checkDefs("after move", ByRepoCommitIDs(Version{Repo: "r", CommitID: "rel"}), "c2")
+
+	// A snapshot can name another snapshot's commit.
+	if err := sc.CreateSnapshot("r", "latest", "rel"); err != nil {
+		t.Fatalf("%s: CreateSnapshot (of snapshot): %s", mrs, err)
+	}
+	snapshots, err := mrs.(SnapshotStore).Snapshots("")
+	if err != nil {
+		t.Fatalf("%s: Snapshots: %s", mrs, err)
+	}
+	var got [][3]string
+	for _, sn := range snapshots {
+		got = append(got, [3]string{sn.Repo, sn.Name, sn.CommitID})
+	}
+	if want := [][3]string{{"r", "latest", "c2"}, {"r", "rel", "c2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: Snapshots: got %v, want %v", mrs, got, want)
+	}
+
+	if err := sc.DeleteSnapshot("r", "rel"); err != nil {
+		t.Fatalf("%s: DeleteSnapshot: %s", mrs, err)
+	}
+	if err := sc.DeleteSnapshot("r", "rel"); err == nil {
+		t.Errorf("%s: DeleteSnapshot of deleted snapshot: got no error", mrs)
+	}
+	defs, err := mrs.Defs(ByRepoCommitIDs(Version{Repo: "r", CommitID: "rel"}))
+	if err != nil && !IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(defs) != 0 {
+		t.Errorf("%s: got defs %v for deleted snapshot, want none", mrs, defs)
+	}
+}
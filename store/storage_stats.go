@@ -0,0 +1,145 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/kr/fs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// A StorageStatsStore reports how a repo's data is stored (see
+// Compactor). It is implemented by the FS-backed stores at the
+// MultiRepoStore and RepoStore levels.
+type StorageStatsStore interface {
+	// StorageStats returns stats about how the data of all of the
+	// repo's commits is stored. The repo is only used by
+	// MultiRepoStores.
+	StorageStats(repo string) (*StorageStats, error)
+}
+
+// StorageStats describes how a repo's data is stored, and how much
+// space compaction (with deltas or deduplication) saves.
+type StorageStats struct {
+	Repo string `json:",omitempty"`
+
+	// Commits is the number of commits, and Compacted is the number
+	// of them whose data is packed (see Compactor).
+	Commits, Compacted int
+
+	// Files is the number of per-unit data files in all commits.
+	// LooseFiles of them are stored as loose files, and DeltaFiles
+	// as deltas (see CompactOpt.DeltaBase).
+	Files, LooseFiles, DeltaFiles int
+
+	// Blobs is the number of distinct blobs (see CompactOpt.Dedup)
+	// that commits' data is stored in, and BlobRefs is the number of
+	// references from commits to them (so a blob that holds a unit
+	// that's unchanged in 3 commits has 3 refs). SharedBlobs is the
+	// number of blobs with more than 1 ref.
+	Blobs, BlobRefs, SharedBlobs int
+
+	// Size is the total size of the files in all commits (as
+	// they're read), and StoredSize is the space they take up
+	// (counting each shared blob once).
+	Size, StoredSize int64
+}
+
+// Saved returns the fraction of Size that compaction saves.
+func (s *StorageStats) Saved() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return 1 - float64(s.StoredSize)/float64(s.Size)
+}
+
+func (s *fsMultiRepoStore) StorageStats(repo string) (*StorageStats, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("StorageStats: repo: empty")
+	}
+	repo = s.canonicalRepo(repo)
+	stats, err := s.openRepoStore(repo).(StorageStatsStore).StorageStats(repo)
+	if err != nil {
+		return nil, err
+	}
+	stats.Repo = repo
+	return stats, nil
+}
+
+func (s *fsRepoStore) StorageStats(repo string) (*StorageStats, error) {
+	commitIDs, err := s.versionDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &StorageStats{Commits: len(commitIDs)}
+	blobRefs := map[string]int{}
+	blobSizes := map[string]int64{}
+	for _, commitID := range commitIDs {
+		var idx packIndex
+		if err := readJSONFile(s.fs, path.Join(commitID, packIndexFilename), &idx); err != nil && !isOSOrVFSNotExist(err) {
+			return nil, err
+		}
+		if len(idx.Files) > 0 {
+			stats.Compacted++
+		}
+		commitBlobs := map[string]struct{}{}
+		for _, e := range idx.Files {
+			stats.Files++
+			stats.Size += e.fileSize()
+			if e.Delta {
+				stats.DeltaFiles++
+			}
+			if e.Blob != "" {
+				commitBlobs[e.Blob] = struct{}{}
+				if end := e.Offset + e.Size; end > blobSizes[e.Blob] {
+					blobSizes[e.Blob] = end
+				}
+			}
+		}
+		for hash := range commitBlobs {
+			blobRefs[hash]++
+		}
+
+		// Count the loose files and the pack file.
+		w := fs.WalkFS(commitID, rwvfs.Walkable(s.fs))
+		for w.Step() {
+			if err := w.Err(); err != nil {
+				return nil, err
+			}
+			fi := w.Stat()
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			name := strings.TrimPrefix(w.Path(), commitID+"/")
+			if _, packed := idx.Files[name]; packed {
+				continue
+			}
+			if isPackFilename(name) {
+				stats.StoredSize += fi.Size()
+			} else if isPackable(name) {
+				stats.Files++
+				stats.LooseFiles++
+				stats.Size += fi.Size()
+				stats.StoredSize += fi.Size()
+			}
+		}
+	}
+
+	stats.Blobs = len(blobRefs)
+	for hash, refs := range blobRefs {
+		stats.BlobRefs += refs
+		if refs > 1 {
+			stats.SharedBlobs++
+		}
+		stats.StoredSize += blobSizes[hash]
+	}
+	return stats, nil
+}
+
+var (
+	_ StorageStatsStore = (*fsMultiRepoStore)(nil)
+	_ StorageStatsStore = (*fsRepoStore)(nil)
+)
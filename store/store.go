@@ -20,6 +20,19 @@ func isStoreNotExist(err error) bool {
 	return isOSOrVFSNotExist(err) || err == errRepoNoInit || err == errTreeNoInit || err == errMultiRepoStoreNoInit || err == errUnitNoInit
 }
 
+// IsNotExist returns a boolean indicating whether err is known to
+// report that a store (or some of its data) does not exist.
+func IsNotExist(err error) bool { return isStoreNotExist(err) }
+
+// IsIndexCorrupt returns a boolean indicating whether err reports
+// that an index could not be read because its data is corrupt (or was
+// written in an incompatible format). Rebuilding the index usually
+// fixes it.
+func IsIndexCorrupt(err error) bool {
+	_, ok := err.(*errIndexCorrupt)
+	return ok
+}
+
 // isOSOrVFSNotExist returns a boolean indicating whether err is known
 // to be an OS- or VFS-level error reporting that a file or dir does
 // not exist. It is like os.IsNotExist but also handles common errors
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"io"
+	"strings"
+)
+
+// IsTransient returns a boolean indicating whether err is likely to be
+// a transient error: one that may not occur if the operation is
+// retried, such as a network timeout, a dropped connection, or an S3
+// "503 Slow Down" or "500 Internal Error" response. Other errors
+// (e.g., a file doesn't exist, access is denied, or data is corrupt)
+// are permanent.
+func IsTransient(err error) bool {
+	if err == nil || isOSOrVFSNotExist(err) || IsIndexCorrupt(err) {
+		return false
+	}
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if e, ok := err.(interface {
+		Timeout() bool
+	}); ok && e.Timeout() {
+		return true
+	}
+	if e, ok := err.(interface {
+		Temporary() bool
+	}); ok && e.Temporary() {
+		return true
+	}
+
+	// VFS implementations (such as s3vfs) usually don't preserve the
+	// type of the underlying error, so check its message.
+	msg := err.Error()
+	for _, s := range transientErrorMessages {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// transientErrorMessages are substrings of the messages of errors
+// that IsTransient considers transient.
+var transientErrorMessages = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"connection refused",
+	"unexpected EOF",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"use of closed network connection",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"InternalError",
+	"RequestTimeout",
+	"ServiceUnavailable",
+	"SlowDown",
+}
+
+// withTransientRetries calls f until it succeeds, returns an error
+// that is not transient (see IsTransient), or has failed
+// NetFetch.Retries+1 times, waiting exponentially longer between
+// attempts (like withNetRetries). It returns the last error. The
+// description of the operation (what) is logged with each retry.
+func withTransientRetries(what string, f func() error) error {
+	backoff := NetFetch.RetryBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if !IsTransient(err) || i >= NetFetch.Retries {
+			return err
+		}
+		vlog.Printf("%s failed with a transient error (attempt %d of %d), retrying in %s: %s", what, i+1, NetFetch.Retries+1, backoff, err)
+		sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&os.PathError{Op: "open", Path: "f", Err: os.ErrNotExist}, false},
+		{&errIndexCorrupt{name: "x", err: errors.New("bad")}, false},
+		{errors.New("permission denied"), false},
+		{io.ErrUnexpectedEOF, true},
+		{timeoutError{}, true},
+		{errors.New("Get https://b.s3.amazonaws.com/x: read tcp 1.2.3.4:443: connection reset by peer"), true},
+		{errors.New("Error 503: SlowDown: Please reduce your request rate."), true},
+		{errors.New("http error: 503 Service Unavailable"), true},
+	}
+	for _, test := range tests {
+		if got := IsTransient(test.err); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWithTransientRetries(t *testing.T) {
+	defer func(c NetFetchConfig) { NetFetch = c }(NetFetch)
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	NetFetch.Retries = 2
+	NetFetch.RetryBackoff = time.Millisecond
+
+	// Transient errors are retried.
+	calls := 0
+	err := withTransientRetries("test", func() error {
+		calls++
+		if calls <= 2 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+	if want := []time.Duration{time.Millisecond, 2 * time.Millisecond}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("got backoffs %v, want %v", slept, want)
+	}
+
+	// Give up after NetFetch.Retries retries.
+	calls = 0
+	if err := withTransientRetries("test", func() error { calls++; return io.ErrUnexpectedEOF }); err != io.ErrUnexpectedEOF {
+		t.Errorf("got err %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+
+	// Permanent errors are not retried.
+	calls = 0
+	permErr := errors.New("permission denied")
+	if err := withTransientRetries("test", func() error { calls++; return permErr }); err != permErr {
+		t.Errorf("got err %v, want %v", err, permErr)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
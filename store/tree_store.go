@@ -13,7 +13,8 @@ type TreeStore interface {
 
 	// UnitStore's methods call the corresponding methods on the
 	// UnitStore of each source unit contained within this tree. The
-	// combined results are returned (in undefined order).
+	// combined results are sorted by key (unless an Unordered filter
+	// is given).
 	UnitStore
 }
 
@@ -51,6 +52,11 @@ type treeStores struct {
 var _ TreeStore = (*treeStores)(nil)
 
 func (s treeStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]UnitFilter)
 	tss, err := openTreeStores(s.opener, f)
 	if err != nil {
 		return nil, err
@@ -71,10 +77,16 @@ func (s treeStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 		}
 		allUnits = append(allUnits, units...)
 	}
+	sortUnits(allUnits, f)
 	return allUnits, nil
 }
 
 func (s treeStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]DefFilter)
 	tss, err := openTreeStores(s.opener, f)
 	if err != nil {
 		return nil, err
@@ -95,10 +107,16 @@ func (s treeStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
 		}
 		allDefs = append(allDefs, defs...)
 	}
+	sortDefs(allDefs, f)
 	return allDefs, nil
 }
 
 func (s treeStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	rf, err := resolveSnapshots(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+	f = rf.([]RefFilter)
 	tss, err := openTreeStores(s.opener, f)
 	if err != nil {
 		return nil, err
@@ -120,5 +138,6 @@ func (s treeStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 		}
 		allRefs = append(allRefs, refs...)
 	}
+	sortRefs(allRefs, f)
 	return allRefs, nil
 }
@@ -583,7 +583,7 @@ func testTreeStore_Defs_ByFiles(t *testing.T, ts TreeStoreImporter) {
 		{DefKey: graph.DefKey{UnitType: "t2", Unit: "u2", Path: "p2"}, File: "f2"},
 	}
 
-	c_unitFilesIndex_getByPath = 0
+	c_defFilesTreeIndex_getByPath = 0
 	defs, err := ts.Defs(ByFiles("f2"))
 	if err != nil {
 		t.Errorf("%s: Defs(ByFiles f2): %s", ts, err)
@@ -592,8 +592,8 @@ func testTreeStore_Defs_ByFiles(t *testing.T, ts TreeStoreImporter) {
 		t.Errorf("%s: Defs(ByFiles f2): got defs %v, want %v", ts, defs, want)
 	}
 	if isIndexedStore(ts) {
-		if want := 1; c_unitFilesIndex_getByPath != want {
-			t.Errorf("%s: Defs(ByFiles f2): got %d index hits, want %d", ts, c_unitFilesIndex_getByPath, want)
+		if want := 1; c_defFilesTreeIndex_getByPath != want {
+			t.Errorf("%s: Defs(ByFiles f2): got %d index hits, want %d", ts, c_defFilesTreeIndex_getByPath, want)
 		}
 	}
 }
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// unitLanguagesIndex makes it fast to determine which source units
+// are written in a language.
+type unitLanguagesIndex struct {
+	langs map[string][]unit.ID2 // language -> units
+	ready bool
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	unitIndexBuilder
+	unitIndex
+} = (*unitLanguagesIndex)(nil)
+
+func (x *unitLanguagesIndex) String() string {
+	return fmt.Sprintf("unitLanguagesIndex(ready=%v)", x.ready)
+}
+
+// Covers implements unitIndex.
+func (x *unitLanguagesIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if _, ok := f.(ByLanguagesFilter); ok {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Units implements unitIndex.
+func (x *unitLanguagesIndex) Units(fs ...UnitFilter) ([]unit.ID2, error) {
+	if x.langs == nil {
+		panic("langs not built/read")
+	}
+	for _, f := range fs {
+		if lf, ok := f.(ByLanguagesFilter); ok {
+			us := []unit.ID2{}
+			for _, lang := range lf.ByLanguages() {
+				us = append(us, x.langs[lang]...)
+			}
+			vlog.Printf("unitLanguagesIndex(%v): Found units %v using index.", fs, us)
+			return us, nil
+		}
+	}
+	return nil, nil
+}
+
+// Build implements unitIndexBuilder.
+func (x *unitLanguagesIndex) Build(units []*unit.SourceUnit) error {
+	vlog.Printf("unitLanguagesIndex: building index...")
+	x.langs = map[string][]unit.ID2{}
+	for _, u := range units {
+		if u.Language != "" {
+			x.langs[u.Language] = append(x.langs[u.Language], u.ID2())
+		}
+	}
+	x.ready = true
+	vlog.Printf("unitLanguagesIndex: done building index.")
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *unitLanguagesIndex) Write(w io.Writer) error {
+	if x.langs == nil {
+		panic("no langs to write")
+	}
+	return json.NewEncoder(w).Encode(x.langs)
+}
+
+// Read implements persistedIndex.
+func (x *unitLanguagesIndex) Read(r io.Reader) error {
+	err := json.NewDecoder(r).Decode(&x.langs)
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *unitLanguagesIndex) Ready() bool { return x.ready }
@@ -76,7 +76,8 @@ func (s unitStores) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 			return nil
 		})
 	}
-	err = par.Wait()
+	err = getQueryControls(fs).err(par.Wait())
+	sortDefs(allDefs, fs)
 	return allDefs, err
 }
 
@@ -113,6 +114,7 @@ func (s unitStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 		}
 		allRefs = append(allRefs, refs...)
 	}
+	sortRefs(allRefs, f)
 	return allRefs, nil
 }
 
@@ -150,4 +152,34 @@ func cleanForImport(data *graph.Output, repo, unitType, unit string) {
 		ann.Repo = ""
 		ann.CommitID = ""
 	}
+	for _, call := range data.Calls {
+		call.Unit = ""
+		call.UnitType = ""
+		call.Repo = ""
+		call.CommitID = ""
+		if repo != "" && call.CalleeRepo == repo {
+			call.CalleeRepo = ""
+		}
+		if unitType != "" && call.CalleeUnitType == unitType {
+			call.CalleeUnitType = ""
+		}
+		if unit != "" && call.CalleeUnit == unit {
+			call.CalleeUnit = ""
+		}
+	}
+	for _, r := range data.Relations {
+		r.Unit = ""
+		r.UnitType = ""
+		r.Repo = ""
+		r.CommitID = ""
+		if repo != "" && r.TargetRepo == repo {
+			r.TargetRepo = ""
+		}
+		if unitType != "" && r.TargetUnitType == unitType {
+			r.TargetUnitType = ""
+		}
+		if unit != "" && r.TargetUnit == unit {
+			r.TargetUnit = ""
+		}
+	}
 }
@@ -3,6 +3,7 @@ package store
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -67,17 +68,63 @@ func openUnitStores(o unitStoreOpener, filters interface{}) (map[unit.ID2]UnitSt
 		return nil, err
 	}
 
+	unitIDs, err = scopeUnitsByLanguage(o, unitIDs, storeFilters(filters))
+	if err != nil {
+		return nil, err
+	}
+
 	if unitIDs == nil {
-		return o.openAllUnitStores()
+		start := time.Now()
+		uss, err := o.openAllUnitStores()
+		if err == nil {
+			explainStep(o, "scope", start, "no filters narrow the source units; opened all %d source units", len(uss))
+		}
+		return uss, err
 	}
 
 	uss := make(map[unit.ID2]UnitStore, len(unitIDs))
 	for _, u := range unitIDs {
 		uss[u] = o.openUnitStore(u)
 	}
+	explainStep(o, "scope", time.Now(), "querying %d source units", len(uss))
 	return uss, nil
 }
 
+// scopeUnitsByLanguage narrows unitIDs (the result of scopeUnits) to
+// the source units that match the ByLanguages filters in filters, if
+// any. Defs and refs do not record their language, so this scoping is
+// what makes ByLanguages apply to them.
+func scopeUnitsByLanguage(o unitStoreOpener, unitIDs []unit.ID2, filters []interface{}) ([]unit.ID2, error) {
+	var ufs []UnitFilter
+	for _, f := range filters {
+		if _, ok := f.(ByLanguagesFilter); ok {
+			ufs = append(ufs, f.(UnitFilter))
+		}
+	}
+	if len(ufs) == 0 || (unitIDs != nil && len(unitIDs) == 0) {
+		return unitIDs, nil
+	}
+
+	lister, ok := o.(interface {
+		Units(...UnitFilter) ([]*unit.SourceUnit, error)
+	})
+	if !ok {
+		return unitIDs, nil
+	}
+	if unitIDs != nil {
+		ufs = append(ufs, ByUnits(unitIDs...))
+	}
+	units, err := lister.Units(ufs...)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]unit.ID2, len(units))
+	for i, u := range units {
+		ids[i] = u.ID2()
+	}
+	return ids, nil
+}
+
 // filtersForUnit modifies the filters list to remove filters or
 // conditions inside filters that are guaranteed to be true or
 // unnecessary when using the filters on a call to a specific unit
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// VendorDirs are the names of the directories that hold vendored
+// copies of dependencies.
+var VendorDirs = []string{"vendor", "node_modules"}
+
+// VendoredPath returns the part of the slash-separated path p that is
+// underneath the innermost vendor dir (see VendorDirs) containing p,
+// and true. For example, "a/vendor/github.com/x/y" yields
+// "github.com/x/y". If p is not in a vendor dir, it returns "" and
+// false.
+func VendoredPath(p string) (string, bool) {
+	parts := strings.Split(path.Clean(filepath.ToSlash(p)), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		for _, d := range VendorDirs {
+			if parts[i] == d {
+				return strings.Join(parts[i+1:], "/"), true
+			}
+		}
+	}
+	return "", false
+}
+
+// A VendorAttribution maps the source units in a tree that are
+// vendored copies of dependencies to the units in the upstream
+// repositories that they were copied from.
+type VendorAttribution struct {
+	repo     string
+	upstream map[unit.ID2]unit.Key
+}
+
+// NewVendorAttribution determines which of units (the source units of
+// repo) are vendored copies of dependencies, using the tree's
+// resolved deps. A unit is attributed to the resolved dep (on another
+// repo) whose ToUnit is the longest path prefix of the unit's
+// vendored path (see VendoredPath).
+func NewVendorAttribution(repo string, units []*unit.SourceUnit, deps []*dep.ResolvedDep) *VendorAttribution {
+	a := &VendorAttribution{repo: repo, upstream: map[unit.ID2]unit.Key{}}
+	for _, u := range units {
+		dir := u.Dir
+		if dir == "" && len(u.Files) > 0 {
+			dir = path.Dir(filepath.ToSlash(u.Files[0]))
+		}
+		vpath, ok := VendoredPath(dir)
+		if !ok {
+			continue
+		}
+
+		var best *dep.ResolvedDep
+		for _, d := range deps {
+			if d.ToRepo == "" || d.ToRepo == repo || d.ToUnitType != u.Type {
+				continue
+			}
+			if vpath != d.ToUnit && !strings.HasPrefix(vpath, d.ToUnit+"/") {
+				continue
+			}
+			if best == nil || len(d.ToUnit) > len(best.ToUnit) {
+				best = d
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		// Units beneath the dep's unit (such as subpackages) are named
+		// by their path relative to the upstream repository's units.
+		a.upstream[u.ID2()] = unit.Key{
+			Repo:     best.ToRepo,
+			UnitType: best.ToUnitType,
+			Unit:     best.ToUnit + strings.TrimPrefix(vpath, best.ToUnit),
+			CommitID: best.ToRevSpec,
+		}
+	}
+	return a
+}
+
+// Len returns the number of vendored source units.
+func (a *VendorAttribution) Len() int { return len(a.upstream) }
+
+// Upstream returns the key of the upstream unit that the unit u is a
+// vendored copy of, if any.
+func (a *VendorAttribution) Upstream(u unit.ID2) (unit.Key, bool) {
+	k, ok := a.upstream[u]
+	return k, ok
+}
+
+// AttributeUnit returns u, or, if u is a vendored copy of an upstream
+// unit, a copy of u whose Upstream field is set.
+func (a *VendorAttribution) AttributeUnit(u *unit.SourceUnit) *unit.SourceUnit {
+	k, ok := a.upstream[u.ID2()]
+	if !ok {
+		return u
+	}
+	uc := *u
+	uc.Upstream = &k
+	return &uc
+}
+
+// AttributeRefs rewrites the refs in data (the graph data of the unit
+// u) to defs in vendored units so that they refer to the
+// corresponding defs in the upstream units. Cross-repo ref queries
+// then find these refs under the upstream repository instead of the
+// vendored copy. Refs that are the definitions of defs (whose Def
+// field is true) are not rewritten, so vendored defs keep their
+// locations. It returns the number of refs that were rewritten.
+func (a *VendorAttribution) AttributeRefs(u unit.ID2, data *graph.Output) int {
+	if len(a.upstream) == 0 {
+		return 0
+	}
+	var n int
+	for _, ref := range data.Refs {
+		if ref.Def || (ref.DefRepo != "" && ref.DefRepo != a.repo) {
+			continue
+		}
+		defUnit := unit.ID2{Type: ref.DefUnitType, Name: ref.DefUnit}
+		if defUnit.Type == "" {
+			defUnit.Type = u.Type
+		}
+		if defUnit.Name == "" {
+			defUnit.Name = u.Name
+		}
+		k, ok := a.upstream[defUnit]
+		if !ok {
+			continue
+		}
+		ref.DefRepo = k.Repo
+		ref.DefUnitType = k.UnitType
+		ref.DefUnit = k.Unit
+		n++
+	}
+	return n
+}
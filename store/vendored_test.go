@@ -0,0 +1,89 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestVendoredPath(t *testing.T) {
+	tests := map[string]struct {
+		want string
+		ok   bool
+	}{
+		"a/b":                           {"", false},
+		"vendor":                        {"", false},
+		"vendor/github.com/x/y":         {"github.com/x/y", true},
+		"a/vendor/b/vendor/c":           {"c", true},
+		"web/node_modules/lodash/fp":    {"lodash/fp", true},
+		"web/node_modules/@types/node/": {"@types/node", true},
+	}
+	for p, test := range tests {
+		got, ok := VendoredPath(p)
+		if got != test.want || ok != test.ok {
+			t.Errorf("VendoredPath(%q): got %q, %v, want %q, %v", p, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestVendorAttribution(t *testing.T) {
+	units := []*unit.SourceUnit{
+		{Type: "GoPackage", Name: "r/cmd", Dir: "cmd"},
+		{Type: "GoPackage", Name: "r/vendor/x.com/y", Dir: "vendor/x.com/y"},
+		{Type: "GoPackage", Name: "r/vendor/x.com/y/z", Dir: "vendor/x.com/y/z"},
+		{Type: "GoPackage", Name: "r/vendor/x.com/unresolved", Dir: "vendor/x.com/unresolved"},
+		{Type: "CommonJSPackage", Name: "lodash", Files: []string{"node_modules/lodash/index.js"}},
+	}
+	deps := []*dep.ResolvedDep{
+		{FromUnit: "r/cmd", FromUnitType: "GoPackage", ToRepo: "x.com/y", ToUnit: "x.com/y", ToUnitType: "GoPackage", ToRevSpec: "v1"},
+		{FromUnit: "r/cmd", FromUnitType: "GoPackage", ToRepo: "r", ToUnit: "x.com/unresolved", ToUnitType: "GoPackage"},
+		{FromUnit: "web", FromUnitType: "CommonJSPackage", ToRepo: "github.com/lodash/lodash", ToUnit: "lodash", ToUnitType: "CommonJSPackage"},
+	}
+	a := NewVendorAttribution("r", units, deps)
+	if a.Len() != 3 {
+		t.Errorf("got %d vendored units, want 3", a.Len())
+	}
+
+	want := map[unit.ID2]*unit.Key{
+		units[0].ID2(): nil,
+		units[1].ID2(): {Repo: "x.com/y", CommitID: "v1", UnitType: "GoPackage", Unit: "x.com/y"},
+		units[2].ID2(): {Repo: "x.com/y", CommitID: "v1", UnitType: "GoPackage", Unit: "x.com/y/z"},
+		units[3].ID2(): nil,
+		units[4].ID2(): {Repo: "github.com/lodash/lodash", UnitType: "CommonJSPackage", Unit: "lodash"},
+	}
+	for _, u := range units {
+		got := a.AttributeUnit(u).Upstream
+		if w := want[u.ID2()]; !reflect.DeepEqual(got, w) {
+			t.Errorf("unit %v: got Upstream %+v, want %+v", u.ID2(), got, w)
+		}
+		if u.Upstream != nil {
+			t.Errorf("unit %v: AttributeUnit modified its argument", u.ID2())
+		}
+	}
+
+	data := graph.Output{Refs: []*graph.Ref{
+		{DefUnitType: "GoPackage", DefUnit: "r/vendor/x.com/y", DefPath: "F"},
+		{DefRepo: "r", DefUnitType: "GoPackage", DefUnit: "r/vendor/x.com/y/z", DefPath: "G"},
+		{DefPath: "H"},
+		{DefPath: "H", Def: true},
+		{DefUnitType: "GoPackage", DefUnit: "r/cmd", DefPath: "I"},
+		{DefRepo: "other", DefUnitType: "GoPackage", DefUnit: "r/vendor/x.com/y", DefPath: "J"},
+	}}
+	if n := a.AttributeRefs(units[1].ID2(), &data); n != 3 {
+		t.Errorf("got %d attributed refs, want 3", n)
+	}
+	wantRefs := []*graph.Ref{
+		{DefRepo: "x.com/y", DefUnitType: "GoPackage", DefUnit: "x.com/y", DefPath: "F"},
+		{DefRepo: "x.com/y", DefUnitType: "GoPackage", DefUnit: "x.com/y/z", DefPath: "G"},
+		{DefRepo: "x.com/y", DefUnitType: "GoPackage", DefUnit: "x.com/y", DefPath: "H"},
+		{DefPath: "H", Def: true},
+		{DefUnitType: "GoPackage", DefUnit: "r/cmd", DefPath: "I"},
+		{DefRepo: "other", DefUnitType: "GoPackage", DefUnit: "r/vendor/x.com/y", DefPath: "J"},
+	}
+	if !reflect.DeepEqual(data.Refs, wantRefs) {
+		t.Errorf("got refs %+v, want %+v", data.Refs, wantRefs)
+	}
+}
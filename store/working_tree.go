@@ -0,0 +1,43 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// WorkingTreeCommitIDPrefix is the prefix of the synthetic commit IDs
+// under which the data of uncommitted working trees is stored (see
+// WorkingTreeCommitID).
+const WorkingTreeCommitIDPrefix = "WORKDIR-"
+
+// workingTreeHashLen is the number of hex digits of the content hash
+// in a working tree commit ID, chosen so that working tree commit IDs
+// are as long as git and hg commit IDs (40 chars).
+const workingTreeHashLen = 40 - len(WorkingTreeCommitIDPrefix)
+
+// WorkingTreeCommitID returns the synthetic commit ID for the
+// uncommitted working tree of a repository whose contents are read
+// from r (typically the commit that the working tree is based on,
+// followed by the diff of the working tree against it and the
+// untracked files). Working trees with the same contents have the
+// same commit ID, so data imported for a working tree is reused until
+// it changes.
+//
+// Working tree commit IDs are as long as git and hg commit IDs, so
+// they can be used wherever commit IDs are, but they aren't real
+// commits (see IsWorkingTreeCommitID).
+func WorkingTreeCommitID(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return WorkingTreeCommitIDPrefix + hex.EncodeToString(h.Sum(nil))[:workingTreeHashLen], nil
+}
+
+// IsWorkingTreeCommitID returns whether commitID is a synthetic commit
+// ID for an uncommitted working tree (see WorkingTreeCommitID).
+func IsWorkingTreeCommitID(commitID string) bool {
+	return strings.HasPrefix(commitID, WorkingTreeCommitIDPrefix)
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWorkingTreeCommitID(t *testing.T) {
+	a, err := WorkingTreeCommitID(strings.NewReader("base\ndiff a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 40 {
+		t.Errorf("got commit ID %q (len %d), want len 40", a, len(a))
+	}
+	if !IsWorkingTreeCommitID(a) {
+		t.Errorf("got IsWorkingTreeCommitID(%q) false, want true", a)
+	}
+
+	if a2, _ := WorkingTreeCommitID(strings.NewReader("base\ndiff a")); a2 != a {
+		t.Errorf("same contents: got commit IDs %q and %q, want them to be equal", a, a2)
+	}
+	if b, _ := WorkingTreeCommitID(strings.NewReader("base\ndiff b")); b == a {
+		t.Errorf("different contents: got the same commit ID %q", a)
+	}
+
+	if commitID := strings.Repeat("a", 40); IsWorkingTreeCommitID(commitID) {
+		t.Errorf("got IsWorkingTreeCommitID(%q) true, want false", commitID)
+	}
+}
@@ -60,9 +60,22 @@ func chooseTool(op, unitType string, tcs []*Info) (*srclib.ToolRef, error) {
 	}
 
 	if n := len(satisfying); n == 0 {
-		return nil, fmt.Errorf("no tool satisfies op %q for source unit type %q", op, unitType)
+		return nil, &errNoTool{op: op, unitType: unitType}
 	} else if n > 1 {
 		return nil, fmt.Errorf("%d tools satisfy op %q for source unit type %q (refusing to choose between multiple possibilities)", n, op, unitType)
 	}
 	return satisfying[0], nil
 }
+
+type errNoTool struct{ op, unitType string }
+
+func (e *errNoTool) Error() string {
+	return fmt.Sprintf("no tool satisfies op %q for source unit type %q", e.op, e.unitType)
+}
+
+// IsNoTool returns a boolean indicating whether err reports that no
+// tool performs an op on a source unit type (see ChooseTool).
+func IsNoTool(err error) bool {
+	_, ok := err.(*errNoTool)
+	return ok
+}
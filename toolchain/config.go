@@ -1,5 +1,10 @@
 package toolchain
 
+import (
+	"path"
+	"strings"
+)
+
 // ConfigFilename is the filename of the toolchain configuration file. The
 // presence of this file in a directory signifies that a srclib toolchain is
 // defined in that directory.
@@ -7,6 +12,24 @@ const ConfigFilename = "Srclibtoolchain"
 
 // Config represents a Srclibtoolchain file, which defines a srclib toolchain.
 type Config struct {
+	// Language is the programming language (such as "go" or "python")
+	// that this toolchain analyzes. It is optional; see Language.
+	Language string `json:",omitempty"`
+
 	// Tools is the list of this toolchain's tools and their definitions.
 	Tools []*ToolInfo
 }
+
+// Language returns the programming language that the toolchain at
+// path analyzes. If the toolchain is installed and its Srclibtoolchain
+// file specifies a Language, that is returned. Otherwise the language
+// is derived from the last path component with any "srclib-" prefix
+// removed (e.g., "go" for "sourcegraph.com/sourcegraph/srclib-go").
+func Language(toolchainPath string) string {
+	if tc, err := Lookup(toolchainPath); err == nil {
+		if c, err := tc.ReadConfig(); err == nil && c.Language != "" {
+			return c.Language
+		}
+	}
+	return strings.TrimPrefix(path.Base(toolchainPath), "srclib-")
+}
@@ -0,0 +1,129 @@
+package toolchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// FormatOp is the operation performed by tools that format defs and
+// other objects (see FormatDef).
+const FormatOp = "fmt"
+
+// Def formats, for FormatDef.
+const (
+	// DeclFormat is a def's declaration as a one-line signature (e.g.,
+	// "func (*Client) Get(url string) (*Response, error)" for a Go
+	// method).
+	DeclFormat = "decl"
+
+	// FullFormat is a longer description of a def, including its
+	// declaration.
+	FullFormat = "full"
+)
+
+// FormatDef renders def in format (usually DeclFormat) as a single
+// line, so that all UIs render defs consistently.
+//
+// If a toolchain that is linked into the program registered a
+// DefFormatter for def.UnitType (with graph.RegisterMakeDefFormatter),
+// it formats the def. Otherwise, the FormatOp tool of the toolchain in
+// the SRCLIBPATH that handles def.UnitType is run (as a program) with
+// the def's JSON. If there is no such tool, a generic signature is
+// made from the def's Kind and TreePath (or Name).
+func FormatDef(def *graph.Def, format string) (string, error) {
+	if mk, ok := graph.MakeDefFormatters[def.UnitType]; ok {
+		if f := mk(def); f != nil {
+			sig := f.DefKeyword() + " " + f.Name(graph.ScopeQualified) + f.NameAndTypeSeparator() + f.Type(graph.ScopeQualified)
+			if format == FullFormat {
+				sig += fmt.Sprintf(" (%s %s)", f.Language(), f.Kind())
+			}
+			return oneLine(sig), nil
+		}
+	}
+
+	t, err := formatTool(def.UnitType)
+	if IsNoTool(err) || t == noneToolchain {
+		return genericSignature(def), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	defJSON, err := json.Marshal(def)
+	if err != nil {
+		return "", err
+	}
+	// Only call as a program for now.
+	tool, err := OpenTool(t.Toolchain, t.Subcmd, AsProgram)
+	if err != nil {
+		return "", err
+	}
+	cmd, err := tool.Command()
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Args = append(cmd.Args, "--unit-type", def.UnitType, "--object-type", "def", "--format", format, "--object", string(defJSON))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("formatting def %s with %s %s: %s", def.Path, t.Toolchain, t.Subcmd, err)
+	}
+	return oneLine(out.String()), nil
+}
+
+var (
+	formatToolsMu sync.Mutex
+	formatTools   = map[string]formatToolResult{} // unit type -> tool
+)
+
+type formatToolResult struct {
+	tool *srclib.ToolRef
+	err  error
+}
+
+// formatTool returns the FormatOp tool for unitType. The tools are
+// cached, because UIs format many defs, and choosing a tool reads the
+// config of every toolchain in the SRCLIBPATH.
+func formatTool(unitType string) (*srclib.ToolRef, error) {
+	formatToolsMu.Lock()
+	defer formatToolsMu.Unlock()
+	if r, present := formatTools[unitType]; present {
+		return r.tool, r.err
+	}
+	t, err := ChooseTool(FormatOp, unitType)
+	formatTools[unitType] = formatToolResult{t, err}
+	return t, err
+}
+
+// genericSignature returns a signature for def made from its Kind and
+// the non-ghost components of its TreePath (or its Name if it has no
+// TreePath), for defs whose toolchain has no formatter.
+func genericSignature(def *graph.Def) string {
+	name := def.Name
+	if def.TreePath != "" {
+		var parts []string
+		for _, p := range strings.Split(def.TreePath, "/") {
+			if p != "" && p != "." && !strings.HasPrefix(p, "-") {
+				parts = append(parts, p)
+			}
+		}
+		if len(parts) > 0 {
+			name = strings.Join(parts, ".")
+		}
+	}
+	if def.Kind == "" {
+		return name
+	}
+	return def.Kind + " " + name
+}
+
+// oneLine collapses the whitespace (including newlines) in s into
+// single spaces.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
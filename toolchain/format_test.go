@@ -0,0 +1,68 @@
+package toolchain
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+type testDefFormatter struct{ def *graph.Def }
+
+func (f testDefFormatter) Name(qual graph.Qualification) string {
+	if qual == graph.ScopeQualified {
+		return "T." + f.def.Name
+	}
+	return f.def.Name
+}
+func (f testDefFormatter) Type(graph.Qualification) string { return "(a int)\n\tint" }
+func (f testDefFormatter) NameAndTypeSeparator() string    { return "" }
+func (f testDefFormatter) Language() string                { return "Test" }
+func (f testDefFormatter) DefKeyword() string              { return "func" }
+func (f testDefFormatter) Kind() string                    { return "method" }
+
+func init() {
+	graph.RegisterMakeDefFormatter("FormatTestUnit", func(def *graph.Def) graph.DefFormatter {
+		return testDefFormatter{def}
+	})
+}
+
+func TestFormatDef_registered(t *testing.T) {
+	def := &graph.Def{DefKey: graph.DefKey{UnitType: "FormatTestUnit", Path: "T/M"}, Name: "M"}
+	tests := map[string]string{
+		DeclFormat: "func T.M(a int) int",
+		FullFormat: "func T.M(a int) int (Test method)",
+	}
+	for format, want := range tests {
+		sig, err := FormatDef(def, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig != want {
+			t.Errorf("%s: got %q, want %q", format, sig, want)
+		}
+	}
+}
+
+func TestGenericSignature(t *testing.T) {
+	tests := []struct {
+		def  *graph.Def
+		want string
+	}{
+		{&graph.Def{Name: "F"}, "F"},
+		{&graph.Def{Name: "F", Kind: "func"}, "func F"},
+		{&graph.Def{Name: "M", Kind: "method", TreePath: "pkg/T/M"}, "method pkg.T.M"},
+		{&graph.Def{Name: "x", Kind: "var", TreePath: "./F/-scope/x"}, "var F.x"},
+	}
+	for _, test := range tests {
+		if got := genericSignature(test.def); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.def, got, test.want)
+		}
+	}
+}
+
+func TestChooseTool_noTool(t *testing.T) {
+	_, err := chooseTool(FormatOp, "NoSuchUnitType", nil)
+	if !IsNoTool(err) {
+		t.Errorf("got err %v, want IsNoTool", err)
+	}
+}
@@ -0,0 +1,498 @@
+package toolchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ScaffoldOptions configures the toolchain skeleton created by
+// Scaffold. Zero fields are given defaults derived from the toolchain
+// dir's name.
+type ScaffoldOptions struct {
+	// Language is the programming language that the toolchain
+	// analyzes (e.g., "haskell"). It defaults to the dir's name with
+	// any "srclib-" prefix removed.
+	Language string
+
+	// UnitType is the type of the source units that the toolchain
+	// scans for (e.g., "HaskellPackage"). It defaults to the
+	// capitalized Language plus "Package".
+	UnitType string
+
+	// Extensions are the extensions (including the ".") of the source
+	// files that the scanner puts in source units. They default to
+	// "." plus the Language.
+	Extensions []string
+
+	// Impl is the language that the toolchain's program is written in
+	// (one of ScaffoldImpls). It defaults to "go".
+	Impl string
+
+	// Program is the name of the toolchain's program (in .bin), which
+	// must be the last component of the toolchain's path. It defaults
+	// to the dir's name.
+	Program string
+}
+
+// ScaffoldImpls are the languages that Scaffold can write a
+// toolchain's program in.
+var ScaffoldImpls = []string{"go", "python"}
+
+// Scaffold creates the skeleton of a new toolchain in dir: its
+// Srclibtoolchain file, a Dockerfile, a program with stub scan, graph,
+// and depresolve tools, and a fixture for 'src toolchain test'. It
+// returns the files it created (relative to dir). It fails without
+// writing anything if any of the files already exist.
+func Scaffold(dir string, opt ScaffoldOptions) ([]string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Program == "" {
+		opt.Program = filepath.Base(absDir)
+	}
+	if opt.Language == "" {
+		opt.Language = strings.TrimPrefix(opt.Program, "srclib-")
+	}
+	if opt.UnitType == "" {
+		opt.UnitType = strings.Title(opt.Language) + "Package"
+	}
+	if len(opt.Extensions) == 0 {
+		opt.Extensions = []string{"." + opt.Language}
+	}
+	if opt.Impl == "" {
+		opt.Impl = "go"
+	}
+	implFiles, ok := scaffoldImplFiles[opt.Impl]
+	if !ok {
+		return nil, fmt.Errorf("can't scaffold a toolchain written in %q (must be one of: %s)", opt.Impl, strings.Join(ScaffoldImpls, ", "))
+	}
+
+	files := map[string]string{}
+	for name, tmpl := range scaffoldFiles {
+		files[name] = tmpl
+	}
+	for name, tmpl := range implFiles {
+		files[name] = tmpl
+	}
+
+	// Render all of the files before writing any, so that a failure
+	// doesn't leave a partial skeleton.
+	rendered := map[string][]byte{}
+	var names []string
+	for name, text := range files {
+		name = strings.Replace(name, "PROGRAM", opt.Program, -1)
+		name = strings.Replace(name, "EXT", opt.Extensions[0], -1)
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return nil, fmt.Errorf("file %s already exists", filepath.Join(dir, name))
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		data, err := renderScaffoldFile(name, text, opt)
+		if err != nil {
+			return nil, err
+		}
+		rendered[name] = data
+		names = append(names, name)
+	}
+	config, err := scaffoldConfig(opt)
+	if err != nil {
+		return nil, err
+	}
+	rendered[ConfigFilename] = config
+	names = append(names, ConfigFilename)
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(name, ".bin/") {
+			mode = 0755
+		}
+		if err := ioutil.WriteFile(path, rendered[name], mode); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// scaffoldConfig returns the Srclibtoolchain file for a toolchain
+// whose scan, graph, and depresolve tools are its program's
+// subcommands of the same name.
+func scaffoldConfig(opt ScaffoldOptions) ([]byte, error) {
+	c := Config{Language: opt.Language}
+	for _, op := range []string{"scan", "graph", "depresolve"} {
+		c.Tools = append(c.Tools, &ToolInfo{Subcmd: op, Op: op, SourceUnitTypes: []string{opt.UnitType}})
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func renderScaffoldFile(name, text string, opt ScaffoldOptions) ([]byte, error) {
+	t, err := template.New(name).Funcs(template.FuncMap{"quoteList": quoteList}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, opt); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// quoteList returns the quoted strings in ss, separated by commas.
+func quoteList(ss []string) string {
+	q := make([]string, len(ss))
+	for i, s := range ss {
+		q[i] = strconv.Quote(s)
+	}
+	return strings.Join(q, ", ")
+}
+
+// scaffoldFiles are the templates of the files that Scaffold creates
+// for every toolchain, keyed on their path (in which PROGRAM and EXT
+// are replaced by the program name and first extension).
+var scaffoldFiles = map[string]string{
+	"README.md": `# {{.Program}}
+
+{{.Program}} is a [srclib](https://srclib.org) toolchain for {{.Language}}.
+It scans for {{.UnitType}} source units (directories containing
+{{range $i, $e := .Extensions}}{{if $i}}, {{end}}*{{$e}}{{end}} files), and graphs and resolves
+their dependencies.
+
+The scan, graph, and depresolve tools are stubs: the scanner makes a
+source unit of each directory, the grapher emits a def for each file,
+and the dependency resolver finds no dependencies. Replace them with
+real analysis of {{.Language}} code.
+
+## Development
+
+Make the toolchain available in the SRCLIBPATH (as, e.g.,
+github.com/you/{{.Program}}) with:
+
+    src toolchain add github.com/you/{{.Program}}
+
+Then run its tests, which check its output on the fixtures in
+testdata/conformance/case against the golden output in
+testdata/conformance/expected:
+
+    make test
+
+After changing the toolchain's output, update the golden output (and
+check it for errors before committing it) with:
+
+    make update-golden
+`,
+
+	"testdata/conformance/case/basic/basicEXT": `This is a sample {{.Language}} file. Replace it with a {{.Language}} program that
+exercises the toolchain's analysis.
+`,
+}
+
+// scaffoldImplFiles are the templates of the files that make up the
+// program of a toolchain written in each of ScaffoldImpls.
+var scaffoldImplFiles = map[string]map[string]string{
+	"go": {
+		"Makefile": `TOOLCHAIN ?= $(shell src toolchain list | awk '$$1 ~ /\/{{.Program}}$$/ { print $$1 }')
+
+.bin/{{.Program}}: *.go
+	go build -o .bin/{{.Program}} .
+
+.PHONY: test update-golden
+test: .bin/{{.Program}}
+	src toolchain test -m program $(TOOLCHAIN)
+
+update-golden: .bin/{{.Program}}
+	src toolchain test -m program --update $(TOOLCHAIN)
+`,
+
+		"Dockerfile": `FROM golang:1.4
+
+ADD . /srclib/{{.Program}}/
+WORKDIR /srclib/{{.Program}}
+RUN go build -o .bin/{{.Program}} .
+
+WORKDIR /src
+ENTRYPOINT ["/srclib/{{.Program}}/.bin/{{.Program}}"]
+`,
+
+		"main.go": `// Command {{.Program}} is a srclib toolchain for {{.Language}}.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const unitType = {{printf "%q" .UnitType}}
+
+var extensions = []string{{"{"}}{{quoteList .Extensions}}{{"}"}}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: {{.Program}} scan|graph|depresolve")
+		os.Exit(2)
+	}
+
+	// Tools are run in the directory tree to analyze, and they receive
+	// their input (the tree's config or a source unit) as JSON on
+	// stdin.
+	var out interface{}
+	var err error
+	switch os.Args[1] {
+	case "scan":
+		out, err = scan(os.Stdin)
+	case "graph":
+		out, err = graph(os.Stdin)
+	case "depresolve":
+		out, err = depresolve(os.Stdin)
+	default:
+		err = fmt.Errorf("unknown subcommand %q", os.Args[1])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "{{.Program}} %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// SourceUnit is a srclib source unit.
+type SourceUnit struct {
+	Name  string
+	Type  string
+	Dir   string
+	Files []string
+	Ops   map[string]interface{}
+}
+
+// Def is a srclib def.
+type Def struct {
+	Path     string
+	TreePath string
+	Name     string
+	Kind     string
+	File     string
+	DefStart uint32
+	DefEnd   uint32
+	Exported bool
+}
+
+// Output is the output of the grapher.
+type Output struct {
+	Defs []*Def
+	Refs []interface{}
+	Docs []interface{}
+}
+
+// scan makes a source unit of each directory that contains source
+// files.
+//
+// TODO: Scan for {{.Language}} packages (or whatever the unit of
+// compilation is in {{.Language}}).
+func scan(in io.Reader) ([]*SourceUnit, error) {
+	// The input is the tree's config (from its Srcfile).
+	if _, err := ioutil.ReadAll(in); err != nil {
+		return nil, err
+	}
+
+	filesByDir := map[string][]string{}
+	err := filepath.Walk(".", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != "." && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		for _, ext := range extensions {
+			if filepath.Ext(path) == ext {
+				dir := filepath.Dir(path)
+				filesByDir[dir] = append(filesByDir[dir], filepath.ToSlash(path))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	units := []*SourceUnit{}
+	for dir, files := range filesByDir {
+		sort.Strings(files)
+		units = append(units, &SourceUnit{
+			Name:  filepath.ToSlash(dir),
+			Type:  unitType,
+			Dir:   filepath.ToSlash(dir),
+			Files: files,
+			Ops:   map[string]interface{}{"graph": nil, "depresolve": nil},
+		})
+	}
+	return units, nil
+}
+
+// graph emits a def for each file in the source unit.
+//
+// TODO: Emit the defs, refs, and docs in the source unit's files.
+func graph(in io.Reader) (*Output, error) {
+	var u SourceUnit
+	if err := json.NewDecoder(in).Decode(&u); err != nil {
+		return nil, err
+	}
+
+	o := &Output{Defs: []*Def{}, Refs: []interface{}{}, Docs: []interface{}{}}
+	for _, file := range u.Files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		o.Defs = append(o.Defs, &Def{
+			Path:     file,
+			TreePath: file,
+			Name:     filepath.Base(file),
+			Kind:     "file",
+			File:     file,
+			DefEnd:   uint32(fi.Size()),
+			Exported: true,
+		})
+	}
+	return o, nil
+}
+
+// depresolve resolves the source unit's dependencies.
+//
+// TODO: Resolve the source unit's dependencies to the repositories
+// and source units that define them.
+func depresolve(in io.Reader) ([]interface{}, error) {
+	var u SourceUnit
+	if err := json.NewDecoder(in).Decode(&u); err != nil {
+		return nil, err
+	}
+	return []interface{}{}, nil
+}
+`,
+	},
+
+	"python": {
+		"Makefile": `TOOLCHAIN ?= $(shell src toolchain list | awk '$$1 ~ /\/{{.Program}}$$/ { print $$1 }')
+
+.PHONY: test update-golden
+test:
+	src toolchain test -m program $(TOOLCHAIN)
+
+update-golden:
+	src toolchain test -m program --update $(TOOLCHAIN)
+`,
+
+		"Dockerfile": `FROM python:2.7
+
+ADD . /srclib/{{.Program}}/
+
+WORKDIR /src
+ENTRYPOINT ["/srclib/{{.Program}}/.bin/{{.Program}}"]
+`,
+
+		".bin/PROGRAM": `#!/bin/sh
+exec python "$(dirname "$0")/../toolchain.py" "$@"
+`,
+
+		"toolchain.py": `"""{{.Program}} is a srclib toolchain for {{.Language}}.
+
+Tools are run in the directory tree to analyze, and they receive their
+input (the tree's config or a source unit) as JSON on stdin.
+"""
+
+import json
+import os
+import sys
+
+UNIT_TYPE = {{printf "%q" .UnitType}}
+EXTENSIONS = ({{quoteList .Extensions}},)
+
+
+def scan():
+    """Makes a source unit of each directory that contains source files.
+
+    TODO: Scan for {{.Language}} packages (or whatever the unit of
+    compilation is in {{.Language}}).
+    """
+    sys.stdin.read()  # the tree's config (from its Srcfile)
+    files_by_dir = {}
+    for root, dirs, files in os.walk("."):
+        dirs[:] = sorted(d for d in dirs if not d.startswith("."))
+        for f in sorted(files):
+            if os.path.splitext(f)[1] in EXTENSIONS:
+                d = os.path.normpath(root)
+                files_by_dir.setdefault(d, []).append(os.path.normpath(os.path.join(root, f)))
+    return [{
+        "Name": d,
+        "Type": UNIT_TYPE,
+        "Dir": d,
+        "Files": files,
+        "Ops": {"graph": None, "depresolve": None},
+    } for d, files in sorted(files_by_dir.items())]
+
+
+def graph():
+    """Emits a def for each file in the source unit.
+
+    TODO: Emit the defs, refs, and docs in the source unit's files.
+    """
+    unit = json.load(sys.stdin)
+    defs = [{
+        "Path": f,
+        "TreePath": f,
+        "Name": os.path.basename(f),
+        "Kind": "file",
+        "File": f,
+        "DefStart": 0,
+        "DefEnd": os.path.getsize(f),
+        "Exported": True,
+    } for f in unit["Files"]]
+    return {"Defs": defs, "Refs": [], "Docs": []}
+
+
+def depresolve():
+    """Resolves the source unit's dependencies.
+
+    TODO: Resolve the source unit's dependencies to the repositories
+    and source units that define them.
+    """
+    json.load(sys.stdin)
+    return []
+
+
+TOOLS = {"scan": scan, "graph": graph, "depresolve": depresolve}
+
+if __name__ == "__main__":
+    if len(sys.argv) < 2 or sys.argv[1] not in TOOLS:
+        sys.stderr.write("usage: {{.Program}} scan|graph|depresolve\n")
+        sys.exit(2)
+    json.dump(TOOLS[sys.argv[1]](), sys.stdout)
+`,
+	},
+}
@@ -0,0 +1,83 @@
+package toolchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib"
+)
+
+func TestScaffold(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "srclib-toolchain-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	defer func(orig string) {
+		srclib.Path = orig
+	}(srclib.Path)
+	srclib.Path = tmpdir
+
+	dir := filepath.Join(tmpdir, "example.com/srclib-foo")
+	files, err := Scaffold(dir, ScaffoldOptions{Impl: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{
+		".bin/srclib-foo",
+		"Dockerfile",
+		"Makefile",
+		"README.md",
+		"Srclibtoolchain",
+		"testdata/conformance/case/basic/basic.foo",
+		"toolchain.py",
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("got files %v, want %v", files, wantFiles)
+	}
+
+	tc, err := Lookup("example.com/srclib-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Program != ".bin/srclib-foo" || tc.Dockerfile != "Dockerfile" {
+		t.Errorf("got toolchain program %q and Dockerfile %q, want both", tc.Program, tc.Dockerfile)
+	}
+	c, err := tc.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Language != "foo" {
+		t.Errorf("got language %q, want %q", c.Language, "foo")
+	}
+	if len(c.Tools) != 3 {
+		t.Fatalf("got %d tools, want 3", len(c.Tools))
+	}
+	for _, tool := range c.Tools {
+		if want := []string{"FooPackage"}; !reflect.DeepEqual(tool.SourceUnitTypes, want) {
+			t.Errorf("%s: got source unit types %v, want %v", tool.Op, tool.SourceUnitTypes, want)
+		}
+	}
+
+	if tool, err := LookupToolInfo("example.com/srclib-foo", "graph"); err != nil {
+		t.Error(err)
+	} else if tool.Op != "graph" {
+		t.Errorf("got tool op %q, want %q", tool.Op, "graph")
+	}
+	if _, err := LookupToolInfo("example.com/srclib-foo", "nonexistent"); err == nil {
+		t.Error("got no error looking up a nonexistent tool")
+	}
+
+	// Existing files are not overwritten.
+	if _, err := Scaffold(dir, ScaffoldOptions{Impl: "python"}); err == nil {
+		t.Error("got no error scaffolding over an existing toolchain")
+	}
+
+	if _, err := Scaffold(filepath.Join(tmpdir, "bar"), ScaffoldOptions{Impl: "cobol"}); err == nil {
+		t.Error("got no error for an unknown impl")
+	}
+}
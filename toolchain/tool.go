@@ -34,6 +34,34 @@ type ToolInfo struct {
 	// TODO(sqs): determine how repository- or directory-level tools will be
 	// defined.
 	SourceUnitTypes []string `json:",omitempty"`
+
+	// DefsManifest is whether this tool (a grapher) accepts a
+	// "--defs-manifest=FILE" argument, which names a file that lists
+	// the exported defs of the source units that the source unit
+	// being graphed depends on (see grapher.DefsManifest). Graphers
+	// can use it to resolve refs to defs in other source units
+	// precisely, instead of guessing the defs' paths.
+	DefsManifest bool `json:",omitempty"`
+}
+
+// LookupToolInfo returns the definition of the tool named subcmd in the
+// toolchain at toolchainPath, from the toolchain's Srclibtoolchain
+// file.
+func LookupToolInfo(toolchainPath, subcmd string) (*ToolInfo, error) {
+	tc, err := Lookup(toolchainPath)
+	if err != nil {
+		return nil, err
+	}
+	c, err := tc.ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range c.Tools {
+		if tool.Subcmd == subcmd {
+			return tool, nil
+		}
+	}
+	return nil, fmt.Errorf("no tool %q in toolchain %s", subcmd, toolchainPath)
 }
 
 // ListTools lists all tools in all available toolchains (returned by List). If
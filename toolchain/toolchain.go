@@ -62,8 +62,21 @@ const (
 
 	// AsDockerContainer enables the use of Docker container toolchains.
 	AsDockerContainer
+
+	// Offline forbids toolchains from accessing the network. Tools are
+	// run with OfflineEnv set, and Docker containers are run without
+	// networking.
+	Offline
 )
 
+// OfflineEnv is the environment variable that is set (to "1") for
+// tools that are run in Offline mode. Tools that would access the
+// network (e.g., dependency resolvers that query package registries)
+// must use local caches instead, or fail if the information they need
+// isn't cached. Program toolchains can't be prevented from accessing
+// the network, so they must heed OfflineEnv.
+const OfflineEnv = "SRCLIB_OFFLINE"
+
 func (m Mode) String() string {
 	var s []string
 	if m&AsProgram > 0 {
@@ -72,6 +85,9 @@ func (m Mode) String() string {
 	if m&AsDockerContainer > 0 {
 		s = append(s, "run as docker container")
 	}
+	if m&Offline > 0 {
+		s = append(s, "offline")
+	}
 	return strings.Join(s, " | ")
 }
 
@@ -83,7 +99,7 @@ func Open(path string, mode Mode) (Toolchain, error) {
 	}
 
 	if mode&AsProgram > 0 && tc.Program != "" {
-		return &programToolchain{filepath.Join(tc.Dir, tc.Program)}, nil
+		return &programToolchain{filepath.Join(tc.Dir, tc.Program), mode&Offline > 0}, nil
 	}
 	if mode&AsDockerContainer > 0 && tc.Dockerfile != "" {
 		// use current dir as Docker volume mount when running container
@@ -91,7 +107,7 @@ func Open(path string, mode Mode) (Toolchain, error) {
 		if err != nil {
 			return nil, err
 		}
-		return newDockerToolchain(tc.Path, tc.Dir, tc.Dockerfile, wd)
+		return newDockerToolchain(tc.Path, tc.Dir, tc.Dockerfile, wd, mode&Offline > 0)
 	}
 
 	if tc.Program != "" || tc.Dockerfile != "" {
@@ -126,6 +142,9 @@ type Toolchain interface {
 type programToolchain struct {
 	// program (executable) path
 	program string
+
+	// offline is whether the program is run in Offline mode.
+	offline bool
 }
 
 // IsBuilt always returns true for programs.
@@ -137,6 +156,9 @@ func (t *programToolchain) Build() error { return nil }
 // Command returns an *exec.Cmd that executes this program.
 func (t *programToolchain) Command() (*exec.Cmd, error) {
 	cmd := exec.Command(t.program)
+	if t.offline {
+		cmd.Env = append(os.Environ(), OfflineEnv+"=1")
+	}
 	return cmd, nil
 }
 
@@ -154,10 +176,13 @@ type dockerToolchain struct {
 	// hostVolumeDir is the host directory to mount at /src in the container.
 	hostVolumeDir string
 
+	// offline is whether the container is run in Offline mode.
+	offline bool
+
 	docker *docker.Client
 }
 
-func newDockerToolchain(path, dir, dockerfile, hostVolumeDir string) (*dockerToolchain, error) {
+func newDockerToolchain(path, dir, dockerfile, hostVolumeDir string, offline bool) (*dockerToolchain, error) {
 	dc, err := newDockerClient()
 	if err != nil {
 		return nil, err
@@ -169,6 +194,7 @@ func newDockerToolchain(path, dir, dockerfile, hostVolumeDir string) (*dockerToo
 		imageName:     strings.Replace(path, "/", "-", -1),
 		docker:        dc,
 		hostVolumeDir: hostVolumeDir,
+		offline:       offline,
 	}, nil
 }
 
@@ -209,6 +235,9 @@ func (t *dockerToolchain) Command() (*exec.Cmd, error) {
 	if built, err := t.IsBuilt(); err != nil {
 		return nil, err
 	} else if !built {
+		if t.offline {
+			return nil, fmt.Errorf("Docker image %s is not built, and it can't be built offline (build it with 'src toolchain build' while online)", t.imageName)
+		}
 		if err := t.Build(); err != nil {
 			return nil, err
 		}
@@ -217,6 +246,10 @@ func (t *dockerToolchain) Command() (*exec.Cmd, error) {
 	// TODO(sqs): once all the toolchains have a "USER srclib" directive, add:
 	//   "--user", "srclib"
 	// to the run options below.
-	cmd := exec.Command("docker", "run", "--memory=4g", "-i", "--volume="+t.hostVolumeDir+":/src:ro", t.imageName)
+	cmd := exec.Command("docker", "run", "--memory=4g", "-i", "--volume="+t.hostVolumeDir+":/src:ro")
+	if t.offline {
+		cmd.Args = append(cmd.Args, "--net=none", "--env="+OfflineEnv+"=1")
+	}
+	cmd.Args = append(cmd.Args, t.imageName)
 	return cmd, nil
 }
@@ -50,6 +50,22 @@ type SourceUnit struct {
 	// should be left blank, to be filled in by the `src` tool.
 	CommitID string `json:",omitempty"`
 
+	// Toolchain is the path of the toolchain whose grapher produced
+	// this source unit's graph data, if any. Like Repo, it need not be
+	// set by the scanner; it is filled in by the `src` tool on import.
+	Toolchain string `json:",omitempty"`
+
+	// Language is the programming language of this source unit (such
+	// as "go" or "python"), if known. It is filled in on import from
+	// the toolchain's configuration if the scanner does not set it.
+	Language string `json:",omitempty"`
+
+	// Upstream is the key of the source unit that this source unit is
+	// a vendored copy of (e.g., a unit in a vendor/ or node_modules/
+	// directory), if any. It is filled in on import from the tree's
+	// dependency resolution data.
+	Upstream *Key `json:",omitempty"`
+
 	// Globs is a list of patterns that match files that make up this source
 	// unit. It is used to detect when the source unit definition is out of date
 	// (e.g., when a file matches the glob but is not in the Files list).
@@ -77,6 +93,13 @@ type SourceUnit struct {
 	// is often slow (requiring network access, etc.).
 	Dependencies []interface{} `json:",omitempty"`
 
+	// GraphDeps lists the other source units in the same tree whose
+	// graph output the grapher uses when graphing this source unit
+	// (e.g., to resolve refs to the defs of an internal library). They
+	// are graphed before this source unit, and the paths of their
+	// graph output files are passed to the grapher as arguments.
+	GraphDeps []ID2 `json:",omitempty"`
+
 	// Info is an optional field that contains additional information used to
 	// display the source unit
 	Info *Info `json:",omitempty"`